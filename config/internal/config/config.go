@@ -66,9 +66,11 @@ func newVM(
 	flags *pflag.FlagSet,
 ) *jsonnet.VM {
 	vm := jsonnet.MakeVM()
-	return loadFlagVariables(
-		// flags override environment variables
-		loadEnvironmentVariables(vm),
+	return loadExplicitVariables(
+		loadFlagVariables(
+			// flags override environment variables
+			loadEnvironmentVariables(vm),
+			flags),
 		flags)
 }
 
@@ -17,6 +17,7 @@ package config
 import (
 	"context"
 	"errors"
+	"slices"
 
 	"github.com/knadh/koanf/v2"
 	sf "github.com/wissance/stringFormatter"
@@ -29,6 +30,7 @@ const (
 
 var (
 	invalidConfigValueErr = errors.New("invalid config value type")
+	invalidConfigErr      = errors.New("invalid config value")
 	IllegalConfigStateErr = errors.New("illegal config state")
 	unavailableConfigErr  = errors.New("config not found")
 )
@@ -40,8 +42,44 @@ var ctxVars = map[CtxKey]*ctxVar{
 	VerbosityKey:      {"verbosity", TYPE_STRING, false},
 	ExecEnvKey:        {"env.id", TYPE_STRING, false},
 	InstanceIDKey:     {"env.instance.id", TYPE_STRING, true},
+	ProjectIDKey:      {"gcp.project.id", TYPE_STRING, false},
+	ProjectNumKey:     {"gcp.project.number", TYPE_STRING, false},
+	GcpRegionKey:      {"gcp.region", TYPE_STRING, false},
+	FilterKey:         {"filter.bpf", TYPE_STRING, false},
 	L3ProtosFilterKey: {"protos.l3", TYPE_LIST_STRING, false},
 	L4ProtosFilterKey: {"protos.l4", TYPE_LIST_STRING, false},
+	ExtensionKey:      {"extension", TYPE_STRING, false},
+	IfaceKey:          {"iface", TYPE_STRING, false},
+	DirectoryKey:      {"directory", TYPE_STRING, false},
+	GcsExportKey:      {"gcs.export", TYPE_BOOLEAN, false},
+	GcsMountPointKey:  {"gcs.mount", TYPE_STRING, false},
+	GcsDirKey:         {"gcs.dir", TYPE_STRING, false},
+	GcsTempDirKey:     {"gcs.temp_dir", TYPE_STRING, false},
+	GzipKey:           {"feature.gzip", TYPE_BOOLEAN, false},
+	TcpdumpKey:        {"feature.tcpdump", TYPE_BOOLEAN, false},
+	JsondumpKey:       {"feature.json.dump", TYPE_BOOLEAN, false},
+	JsonlogKey:        {"feature.json.log", TYPE_BOOLEAN, false},
+	OrderedKey:        {"feature.ordered", TYPE_BOOLEAN, false},
+	ConntrackKey:      {"feature.conntrack", TYPE_BOOLEAN, false},
+	CronKey:           {"feature.cron.enabled", TYPE_BOOLEAN, false},
+	CronExpressionKey: {"feature.cron.expression", TYPE_STRING, false},
+	TimeoutKey:        {"timeout", TYPE_INTEGER, false},
+	HealthcheckKey:    {"healthcheck.port", TYPE_INTEGER, false},
+	HcCaptureKey:      {"healthcheck.capture", TYPE_STRING, false},
+	HcExporterKey:     {"healthcheck.exporter", TYPE_STRING, false},
+	HcConfigKey:       {"healthcheck.config", TYPE_STRING, false},
+	HcExportKey:       {"healthcheck.export", TYPE_BOOLEAN, false},
+	HcBacklogKey:      {"healthcheck.backlog", TYPE_INTEGER, false},
+	HcDiskKey:         {"healthcheck.disk", TYPE_INTEGER, false},
+	SupervisorPortKey: {"supervisor.port", TYPE_INTEGER, false},
+	LogFieldsKey:      {"logging.fields", TYPE_LIST_STRING, false},
+	AuditFieldsKey:    {"logging.audit.fields", TYPE_LIST_STRING, false},
+	CompressionKey:    {"compression", TYPE_LIST_STRING, false},
+	SnaplenKey:        {"snaplen", TYPE_INTEGER, false},
+	SampleRateKey:     {"feature.session.sample_rate", TYPE_FLOAT64, false},
+	SampleSeedKey:     {"feature.session.sample_seed", TYPE_STRING, false},
+	SampleModeKey:     {"feature.session.sample_mode", TYPE_STRING, false},
+	ProfileKey:        {"profile", TYPE_STRING, false},
 }
 
 func newConfigPathError(
@@ -85,51 +123,98 @@ func newCtxKeyPath(
 	return sf.Format(ctxKeyPathTemplate, ctxKeyPrefix, v.path)
 }
 
-func setCtxVar(
-	ctx context.Context,
+// resolveCtxVar returns the value of the context variable,
+// or its default value when it is optional and not available in the config.
+func resolveCtxVar(
 	ktx *koanf.Koanf,
 	k *CtxKey,
 	v *ctxVar,
-) (context.Context, error) {
+) (any, *ReportEntry) {
 	path := newCtxKeyPath(v)
-	var value any = nil
+	entry := newReportEntry(k, v)
 
 	isAvailable := ktx.Exists(path)
 
 	if v.required && !isAvailable {
-		return ctx, newUnavailableConfigError(&path)
+		entry.fail(newUnavailableConfigError(&path))
+		return nil, entry
 	} else if !isAvailable {
 		if envVar, ok := envVars[*k]; ok {
 			ktx.Set(path, envVar.defaultValue)
+			entry.Outcome = OUTCOME_DEFAULTED
 		} else {
-			return ctx, newIllegalConfigStateError(&path)
+			entry.fail(newIllegalConfigStateError(&path))
+			return nil, entry
 		}
 	}
 
 	switch v.typ {
 	case TYPE_STRING:
-		value = ktx.String(path)
+		return ktx.String(path), entry
 	case TYPE_BOOLEAN:
-		value = ktx.Bool(path)
+		return ktx.Bool(path), entry
+	case TYPE_INTEGER:
+		return ktx.Int(path), entry
+	case TYPE_FLOAT64:
+		return ktx.Float64(path), entry
 	case TYPE_LIST_STRING:
-		value = ktx.Strings(path)
+		return ktx.Strings(path), entry
 	default:
-		return ctx, newInvalidConfigValueTypeError(&path)
+		entry.fail(newInvalidConfigValueTypeError(&path))
+		return nil, entry
 	}
-
-	return context.WithValue(ctx, k.ToCtxKey(), value), nil
 }
 
+// LoadContext sets a context variable for every config key which could be resolved and is valid;
+// keys which failed to be resolved or validated are not set, and the returned report describes why.
+// Boolean features can be overridden with `PCAP_FEATURE_<NAME>` env vars.
+// Keys of the active profile which were not set explicitly are expanded before being resolved.
 func LoadContext(
 	ctx context.Context,
 	ktx *koanf.Koanf,
-) context.Context {
+) (context.Context, *ConfigReport) {
+	state := &profilesState{parent: ctx, ktx: ktx.Copy()}
+	expanded, profileErr := expandProfile(ktx)
+
+	report := &ConfigReport{}
+	values := make(map[CtxKey]any, len(ctxVars))
 	for k, v := range ctxVars {
-		if _ctx, err := setCtxVar(ctx, ktx, &k, v); err == nil {
-			ctx = _ctx
-		} else {
-			ctx = context.WithValue(ctx, k.ToCtxKey(), err)
+		overridden := overrideFeature(ktx, &k, v)
+		value, entry := resolveCtxVar(ktx, &k, v)
+		if overridden && entry.Outcome != OUTCOME_FAILED {
+			entry.Outcome = OUTCOME_OVERRIDDEN
+		} else if slices.Contains(expanded, k) && entry.Outcome != OUTCOME_FAILED {
+			entry.Outcome = OUTCOME_PROFILED
+		}
+		report.add(entry)
+		if entry.Outcome != OUTCOME_FAILED {
+			values[k] = value
 		}
 	}
-	return ctx
+	// an active profile which cannot be expanded makes the config unusable: it would not be the intended one
+	if entry, ok := report.Get(ProfileKey); ok && profileErr != nil {
+		entry.Required = true
+		entry.fail(profileErr)
+		delete(values, ProfileKey)
+	}
+	// validators may depend on any other config value: they run once all of them are resolved
+	for k, validate := range ctxValidators {
+		entry, ok := report.Get(k)
+		value, resolved := values[k]
+		if !ok || !resolved {
+			continue
+		}
+		required, err := validate(ktx, value)
+		entry.Required = entry.Required || required
+		if err != nil {
+			entry.fail(err)
+			delete(values, k)
+		}
+	}
+	for k, value := range values {
+		ctx = context.WithValue(ctx, k.ToCtxKey(), value)
+	}
+	ctx = context.WithValue(ctx, profilesStateKey{}, state)
+	report.sort()
+	return ctx, report
 }
@@ -16,9 +16,11 @@ package config
 
 import (
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/google/go-jsonnet"
+	"github.com/spf13/pflag"
 	sf "github.com/wissance/stringFormatter"
 )
 
@@ -41,6 +43,9 @@ const (
 	envVarTemplate = "{0}_{1}"
 
 	extVarTemplate = "ext__{0}"
+
+	// lists the config keys set explicitly by env vars or flags: they take precedence over capture profiles
+	explicitExtVar = "ext__PCAP_EXPLICIT"
 )
 
 var envVars = map[CtxKey]*variable{
@@ -64,6 +69,26 @@ var envVars = map[CtxKey]*variable{
 		"unknown",
 		"runtime instance ID (depends on the execution environment)",
 	},
+	ProjectIDKey: {
+		"project_id",
+		"",
+		"ID of the project where the PCAP sidecar is deployed; empty lets modules use 'PROJECT_ID'",
+	},
+	ProjectNumKey: {
+		"project_num",
+		"",
+		"number of the project where the PCAP sidecar is deployed",
+	},
+	GcpRegionKey: {
+		"region",
+		"",
+		"region where the PCAP sidecar is deployed; empty lets modules use 'GCP_REGION'",
+	},
+	FilterKey: {
+		"filter",
+		"DISABLED",
+		"standard tcpdump BPF filter; it is validated at load time, and overrides all other filters",
+	},
 	L3ProtosFilterKey: {
 		"l3_protos",
 		"icmp,icmp6",
@@ -74,6 +99,171 @@ var envVars = map[CtxKey]*variable{
 		"tcp,udp",
 		"list of transport layer protocols that should be captured",
 	},
+	ExtensionKey: {
+		"ext",
+		"pcap",
+		"comma-separated list of extensions used for PCAP files",
+	},
+	IfaceKey: {
+		"iface",
+		"any",
+		"comma-separated list of network interfaces to capture from; supports 'any', names prefixes, and globs",
+	},
+	DirectoryKey: {
+		"tmp",
+		"/pcap-tmp",
+		"local directory where PCAP files are written before being exported",
+	},
+	GcsExportKey: {
+		"gcs_export",
+		"true",
+		"export PCAP files to GCS; when disabled, PCAP files are kept in the local directory",
+	},
+	GcsMountPointKey: {
+		"mnt",
+		"/pcap",
+		"local directory where the GCS bucket is mounted using GCS Fuse",
+	},
+	GcsDirKey: {
+		"gcs_dir",
+		"",
+		"directory within the GCS bucket where PCAP files are exported",
+	},
+	GcsTempDirKey: {
+		"gcs_temp_dir",
+		"",
+		"directory within the GCS bucket where PCAP files are staged before being moved into the GCS directory; empty disables staging",
+	},
+	GzipKey: {
+		"gzip",
+		"true",
+		"compress PCAP files before exporting them",
+	},
+	TcpdumpKey: {
+		"tcpdump",
+		"true",
+		"capture packets into PCAP files using tcpdump",
+	},
+	JsondumpKey: {
+		"jsondump",
+		"false",
+		"write packet translations as JSON",
+	},
+	JsonlogKey: {
+		"jsondump_log",
+		"false",
+		"write packet translations as JSON to standard output",
+	},
+	OrderedKey: {
+		"ordered",
+		"false",
+		"write JSON PCAP output as obtained from gopacket",
+	},
+	ConntrackKey: {
+		"conntrack",
+		"false",
+		"enable connection tracking ('ordered' is also enabled)",
+	},
+	CronKey: {
+		"use_cron",
+		"false",
+		"schedule tcpdump executions using the cron expression",
+	},
+	CronExpressionKey: {
+		"cron_exp",
+		"",
+		"standard cron expression used to schedule tcpdump executions; required when cron is enabled",
+	},
+	TimeoutKey: {
+		"to",
+		"0",
+		"seconds every tcpdump execution captures packets for; 0 captures until the sidecar stops",
+	},
+	SupervisorPortKey: {
+		"supervisor_port",
+		"23456",
+		"TCP port where supervisord serves its XML-RPC interface on the loopback address",
+	},
+	HealthcheckKey: {
+		"hc_port",
+		"12345",
+		"TCP port where the healthcheck server serves startup, liveness, and readiness probes",
+	},
+	HcCaptureKey: {
+		"hc_capture",
+		"",
+		"address of the packet capturing startup probe; i.e.: '127.0.0.1:12344'; empty skips the check",
+	},
+	HcExporterKey: {
+		"hc_exporter",
+		"",
+		"address where the PCAP files exporter serves its status; i.e.: '127.0.0.1:12346'; empty skips the check",
+	},
+	HcConfigKey: {
+		"hc_config",
+		"",
+		"address where the config server serves its readiness; empty skips the check",
+	},
+	HcExportKey: {
+		"hc_export",
+		"false",
+		"whether PCAP files export is required for the sidecar to be ready",
+	},
+	HcBacklogKey: {
+		"hc_backlog",
+		"0",
+		"max PCAP files pending export before the sidecar is unready; 0 disables the check",
+	},
+	HcDiskKey: {
+		"hc_disk_mib",
+		"0",
+		"min free MiB in the local PCAP files directory before the sidecar is unready; 0 disables the check",
+	},
+	LogFieldsKey: {
+		"log_fields",
+		"project,region,service,version,instance,sidecar,module",
+		"comma-separated list of identity fields attached to every log event",
+	},
+	AuditFieldsKey: {
+		"audit_fields",
+		"project,service,instance",
+		"comma-separated list of identity fields attached to GCS audit logs",
+	},
+	CompressionKey: {
+		"compression",
+		"",
+		"comma-separated list of '<iface>=<level>' gzip levels which pin the compression of PCAP files per interface; 0 disables it",
+	},
+	SnaplenKey: {
+		"snaplen",
+		"262144",
+		"bytes of data captured from each packet; 0 uses the default of 262144",
+	},
+	SampleRateKey: {
+		"session_sample_rate",
+		"1",
+		"fraction of sessions, between 0 and 1, whose PCAP files are exported with full fidelity",
+	},
+	SampleSeedKey: {
+		"session_sample_seed",
+		"instance",
+		"source of the sampling decision: 'instance' hashes the instance ID so that it is stable across re-deploys; 'random'",
+	},
+	SampleModeKey: {
+		"session_sample_mode",
+		"drop",
+		"what unsampled sessions export: 'drop' exports nothing; 'headers' exports packet headers only",
+	},
+	ProfileKey: {
+		"profile",
+		"",
+		"name of the active capture profile; keys which are not set explicitly take the value of the profile",
+	},
+	ProfilesKey: {
+		"profiles",
+		"{}",
+		"JSON object mapping capture profile names to the config keys they set; added to the built-in profiles",
+	},
 }
 
 func newEnvVarKey(
@@ -125,3 +315,21 @@ func loadEnvironmentVariables(
 	}
 	return vm
 }
+
+// loadExplicitVariables sets the config keys whose env var was set, or whose flag was changed.
+func loadExplicitVariables(
+	vm *jsonnet.VM,
+	flags *pflag.FlagSet,
+) *jsonnet.VM {
+	explicit := []string{}
+	for k, v := range envVars {
+		if _, ok := os.LookupEnv(newEnvVarName(v)); ok {
+			explicit = append(explicit, string(k))
+		} else if flag := flags.Lookup(newFlagVarName(v)); flag != nil && flag.Changed {
+			explicit = append(explicit, string(k))
+		}
+	}
+	sort.Strings(explicit)
+	vm.ExtVar(explicitExtVar, strings.Join(explicit, ","))
+	return vm
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+	sf "github.com/wissance/stringFormatter"
+)
+
+const (
+	featureKeyPrefix      = "feature/"
+	featureEnvVarTemplate = "{0}_FEATURE_{1}"
+)
+
+// newFeatureEnvVarName returns the name of the env var which overrides the boolean feature `k`;
+// i.e.: `feature/json/dump` is overridden by `PCAP_FEATURE_JSON_DUMP`.
+func newFeatureEnvVarName(
+	k *CtxKey,
+	v *ctxVar,
+) (string, bool) {
+	key := string(*k)
+	if v.typ != TYPE_BOOLEAN || !strings.HasPrefix(key, featureKeyPrefix) {
+		return "", false
+	}
+	name := strings.NewReplacer("/", "_", "-", "_").
+		Replace(strings.TrimPrefix(key, featureKeyPrefix))
+	return sf.Format(featureEnvVarTemplate, envVarPrefix, strings.ToUpper(name)), true
+}
+
+// overrideFeature sets the value of the boolean feature `k` from its env var, which takes precedence over the config.
+// It allows features to be toggled during incident response without regenerating the config file.
+func overrideFeature(
+	ktx *koanf.Koanf,
+	k *CtxKey,
+	v *ctxVar,
+) bool {
+	name, ok := newFeatureEnvVarName(k, v)
+	if !ok {
+		return false
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return false
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Println(
+			sf.Format("ignoring feature override {0}={1}: invalid boolean value", name, value),
+		)
+		return false
+	}
+
+	ktx.Set(newCtxKeyPath(v), enabled)
+	log.Println(
+		sf.Format("feature '{0}' overridden by {1}={2}", string(*k), name, enabled),
+	)
+	return true
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// TestFeatureOverride verifies that `PCAP_FEATURE_<NAME>` env vars take precedence over the config,
+// and that invalid values are ignored.
+func TestFeatureOverride(t *testing.T) {
+	defer func(vars map[CtxKey]*ctxVar, envs map[CtxKey]*variable) {
+		ctxVars, envVars = vars, envs
+	}(ctxVars, envVars)
+
+	ctxVars = map[CtxKey]*ctxVar{
+		GzipKey:      {"feature.gzip", TYPE_BOOLEAN, false},
+		ConntrackKey: {"feature.conntrack", TYPE_BOOLEAN, false},
+		IfaceKey:     {"iface", TYPE_STRING, false},
+	}
+	envVars = map[CtxKey]*variable{}
+
+	gzipKey, ifaceKey := GzipKey, IfaceKey
+
+	tests := []struct {
+		name    string
+		env     string
+		want    bool
+		outcome Outcome
+	}{
+		{"config", "", true, OUTCOME_RESOLVED},
+		{"disabled", "false", false, OUTCOME_OVERRIDDEN},
+		{"enabled", "1", true, OUTCOME_OVERRIDDEN},
+		{"invalid", "nope", true, OUTCOME_RESOLVED},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv("PCAP_FEATURE_GZIP", tc.env)
+			}
+			// non-boolean keys are never overridden
+			t.Setenv("PCAP_FEATURE_IFACE", "true")
+
+			ktx := koanf.New(".")
+			ktx.Set("pcap.feature.gzip", true)
+			ktx.Set("pcap.feature.conntrack", false)
+			ktx.Set("pcap.iface", "eth0")
+
+			ctx, report := LoadContext(context.Background(), ktx)
+
+			if value := ctx.Value(gzipKey.ToCtxKey()); value != tc.want {
+				t.Errorf("gzip = %v, want %v", value, tc.want)
+			}
+			if entry, _ := report.Get(GzipKey); entry.Outcome != tc.outcome {
+				t.Errorf("gzip outcome = %s, want %s", entry.Outcome, tc.outcome)
+			}
+			if entry, _ := report.Get(ConntrackKey); entry.Outcome != OUTCOME_RESOLVED {
+				t.Errorf("conntrack outcome = %s, want %s", entry.Outcome, OUTCOME_RESOLVED)
+			}
+			if value := ctx.Value(ifaceKey.ToCtxKey()); value != "eth0" {
+				t.Errorf("iface = %v, want eth0", value)
+			}
+		})
+	}
+}
+
+// TestFeatureEnvVarName verifies how feature keys are mapped to env vars.
+func TestFeatureEnvVarName(t *testing.T) {
+	tests := []struct {
+		key  CtxKey
+		typ  ctxVarType
+		want string
+	}{
+		{GzipKey, TYPE_BOOLEAN, "PCAP_FEATURE_GZIP"},
+		{JsondumpKey, TYPE_BOOLEAN, "PCAP_FEATURE_JSON_DUMP"},
+		{JsonlogKey, TYPE_BOOLEAN, "PCAP_FEATURE_JSON_LOG"},
+		{FsNotifyKey, TYPE_BOOLEAN, "PCAP_FEATURE_FS_NOTIFY"},
+		{HealthcheckKey, TYPE_INTEGER, ""},
+		{GcsExportKey, TYPE_BOOLEAN, ""},
+	}
+
+	for _, tc := range tests {
+		got, _ := newFeatureEnvVarName(&tc.key, &ctxVar{typ: tc.typ})
+		if got != tc.want {
+			t.Errorf("%s: env var = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+}
@@ -84,6 +84,23 @@ func registerBooleanFlag(
 	}
 }
 
+func registerIntegerFlag(
+	flags *pflag.FlagSet,
+	name *string,
+	cv *ctxVar,
+	ev *variable,
+) error {
+	if value, err := strconv.
+		Atoi(ev.defaultValue); err == nil {
+		flags.Int(*name, value, ev.description)
+		return nil
+	} else {
+		return errors.Join(errors.New(
+			sf.Format("invalid integer value: {0}", ev.defaultValue),
+		), err)
+	}
+}
+
 func logFlagRegistrationError(
 	v *variable,
 	err error,
@@ -107,6 +124,8 @@ func registerFlag(
 		flags.String(name, ev.defaultValue, ev.description)
 	case TYPE_BOOLEAN:
 		err = registerBooleanFlag(flags, &name, cv, ev)
+	case TYPE_INTEGER:
+		err = registerIntegerFlag(flags, &name, cv, ev)
 	default:
 		path := sf.Format("flag::{0}", ev.name)
 		err = newInvalidConfigValueTypeError(&path)
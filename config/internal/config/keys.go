@@ -51,6 +51,12 @@ const (
 	OrderedKey        = CtxKey("feature/ordered")
 	ConntrackKey      = CtxKey("feature/conntrack")
 	HealthcheckKey    = CtxKey("feature/healthcheck/port")
+	HcCaptureKey      = CtxKey("feature/healthcheck/capture")
+	HcExporterKey     = CtxKey("feature/healthcheck/exporter")
+	HcConfigKey       = CtxKey("feature/healthcheck/config")
+	HcExportKey       = CtxKey("feature/healthcheck/export")
+	HcBacklogKey      = CtxKey("feature/healthcheck/backlog")
+	HcDiskKey         = CtxKey("feature/healthcheck/disk")
 	DebugKey          = CtxKey("feature/debug")
 	SupervisorPortKey = CtxKey("supervisor/port")
 	FilterKey         = CtxKey("filter/bpf")
@@ -69,6 +75,14 @@ const (
 	RotateSecsKey     = CtxKey("rotate-secs")
 	VerbosityKey      = CtxKey("verbosity")
 	ExtensionKey      = CtxKey("extension")
+	LogFieldsKey      = CtxKey("logging/fields")
+	AuditFieldsKey    = CtxKey("logging/audit/fields")
+	CompressionKey    = CtxKey("compression")
+	SampleRateKey     = CtxKey("feature/session/sample-rate")
+	SampleSeedKey     = CtxKey("feature/session/sample-seed")
+	SampleModeKey     = CtxKey("feature/session/sample-mode")
+	ProfileKey        = CtxKey("profile")
+	ProfilesKey       = CtxKey("profiles")
 )
 
 const ctxKeyTemplate = "pcap/cfg/{0}"
@@ -80,6 +94,7 @@ const (
 	TYPE_STRING  = ctxVarType("string")
 	TYPE_BOOLEAN = ctxVarType("boolean")
 	TYPE_INTEGER = ctxVarType("int")
+	TYPE_FLOAT64 = ctxVarType("float64")
 	TYPE_UINT8   = ctxVarType("uint8")
 	TYPE_UINT16  = ctxVarType("uint16")
 	TYPE_UINT32  = ctxVarType("uint32")
@@ -0,0 +1,234 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+	sf "github.com/wissance/stringFormatter"
+)
+
+type (
+	// Profile is a named bundle of config keys, by JSON path relative to `pcap.`.
+	Profile map[string]any
+
+	// ProfileSwitch describes the config keys whose value changed when switching the active profile.
+	ProfileSwitch struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		// Live keys were reconfigured by the subsystems which consume them
+		Live []string `json:"live,omitempty"`
+		// Restart keys only take effect once the sidecar is restarted
+		Restart []string `json:"restart,omitempty"`
+	}
+
+	profilesState struct {
+		// context on top of which the config was loaded
+		parent context.Context
+		// config as loaded, before defaults and profiles were applied
+		ktx *koanf.Koanf
+	}
+
+	profilesStateKey struct{}
+)
+
+const (
+	profilesPath = "pcap.profiles"
+	// config keys set explicitly by env vars or flags; when it is not available, keys present in the config are explicit
+	explicitPath = "pcap.explicit"
+)
+
+var (
+	unknownProfileErr    = errors.New("unknown profile")
+	disallowedProfileErr = errors.New("key is not allowed in profiles")
+
+	// profileKeys are the only config keys which profiles may set
+	profileKeys = []CtxKey{
+		L3ProtosFilterKey,
+		L4ProtosFilterKey,
+		SnaplenKey,
+		GzipKey,
+		CompressionKey,
+		SampleRateKey,
+		SampleSeedKey,
+		SampleModeKey,
+	}
+)
+
+func findCtxKeyByPath(
+	path string,
+) (CtxKey, bool) {
+	for k, v := range ctxVars {
+		if v.path == path {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// profilePaths returns the JSON paths of the config keys which profiles may set.
+func profilePaths() []string {
+	paths := make([]string, 0, len(profileKeys))
+	for _, k := range profileKeys {
+		if v, ok := ctxVars[k]; ok {
+			paths = append(paths, v.path)
+		}
+	}
+	return paths
+}
+
+// loadProfiles returns all the profiles declared in the config.
+func loadProfiles(
+	ktx *koanf.Koanf,
+) map[string]Profile {
+	profiles := make(map[string]Profile)
+	for _, name := range ktx.MapKeys(profilesPath) {
+		pktx := ktx.Cut(sf.Format(ctxKeyPathTemplate, profilesPath, name))
+		profile := make(Profile)
+		for _, path := range pktx.Keys() {
+			profile[path] = pktx.Get(path)
+		}
+		profiles[name] = profile
+	}
+	return profiles
+}
+
+// validateProfile returns the config keys set by the profile, failing if any of them is not allowed.
+func validateProfile(
+	name string,
+	profile Profile,
+) (map[CtxKey]any, error) {
+	values := make(map[CtxKey]any, len(profile))
+	for path, value := range profile {
+		k, ok := findCtxKeyByPath(path)
+		if !ok || !slices.Contains(profileKeys, k) {
+			return nil, errors.Join(disallowedProfileErr,
+				errors.New(sf.Format("profile => {0}: key => {1}: must be one of {2}", name, path, profilePaths())))
+		}
+		values[k] = value
+	}
+	return values, nil
+}
+
+func isExplicit(
+	ktx *koanf.Koanf,
+	k CtxKey,
+	v *ctxVar,
+) bool {
+	if ktx.Exists(explicitPath) {
+		return slices.Contains(ktx.Strings(explicitPath), string(k))
+	}
+	return ktx.Exists(newCtxKeyPath(v))
+}
+
+// expandProfile sets the keys of the active profile which were not set explicitly, and returns them;
+// explicit keys always take precedence over the ones set by the active profile.
+func expandProfile(
+	ktx *koanf.Koanf,
+) ([]CtxKey, error) {
+	v, ok := ctxVars[ProfileKey]
+	if !ok {
+		return nil, nil
+	}
+	name := strings.TrimSpace(ktx.String(newCtxKeyPath(v)))
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := loadProfiles(ktx)[name]
+	if !ok {
+		return nil, errors.Join(unknownProfileErr,
+			errors.New(sf.Format("profile => {0}", name)))
+	}
+	values, err := validateProfile(name, profile)
+	if err != nil {
+		return nil, err
+	}
+	expanded := []CtxKey{}
+	for k, value := range values {
+		v := ctxVars[k]
+		if isExplicit(ktx, k, v) {
+			continue
+		}
+		ktx.Set(newCtxKeyPath(v), value)
+		expanded = append(expanded, k)
+	}
+	return expanded, nil
+}
+
+func getProfilesState(
+	ctx context.Context,
+) (*profilesState, error) {
+	if state, ok := ctx.Value(profilesStateKey{}).(*profilesState); ok {
+		return state, nil
+	}
+	path := profilesPath
+	return nil, newIllegalConfigStateError(&path)
+}
+
+// GetProfiles returns all the profiles declared in the config loaded into the context.
+func GetProfiles(
+	ctx context.Context,
+) (map[string]Profile, error) {
+	state, err := getProfilesState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return loadProfiles(state.ktx), nil
+}
+
+// SwitchProfile reloads the config with another active profile; `live` are the keys whose consumers support
+// being reconfigured at runtime, all other changed keys are reported as requiring a restart.
+// The current context is returned when the config cannot be loaded with the new active profile.
+func SwitchProfile(
+	ctx context.Context,
+	name string,
+	live ...CtxKey,
+) (context.Context, *ConfigReport, *ProfileSwitch, error) {
+	state, err := getProfilesState(ctx)
+	if err != nil {
+		return ctx, nil, nil, err
+	}
+
+	profileKey := ProfileKey
+	from, _ := ctx.Value(profileKey.ToCtxKey()).(string)
+
+	ktx := state.ktx.Copy()
+	ktx.Set(newCtxKeyPath(ctxVars[ProfileKey]), name)
+	// the active profile is never explicit: other keys keep their precedence over profiles
+	switchCtx, report := LoadContext(state.parent, ktx)
+
+	if entry, ok := report.Get(ProfileKey); ok && entry.Outcome == OUTCOME_FAILED {
+		return ctx, report, nil, errors.New(entry.Reason)
+	}
+
+	profileSwitch := &ProfileSwitch{From: from, To: name}
+	for _, k := range profileKeys {
+		key := k.ToCtxKey()
+		if reflect.DeepEqual(ctx.Value(key), switchCtx.Value(key)) {
+			continue
+		}
+		if slices.Contains(live, k) {
+			profileSwitch.Live = append(profileSwitch.Live, string(k))
+		} else {
+			profileSwitch.Restart = append(profileSwitch.Restart, string(k))
+		}
+	}
+	return switchCtx, report, profileSwitch, nil
+}
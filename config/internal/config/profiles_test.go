@@ -0,0 +1,264 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// newProfilesConfig returns a config which declares the `headers` and `full` profiles.
+func newProfilesConfig() *koanf.Koanf {
+	ktx := koanf.New(".")
+	ktx.Set("pcap.env.instance.id", "instance")
+	ktx.Set("pcap.profiles.headers.snaplen", float64(96))
+	ktx.Set("pcap.profiles.headers.feature.gzip", false)
+	ktx.Set("pcap.profiles.headers.feature.session.sample_rate", 0.5)
+	ktx.Set("pcap.profiles.full.snaplen", float64(0))
+	ktx.Set("pcap.profiles.full.feature.session.sample_rate", float64(1))
+	return ktx
+}
+
+// TestProfileExpansion verifies that keys set explicitly take precedence over the ones set by the active profile.
+func TestProfileExpansion(t *testing.T) {
+	snaplenKey := SnaplenKey
+
+	tests := []struct {
+		name    string
+		setup   func(*koanf.Koanf)
+		want    int
+		outcome Outcome
+	}{
+		{
+			"no profile",
+			func(ktx *koanf.Koanf) {},
+			262144,
+			OUTCOME_DEFAULTED,
+		},
+		{
+			"not set",
+			func(ktx *koanf.Koanf) {
+				ktx.Set("pcap.profile", "headers")
+			},
+			96,
+			OUTCOME_PROFILED,
+		},
+		{
+			"set in the config",
+			func(ktx *koanf.Koanf) {
+				ktx.Set("pcap.profile", "headers")
+				ktx.Set("pcap.snaplen", 1500)
+			},
+			1500,
+			OUTCOME_RESOLVED,
+		},
+		{
+			"set explicitly",
+			func(ktx *koanf.Koanf) {
+				ktx.Set("pcap.profile", "headers")
+				ktx.Set("pcap.snaplen", 1500)
+				ktx.Set("pcap.explicit", []string{"profile", "snaplen"})
+			},
+			1500,
+			OUTCOME_RESOLVED,
+		},
+		{
+			// generated configs contain all keys: only the ones set by env vars or flags are explicit
+			"defaulted by the template",
+			func(ktx *koanf.Koanf) {
+				ktx.Set("pcap.profile", "headers")
+				ktx.Set("pcap.snaplen", 262144)
+				ktx.Set("pcap.explicit", []string{"profile"})
+			},
+			96,
+			OUTCOME_PROFILED,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ktx := newProfilesConfig()
+			tc.setup(ktx)
+
+			ctx, report := LoadContext(context.Background(), ktx)
+
+			if report.HasFatal() {
+				t.Fatalf("config is not usable:\n%s", report)
+			}
+			if value := ctx.Value(snaplenKey.ToCtxKey()); value != tc.want {
+				t.Errorf("snaplen = %v, want %v", value, tc.want)
+			}
+			if entry, _ := report.Get(SnaplenKey); entry.Outcome != tc.outcome {
+				t.Errorf("snaplen outcome = %s, want %s", entry.Outcome, tc.outcome)
+			}
+		})
+	}
+}
+
+// TestProfileFeatureOverride verifies that `PCAP_FEATURE_<NAME>` env vars take precedence over profiles.
+func TestProfileFeatureOverride(t *testing.T) {
+	t.Setenv("PCAP_FEATURE_GZIP", "true")
+
+	ktx := newProfilesConfig()
+	ktx.Set("pcap.profile", "headers")
+
+	ctx, report := LoadContext(context.Background(), ktx)
+
+	gzipKey := GzipKey
+	if value := ctx.Value(gzipKey.ToCtxKey()); value != true {
+		t.Errorf("gzip = %v, want true", value)
+	}
+	if entry, _ := report.Get(GzipKey); entry.Outcome != OUTCOME_OVERRIDDEN {
+		t.Errorf("gzip outcome = %s, want %s", entry.Outcome, OUTCOME_OVERRIDDEN)
+	}
+}
+
+// TestInvalidProfile verifies that the config is not usable when the active profile does not exist,
+// or when it sets keys which are not allowed in profiles.
+func TestInvalidProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		reason  string
+	}{
+		{"unknown", "summaries", "unknown profile"},
+		{"disallowed key", "iface", "key => iface"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ktx := newProfilesConfig()
+			ktx.Set("pcap.profiles.iface.iface", "eth0")
+			ktx.Set("pcap.profile", tc.profile)
+
+			ctx, report := LoadContext(context.Background(), ktx)
+
+			entry, _ := report.Get(ProfileKey)
+			if !entry.IsFatal() || !report.HasFatal() {
+				t.Errorf("invalid profile is not fatal: %s", entry)
+			}
+			if !strings.Contains(entry.Reason, tc.reason) {
+				t.Errorf("reason = %q, want %q", entry.Reason, tc.reason)
+			}
+			profileKey := ProfileKey
+			if value := ctx.Value(profileKey.ToCtxKey()); value != nil {
+				t.Errorf("profile = %v, want none", value)
+			}
+		})
+	}
+}
+
+// TestSwitchProfile verifies which keys are reported as reconfigured live, and which ones require a restart.
+func TestSwitchProfile(t *testing.T) {
+	snaplenKey, sampleRateKey := SnaplenKey, SampleRateKey
+
+	tests := []struct {
+		name        string
+		to          string
+		live        []CtxKey
+		wantSnaplen int
+		wantRate    float64
+		wantLive    []string
+		wantRestart []string
+	}{
+		{
+			"restart required",
+			"full",
+			nil,
+			0, 1,
+			nil,
+			[]string{"feature/gzip", "feature/session/sample-rate", "snaplen"},
+		},
+		{
+			"live",
+			"full",
+			[]CtxKey{SampleRateKey, GzipKey},
+			0, 1,
+			[]string{"feature/gzip", "feature/session/sample-rate"},
+			[]string{"snaplen"},
+		},
+		{
+			"no profile",
+			"",
+			[]CtxKey{SnaplenKey},
+			262144, 1,
+			[]string{"snaplen"},
+			[]string{"feature/gzip", "feature/session/sample-rate"},
+		},
+		{
+			"same profile",
+			"headers",
+			nil,
+			96, 0.5,
+			nil,
+			nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ktx := newProfilesConfig()
+			ktx.Set("pcap.profile", "headers")
+			ktx.Set("pcap.feature.gzip", true)
+			ktx.Set("pcap.explicit", []string{"profile"})
+			ctx, _ := LoadContext(context.Background(), ktx)
+
+			switchCtx, report, profileSwitch, err := SwitchProfile(ctx, tc.to, tc.live...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.HasFatal() {
+				t.Fatalf("config is not usable:\n%s", report)
+			}
+			if profileSwitch.From != "headers" || profileSwitch.To != tc.to {
+				t.Errorf("switch = %s => %s, want headers => %s", profileSwitch.From, profileSwitch.To, tc.to)
+			}
+			if value := switchCtx.Value(snaplenKey.ToCtxKey()); value != tc.wantSnaplen {
+				t.Errorf("snaplen = %v, want %v", value, tc.wantSnaplen)
+			}
+			if value := switchCtx.Value(sampleRateKey.ToCtxKey()); value != tc.wantRate {
+				t.Errorf("sample rate = %v, want %v", value, tc.wantRate)
+			}
+			slices.Sort(profileSwitch.Live)
+			slices.Sort(profileSwitch.Restart)
+			if !slices.Equal(profileSwitch.Live, tc.wantLive) {
+				t.Errorf("live = %v, want %v", profileSwitch.Live, tc.wantLive)
+			}
+			if !slices.Equal(profileSwitch.Restart, tc.wantRestart) {
+				t.Errorf("restart = %v, want %v", profileSwitch.Restart, tc.wantRestart)
+			}
+			// the original context is not modified
+			if value := ctx.Value(snaplenKey.ToCtxKey()); value != 96 {
+				t.Errorf("original snaplen = %v, want 96", value)
+			}
+		})
+	}
+
+	ctx, _ := LoadContext(context.Background(), newProfilesConfig())
+	switchCtx, _, _, err := SwitchProfile(ctx, "summaries")
+	if err == nil || !strings.Contains(err.Error(), "unknown profile") {
+		t.Errorf("switching to an unknown profile: got %v", err)
+	}
+	if switchCtx != ctx {
+		t.Error("switching to an unknown profile changed the context")
+	}
+	if _, _, _, err := SwitchProfile(context.Background(), "full"); err == nil {
+		t.Error("switching without a loaded config did not fail")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	sf "github.com/wissance/stringFormatter"
+)
+
+type (
+	Outcome string
+
+	ReportEntry struct {
+		Key      string  `json:"key"`
+		Path     string  `json:"path"`
+		Required bool    `json:"required"`
+		Outcome  Outcome `json:"outcome"`
+		Reason   string  `json:"reason,omitempty"`
+	}
+
+	// ConfigReport describes how every config key was resolved while loading the config.
+	ConfigReport struct {
+		Entries []*ReportEntry `json:"entries"`
+	}
+)
+
+const (
+	OUTCOME_RESOLVED   = Outcome("resolved")
+	OUTCOME_DEFAULTED  = Outcome("defaulted")
+	OUTCOME_OVERRIDDEN = Outcome("overridden")
+	OUTCOME_PROFILED   = Outcome("profiled")
+	OUTCOME_FAILED     = Outcome("failed")
+
+	reportEntryTemplate = "{0} ({1}): {2}"
+)
+
+func newReportEntry(
+	k *CtxKey,
+	v *ctxVar,
+) *ReportEntry {
+	return &ReportEntry{
+		Key:      string(*k),
+		Path:     newCtxKeyPath(v),
+		Required: v.required,
+		Outcome:  OUTCOME_RESOLVED,
+	}
+}
+
+func (e *ReportEntry) fail(
+	err error,
+) {
+	e.Outcome = OUTCOME_FAILED
+	e.Reason = strings.ReplaceAll(err.Error(), "\n", ": ")
+}
+
+// IsFatal reports whether the config is not usable because of this entry;
+// unknown keys are only required in strict mode.
+func (e *ReportEntry) IsFatal() bool {
+	return e.Required && (e.Outcome == OUTCOME_FAILED || e.Outcome == OUTCOME_UNKNOWN)
+}
+
+func (e *ReportEntry) String() string {
+	outcome := string(e.Outcome)
+	if e.Reason != "" {
+		outcome = sf.Format("{0} => {1}", outcome, e.Reason)
+	}
+	return sf.Format(reportEntryTemplate, e.Key, e.Path, outcome)
+}
+
+func (r *ConfigReport) add(
+	entry *ReportEntry,
+) {
+	r.Entries = append(r.Entries, entry)
+}
+
+func (r *ConfigReport) sort() {
+	sort.Slice(r.Entries, func(i, j int) bool {
+		return r.Entries[i].Key < r.Entries[j].Key
+	})
+}
+
+// HasFatal reports whether any required key failed to be resolved, or is unknown in strict mode.
+func (r *ConfigReport) HasFatal() bool {
+	for _, entry := range r.Entries {
+		if entry.IsFatal() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ConfigReport) Get(
+	key CtxKey,
+) (*ReportEntry, bool) {
+	for _, entry := range r.Entries {
+		if entry.Key == string(key) {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+func (r *ConfigReport) String() string {
+	lines := make([]string, len(r.Entries))
+	for i, entry := range r.Entries {
+		lines[i] = entry.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *ConfigReport) JSON() ([]byte, error) {
+	return json.Marshal(r)
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// TestLoadContextReport verifies the outcome reported for every config key,
+// and that failed keys never set error-valued context variables.
+func TestLoadContextReport(t *testing.T) {
+	const (
+		resolvedKey    = CtxKey("test/resolved")
+		defaultedKey   = CtxKey("test/defaulted")
+		requiredKey    = CtxKey("test/required")
+		noDefaultKey   = CtxKey("test/no-default")
+		invalidTypeKey = CtxKey("test/invalid-type")
+	)
+
+	defer func(vars map[CtxKey]*ctxVar, envs map[CtxKey]*variable) {
+		ctxVars, envVars = vars, envs
+	}(ctxVars, envVars)
+
+	ctxVars = map[CtxKey]*ctxVar{
+		resolvedKey:    {"resolved", TYPE_STRING, true},
+		defaultedKey:   {"defaulted", TYPE_STRING, false},
+		requiredKey:    {"required", TYPE_STRING, true},
+		noDefaultKey:   {"no_default", TYPE_STRING, false},
+		invalidTypeKey: {"invalid_type", TYPE_UINT64, false},
+	}
+	envVars = map[CtxKey]*variable{
+		defaultedKey: {"defaulted", "default", ""},
+	}
+
+	ktx := koanf.New(".")
+	ktx.Set("pcap.resolved", "value")
+	ktx.Set("pcap.invalid_type", "1")
+
+	ctx, report := LoadContext(context.Background(), ktx)
+
+	tests := []struct {
+		key       CtxKey
+		want      Outcome
+		wantValue any
+		wantFatal bool
+	}{
+		{resolvedKey, OUTCOME_RESOLVED, "value", false},
+		{defaultedKey, OUTCOME_DEFAULTED, "default", false},
+		{requiredKey, OUTCOME_FAILED, nil, true},
+		{noDefaultKey, OUTCOME_FAILED, nil, false},
+		{invalidTypeKey, OUTCOME_FAILED, nil, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.key), func(t *testing.T) {
+			entry, ok := report.Get(tc.key)
+			if !ok {
+				t.Fatalf("key %s is not reported", tc.key)
+			}
+			if entry.Outcome != tc.want {
+				t.Errorf("outcome = %s, want %s", entry.Outcome, tc.want)
+			}
+			if (entry.Outcome == OUTCOME_FAILED) != (entry.Reason != "") {
+				t.Errorf("reason = %q for outcome %s", entry.Reason, entry.Outcome)
+			}
+			if entry.IsFatal() != tc.wantFatal {
+				t.Errorf("IsFatal() = %v, want %v", entry.IsFatal(), tc.wantFatal)
+			}
+			if value := ctx.Value(tc.key.ToCtxKey()); value != tc.wantValue {
+				t.Errorf("context value = %v, want %v", value, tc.wantValue)
+			}
+		})
+	}
+
+	if !report.HasFatal() {
+		t.Error("HasFatal() = false, want true")
+	}
+}
+
+// TestConfigReportRendering verifies the human and JSON renderings of the report.
+func TestConfigReportRendering(t *testing.T) {
+	report := &ConfigReport{}
+	report.add(&ReportEntry{Key: "verbosity", Path: "pcap.verbosity", Outcome: OUTCOME_DEFAULTED})
+	report.add(&ReportEntry{Key: "debug", Path: "pcap.debug", Outcome: OUTCOME_RESOLVED})
+	report.sort()
+
+	if report.HasFatal() {
+		t.Error("HasFatal() = true, want false")
+	}
+
+	want := "debug (pcap.debug): resolved\nverbosity (pcap.verbosity): defaulted"
+	if got := report.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	data, err := report.JSON()
+	if err != nil {
+		t.Fatalf("JSON() failed: %v", err)
+	}
+	var decoded ConfigReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON report: %v", err)
+	}
+	if len(decoded.Entries) != 2 || decoded.Entries[0].Key != "debug" {
+		t.Errorf("decoded report = %+v", decoded.Entries)
+	}
+	if strings.Contains(string(data), "reason") {
+		t.Errorf("JSON() = %s, want no reasons", data)
+	}
+}
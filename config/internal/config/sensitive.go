@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"strings"
+)
+
+// REDACTED_VALUE replaces the values of sensitive keys wherever the config is logged or exported.
+const REDACTED_VALUE = "***"
+
+// sensitiveKeys are config keys whose values must never be logged nor exported;
+// getters still return their actual values, so only code can read them.
+var sensitiveKeys = map[CtxKey]struct{}{}
+
+// IsSensitive returns whether the value of `k` must be redacted.
+func IsSensitive(
+	k CtxKey,
+) bool {
+	_, ok := sensitiveKeys[k]
+	return ok
+}
+
+// sensitivePaths returns the JSON paths of all sensitive keys, without the `pcap.` prefix.
+func sensitivePaths() []string {
+	paths := []string{}
+	for k := range sensitiveKeys {
+		if v, ok := ctxVars[k]; ok {
+			paths = append(paths, v.path)
+		}
+	}
+	return paths
+}
+
+// redactPath replaces the value at the dotted `path` of `document` if it is set.
+func redactPath(
+	document map[string]any,
+	path string,
+) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := document[part].(map[string]any)
+		if !ok {
+			return
+		}
+		document = next
+	}
+	if _, ok := document[parts[len(parts)-1]]; ok {
+		document[parts[len(parts)-1]] = REDACTED_VALUE
+	}
+}
+
+// RedactJSON replaces the values of all sensitive keys in the JSON config `content`,
+// including the ones set by capture profiles.
+func RedactJSON(
+	content []byte,
+) ([]byte, error) {
+	var document map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if pcap, ok := document[ctxKeyPrefix].(map[string]any); ok {
+		profiles, _ := pcap[strings.TrimPrefix(profilesPath, ctxKeyPrefix+".")].(map[string]any)
+		for _, path := range sensitivePaths() {
+			redactPath(pcap, path)
+			for _, profile := range profiles {
+				if profile, ok := profile.(map[string]any); ok {
+					redactPath(profile, path)
+				}
+			}
+		}
+	}
+	return json.MarshalIndent(document, "", "  ")
+}
+
+// Redacted returns a copy of the profile where the values of sensitive keys are replaced.
+func (p Profile) Redacted() Profile {
+	redacted := maps.Clone(p)
+	for _, path := range sensitivePaths() {
+		if _, ok := redacted[path]; ok {
+			redacted[path] = REDACTED_VALUE
+		}
+	}
+	return redacted
+}
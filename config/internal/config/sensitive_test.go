@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// TestRedactJSON verifies that a sensitive key is redacted in the exported config,
+// including when it is set by a profile, while its actual value is still available to code.
+func TestRedactJSON(t *testing.T) {
+	sensitiveKeys[GcsDirKey] = struct{}{}
+	defer delete(sensitiveKeys, GcsDirKey)
+
+	content := []byte(`{"pcap":{"env":{"instance":{"id":"instance"}},"gcs":{"dir":"/secret/dir"},"profiles":{"headers":{"gcs":{"dir":"/profile/dir"}}},"snaplen":96}}`)
+
+	redacted, err := RedactJSON(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Pcap struct {
+			Gcs struct {
+				Dir string `json:"dir"`
+			} `json:"gcs"`
+			Profiles map[string]struct {
+				Gcs struct {
+					Dir string `json:"dir"`
+				} `json:"gcs"`
+			} `json:"profiles"`
+			Snaplen int `json:"snaplen"`
+		} `json:"pcap"`
+	}
+	if err := json.Unmarshal(redacted, &document); err != nil {
+		t.Fatalf("invalid redacted config: %v", err)
+	}
+	if document.Pcap.Gcs.Dir != REDACTED_VALUE {
+		t.Errorf("gcs.dir = %s, want %s", document.Pcap.Gcs.Dir, REDACTED_VALUE)
+	}
+	if dir := document.Pcap.Profiles["headers"].Gcs.Dir; dir != REDACTED_VALUE {
+		t.Errorf("profiles.headers.gcs.dir = %s, want %s", dir, REDACTED_VALUE)
+	}
+	if document.Pcap.Snaplen != 96 {
+		t.Errorf("snaplen = %d, want 96", document.Pcap.Snaplen)
+	}
+
+	if profile := (Profile{"gcs.dir": "/profile/dir", "snaplen": 96}).Redacted(); profile["gcs.dir"] != REDACTED_VALUE || profile["snaplen"] != 96 {
+		t.Errorf("redacted profile = %v", profile)
+	}
+
+	ktx := koanf.New(".")
+	ktx.Set("pcap.env.instance.id", "instance")
+	ktx.Set("pcap.gcs.dir", "/secret/dir")
+	ctx, _ := LoadContext(context.Background(), ktx)
+	gcsDirKey := GcsDirKey
+	if dir, _ := ctx.Value(gcsDirKey.ToCtxKey()).(string); dir != "/secret/dir" {
+		t.Errorf("gcs.dir = %s, want /secret/dir", dir)
+	}
+}
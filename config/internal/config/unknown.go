@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+	sf "github.com/wissance/stringFormatter"
+)
+
+// KeysMode defines how config keys which do not map to any context variable are handled.
+type KeysMode string
+
+const (
+	// KEYS_LENIENT ignores unknown keys
+	KEYS_LENIENT = KeysMode("lenient")
+	// KEYS_WARN reports unknown keys without making the config unusable
+	KEYS_WARN = KeysMode("warn")
+	// KEYS_STRICT reports unknown keys as fatal
+	KEYS_STRICT = KeysMode("strict")
+
+	OUTCOME_UNKNOWN = Outcome("unknown")
+
+	// edits within which a known path is suggested for an unknown one
+	maxSuggestionDistance = 3
+)
+
+var (
+	invalidKeysModeErr  = errors.New("invalid keys mode")
+	unknownConfigKeyErr = errors.New("unknown config key")
+)
+
+// ParseKeysMode returns the KeysMode named `mode`, case-insensitively.
+func ParseKeysMode(
+	mode string,
+) (KeysMode, error) {
+	switch m := KeysMode(strings.ToLower(mode)); m {
+	case KEYS_LENIENT, KEYS_WARN, KEYS_STRICT:
+		return m, nil
+	}
+	return KEYS_LENIENT, errors.Join(invalidKeysModeErr,
+		errors.New(sf.Format("{0} is not one of: lenient, warn, strict", mode)))
+}
+
+// isKnownPath reports whether `path` is read while loading the config;
+// profiles are validated when they are expanded, so keys within them are always known.
+func isKnownPath(
+	path string,
+	known []string,
+) bool {
+	if path == profilesPath || strings.HasPrefix(path, profilesPath+".") {
+		return true
+	}
+	return path == explicitPath || slices.Contains(known, path)
+}
+
+func knownPaths() []string {
+	paths := make([]string, 0, len(ctxVars))
+	for _, v := range ctxVars {
+		paths = append(paths, newCtxKeyPath(v))
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// suggestPath returns the known path closest to `path`, if it is likely to be a misspelling of it.
+func suggestPath(
+	path string,
+	known []string,
+) (string, bool) {
+	suggestion, distance := "", maxSuggestionDistance+1
+	for _, candidate := range known {
+		if d := editDistance(path, candidate); d < distance {
+			suggestion, distance = candidate, d
+		}
+	}
+	return suggestion, suggestion != ""
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// unknownKeys returns one report entry for every key in the config which is never read;
+// in strict mode the entries are fatal.
+func unknownKeys(
+	ktx *koanf.Koanf,
+	mode KeysMode,
+) []*ReportEntry {
+	if mode == KEYS_LENIENT {
+		return nil
+	}
+	known := knownPaths()
+	entries := []*ReportEntry{}
+	for _, path := range ktx.Keys() {
+		if isKnownPath(path, known) {
+			continue
+		}
+		entry := &ReportEntry{
+			Key:      path,
+			Path:     path,
+			Required: mode == KEYS_STRICT,
+			Outcome:  OUTCOME_UNKNOWN,
+		}
+		err := unknownConfigKeyErr
+		if suggestion, ok := suggestPath(path, known); ok {
+			err = errors.Join(err, errors.New(sf.Format("did you mean {0}?", suggestion)))
+		}
+		entry.Reason = strings.ReplaceAll(err.Error(), "\n", ": ")
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// LoadContextWithKeysMode is LoadContext which also reports the config keys which are never read, according to `mode`.
+func LoadContextWithKeysMode(
+	ctx context.Context,
+	ktx *koanf.Koanf,
+	mode KeysMode,
+) (context.Context, *ConfigReport) {
+	// defaults and profiles are set into the config while loading it: only keys which were given may be unknown
+	unknown := unknownKeys(ktx, mode)
+	ctx, report := LoadContext(ctx, ktx)
+	for _, entry := range unknown {
+		report.add(entry)
+	}
+	report.sort()
+	return ctx, report
+}
+
+// Unknown returns the entries of the config keys which are never read.
+func (r *ConfigReport) Unknown() []*ReportEntry {
+	entries := []*ReportEntry{}
+	for _, entry := range r.Entries {
+		if entry.Outcome == OUTCOME_UNKNOWN {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
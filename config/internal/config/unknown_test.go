@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// TestUnknownKeys verifies that a misspelled key is reported according to the keys mode,
+// and that profiles and explicit keys are never reported.
+func TestUnknownKeys(t *testing.T) {
+	newKtx := func() *koanf.Koanf {
+		ktx := koanf.New(".")
+		ktx.Set("pcap.env.instance.id", "instance")
+		ktx.Set("pcap.filter.bfp", "tcp port 443")
+		ktx.Set("pcap.explicit", []string{"snaplen"})
+		ktx.Set("pcap.profiles.headers.snaplen", 128)
+		return ktx
+	}
+
+	tests := []struct {
+		mode      KeysMode
+		wantCount int
+		wantFatal bool
+	}{
+		{KEYS_LENIENT, 0, false},
+		{KEYS_WARN, 1, false},
+		{KEYS_STRICT, 1, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			_, report := LoadContextWithKeysMode(context.Background(), newKtx(), tc.mode)
+
+			unknown := report.Unknown()
+			if len(unknown) != tc.wantCount {
+				t.Fatalf("Unknown() = %v, want %d entries", unknown, tc.wantCount)
+			}
+			if tc.wantCount == 0 {
+				return
+			}
+			entry := unknown[0]
+			if got := entry.IsFatal(); got != tc.wantFatal {
+				t.Errorf("IsFatal() = %v, want %v", got, tc.wantFatal)
+			}
+			if entry.Path != "pcap.filter.bfp" {
+				t.Errorf("unknown path = %q, want pcap.filter.bfp", entry.Path)
+			}
+			if !strings.Contains(entry.Reason, "did you mean pcap.filter.bpf?") {
+				t.Errorf("reason = %q, want a suggestion", entry.Reason)
+			}
+		})
+	}
+}
+
+func TestParseKeysMode(t *testing.T) {
+	if mode, err := ParseKeysMode("STRICT"); err != nil || mode != KEYS_STRICT {
+		t.Errorf("ParseKeysMode(STRICT) = %v, %v", mode, err)
+	}
+	if _, err := ParseKeysMode("loose"); err == nil {
+		t.Error("ParseKeysMode(loose) succeeded")
+	}
+}
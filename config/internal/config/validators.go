@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/bpf"
+	"github.com/knadh/koanf/v2"
+	"github.com/robfig/cron/v3"
+	sf "github.com/wissance/stringFormatter"
+)
+
+// ctxValidator verifies a resolved config value; it may depend on other config values.
+// It returns whether the value is required, and why it is not usable.
+type ctxValidator func(ktx *koanf.Koanf, value any) (bool, error)
+
+// ctxValidators run after all context variables are resolved, so that config mistakes are found at load time.
+var ctxValidators = map[CtxKey]ctxValidator{
+	CronExpressionKey: validateCronExpression,
+	FilterKey:         validateFilter,
+}
+
+func newInvalidConfigValueError(
+	path *string,
+	err error,
+) error {
+	return errors.Join(
+		invalidConfigErr,
+		newConfigPathError(path),
+		err,
+	)
+}
+
+// ParseCronExpression parses a standard cron expression: 5 fields, or descriptors such as `@hourly`.
+func ParseCronExpression(
+	expression string,
+) (cron.Schedule, error) {
+	return cron.ParseStandard(expression)
+}
+
+// validateCronExpression requires a valid cron expression only when scheduled captures are enabled.
+func validateCronExpression(
+	ktx *koanf.Koanf,
+	value any,
+) (bool, error) {
+	enabledPath := newCtxKeyPath(ctxVars[CronKey])
+	if !ktx.Bool(enabledPath) {
+		return false, nil
+	}
+
+	expression, _ := value.(string)
+	if _, err := ParseCronExpression(expression); err != nil {
+		path := newCtxKeyPath(ctxVars[CronExpressionKey])
+		return true, newInvalidConfigValueError(&path,
+			errors.New(sf.Format("'{0}': {1}", expression, err.Error())))
+	}
+	return true, nil
+}
+
+// validateFilter requires the BPF filter, unless it is disabled, to compile.
+func validateFilter(
+	_ *koanf.Koanf,
+	value any,
+) (bool, error) {
+	filter, _ := value.(string)
+	if filter == "" || strings.EqualFold(filter, "DISABLED") {
+		return false, nil
+	}
+
+	if err := bpf.CompileCheck(filter); err != nil {
+		path := newCtxKeyPath(ctxVars[FilterKey])
+		return true, newInvalidConfigValueError(&path,
+			errors.New(sf.Format("'{0}': {1}", filter, err.Error())))
+	}
+	return true, nil
+}
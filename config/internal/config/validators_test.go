@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// TestLoadContextCronExpression verifies that cron expressions are validated at load time,
+// and that they are only required when scheduled captures are enabled.
+func TestLoadContextCronExpression(t *testing.T) {
+	defer func(vars map[CtxKey]*ctxVar) {
+		ctxVars = vars
+	}(ctxVars)
+
+	ctxVars = map[CtxKey]*ctxVar{
+		CronKey:           {"feature.cron.enabled", TYPE_BOOLEAN, false},
+		CronExpressionKey: {"feature.cron.expression", TYPE_STRING, false},
+	}
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		expression string
+		want       Outcome
+		wantFatal  bool
+	}{
+		{"fields", true, "*/5 * * * *", OUTCOME_RESOLVED, false},
+		{"descriptor", true, "@hourly", OUTCOME_RESOLVED, false},
+		{"timezone", true, "CRON_TZ=UTC 0 9 * * 1-5", OUTCOME_RESOLVED, false},
+		{"too many fields", true, "0 */5 * * * *", OUTCOME_FAILED, true},
+		{"out of range", true, "61 * * * *", OUTCOME_FAILED, true},
+		{"malformed", true, "every minute", OUTCOME_FAILED, true},
+		{"empty", true, "", OUTCOME_FAILED, true},
+		{"disabled", false, "-", OUTCOME_RESOLVED, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ktx := koanf.New(".")
+			ktx.Set("pcap.feature.cron.enabled", tc.enabled)
+			ktx.Set("pcap.feature.cron.expression", tc.expression)
+
+			ctx, report := LoadContext(context.Background(), ktx)
+
+			entry, ok := report.Get(CronExpressionKey)
+			if !ok {
+				t.Fatalf("key %s is not reported", CronExpressionKey)
+			}
+			if entry.Outcome != tc.want {
+				t.Errorf("outcome = %s, want %s: %s", entry.Outcome, tc.want, entry.Reason)
+			}
+			if entry.IsFatal() != tc.wantFatal || report.HasFatal() != tc.wantFatal {
+				t.Errorf("IsFatal() = %v, want %v", entry.IsFatal(), tc.wantFatal)
+			}
+			key := CronExpressionKey
+			value := ctx.Value(key.ToCtxKey())
+			if tc.want == OUTCOME_FAILED && value != nil {
+				t.Errorf("context value = %v, want none", value)
+			} else if tc.want != OUTCOME_FAILED && value != tc.expression {
+				t.Errorf("context value = %v, want %s", value, tc.expression)
+			}
+		})
+	}
+}
+
+// TestLoadContextFilter verifies that BPF filters which would not compile are rejected at load time.
+func TestLoadContextFilter(t *testing.T) {
+	defer func(vars map[CtxKey]*ctxVar) {
+		ctxVars = vars
+	}(ctxVars)
+
+	ctxVars = map[CtxKey]*ctxVar{
+		FilterKey: {"filter.bpf", TYPE_STRING, false},
+	}
+
+	tests := []struct {
+		name      string
+		filter    string
+		want      Outcome
+		wantFatal bool
+	}{
+		{"disabled", "DISABLED", OUTCOME_RESOLVED, false},
+		{"empty", "", OUTCOME_RESOLVED, false},
+		{"valid", "tcp port 443 and not host 169.254.169.254", OUTCOME_RESOLVED, false},
+		{"generated", "(tcp[tcpflags]&(tcp-syn|tcp-push)!=0) or (ip6[13+40]&0x0a!=0)", OUTCOME_RESOLVED, false},
+		{"syntax", "tcp port", OUTCOME_FAILED, true},
+		{"qualifier", "icmp port 80", OUTCOME_FAILED, true},
+		{"constant", "tcp[tcpflags]&tcp-psh!=0", OUTCOME_FAILED, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ktx := koanf.New(".")
+			ktx.Set("pcap.filter.bpf", tc.filter)
+
+			_, report := LoadContext(context.Background(), ktx)
+
+			entry, ok := report.Get(FilterKey)
+			if !ok {
+				t.Fatalf("key %s is not reported", FilterKey)
+			}
+			if entry.Outcome != tc.want {
+				t.Errorf("outcome = %s, want %s: %s", entry.Outcome, tc.want, entry.Reason)
+			}
+			if entry.IsFatal() != tc.wantFatal {
+				t.Errorf("IsFatal() = %v, want %v", entry.IsFatal(), tc.wantFatal)
+			}
+		})
+	}
+}
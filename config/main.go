@@ -15,25 +15,108 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
 	cfg "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/buildinfo"
+	pcap "github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/config"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/healthcheck"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/snaplen"
 	"github.com/spf13/pflag"
 	flag "github.com/spf13/pflag"
 	sf "github.com/wissance/stringFormatter"
 )
 
+// probes must complete within the default Cloud Run/GKE probe timeout of 1 second
+const healthcheckTimeout = 900 * time.Millisecond
+
 func registerFlags(
 	flags *pflag.FlagSet,
 ) *pflag.FlagSet {
 	flags.String("template", "/pcap.jsonnet", "absolute path of the PCAP config file template")
 	flags.String("config", "/pcap.json", "absolute path where the PCAP config file should be generated")
+	flags.Bool("version", false, "print version information and exit")
+	flags.Bool("version_json", false, "print version information as JSON and exit")
+	flags.Bool("healthcheck", false, "serve startup, liveness, and readiness probes after creating the config file, until SIGTERM")
+	flags.Bool("list_profiles", false, "print the names of all capture profiles after creating the config file, and exit")
+	flags.String("show_profile", "", "print the config keys set by the named capture profile after creating the config file, and exit")
+	flags.String("unknown_keys", string(pcap.KEYS_WARN), "how keys in the config file which are never read are reported: lenient, warn, or strict")
 
 	return flags
 }
 
+// printProfiles prints all capture profiles, or the config keys set by the one named `show`;
+// it is available even if the active profile is not usable, so that it can be fixed.
+func printProfiles(
+	ctx context.Context,
+	show string,
+) error {
+	profiles, err := pcap.GetProfiles(ctx)
+	if err != nil {
+		return err
+	}
+	active, _ := pcap.GetProfile(ctx)
+
+	if show == "" {
+		names := slices.Sorted(maps.Keys(profiles))
+		for _, name := range names {
+			if name == active {
+				name = sf.Format("{0} (active)", name)
+			}
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	profile, ok := profiles[show]
+	if !ok {
+		return errors.New(sf.Format("unknown profile: {0}", show))
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	// values of sensitive keys are never printed
+	return encoder.Encode(profile.Redacted())
+}
+
+// startHealthcheck serves the probes assembled from the config until SIGTERM is received.
+func startHealthcheck(
+	ctx context.Context,
+) error {
+	hcConfig, err := pcap.GetHealthcheckConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", sf.Format(":{0}", hcConfig.Port))
+	if err != nil {
+		return err
+	}
+
+	checks := healthcheck.NewChecks(hcConfig, &http.Client{})
+	server := healthcheck.NewServer(checks, healthcheckTimeout)
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	log.Println(
+		sf.Format("serving {0} healthchecks at: {1}", len(checks), listener.Addr().String()),
+	)
+	return server.Serve(ctx, listener)
+}
+
 func main() {
 	flags := flag.NewFlagSet("pcap", flag.ContinueOnError)
 
@@ -41,6 +124,19 @@ func main() {
 
 	flags.Parse(os.Args[1:])
 
+	printVersion, _ := flags.GetBool("version")
+	versionJSON, _ := flags.GetBool("version_json")
+	if printVersion || versionJSON {
+		if err := buildinfo.Get().Print(os.Stdout, versionJSON); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Println(
+		sf.Format("starting: {0}", buildinfo.Get()),
+	)
+
 	template, _ := flags.GetString("template")
 	config, _ := flags.GetString("config")
 
@@ -55,6 +151,108 @@ func main() {
 		sf.Format("config file created at: {0}", config),
 	)
 
+	unknownKeys, _ := flags.GetString("unknown_keys")
+	keysMode, err := pcap.ParseKeysMode(unknownKeys)
+	if err != nil {
+		log.Fatalln(
+			sf.Format("invalid --unknown_keys: {0}", err.Error()),
+		)
+	}
+
+	ctx, report, err := pcap.LoadJSONWithKeysMode(context.Background(), config, keysMode)
+	if err != nil {
+		log.Fatalln(
+			sf.Format("failed to load config file: {0}", err.Error()),
+		)
+	}
+	log.Println(
+		sf.Format("config report:\n{0}", report.String()),
+	)
+	listProfiles, _ := flags.GetBool("list_profiles")
+	showProfile, _ := flags.GetString("show_profile")
+	if listProfiles || showProfile != "" {
+		if err := printProfiles(ctx, showProfile); err != nil {
+			log.Fatalln(
+				sf.Format("failed to print profiles: {0}", err.Error()),
+			)
+		}
+		return
+	}
+	for _, entry := range report.Unknown() {
+		log.Println(
+			sf.Format("WARNING: {0}", entry.String()),
+		)
+	}
+	if report.HasFatal() {
+		log.Fatalln("config file is not usable: required keys are missing, invalid, or unknown")
+	}
+	log.Println(
+		sf.Format("features: {0}", pcap.GetFeatures(ctx).String()),
+	)
+	if profile, err := pcap.GetProfile(ctx); err == nil {
+		log.Println(
+			sf.Format("capture profile: {0}", profile),
+		)
+	}
+	if enabled, _ := pcap.IsCronEnabled(ctx); enabled {
+		// an invalid cron expression is reported as fatal: scheduled captures would never start
+		if expression, err := pcap.GetCronExpression(ctx); err == nil {
+			log.Println(
+				sf.Format("cron expression: {0}", expression),
+			)
+		}
+	}
+	if length, err := pcap.GetSnaplen(ctx); err == nil {
+		log.Println(
+			sf.Format("snaplen: {0}", length),
+		)
+		if snaplen.IsTruncating(length) {
+			log.Println(
+				sf.Format("WARNING: snaplen {0} is smaller than {1} bytes: full-sized packets will be truncated", length, snaplen.MinUntruncated),
+			)
+		}
+	} else if err != pcap.UnavailableConfigError {
+		log.Fatalln(
+			sf.Format("invalid snaplen: {0}", err.Error()),
+		)
+	}
+	if sampling, err := pcap.GetSessionSampling(ctx); err == nil {
+		log.Println(
+			sf.Format("session sampling: rate={0} seed={1} mode={2}", sampling.Rate, sampling.Seed, sampling.Mode),
+		)
+	} else if err != pcap.UnavailableConfigError {
+		log.Fatalln(
+			sf.Format("invalid session sampling: {0}", err.Error()),
+		)
+	}
+	// supervisorctl and the process filter of tcpdumpw reach supervisord at this address
+	if url, err := pcap.GetSupervisorURL(ctx); err == nil {
+		log.Println(
+			sf.Format("supervisor: {0}", url),
+		)
+	} else if err != pcap.UnavailableConfigError {
+		log.Fatalln(
+			sf.Format("invalid supervisor port: {0}", err.Error()),
+		)
+	}
+	if port, err := pcap.GetHealthcheckPort(ctx); err == nil {
+		log.Println(
+			sf.Format("healthcheck port: {0}", port),
+		)
+	} else {
+		log.Println(
+			sf.Format("healthcheck port is not available: {0}", err.Error()),
+		)
+	}
+
+	if serveHealthcheck, _ := flags.GetBool("healthcheck"); serveHealthcheck {
+		if err := startHealthcheck(ctx); err != nil {
+			log.Fatalln(
+				sf.Format("failed to serve healthcheck: {0}", err.Error()),
+			)
+		}
+	}
+
 	// TODO: move ALL cmd args from all modules to this one and merge them with env vars using:
 	//  - https://pkg.go.dev/github.com/knadh/koanf/providers/posflag
 	//  - https://github.com/knadh/koanf?tab=readme-ov-file#reading-from-command-line
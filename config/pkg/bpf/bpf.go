@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bpf checks whether a capture filter would compile, without requiring libpcap;
+// it mirrors libpcap's grammar and qualifier rules so that invalid filters are rejected
+// when configuration is validated instead of when tcpdump starts.
+//
+// Host names are only checked syntactically: libpcap resolves them at compile time,
+// which depends on the network where the filter is eventually used.
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxInstructions is libpcap's `BPF_MAXINSNS`; larger programs are rejected by the kernel.
+	MaxInstructions = 4096
+	// MaxNesting bounds the depth of parenthesized expressions.
+	MaxNesting = 1000
+)
+
+var ErrInvalidFilter = errors.New("invalid BPF filter")
+
+// CompileError describes why a filter does not compile, and where.
+type CompileError struct {
+	// Pos is the byte offset within the filter where the error was detected.
+	Pos   int
+	Token string
+	Msg   string
+}
+
+func (e *CompileError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+	}
+	return fmt.Sprintf("%s at position %d near '%s'", e.Msg, e.Pos, e.Token)
+}
+
+func (e *CompileError) Unwrap() error {
+	return ErrInvalidFilter
+}
+
+// CompileCheck returns a `*CompileError` if `filter` would not compile into a BPF program;
+// an empty filter captures every packet, so it is valid.
+func CompileCheck(
+	filter string,
+) error {
+	if strings.TrimSpace(filter) == "" {
+		return nil
+	}
+
+	tokens, err := lex(filter)
+	if err != nil {
+		return err
+	}
+
+	p := &parser{tokens: tokens}
+	if err := p.parseExpression(); err != nil {
+		return err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return p.errorAt(tok, "syntax error")
+	}
+	if p.instructions > MaxInstructions {
+		return &CompileError{
+			Pos: 0,
+			Msg: fmt.Sprintf("expression too complex: at least %d instructions, max is %d", p.instructions, MaxInstructions),
+		}
+	}
+	return nil
+}
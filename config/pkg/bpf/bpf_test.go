@@ -0,0 +1,163 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestCompileCheckValid verifies that filters accepted by libpcap, including every one generated from structured config, compile.
+func TestCompileCheckValid(t *testing.T) {
+	filters := []string{
+		"",
+		"   ",
+		"tcp",
+		"ip or ip6 or arp",
+		"tcp or udp or icmp or icmp6",
+		"(tcp or udp or icmp or icmp6) and (ip or ip6 or arp)",
+		"port 80 or port 443",
+		"port 80 or 443",
+		"tcp port 80",
+		"udp dst port domain",
+		"sctp src port 9899",
+		"portrange 8000-8080",
+		"tcp portrange 1-65535",
+		"host 10.0.0.1 or host 10.0.0.2",
+		"host 10.0.0.1 or 10.0.0.2",
+		"host ::1",
+		"ip6 host fe80::1",
+		"ip host 169.254.169.254",
+		"src or dst host metadata.google.internal",
+		"src and dst net 10.0.0.0/8",
+		"net 0.0.0.0/0 or net ::/0",
+		"net 10/8",
+		"net 192.168 mask 255.255.0.0",
+		"ether host aa:bb:cc:dd:ee:ff",
+		"ether broadcast or ip multicast",
+		"ip proto \\tcp",
+		"ip6 proto 58",
+		"ether proto 0x86dd",
+		"greater 64 and less 1500",
+		"not port 22",
+		"! (port 22 || port 3389)",
+		"tcp && !udp",
+		"(tcp[tcpflags]&(tcp-syn|tcp-ack)!=0) or (ip6[13+40]&0x12!=0)",
+		"tcp[tcpflags] & (tcp-push) != 0",
+		"tcp[13] & 2 != 0",
+		"(tcp[13]&2)!=0",
+		"ip[2:2] > 576",
+		"icmp[icmptype] = icmp-echo",
+		"len <= 128",
+		"ip[0] & 0xf != 5",
+		"tcp[((tcp[12:1] & 0xf0) >> 2):4] = 0x47455420",
+		"vlan and tcp",
+		"-1 < 0",
+		"((((tcp))))",
+		"ip[6:2] & 0x1fff = 0 and ip[8] % 2 = 1",
+	}
+
+	for _, filter := range filters {
+		if err := CompileCheck(filter); err != nil {
+			t.Errorf("CompileCheck(%q) = %v, want nil", filter, err)
+		}
+	}
+}
+
+// TestCompileCheckInvalid verifies that filters rejected by libpcap fail, and where.
+func TestCompileCheckInvalid(t *testing.T) {
+	tests := []struct {
+		filter string
+		pos    int
+		msg    string
+	}{
+		{"tcp port", 8, "syntax error"},
+		{"port 80 and", 11, "syntax error"},
+		{"port 99999", 5, "illegal port number"},
+		{"port nosuchservice", 5, "unknown port"},
+		{"icmp port 80", 0, "illegal qualifier of 'port'"},
+		{"ip6 portrange 1-2", 0, "illegal qualifier of 'portrange'"},
+		{"portrange 80", 10, "illegal port range"},
+		{"tcp host 10.0.0.1", 0, "'tcp' modifier applied to host"},
+		{"ip6 host 10.0.0.1", 0, "'ip6' modifier applied to ip host"},
+		{"ip host ::1", 0, "'ip' modifier applied to ip6 host"},
+		{"ether host 10.0.0.1", 11, "bad ethernet address"},
+		{"host 999.1.1.1", 5, "invalid host"},
+		{"net 10.0.0.1/8", 4, "non-network bits set"},
+		{"net 10.0.0.0/33", 4, "mask length must be <= 32"},
+		{"net fe80::1/64", 4, "non-network bits set"},
+		{"net 10.1.0.0 mask 255.0.0.0", 4, "non-network bits set"},
+		{"udp net 10.0.0.0/8", 0, "'udp' modifier applied to net"},
+		{"proto nosuchproto", 6, "unknown protocol"},
+		{"ip proto 256", 9, "unknown protocol"},
+		{"gateway 10.0.0.1", 8, "'gateway' requires a name"},
+		{"ip[0:3] = 1", 5, "data size must be 1, 2, or 4"},
+		{"ip[0] / 0 = 1", 8, "division by zero"},
+		{"tcp[13] & 2 !=", 14, "syntax error"},
+		{"(tcp or udp", 11, "expected ')'"},
+		{"tcp or udp)", 10, "syntax error"},
+		{"greater", 7, "'greater' requires a length"},
+		{"port 80 @ 1", 8, "illegal character '@'"},
+		{"host", 4, "syntax error"},
+	}
+
+	for _, tc := range tests {
+		err := CompileCheck(tc.filter)
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) {
+			t.Errorf("CompileCheck(%q) = %v, want *CompileError", tc.filter, err)
+			continue
+		}
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("CompileCheck(%q) = %v, want ErrInvalidFilter", tc.filter, err)
+		}
+		if compileErr.Pos != tc.pos || !strings.Contains(compileErr.Msg, tc.msg) {
+			t.Errorf("CompileCheck(%q) = %v, want %q at position %d", tc.filter, err, tc.msg, tc.pos)
+		}
+	}
+}
+
+// TestCompileCheckLength verifies the limits on program size and nesting.
+func TestCompileCheckLength(t *testing.T) {
+	ports := func(n int) string {
+		primitives := make([]string, n)
+		for i := range primitives {
+			primitives[i] = "port " + strings.Repeat("1", 1+i%4)
+		}
+		return strings.Join(primitives, " or ")
+	}
+
+	if err := CompileCheck(ports(100)); err != nil {
+		t.Errorf("100 ports: %v", err)
+	}
+	if err := CompileCheck(ports(1000)); err == nil || !strings.Contains(err.Error(), "too complex") {
+		t.Errorf("1000 ports: %v, want too complex", err)
+	}
+
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "tcp" + strings.Repeat(")", n)
+	}
+	if err := CompileCheck(nested(MaxNesting - 1)); err != nil {
+		t.Errorf("nesting %d: %v", MaxNesting-1, err)
+	}
+	if err := CompileCheck(nested(MaxNesting + 1)); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("nesting %d: %v, want nested too deeply", MaxNesting+1, err)
+	}
+
+	if err := CompileCheck("("); err == nil {
+		t.Error("'(' compiled")
+	}
+}
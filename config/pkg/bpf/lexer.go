@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	tokenKind uint8
+
+	token struct {
+		kind tokenKind
+		text string
+		// byte offset of the token within the filter
+		pos int
+	}
+)
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenOperator
+)
+
+// operators are matched longest first
+var operators = []string{
+	"&&", "||", "!=", "==", "<=", ">=", "<<", ">>",
+	"(", ")", "[", "]", "!", "&", "|", "^", "=", "<", ">", "+", "-", "*", "/", "%", ":",
+}
+
+func isWordStart(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '.' || b == '\\'
+}
+
+// isWordByte allows hyphens, slashes, and colons within words, same as libpcap does for names such as `tcp-syn`,
+// CIDR networks, and IPv6 addresses; within brackets they are arithmetic operators and data sizes.
+func isWordByte(b byte, brackets int) bool {
+	return isWordStart(b) || brackets == 0 && (b == '-' || b == '/' || b == ':')
+}
+
+func lex(filter string) ([]token, error) {
+	tokens := []token{}
+	brackets := 0
+	for i := 0; i < len(filter); {
+		b := filter[i]
+		switch {
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			i++
+			continue
+
+		// IPv6 addresses may start with `::`
+		case isWordStart(b) || brackets == 0 && strings.HasPrefix(filter[i:], "::"):
+			start := i
+			for i < len(filter) && isWordByte(filter[i], brackets) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: filter[start:i], pos: start})
+			continue
+		}
+
+		matched := ""
+		for _, op := range operators {
+			if strings.HasPrefix(filter[i:], op) {
+				matched = op
+				break
+			}
+		}
+		if matched == "" {
+			return nil, &CompileError{Pos: i, Msg: fmt.Sprintf("illegal character '%c'", b)}
+		}
+		switch matched {
+		case "[":
+			brackets++
+		case "]":
+			brackets--
+		}
+		tokens = append(tokens, token{kind: tokenOperator, text: matched, pos: i})
+		i += len(matched)
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(filter)}), nil
+}
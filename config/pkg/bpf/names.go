@@ -0,0 +1,108 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// servicesFile is where libpcap resolves port names from.
+const servicesFile = "/etc/services"
+
+var (
+	// protocol qualifiers: `tcp port 80`, `ip6 host ::1`, ...
+	protocols = map[string]bool{
+		"ether": true, "ip": true, "ip6": true, "arp": true, "rarp": true,
+		"tcp": true, "udp": true, "sctp": true, "icmp": true, "icmp6": true, "igmp": true,
+	}
+
+	// protocols whose headers may be accessed using `proto[expr:size]`
+	accessProtocols = map[string]bool{
+		"ether": true, "link": true, "ppp": true, "slip": true, "ip": true, "ip6": true, "arp": true, "rarp": true,
+		"tcp": true, "udp": true, "sctp": true, "icmp": true, "icmp6": true, "igmp": true, "igrp": true, "pim": true, "vrrp": true, "carp": true,
+	}
+
+	kinds = map[string]bool{
+		"host": true, "net": true, "port": true, "portrange": true, "proto": true, "gateway": true,
+	}
+
+	reserved = map[string]bool{
+		"and": true, "or": true, "not": true, "src": true, "dst": true, "mask": true, "len": true,
+		"greater": true, "less": true, "broadcast": true, "multicast": true,
+		"inbound": true, "outbound": true, "vlan": true, "mpls": true,
+	}
+
+	// named constants accepted within arithmetic expressions
+	constants = map[string]bool{
+		"tcpflags": true, "icmptype": true, "icmpcode": true, "icmp6type": true, "icmp6code": true,
+		"tcp-fin": true, "tcp-syn": true, "tcp-rst": true, "tcp-push": true,
+		"tcp-ack": true, "tcp-urg": true, "tcp-ece": true, "tcp-cwr": true,
+		"icmp-echoreply": true, "icmp-unreach": true, "icmp-sourcequench": true, "icmp-redirect": true,
+		"icmp-echo": true, "icmp-routeradvert": true, "icmp-routersolicit": true, "icmp-timxceed": true,
+		"icmp-paramprob": true, "icmp-tstamp": true, "icmp-tstampreply": true, "icmp-ireq": true,
+		"icmp-ireqreply": true, "icmp-maskreq": true, "icmp-maskreply": true,
+	}
+
+	// names accepted by `proto`, in addition to protocol numbers
+	protocolNames = map[string]bool{
+		"ip": true, "ip6": true, "arp": true, "rarp": true, "tcp": true, "udp": true, "sctp": true,
+		"icmp": true, "icmp6": true, "igmp": true, "igrp": true, "pim": true, "vrrp": true, "carp": true,
+		"esp": true, "ah": true, "gre": true, "ipv6": true,
+	}
+
+	// well-known services, so that common port names are accepted even where `/etc/services` is missing
+	wellKnownServices = []string{
+		"ftp-data", "ftp", "ssh", "telnet", "smtp", "domain", "bootps", "bootpc", "tftp", "http", "www",
+		"pop3", "ntp", "imap", "snmp", "snmp-trap", "ldap", "https", "syslog", "submission", "ldaps",
+		"imaps", "pop3s", "mysql", "postgresql", "http-alt",
+	}
+
+	services = sync.OnceValue(loadServices)
+)
+
+func loadServices() map[string]bool {
+	names := make(map[string]bool, len(wellKnownServices))
+	for _, name := range wellKnownServices {
+		names[name] = true
+	}
+
+	f, err := os.Open(servicesFile)
+	if err != nil {
+		return names
+	}
+	defer f.Close()
+
+	// format: `name port/protocol [aliases...] [# comment]`
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		names[fields[0]] = true
+		for _, alias := range fields[2:] {
+			names[alias] = true
+		}
+	}
+	return names
+}
+
+func isKeyword(word string) bool {
+	return protocols[word] || kinds[word] || reserved[word]
+}
@@ -0,0 +1,585 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpf
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	qualifiers struct {
+		proto string
+		dir   string
+		kind  string
+	}
+
+	parser struct {
+		tokens []token
+		i      int
+		depth  int
+		// lower bound of the number of instructions of the compiled program
+		instructions int
+		// qualifiers of the previous primitive, applied to bare IDs: `host a or b`
+		last *qualifiers
+	}
+
+	checkpoint struct {
+		i            int
+		instructions int
+		last         *qualifiers
+	}
+)
+
+// estimated instructions per primitive, after libpcap's optimizer
+const (
+	costProtocol = 3
+	costHost     = 8
+	costNet      = 8
+	costPort     = 12
+	costRange    = 14
+	costProto    = 6
+	costGateway  = 10
+	costLength   = 2
+	costSpecial  = 4
+	costRelation = 6
+	costAccess   = 3
+	costOperand  = 1
+)
+
+var (
+	relationalOperators = map[string]bool{
+		">": true, "<": true, ">=": true, "<=": true, "=": true, "==": true, "!=": true,
+	}
+
+	arithmeticOperators = map[string]bool{
+		"+": true, "-": true, "*": true, "/": true, "%": true, "&": true, "|": true, "^": true, "<<": true, ">>": true,
+	}
+
+	hostnameRegex = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9])?(\.[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9])?)*\.?$`)
+)
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.i+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.i+n]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) is(text string) bool {
+	tok := p.peek()
+	return tok.kind != tokenEOF && tok.text == text
+}
+
+func (p *parser) isOperator(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokenOperator && tok.text == text
+}
+
+func (p *parser) save() checkpoint {
+	return checkpoint{p.i, p.instructions, p.last}
+}
+
+func (p *parser) restore(c checkpoint) {
+	p.i, p.instructions, p.last = c.i, c.instructions, c.last
+}
+
+func (p *parser) errorAt(tok token, msg string) *CompileError {
+	return &CompileError{Pos: tok.pos, Token: tok.text, Msg: msg}
+}
+
+func (p *parser) expect(text string) *CompileError {
+	if !p.isOperator(text) {
+		return p.errorAt(p.peek(), fmt.Sprintf("syntax error: expected '%s'", text))
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) enter() *CompileError {
+	p.depth++
+	if p.depth > MaxNesting {
+		return p.errorAt(p.peek(), "expression nested too deeply")
+	}
+	return nil
+}
+
+// expression := and { ( `or` | `||` ) and }
+func (p *parser) parseExpression() *CompileError {
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer func() { p.depth-- }()
+
+	if err := p.parseAnd(); err != nil {
+		return err
+	}
+	for p.is("or") || p.isOperator("||") {
+		p.next()
+		if err := p.parseAnd(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// and := unary { ( `and` | `&&` ) unary }
+func (p *parser) parseAnd() *CompileError {
+	if err := p.parseUnary(); err != nil {
+		return err
+	}
+	for p.is("and") || p.isOperator("&&") {
+		p.next()
+		if err := p.parseUnary(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unary := { `not` | `!` } ( relation | `(` expression `)` | primitive )
+func (p *parser) parseUnary() *CompileError {
+	for p.is("not") || p.isOperator("!") {
+		p.next()
+	}
+
+	// relations and primitives may both start with a word or a parenthesis:
+	// try the relation first, and report the error found furthest if neither parses.
+	saved := p.save()
+	relationErr := p.parseRelation()
+	if relationErr == nil {
+		return nil
+	}
+	p.restore(saved)
+
+	var err *CompileError
+	if p.isOperator("(") {
+		p.next()
+		if err = p.parseExpression(); err == nil {
+			err = p.expect(")")
+		}
+	} else {
+		err = p.parsePrimitive()
+	}
+	if err != nil && relationErr.Pos > err.Pos {
+		return relationErr
+	}
+	return err
+}
+
+// relation := arithmetic relop arithmetic
+func (p *parser) parseRelation() *CompileError {
+	if err := p.parseArithmetic(); err != nil {
+		return err
+	}
+	if tok := p.peek(); tok.kind != tokenOperator || !relationalOperators[tok.text] {
+		return p.errorAt(tok, "syntax error")
+	}
+	p.next()
+	if err := p.parseArithmetic(); err != nil {
+		return err
+	}
+	p.instructions += costRelation
+	return nil
+}
+
+// arithmetic := operand { arithop operand }
+func (p *parser) parseArithmetic() *CompileError {
+	if err := p.parseOperand(); err != nil {
+		return err
+	}
+	for tok := p.peek(); tok.kind == tokenOperator && arithmeticOperators[tok.text]; tok = p.peek() {
+		p.next()
+		if divisor := p.peek(); (tok.text == "/" || tok.text == "%") && divisor.kind == tokenWord {
+			if n, ok := parseNumber(divisor.text); ok && n == 0 {
+				return p.errorAt(divisor, "division by zero")
+			}
+		}
+		if err := p.parseOperand(); err != nil {
+			return err
+		}
+		p.instructions += costOperand
+	}
+	return nil
+}
+
+// operand := `-` operand | `(` arithmetic `)` | number | `len` | constant | proto `[` arithmetic [ `:` size ] `]`
+func (p *parser) parseOperand() *CompileError {
+	for p.isOperator("-") {
+		p.next()
+	}
+
+	tok := p.peek()
+	if tok.kind == tokenOperator && tok.text == "(" {
+		if err := p.enter(); err != nil {
+			return err
+		}
+		defer func() { p.depth-- }()
+		p.next()
+		if err := p.parseArithmetic(); err != nil {
+			return err
+		}
+		return p.expect(")")
+	}
+
+	if tok.kind != tokenWord {
+		return p.errorAt(tok, "syntax error")
+	}
+
+	if _, ok := parseNumber(tok.text); ok || tok.text == "len" || constants[tok.text] {
+		p.next()
+		p.instructions += costOperand
+		return nil
+	}
+
+	if !accessProtocols[tok.text] || p.peekAt(1).text != "[" {
+		return p.errorAt(tok, "syntax error")
+	}
+	p.next()
+	p.next()
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer func() { p.depth-- }()
+	if err := p.parseArithmetic(); err != nil {
+		return err
+	}
+	if p.isOperator(":") {
+		p.next()
+		size := p.next()
+		if n, ok := parseNumber(size.text); !ok || n != 1 && n != 2 && n != 4 {
+			return p.errorAt(size, "data size must be 1, 2, or 4")
+		}
+	}
+	if err := p.expect("]"); err != nil {
+		return err
+	}
+	p.instructions += costAccess
+	return nil
+}
+
+// primitive := [ proto ] [ dir ] [ kind ] id | keyword primitives such as `greater 64`
+func (p *parser) parsePrimitive() *CompileError {
+	start := p.peek()
+	if start.kind != tokenWord {
+		return p.errorAt(start, "syntax error")
+	}
+
+	q := qualifiers{}
+	if protocols[start.text] {
+		q.proto = p.next().text
+	}
+	if p.is("src") || p.is("dst") {
+		q.dir = p.next().text
+		// `src or dst` and `src and dst` are directions only when followed by the other one
+		if other := p.peekAt(1); (p.is("or") || p.is("and")) && other.kind == tokenWord && (other.text == "src" || other.text == "dst") && other.text != q.dir {
+			q.dir = q.dir + " " + p.next().text + " " + p.next().text
+		}
+	}
+	if tok := p.peek(); tok.kind == tokenWord && kinds[tok.text] {
+		q.kind = p.next().text
+	}
+
+	if q.dir == "" && q.kind == "" {
+		if handled, err := p.parseKeywordPrimitive(q); handled {
+			return err
+		}
+	}
+
+	id := p.peek()
+	if id.kind != tokenWord || isKeyword(id.text) && !(q.kind == "proto" && protocolNames[id.text]) {
+		// a protocol on its own, e.g. `tcp`, unless its header is being accessed
+		if q.proto != "" && q.dir == "" && q.kind == "" && id.text != "[" {
+			p.instructions += costProtocol
+			p.last = nil
+			return nil
+		}
+		return p.errorAt(id, "syntax error")
+	}
+
+	if q == (qualifiers{}) && p.last != nil {
+		q = *p.last
+	}
+	if q.kind == "" {
+		q.kind = "host"
+	}
+	p.next()
+	if err := p.checkID(start, q, id); err != nil {
+		return err
+	}
+	p.last = &q
+	return nil
+}
+
+// parseKeywordPrimitive handles primitives which are keywords on their own, optionally after a protocol.
+func (p *parser) parseKeywordPrimitive(q qualifiers) (bool, *CompileError) {
+	tok := p.peek()
+	if tok.kind != tokenWord {
+		return false, nil
+	}
+
+	switch tok.text {
+	case "greater", "less":
+		if q.proto != "" {
+			return true, p.errorAt(tok, "syntax error")
+		}
+		p.next()
+		if n := p.next(); !isNumber(n.text) {
+			return true, p.errorAt(n, fmt.Sprintf("'%s' requires a length", tok.text))
+		}
+		p.instructions += costLength
+
+	case "broadcast", "multicast":
+		if q.proto != "" && q.proto != "ether" && q.proto != "ip" && q.proto != "ip6" {
+			return true, p.errorAt(tok, fmt.Sprintf("'%s' modifier applied to %s", q.proto, tok.text))
+		}
+		p.next()
+		p.instructions += costSpecial
+
+	case "inbound", "outbound":
+		if q.proto != "" {
+			return true, p.errorAt(tok, "syntax error")
+		}
+		p.next()
+		p.instructions += costLength
+
+	case "vlan", "mpls":
+		if q.proto != "" {
+			return true, p.errorAt(tok, "syntax error")
+		}
+		p.next()
+		if n := p.peek(); n.kind == tokenWord && isNumber(n.text) {
+			p.next()
+		}
+		p.instructions += costSpecial
+
+	default:
+		return false, nil
+	}
+
+	p.last = nil
+	return true, nil
+}
+
+// checkID verifies that `id` is valid for qualifiers `q`, using libpcap's error messages.
+func (p *parser) checkID(start token, q qualifiers, id token) *CompileError {
+	switch q.kind {
+	case "host":
+		switch q.proto {
+		case "tcp", "udp", "sctp", "icmp", "icmp6", "igmp":
+			return p.errorAt(start, fmt.Sprintf("'%s' modifier applied to host", q.proto))
+		case "ether":
+			if mac, err := net.ParseMAC(id.text); err != nil || len(mac) != 6 {
+				return p.errorAt(id, "bad ethernet address")
+			}
+			p.instructions += costHost
+			return nil
+		}
+		if ip := net.ParseIP(id.text); ip != nil {
+			if ip.To4() != nil && q.proto == "ip6" {
+				return p.errorAt(start, "'ip6' modifier applied to ip host")
+			}
+			if ip.To4() == nil && q.proto != "" && q.proto != "ip6" {
+				return p.errorAt(start, fmt.Sprintf("'%s' modifier applied to ip6 host", q.proto))
+			}
+		} else if !isHostname(id.text) {
+			return p.errorAt(id, "invalid host")
+		}
+		p.instructions += costHost
+
+	case "net":
+		if q.proto != "" && q.proto != "ip" && q.proto != "ip6" && q.proto != "arp" && q.proto != "rarp" {
+			return p.errorAt(start, fmt.Sprintf("'%s' modifier applied to net", q.proto))
+		}
+		if err := p.checkNet(id); err != nil {
+			return err
+		}
+		p.instructions += costNet
+
+	case "port", "portrange":
+		if q.proto != "" && q.proto != "tcp" && q.proto != "udp" && q.proto != "sctp" {
+			return p.errorAt(start, fmt.Sprintf("illegal qualifier of '%s'", q.kind))
+		}
+		ports := []string{id.text}
+		if q.kind == "portrange" {
+			from, to, ok := strings.Cut(id.text, "-")
+			if !ok {
+				return p.errorAt(id, "illegal port range")
+			}
+			ports = []string{from, to}
+			p.instructions += costRange
+		} else {
+			p.instructions += costPort
+		}
+		for _, port := range ports {
+			if err := checkPort(port); err != "" {
+				return p.errorAt(id, err)
+			}
+		}
+
+	case "proto":
+		if q.proto != "" && q.proto != "ether" && q.proto != "ip" && q.proto != "ip6" {
+			return p.errorAt(start, "illegal qualifier of 'proto'")
+		}
+		name := strings.TrimPrefix(id.text, "\\")
+		if n, ok := parseNumber(name); ok && n > 255 && q.proto != "ether" || !ok && !protocolNames[name] {
+			return p.errorAt(id, fmt.Sprintf("unknown protocol: %s", name))
+		}
+		p.instructions += costProto
+
+	case "gateway":
+		if q.proto != "" && q.proto != "ip" {
+			return p.errorAt(start, "illegal qualifier of 'gateway'")
+		}
+		if net.ParseIP(id.text) != nil || !isHostname(id.text) {
+			return p.errorAt(id, "'gateway' requires a name")
+		}
+		p.instructions += costGateway
+	}
+	return nil
+}
+
+// checkNet accepts CIDR notation, possibly abbreviated (`10/8`), and the `mask` form.
+func (p *parser) checkNet(id token) *CompileError {
+	address, length, hasLength := strings.Cut(id.text, "/")
+
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		bits := 128
+		if hasLength {
+			n, err := strconv.Atoi(length)
+			if err != nil || n < 0 || n > 128 {
+				return p.errorAt(id, "mask length must be <= 128")
+			}
+			bits = n
+		}
+		if !ip.Mask(net.CIDRMask(bits, 128)).Equal(ip) {
+			return p.errorAt(id, fmt.Sprintf("non-network bits set in \"%s\"", id.text))
+		}
+		return nil
+	}
+
+	addr, octets, ok := parseIPv4(address)
+	if !ok {
+		return p.errorAt(id, "invalid net")
+	}
+	bits := 8 * octets
+	if hasLength {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > 32 {
+			return p.errorAt(id, "mask length must be <= 32")
+		}
+		bits = n
+	} else if p.is("mask") {
+		p.next()
+		maskToken := p.next()
+		mask, maskOctets, ok := parseIPv4(maskToken.text)
+		if !ok || maskOctets != 4 {
+			return p.errorAt(maskToken, "invalid mask")
+		}
+		if addr&^mask != 0 {
+			return p.errorAt(id, fmt.Sprintf("non-network bits set in \"%s mask %s\"", id.text, maskToken.text))
+		}
+		return nil
+	}
+	if mask := ^uint32(0) << (32 - bits); bits < 32 && addr&^mask != 0 {
+		return p.errorAt(id, fmt.Sprintf("non-network bits set in \"%s\"", id.text))
+	}
+	return nil
+}
+
+func checkPort(port string) string {
+	if n, ok := parseNumber(port); ok {
+		if n > 65535 {
+			return fmt.Sprintf("illegal port number %d > 65535", n)
+		}
+		return ""
+	}
+	if !services()[port] {
+		return fmt.Sprintf("unknown port: %s", port)
+	}
+	return ""
+}
+
+// parseIPv4 parses possibly abbreviated dotted addresses, left aligned: `10.1` is `10.1.0.0`.
+func parseIPv4(s string) (uint32, int, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return 0, 0, false
+	}
+	var addr uint32
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return 0, 0, false
+		}
+		addr = addr<<8 | uint32(n)
+	}
+	return addr << (8 * (4 - len(parts))), len(parts), true
+}
+
+// parseNumber accepts decimal, octal (`017`), and hexadecimal (`0x1f`) numbers, same as libpcap.
+func parseNumber(s string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		s, base = s[2:], 16
+	case len(s) > 1 && s[0] == '0':
+		s, base = s[1:], 8
+	}
+	if s == "" || strings.ContainsAny(s, "_+-") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, base, 32)
+	return n, err == nil
+}
+
+func isNumber(s string) bool {
+	_, ok := parseNumber(s)
+	return ok
+}
+
+// isHostname only checks syntax; names made only of numbers must be IPv4 addresses.
+func isHostname(s string) bool {
+	if !hostnameRegex.MatchString(s) {
+		return false
+	}
+	numeric := true
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if _, err := strconv.ParseUint(label, 10, 64); err != nil {
+			numeric = false
+			break
+		}
+	}
+	if numeric {
+		_, _, ok := parseIPv4(s)
+		return ok
+	}
+	return true
+}
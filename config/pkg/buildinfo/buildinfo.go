@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package buildinfo reports the version of every PCAP sidecar binary using the same scheme.
+//
+// Version, commit, and build date are set at build time:
+//   - `-ldflags "-X github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/buildinfo.Version=${VERSION}"`
+//
+// when not set, they are sourced from the VCS information embedded by the Go toolchain.
+package buildinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go"`
+	Module    string `json:"module"`
+}
+
+const unknown = "unknown"
+
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+
+	readBuildInfo = debug.ReadBuildInfo
+)
+
+func orDefault(value, defaultValue string) string {
+	if value = strings.TrimSpace(value); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+
+	if buildInfo, ok := readBuildInfo(); ok {
+		info.Module = buildInfo.Main.Path
+		if info.Version == "" && buildInfo.Main.Version != "(devel)" {
+			info.Version = buildInfo.Main.Version
+		}
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = orDefault(info.Commit, setting.Value)
+			case "vcs.time":
+				info.Date = orDefault(info.Date, setting.Value)
+			}
+		}
+	}
+
+	info.Version = orDefault(info.Version, "dev")
+	info.Commit = orDefault(info.Commit, unknown)
+	info.Date = orDefault(info.Date, unknown)
+	info.Module = orDefault(info.Module, unknown)
+	return info
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s %s (commit: %s, built: %s, %s)", i.Module, i.Version, i.Commit, i.Date, i.GoVersion)
+}
+
+// Map returns the build information as structured log data.
+func (i Info) Map() map[string]any {
+	return map[string]any{
+		"version": i.Version,
+		"commit":  i.Commit,
+		"date":    i.Date,
+		"go":      i.GoVersion,
+		"module":  i.Module,
+	}
+}
+
+// Print writes the build information in human readable form, or as JSON.
+func (i Info) Print(
+	w io.Writer,
+	asJSON bool,
+) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(i)
+	}
+	_, err := fmt.Fprintln(w, i.String())
+	return err
+}
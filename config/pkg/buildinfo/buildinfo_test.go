@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buildinfo
+
+import (
+	"encoding/json"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func fakeBuildInfo(info *debug.BuildInfo, ok bool) func() {
+	original := readBuildInfo
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return info, ok
+	}
+	return func() {
+		readBuildInfo = original
+	}
+}
+
+// TestGet verifies that link-time values take precedence over the embedded VCS information.
+func TestGet(t *testing.T) {
+	defer fakeBuildInfo(&debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify", Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123abc"},
+			{Key: "vcs.time", Value: "2024-01-01T00:00:00Z"},
+		},
+	}, true)()
+
+	info := Get()
+	if info.Version != "dev" || info.Commit != "0123abc" || info.Date != "2024-01-01T00:00:00Z" {
+		t.Errorf("Get() = %+v, want VCS information", info)
+	}
+	if info.Module != "github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify" || info.GoVersion != runtime.Version() {
+		t.Errorf("Get() = %+v, want module and Go version", info)
+	}
+
+	defer func() { Version, Commit = "", "" }()
+	Version, Commit = "1.2.3", "fedcba9"
+	if info := Get(); info.Version != "1.2.3" || info.Commit != "fedcba9" {
+		t.Errorf("Get() = %+v, want link-time values", info)
+	}
+}
+
+// TestPrint verifies the human readable and JSON version formats.
+func TestPrint(t *testing.T) {
+	defer fakeBuildInfo(nil, false)()
+
+	info := Get()
+	if info.Module != unknown || info.Commit != unknown || info.Date != unknown {
+		t.Errorf("Get() without build info = %+v, want unknown values", info)
+	}
+
+	var human strings.Builder
+	if err := info.Print(&human, false); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+	want := "unknown dev (commit: unknown, built: unknown, " + runtime.Version() + ")\n"
+	if human.String() != want {
+		t.Errorf("Print() = %q, want %q", human.String(), want)
+	}
+
+	var asJSON strings.Builder
+	if err := info.Print(&asJSON, true); err != nil {
+		t.Fatalf("Print(JSON) failed: %v", err)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal([]byte(asJSON.String()), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for _, key := range []string{"version", "commit", "date", "go", "module"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("JSON version is missing %q: %s", key, asJSON.String())
+		}
+	}
+}
@@ -26,6 +26,10 @@ import (
 type (
 	PcapVerbosity string
 
+	ConfigReport = config.ConfigReport
+	ReportEntry  = config.ReportEntry
+	KeysMode     = config.KeysMode
+
 	PcapConfig struct {
 		Debug     bool
 		Verbosity PcapVerbosity
@@ -37,17 +41,65 @@ const (
 	PCAP_VERBOSITY_DEBUG = PcapVerbosity("DEBUG")
 )
 
-func LoadJSON(
+const (
+	KEYS_LENIENT = config.KEYS_LENIENT
+	KEYS_WARN    = config.KEYS_WARN
+	KEYS_STRICT  = config.KEYS_STRICT
+)
+
+// REDACTED_VALUE replaces the values of sensitive keys in logged and exported configs.
+const REDACTED_VALUE = config.REDACTED_VALUE
+
+// ParseKeysMode returns the KeysMode named `mode`: one of `lenient`, `warn`, or `strict`.
+func ParseKeysMode(
+	mode string,
+) (KeysMode, error) {
+	return config.ParseKeysMode(mode)
+}
+
+// LoadJSONWithReport loads the config file, and reports how every config key was resolved;
+// `configFile` may also be a `gs://` URI.
+func LoadJSONWithReport(
 	ctx context.Context,
 	configFile string,
-) (context.Context, error) {
+) (context.Context, *ConfigReport, error) {
+	return LoadJSONWithKeysMode(ctx, configFile, KEYS_LENIENT)
+}
+
+// LoadJSONWithKeysMode is LoadJSONWithReport which also reports the keys in the config file
+// which are never read: as warnings with KEYS_WARN, or as fatal with KEYS_STRICT.
+func LoadJSONWithKeysMode(
+	ctx context.Context,
+	configFile string,
+	mode KeysMode,
+) (context.Context, *ConfigReport, error) {
+	if IsGCSURI(configFile) {
+		return loadFromGCS(ctx, configFile, mode)
+	}
 	k := koanf.New(".")
 	if err := k.Load(
 		file.Provider(configFile),
 		json.Parser(),
-	); err == nil {
-		return config.LoadContext(ctx, k), nil
-	} else {
-		return ctx, err
+	); err != nil {
+		return ctx, nil, err
 	}
+	ctx, report := config.LoadContextWithKeysMode(ctx, k, mode)
+	return ctx, report, nil
+}
+
+// LoadJSON loads the config file; keys which cannot be resolved are reported as unavailable by the getters.
+func LoadJSON(
+	ctx context.Context,
+	configFile string,
+) (context.Context, error) {
+	ctx, _, err := LoadJSONWithReport(ctx, configFile)
+	return ctx, err
+}
+
+// RedactJSON replaces the values of all sensitive keys in the JSON config `content`;
+// getters still return their actual values.
+func RedactJSON(
+	content []byte,
+) ([]byte, error) {
+	return config.RedactJSON(content)
 }
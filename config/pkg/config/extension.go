@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	sf "github.com/wissance/stringFormatter"
+)
+
+var InvalidExtensionError = errors.New("invalid PCAP files extension")
+
+// extensions are used to build file names and regular expressions:
+// they must not contain dots, path separators nor regexp meta-characters.
+var extensionRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+func newInvalidExtensionError(
+	extension string,
+) error {
+	return errors.Join(
+		InvalidExtensionError,
+		errors.New(sf.Format("extension => '{0}'", extension)),
+	)
+}
+
+// ParseExtensions splits a comma-separated list of PCAP files extensions, and validates each one.
+func ParseExtensions(
+	value string,
+) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, newInvalidExtensionError(value)
+	}
+
+	extensions := strings.Split(value, ",")
+	for i, extension := range extensions {
+		extension = strings.TrimSpace(extension)
+		if !extensionRegex.MatchString(extension) {
+			return nil, newInvalidExtensionError(extension)
+		}
+		extensions[i] = extension
+	}
+	return extensions, nil
+}
+
+// UnwatchedExtensions returns the `captured` extensions which are not `watched`;
+// PCAP files written using any of them would never be exported.
+func UnwatchedExtensions(
+	captured, watched []string,
+) []string {
+	unwatched := []string{}
+	for _, extension := range captured {
+		if !slices.Contains(watched, extension) && !slices.Contains(unwatched, extension) {
+			unwatched = append(unwatched, extension)
+		}
+	}
+	return unwatched
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// TestGetExtension verifies that extensions are split and validated.
+func TestGetExtension(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr error
+	}{
+		{"single", "pcap", []string{"pcap"}, nil},
+		{"multiple", "pcap, pcapng", []string{"pcap", "pcapng"}, nil},
+		{"dotted", ".pcap", nil, InvalidExtensionError},
+		{"empty", "", nil, InvalidExtensionError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKey(c.ExtensionKey), tc.value)
+			got, err := GetExtension(ctx)
+			if !errors.Is(err, tc.wantErr) || !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, %v, want %v, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := GetExtension(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+}
+
+// TestUnwatchedExtensions verifies that captured extensions missing from the watched ones are detected.
+func TestUnwatchedExtensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		captured []string
+		watched  []string
+		want     []string
+	}{
+		{"same", []string{"pcap"}, []string{"pcap"}, []string{}},
+		{"watched superset", []string{"pcap"}, []string{"pcap", "json"}, []string{}},
+		{"pcapng captured, pcap watched", []string{"pcapng"}, []string{"pcap"}, []string{"pcapng"}},
+		{"partially watched", []string{"pcap", "pcapng", "pcapng"}, []string{"pcap"}, []string{"pcapng"}},
+		{"nothing watched", []string{"pcap"}, nil, []string{"pcap"}},
+		{"case sensitive", []string{"PCAP"}, []string{"pcap"}, []string{"PCAP"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := UnwatchedExtensions(tc.captured, tc.watched); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+	sf "github.com/wissance/stringFormatter"
+)
+
+// features may be overridden by `PCAP_FEATURE_<NAME>` env vars; i.e.: `PCAP_FEATURE_GZIP=false`
+
+func IsGzipEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.GzipKey)
+}
+
+func IsTcpdumpEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.TcpdumpKey)
+}
+
+func IsJsondumpEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.JsondumpKey)
+}
+
+// IsJsonlogEnabled reports whether packet translations are also written to standard output.
+func IsJsonlogEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.JsonlogKey)
+}
+
+func IsOrderedEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.OrderedKey)
+}
+
+// IsConntrackEnabled reports whether connections are tracked; it implies `ordered`.
+func IsConntrackEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.ConntrackKey)
+}
+
+// IsCronEnabled reports whether `tcpdump` executions are scheduled using the cron expression.
+func IsCronEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.CronKey)
+}
+
+// CronSchedule produces the activation times of a cron expression.
+type CronSchedule interface {
+	Next(time.Time) time.Time
+}
+
+// ParseCronExpression parses a standard cron expression: 5 fields, or descriptors such as `@hourly`.
+func ParseCronExpression(
+	expression string,
+) (CronSchedule, error) {
+	schedule, err := c.ParseCronExpression(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cron expression '%s': %v", InvalidConfigError, expression, err)
+	}
+	return schedule, nil
+}
+
+// GetCronExpression returns the standard cron expression used to schedule `tcpdump` executions;
+// it is unavailable when it failed validation at load time.
+func GetCronExpression(
+	ctx context.Context,
+) (string, error) {
+	expression, err := getString(ctx, c.CronExpressionKey)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ParseCronExpression(expression); err != nil {
+		return "", err
+	}
+	return expression, nil
+}
+
+// GetCaptureTimeout returns how long every `tcpdump` execution captures packets; `0` means that executions are not stopped.
+func GetCaptureTimeout(
+	ctx context.Context,
+) (time.Duration, error) {
+	secs, err := getInteger(ctx, c.TimeoutKey)
+	if err != nil {
+		return 0, err
+	}
+	if secs < 0 {
+		return 0, fmt.Errorf("%w: timeout must not be negative: %d", InvalidConfigError, secs)
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// Features are the capture features enabled by the config, including the ones implied by other features.
+type Features map[string]bool
+
+// GetFeatures returns all capture features; unavailable features are disabled.
+func GetFeatures(
+	ctx context.Context,
+) Features {
+	features := Features{
+		"gzip":      getBooleanOrDefault(ctx, c.GzipKey, false),
+		"tcpdump":   getBooleanOrDefault(ctx, c.TcpdumpKey, false),
+		"jsondump":  getBooleanOrDefault(ctx, c.JsondumpKey, false),
+		"jsonlog":   getBooleanOrDefault(ctx, c.JsonlogKey, false),
+		"ordered":   getBooleanOrDefault(ctx, c.OrderedKey, false),
+		"conntrack": getBooleanOrDefault(ctx, c.ConntrackKey, false),
+	}
+	// connections tracking requires packets to be translated in order
+	if features["conntrack"] {
+		features["ordered"] = true
+	}
+	return features
+}
+
+func (f Features) String() string {
+	features := make([]string, 0, len(f))
+	for feature, enabled := range f {
+		features = append(features, sf.Format("{0}={1}", feature, enabled))
+	}
+	slices.Sort(features)
+	return strings.Join(features, ",")
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+func withFeature(
+	ctx context.Context,
+	key c.CtxKey,
+	enabled bool,
+) context.Context {
+	return context.WithValue(ctx, contextKey(key), enabled)
+}
+
+// TestIsConntrackEnabled verifies the conntrack getter for enabled, disabled, and unavailable values.
+func TestIsConntrackEnabled(t *testing.T) {
+	if _, err := IsConntrackEnabled(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+	for _, want := range []bool{true, false} {
+		ctx := withFeature(context.Background(), c.ConntrackKey, want)
+		if got, err := IsConntrackEnabled(ctx); err != nil || got != want {
+			t.Errorf("got %v, %v, want %v", got, err, want)
+		}
+	}
+}
+
+// TestIsJsonEnabled verifies the JSON dump and JSON log getters, which are independent of each other.
+func TestIsJsonEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		key  c.CtxKey
+		get  func(context.Context) (bool, error)
+	}{
+		{"jsondump", c.JsondumpKey, IsJsondumpEnabled},
+		{"jsonlog", c.JsonlogKey, IsJsonlogEnabled},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.get(context.Background()); err != UnavailableConfigError {
+				t.Errorf("got %v, want %v", err, UnavailableConfigError)
+			}
+			for _, want := range []bool{true, false} {
+				ctx := withFeature(context.Background(), tc.key, want)
+				if got, err := tc.get(ctx); err != nil || got != want {
+					t.Errorf("got %v, %v, want %v", got, err, want)
+				}
+			}
+		})
+	}
+
+	ctx := withFeature(context.Background(), c.JsonlogKey, true)
+	if features := GetFeatures(ctx); !features["jsonlog"] || features["jsondump"] {
+		t.Errorf("features = %s", features)
+	}
+}
+
+// TestGetFeatures verifies that features are mapped by name, and that conntrack implies ordered.
+func TestGetFeatures(t *testing.T) {
+	tests := []struct {
+		name      string
+		conntrack bool
+		ordered   bool
+		want      string
+	}{
+		{"none", false, false, "conntrack=false,gzip=true,jsondump=false,jsonlog=false,ordered=false,tcpdump=false"},
+		{"ordered", false, true, "conntrack=false,gzip=true,jsondump=false,jsonlog=false,ordered=true,tcpdump=false"},
+		{"conntrack", true, false, "conntrack=true,gzip=true,jsondump=false,jsonlog=false,ordered=true,tcpdump=false"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := withFeature(context.Background(), c.GzipKey, true)
+			ctx = withFeature(ctx, c.ConntrackKey, tc.conntrack)
+			ctx = withFeature(ctx, c.OrderedKey, tc.ordered)
+			if got := GetFeatures(ctx).String(); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestGetCronExpression verifies that only valid standard cron expressions are returned.
+func TestGetCronExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    error
+	}{
+		{"fields", "0 */6 * * *", nil},
+		{"descriptor", "@daily", nil},
+		{"seconds", "0 0 */6 * * *", InvalidConfigError},
+		{"malformed", "* * *", InvalidConfigError},
+		{"invalid value", "0 25 * * *", InvalidConfigError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKey(c.CronExpressionKey), tc.expression)
+			got, err := GetCronExpression(ctx)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && got != tc.expression {
+				t.Errorf("got %s, want %s", got, tc.expression)
+			}
+		})
+	}
+
+	if _, err := GetCronExpression(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+}
+
+// TestGetCaptureTimeout verifies that timeouts are seconds, and that negative values are rejected.
+func TestGetCaptureTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		secs    int
+		want    time.Duration
+		wantErr error
+	}{
+		{"disabled", 0, 0, nil},
+		{"seconds", 300, 5 * time.Minute, nil},
+		{"negative", -1, 0, InvalidConfigError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKey(c.TimeoutKey), tc.secs)
+			got, err := GetCaptureTimeout(ctx)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("got %v, %v, want %v, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := GetCaptureTimeout(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+	jsonParser "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/v2"
+)
+
+const (
+	gcsScheme = "gs://"
+
+	gcsMaxAttempts  = 5
+	gcsRetryBackoff = 250 * time.Millisecond
+)
+
+var (
+	// both are variables so that tests can use a fake storage server
+	gcsEndpoint = "https://storage.googleapis.com"
+	tokenURL    = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	gcsClient = &http.Client{Timeout: 30 * time.Second}
+
+	errInvalidGCSURI = errors.New("invalid GCS URI")
+)
+
+// gcsStatusError is a failed GCS request; it is transient when it is worth retrying.
+type gcsStatusError struct {
+	status int
+}
+
+func (e *gcsStatusError) Error() string {
+	return fmt.Sprintf("GCS request failed: %d %s", e.status, http.StatusText(e.status))
+}
+
+func (e *gcsStatusError) transient() bool {
+	return e.status == http.StatusTooManyRequests || e.status == http.StatusRequestTimeout || e.status >= 500
+}
+
+// bytesProvider feeds an already fetched config file to koanf.
+type bytesProvider []byte
+
+func (b bytesProvider) ReadBytes() ([]byte, error) {
+	return b, nil
+}
+
+func (b bytesProvider) Read() (map[string]any, error) {
+	return nil, errors.New("bytesProvider does not support Read()")
+}
+
+// IsGCSURI returns whether `path` is a `gs://bucket/object` URI rather than a local path.
+func IsGCSURI(
+	path string,
+) bool {
+	return strings.HasPrefix(path, gcsScheme)
+}
+
+func parseGCSURI(
+	gcsURI string,
+) (bucket, object string, err error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(gcsURI, gcsScheme), "/")
+	if !IsGCSURI(gcsURI) || !ok || bucket == "" || object == "" {
+		return "", "", fmt.Errorf("%w: %q", errInvalidGCSURI, gcsURI)
+	}
+	return bucket, object, nil
+}
+
+// newAccessToken returns an access token for the default service account from the metadata server.
+func newAccessToken(
+	ctx context.Context,
+) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := gcsClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", &gcsStatusError{status: res.StatusCode}
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func fetchGCSObject(
+	ctx context.Context,
+	bucket, object string,
+) ([]byte, error) {
+	token, err := newAccessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+	objectURL := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media",
+		gcsEndpoint, url.PathEscape(bucket), url.PathEscape(object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := gcsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &gcsStatusError{status: res.StatusCode}
+	}
+	return io.ReadAll(res.Body)
+}
+
+// FetchFromGCS downloads the config file at `gcsURI` using the GCS JSON API, so that it can be read without a GCS Fuse mount.
+// Transient failures are retried with exponential backoff; other failures, i.e. `404`, are not.
+func FetchFromGCS(
+	ctx context.Context,
+	gcsURI string,
+) ([]byte, error) {
+	bucket, object, err := parseGCSURI(gcsURI)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := gcsRetryBackoff
+	for attempt := 1; ; attempt++ {
+		content, err := fetchGCSObject(ctx, bucket, object)
+		if err == nil {
+			return content, nil
+		}
+		var statusErr *gcsStatusError
+		if (errors.As(err, &statusErr) && !statusErr.transient()) || attempt == gcsMaxAttempts {
+			return nil, fmt.Errorf("failed to fetch %s after %d attempts: %w", gcsURI, attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// LoadFromGCS loads the config file stored at `gcsURI`, and reports how every config key was resolved.
+func LoadFromGCS(
+	ctx context.Context,
+	gcsURI string,
+) (context.Context, *ConfigReport, error) {
+	return loadFromGCS(ctx, gcsURI, KEYS_LENIENT)
+}
+
+func loadFromGCS(
+	ctx context.Context,
+	gcsURI string,
+	mode KeysMode,
+) (context.Context, *ConfigReport, error) {
+	content, err := FetchFromGCS(ctx, gcsURI)
+	if err != nil {
+		return ctx, nil, err
+	}
+	k := koanf.New(".")
+	if err := k.Load(bytesProvider(content), jsonParser.Parser()); err != nil {
+		return ctx, nil, err
+	}
+	ctx, report := config.LoadContextWithKeysMode(ctx, k, mode)
+	return ctx, report, nil
+}
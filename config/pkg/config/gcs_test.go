@@ -0,0 +1,105 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newFakeStorage serves `content` as the object `configs/pcap.json` of the bucket `bucket`,
+// after failing the first `failures` requests with `503`; it returns how many objects were requested.
+func newFakeStorage(
+	t *testing.T,
+	content string,
+	failures int64,
+) *atomic.Int64 {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(`{"access_token":"t0k3n"}`))
+		case strings.HasPrefix(r.URL.Path, "/storage/"):
+			n := requests.Add(1)
+			if r.Header.Get("Authorization") != "Bearer t0k3n" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.EscapedPath() != "/storage/v1/b/bucket/o/configs%2Fpcap.json" || r.URL.Query().Get("alt") != "media" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if n <= failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(content))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	endpoint, token := gcsEndpoint, tokenURL
+	gcsEndpoint, tokenURL = server.URL, server.URL+"/token"
+	t.Cleanup(func() {
+		gcsEndpoint, tokenURL = endpoint, token
+	})
+	return &requests
+}
+
+// TestLoadFromGCS verifies that the config file is loaded from a GCS object, and that transient failures are retried.
+func TestLoadFromGCS(t *testing.T) {
+	requests := newFakeStorage(t, `{"pcap":{"env":{"instance":{"id":"instance"}},"snaplen":96}}`, 2)
+
+	ctx, report, err := LoadJSONWithReport(context.Background(), "gs://bucket/configs/pcap.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.HasFatal() {
+		t.Errorf("report has fatal entries:\n%s", report)
+	}
+	if snaplen, err := GetSnaplen(ctx); err != nil || snaplen != 96 {
+		t.Errorf("snaplen = %d, %v, want 96", snaplen, err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+// TestFetchFromGCSErrors verifies that invalid URIs and missing objects fail without being retried.
+func TestFetchFromGCSErrors(t *testing.T) {
+	requests := newFakeStorage(t, `{}`, 0)
+
+	for _, uri := range []string{"gs://bucket", "gs:///pcap.json", "/pcap.json"} {
+		if _, err := FetchFromGCS(context.Background(), uri); err == nil {
+			t.Errorf("FetchFromGCS(%s) succeeded", uri)
+		}
+	}
+	if _, err := FetchFromGCS(context.Background(), "gs://bucket/missing.json"); err == nil {
+		t.Error("missing object was fetched")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
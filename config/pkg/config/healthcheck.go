@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// HealthcheckConfig describes which checks the healthcheck server runs, and their thresholds;
+// zero values disable the corresponding check.
+type HealthcheckConfig struct {
+	Port          uint16
+	Directory     string
+	CaptureAddr   string
+	ExporterAddr  string
+	ConfigAddr    string
+	RequireExport bool
+	MaxBacklog    int
+	MinFreeMiB    int
+}
+
+func getInteger(
+	ctx context.Context,
+	key c.CtxKey,
+) (int, error) {
+	k := contextKey(key)
+	value := ctx.Value(k)
+
+	if v, ok := value.(int); ok {
+		return v, nil
+	}
+
+	return 0, UnavailableConfigError
+}
+
+func getStringOrDefault(
+	ctx context.Context,
+	key c.CtxKey,
+	defaultValue string,
+) string {
+	if value, err := getString(ctx, key); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getIntegerOrDefault(
+	ctx context.Context,
+	key c.CtxKey,
+	defaultValue int,
+) int {
+	if value, err := getInteger(ctx, key); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// GetHealthcheckPort returns the TCP port where startup probes are accepted; `0` and ports above `65535` are invalid.
+func GetHealthcheckPort(
+	ctx context.Context,
+) (uint16, error) {
+	port, err := getInteger(ctx, c.HealthcheckKey)
+	if err != nil {
+		return 0, err
+	}
+	if port <= 0 || port > math.MaxUint16 {
+		return 0, fmt.Errorf("%w: healthcheck port must be between 1 and %d: %d", InvalidConfigError, math.MaxUint16, port)
+	}
+	return uint16(port), nil
+}
+
+// GetHealthcheckConfig returns the healthcheck configuration; only the port is mandatory.
+func GetHealthcheckConfig(
+	ctx context.Context,
+) (*HealthcheckConfig, error) {
+	port, err := GetHealthcheckPort(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &HealthcheckConfig{
+		Port:          port,
+		Directory:     getStringOrDefault(ctx, c.DirectoryKey, ""),
+		CaptureAddr:   getStringOrDefault(ctx, c.HcCaptureKey, ""),
+		ExporterAddr:  getStringOrDefault(ctx, c.HcExporterKey, ""),
+		ConfigAddr:    getStringOrDefault(ctx, c.HcConfigKey, ""),
+		RequireExport: getBooleanOrDefault(ctx, c.HcExportKey, false),
+		MaxBacklog:    getIntegerOrDefault(ctx, c.HcBacklogKey, 0),
+		MinFreeMiB:    getIntegerOrDefault(ctx, c.HcDiskKey, 0),
+	}, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// placeholder used by the config file when the instance ID is not known
+const unknownInstanceID = "unknown"
+
+// getIdentity returns the value of a deployment identity key; empty values are not set.
+func getIdentity(
+	ctx context.Context,
+	key c.CtxKey,
+) (string, error) {
+	value, err := getString(ctx, key)
+	if err != nil || value == "" {
+		return "", UnavailableConfigError
+	}
+	return value, nil
+}
+
+// GetProjectID returns the ID of the project where the PCAP sidecar is deployed.
+func GetProjectID(
+	ctx context.Context,
+) (string, error) {
+	return getIdentity(ctx, c.ProjectIDKey)
+}
+
+// GetProjectNumber returns the number of the project where the PCAP sidecar is deployed; it must be numeric.
+func GetProjectNumber(
+	ctx context.Context,
+) (string, error) {
+	number, err := getIdentity(ctx, c.ProjectNumKey)
+	if err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseUint(number, 10, 64); err != nil {
+		return "", fmt.Errorf("%w: project number must be numeric: %s", InvalidConfigError, number)
+	}
+	return number, nil
+}
+
+// GetRegion returns the region where the PCAP sidecar is deployed.
+func GetRegion(
+	ctx context.Context,
+) (string, error) {
+	return getIdentity(ctx, c.GcpRegionKey)
+}
+
+// GetInstanceID returns the ID of the instance hosting the PCAP sidecar;
+// the `unknown` placeholder is not an instance ID.
+func GetInstanceID(
+	ctx context.Context,
+) (string, error) {
+	instanceID, err := getIdentity(ctx, c.InstanceIDKey)
+	if err != nil || instanceID == unknownInstanceID {
+		return "", UnavailableConfigError
+	}
+	return instanceID, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// TestIdentityGetters verifies that empty and placeholder values are reported as unavailable.
+func TestIdentityGetters(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     c.CtxKey
+		value   any
+		get     func(context.Context) (string, error)
+		want    string
+		wantErr error
+	}{
+		{"project", c.ProjectIDKey, "my-project", GetProjectID, "my-project", nil},
+		{"empty project", c.ProjectIDKey, "", GetProjectID, "", UnavailableConfigError},
+		{"missing project", "", nil, GetProjectID, "", UnavailableConfigError},
+		{"region", c.GcpRegionKey, "us-central1", GetRegion, "us-central1", nil},
+		{"project number", c.ProjectNumKey, "123456789", GetProjectNumber, "123456789", nil},
+		{"invalid project number", c.ProjectNumKey, "my-project", GetProjectNumber, "", InvalidConfigError},
+		{"instance", c.InstanceIDKey, "0a1b2c", GetInstanceID, "0a1b2c", nil},
+		{"unknown instance", c.InstanceIDKey, "unknown", GetInstanceID, "", UnavailableConfigError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.value != nil {
+				ctx = context.WithValue(ctx, contextKey(tc.key), tc.value)
+			}
+			got, err := tc.get(ctx)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("got %q, %v, want %q, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+type (
+	// Profile is a named bundle of config keys, by JSON path relative to `pcap.`.
+	Profile = c.Profile
+
+	// ProfileSwitch describes the config keys whose value changed when switching the active profile.
+	ProfileSwitch = c.ProfileSwitch
+)
+
+// GetProfile returns the name of the active capture profile.
+func GetProfile(
+	ctx context.Context,
+) (string, error) {
+	if profile, err := getString(ctx, c.ProfileKey); err == nil && profile != "" {
+		return profile, nil
+	}
+	return "", UnavailableConfigError
+}
+
+// GetProfiles returns all the capture profiles declared in the config, including the built-in ones.
+func GetProfiles(
+	ctx context.Context,
+) (map[string]Profile, error) {
+	profiles, err := c.GetProfiles(ctx)
+	if err != nil {
+		return nil, UnavailableConfigError
+	}
+	return profiles, nil
+}
+
+// SwitchProfile reloads the config with `profile` as the active capture profile; `live` are the keys,
+// as they are reported, which the caller reconfigures at runtime: other changed keys require a restart.
+func SwitchProfile(
+	ctx context.Context,
+	profile string,
+	live ...string,
+) (context.Context, *ConfigReport, *ProfileSwitch, error) {
+	liveKeys := make([]c.CtxKey, len(live))
+	for i, key := range live {
+		liveKeys[i] = c.CtxKey(key)
+	}
+	return c.SwitchProfile(ctx, profile, liveKeys...)
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// SessionSampling describes which sessions export their PCAP files with full fidelity.
+type SessionSampling struct {
+	// Rate is the fraction of sessions which are sampled, between `0` and `1`
+	Rate float64
+	Seed SampleSeed
+	// Mode is what unsampled sessions export
+	Mode SampleMode
+}
+
+type (
+	SampleSeed string
+	SampleMode string
+)
+
+const (
+	// SAMPLE_SEED_INSTANCE hashes the instance ID: re-deploys of the same instance make the same decision
+	SAMPLE_SEED_INSTANCE = SampleSeed("instance")
+	SAMPLE_SEED_RANDOM   = SampleSeed("random")
+
+	SAMPLE_MODE_DROP    = SampleMode("drop")
+	SAMPLE_MODE_HEADERS = SampleMode("headers")
+)
+
+var (
+	sampleSeeds = []SampleSeed{SAMPLE_SEED_INSTANCE, SAMPLE_SEED_RANDOM}
+	sampleModes = []SampleMode{SAMPLE_MODE_DROP, SAMPLE_MODE_HEADERS}
+)
+
+func getFloat(
+	ctx context.Context,
+	key c.CtxKey,
+) (float64, error) {
+	k := contextKey(key)
+	value := ctx.Value(k)
+
+	if v, ok := value.(float64); ok {
+		return v, nil
+	}
+
+	return 0, UnavailableConfigError
+}
+
+// GetSessionSampling returns the session sampling config; sessions are all sampled when the rate is not set.
+func GetSessionSampling(
+	ctx context.Context,
+) (*SessionSampling, error) {
+	rate, err := getFloat(ctx, c.SampleRateKey)
+	if err != nil {
+		return nil, err
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("%w: session sample rate must be between 0 and 1: %v", InvalidConfigError, rate)
+	}
+
+	sampling := &SessionSampling{
+		Rate: rate,
+		Seed: SampleSeed(getStringOrDefault(ctx, c.SampleSeedKey, string(SAMPLE_SEED_INSTANCE))),
+		Mode: SampleMode(getStringOrDefault(ctx, c.SampleModeKey, string(SAMPLE_MODE_DROP))),
+	}
+	if !slices.Contains(sampleSeeds, sampling.Seed) {
+		return nil, fmt.Errorf("%w: session sample seed must be one of %v: %s", InvalidConfigError, sampleSeeds, sampling.Seed)
+	}
+	if !slices.Contains(sampleModes, sampling.Mode) {
+		return nil, fmt.Errorf("%w: session sample mode must be one of %v: %s", InvalidConfigError, sampleModes, sampling.Mode)
+	}
+	return sampling, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// TestGetSessionSampling verifies that the rate, seed, and mode are validated, and that seed and mode are optional.
+func TestGetSessionSampling(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		seed    string
+		mode    string
+		want    SessionSampling
+		wantErr error
+	}{
+		{"defaults", 0.05, "", "", SessionSampling{0.05, SAMPLE_SEED_INSTANCE, SAMPLE_MODE_DROP}, nil},
+		{"headers", 0.5, "random", "headers", SessionSampling{0.5, SAMPLE_SEED_RANDOM, SAMPLE_MODE_HEADERS}, nil},
+		{"all", 1, "instance", "drop", SessionSampling{1, SAMPLE_SEED_INSTANCE, SAMPLE_MODE_DROP}, nil},
+		{"none", 0, "instance", "drop", SessionSampling{0, SAMPLE_SEED_INSTANCE, SAMPLE_MODE_DROP}, nil},
+		{"negative rate", -0.1, "", "", SessionSampling{}, InvalidConfigError},
+		{"rate above 1", 5, "", "", SessionSampling{}, InvalidConfigError},
+		{"unknown seed", 0.1, "time", "", SessionSampling{}, InvalidConfigError},
+		{"summaries are not supported", 0.1, "", "summary", SessionSampling{}, InvalidConfigError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKey(c.SampleRateKey), tc.rate)
+			if tc.seed != "" {
+				ctx = context.WithValue(ctx, contextKey(c.SampleSeedKey), tc.seed)
+			}
+			if tc.mode != "" {
+				ctx = context.WithValue(ctx, contextKey(c.SampleModeKey), tc.mode)
+			}
+			got, err := GetSessionSampling(ctx)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && *got != tc.want {
+				t.Errorf("got %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+
+	if _, err := GetSessionSampling(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+}
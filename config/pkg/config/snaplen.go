@@ -0,0 +1,38 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/snaplen"
+)
+
+// GetSnaplen returns the number of bytes captured from each packet; `0` means `snaplen.Default`.
+func GetSnaplen(
+	ctx context.Context,
+) (int, error) {
+	value, err := getInteger(ctx, c.SnaplenKey)
+	if err != nil {
+		return 0, err
+	}
+	resolved, err := snaplen.Resolve(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", InvalidConfigError, err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/snaplen"
+)
+
+// TestGetSnaplen verifies that the default is applied, and that invalid values are reported as invalid config.
+func TestGetSnaplen(t *testing.T) {
+	tests := []struct {
+		name    string
+		snaplen int
+		want    int
+		wantErr error
+	}{
+		{"default", 0, snaplen.Default, nil},
+		{"explicit", 65536, 65536, nil},
+		{"max", snaplen.Max, snaplen.Max, nil},
+		{"negative", -1, 0, InvalidConfigError},
+		{"above max", snaplen.Max + 1, 0, InvalidConfigError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKey(c.SnaplenKey), tc.snaplen)
+			got, err := GetSnaplen(ctx)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("got %d, %v, want %d, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := GetSnaplen(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// GetSupervisorPort returns the TCP port where supervisord serves its XML-RPC interface;
+// `0` and ports above `65535` are invalid.
+func GetSupervisorPort(
+	ctx context.Context,
+) (uint16, error) {
+	port, err := getInteger(ctx, c.SupervisorPortKey)
+	if err != nil {
+		return 0, err
+	}
+	if port <= 0 || port > math.MaxUint16 {
+		return 0, fmt.Errorf("%w: supervisor port must be between 1 and %d: %d", InvalidConfigError, math.MaxUint16, port)
+	}
+	return uint16(port), nil
+}
+
+// GetSupervisorURL returns the supervisord `serverurl` used by supervisorctl and XML-RPC clients.
+func GetSupervisorURL(
+	ctx context.Context,
+) (string, error) {
+	port, err := GetSupervisorPort(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d", port), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
+)
+
+// TestGetSupervisorPort verifies that only ports within the TCP port range are accepted.
+func TestGetSupervisorPort(t *testing.T) {
+	tests := []struct {
+		name    string
+		port    int
+		want    uint16
+		wantErr error
+	}{
+		{"default", 23456, 23456, nil},
+		{"max", 65535, 65535, nil},
+		{"zero", 0, 0, InvalidConfigError},
+		{"negative", -1, 0, InvalidConfigError},
+		{"out of range", 65536, 0, InvalidConfigError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), contextKey(c.SupervisorPortKey), tc.port)
+			got, err := GetSupervisorPort(ctx)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("got %d, %v, want %d, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := GetSupervisorPort(context.Background()); err != UnavailableConfigError {
+		t.Errorf("got %v, want %v", err, UnavailableConfigError)
+	}
+
+	ctx := context.WithValue(context.Background(), contextKey(c.SupervisorPortKey), 23456)
+	if url, err := GetSupervisorURL(ctx); err != nil || url != "http://127.0.0.1:23456" {
+		t.Errorf("GetSupervisorURL() = %q, %v", url, err)
+	}
+}
@@ -21,13 +21,10 @@ import (
 	c "github.com/GoogleCloudPlatform/pcap-sidecar/config/internal/config"
 )
 
-var UnavailableConfigError = errors.New("")
-
-func newError(
-	err error,
-) error {
-	return errors.Join(UnavailableConfigError, err)
-}
+var (
+	UnavailableConfigError = errors.New("")
+	InvalidConfigError     = errors.New("invalid config value")
+)
 
 func contextKey(
 	key c.CtxKey,
@@ -44,8 +41,6 @@ func getBoolean(
 
 	if v, ok := value.(bool); ok {
 		return v, nil
-	} else if err, errOK := value.(error); errOK {
-		return false, newError(err)
 	}
 
 	return false, UnavailableConfigError
@@ -71,13 +66,25 @@ func getString(
 
 	if v, ok := value.(string); ok {
 		return v, nil
-	} else if err, errOK := value.(error); errOK {
-		return "", newError(err)
 	}
 
 	return "", UnavailableConfigError
 }
 
+func getStrings(
+	ctx context.Context,
+	key c.CtxKey,
+) ([]string, error) {
+	k := contextKey(key)
+	value := ctx.Value(k)
+
+	if v, ok := value.([]string); ok && len(v) > 0 {
+		return v, nil
+	}
+
+	return nil, UnavailableConfigError
+}
+
 func GetDebug(
 	ctx context.Context,
 ) (bool, error) {
@@ -107,3 +114,88 @@ func GetVerbosity(
 ) (PcapVerbosity, error) {
 	return GetVerbosityOrDefault(ctx, PCAP_VERBOSITY_DEBUG)
 }
+
+// GetExtension returns the extensions of the PCAP files written by `tcpdumpw`,
+// which are also the ones watched by `pcap-fsnotify`.
+func GetExtension(
+	ctx context.Context,
+) ([]string, error) {
+	value, err := getString(ctx, c.ExtensionKey)
+	if err != nil {
+		return nil, err
+	}
+	return ParseExtensions(value)
+}
+
+// GetIface returns the interfaces specification: `any`, interface names prefixes, or globs.
+func GetIface(
+	ctx context.Context,
+) (string, error) {
+	return getString(ctx, c.IfaceKey)
+}
+
+// IsGcsExportEnabled reports whether PCAP files should be exported to GCS;
+// when disabled, PCAP files are captured and rotated, but kept in the local directory.
+func IsGcsExportEnabled(
+	ctx context.Context,
+) (bool, error) {
+	return getBoolean(ctx, c.GcsExportKey)
+}
+
+// GetDirectory returns the local directory where PCAP files are written before being exported.
+func GetDirectory(
+	ctx context.Context,
+) (string, error) {
+	if dir, err := getString(ctx, c.DirectoryKey); err == nil && dir != "" {
+		return dir, nil
+	}
+	return "", UnavailableConfigError
+}
+
+// GetGcsMountPoint returns the local directory where the GCS bucket is mounted.
+func GetGcsMountPoint(
+	ctx context.Context,
+) (string, error) {
+	if mount, err := getString(ctx, c.GcsMountPointKey); err == nil && mount != "" {
+		return mount, nil
+	}
+	return "", UnavailableConfigError
+}
+
+// GetGcsDir returns the directory within the GCS bucket where PCAP files are exported.
+func GetGcsDir(
+	ctx context.Context,
+) (string, error) {
+	return getString(ctx, c.GcsDirKey)
+}
+
+// GetGcsTempDir returns the directory within the GCS bucket where PCAP files are staged before
+// being moved into the GCS directory; staging is disabled when it is empty.
+func GetGcsTempDir(
+	ctx context.Context,
+) (string, error) {
+	return getString(ctx, c.GcsTempDirKey)
+}
+
+// GetLogFields returns the identity fields attached to every log event:
+// any of `project`, `region`, `service`, `version`, `instance`, `sidecar`, `module`.
+func GetLogFields(
+	ctx context.Context,
+) ([]string, error) {
+	return getStrings(ctx, c.LogFieldsKey)
+}
+
+// GetCompressionOverrides returns the `<iface>=<level>` gzip levels which pin the compression of PCAP files per interface.
+func GetCompressionOverrides(
+	ctx context.Context,
+) ([]string, error) {
+	return getStrings(ctx, c.CompressionKey)
+}
+
+// GetAuditFields returns the identity fields attached to GCS audit logs;
+// it is independent from the log events fields.
+func GetAuditFields(
+	ctx context.Context,
+) ([]string, error) {
+	return getStrings(ctx, c.AuditFieldsKey)
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package env parses environment variables strictly, and records every consumed environment variable
+// along with the source of its value, so that a startup summary can show how the process was configured.
+package env
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type (
+	// Source is where the value of an environment variable was taken from.
+	Source string
+
+	// TriState distinguishes an unset boolean environment variable from an explicit `false`.
+	TriState uint8
+
+	// Var describes a consumed environment variable.
+	Var struct {
+		Name   string `json:"name"`
+		Value  any    `json:"value"`
+		Source Source `json:"source"`
+		// Flag is the name of the flag which may override the environment variable
+		Flag string `json:"flag,omitempty"`
+		// Malformed is the raw value which could not be parsed; the default value is used instead
+		Malformed string `json:"malformed,omitempty"`
+		Err       error  `json:"-"`
+	}
+
+	// Env is safe for concurrent use.
+	Env struct {
+		mu     sync.Mutex
+		lookup func(string) (string, bool)
+		vars   []*Var
+	}
+)
+
+const (
+	SOURCE_ENV     = Source("env")
+	SOURCE_FLAG    = Source("flag")
+	SOURCE_DEFAULT = Source("default")
+)
+
+const (
+	UNSET TriState = iota
+	TRUE
+	FALSE
+)
+
+// New creates an environment reader; `lookup` is usually `os.LookupEnv`.
+func New(
+	lookup func(string) (string, bool),
+) *Env {
+	return &Env{lookup: lookup}
+}
+
+// NewFromOS creates an environment reader for the process environment.
+func NewFromOS() *Env {
+	return New(os.LookupEnv)
+}
+
+func (e *Env) record(
+	v *Var,
+) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// only the last read of an environment variable is kept
+	for i, known := range e.vars {
+		if known.Name == v.Name {
+			e.vars[i] = v
+			return
+		}
+	}
+	e.vars = append(e.vars, v)
+}
+
+// String returns the value of the environment variable `name`, or `defaultValue` when it is not set.
+func (e *Env) String(
+	name, defaultValue string,
+) string {
+	v := &Var{Name: name, Value: defaultValue, Source: SOURCE_DEFAULT}
+	if value, ok := e.lookup(name); ok {
+		v.Value, v.Source = value, SOURCE_ENV
+	}
+	e.record(v)
+	return v.Value.(string)
+}
+
+// parseBool accepts the same values as `strconv.ParseBool`; empty values are unset.
+func (e *Env) parseBool(
+	name string,
+) (TriState, string, error) {
+	value, ok := e.lookup(name)
+	if !ok || value == "" {
+		return UNSET, "", nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return UNSET, value, fmt.Errorf("%s: invalid boolean: %q", name, value)
+	}
+	if enabled {
+		return TRUE, value, nil
+	}
+	return FALSE, value, nil
+}
+
+// TriState returns whether the boolean environment variable `name` is explicitly `true` or `false`;
+// malformed values are recorded, and reported as `UNSET`.
+func (e *Env) TriState(
+	name string,
+) TriState {
+	state, raw, err := e.parseBool(name)
+	v := &Var{Name: name, Value: state.String(), Source: SOURCE_ENV}
+	if state == UNSET {
+		v.Source = SOURCE_DEFAULT
+	}
+	if err != nil {
+		v.Malformed, v.Err = raw, err
+	}
+	e.record(v)
+	return state
+}
+
+// Bool returns the value of the boolean environment variable `name`, or `defaultValue` when it is not set or malformed.
+func (e *Env) Bool(
+	name string,
+	defaultValue bool,
+) bool {
+	return e.BoolWithFlag(name, nil, "", defaultValue)
+}
+
+// BoolWithFlag returns the value of the flag `flagName` when it is explicitly set in `flags`;
+// otherwise the value of the boolean environment variable `name`, or `defaultValue` when it is not set or malformed.
+func (e *Env) BoolWithFlag(
+	name string,
+	flags *flag.FlagSet,
+	flagName string,
+	defaultValue bool,
+) bool {
+	v := &Var{Name: name, Value: defaultValue, Source: SOURCE_DEFAULT, Flag: flagName}
+
+	state, raw, err := e.parseBool(name)
+	if err != nil {
+		v.Malformed, v.Err = raw, err
+	} else if state != UNSET {
+		v.Value, v.Source = state == TRUE, SOURCE_ENV
+	}
+
+	if flags != nil && flagName != "" {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name != flagName {
+				return
+			}
+			if getter, ok := f.Value.(flag.Getter); ok {
+				if value, ok := getter.Get().(bool); ok {
+					v.Value, v.Source = value, SOURCE_FLAG
+				}
+			}
+		})
+	}
+
+	e.record(v)
+	return v.Value.(bool)
+}
+
+// Vars returns all consumed environment variables in the order in which they were first read.
+func (e *Env) Vars() []Var {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vars := make([]Var, len(e.vars))
+	for i, v := range e.vars {
+		vars[i] = *v
+	}
+	return vars
+}
+
+// Malformed returns the consumed environment variables whose value could not be parsed.
+func (e *Env) Malformed() []Var {
+	malformed := []Var{}
+	for _, v := range e.Vars() {
+		if v.Err != nil {
+			malformed = append(malformed, v)
+		}
+	}
+	return malformed
+}
+
+// Summary maps every consumed environment variable to its value and source.
+func (e *Env) Summary() map[string]any {
+	summary := make(map[string]any)
+	for _, v := range e.Vars() {
+		entry := map[string]any{"value": v.Value, "source": v.Source}
+		if v.Flag != "" {
+			entry["flag"] = v.Flag
+		}
+		if v.Malformed != "" {
+			entry["malformed"] = v.Malformed
+		}
+		summary[v.Name] = entry
+	}
+	return summary
+}
+
+func (s TriState) String() string {
+	switch s {
+	case TRUE:
+		return "true"
+	case FALSE:
+		return "false"
+	default:
+		return "unset"
+	}
+}
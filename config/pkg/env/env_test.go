@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package env
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func newTestEnv(vars map[string]string) *Env {
+	return New(func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	})
+}
+
+// TestTriState verifies that unset, explicit, and malformed boolean env vars are distinguished.
+func TestTriState(t *testing.T) {
+	e := newTestEnv(map[string]string{
+		"PCAP_TRUE":      "true",
+		"PCAP_FALSE":     "0",
+		"PCAP_MALFORMED": "ture",
+	})
+
+	tests := []struct {
+		name          string
+		want          TriState
+		wantMalformed bool
+	}{
+		{"PCAP_TRUE", TRUE, false},
+		{"PCAP_FALSE", FALSE, false},
+		{"PCAP_UNSET", UNSET, false},
+		{"PCAP_MALFORMED", UNSET, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := e.TriState(tc.name); got != tc.want {
+				t.Errorf("TriState() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	malformed := e.Malformed()
+	if len(malformed) != 1 || malformed[0].Name != "PCAP_MALFORMED" || malformed[0].Malformed != "ture" {
+		t.Errorf("Malformed() = %+v", malformed)
+	}
+}
+
+// TestBoolWithFlag verifies that explicit flags take precedence over env vars, which take precedence over defaults;
+// malformed env vars fall back to the default value.
+func TestBoolWithFlag(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		args       []string
+		def        bool
+		want       bool
+		wantSource Source
+	}{
+		{"default", nil, nil, false, false, SOURCE_DEFAULT},
+		{"env true", map[string]string{"PCAP_GAE": "true"}, nil, false, true, SOURCE_ENV},
+		{"env false", map[string]string{"PCAP_GAE": "false"}, nil, true, false, SOURCE_ENV},
+		{"malformed", map[string]string{"PCAP_GAE": "ture"}, nil, false, false, SOURCE_DEFAULT},
+		{"empty", map[string]string{"PCAP_GAE": ""}, nil, true, true, SOURCE_DEFAULT},
+		{"flag over env", map[string]string{"PCAP_GAE": "true"}, []string{"-gae=false"}, false, false, SOURCE_FLAG},
+		{"flag over malformed", map[string]string{"PCAP_GAE": "ture"}, []string{"-gae"}, false, true, SOURCE_FLAG},
+		{"flag over default", nil, []string{"-gae=true"}, false, true, SOURCE_FLAG},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags.SetOutput(io.Discard)
+			flags.Bool("gae", false, "")
+			if err := flags.Parse(tc.args); err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+
+			e := newTestEnv(tc.env)
+			if got := e.BoolWithFlag("PCAP_GAE", flags, "gae", tc.def); got != tc.want {
+				t.Errorf("BoolWithFlag() = %v, want %v", got, tc.want)
+			}
+			vars := e.Vars()
+			if len(vars) != 1 || vars[0].Source != tc.wantSource || vars[0].Flag != "gae" {
+				t.Errorf("Vars() = %+v, want source %s", vars, tc.wantSource)
+			}
+		})
+	}
+}
+
+// TestSummary verifies that the summary includes every consumed env var, its value, and its source.
+func TestSummary(t *testing.T) {
+	e := newTestEnv(map[string]string{
+		"PROJECT_ID": "project",
+		"PCAP_GAE":   "ture",
+	})
+
+	e.String("PROJECT_ID", "")
+	e.String("GCP_REGION", "us-central1")
+	e.Bool("PCAP_GAE", false)
+	// repeated reads are summarized once
+	e.String("PROJECT_ID", "")
+
+	summary := e.Summary()
+	if len(summary) != 3 {
+		t.Fatalf("Summary() = %v, want 3 env vars", summary)
+	}
+
+	tests := []struct {
+		name       string
+		want       any
+		wantSource Source
+		malformed  string
+	}{
+		{"PROJECT_ID", "project", SOURCE_ENV, ""},
+		{"GCP_REGION", "us-central1", SOURCE_DEFAULT, ""},
+		{"PCAP_GAE", false, SOURCE_DEFAULT, "ture"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, ok := summary[tc.name].(map[string]any)
+			if !ok {
+				t.Fatalf("%s is not summarized", tc.name)
+			}
+			if entry["value"] != tc.want || entry["source"] != tc.wantSource {
+				t.Errorf("summary = %v, want %v from %s", entry, tc.want, tc.wantSource)
+			}
+			if malformed, _ := entry["malformed"].(string); malformed != tc.malformed {
+				t.Errorf("malformed = %q, want %q", malformed, tc.malformed)
+			}
+		})
+	}
+
+	if vars := e.Vars(); vars[0].Name != "PROJECT_ID" || vars[2].Name != "PCAP_GAE" {
+		t.Errorf("Vars() = %+v, want consumption order", vars)
+	}
+}
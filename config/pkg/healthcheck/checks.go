@@ -0,0 +1,241 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/config"
+)
+
+const (
+	// the PCAP files exporter serves its readiness at `/readyz` when it does not expose the capture status
+	captureStatusPath = "/v1/capture-status"
+	exporterReadyPath = "/readyz"
+	configReadyPath   = "/ready"
+
+	maxDetailBytes = 512
+)
+
+func dial(
+	ctx context.Context,
+	addr string,
+) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil && errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, errors.Join(ErrUnavailable, err)
+	}
+	return conn, err
+}
+
+// TCPCheck passes when a connection to `addr` can be established.
+func TCPCheck(
+	addr string,
+) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		conn, err := dial(ctx, addr)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return fmt.Sprintf("connected to %s", addr), nil
+	}
+}
+
+// get returns the status code and a short prefix of the body;
+// dependencies which are not listening, or do not serve `path`, are unavailable.
+func get(
+	ctx context.Context,
+	client *http.Client,
+	addr, path string,
+) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return 0, "", errors.Join(ErrUnavailable, err)
+		}
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxDetailBytes))
+	detail := strings.TrimSpace(string(body))
+
+	if res.StatusCode == http.StatusNotFound {
+		return res.StatusCode, detail, fmt.Errorf("%w: %s not found", ErrUnavailable, path)
+	}
+	return res.StatusCode, detail, nil
+}
+
+func checkHTTP(
+	ctx context.Context,
+	client *http.Client,
+	addr string,
+	paths ...string,
+) (string, error) {
+	var err error
+	for _, path := range paths {
+		var (
+			code   int
+			detail string
+		)
+		code, detail, err = get(ctx, client, addr, path)
+		if err == nil {
+			if code != http.StatusOK {
+				return detail, fmt.Errorf("%s responded %d", path, code)
+			}
+			return detail, nil
+		}
+		if !errors.Is(err, ErrUnavailable) || code != http.StatusNotFound {
+			break
+		}
+	}
+	return "", err
+}
+
+// ExporterCheck consumes the capture status of the PCAP files exporter, or its readiness when it is not available.
+func ExporterCheck(
+	client *http.Client,
+	addr string,
+) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		return checkHTTP(ctx, client, addr, captureStatusPath, exporterReadyPath)
+	}
+}
+
+// ConfigCheck consumes the readiness of the config server.
+func ConfigCheck(
+	client *http.Client,
+	addr string,
+) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		return checkHTTP(ctx, client, addr, configReadyPath)
+	}
+}
+
+// BacklogCheck fails when more than `max` PCAP files are waiting to be exported from `dir`.
+func BacklogCheck(
+	dir string,
+	max int,
+) func(context.Context) (string, error) {
+	return func(_ context.Context) (string, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		pending := 0
+		for _, entry := range entries {
+			// hidden files are used to stage PCAP files; i.e.: post-processing
+			if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				pending += 1
+			}
+		}
+		detail := fmt.Sprintf("%d/%d PCAP files pending", pending, max)
+		if pending > max {
+			return detail, errors.New("too many PCAP files pending")
+		}
+		return detail, nil
+	}
+}
+
+// DiskCheck fails when less than `minFreeMiB` are available in the filesystem of `dir`.
+func DiskCheck(
+	dir string,
+	minFreeMiB int,
+) func(context.Context) (string, error) {
+	return func(_ context.Context) (string, error) {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(dir, &stat); err != nil {
+			return "", err
+		}
+		freeMiB := stat.Bavail * uint64(stat.Bsize) >> 20
+		detail := fmt.Sprintf("%d/%d MiB free", freeMiB, minFreeMiB)
+		if freeMiB < uint64(minFreeMiB) {
+			return detail, errors.New("not enough disk space")
+		}
+		return detail, nil
+	}
+}
+
+// NewChecks assembles the checks enabled by `cfg`:
+//   - `capture`: packet capturing must be reachable for all probes.
+//   - `config`: the config server should be ready at startup.
+//   - `exporter`: the PCAP files exporter must be ready when export is required.
+//   - `backlog` and `disk`: local PCAP files must be within thresholds to be ready.
+func NewChecks(
+	cfg *config.HealthcheckConfig,
+	client *http.Client,
+) []*Check {
+	checks := []*Check{}
+
+	if cfg.CaptureAddr != "" {
+		checks = append(checks, &Check{
+			Name:     "capture",
+			Probes:   probes,
+			Required: true,
+			Run:      TCPCheck(cfg.CaptureAddr),
+		})
+	}
+
+	if cfg.ConfigAddr != "" {
+		checks = append(checks, &Check{
+			Name:   "config",
+			Probes: []Probe{PROBE_STARTUP},
+			Run:    ConfigCheck(client, cfg.ConfigAddr),
+		})
+	}
+
+	if cfg.ExporterAddr != "" {
+		checks = append(checks, &Check{
+			Name:     "exporter",
+			Probes:   []Probe{PROBE_READINESS},
+			Required: cfg.RequireExport,
+			Run:      ExporterCheck(client, cfg.ExporterAddr),
+		})
+	}
+
+	if cfg.Directory != "" && cfg.MaxBacklog > 0 {
+		checks = append(checks, &Check{
+			Name:     "backlog",
+			Probes:   []Probe{PROBE_READINESS},
+			Required: true,
+			Run:      BacklogCheck(cfg.Directory, cfg.MaxBacklog),
+		})
+	}
+
+	if cfg.Directory != "" && cfg.MinFreeMiB > 0 {
+		checks = append(checks, &Check{
+			Name:     "disk",
+			Probes:   []Probe{PROBE_READINESS},
+			Required: true,
+			Run:      DiskCheck(cfg.Directory, cfg.MinFreeMiB),
+		})
+	}
+
+	return checks
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package healthcheck
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	Probe  string
+	Status string
+
+	// Check runs for every probe in `Probes`: failures of optional checks only degrade the probe.
+	Check struct {
+		Name     string
+		Probes   []Probe
+		Required bool
+		// Run returns a short description of what was observed; i.e.: `3 PCAP files pending`
+		Run func(ctx context.Context) (string, error)
+	}
+
+	CheckResult struct {
+		Name     string `json:"name"`
+		Status   Status `json:"status"`
+		Required bool   `json:"required"`
+		Detail   string `json:"detail,omitempty"`
+	}
+
+	Response struct {
+		Probe  Probe          `json:"probe"`
+		Status Status         `json:"status"`
+		Checks []*CheckResult `json:"checks"`
+	}
+
+	// Server serves probes which are only failed by required checks: responses are `200` or `503`.
+	Server struct {
+		checks  []*Check
+		timeout time.Duration
+		mux     *http.ServeMux
+	}
+)
+
+const (
+	PROBE_STARTUP   = Probe("startup")
+	PROBE_LIVENESS  = Probe("liveness")
+	PROBE_READINESS = Probe("readiness")
+
+	STATUS_PASS = Status("pass")
+	STATUS_WARN = Status("warn")
+	STATUS_FAIL = Status("fail")
+	STATUS_SKIP = Status("skip")
+)
+
+// ErrUnavailable is returned by checks whose dependency is absent:
+// optional checks are skipped, and required ones fail.
+var ErrUnavailable = errors.New("dependency unavailable")
+
+var probes = []Probe{PROBE_STARTUP, PROBE_LIVENESS, PROBE_READINESS}
+
+// NewServer serves `/startup`, `/liveness`, and `/readiness`; each check is given at most `timeout` to complete.
+func NewServer(
+	checks []*Check,
+	timeout time.Duration,
+) *Server {
+	s := &Server{
+		checks:  checks,
+		timeout: timeout,
+		mux:     http.NewServeMux(),
+	}
+	for _, probe := range probes {
+		s.mux.HandleFunc("/"+string(probe), func(w http.ResponseWriter, r *http.Request) {
+			s.handleProbe(w, r, probe)
+		})
+	}
+	return s
+}
+
+func runCheck(
+	ctx context.Context,
+	check *Check,
+) *CheckResult {
+	result := &CheckResult{
+		Name:     check.Name,
+		Status:   STATUS_PASS,
+		Required: check.Required,
+	}
+
+	detail, err := check.Run(ctx)
+	result.Detail = detail
+
+	switch {
+	case err == nil:
+		return result
+	case errors.Is(err, ErrUnavailable) && !check.Required:
+		result.Status = STATUS_SKIP
+	case check.Required:
+		result.Status = STATUS_FAIL
+	default:
+		result.Status = STATUS_WARN
+	}
+
+	if result.Detail == "" {
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+// Run executes all the checks of `probe` concurrently.
+func (s *Server) Run(
+	ctx context.Context,
+	probe Probe,
+) *Response {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	response := &Response{
+		Probe:  probe,
+		Status: STATUS_PASS,
+		Checks: []*CheckResult{},
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range s.checks {
+		if !slices.Contains(check.Probes, probe) {
+			continue
+		}
+		wg.Go(func() {
+			result := runCheck(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks = append(response.Checks, result)
+		})
+	}
+	wg.Wait()
+
+	slices.SortFunc(response.Checks, func(a, b *CheckResult) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	for _, result := range response.Checks {
+		if result.Status == STATUS_FAIL {
+			response.Status = STATUS_FAIL
+			break
+		} else if result.Status == STATUS_WARN {
+			response.Status = STATUS_WARN
+		}
+	}
+
+	return response
+}
+
+func (s *Server) handleProbe(
+	w http.ResponseWriter,
+	r *http.Request,
+	probe Probe,
+) {
+	response := s.Run(r.Context(), probe)
+	w.Header().Set("Content-Type", "application/json")
+	if response.Status == STATUS_FAIL {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Serve serves probes using `listener` until `ctx` is done; in-flight probes are given 1 second to complete.
+func (s *Server) Serve(
+	ctx context.Context,
+	listener net.Listener,
+) error {
+	server := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
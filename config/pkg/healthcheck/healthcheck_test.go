@@ -0,0 +1,261 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/config"
+)
+
+// closedAddr returns an address where nothing is listening.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// listeningAddr returns an address which accepts connections until the test ends.
+func listeningAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// fakeServer serves `status` at each path in `paths`, and `404` for everything else.
+func fakeServer(t *testing.T, status int, paths ...string) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	for _, path := range paths {
+		mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"ready":true}`))
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// pcapDir returns a directory with `files` PCAP files pending export, and one staged hidden file.
+func pcapDir(t *testing.T, files int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := range files {
+		name := filepath.Join(dir, "part__"+string(rune('a'+i))+".pcap")
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".pcapfsn-postprocess-part.pcap"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestProbes wires fake dependencies, and verifies the status code of every probe.
+func TestProbes(t *testing.T) {
+	const (
+		ok          = http.StatusOK
+		unavailable = http.StatusServiceUnavailable
+	)
+
+	tests := []struct {
+		name string
+		cfg  func(*config.HealthcheckConfig)
+		// expected status codes for startup, liveness, and readiness
+		want [3]int
+	}{
+		{
+			name: "healthy",
+			cfg:  func(*config.HealthcheckConfig) {},
+			want: [3]int{ok, ok, ok},
+		},
+		{
+			name: "capture down",
+			cfg:  func(c *config.HealthcheckConfig) { c.CaptureAddr = closedAddr(t) },
+			want: [3]int{unavailable, unavailable, unavailable},
+		},
+		{
+			name: "config absent",
+			cfg:  func(c *config.HealthcheckConfig) { c.ConfigAddr = closedAddr(t) },
+			want: [3]int{ok, ok, ok},
+		},
+		{
+			name: "config unready",
+			cfg:  func(c *config.HealthcheckConfig) { c.ConfigAddr = fakeServer(t, unavailable, configReadyPath) },
+			want: [3]int{ok, ok, ok},
+		},
+		{
+			name: "exporter without capture status",
+			cfg:  func(c *config.HealthcheckConfig) { c.ExporterAddr = fakeServer(t, ok, exporterReadyPath) },
+			want: [3]int{ok, ok, ok},
+		},
+		{
+			name: "exporter unready",
+			cfg:  func(c *config.HealthcheckConfig) { c.ExporterAddr = fakeServer(t, unavailable, exporterReadyPath) },
+			want: [3]int{ok, ok, unavailable},
+		},
+		{
+			name: "exporter absent and export required",
+			cfg:  func(c *config.HealthcheckConfig) { c.ExporterAddr = closedAddr(t) },
+			want: [3]int{ok, ok, unavailable},
+		},
+		{
+			name: "exporter absent and export optional",
+			cfg: func(c *config.HealthcheckConfig) {
+				c.ExporterAddr = closedAddr(t)
+				c.RequireExport = false
+			},
+			want: [3]int{ok, ok, ok},
+		},
+		{
+			name: "exporter unready and export optional",
+			cfg: func(c *config.HealthcheckConfig) {
+				c.ExporterAddr = fakeServer(t, unavailable, captureStatusPath)
+				c.RequireExport = false
+			},
+			want: [3]int{ok, ok, ok},
+		},
+		{
+			name: "backlog exceeded",
+			cfg:  func(c *config.HealthcheckConfig) { c.Directory = pcapDir(t, 3) },
+			want: [3]int{ok, ok, unavailable},
+		},
+		{
+			name: "disk full",
+			cfg:  func(c *config.HealthcheckConfig) { c.MinFreeMiB = 1 << 40 },
+			want: [3]int{ok, ok, unavailable},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.HealthcheckConfig{
+				Directory:     pcapDir(t, 2),
+				CaptureAddr:   listeningAddr(t),
+				ExporterAddr:  fakeServer(t, ok, captureStatusPath),
+				ConfigAddr:    fakeServer(t, ok, configReadyPath),
+				RequireExport: true,
+				MaxBacklog:    2,
+				MinFreeMiB:    1,
+			}
+			tc.cfg(cfg)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			server := NewServer(NewChecks(cfg, &http.Client{}), time.Second)
+			go server.Serve(ctx, listener)
+
+			for i, probe := range probes {
+				res, err := http.Get("http://" + listener.Addr().String() + "/" + string(probe))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var response Response
+				err = json.NewDecoder(res.Body).Decode(&response)
+				res.Body.Close()
+				if err != nil {
+					t.Fatalf("invalid %s response: %v", probe, err)
+				}
+				if res.StatusCode != tc.want[i] {
+					t.Errorf("GET /%s = %d, want %d: %+v", probe, res.StatusCode, tc.want[i], response.Checks)
+				}
+				if response.Probe != probe || len(response.Checks) == 0 {
+					t.Errorf("GET /%s = %+v, want per check results", probe, response)
+				}
+			}
+		})
+	}
+}
+
+// TestRunCheck verifies how check errors are reported depending on whether the check is required.
+func TestRunCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		required bool
+		want     Status
+	}{
+		{"pass", nil, true, STATUS_PASS},
+		{"optional failure", context.DeadlineExceeded, false, STATUS_WARN},
+		{"required failure", context.DeadlineExceeded, true, STATUS_FAIL},
+		{"optional unavailable", ErrUnavailable, false, STATUS_SKIP},
+		{"required unavailable", ErrUnavailable, true, STATUS_FAIL},
+	}
+
+	for _, tc := range tests {
+		check := &Check{
+			Name:     tc.name,
+			Required: tc.required,
+			Run:      func(context.Context) (string, error) { return "", tc.err },
+		}
+		if got := runCheck(context.Background(), check); got.Status != tc.want {
+			t.Errorf("%s: status = %s, want %s", tc.name, got.Status, tc.want)
+		}
+	}
+}
+
+// TestServeShutdown verifies that serving stops promptly once the context is done.
+func TestServeShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewServer(nil, time.Second).Serve(ctx, listener) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve() did not return after the context was done")
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iface
+
+import (
+	"errors"
+	"net"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	sf "github.com/wissance/stringFormatter"
+)
+
+type (
+	Interface struct {
+		Name     string `json:"name"`
+		Index    int    `json:"index"`
+		Up       bool   `json:"up"`
+		Loopback bool   `json:"loopback"`
+	}
+
+	// Source lists the network interfaces available to the container.
+	Source interface {
+		Interfaces() ([]Interface, error)
+	}
+
+	SourceFunc func() ([]Interface, error)
+
+	Options struct {
+		// loopback interfaces are only resolved when explicitly requested by name or glob
+		IncludeLoopback bool
+		ExcludeDown     bool
+	}
+
+	// Resolver expands an interfaces specification ( i.e. `any`, `eth`, `eth*,lo` )
+	// into the concrete network interfaces which are currently available.
+	Resolver struct {
+		mu       sync.RWMutex
+		spec     []string
+		source   Source
+		options  Options
+		resolved []Interface
+		literal  bool
+	}
+)
+
+const (
+	AnyIface = "any"
+
+	// same semantics as `tcpdumpw`: plain names are prefixes followed by a numeric suffix
+	prefixRegexTemplate = `^(?:ipvlan-)?{0}\d+.*$`
+	globMetaChars       = `*?[`
+)
+
+var (
+	EmptySpecError = errors.New("empty interfaces specification")
+
+	// `net.Interfaces` is backed by netlink on Linux
+	netInterfaces = net.Interfaces
+)
+
+func (f SourceFunc) Interfaces() ([]Interface, error) {
+	return f()
+}
+
+// NewSource returns a Source listing the interfaces visible to the current network namespace.
+func NewSource() Source {
+	return SourceFunc(func() ([]Interface, error) {
+		netIfaces, err := netInterfaces()
+		if err != nil {
+			return nil, err
+		}
+		ifaces := make([]Interface, len(netIfaces))
+		for i, netIface := range netIfaces {
+			ifaces[i] = Interface{
+				Name:     netIface.Name,
+				Index:    netIface.Index,
+				Up:       netIface.Flags&net.FlagUp != 0,
+				Loopback: netIface.Flags&net.FlagLoopback != 0,
+			}
+		}
+		return ifaces, nil
+	})
+}
+
+// ParseSpec splits a comma-separated interfaces specification.
+func ParseSpec(
+	value string,
+) ([]string, error) {
+	spec := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			spec = append(spec, entry)
+		}
+	}
+	if len(spec) == 0 {
+		return nil, EmptySpecError
+	}
+	return spec, nil
+}
+
+func NewResolver(
+	spec []string,
+	source Source,
+	options Options,
+) *Resolver {
+	return &Resolver{
+		spec:    spec,
+		source:  source,
+		options: options,
+	}
+}
+
+func isAny(entry string) bool {
+	return strings.EqualFold(entry, AnyIface)
+}
+
+func isGlob(entry string) bool {
+	return strings.ContainsAny(entry, globMetaChars)
+}
+
+// matches reports whether `entry` selects the interface `name`;
+// `explicit` is `true` when the interface was not selected by `any`.
+func matches(
+	entry, name string,
+) (matched, explicit bool) {
+	if isAny(entry) {
+		return true, false
+	}
+	if entry == name {
+		return true, true
+	}
+	if isGlob(entry) {
+		matched, _ = path.Match(entry, name)
+		return matched, matched
+	}
+	prefixRegex := regexp.MustCompile(sf.Format(prefixRegexTemplate, regexp.QuoteMeta(entry)))
+	matched = prefixRegex.MatchString(name)
+	return matched, matched
+}
+
+func (r *Resolver) selects(
+	iface *Interface,
+) bool {
+	if r.options.ExcludeDown && !iface.Up {
+		return false
+	}
+	for _, entry := range r.spec {
+		matched, explicit := matches(entry, iface.Name)
+		if !matched {
+			continue
+		}
+		if !iface.Loopback || explicit || r.options.IncludeLoopback {
+			return true
+		}
+	}
+	return false
+}
+
+// literalInterfaces is the configured specification without globs;
+// it is used when interfaces cannot be discovered.
+func (r *Resolver) literalInterfaces() []Interface {
+	ifaces := []Interface{}
+	for _, entry := range r.spec {
+		if !isGlob(entry) {
+			ifaces = append(ifaces, Interface{Name: entry, Up: true})
+		}
+	}
+	return ifaces
+}
+
+func (r *Resolver) discover() ([]Interface, error) {
+	ifaces, err := r.source.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	resolved := []Interface{}
+	for _, iface := range ifaces {
+		if r.selects(&iface) {
+			resolved = append(resolved, iface)
+		}
+	}
+	sort.SliceStable(resolved, func(i, j int) bool {
+		return resolved[i].Index < resolved[j].Index
+	})
+	return resolved, nil
+}
+
+func names(ifaces []Interface) []string {
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = iface.Name
+	}
+	return names
+}
+
+// Refresh re-evaluates the specification against the live interfaces, and returns which ones were added and removed;
+// if discovery fails, the literal specification is resolved and the discovery error is returned.
+func (r *Resolver) Refresh() (added, removed []string, err error) {
+	resolved, err := r.discover()
+	literal := err != nil
+	if literal {
+		resolved = r.literalInterfaces()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := names(r.resolved)
+	current := names(resolved)
+	for _, name := range current {
+		if !slices.Contains(previous, name) {
+			added = append(added, name)
+		}
+	}
+	for _, name := range previous {
+		if !slices.Contains(current, name) {
+			removed = append(removed, name)
+		}
+	}
+
+	r.resolved = resolved
+	r.literal = literal
+	return added, removed, err
+}
+
+// Interfaces returns the interfaces resolved by the last refresh.
+func (r *Resolver) Interfaces() []Interface {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.resolved)
+}
+
+func (r *Resolver) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return names(r.resolved)
+}
+
+// IsLiteral reports whether the last refresh fell back to the literal specification.
+func (r *Resolver) IsLiteral() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.literal
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iface
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type fakeSource struct {
+	ifaces []Interface
+	err    error
+}
+
+func (s *fakeSource) Interfaces() ([]Interface, error) {
+	return s.ifaces, s.err
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		ifaces: []Interface{
+			{Name: "lo", Index: 1, Up: true, Loopback: true},
+			{Name: "eth0", Index: 2, Up: true},
+			{Name: "eth1", Index: 3, Up: false},
+			{Name: "ipvlan-eth2", Index: 4, Up: true},
+			{Name: "docker0", Index: 5, Up: true},
+		},
+	}
+}
+
+// TestResolve verifies how specifications are expanded against the live interfaces.
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		options Options
+		want    []string
+	}{
+		{"any excludes loopback", "any", Options{}, []string{"eth0", "eth1", "ipvlan-eth2", "docker0"}},
+		{"any includes loopback when requested", "ANY", Options{IncludeLoopback: true}, []string{"lo", "eth0", "eth1", "ipvlan-eth2", "docker0"}},
+		{"any excludes down", "any", Options{ExcludeDown: true}, []string{"eth0", "ipvlan-eth2", "docker0"}},
+		{"prefix", "eth", Options{}, []string{"eth0", "eth1", "ipvlan-eth2"}},
+		{"glob", "eth*", Options{}, []string{"eth0", "eth1"}},
+		{"character class glob", "eth[1-9]", Options{}, []string{"eth1"}},
+		{"explicit loopback", "lo,docker*", Options{}, []string{"lo", "docker0"}},
+		{"loopback glob", "l*", Options{}, []string{"lo"}},
+		{"exact name", "docker0", Options{}, []string{"docker0"}},
+		{"no match", "wlan*", Options{}, []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := ParseSpec(tc.spec)
+			if err != nil {
+				t.Fatalf("ParseSpec(%q) failed: %v", tc.spec, err)
+			}
+			r := NewResolver(spec, newFakeSource(), tc.options)
+			if _, _, err := r.Refresh(); err != nil {
+				t.Fatalf("Refresh() failed: %v", err)
+			}
+			if got := r.Names(); !slices.Equal(got, tc.want) {
+				t.Errorf("Names() = %v, want %v", got, tc.want)
+			}
+			if r.IsLiteral() {
+				t.Error("IsLiteral() = true, want false")
+			}
+		})
+	}
+}
+
+// TestParseSpec verifies that empty entries are ignored.
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec(" eth0, ,lo ")
+	if err != nil {
+		t.Fatalf("ParseSpec failed: %v", err)
+	}
+	if want := []string{"eth0", "lo"}; !slices.Equal(spec, want) {
+		t.Errorf("ParseSpec = %v, want %v", spec, want)
+	}
+	if _, err := ParseSpec(" , "); !errors.Is(err, EmptySpecError) {
+		t.Errorf("ParseSpec(empty) error = %v, want %v", err, EmptySpecError)
+	}
+}
+
+// TestRefreshHotPlug verifies that interfaces added and removed after startup are picked up.
+func TestRefreshHotPlug(t *testing.T) {
+	source := newFakeSource()
+	r := NewResolver([]string{"eth*"}, source, Options{})
+
+	added, removed, _ := r.Refresh()
+	if !slices.Equal(added, []string{"eth0", "eth1"}) || len(removed) != 0 {
+		t.Fatalf("initial Refresh() = +%v -%v", added, removed)
+	}
+
+	source.ifaces = append(source.ifaces[:2], Interface{Name: "eth3", Index: 6, Up: true})
+	added, removed, _ = r.Refresh()
+	if !slices.Equal(added, []string{"eth3"}) || !slices.Equal(removed, []string{"eth1"}) {
+		t.Errorf("Refresh() = +%v -%v, want +[eth3] -[eth1]", added, removed)
+	}
+	if want := []string{"eth0", "eth3"}; !slices.Equal(r.Names(), want) {
+		t.Errorf("Names() = %v, want %v", r.Names(), want)
+	}
+
+	added, removed, _ = r.Refresh()
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("unchanged Refresh() = +%v -%v", added, removed)
+	}
+}
+
+// TestRefreshFallback verifies that discovery failures resolve the literal specification.
+func TestRefreshFallback(t *testing.T) {
+	discoveryErr := errors.New("netlink unavailable")
+	source := &fakeSource{err: discoveryErr}
+	r := NewResolver([]string{"any", "eth*", "lo"}, source, Options{})
+
+	if _, _, err := r.Refresh(); !errors.Is(err, discoveryErr) {
+		t.Errorf("Refresh() error = %v, want %v", err, discoveryErr)
+	}
+	if want := []string{"any", "lo"}; !slices.Equal(r.Names(), want) {
+		t.Errorf("Names() = %v, want %v", r.Names(), want)
+	}
+	if !r.IsLiteral() {
+		t.Error("IsLiteral() = false, want true")
+	}
+
+	source.err = nil
+	source.ifaces = newFakeSource().ifaces
+	if _, _, err := r.Refresh(); err != nil {
+		t.Fatalf("Refresh() failed: %v", err)
+	}
+	if r.IsLiteral() {
+		t.Error("IsLiteral() = true after recovery, want false")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snaplen defines the number of bytes captured from each packet, so that
+// the modules producing PCAP files and the ones reading them agree on it.
+package snaplen
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// Default is the capture length used when snaplen is `0`;
+	// it is tcpdump's default, and the one assumed when reading PCAP files.
+	Default = 262144
+	// Max is the largest capture length accepted by tcpdump.
+	Max = 262144
+	// MinUntruncated is the Ethernet MTU plus link-layer headers (14 bytes + 4 bytes VLAN tag);
+	// smaller capture lengths silently truncate full-sized packets.
+	MinUntruncated = 1500 + 14 + 4
+)
+
+var ErrInvalid = errors.New("invalid snaplen")
+
+// Resolve applies `Default` to `0`, and rejects negative values and values above `Max`.
+func Resolve(
+	snaplen int,
+) (int, error) {
+	if snaplen == 0 {
+		return Default, nil
+	}
+	if snaplen < 0 || snaplen > Max {
+		return 0, fmt.Errorf("%w: must be between 0 and %d: %d", ErrInvalid, Max, snaplen)
+	}
+	return snaplen, nil
+}
+
+// IsTruncating reports whether `snaplen` is too small to capture full-sized packets.
+func IsTruncating(
+	snaplen int,
+) bool {
+	return snaplen < MinUntruncated
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snaplen
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestResolve verifies that `0` is replaced by the default, and that values out of range are rejected.
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		snaplen int
+		want    int
+		wantErr error
+	}{
+		{"default", 0, Default, nil},
+		{"explicit", 65536, 65536, nil},
+		{"max", Max, Max, nil},
+		{"negative", -1, 0, ErrInvalid},
+		{"above max", Max + 1, 0, ErrInvalid},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Resolve(tc.snaplen)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("got %d, %v, want %d, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestIsTruncating verifies that capture lengths smaller than MTU plus headers are reported.
+func TestIsTruncating(t *testing.T) {
+	tests := []struct {
+		name    string
+		snaplen int
+		want    bool
+	}{
+		{"headers only", 96, true},
+		{"MTU without headers", 1500, true},
+		{"MTU with headers", MinUntruncated, false},
+		{"default", Default, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := IsTruncating(tc.snaplen); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+// eventTypes are all event types; bumping a version requires regenerating its schema using `go generate`.
+var eventTypes = []EventType{
+	{PCAP_FSNINI, 1, "initialization and configuration", func() Payload { return &FsnIni{} }},
+	{PCAP_FSNEND, 1, "shutdown and its summary", func() Payload { return &FsnEnd{} }},
+	{PCAP_FSNERR, 2, "failures to watch, flush, or export PCAP files", func() Payload { return &FsnErr{} }},
+	{PCAP_CREATE, 2, "new PCAP files", func() Payload { return &Create{} }},
+	{PCAP_EXPORT, 2, "exports of PCAP files", func() Payload { return &Export{} }},
+	{PCAP_QUEUED, 2, "PCAP files queued for export", func() Payload { return &Queued{} }},
+	{PCAP_OSWMEM, 1, "flushes of OS file write buffers", func() Payload { return &OsWMem{} }},
+	{PCAP_SIGNAL, 1, "signals and operator commands", func() Payload { return &Signal{} }},
+	{PCAP_FSLOCK, 1, "PCAP lock file", func() Payload { return &FsLock{} }},
+	{PCAP_MFLUSH, 1, "manual flushes", func() Payload { return &MFlush{} }},
+	{PCAP_SCHEDL, 1, "scheduled tasks", func() Payload { return &Schedl{} }},
+	{PCAP_RMOUNT, 1, "availability of the destination directory", func() Payload { return &RMount{} }},
+	{PCAP_SLO, 1, "durability latency", func() Payload { return &SLO{} }},
+	{PCAP_IFACES, 1, "network interfaces", func() Payload { return &Ifaces{} }},
+	{PCAP_PRESSURE, 1, "pressure-aware exports", func() Payload { return &Pressure{} }},
+	{PCAP_ANALYSIS, 1, "post-processing of PCAP files", func() Payload { return &Analysis{} }},
+	{PCAP_MIRROR, 1, "local copies of PCAP files", func() Payload { return &Mirror{} }},
+	{PCAP_CHKPNT, 1, "checkpoints of PCAP files", func() Payload { return &Chkpnt{} }},
+	{PCAP_BACKFILL, 1, "backfill of pending PCAP files", func() Payload { return &Backfill{} }},
+	{PCAP_WINDOW, 1, "capture windows", func() Payload { return &Window{} }},
+	{PCAP_FETCH, 1, "retrieval of exported PCAP files", func() Payload { return &Fetch{} }},
+	{PCAP_SAMPLE, 1, "session sampling", func() Payload { return &Sample{} }},
+	{PCAP_CANARY, 1, "characterization of the destination", func() Payload { return &Canary{} }},
+	{PCAP_CODECS, 1, "comparison of compression codecs", func() Payload { return &Codecs{} }},
+	{PCAP_FSTRACE, 1, "all events of the watched directory", func() Payload { return &FsTrace{} }},
+	{PCAP_EVENTS, 1, "buffering of events of the watched directory", func() Payload { return &Events{} }},
+}
+
+type (
+	FsnIni struct {
+		Base
+		Directory   string   `json:"directory,omitempty"`
+		Latency     string   `json:"latency,omitempty"`
+		Elapsed     string   `json:"elapsed,omitempty"`
+		Timeout     string   `json:"timeout,omitempty"`
+		PrefixShard any      `json:"prefix_shard,omitempty"`
+		Name        string   `json:"name,omitempty"`
+		Value       string   `json:"value,omitempty"`
+		Default     any      `json:"default,omitempty"`
+		Env         any      `json:"env,omitempty"`
+		Flags       any      `json:"flags,omitempty"`
+		GcsKMSKey   string   `json:"gcs_kms_key,omitempty"`
+		Files       *int     `json:"files,omitempty"`
+		WatchMode   string   `json:"watch_mode,omitempty"`
+		Ready       *bool    `json:"ready,omitempty"`
+		Watched     []string `json:"watched,omitempty"`
+		SelfTest    *bool    `json:"selftest,omitempty"`
+		Filter      *string  `json:"filter,omitempty"`
+		Compiles    *bool    `json:"compiles,omitempty"`
+		// Args are the effective flags at startup
+		Args map[string]any `json:"-" telemetry:"inline"`
+	}
+
+	FsnEnd struct {
+		Base
+		Results       any          `json:"results,omitempty"`
+		Latency       string       `json:"latency,omitempty"`
+		Cause         string       `json:"cause,omitempty"`
+		Files         *uint32      `json:"files,omitempty"`
+		Timestamp     string       `json:"timestamp,omitempty"`
+		Manifest      any          `json:"manifest,omitempty"`
+		Shutdown      any          `json:"shutdown,omitempty"`
+		Gaps          any          `json:"gaps,omitempty"`
+		SLO           any          `json:"slo,omitempty"`
+		Compression   *Compression `json:"compression,omitempty"`
+		ActiveFlush   any          `json:"active_flush,omitempty"`
+		ExportLatency any          `json:"export_latency,omitempty"`
+		ExportedBytes *int64       `json:"exported_bytes,omitempty"`
+		Lost          any          `json:"lost,omitempty"`
+	}
+
+	// Compression is the total size of exported PCAP files before and after compression.
+	Compression struct {
+		OrigBytes int64 `json:"orig_bytes"`
+		CompBytes int64 `json:"comp_bytes"`
+	}
+
+	FsnErr struct {
+		Base
+		WithFs
+		WithEventID
+		Key      string  `json:"key,omitempty"`
+		Interval string  `json:"interval,omitempty"`
+		Previous string  `json:"previous,omitempty"`
+		Current  string  `json:"current,omitempty"`
+		Missing  *uint64 `json:"missing,omitempty"`
+		Gaps     *uint64 `json:"gaps,omitempty"`
+		Closed   *bool   `json:"closed,omitempty"`
+		Filter   *string `json:"filter,omitempty"`
+		Compiles *bool   `json:"compiles,omitempty"`
+	}
+
+	Create struct {
+		Base
+		WithFs
+		WithEventID
+	}
+
+	Export struct {
+		Base
+		WithFs
+		WithEventID
+		ExportLatency any      `json:"export_latency,omitempty"`
+		Bucket        string   `json:"bucket,omitempty"`
+		KMSKey        string   `json:"kms_key,omitempty"`
+		DefaultKMSKey string   `json:"default_kms_key,omitempty"`
+		Project       string   `json:"project,omitempty"`
+		Endpoint      string   `json:"endpoint,omitempty"`
+		Address       string   `json:"address,omitempty"`
+		Local         string   `json:"local,omitempty"`
+		Remote        string   `json:"remote,omitempty"`
+		Stream        string   `json:"stream,omitempty"`
+		State         string   `json:"state,omitempty"`
+		Source        string   `json:"source,omitempty"`
+		Target        string   `json:"target,omitempty"`
+		Destination   string   `json:"destination,omitempty"`
+		File          string   `json:"file,omitempty"`
+		Attempt       *int     `json:"attempt,omitempty"`
+		FastFails     *uint64  `json:"fast_fails,omitempty"`
+		Index         string   `json:"index,omitempty"`
+		Sessions      *int     `json:"sessions,omitempty"`
+		Attempts      *int     `json:"attempts,omitempty"`
+		Manifest      any      `json:"manifest,omitempty"`
+		OrigBytes     *int64   `json:"orig_bytes,omitempty"`
+		CompBytes     *int64   `json:"comp_bytes,omitempty"`
+		Ratio         *float64 `json:"ratio,omitempty"`
+		Decision      any      `json:"decision,omitempty"`
+		NextLevel     *int     `json:"next_level,omitempty"`
+		// Labels are the labels of the bucket
+		Labels map[string]string `json:"-" telemetry:"inline"`
+	}
+
+	Queued struct {
+		Base
+		WithFs
+		WithEventID
+	}
+
+	OsWMem struct {
+		Base
+		Before   *uint64 `json:"before,omitempty"`
+		After    *uint64 `json:"after,omitempty"`
+		Released *int64  `json:"released,omitempty"`
+		Trigger  string  `json:"trigger,omitempty"`
+	}
+
+	Signal struct {
+		Base
+		Command   string `json:"command,omitempty"`
+		Signal    any    `json:"signal,omitempty"`
+		Timestamp string `json:"timestamp,omitempty"`
+		Files     *int   `json:"files,omitempty"`
+	}
+
+	FsLock struct {
+		Base
+		Lock       string `json:"lock,omitempty"`
+		ShortLived any    `json:"short_lived,omitempty"`
+		Latency    string `json:"latency,omitempty"`
+	}
+
+	MFlush struct {
+		Base
+		Files     *int    `json:"files,omitempty"`
+		Flushed   *uint32 `json:"flushed,omitempty"`
+		Timestamp string  `json:"timestamp,omitempty"`
+		Latency   string  `json:"latency,omitempty"`
+	}
+
+	Schedl struct {
+		Base
+		Task     string `json:"task,omitempty"`
+		Interval string `json:"interval,omitempty"`
+		Tasks    any    `json:"tasks,omitempty"`
+	}
+
+	RMount struct {
+		Base
+		Directory string `json:"directory,omitempty"`
+		// DirectoryEvent is the availability change of the destination directory
+		DirectoryEvent string `json:"directory_event,omitempty"`
+	}
+
+	SLO struct {
+		Base
+		SLO any `json:"slo,omitempty"`
+	}
+
+	Ifaces struct {
+		Base
+		Interfaces any      `json:"interfaces,omitempty"`
+		Added      []string `json:"added,omitempty"`
+		Removed    []string `json:"removed,omitempty"`
+		Literal    *bool    `json:"literal,omitempty"`
+	}
+
+	Pressure struct {
+		Base
+		Readings  any      `json:"readings,omitempty"`
+		Threshold *float64 `json:"threshold,omitempty"`
+		Throttled *bool    `json:"throttled,omitempty"`
+	}
+
+	Analysis struct {
+		Base
+		WithFs
+		Processor string `json:"processor,omitempty"`
+		Target    string `json:"target,omitempty"`
+	}
+
+	Mirror struct {
+		Base
+		WithFs
+		Source  string   `json:"source,omitempty"`
+		Linked  *bool    `json:"linked,omitempty"`
+		Removed []string `json:"removed,omitempty"`
+		Window  string   `json:"window,omitempty"`
+	}
+
+	Chkpnt struct {
+		Base
+		WithFs
+		Mode string `json:"mode,omitempty"`
+	}
+
+	Backfill struct {
+		Base
+		Progress any    `json:"progress,omitempty"`
+		Limits   string `json:"limits,omitempty"`
+	}
+
+	Window struct {
+		Base
+		// WindowEvent is the transition of the capture window
+		WindowEvent string  `json:"window_event,omitempty"`
+		Window      any     `json:"window,omitempty"`
+		Duration    string  `json:"duration,omitempty"`
+		Files       *int    `json:"files,omitempty"`
+		Flushed     *uint32 `json:"flushed,omitempty"`
+	}
+
+	Fetch struct {
+		Base
+		Session   string `json:"session,omitempty"`
+		MaxBytes  *int64 `json:"max_bytes,omitempty"`
+		PerMinute *uint  `json:"per_minute,omitempty"`
+		Who       string `json:"who,omitempty"`
+		UserAgent string `json:"user_agent,omitempty"`
+		Name      string `json:"name,omitempty"`
+		Range     string `json:"range,omitempty"`
+		Status    *int   `json:"status,omitempty"`
+		Bytes     *int64 `json:"bytes,omitempty"`
+		Timestamp string `json:"timestamp,omitempty"`
+		Latency   string `json:"latency,omitempty"`
+	}
+
+	Sample struct {
+		Base
+		WithFs
+		Command  string `json:"command,omitempty"`
+		Sampling any    `json:"sampling,omitempty"`
+	}
+
+	Canary struct {
+		Base
+		Canary any `json:"canary,omitempty"`
+	}
+
+	Codecs struct {
+		Base
+		WithFs
+		Source string `json:"source,omitempty"`
+		Bytes  *int64 `json:"bytes,omitempty"`
+		Level  *int   `json:"level,omitempty"`
+		Codecs any    `json:"codecs,omitempty"`
+	}
+
+	// FsTrace is a single event of the watched directory, including those which are ignored
+	FsTrace struct {
+		Base
+		WithFs
+		Op      string `json:"op,omitempty"`
+		Matched *bool  `json:"matched,omitempty"`
+	}
+
+	Events struct {
+		Base
+		Queue    any    `json:"queue,omitempty"`
+		Interval string `json:"interval,omitempty"`
+	}
+)
+
+func (*FsnIni) Event() Event   { return PCAP_FSNINI }
+func (*FsnEnd) Event() Event   { return PCAP_FSNEND }
+func (*FsnErr) Event() Event   { return PCAP_FSNERR }
+func (*Create) Event() Event   { return PCAP_CREATE }
+func (*Export) Event() Event   { return PCAP_EXPORT }
+func (*Queued) Event() Event   { return PCAP_QUEUED }
+func (*OsWMem) Event() Event   { return PCAP_OSWMEM }
+func (*Signal) Event() Event   { return PCAP_SIGNAL }
+func (*FsLock) Event() Event   { return PCAP_FSLOCK }
+func (*MFlush) Event() Event   { return PCAP_MFLUSH }
+func (*Schedl) Event() Event   { return PCAP_SCHEDL }
+func (*RMount) Event() Event   { return PCAP_RMOUNT }
+func (*SLO) Event() Event      { return PCAP_SLO }
+func (*Ifaces) Event() Event   { return PCAP_IFACES }
+func (*Pressure) Event() Event { return PCAP_PRESSURE }
+func (*Analysis) Event() Event { return PCAP_ANALYSIS }
+func (*Mirror) Event() Event   { return PCAP_MIRROR }
+func (*Chkpnt) Event() Event   { return PCAP_CHKPNT }
+func (*Backfill) Event() Event { return PCAP_BACKFILL }
+func (*Window) Event() Event   { return PCAP_WINDOW }
+func (*Fetch) Event() Event    { return PCAP_FETCH }
+func (*Sample) Event() Event   { return PCAP_SAMPLE }
+func (*Canary) Event() Event   { return PCAP_CANARY }
+func (*Codecs) Event() Event   { return PCAP_CODECS }
+func (*FsTrace) Event() Event  { return PCAP_FSTRACE }
+func (*Events) Event() Event   { return PCAP_EVENTS }
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// gen writes the JSON Schema of the current version of every event type into `schemas/`;
+// it refuses to change the schema of an existing version: changing a payload requires bumping its version.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+)
+
+func main() {
+	var errs []error
+	for _, t := range telemetry.Types() {
+		schema, err := t.Generate()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		path := filepath.Join("schemas", telemetry.SchemaFile(t.Event, t.Version))
+		current, err := os.ReadFile(path)
+		if err == nil {
+			if !bytes.Equal(current, schema) {
+				errs = append(errs, fmt.Errorf("schema of %s version %d changed: bump its version", t.Event, t.Version))
+			}
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(path, schema, 0o644); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"embed"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const metaSchema = "https://json-schema.org/draft/2020-12/schema"
+
+//go:embed schemas/*.json
+var schemas embed.FS
+
+var (
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+	textMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType      = reflect.TypeFor[time.Time]()
+)
+
+// SchemaFile returns the name of the file holding the schema of `version` of `event`.
+func SchemaFile(
+	event Event,
+	version int,
+) string {
+	return fmt.Sprintf("%s.v%d.json", event, version)
+}
+
+// Generate returns the JSON Schema of the current version of the event type, as it is stored at `schemas/`.
+func (t EventType) Generate() ([]byte, error) {
+	payload := reflect.TypeOf(t.New()).Elem()
+
+	schema := structSchema(payload)
+	properties := schema["properties"].(map[string]any)
+	properties["event"] = map[string]any{"const": t.Event}
+	properties["schema_version"] = map[string]any{"const": t.Version}
+	properties["error"] = map[string]any{"type": "string"}
+	schema["required"] = []string{"event", "schema_version"}
+
+	// inline fields are merged into the payload: their entries are the only additional properties
+	if inline := inlineFields(payload); len(inline) > 0 {
+		schema["additionalProperties"] = typeSchema(inline[0].Type.Elem())
+	}
+
+	schema["$schema"] = metaSchema
+	schema["$id"] = SchemaFile(t.Event, t.Version)
+	schema["title"] = string(t.Event)
+	schema["description"] = t.Description
+
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Schema returns the embedded JSON Schema of `version` of `event`.
+func Schema(
+	event Event,
+	version int,
+) ([]byte, error) {
+	return schemas.ReadFile("schemas/" + SchemaFile(event, version))
+}
+
+// DumpSchemas writes the embedded JSON Schemas of the current version of all event types, keyed by event type.
+func DumpSchemas(
+	w io.Writer,
+) error {
+	all := make(map[Event]json.RawMessage, len(eventTypes))
+	for _, t := range eventTypes {
+		schema, err := Schema(t.Event, t.Version)
+		if err != nil {
+			return err
+		}
+		all[t.Event] = schema
+	}
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func inlineFields(
+	t reflect.Type,
+) []reflect.StructField {
+	var fields []reflect.StructField
+	for _, field := range reflect.VisibleFields(t) {
+		if field.Tag.Get("telemetry") == "inline" && field.Type.Kind() == reflect.Map {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// structSchema mirrors `encoding/json`: fields of embedded structs are promoted, and `-` fields are skipped.
+func structSchema(
+	t reflect.Type,
+) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous && field.Type.Kind() == reflect.Struct {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func typeSchema(
+	t reflect.Type,
+) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler):
+		return map[string]any{}
+	case t.Implements(textMarshaler) || reflect.PointerTo(t).Implements(textMarshaler):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	// interfaces are free-form
+	return map[string]any{}
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telemetry defines the contract of structured log events: every event type has a typed payload,
+// which is emitted as the `data` of its log entries along with the schema version of the event type.
+//
+// The JSON Schema of every version of every event type is kept at `schemas/` and embedded in binaries;
+// changing a payload requires bumping the version of its event type and running `go generate`.
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+//go:generate go run ./internal/gen
+
+type Event string
+
+const (
+	PCAP_FSNINI   Event = "PCAP_FSNINI"
+	PCAP_FSNEND   Event = "PCAP_FSNEND"
+	PCAP_FSNERR   Event = "PCAP_FSNERR"
+	PCAP_CREATE   Event = "PCAP_CREATE"
+	PCAP_EXPORT   Event = "PCAP_EXPORT"
+	PCAP_QUEUED   Event = "PCAP_QUEUED"
+	PCAP_OSWMEM   Event = "PCAP_OSWMEM"
+	PCAP_SIGNAL   Event = "PCAP_SIGNAL"
+	PCAP_FSLOCK   Event = "PCAP_FSLOCK"
+	PCAP_MFLUSH   Event = "PCAP_MFLUSH"
+	PCAP_SCHEDL   Event = "PCAP_SCHEDL"
+	PCAP_RMOUNT   Event = "PCAP_RMOUNT"
+	PCAP_SLO      Event = "PCAP_SLO"
+	PCAP_IFACES   Event = "PCAP_IFACES"
+	PCAP_PRESSURE Event = "PCAP_PRESSURE"
+	PCAP_ANALYSIS Event = "PCAP_ANALYSIS"
+	PCAP_MIRROR   Event = "PCAP_MIRROR"
+	PCAP_CHKPNT   Event = "PCAP_CHKPNT"
+	PCAP_BACKFILL Event = "PCAP_BACKFILL"
+	PCAP_WINDOW   Event = "PCAP_WINDOW"
+	PCAP_FETCH    Event = "PCAP_FETCH"
+	PCAP_SAMPLE   Event = "PCAP_SAMPLE"
+	PCAP_CANARY   Event = "PCAP_CANARY"
+	PCAP_CODECS   Event = "PCAP_CODECS"
+	PCAP_FSTRACE  Event = "PCAP_FSTRACE"
+	PCAP_EVENTS   Event = "PCAP_EVENTS"
+)
+
+// Payload is the `data` of a structured log event; payloads are defined by this package only.
+type Payload interface {
+	Event() Event
+	base() *Base
+}
+
+// Base is embedded by every payload: `extra` carries free-form fields which are not part of the contract
+// of the event type, so that they can be logged before being promoted into a new schema version.
+type Base struct {
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+func (b *Base) base() *Base {
+	return b
+}
+
+// Fs describes the file operation of events about PCAP files.
+type Fs struct {
+	Source string `json:"source,omitempty"`
+	Target string `json:"target,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+}
+
+// WithFs is embedded by payloads of events about PCAP files.
+type WithFs struct {
+	Fs *Fs `json:"fs,omitempty"`
+}
+
+func (w *WithFs) setFs(fs *Fs) {
+	w.Fs = fs
+}
+
+// WithEventID is embedded by payloads of events about the export of a PCAP file.
+type WithEventID struct {
+	// EventID correlates all events about a PCAP file, from it being detected to it being exported
+	EventID string `json:"event_id,omitempty"`
+}
+
+func (w *WithEventID) setEventID(id string) {
+	w.EventID = id
+}
+
+// EventType describes the current version of the payload of an event type.
+type EventType struct {
+	Event       Event
+	Version     int
+	Description string
+
+	new func() Payload
+}
+
+// New returns an empty payload of the event type.
+func (t EventType) New() Payload {
+	return t.new()
+}
+
+// Types returns all event types.
+func Types() []EventType {
+	return eventTypes
+}
+
+// Lookup returns the event type of `event`.
+func Lookup(event Event) (EventType, bool) {
+	for _, t := range eventTypes {
+		if t.Event == event {
+			return t, true
+		}
+	}
+	return EventType{}, false
+}
+
+// New returns an empty payload of `event`; it returns nil if `event` is unknown.
+func New(event Event) Payload {
+	if t, ok := Lookup(event); ok {
+		return t.New()
+	}
+	return nil
+}
+
+// SetFs sets the file operation of `payload`;
+// payloads of events which are not about PCAP files carry it in `extra`.
+func SetFs(payload Payload, fs *Fs) {
+	if p, ok := payload.(interface{ setFs(*Fs) }); ok {
+		p.setFs(fs)
+		return
+	}
+	b := payload.base()
+	if b.Extra == nil {
+		b.Extra = make(map[string]any, 1)
+	}
+	b.Extra["fs"] = fs
+}
+
+// SetEventID sets the ID which correlates `payload` to the detection of its PCAP file;
+// payloads of events which are not about exports carry it in `extra`.
+func SetEventID(payload Payload, id string) {
+	if p, ok := payload.(interface{ setEventID(string) }); ok {
+		p.setEventID(id)
+		return
+	}
+	b := payload.base()
+	if b.Extra == nil {
+		b.Extra = make(map[string]any, 1)
+	}
+	b.Extra["event_id"] = id
+}
+
+// Ptr returns a pointer to `v`; payloads use pointers for fields whose zero value is meaningful.
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Data returns the `data` of a log entry: the fields of `payload`, along with its event type,
+// the schema version of its event type, and `err` if any.
+func Data(
+	payload Payload,
+	err error,
+) map[string]any {
+	event := payload.Event()
+
+	data := make(map[string]any)
+	if b, marshalErr := json.Marshal(payload); marshalErr == nil {
+		// numbers are kept verbatim: large integers such as byte counts must not become floats
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		decoder.Decode(&data)
+	} else {
+		data["extra"] = map[string]any{"marshal_error": marshalErr.Error()}
+	}
+
+	// inline fields are merged into the payload without replacing any of its fields
+	v := reflect.ValueOf(payload).Elem()
+	for _, field := range inlineFields(v.Type()) {
+		iter := v.FieldByIndex(field.Index).MapRange()
+		for iter.Next() {
+			if key := iter.Key().String(); data[key] == nil {
+				data[key] = iter.Value().Interface()
+			}
+		}
+	}
+
+	data["event"] = event
+	if t, ok := Lookup(event); ok {
+		data["schema_version"] = t.Version
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	return data
+}
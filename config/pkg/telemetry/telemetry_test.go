@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSchemasAreCurrent verifies that payloads match the embedded schema of their current version:
+// changing a payload requires bumping the version of its event type.
+func TestSchemasAreCurrent(t *testing.T) {
+	for _, eventType := range Types() {
+		generated, err := eventType.Generate()
+		if err != nil {
+			t.Fatalf("%s: %v", eventType.Event, err)
+		}
+		embedded, err := Schema(eventType.Event, eventType.Version)
+		if err != nil {
+			t.Errorf("%s: missing schema of version %d: run `go generate ./pkg/telemetry`", eventType.Event, eventType.Version)
+			continue
+		}
+		if !bytes.Equal(generated, embedded) {
+			t.Errorf("%s: payload does not match the schema of version %d: bump its version and run `go generate ./pkg/telemetry`",
+				eventType.Event, eventType.Version)
+		}
+	}
+}
+
+// TestSchemasAreKept verifies that schemas of previous versions are kept, so that older log entries can still be validated.
+func TestSchemasAreKept(t *testing.T) {
+	for _, eventType := range Types() {
+		for version := 1; version <= eventType.Version; version++ {
+			if _, err := Schema(eventType.Event, version); err != nil {
+				t.Errorf("%s: missing schema of version %d", eventType.Event, version)
+			}
+		}
+	}
+}
+
+func TestDataValidates(t *testing.T) {
+	for _, eventType := range Types() {
+		empty := eventType.New()
+		populated := eventType.New()
+		populate(reflect.ValueOf(populated).Elem())
+		SetFs(populated, &Fs{Source: "/pcap/a.pcap", Target: "/gcs/a.pcap.gz", Bytes: 1 << 40})
+
+		for _, entry := range []map[string]any{Data(empty, nil), Data(populated, errors.New("failed"))} {
+			b, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatalf("%s: %v", eventType.Event, err)
+			}
+			if err := Validate(b); err != nil {
+				t.Errorf("%s: %v: %s", eventType.Event, err, b)
+			}
+		}
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  string
+	}{
+		{"unknown property", `{"event":"PCAP_CREATE","schema_version":1,"source":"a.pcap"}`, "unknown property"},
+		{"wrong type", `{"event":"PCAP_EXPORT","schema_version":1,"attempt":"1"}`, "want integer"},
+		{"nested", `{"event":"PCAP_EXPORT","schema_version":1,"fs":{"bytes":1.5}}`, "data.fs.bytes"},
+		{"unknown version", `{"event":"PCAP_CREATE","schema_version":99}`, "no schema"},
+		{"unknown event", `{"event":"PCAP_UNKNOWN","schema_version":1}`, "no schema"},
+		{"missing version", `{"event":"PCAP_CREATE"}`, "invalid schema_version"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate([]byte(tc.entry))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Validate(%s) = %v; want error containing %q", tc.entry, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestData(t *testing.T) {
+	payload := &Export{
+		Bucket:    "bucket",
+		OrigBytes: Ptr(int64(1<<53 + 1)),
+		Labels:    map[string]string{"bucket": "label", "team": "network"},
+	}
+	data := Data(payload, errors.New("failed"))
+
+	b, _ := json.Marshal(data)
+	for _, want := range []string{
+		`"event":"PCAP_EXPORT"`,
+		`"schema_version":2`,
+		`"error":"failed"`,
+		`"orig_bytes":9007199254740993`,
+		// labels do not replace fields
+		`"bucket":"bucket"`,
+		`"team":"network"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Data() = %s; want %s", b, want)
+		}
+	}
+}
+
+func TestSetFs(t *testing.T) {
+	fs := &Fs{Source: "a.pcap"}
+
+	export := &Export{}
+	SetFs(export, fs)
+	if export.Fs != fs {
+		t.Errorf("SetFs(Export) did not set fs")
+	}
+
+	// events which are not about PCAP files carry it in `extra`
+	signal := &Signal{}
+	SetFs(signal, fs)
+	if signal.Extra["fs"] != fs {
+		t.Errorf("SetFs(Signal) = %v; want fs in extra", signal.Extra)
+	}
+}
+
+func TestSetEventID(t *testing.T) {
+	queued := &Queued{}
+	SetEventID(queued, "0a1b2c3d")
+	if queued.EventID != "0a1b2c3d" {
+		t.Errorf("SetEventID(Queued) did not set event_id")
+	}
+
+	// events which are not about exports carry it in `extra`
+	mirror := &Mirror{}
+	SetEventID(mirror, "0a1b2c3d")
+	if mirror.Extra["event_id"] != "0a1b2c3d" {
+		t.Errorf("SetEventID(Mirror) = %v; want event_id in extra", mirror.Extra)
+	}
+}
+
+func TestDumpSchemas(t *testing.T) {
+	var out bytes.Buffer
+	if err := DumpSchemas(&out); err != nil {
+		t.Fatal(err)
+	}
+	var all map[Event]map[string]any
+	if err := json.Unmarshal(out.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(Types()) {
+		t.Errorf("DumpSchemas() = %d schemas; want %d", len(all), len(Types()))
+	}
+}
+
+// populate sets every field of `v` to a non-zero value.
+func populate(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		populate(v.Elem())
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				populate(v.Field(i))
+			}
+		}
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		v.SetInt(1 << 30)
+	case reflect.Uint, reflect.Uint64, reflect.Uint32:
+		v.SetUint(1 << 30)
+	case reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		populate(v.Index(0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		value := reflect.New(v.Type().Elem()).Elem()
+		populate(value)
+		v.SetMapIndex(reflect.ValueOf("key"), value)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(map[string]any{"key": []any{1, "value"}}))
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Validate checks the `data` of a log entry against the embedded schema of its event type and schema version;
+// it supports the subset of JSON Schema used by generated schemas.
+func Validate(
+	data []byte,
+) error {
+	var entry map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil {
+		return err
+	}
+
+	event, _ := entry["event"].(string)
+	number, _ := entry["schema_version"].(json.Number)
+	version, err := number.Int64()
+	if err != nil {
+		return fmt.Errorf("invalid schema_version: %w", err)
+	}
+	b, err := Schema(Event(event), int(version))
+	if err != nil {
+		return fmt.Errorf("no schema for %s version %d: %w", event, version, err)
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return err
+	}
+	return validate("data", schema, entry)
+}
+
+func validate(
+	path string,
+	schema map[string]any,
+	value any,
+) error {
+	if want, ok := schema["const"]; ok && fmt.Sprint(want) != fmt.Sprint(value) {
+		return fmt.Errorf("%s: want %v, got %v", path, want, value)
+	}
+
+	if want, ok := schema["type"].(string); ok {
+		if got := typeOf(value); got != want && !(want == "number" && got == "integer") {
+			return fmt.Errorf("%s: want %s, got %s", path, want, got)
+		}
+	}
+
+	switch v := value.(type) {
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validate(fmt.Sprintf("%s[%d]", path, i), items, item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing %s", path, name)
+			}
+		}
+		for name, field := range v {
+			fieldPath := path + "." + name
+			if fieldSchema, ok := properties[name].(map[string]any); ok {
+				if err := validate(fieldPath, fieldSchema, field); err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: unknown property", fieldPath)
+				}
+			case map[string]any:
+				if err := validate(fieldPath, additional, field); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func typeOf(
+	value any,
+) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -34,7 +34,7 @@ import (
 var (
 	engine    = flag.String("eng", "google", "Engine to use for capturing packets: tcpdump or google")
 	iface     = flag.String("i", "any", "Interface to read packets from")
-	snaplen   = flag.Int("s", 0, "Snap length (number of bytes max to read per packet")
+	snaplen   = flag.Int("s", 0, "Snap length (number of bytes max to read per packet); 0 uses the default of 262144")
 	writeTo   = flag.String("w", "stdout", "Where to write packet capture to: stdout or a file path")
 	tsType    = flag.String("ts_type", "", "Type of timestamps to use")
 	promisc   = flag.Bool("promisc", true, "Set promiscuous mode")
@@ -47,7 +47,7 @@ func (p *Pcap) newPcap(ctx context.Context) (*pcap.InactiveHandle, error) {
 		gopacketLogger.Printf("could not create: %v\n", err)
 	}
 
-	if err = inactiveHandle.SetSnapLen(cfg.Snaplen); err != nil {
+	if err = inactiveHandle.SetSnapLen(snaplenOf(&cfg)); err != nil {
 		gopacketLogger.Printf("could not set snap length: %v\n", err)
 		return nil, err
 	}
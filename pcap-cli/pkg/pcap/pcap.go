@@ -144,6 +144,9 @@ const (
 
 const (
 	PcapDefaultFilter = "(tcp or udp or icmp or icmp6) and (ip or ip6 or arp)"
+	// PcapDefaultSnaplen is the capture length used when `Snaplen` is not set;
+	// it is tcpdump's default, and the one assumed by the config and pcap-fsnotify modules.
+	PcapDefaultSnaplen = 262144
 )
 
 const (
@@ -214,6 +217,14 @@ func providePcapFilter(
 	return &pcapFilter
 }
 
+// snaplenOf returns the capture length used by all engines; non-positive values fall back to `PcapDefaultSnaplen`.
+func snaplenOf(cfg *PcapConfig) int {
+	if cfg.Snaplen <= 0 {
+		return PcapDefaultSnaplen
+	}
+	return cfg.Snaplen
+}
+
 func findAllDevs(compare func(*string) bool) ([]*PcapDevice, error) {
 	devices, err := pcap.FindAllDevs()
 	if err != nil {
@@ -40,7 +40,7 @@ func (t *Tcpdump) IsActive() bool {
 func (t *Tcpdump) buildArgs(ctx context.Context) []string {
 	cfg := t.config
 
-	args := []string{"-n", "-Z", "root", "-i", cfg.Iface, "-s", fmt.Sprintf("%d", cfg.Snaplen)}
+	args := []string{"-n", "-Z", "root", "-i", cfg.Iface, "-s", fmt.Sprintf("%d", snaplenOf(cfg))}
 
 	if cfg.Output != "stdout" {
 		directory := filepath.Dir(cfg.Output)
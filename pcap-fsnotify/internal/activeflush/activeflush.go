@@ -0,0 +1,278 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package activeflush asks capture processes to flush their packet buffers before the final flush on shutdown:
+// `tcpdump` writes buffered packets into the in-progress PCAP file when it receives `SIGUSR2`.
+// It also finds the PCAP files which capture processes are writing into.
+package activeflush
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type (
+	Outcome string
+
+	// Result describes the active flush of a single interface.
+	Result struct {
+		Iface   string  `json:"iface"`
+		Outcome Outcome `json:"outcome"`
+		PIDs    []int   `json:"pids,omitempty"`
+		Reason  string  `json:"reason,omitempty"`
+		Latency string  `json:"latency,omitempty"`
+	}
+
+	Flusher struct {
+		// Timeout bounds waiting for the sizes of in-progress PCAP files to stabilize
+		Timeout time.Duration
+		// Settle is how long sizes must remain unchanged to be considered stable
+		Settle time.Duration
+
+		signal func(pid int) error
+	}
+)
+
+const (
+	OUTCOME_FLUSHED = Outcome("flushed")
+	OUTCOME_SKIPPED = Outcome("skipped")
+	OUTCOME_TIMEOUT = Outcome("timeout")
+
+	// the interface is unknown for PIDs provided via a pidfile whose command line cannot be read
+	unknownIface = ""
+
+	pollInterval = 10 * time.Millisecond
+)
+
+var (
+	errAmbiguous = errors.New("more than one capture process")
+	errNoProcess = errors.New("no capture process")
+)
+
+func NewFlusher(
+	timeout, settle time.Duration,
+) *Flusher {
+	return &Flusher{
+		Timeout: timeout,
+		Settle:  settle,
+		signal: func(pid int) error {
+			return syscall.Kill(pid, syscall.SIGUSR2)
+		},
+	}
+}
+
+// ifaceOf returns the value of the `-i` flag of a capture process command line.
+func ifaceOf(
+	args []string,
+) (string, bool) {
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) {
+			return args[i+1], true
+		}
+		if value, ok := strings.CutPrefix(arg, "--interface="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func readCmdline(
+	procDir string,
+	pid int,
+) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(bytes.TrimRight(data, "\x00")), "\x00"), nil
+}
+
+// Discover finds the PIDs of the capture processes for every interface by matching the command line
+// of all the processes in `procDir`: the executable must be named `name`, and the interface is its `-i` flag.
+func Discover(
+	procDir string,
+	name string,
+) (map[string][]int, error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make(map[string][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		// processes may exit while being listed
+		args, err := readCmdline(procDir, pid)
+		if err != nil || len(args) == 0 || filepath.Base(args[0]) != name {
+			continue
+		}
+		if iface, ok := ifaceOf(args); ok {
+			pids[iface] = append(pids[iface], pid)
+		}
+	}
+	return pids, nil
+}
+
+// ReadPIDFile reads one PID per line from `pidFile`; interfaces are read from the command line in `procDir`.
+func ReadPIDFile(
+	pidFile string,
+	procDir string,
+) (map[string][]int, error) {
+	file, err := os.Open(pidFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pids := make(map[string][]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		iface := unknownIface
+		if args, err := readCmdline(procDir, pid); err == nil {
+			iface, _ = ifaceOf(args)
+		}
+		pids[iface] = append(pids[iface], pid)
+	}
+	return pids, scanner.Err()
+}
+
+func sizeOf(
+	files []string,
+) int64 {
+	size := int64(0)
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// awaitStable waits until the size of `files` remains unchanged for `Settle`.
+func (f *Flusher) awaitStable(
+	ctx context.Context,
+	files []string,
+) bool {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	size := sizeOf(files)
+	stableSince := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		if current := sizeOf(files); current != size {
+			size, stableSince = current, time.Now()
+		} else if time.Since(stableSince) >= f.Settle {
+			return true
+		}
+	}
+}
+
+func (f *Flusher) flush(
+	ctx context.Context,
+	iface string,
+	pids []int,
+	files []string,
+) *Result {
+	start := time.Now()
+	result := &Result{
+		Iface:   iface,
+		Outcome: OUTCOME_SKIPPED,
+		PIDs:    pids,
+	}
+
+	// signaling the wrong process is worse than exporting a PCAP file which is not fully flushed
+	switch {
+	case len(pids) == 0:
+		result.Reason = errNoProcess.Error()
+		return result
+	case len(pids) > 1 || iface == unknownIface:
+		result.Reason = errAmbiguous.Error()
+		return result
+	}
+
+	if err := f.signal(pids[0]); errors.Is(err, syscall.EPERM) {
+		result.Reason = "no permission to signal the capture process"
+		return result
+	} else if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+
+	if f.awaitStable(ctx, files) {
+		result.Outcome = OUTCOME_FLUSHED
+	} else {
+		result.Outcome = OUTCOME_TIMEOUT
+	}
+	result.Latency = time.Since(start).String()
+	return result
+}
+
+// Flush signals the capture process of every interface, and waits for its in-progress PCAP files to stop growing;
+// `files` are the in-progress PCAP files of every interface. Interfaces are flushed concurrently,
+// and results are sorted by interface.
+func (f *Flusher) Flush(
+	ctx context.Context,
+	pids map[string][]int,
+	files map[string][]string,
+) []*Result {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	ifaces := []string{}
+	for iface := range pids {
+		ifaces = append(ifaces, iface)
+	}
+	for iface := range files {
+		if _, ok := pids[iface]; !ok {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	slices.Sort(ifaces)
+
+	results := make([]*Result, len(ifaces))
+	var wg sync.WaitGroup
+	for i, iface := range ifaces {
+		wg.Go(func() {
+			results[i] = f.flush(ctx, iface, pids[iface], files[iface])
+		})
+	}
+	wg.Wait()
+	return results
+}
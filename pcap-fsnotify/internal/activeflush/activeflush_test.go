@@ -0,0 +1,223 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activeflush
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is a fake capture process: it appends to its PCAP file when it receives `SIGUSR2`,
+// either a few chunks ( `flush` ), or until it is killed ( `stream` ).
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("ACTIVEFLUSH_HELPER") == "" {
+		return
+	}
+	mode, pcapFile := os.Getenv("ACTIVEFLUSH_MODE"), os.Getenv("ACTIVEFLUSH_FILE")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	os.WriteFile(pcapFile+".ready", nil, 0o644)
+	<-signals
+
+	file, err := os.OpenFile(pcapFile, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		os.Exit(1)
+	}
+	for i := 0; mode == "stream" || i < 5; i++ {
+		file.Write(make([]byte, 1024))
+		time.Sleep(20 * time.Millisecond)
+	}
+	file.Close()
+	time.Sleep(time.Minute)
+}
+
+// startHelper starts a fake capture process for `iface`, and waits for it to handle `SIGUSR2`.
+func startHelper(t *testing.T, iface, mode string) (int, string) {
+	t.Helper()
+	pcapFile := filepath.Join(t.TempDir(), fmt.Sprintf("part__1_%s__20240101T000000.pcap", iface))
+	if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "--", "-i", iface)
+	cmd.Env = append(os.Environ(), "ACTIVEFLUSH_HELPER=1", "ACTIVEFLUSH_MODE="+mode, "ACTIVEFLUSH_FILE="+pcapFile)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := os.Stat(pcapFile + ".ready"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("fake capture process did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cmd.Process.Pid, pcapFile
+}
+
+func sizeOfFile(t *testing.T, file string) int64 {
+	t.Helper()
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// TestFlush verifies that flushing waits for in-progress PCAP files to stop growing, and the outcome of every interface.
+func TestFlush(t *testing.T) {
+	flushedPID, flushedFile := startHelper(t, "eth0", "flush")
+	streamPID, streamFile := startHelper(t, "eth1", "stream")
+
+	pids := map[string][]int{
+		"eth0": {flushedPID},
+		"eth1": {streamPID},
+		// signaling is skipped when discovery is ambiguous
+		"eth2": {flushedPID, streamPID},
+		"eth3": {1},
+	}
+	files := map[string][]string{
+		"eth0": {flushedFile},
+		"eth1": {streamFile},
+		"eth4": {filepath.Join(t.TempDir(), "part__5_eth4__20240101T000000.pcap")},
+	}
+
+	flusher := NewFlusher(500*time.Millisecond, 100*time.Millisecond)
+	signal := flusher.signal
+	flusher.signal = func(pid int) error {
+		if pid == 1 {
+			return syscall.EPERM
+		}
+		return signal(pid)
+	}
+
+	results := flusher.Flush(context.Background(), pids, files)
+
+	want := map[string]Outcome{
+		"eth0": OUTCOME_FLUSHED,
+		"eth1": OUTCOME_TIMEOUT,
+		"eth2": OUTCOME_SKIPPED,
+		"eth3": OUTCOME_SKIPPED,
+		"eth4": OUTCOME_SKIPPED,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %d, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if i > 0 && results[i-1].Iface >= result.Iface {
+			t.Errorf("results are not sorted by interface")
+		}
+		if result.Outcome != want[result.Iface] {
+			t.Errorf("%s: outcome = %s (%s), want %s", result.Iface, result.Outcome, result.Reason, want[result.Iface])
+		}
+	}
+
+	// all the chunks written after `SIGUSR2` must be on disk once flushing completes
+	if size := sizeOfFile(t, flushedFile); size != 4+5*1024 {
+		t.Errorf("flushed PCAP file size = %d, want %d", size, 4+5*1024)
+	}
+}
+
+// TestDiscover verifies that capture processes are matched by executable name, and grouped by interface.
+func TestDiscover(t *testing.T) {
+	procDir := t.TempDir()
+	processes := map[int][]string{
+		10: {"/usr/sbin/tcpdump", "-n", "-i", "eth0", "-w", "/pcap-tmp/part.pcap"},
+		11: {"tcpdump", "-n", "-i", "eth1"},
+		12: {"tcpdump", "-n", "-i", "eth1"},
+		13: {"/bin/bash", "-c", "tcpdump -i eth2"},
+		14: {"tcpdump", "--version"},
+	}
+	for pid, args := range processes {
+		dir := filepath.Join(procDir, strconv.Itoa(pid))
+		os.MkdirAll(dir, 0o755)
+		cmdline := ""
+		for _, arg := range args {
+			cmdline += arg + "\x00"
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(procDir, "self"), 0o755)
+
+	pids, err := Discover(procDir, "tcpdump")
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+	slices.Sort(pids["eth1"])
+	if len(pids) != 2 || !slices.Equal(pids["eth0"], []int{10}) || !slices.Equal(pids["eth1"], []int{11, 12}) {
+		t.Errorf("Discover() = %v, want eth0:[10] eth1:[11 12]", pids)
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "tcpdump.pid")
+	os.WriteFile(pidFile, []byte("10\n99\n"), 0o644)
+	pids, err = ReadPIDFile(pidFile, procDir)
+	if err != nil {
+		t.Fatalf("ReadPIDFile() failed: %v", err)
+	}
+	if !slices.Equal(pids["eth0"], []int{10}) || !slices.Equal(pids[unknownIface], []int{99}) {
+		t.Errorf("ReadPIDFile() = %v, want eth0:[10] and an unknown interface for 99", pids)
+	}
+}
+
+// TestOpenFiles verifies that open files are read from the descriptors of every capture process,
+// and that processes which exited, and descriptors which are not files, are skipped.
+func TestOpenFiles(t *testing.T) {
+	procDir := t.TempDir()
+	descriptors := map[int]map[string]string{
+		10: {"0": "/dev/null", "3": "/pcap-tmp/part__1_eth0__20240101T000100.pcap", "4": "socket:[12345]"},
+		11: {"3": "/pcap-tmp/part__2_eth1__20240101T000100.pcap"},
+	}
+	for pid, fds := range descriptors {
+		fdDir := filepath.Join(procDir, strconv.Itoa(pid), "fd")
+		os.MkdirAll(fdDir, 0o755)
+		for fd, target := range fds {
+			if err := os.Symlink(target, filepath.Join(fdDir, fd)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	files := OpenFiles(procDir, map[string][]int{"eth0": {10, 99}, "eth1": {11}})
+	want := []string{
+		"/dev/null",
+		"/pcap-tmp/part__1_eth0__20240101T000100.pcap",
+		"/pcap-tmp/part__2_eth1__20240101T000100.pcap",
+	}
+	if len(files) != len(want) {
+		t.Errorf("OpenFiles() = %v, want %v", files, want)
+	}
+	for _, file := range want {
+		if _, ok := files[file]; !ok {
+			t.Errorf("OpenFiles() = %v, want %s", files, file)
+		}
+	}
+}
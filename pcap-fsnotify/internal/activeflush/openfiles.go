@@ -0,0 +1,46 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activeflush
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// OpenFiles returns the files held open by the processes `pids`, as listed by their descriptors in `procDir`;
+// processes which exited, or whose descriptors cannot be read, are skipped.
+func OpenFiles(
+	procDir string,
+	pids map[string][]int,
+) map[string]struct{} {
+	files := make(map[string]struct{})
+	for _, ifacePIDs := range pids {
+		for _, pid := range ifacePIDs {
+			fdDir := filepath.Join(procDir, strconv.Itoa(pid), "fd")
+			entries, err := os.ReadDir(fdDir)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				// descriptors may be closed while being listed
+				if target, err := os.Readlink(filepath.Join(fdDir, entry.Name())); err == nil && filepath.IsAbs(target) {
+					files[target] = struct{}{}
+				}
+			}
+		}
+	}
+	return files
+}
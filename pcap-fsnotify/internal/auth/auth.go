@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Token authorizes operator requests which carry it as a bearer token; it is shared by all operator endpoints.
+type Token struct {
+	token []byte
+}
+
+var errEmptyToken = errors.New("token is empty")
+
+func NewToken(
+	token string,
+) (*Token, error) {
+	if token = strings.TrimSpace(token); token == "" {
+		return nil, errEmptyToken
+	}
+	return &Token{token: []byte(token)}, nil
+}
+
+// ReadToken reads the bearer token from `tokenFile`;
+// it is read from a file so that it is neither part of the command line nor of the logged environment.
+func ReadToken(
+	tokenFile string,
+) (*Token, error) {
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewToken(string(data))
+}
+
+func (t *Token) IsAuthorized(
+	r *http.Request,
+) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), t.token) == 1
+}
+
+// Challenge rejects a request which is not authorized.
+func Challenge(
+	w http.ResponseWriter,
+) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
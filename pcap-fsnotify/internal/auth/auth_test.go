@@ -0,0 +1,83 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsAuthorized(t *testing.T) {
+	token, err := NewToken("secret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          bool
+	}{
+		{"missing", "", false},
+		{"wrong", "Bearer other", false},
+		{"prefix", "Bearer secret", false},
+		{"scheme", "Basic secret-token", false},
+		{"valid", "Bearer secret-token", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/pause", nil)
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			if got := token.IsAuthorized(r); got != tc.want {
+				t.Errorf("IsAuthorized(%q) = %v, want %v", tc.authorization, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestReadToken verifies that surrounding whitespace is not part of the token, and that empty tokens are rejected.
+func TestReadToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+
+	if err := os.WriteFile(tokenFile, []byte("secret-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ReadToken(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/files", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	if !token.IsAuthorized(r) {
+		t.Error("token read from file does not authorize its bearer")
+	}
+
+	if err := os.WriteFile(tokenFile, []byte(" \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadToken(tokenFile); err != errEmptyToken {
+		t.Errorf("ReadToken(empty) = %v, want %v", err, errEmptyToken)
+	}
+	if _, err := ReadToken(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ReadToken(missing) succeeded")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backfill paces the export of PCAP files accumulated while exports were not possible,
+// so that draining a large backlog does not compete with live exports nor saturate the destination.
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// Limits bound backfill exports; `0` disables any of them.
+	Limits struct {
+		BytesPerSecond int64
+		OpsPerSecond   float64
+	}
+
+	// Progress describes the backlog being drained.
+	Progress struct {
+		RemainingFiles int64 `json:"remaining_files"`
+		RemainingBytes int64 `json:"remaining_bytes"`
+		DrainedFiles   int64 `json:"drained_files"`
+		DrainedBytes   int64 `json:"drained_bytes"`
+		// BytesPerSecond is the current bandwidth limit, or the observed rate when bandwidth is not limited
+		BytesPerSecond int64  `json:"bytes_per_second"`
+		ETA            string `json:"eta,omitempty"`
+		Live           int    `json:"live"`
+	}
+
+	// Controller is safe for concurrent use.
+	Controller struct {
+		mu     sync.Mutex
+		limits Limits
+		now    func() time.Time
+		sleep  func(ctx context.Context, d time.Duration) error
+		// adaptive backfills start at `minFactor` of the bandwidth limit, and only speed up while `healthy`
+		adaptive bool
+		healthy  func() bool
+		factor   float64
+		// live exports in progress; `idle` is closed while there are none
+		live int
+		idle chan struct{}
+		// earliest time at which the next backfill export may start
+		nextOp   time.Time
+		nextByte time.Time
+		// backlog bookkeeping
+		remainingFiles int64
+		remainingBytes int64
+		drainedFiles   int64
+		drainedBytes   int64
+		drainStart     time.Time
+	}
+)
+
+const (
+	minFactor      = 0.1
+	increaseFactor = 1.5
+	decreaseFactor = 0.5
+)
+
+func sleep(
+	ctx context.Context,
+	d time.Duration,
+) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// NewController creates a backfill controller; when `adaptive`, `healthy` reports whether live exports meet their SLO.
+func NewController(
+	limits Limits,
+	adaptive bool,
+	healthy func() bool,
+) *Controller {
+	return newController(limits, adaptive, healthy, time.Now, sleep)
+}
+
+func newController(
+	limits Limits,
+	adaptive bool,
+	healthy func() bool,
+	now func() time.Time,
+	sleep func(ctx context.Context, d time.Duration) error,
+) *Controller {
+	idle := make(chan struct{})
+	close(idle)
+
+	factor := 1.0
+	if adaptive {
+		factor = minFactor
+	}
+
+	return &Controller{
+		limits:   limits,
+		now:      now,
+		sleep:    sleep,
+		adaptive: adaptive,
+		healthy:  healthy,
+		factor:   factor,
+		idle:     idle,
+	}
+}
+
+// LiveStarted marks the start of a live export; backfill exports are not started until it is done.
+func (c *Controller) LiveStarted() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.live == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.live += 1
+}
+
+// LiveDone marks the end of a live export.
+func (c *Controller) LiveDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.live == 0 {
+		return
+	}
+	c.live -= 1
+	if c.live == 0 {
+		close(c.idle)
+	}
+}
+
+// Add registers `files` with a total size of `bytes` as pending backfill.
+func (c *Controller) Add(
+	files, bytes int64,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.remainingFiles == 0 {
+		c.drainStart = c.now()
+		c.drainedFiles, c.drainedBytes = 0, 0
+	}
+	c.remainingFiles += files
+	c.remainingBytes += bytes
+}
+
+// Done registers that a backfill export of `bytes` is complete, successful or not.
+func (c *Controller) Done(
+	bytes int64,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remainingFiles = max(c.remainingFiles-1, 0)
+	c.remainingBytes = max(c.remainingBytes-bytes, 0)
+	c.drainedFiles += 1
+	c.drainedBytes += bytes
+}
+
+// waitForIdle blocks while live exports are in progress.
+func (c *Controller) waitForIdle(
+	ctx context.Context,
+) error {
+	c.mu.Lock()
+	idle := c.idle
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-idle:
+		return nil
+	}
+}
+
+// bytesPerSecond returns the current bandwidth limit; `0` means unlimited.
+func (c *Controller) bytesPerSecond() float64 {
+	return float64(c.limits.BytesPerSecond) * c.factor
+}
+
+// reserve returns how long a backfill export of `bytes` must wait to honor the limits.
+func (c *Controller) reserve(
+	bytes int64,
+) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	start := now
+	if c.nextOp.After(start) {
+		start = c.nextOp
+	}
+	if c.nextByte.After(start) {
+		start = c.nextByte
+	}
+	if c.limits.OpsPerSecond > 0 {
+		c.nextOp = start.Add(time.Duration(float64(time.Second) / c.limits.OpsPerSecond))
+	}
+	if rate := c.bytesPerSecond(); rate > 0 {
+		// bytes are paid after the export starts: the next export waits for them
+		c.nextByte = start.Add(time.Duration(float64(bytes) / rate * float64(time.Second)))
+	}
+	return start.Sub(now)
+}
+
+// Acquire blocks until a backfill export of `bytes` may start: live exports are always completed first.
+func (c *Controller) Acquire(
+	ctx context.Context,
+	bytes int64,
+) error {
+	if err := c.waitForIdle(ctx); err != nil {
+		return err
+	}
+	if err := c.sleep(ctx, c.reserve(bytes)); err != nil {
+		return err
+	}
+	// live exports may have started while waiting
+	return c.waitForIdle(ctx)
+}
+
+// Adjust speeds adaptive backfills up while live exports are healthy, and slows them down otherwise;
+// it returns `true` when the bandwidth limit changed.
+func (c *Controller) Adjust() bool {
+	if !c.adaptive {
+		return false
+	}
+
+	healthy := c.healthy()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	factor := c.factor
+	if healthy {
+		factor = math.Min(1, factor*increaseFactor)
+	} else {
+		factor = math.Max(minFactor, factor*decreaseFactor)
+	}
+	changed := factor != c.factor
+	c.factor = factor
+	return changed
+}
+
+func (c *Controller) Limits() Limits {
+	return c.limits
+}
+
+func (c *Controller) Progress() Progress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	progress := Progress{
+		RemainingFiles: c.remainingFiles,
+		RemainingBytes: c.remainingBytes,
+		DrainedFiles:   c.drainedFiles,
+		DrainedBytes:   c.drainedBytes,
+		Live:           c.live,
+	}
+
+	rate := c.bytesPerSecond()
+	if rate == 0 {
+		if elapsed := c.now().Sub(c.drainStart).Seconds(); elapsed > 0 && c.drainedBytes > 0 {
+			rate = float64(c.drainedBytes) / elapsed
+		}
+	}
+	progress.BytesPerSecond = int64(rate)
+	if rate > 0 && c.remainingBytes > 0 {
+		eta := time.Duration(float64(c.remainingBytes) / rate * float64(time.Second))
+		progress.ETA = eta.Round(time.Second).String()
+	}
+	return progress
+}
+
+func (l Limits) String() string {
+	return fmt.Sprintf("%d bytes/s, %.2f ops/s", l.BytesPerSecond, l.OpsPerSecond)
+}
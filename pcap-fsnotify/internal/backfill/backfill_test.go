@@ -0,0 +1,220 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backfill
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is advanced by sleeping.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+		c.slept += d
+	}
+	return ctx.Err()
+}
+
+func newTestController(limits Limits, adaptive bool, healthy func() bool) (*Controller, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	return newController(limits, adaptive, healthy, clock.Now, clock.Sleep), clock
+}
+
+// TestAcquireRate verifies that draining a large backlog honors the configured limits.
+func TestAcquireRate(t *testing.T) {
+	const (
+		files    = 1000
+		fileSize = 1 << 20
+	)
+
+	tests := []struct {
+		name   string
+		limits Limits
+		// expected time to drain the backlog
+		want time.Duration
+	}{
+		{
+			name:   "unlimited",
+			limits: Limits{},
+			want:   0,
+		},
+		{
+			name:   "bytes",
+			limits: Limits{BytesPerSecond: 10 << 20},
+			// the last export is not waited for
+			want: (files - 1) * time.Second / 10,
+		},
+		{
+			name:   "ops",
+			limits: Limits{OpsPerSecond: 4},
+			want:   (files - 1) * time.Second / 4,
+		},
+		{
+			name:   "strictest",
+			limits: Limits{BytesPerSecond: 10 << 20, OpsPerSecond: 20},
+			want:   (files - 1) * time.Second / 10,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			controller, clock := newTestController(tc.limits, false, nil)
+			controller.Add(files, files*fileSize)
+
+			for range files {
+				if err := controller.Acquire(context.Background(), fileSize); err != nil {
+					t.Fatalf("Acquire() = %v", err)
+				}
+				controller.Done(fileSize)
+			}
+
+			if clock.slept != tc.want {
+				t.Errorf("slept %v, want %v", clock.slept, tc.want)
+			}
+			if p := controller.Progress(); p.RemainingFiles != 0 || p.RemainingBytes != 0 || p.DrainedFiles != files {
+				t.Errorf("Progress() = %+v", p)
+			}
+		})
+	}
+}
+
+// TestAcquireYieldsToLive verifies that backfill exports wait for live exports to complete.
+func TestAcquireYieldsToLive(t *testing.T) {
+	controller, _ := newTestController(Limits{}, false, nil)
+
+	controller.LiveStarted()
+	controller.LiveStarted()
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- controller.Acquire(context.Background(), 1)
+	}()
+
+	controller.LiveDone()
+	select {
+	case <-acquired:
+		t.Fatal("Acquire() returned while a live export is in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	controller.LiveDone()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Acquire() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire() did not return after live exports completed")
+	}
+
+	// unbalanced calls must not block backfills
+	controller.LiveDone()
+	if err := controller.Acquire(context.Background(), 1); err != nil {
+		t.Fatalf("Acquire() = %v", err)
+	}
+}
+
+// TestAcquireCanceled verifies that waiting for live exports honors the context.
+func TestAcquireCanceled(t *testing.T) {
+	controller, _ := newTestController(Limits{}, false, nil)
+	controller.LiveStarted()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := controller.Acquire(ctx, 1); err == nil {
+		t.Fatal("Acquire() = nil, want context error")
+	}
+}
+
+// TestAdjust verifies that adaptive backfills speed up while healthy, and back off when the SLO is degraded.
+func TestAdjust(t *testing.T) {
+	const rate = 100 << 20
+
+	healthy := true
+	controller, _ := newTestController(Limits{BytesPerSecond: rate}, true, func() bool { return healthy })
+
+	if got, want := controller.Progress().BytesPerSecond, int64(rate*minFactor); got != want {
+		t.Fatalf("initial rate = %d, want %d", got, want)
+	}
+
+	for range 10 {
+		controller.Adjust()
+	}
+	if got := controller.Progress().BytesPerSecond; got != rate {
+		t.Fatalf("healthy rate = %d, want %d", got, rate)
+	}
+	if controller.Adjust() {
+		t.Error("Adjust() = true at the configured rate")
+	}
+
+	healthy = false
+	if !controller.Adjust() {
+		t.Error("Adjust() = false when degraded")
+	}
+	if got, want := controller.Progress().BytesPerSecond, int64(rate*decreaseFactor); got != want {
+		t.Errorf("degraded rate = %d, want %d", got, want)
+	}
+
+	for range 10 {
+		controller.Adjust()
+	}
+	if got, want := controller.Progress().BytesPerSecond, int64(rate*minFactor); got != want {
+		t.Errorf("minimum rate = %d, want %d", got, want)
+	}
+
+	static, _ := newTestController(Limits{BytesPerSecond: rate}, false, func() bool { return false })
+	if static.Adjust() {
+		t.Error("Adjust() = true when not adaptive")
+	}
+}
+
+// TestProgressETA verifies the ETA is derived from the remaining bytes and the rate.
+func TestProgressETA(t *testing.T) {
+	controller, clock := newTestController(Limits{}, false, nil)
+	controller.Add(4, 400)
+
+	if p := controller.Progress(); p.ETA != "" {
+		t.Errorf("ETA = %q before any progress", p.ETA)
+	}
+
+	clock.now = clock.now.Add(10 * time.Second)
+	controller.Done(100)
+
+	p := controller.Progress()
+	if p.BytesPerSecond != 10 {
+		t.Errorf("BytesPerSecond = %d, want 10", p.BytesPerSecond)
+	}
+	if p.ETA != "30s" {
+		t.Errorf("ETA = %q, want 30s", p.ETA)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package canary characterizes the storage semantics of the destination of exported PCAP files:
+// some failure modes, i.e. retention locks or lifecycle rules, only appear after PCAP files are exported.
+package canary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/pcapfile"
+)
+
+type (
+	// Storage stores canary objects using the same naming, layout, and compression as exported PCAP files.
+	Storage interface {
+		// Write creates the canary object for the PCAP file `name`; it returns the location of the canary object.
+		Write(ctx context.Context, name string, content []byte) (string, error)
+		// Read returns the content of the canary object at `location`.
+		Read(ctx context.Context, location string) ([]byte, error)
+		// Overwrite replaces the content of the canary object at `location`.
+		Overwrite(ctx context.Context, location string, content []byte) error
+		// Delete removes the canary object at `location`.
+		Delete(ctx context.Context, location string) error
+		// Encryption returns the key which encrypts the canary object at `location`,
+		// or `ErrUnsupported` when the destination does not expose it.
+		Encryption(ctx context.Context, location string) (string, error)
+	}
+
+	// Outcome is the result of a characterization step.
+	Outcome string
+
+	Characterization struct {
+		Location string    `json:"location,omitempty"`
+		Time     time.Time `json:"time"`
+		Delay    string    `json:"delay"`
+		// basic write/read: the only steps which affect health
+		Writable Outcome `json:"writable"`
+		Readable Outcome `json:"readable"`
+		// bucket policies are recorded, not required
+		SurvivesDelay      Outcome `json:"survives_delay"`
+		Overwritable       Outcome `json:"overwritable"`
+		Deletable          Outcome `json:"deletable"`
+		EncryptionVerified Outcome `json:"encryption_verified"`
+		Encryption         string  `json:"encryption,omitempty"`
+		// Errors maps characterization steps to the error they failed with
+		Errors map[string]string `json:"errors,omitempty"`
+	}
+
+	// Prober periodically characterizes the destination; it is safe for concurrent use.
+	Prober struct {
+		storage Storage
+		delay   time.Duration
+		now     func() time.Time
+		mu      sync.Mutex
+		last    *Characterization
+	}
+)
+
+const (
+	OUTCOME_YES     = Outcome("yes")
+	OUTCOME_NO      = Outcome("no")
+	OUTCOME_UNKNOWN = Outcome("unknown")
+
+	STEP_WRITE      = "write"
+	STEP_READ       = "read"
+	STEP_DELAY      = "survives_delay"
+	STEP_OVERWRITE  = "overwrite"
+	STEP_DELETE     = "delete"
+	STEP_ENCRYPTION = "encryption"
+
+	// canary PCAP files are hidden, so that they are not mistaken for captured traffic
+	fileNameTemplate = ".pcapfsn-canary__%s.pcap"
+	timestampLayout  = "20060102T150405"
+)
+
+var (
+	ErrUnsupported = errors.New("not supported by the destination")
+
+	errContentMismatch = errors.New("canary content does not match")
+)
+
+func NewProber(
+	storage Storage,
+	delay time.Duration,
+	now func() time.Time,
+) *Prober {
+	return &Prober{storage: storage, delay: delay, now: now}
+}
+
+// Healthy reports whether canary objects can be written and read back.
+func (c *Characterization) Healthy() bool {
+	return c.Writable == OUTCOME_YES && c.Readable == OUTCOME_YES
+}
+
+func (c *Characterization) fail(
+	step string,
+	err error,
+) Outcome {
+	if c.Errors == nil {
+		c.Errors = make(map[string]string)
+	}
+	c.Errors[step] = err.Error()
+	return OUTCOME_NO
+}
+
+// newContent returns a PCAP file whose only packet carries `id`, so that canary objects can be told apart.
+func newContent(
+	id string,
+	ts time.Time,
+) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := pcapfile.NewWriter(&buffer, pcapfile.WriterOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.WritePacket(ts, []byte(id), len(id)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// verifyContent reads the canary object at `location` and compares it with `content`.
+func (p *Prober) verifyContent(
+	ctx context.Context,
+	location string,
+	content []byte,
+) error {
+	got, err := p.storage.Read(ctx, location)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, content) {
+		return errContentMismatch
+	}
+	return nil
+}
+
+// Probe writes a canary object, reads it back immediately and again after the configured delay,
+// and then attempts to overwrite and to delete it. Steps after a failed basic write/read are `OUTCOME_UNKNOWN`.
+func (p *Prober) Probe(
+	ctx context.Context,
+) Characterization {
+	ts := p.now()
+	result := Characterization{
+		Time:               ts,
+		Delay:              p.delay.String(),
+		Writable:           OUTCOME_UNKNOWN,
+		Readable:           OUTCOME_UNKNOWN,
+		SurvivesDelay:      OUTCOME_UNKNOWN,
+		Overwritable:       OUTCOME_UNKNOWN,
+		Deletable:          OUTCOME_UNKNOWN,
+		EncryptionVerified: OUTCOME_UNKNOWN,
+	}
+	defer p.record(&result)
+
+	name := fmt.Sprintf(fileNameTemplate, ts.UTC().Format(timestampLayout))
+	content, err := newContent(name, ts)
+	if err != nil {
+		result.Writable = result.fail(STEP_WRITE, err)
+		return result
+	}
+
+	location, err := p.storage.Write(ctx, name, content)
+	if err != nil {
+		result.Writable = result.fail(STEP_WRITE, err)
+		return result
+	}
+	result.Location, result.Writable = location, OUTCOME_YES
+
+	if err := p.verifyContent(ctx, location, content); err != nil {
+		result.Readable = result.fail(STEP_READ, err)
+		// the canary object must not be left behind: the outcome is still recorded
+		result.Deletable = p.delete(ctx, &result)
+		return result
+	}
+	result.Readable = OUTCOME_YES
+
+	if key, err := p.storage.Encryption(ctx, location); err == nil {
+		result.EncryptionVerified, result.Encryption = OUTCOME_YES, key
+	} else if !errors.Is(err, ErrUnsupported) {
+		result.EncryptionVerified = result.fail(STEP_ENCRYPTION, err)
+	}
+
+	if p.delay > 0 {
+		timer := time.NewTimer(p.delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// the canary object must not be left behind, even when shutting down
+			result.Deletable = p.delete(context.WithoutCancel(ctx), &result)
+			return result
+		case <-timer.C:
+		}
+	}
+
+	if err := p.verifyContent(ctx, location, content); err != nil {
+		// lifecycle rules may delete objects shortly after they are created
+		result.SurvivesDelay = result.fail(STEP_DELAY, err)
+	} else {
+		result.SurvivesDelay = OUTCOME_YES
+	}
+
+	overwritten, err := newContent(name+"+", ts)
+	if err == nil {
+		err = p.storage.Overwrite(ctx, location, overwritten)
+	}
+	if err == nil {
+		err = p.verifyContent(ctx, location, overwritten)
+	}
+	if err != nil {
+		// retention-locked buckets reject overwrites
+		result.Overwritable = result.fail(STEP_OVERWRITE, err)
+	} else {
+		result.Overwritable = OUTCOME_YES
+	}
+
+	result.Deletable = p.delete(ctx, &result)
+	return result
+}
+
+func (p *Prober) delete(
+	ctx context.Context,
+	result *Characterization,
+) Outcome {
+	if err := p.storage.Delete(ctx, result.Location); err != nil {
+		return result.fail(STEP_DELETE, err)
+	}
+	return OUTCOME_YES
+}
+
+func (p *Prober) record(
+	result *Characterization,
+) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last := *result
+	p.last = &last
+}
+
+// Last returns the latest characterization; `ok` is `false` when the destination was never probed.
+func (p *Prober) Last() (result Characterization, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last == nil {
+		return Characterization{}, false
+	}
+	return *p.last, true
+}
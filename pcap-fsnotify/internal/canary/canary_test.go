@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package canary
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage keeps canary objects in memory; its knobs emulate bucket policies.
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// rejects all writes, i.e.: missing IAM permissions
+	readOnly bool
+	// objects are unreadable, i.e.: CMEK key permission loss
+	unreadable bool
+	// objects are deleted after their first read, i.e.: lifecycle rules
+	lifecycle bool
+	// overwrites and deletes are rejected, i.e.: retention locks
+	retention bool
+	// empty when the encryption key is not exposed
+	key string
+}
+
+var errDenied = errors.New("denied")
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{objects: make(map[string][]byte)}
+}
+
+func (s *fakeStorage) Write(_ context.Context, name string, content []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readOnly {
+		return name, errDenied
+	}
+	s.objects[name] = content
+	return name, nil
+}
+
+func (s *fakeStorage) Read(_ context.Context, location string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unreadable {
+		return nil, errDenied
+	}
+	content, ok := s.objects[location]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	if s.lifecycle {
+		delete(s.objects, location)
+	}
+	return content, nil
+}
+
+func (s *fakeStorage) Overwrite(_ context.Context, location string, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retention {
+		return errDenied
+	}
+	s.objects[location] = content
+	return nil
+}
+
+func (s *fakeStorage) Delete(_ context.Context, location string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retention {
+		return errDenied
+	}
+	delete(s.objects, location)
+	return nil
+}
+
+func (s *fakeStorage) Encryption(_ context.Context, _ string) (string, error) {
+	if s.key == "" {
+		return "", ErrUnsupported
+	}
+	return s.key, nil
+}
+
+// TestProbe verifies the characterization matrix for each bucket policy.
+func TestProbe(t *testing.T) {
+	const (
+		Y = OUTCOME_YES
+		N = OUTCOME_NO
+		U = OUTCOME_UNKNOWN
+	)
+
+	tests := []struct {
+		name      string
+		configure func(*fakeStorage)
+		// writable, readable, survives delay, overwritable, deletable, encryption verified
+		want    [6]Outcome
+		healthy bool
+		// canary objects left behind
+		left int
+	}{
+		{"regular bucket", func(*fakeStorage) {}, [6]Outcome{Y, Y, Y, Y, Y, U}, true, 0},
+		{"CMEK bucket", func(s *fakeStorage) { s.key = "projects/p/locations/l/keyRings/r/cryptoKeys/k" }, [6]Outcome{Y, Y, Y, Y, Y, Y}, true, 0},
+		{"missing write permission", func(s *fakeStorage) { s.readOnly = true }, [6]Outcome{N, U, U, U, U, U}, false, 0},
+		{"CMEK key permission loss", func(s *fakeStorage) { s.unreadable = true }, [6]Outcome{Y, N, U, U, Y, U}, false, 0},
+		{"lifecycle deletion", func(s *fakeStorage) { s.lifecycle = true }, [6]Outcome{Y, Y, N, Y, Y, U}, true, 0},
+		{"retention lock", func(s *fakeStorage) { s.retention = true }, [6]Outcome{Y, Y, Y, N, N, U}, true, 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			storage := newFakeStorage()
+			tc.configure(storage)
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			prober := NewProber(storage, time.Millisecond, func() time.Time { return now })
+
+			if _, ok := prober.Last(); ok {
+				t.Fatal("Last() before Probe() must not be available")
+			}
+
+			got := prober.Probe(context.Background())
+			matrix := [6]Outcome{got.Writable, got.Readable, got.SurvivesDelay, got.Overwritable, got.Deletable, got.EncryptionVerified}
+			if matrix != tc.want {
+				t.Errorf("characterization = %v, want %v (errors: %v)", matrix, tc.want, got.Errors)
+			}
+			if got.Healthy() != tc.healthy {
+				t.Errorf("Healthy() = %v, want %v", got.Healthy(), tc.healthy)
+			}
+			if left := len(storage.objects); left != tc.left {
+				t.Errorf("canary objects left behind = %d, want %d", left, tc.left)
+			}
+			if last, ok := prober.Last(); !ok || last.Writable != got.Writable || !maps.Equal(last.Errors, got.Errors) {
+				t.Errorf("Last() = %+v, want %+v", last, got)
+			}
+		})
+	}
+}
+
+// TestProbeCancelled verifies that the canary object is deleted when probing is cancelled while waiting.
+func TestProbeCancelled(t *testing.T) {
+	storage := newFakeStorage()
+	prober := NewProber(storage, time.Hour, time.Now)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	got := prober.Probe(ctx)
+	if !got.Healthy() || got.SurvivesDelay != OUTCOME_UNKNOWN || got.Deletable != OUTCOME_YES {
+		t.Errorf("Probe() = %+v", got)
+	}
+	if len(storage.objects) != 0 {
+		t.Errorf("canary objects left behind: %d", len(storage.objects))
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package checkpoint progressively copies the PCAP files being written into partial PCAP files at the destination,
+// so that at most one checkpoint interval of captured packets is not durable.
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// Mode is the write pattern used to update partial PCAP files.
+	Mode string
+
+	// Destination stores partial PCAP files.
+	Destination interface {
+		// Probe returns the write pattern supported by the destination.
+		Probe(ctx context.Context) (Mode, error)
+		// Write appends the bytes from `r` to the partial PCAP file of `srcPcapFile` at `offset`,
+		// or replaces it when using `MODE_OVERWRITE`; it returns the name of the partial PCAP file.
+		Write(ctx context.Context, srcPcapFile string, mode Mode, offset int64, r io.Reader) (string, error)
+		// Remove deletes the partial PCAP file at `partialPcapFile`.
+		Remove(ctx context.Context, partialPcapFile string) error
+	}
+
+	// Result describes a checkpoint copy.
+	Result struct {
+		Source  string `json:"source"`
+		Partial string `json:"partial"`
+		// Offset is the size of the source PCAP file that is already copied
+		Offset int64 `json:"offset"`
+		// Bytes is the amount of bytes copied by this checkpoint
+		Bytes int64 `json:"bytes"`
+	}
+
+	state struct {
+		offset       int64
+		partial      string
+		checkpointAt time.Time
+	}
+
+	Checkpointer struct {
+		mu          sync.Mutex
+		destination Destination
+		mode        Mode
+		now         func() time.Time
+		states      map[string]*state
+		// completed PCAP files are never checkpointed again
+		completed map[string]struct{}
+	}
+)
+
+const (
+	// MODE_APPEND only copies the bytes written since the previous checkpoint.
+	MODE_APPEND = Mode("append")
+	// MODE_OVERWRITE copies the whole PCAP file on every checkpoint.
+	MODE_OVERWRITE = Mode("overwrite")
+
+	// PartialSuffix marks partial PCAP files, so that analysis tooling can ignore them.
+	PartialSuffix = ".partial"
+)
+
+// ErrUnsupported is returned when the destination does not support any write pattern required by checkpoints.
+var ErrUnsupported = errors.New("destination does not support checkpoints")
+
+// NewCheckpointer probes `destination`; checkpoints must be disabled when it returns an error.
+func NewCheckpointer(
+	ctx context.Context,
+	destination Destination,
+	now func() time.Time,
+) (*Checkpointer, error) {
+	mode, err := destination.Probe(ctx)
+	if err != nil {
+		return nil, errors.Join(ErrUnsupported, err)
+	}
+	return &Checkpointer{
+		destination: destination,
+		mode:        mode,
+		now:         now,
+		states:      make(map[string]*state),
+		completed:   make(map[string]struct{}),
+	}, nil
+}
+
+func (c *Checkpointer) Mode() Mode {
+	return c.mode
+}
+
+// Checkpoint copies the bytes of `srcPcapFile` which were written since its previous checkpoint.
+// It returns `nil` when there is nothing to copy.
+func (c *Checkpointer) Checkpoint(
+	ctx context.Context,
+	srcPcapFile string,
+) (*Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune()
+
+	if _, ok := c.completed[srcPcapFile]; ok {
+		return nil, nil
+	}
+
+	info, err := os.Stat(srcPcapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := c.states[srcPcapFile]
+	if !ok {
+		s = &state{}
+		c.states[srcPcapFile] = s
+	}
+
+	now := c.now()
+	size := info.Size()
+	if size <= s.offset {
+		// no new packets were written: nothing is at risk
+		s.checkpointAt = now
+		return nil, nil
+	}
+
+	src, err := os.Open(srcPcapFile)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	offset := s.offset
+	if c.mode == MODE_OVERWRITE {
+		offset = 0
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// only the bytes available now are copied: the PCAP file keeps growing while copying
+	partial, err := c.destination.Write(ctx, srcPcapFile, c.mode, offset, io.LimitReader(src, size-offset))
+	if err != nil {
+		// the partial PCAP file is in an unknown state: the next checkpoint replaces it
+		s.offset = 0
+		return nil, err
+	}
+
+	result := &Result{
+		Source:  srcPcapFile,
+		Partial: partial,
+		Offset:  size,
+		Bytes:   size - offset,
+	}
+	s.offset = size
+	s.partial = partial
+	s.checkpointAt = now
+	return result, nil
+}
+
+// Complete removes the partial PCAP file of `srcPcapFile` once it is fully exported,
+// and resets its offset tracking. It returns the name of the removed partial PCAP file, if any.
+func (c *Checkpointer) Complete(
+	ctx context.Context,
+	srcPcapFile string,
+) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.completed[srcPcapFile] = struct{}{}
+
+	s, ok := c.states[srcPcapFile]
+	if !ok {
+		return "", nil
+	}
+	delete(c.states, srcPcapFile)
+
+	if s.partial == "" {
+		return "", nil
+	}
+	return s.partial, c.destination.Remove(ctx, s.partial)
+}
+
+// AtRisk returns the time since `srcPcapFile` was last checkpointed:
+// packets captured since then are not durable. It is `false` when `srcPcapFile` was never checkpointed.
+func (c *Checkpointer) AtRisk(
+	srcPcapFile string,
+) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.states[srcPcapFile]
+	if !ok || s.checkpointAt.IsZero() {
+		return 0, false
+	}
+	return c.now().Sub(s.checkpointAt), true
+}
+
+// prune forgets PCAP files which no longer exist; completed ones cannot be checkpointed again,
+// and partial PCAP files of the ones which were not completed are kept as they may be the only copy.
+func (c *Checkpointer) prune() {
+	for srcPcapFile := range c.completed {
+		if _, err := os.Stat(srcPcapFile); os.IsNotExist(err) {
+			delete(c.completed, srcPcapFile)
+		}
+	}
+	for srcPcapFile := range c.states {
+		if _, err := os.Stat(srcPcapFile); os.IsNotExist(err) {
+			delete(c.states, srcPcapFile)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeDestination keeps partial PCAP files in memory.
+type fakeDestination struct {
+	mode     Mode
+	probeErr error
+	writeErr error
+	partials map[string][]byte
+}
+
+func newFakeDestination(mode Mode) *fakeDestination {
+	return &fakeDestination{mode: mode, partials: make(map[string][]byte)}
+}
+
+func (d *fakeDestination) Probe(context.Context) (Mode, error) {
+	return d.mode, d.probeErr
+}
+
+func (d *fakeDestination) Write(_ context.Context, srcPcapFile string, mode Mode, offset int64, r io.Reader) (string, error) {
+	partialPcapFile := filepath.Base(srcPcapFile) + PartialSuffix
+	if d.writeErr != nil {
+		return partialPcapFile, d.writeErr
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return partialPcapFile, err
+	}
+	if mode == MODE_APPEND && offset > 0 {
+		if int64(len(d.partials[partialPcapFile])) != offset {
+			return partialPcapFile, errors.New("offset mismatch")
+		}
+		data = append(d.partials[partialPcapFile], data...)
+	}
+	d.partials[partialPcapFile] = data
+	return partialPcapFile, nil
+}
+
+func (d *fakeDestination) Remove(_ context.Context, partialPcapFile string) error {
+	delete(d.partials, partialPcapFile)
+	return nil
+}
+
+// fakeClock is advanced manually.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// growingPcapFile simulates a PCAP file being written by `tcpdump`.
+type growingPcapFile struct {
+	path    string
+	content []byte
+}
+
+func newGrowingPcapFile(t *testing.T) *growingPcapFile {
+	t.Helper()
+	f := &growingPcapFile{path: filepath.Join(t.TempDir(), "part__1_eth0__20240101T000000.pcap")}
+	f.grow(t, 0)
+	return f
+}
+
+func (f *growingPcapFile) grow(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		f.content = append(f.content, byte(len(f.content)))
+	}
+	if err := os.WriteFile(f.path, f.content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCheckpointer(t *testing.T, destination Destination, clock *fakeClock) *Checkpointer {
+	t.Helper()
+	c, err := NewCheckpointer(context.Background(), destination, clock.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestCheckpointTracksOffsets verifies that only new bytes are copied, and that partial PCAP files match their sources.
+func TestCheckpointTracksOffsets(t *testing.T) {
+	for _, mode := range []Mode{MODE_APPEND, MODE_OVERWRITE} {
+		t.Run(string(mode), func(t *testing.T) {
+			destination := newFakeDestination(mode)
+			c := newTestCheckpointer(t, destination, &fakeClock{time.Unix(0, 0)})
+			pcapFile := newGrowingPcapFile(t)
+
+			tests := []struct {
+				grow      int
+				wantBytes int64
+			}{
+				{100, 100},
+				{50, 50},
+				{0, 0},
+				{25, 25},
+			}
+
+			for i, tc := range tests {
+				pcapFile.grow(t, tc.grow)
+				result, err := c.Checkpoint(context.Background(), pcapFile.path)
+				if err != nil {
+					t.Fatalf("checkpoint %d: %v", i, err)
+				}
+				if tc.wantBytes == 0 {
+					if result != nil {
+						t.Errorf("checkpoint %d: got %+v, want nothing copied", i, result)
+					}
+					continue
+				}
+				if mode == MODE_OVERWRITE {
+					tc.wantBytes = int64(len(pcapFile.content))
+				}
+				if result.Bytes != tc.wantBytes || result.Offset != int64(len(pcapFile.content)) {
+					t.Errorf("checkpoint %d: got %d bytes at offset %d, want %d bytes at offset %d",
+						i, result.Bytes, result.Offset, tc.wantBytes, len(pcapFile.content))
+				}
+				if !bytes.Equal(destination.partials[result.Partial], pcapFile.content) {
+					t.Errorf("checkpoint %d: partial PCAP file does not match its source", i)
+				}
+			}
+		})
+	}
+}
+
+// TestCompleteReplacesPartial verifies that the partial PCAP file is removed once the PCAP file is exported,
+// and that it is not created again by a late checkpoint.
+func TestCompleteReplacesPartial(t *testing.T) {
+	destination := newFakeDestination(MODE_APPEND)
+	c := newTestCheckpointer(t, destination, &fakeClock{time.Unix(0, 0)})
+	pcapFile := newGrowingPcapFile(t)
+
+	pcapFile.grow(t, 10)
+	result, err := c.Checkpoint(context.Background(), pcapFile.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := c.Complete(context.Background(), pcapFile.path)
+	if err != nil || removed != result.Partial {
+		t.Fatalf("got %s, %v, want %s", removed, err, result.Partial)
+	}
+	if len(destination.partials) != 0 {
+		t.Errorf("partial PCAP file was not removed: %v", destination.partials)
+	}
+	if _, ok := c.AtRisk(pcapFile.path); ok {
+		t.Error("offset tracking was not reset")
+	}
+
+	pcapFile.grow(t, 10)
+	if result, err := c.Checkpoint(context.Background(), pcapFile.path); result != nil || err != nil {
+		t.Errorf("completed PCAP file was checkpointed: %+v, %v", result, err)
+	}
+	if len(destination.partials) != 0 {
+		t.Errorf("partial PCAP file was created again: %v", destination.partials)
+	}
+}
+
+// TestAtRiskWindow verifies that at most one checkpoint interval of packets is not durable.
+func TestAtRiskWindow(t *testing.T) {
+	const interval = 10 * time.Second
+
+	clock := &fakeClock{time.Unix(0, 0)}
+	c := newTestCheckpointer(t, newFakeDestination(MODE_APPEND), clock)
+	pcapFile := newGrowingPcapFile(t)
+
+	// packets are written continuously during a 60s rotation
+	for elapsed := time.Duration(0); elapsed < 60*time.Second; elapsed += time.Second {
+		clock.now = clock.now.Add(time.Second)
+		pcapFile.grow(t, 8)
+		if (elapsed+time.Second)%interval == 0 {
+			if _, err := c.Checkpoint(context.Background(), pcapFile.path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if atRisk, ok := c.AtRisk(pcapFile.path); ok && atRisk > interval {
+			t.Fatalf("%s of packets at risk after %s, want at most %s", atRisk, elapsed, interval)
+		}
+	}
+}
+
+// TestWriteFailureRewritesPartial verifies that a failed checkpoint makes the next one copy the whole PCAP file.
+func TestWriteFailureRewritesPartial(t *testing.T) {
+	destination := newFakeDestination(MODE_APPEND)
+	c := newTestCheckpointer(t, destination, &fakeClock{time.Unix(0, 0)})
+	pcapFile := newGrowingPcapFile(t)
+
+	pcapFile.grow(t, 10)
+	if _, err := c.Checkpoint(context.Background(), pcapFile.path); err != nil {
+		t.Fatal(err)
+	}
+
+	destination.writeErr = errors.New("unavailable")
+	pcapFile.grow(t, 10)
+	if _, err := c.Checkpoint(context.Background(), pcapFile.path); err == nil {
+		t.Fatal("write failure was not reported")
+	}
+
+	destination.writeErr = nil
+	result, err := c.Checkpoint(context.Background(), pcapFile.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Bytes != 20 || !bytes.Equal(destination.partials[result.Partial], pcapFile.content) {
+		t.Errorf("got %d bytes, want the whole PCAP file", result.Bytes)
+	}
+}
+
+// TestUnsupportedDestination verifies that checkpoints are disabled when the destination fails the capability probe.
+func TestUnsupportedDestination(t *testing.T) {
+	destination := newFakeDestination(MODE_APPEND)
+	destination.probeErr = os.ErrPermission
+	if _, err := NewCheckpointer(context.Background(), destination, time.Now); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want %v", err, ErrUnsupported)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+type (
+	// Codec compresses PCAP files; codecs are only compared, PCAP files are always exported using gzip.
+	Codec interface {
+		Name() string
+		Compress(ctx context.Context, dst io.Writer, src io.Reader, level int) error
+	}
+
+	// CodecResult describes the copy of a PCAP file compressed by a codec.
+	CodecResult struct {
+		Codec    string  `json:"codec"`
+		Level    int     `json:"level"`
+		Bytes    int64   `json:"bytes"`
+		Ratio    float64 `json:"ratio"`
+		Duration string  `json:"duration"`
+		Error    string  `json:"error,omitempty"`
+	}
+
+	gzipCodec struct{}
+
+	// commandCodec compresses using an external program which reads from `stdin` and writes into `stdout`.
+	commandCodec struct {
+		name string
+		path string
+		args func(level int) []string
+	}
+
+	countingWriter struct {
+		io.Writer
+		bytes int64
+	}
+)
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func NewGzipCodec() Codec {
+	return &gzipCodec{}
+}
+
+func (c *gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (c *gzipCodec) Compress(
+	_ context.Context,
+	dst io.Writer,
+	src io.Reader,
+	level int,
+) error {
+	gzipWriter, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(gzipWriter, src); err != nil {
+		gzipWriter.Close()
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// NewZstdCodec compresses using the `zstd` program, which is not included in the sidecar image.
+func NewZstdCodec() (Codec, error) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, err
+	}
+	return &commandCodec{
+		name: "zstd",
+		path: path,
+		args: func(level int) []string {
+			// gzip levels are also valid zstd levels
+			return []string{"-q", "-c", fmt.Sprintf("-%d", max(level, 1))}
+		},
+	}, nil
+}
+
+func (c *commandCodec) Name() string {
+	return c.name
+}
+
+func (c *commandCodec) Compress(
+	ctx context.Context,
+	dst io.Writer,
+	src io.Reader,
+	level int,
+) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path, c.args(level)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = src, dst, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", c.name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
+// compareCodec compresses `srcPcapFile` into a temporary copy using `codec`, which is removed afterwards.
+func compareCodec(
+	ctx context.Context,
+	srcPcapFile string,
+	origBytes int64,
+	codec Codec,
+	level int,
+) *CodecResult {
+	result := &CodecResult{Codec: codec.Name(), Level: level}
+
+	err := func() error {
+		src, err := os.Open(srcPcapFile)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		// copies are written to disk, so that timings are comparable with real exports
+		dst, err := os.CreateTemp("", "pcapfsn-codec-*."+codec.Name())
+		if err != nil {
+			return err
+		}
+		defer os.Remove(dst.Name())
+
+		counter := &countingWriter{Writer: dst}
+		start := time.Now()
+		err = codec.Compress(ctx, counter, src, level)
+		err = errors.Join(err, dst.Close())
+		result.Duration = time.Since(start).String()
+		result.Bytes = counter.bytes
+		return err
+	}()
+
+	if err != nil {
+		result.Error = err.Error()
+	} else if result.Bytes > 0 {
+		result.Ratio = float64(origBytes) / float64(result.Bytes)
+	}
+	return result
+}
+
+// Compare compresses `srcPcapFile` using every codec in turn, so that they do not compete for CPU,
+// and returns once all of them complete; the source PCAP file must not be deleted until then.
+func Compare(
+	ctx context.Context,
+	srcPcapFile string,
+	level int,
+	codecs ...Codec,
+) (int64, []*CodecResult, error) {
+	info, err := os.Stat(srcPcapFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	origBytes := info.Size()
+
+	results := make([]*CodecResult, 0, len(codecs))
+	for _, codec := range codecs {
+		results = append(results, compareCodec(ctx, srcPcapFile, origBytes, codec, level))
+	}
+	return origBytes, results, nil
+}
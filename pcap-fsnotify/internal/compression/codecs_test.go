@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCompare verifies that every codec compresses the same PCAP file, and that the source PCAP file is kept.
+func TestCompare(t *testing.T) {
+	codecs := []Codec{NewGzipCodec()}
+	if zstd, err := NewZstdCodec(); err == nil {
+		codecs = append(codecs, zstd)
+	} else {
+		t.Logf("zstd is not available: %v", err)
+	}
+	// `cat` does not compress: its copy is as large as the source
+	codecs = append(codecs, &commandCodec{name: "cat", path: "cat", args: func(int) []string { return nil }})
+
+	srcPcapFile := filepath.Join(t.TempDir(), "part__1_eth0__20240101T000000.pcap")
+	fixture := compressibleFixture()
+	if err := os.WriteFile(srcPcapFile, fixture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	origBytes, results, err := Compare(context.Background(), srcPcapFile, 6, codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origBytes != int64(len(fixture)) {
+		t.Errorf("original bytes = %d, want %d", origBytes, len(fixture))
+	}
+	if len(results) != len(codecs) {
+		t.Fatalf("got %d results, want %d", len(results), len(codecs))
+	}
+	for i, result := range results {
+		if result.Codec != codecs[i].Name() || result.Error != "" || result.Duration == "" {
+			t.Errorf("result = %+v", result)
+			continue
+		}
+		if result.Codec == "cat" {
+			if result.Bytes != origBytes || result.Ratio != 1 {
+				t.Errorf("%s: bytes = %d, ratio = %f; want %d, 1", result.Codec, result.Bytes, result.Ratio, origBytes)
+			}
+		} else if result.Ratio <= highRatio {
+			t.Errorf("%s: ratio = %f, want > %f", result.Codec, result.Ratio, highRatio)
+		}
+	}
+	if _, err := os.Stat(srcPcapFile); err != nil {
+		t.Errorf("source PCAP file was not kept: %v", err)
+	}
+}
+
+// TestCompareFailure verifies that a failing codec is reported without affecting the others.
+func TestCompareFailure(t *testing.T) {
+	failing := &commandCodec{name: "false", path: "false", args: func(int) []string { return nil }}
+
+	srcPcapFile := filepath.Join(t.TempDir(), "part__1_eth0__20240101T000000.pcap")
+	if err := os.WriteFile(srcPcapFile, compressibleFixture(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, results, err := Compare(context.Background(), srcPcapFile, 1, failing, NewGzipCodec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error == "" || results[0].Ratio != 0 {
+		t.Errorf("failing codec result = %+v", results[0])
+	}
+	if results[1].Error != "" || results[1].Bytes == 0 {
+		t.Errorf("gzip result = %+v", results[1])
+	}
+
+	if _, _, err := Compare(context.Background(), filepath.Join(t.TempDir(), "missing.pcap"), 1, NewGzipCodec()); !os.IsNotExist(err) {
+		t.Errorf("Compare() of a missing PCAP file error = %v", err)
+	}
+}
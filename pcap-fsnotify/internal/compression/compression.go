@@ -0,0 +1,281 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compression decides the gzip compression level of every exported PCAP file per interface:
+// levels are adapted within bounds to the compression ratio achieved by recent exports of each interface,
+// unless the interface is pinned to a level by the config.
+package compression
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Decision is how a PCAP file is compressed; `Level` is `gzip.NoCompression` when it is not compressed.
+	Decision struct {
+		Iface    string `json:"iface"`
+		Level    int    `json:"level"`
+		Compress bool   `json:"compress"`
+		Pinned   bool   `json:"pinned,omitempty"`
+		Probe    bool   `json:"probe,omitempty"`
+		Reason   string `json:"reason,omitempty"`
+	}
+
+	// Bounds are the lowest and highest levels adaptation may use; a lowest level of `0` allows disabling compression.
+	Bounds struct {
+		Min int
+		Max int
+	}
+
+	ifaceState struct {
+		level  int
+		ratios []float64
+		// uncompressed exports since compression was disabled
+		skipped int
+		reason  string
+	}
+
+	// Adapter is safe for concurrent use.
+	Adapter struct {
+		mu       sync.Mutex
+		bounds   Bounds
+		adaptive bool
+		window   int
+		pins     map[string]int
+		ifaces   map[string]*ifaceState
+	}
+
+	contextKey struct{}
+)
+
+const (
+	// ratios below are achieved by encrypted or already compressed traffic: compressing harder only wastes CPU
+	lowRatio = 1.1
+	// ratios above are achieved by chatty plaintext traffic: compressing harder pays off
+	highRatio = 3.0
+	// a ratio this many times higher or lower than the recent average means that the traffic changed
+	discontinuity = 2.0
+
+	REASON_INITIAL        = "initial"
+	REASON_PINNED         = "pinned"
+	REASON_INCOMPRESSIBLE = "incompressible"
+	REASON_COMPRESSIBLE   = "compressible"
+	REASON_STABLE         = "stable"
+	REASON_DISCONTINUITY  = "discontinuity"
+	REASON_PROBE          = "probe"
+)
+
+// ParsePins parses per-interface pinned levels: `<iface>=<level>`; `0` disables compression for the interface.
+func ParsePins(
+	entries []string,
+) (map[string]int, error) {
+	pins := make(map[string]int)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		iface, value, ok := strings.Cut(entry, "=")
+		iface = strings.TrimSpace(iface)
+		if !ok || iface == "" {
+			return nil, fmt.Errorf("invalid compression override: %q; expected '<iface>=<level>'", entry)
+		}
+		level, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || level < gzip.NoCompression || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid compression level for '%s': %q; must be between %d and %d",
+				iface, value, gzip.NoCompression, gzip.BestCompression)
+		}
+		pins[iface] = level
+	}
+	return pins, nil
+}
+
+// Validate verifies that bounds are valid gzip levels, and that they are not inverted.
+func (b Bounds) Validate() error {
+	if b.Min < gzip.NoCompression || b.Max > gzip.BestCompression || b.Max < gzip.BestSpeed || b.Min > b.Max {
+		return fmt.Errorf("invalid compression levels: [%d,%d]; must be within [%d,%d]",
+			b.Min, b.Max, gzip.NoCompression, gzip.BestCompression)
+	}
+	return nil
+}
+
+// initial returns the default gzip level within bounds.
+func (b Bounds) initial() int {
+	return max(b.Min, min(b.Max, 6 /* default gzip level */), gzip.BestSpeed)
+}
+
+// NewAdapter creates a compression adapter; the level of every interface is re-evaluated after `window` exports.
+// When not `adaptive`, only pinned interfaces deviate from the default gzip level.
+func NewAdapter(
+	bounds Bounds,
+	adaptive bool,
+	window int,
+	pins map[string]int,
+) *Adapter {
+	return &Adapter{
+		bounds:   bounds,
+		adaptive: adaptive,
+		window:   max(window, 1),
+		pins:     pins,
+		ifaces:   make(map[string]*ifaceState),
+	}
+}
+
+func (a *Adapter) state(
+	iface string,
+) *ifaceState {
+	state, ok := a.ifaces[iface]
+	if !ok {
+		state = &ifaceState{level: a.bounds.initial(), reason: REASON_INITIAL}
+		a.ifaces[iface] = state
+	}
+	return state
+}
+
+// Decide returns how the next PCAP file of `iface` must be compressed.
+func (a *Adapter) Decide(
+	iface string,
+) Decision {
+	if level, ok := a.pins[iface]; ok {
+		return Decision{Iface: iface, Level: level, Compress: level > gzip.NoCompression, Pinned: true, Reason: REASON_PINNED}
+	}
+	if !a.adaptive {
+		return Decision{Iface: iface, Level: gzip.DefaultCompression, Compress: true}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state := a.state(iface)
+	if state.level > gzip.NoCompression {
+		return Decision{Iface: iface, Level: state.level, Compress: true, Reason: state.reason}
+	}
+	// traffic may have become compressible: probe it once per window
+	if state.skipped >= a.window {
+		return Decision{Iface: iface, Level: gzip.BestSpeed, Compress: true, Probe: true, Reason: REASON_PROBE}
+	}
+	return Decision{Iface: iface, Level: gzip.NoCompression, Reason: state.reason}
+}
+
+// Record adapts the level of the interface to the ratio achieved by an export made using `decision`;
+// it returns the level for the next export of the interface.
+func (a *Adapter) Record(
+	decision Decision,
+	origBytes, compBytes int64,
+) int {
+	if decision.Pinned || !a.adaptive {
+		return decision.Level
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	state := a.state(decision.Iface)
+
+	if !decision.Compress {
+		state.skipped += 1
+		return state.level
+	}
+	if origBytes <= 0 || compBytes <= 0 {
+		return state.level
+	}
+	ratio := float64(origBytes) / float64(compBytes)
+
+	if decision.Probe {
+		state.skipped = 0
+		if ratio >= lowRatio {
+			state.level, state.reason = max(a.bounds.Min, gzip.BestSpeed), REASON_PROBE
+			state.ratios = state.ratios[:0]
+		}
+		return state.level
+	}
+
+	if len(state.ratios) > 0 {
+		mean := average(state.ratios)
+		if ratio > mean*discontinuity || ratio*discontinuity < mean {
+			// the traffic fingerprint changed: previous measurements do not apply anymore
+			state.level, state.reason = a.bounds.initial(), REASON_DISCONTINUITY
+			state.ratios = append(state.ratios[:0], ratio)
+			return state.level
+		}
+	}
+
+	state.ratios = append(state.ratios, ratio)
+	if len(state.ratios) < a.window {
+		return state.level
+	}
+
+	mean := average(state.ratios)
+	state.ratios = state.ratios[:0]
+	switch {
+	case mean < lowRatio && state.level > a.bounds.Min:
+		state.level, state.reason = max(state.level-1, a.bounds.Min), REASON_INCOMPRESSIBLE
+		if state.level == gzip.NoCompression {
+			state.skipped = 0
+		}
+	case mean > highRatio && state.level < a.bounds.Max:
+		state.level, state.reason = min(state.level+1, a.bounds.Max), REASON_COMPRESSIBLE
+	default:
+		state.reason = REASON_STABLE
+	}
+	return state.level
+}
+
+// Levels returns the current level of every interface, including pinned ones.
+func (a *Adapter) Levels() map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	levels := make(map[string]int, len(a.ifaces)+len(a.pins))
+	for iface, state := range a.ifaces {
+		levels[iface] = state.level
+	}
+	for iface, level := range a.pins {
+		levels[iface] = level
+	}
+	return levels
+}
+
+func average(
+	values []float64,
+) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// WithLevel returns a context which carries the gzip level used to compress an exported PCAP file.
+func WithLevel(
+	ctx context.Context,
+	level int,
+) context.Context {
+	return context.WithValue(ctx, contextKey{}, level)
+}
+
+// Level returns the gzip level carried by `ctx`, or `gzip.DefaultCompression`.
+func Level(
+	ctx context.Context,
+) int {
+	if level, ok := ctx.Value(contextKey{}).(int); ok {
+		return level
+	}
+	return gzip.DefaultCompression
+}
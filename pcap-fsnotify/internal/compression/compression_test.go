@@ -0,0 +1,219 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// compressibleFixture resembles chatty plaintext traffic.
+func compressibleFixture() []byte {
+	return []byte(strings.Repeat("GET /healthz HTTP/1.1\r\nHost: localhost\r\nUser-Agent: probe\r\n\r\n", 2048))
+}
+
+// incompressibleFixture resembles encrypted traffic.
+func incompressibleFixture() []byte {
+	data := make([]byte, 128<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// export compresses `data` as decided, and records the achieved ratio.
+func export(t *testing.T, adapter *Adapter, iface string, data []byte) Decision {
+	t.Helper()
+
+	decision := adapter.Decide(iface)
+	if !decision.Compress {
+		adapter.Record(decision, 0, 0)
+		return decision
+	}
+
+	var out bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&out, decision.Level)
+	if err != nil {
+		t.Fatalf("invalid level %d: %v", decision.Level, err)
+	}
+	writer.Write(data)
+	writer.Close()
+
+	adapter.Record(decision, int64(len(data)), int64(out.Len()))
+	return decision
+}
+
+// TestAdaptationTrajectory verifies that levels move towards the bounds according to the achieved ratios.
+func TestAdaptationTrajectory(t *testing.T) {
+	const window = 3
+
+	tests := []struct {
+		name   string
+		data   []byte
+		bounds Bounds
+		// level after every window of exports
+		want []int
+	}{
+		{
+			name:   "compressible",
+			data:   compressibleFixture(),
+			bounds: Bounds{Min: 1, Max: 8},
+			want:   []int{7, 8, 8},
+		},
+		{
+			name:   "incompressible",
+			data:   incompressibleFixture(),
+			bounds: Bounds{Min: 4, Max: 9},
+			want:   []int{5, 4, 4},
+		},
+		{
+			name:   "incompressible disabled",
+			data:   incompressibleFixture(),
+			bounds: Bounds{Min: 0, Max: 2},
+			want:   []int{1, 0, 0},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			adapter := NewAdapter(tc.bounds, true, window, nil)
+			for i, want := range tc.want {
+				for range window {
+					decision := export(t, adapter, "eth0", tc.data)
+					if decision.Compress && (decision.Level < max(tc.bounds.Min, 1) || decision.Level > tc.bounds.Max) {
+						t.Fatalf("level %d is out of bounds %+v", decision.Level, tc.bounds)
+					}
+				}
+				if got := adapter.Levels()["eth0"]; got != want {
+					t.Errorf("level after window %d = %d, want %d", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestDisabledProbe verifies that interfaces with compression disabled are probed once per window,
+// and that compression is enabled again when their traffic becomes compressible.
+func TestDisabledProbe(t *testing.T) {
+	adapter := NewAdapter(Bounds{Min: 0, Max: 1}, true, 2, nil)
+
+	for range 2 {
+		export(t, adapter, "eth0", incompressibleFixture())
+	}
+	if decision := adapter.Decide("eth0"); decision.Compress || decision.Reason != REASON_INCOMPRESSIBLE {
+		t.Fatalf("Decide() = %+v, want compression disabled", decision)
+	}
+
+	for range 2 {
+		if decision := export(t, adapter, "eth0", compressibleFixture()); decision.Compress {
+			t.Fatalf("Decide() = %+v before the probe", decision)
+		}
+	}
+	if decision := export(t, adapter, "eth0", compressibleFixture()); !decision.Probe || decision.Level != gzip.BestSpeed {
+		t.Fatalf("Decide() = %+v, want a probe", decision)
+	}
+	if decision := adapter.Decide("eth0"); !decision.Compress || decision.Level != 1 {
+		t.Errorf("Decide() = %+v after a compressible probe", decision)
+	}
+}
+
+// TestPinned verifies that pinned interfaces never adapt, while other interfaces do.
+func TestPinned(t *testing.T) {
+	pins, err := ParsePins([]string{"eth0=9", " lo = 0 ", ""})
+	if err != nil {
+		t.Fatalf("ParsePins() = %v", err)
+	}
+	adapter := NewAdapter(Bounds{Min: 1, Max: 9}, true, 1, pins)
+
+	for range 5 {
+		export(t, adapter, "eth0", incompressibleFixture())
+		export(t, adapter, "lo", compressibleFixture())
+		export(t, adapter, "eth1", incompressibleFixture())
+	}
+
+	if decision := adapter.Decide("eth0"); !decision.Pinned || decision.Level != 9 || !decision.Compress {
+		t.Errorf("Decide(eth0) = %+v", decision)
+	}
+	if decision := adapter.Decide("lo"); !decision.Pinned || decision.Compress {
+		t.Errorf("Decide(lo) = %+v", decision)
+	}
+	if level := adapter.Levels()["eth1"]; level != 1 {
+		t.Errorf("eth1 level = %d, want 1", level)
+	}
+}
+
+// TestDiscontinuity verifies that adaptation restarts when the achieved ratio changes abruptly.
+func TestDiscontinuity(t *testing.T) {
+	adapter := NewAdapter(Bounds{Min: 1, Max: 9}, true, 3, nil)
+
+	for range 2 {
+		export(t, adapter, "eth0", incompressibleFixture())
+	}
+	export(t, adapter, "eth0", compressibleFixture())
+
+	decision := adapter.Decide("eth0")
+	if decision.Reason != REASON_DISCONTINUITY || decision.Level != 6 {
+		t.Errorf("Decide() = %+v, want a reset", decision)
+	}
+}
+
+// TestNotAdaptive verifies that only pinned interfaces deviate from the default level when adaptation is disabled.
+func TestNotAdaptive(t *testing.T) {
+	adapter := NewAdapter(Bounds{Min: 1, Max: 9}, false, 1, map[string]int{"lo": 1})
+
+	for range 3 {
+		export(t, adapter, "eth0", incompressibleFixture())
+	}
+	if decision := adapter.Decide("eth0"); decision.Level != gzip.DefaultCompression || !decision.Compress {
+		t.Errorf("Decide(eth0) = %+v", decision)
+	}
+	if decision := adapter.Decide("lo"); decision.Level != 1 {
+		t.Errorf("Decide(lo) = %+v", decision)
+	}
+}
+
+// TestParsePins verifies the overrides syntax and the levels range.
+func TestParsePins(t *testing.T) {
+	tests := []struct {
+		entries []string
+		wantErr bool
+	}{
+		{[]string{"eth0=0", "lo=9"}, false},
+		{[]string{"eth0"}, true},
+		{[]string{"=1"}, true},
+		{[]string{"eth0=10"}, true},
+		{[]string{"eth0=fast"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.entries, ","), func(t *testing.T) {
+			if _, err := ParsePins(tc.entries); (err != nil) != tc.wantErr {
+				t.Errorf("ParsePins() = %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestLevel verifies that the level is carried by the context.
+func TestLevel(t *testing.T) {
+	if level := Level(context.Background()); level != gzip.DefaultCompression {
+		t.Errorf("Level() = %d, want the default level", level)
+	}
+	if level := Level(WithLevel(context.Background(), 3)); level != 3 {
+		t.Errorf("Level() = %d, want 3", level)
+	}
+}
@@ -14,18 +14,40 @@
 
 package constants
 
+import (
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+)
+
+// event types are defined by `telemetry`, along with the payload of each one of them
 type (
-	PcapEvent string
+	PcapEvent = telemetry.Event
 )
 
 const (
-	PCAP_FSNINI PcapEvent = "PCAP_FSNINI"
-	PCAP_FSNEND PcapEvent = "PCAP_FSNEND"
-	PCAP_FSNERR PcapEvent = "PCAP_FSNERR"
-	PCAP_CREATE PcapEvent = "PCAP_CREATE"
-	PCAP_EXPORT PcapEvent = "PCAP_EXPORT"
-	PCAP_QUEUED PcapEvent = "PCAP_QUEUED"
-	PCAP_OSWMEM PcapEvent = "PCAP_OSWMEM"
-	PCAP_SIGNAL PcapEvent = "PCAP_SIGNAL"
-	PCAP_FSLOCK PcapEvent = "PCAP_FSLOCK"
+	PCAP_FSNINI   = telemetry.PCAP_FSNINI
+	PCAP_FSNEND   = telemetry.PCAP_FSNEND
+	PCAP_FSNERR   = telemetry.PCAP_FSNERR
+	PCAP_CREATE   = telemetry.PCAP_CREATE
+	PCAP_EXPORT   = telemetry.PCAP_EXPORT
+	PCAP_QUEUED   = telemetry.PCAP_QUEUED
+	PCAP_OSWMEM   = telemetry.PCAP_OSWMEM
+	PCAP_SIGNAL   = telemetry.PCAP_SIGNAL
+	PCAP_FSLOCK   = telemetry.PCAP_FSLOCK
+	PCAP_MFLUSH   = telemetry.PCAP_MFLUSH
+	PCAP_SCHEDL   = telemetry.PCAP_SCHEDL
+	PCAP_RMOUNT   = telemetry.PCAP_RMOUNT
+	PCAP_SLO      = telemetry.PCAP_SLO
+	PCAP_IFACES   = telemetry.PCAP_IFACES
+	PCAP_PRESSURE = telemetry.PCAP_PRESSURE
+	PCAP_ANALYSIS = telemetry.PCAP_ANALYSIS
+	PCAP_MIRROR   = telemetry.PCAP_MIRROR
+	PCAP_CHKPNT   = telemetry.PCAP_CHKPNT
+	PCAP_BACKFILL = telemetry.PCAP_BACKFILL
+	PCAP_WINDOW   = telemetry.PCAP_WINDOW
+	PCAP_FETCH    = telemetry.PCAP_FETCH
+	PCAP_SAMPLE   = telemetry.PCAP_SAMPLE
+	PCAP_CANARY   = telemetry.PCAP_CANARY
+	PCAP_CODECS   = telemetry.PCAP_CODECS
+	PCAP_FSTRACE  = telemetry.PCAP_FSTRACE
+	PCAP_EVENTS   = telemetry.PCAP_EVENTS
 )
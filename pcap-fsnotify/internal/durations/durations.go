@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package durations provides flags which accept duration strings ( i.e. `1m30s`, `500ms` ),
+// and bare integers interpreted as seconds for backward compatibility.
+package durations
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type secondsValue time.Duration
+
+// Parse returns the duration of `value`; bare integers are seconds.
+func Parse(
+	value string,
+) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %q", value)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("negative duration: %q", value)
+	}
+	return duration, nil
+}
+
+func (d *secondsValue) Set(value string) error {
+	duration, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*d = secondsValue(duration)
+	return nil
+}
+
+func (d *secondsValue) String() string {
+	return time.Duration(*d).String()
+}
+
+// Flag defines a duration flag on the default flag set.
+func Flag(
+	name string,
+	value time.Duration,
+	usage string,
+) *time.Duration {
+	return FlagOn(flag.CommandLine, name, value, usage)
+}
+
+func FlagOn(
+	flags *flag.FlagSet,
+	name string,
+	value time.Duration,
+	usage string,
+) *time.Duration {
+	duration := value
+	flags.Var((*secondsValue)(&duration), name, usage)
+	return &duration
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package durations
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestParse verifies that bare integers are seconds, and that duration strings are also accepted.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"60", 60 * time.Second, false},
+		{" 0 ", 0, false},
+		{"1m30s", 90 * time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"-1s", 0, true},
+		{"-1", 0, true},
+		{"1.5", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tc := range tests {
+		got, err := Parse(tc.value)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("Parse(%q) = %v, %v; want %v (error: %v)", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+// TestFlag verifies defaults and parsed values of duration flags.
+func TestFlag(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	interval := FlagOn(flags, "interval", 60*time.Second, "")
+	delay := FlagOn(flags, "delay", 2*time.Second, "")
+
+	if err := flags.Parse([]string{"-interval=250ms"}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if *interval != 250*time.Millisecond || *delay != 2*time.Second {
+		t.Errorf("interval=%v delay=%v; want 250ms and 2s", *interval, *delay)
+	}
+	if got := flags.Lookup("delay").DefValue; got != "2s" {
+		t.Errorf("default value = %q, want 2s", got)
+	}
+	if err := flags.Parse([]string{"-delay=never"}); err == nil {
+		t.Error("Parse(-delay=never) succeeded, want error")
+	}
+}
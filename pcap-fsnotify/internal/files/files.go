@@ -0,0 +1,529 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package files serves PCAP files exported by the current session, so that they can be retrieved
+// from within the VPC when the destination bucket is not reachable.
+//
+// Access requires a bearer token, is rate limited, and every request is audited.
+package files
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/auth"
+)
+
+type (
+	// Entry describes an exported file; `Name` is relative to the session directory.
+	Entry struct {
+		Name string    `json:"name"`
+		Size int64     `json:"size"`
+		Time time.Time `json:"time"`
+	}
+
+	// Fetch is the audit record of a single request; `Name` is empty for listings.
+	Fetch struct {
+		Who       string
+		UserAgent string
+		Session   string
+		Name      string
+		Range     string
+		Status    int
+		Bytes     int64
+		Time      time.Time
+		Latency   time.Duration
+	}
+
+	Options struct {
+		// Directory is where the current session exports PCAP files; its base name identifies the session
+		Directory string
+		Token     *auth.Token
+		// MaxBytes caps the bytes served by a single response
+		MaxBytes int64
+		// PerMinute caps requests; `0` disables rate limiting
+		PerMinute uint
+		Audit     func(*Fetch)
+		Now       func() time.Time
+	}
+
+	// Server is safe for concurrent use.
+	Server struct {
+		directory string
+		session   string
+		token     *auth.Token
+		maxBytes  int64
+		audit     func(*Fetch)
+		now       func() time.Time
+		limiter   *limiter
+		closing   atomic.Bool
+		mux       *http.ServeMux
+	}
+
+	// limiter is a token bucket which allows bursts of up to `capacity` requests.
+	limiter struct {
+		mu        sync.Mutex
+		capacity  float64
+		perSecond float64
+		tokens    float64
+		last      time.Time
+	}
+)
+
+const (
+	PATH_FILES = "/files"
+
+	compressedSuffix = ".gz"
+)
+
+var errInvalidRange = errors.New("invalid range")
+
+type fetchKey struct{}
+
+func withFetch(
+	ctx context.Context,
+	fetch *Fetch,
+) context.Context {
+	return context.WithValue(ctx, fetchKey{}, fetch)
+}
+
+func fetchOf(
+	ctx context.Context,
+) *Fetch {
+	if fetch, ok := ctx.Value(fetchKey{}).(*Fetch); ok {
+		return fetch
+	}
+	return &Fetch{}
+}
+
+func NewServer(
+	opts *Options,
+) (*Server, error) {
+	if opts.Directory == "" {
+		return nil, errors.New("directory is required")
+	}
+	if opts.Token == nil {
+		return nil, errors.New("token is required")
+	}
+	if opts.MaxBytes <= 0 {
+		return nil, fmt.Errorf("max bytes must be positive: %d", opts.MaxBytes)
+	}
+
+	s := &Server{
+		directory: opts.Directory,
+		session:   filepath.Base(opts.Directory),
+		token:     opts.Token,
+		maxBytes:  opts.MaxBytes,
+		audit:     opts.Audit,
+		now:       opts.Now,
+		mux:       http.NewServeMux(),
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+	if s.audit == nil {
+		s.audit = func(*Fetch) {}
+	}
+	if opts.PerMinute > 0 {
+		s.limiter = &limiter{
+			capacity:  float64(opts.PerMinute),
+			perSecond: float64(opts.PerMinute) / 60,
+			tokens:    float64(opts.PerMinute),
+			last:      s.now(),
+		}
+	}
+
+	s.mux.HandleFunc("GET "+PATH_FILES, s.handleList)
+	s.mux.HandleFunc("GET "+PATH_FILES+"/{session}/{name...}", s.handleFetch)
+	return s, nil
+}
+
+// Session returns the identifier of the session whose files are served.
+func (s *Server) Session() string {
+	return s.session
+}
+
+// Close refuses all further requests; it is called as soon as shutdown starts.
+func (s *Server) Close() {
+	s.closing.Store(true)
+}
+
+func (l *limiter) allow(
+	now time.Time,
+) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens = math.Min(l.capacity, l.tokens+elapsed*l.perSecond)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// ServeHTTP enforces shutdown, authentication, and rate limiting before serving any request.
+func (s *Server) ServeHTTP(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	start := s.now()
+	fetch := &Fetch{
+		Who:       r.RemoteAddr,
+		UserAgent: r.UserAgent(),
+		Range:     r.Header.Get("Range"),
+		Time:      start,
+	}
+	rw := &recorder{ResponseWriter: w, status: http.StatusOK}
+
+	defer func() {
+		fetch.Status = rw.status
+		if rw.status < http.StatusMultipleChoices {
+			// error messages are not file content
+			fetch.Bytes = rw.bytes
+		}
+		fetch.Latency = s.now().Sub(start)
+		s.audit(fetch)
+	}()
+
+	switch {
+	case s.closing.Load():
+		http.Error(rw, "shutdown in progress", http.StatusServiceUnavailable)
+	case !s.token.IsAuthorized(r):
+		auth.Challenge(rw)
+	case s.limiter != nil && !s.limiter.allow(start):
+		rw.Header().Set("Retry-After", "60")
+		http.Error(rw, "too many requests", http.StatusTooManyRequests)
+	default:
+		s.mux.ServeHTTP(rw, r.WithContext(withFetch(r.Context(), fetch)))
+	}
+}
+
+func (s *Server) handleList(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	fetchOf(r.Context()).Session = s.session
+
+	entries, err := s.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"session": s.session, "files": entries})
+}
+
+// List returns name, size, and modification time of all files exported by the current session.
+func (s *Server) List() ([]*Entry, error) {
+	root, err := os.OpenRoot(s.directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []*Entry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	entries := []*Entry{}
+	err = fs.WalkDir(root.FS(), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &Entry{Name: name, Size: info.Size(), Time: info.ModTime().UTC()})
+		return nil
+	})
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries, err
+}
+
+func (s *Server) handleFetch(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	session, name := r.PathValue("session"), r.PathValue("name")
+	fetch := fetchOf(r.Context())
+	fetch.Session, fetch.Name = session, name
+
+	// files of other sessions are never served, nor files outside of the session directory
+	if session != s.session || !filepath.IsLocal(name) || filepath.Clean(name) != name {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	root, err := os.OpenRoot(s.directory)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer root.Close()
+
+	// `os.Root` also refuses symbolic links which point outside of the session directory
+	info, err := root.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	s.serveFile(w, r, &file{root: root, name: name, size: info.Size()})
+}
+
+// parseRange supports a single range: `bytes=first-last`, `bytes=first-`, or the suffix `bytes=-length`;
+// `first` is `-1` for suffixes, and `last` is `-1` when the range is open ended.
+func parseRange(
+	header string,
+) (first, last int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("%w: only a single bytes range is supported: %s", errInvalidRange, header)
+	}
+	from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %s", errInvalidRange, header)
+	}
+	if from == "" {
+		length, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || length <= 0 {
+			return 0, 0, fmt.Errorf("%w: %s", errInvalidRange, header)
+		}
+		return -1, length, nil
+	}
+	if first, err = strconv.ParseInt(from, 10, 64); err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("%w: %s", errInvalidRange, header)
+	}
+	if to == "" {
+		return first, -1, nil
+	}
+	if last, err = strconv.ParseInt(to, 10, 64); err != nil || last < first {
+		return 0, 0, fmt.Errorf("%w: %s", errInvalidRange, header)
+	}
+	return first, last, nil
+}
+
+func (s *Server) serveFile(
+	w http.ResponseWriter,
+	r *http.Request,
+	f *file,
+) {
+	header := r.Header.Get("Range")
+	ranged := header != ""
+
+	first, last := int64(0), int64(-1)
+	if ranged {
+		var err error
+		if first, last, err = parseRange(header); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if first >= 0 && last >= 0 && last-first+1 > s.maxBytes {
+			http.Error(w, fmt.Sprintf("ranges are limited to %d bytes", s.maxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	// the size of decompressed files is measured up to the bytes that would be served
+	size, complete := f.size, true
+	if f.isCompressed() {
+		limit := s.maxBytes + 1
+		switch {
+		case !ranged:
+		case first < 0:
+			limit = math.MaxInt64
+		case last >= 0:
+			limit = last + 1
+		default:
+			limit = first + s.maxBytes
+		}
+		var err error
+		if size, complete, err = f.measure(limit); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decompress: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	start, end := int64(0), size-1
+	switch {
+	case !ranged && (!complete || size > s.maxBytes):
+		http.Error(w, fmt.Sprintf("responses are limited to %d bytes; use a range request", s.maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	case !ranged:
+	case first < 0:
+		start = max(size-last, 0)
+	case complete && (first >= size || size == 0):
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	case last >= 0:
+		start, end = first, min(last, size-1)
+	default:
+		start, end = first, size-1
+	}
+	if !complete {
+		// decompressed files larger than `limit` contain at least the requested range
+		end = last
+		if last < 0 {
+			end = first + s.maxBytes - 1
+		}
+	}
+	// open ended ranges are served up to the max bytes of a single response
+	end = min(end, start+s.maxBytes-1)
+	length := end - start + 1
+
+	content, err := f.open()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	if err := skip(content, start); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.servedName()))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if ranged {
+		total := "*"
+		if complete {
+			total = strconv.FormatInt(size, 10)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, end, total))
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	io.CopyN(w, content, length)
+}
+
+type (
+	file struct {
+		root *os.Root
+		name string
+		// size of the stored file; the decompressed size is measured on demand
+		size int64
+	}
+
+	content struct {
+		io.Reader
+		closers []io.Closer
+	}
+
+	recorder struct {
+		http.ResponseWriter
+		status int
+		bytes  int64
+	}
+)
+
+func (f *file) isCompressed() bool {
+	return strings.HasSuffix(f.name, compressedSuffix)
+}
+
+// servedName is the name of the file as it is served: decompressed files lose their suffix.
+func (f *file) servedName() string {
+	return strings.TrimSuffix(path.Base(filepath.ToSlash(f.name)), compressedSuffix)
+}
+
+// open returns the content of the file, decompressing it transparently.
+func (f *file) open() (*content, error) {
+	stored, err := f.root.Open(f.name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.isCompressed() {
+		return &content{Reader: stored, closers: []io.Closer{stored}}, nil
+	}
+	decompressed, err := gzip.NewReader(stored)
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+	return &content{Reader: decompressed, closers: []io.Closer{decompressed, stored}}, nil
+}
+
+// measure returns the size of the content when it is smaller than `limit`; otherwise it is incomplete.
+func (f *file) measure(
+	limit int64,
+) (int64, bool, error) {
+	c, err := f.open()
+	if err != nil {
+		return 0, false, err
+	}
+	defer c.Close()
+
+	n, err := io.CopyN(io.Discard, c, limit)
+	if errors.Is(err, io.EOF) {
+		return n, true, nil
+	}
+	return n, false, err
+}
+
+func (c *content) Close() error {
+	var errs []error
+	for _, closer := range c.closers {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func skip(
+	c *content,
+	offset int64,
+) error {
+	if seeker, ok := c.Reader.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, c, offset)
+	return err
+}
+
+func (r *recorder) WriteHeader(
+	status int,
+) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(
+	p []byte,
+) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package files
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/auth"
+)
+
+const (
+	testSession = "instance-1"
+	testToken   = "secret-token"
+)
+
+// content is deterministic so that any byte range can be verified
+func testContent(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+type testServer struct {
+	*Server
+	directory string
+	content   []byte
+	audits    []*Fetch
+	now       time.Time
+}
+
+func newTestServer(t *testing.T, maxBytes int64, perMinute uint) *testServer {
+	t.Helper()
+
+	base := t.TempDir()
+	directory := filepath.Join(base, testSession)
+	if err := os.MkdirAll(filepath.Join(directory, "shard00"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{directory: directory, content: testContent(1000), now: time.Unix(1700000000, 0)}
+
+	if err := os.WriteFile(filepath.Join(directory, "plain.pcap"), ts.content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(ts.content)
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(directory, "shard00", "compressed.pcap.gz"), compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// files outside of the session directory must never be served
+	if err := os.WriteFile(filepath.Join(base, "outside.pcap"), []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(base, "outside.pcap"), filepath.Join(directory, "link.pcap")); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := auth.NewToken(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(&Options{
+		Directory: directory,
+		Token:     token,
+		MaxBytes:  maxBytes,
+		PerMinute: perMinute,
+		Audit:     func(fetch *Fetch) { ts.audits = append(ts.audits, fetch) },
+		Now:       func() time.Time { return ts.now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Server = server
+	return ts
+}
+
+func (ts *testServer) get(target, token, rangeHeader string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if rangeHeader != "" {
+		r.Header.Set("Range", rangeHeader)
+	}
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, r)
+	return w
+}
+
+// TestAuthentication verifies that files are only served with the bearer token.
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 0)
+
+	tests := []struct {
+		name   string
+		token  string
+		target string
+		want   int
+	}{
+		{"missing token", "", "/files/instance-1/plain.pcap", http.StatusUnauthorized},
+		{"wrong token", "other", "/files/instance-1/plain.pcap", http.StatusUnauthorized},
+		{"listing without token", "", "/files", http.StatusUnauthorized},
+		{"valid token", testToken, "/files/instance-1/plain.pcap", http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := ts.get(tc.target, tc.token, ""); w.Code != tc.want {
+				t.Errorf("GET %s = %d, want %d", tc.target, w.Code, tc.want)
+			}
+		})
+	}
+}
+
+// TestSessionPrefix verifies that only files within the current session directory are served.
+func TestSessionPrefix(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 0)
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"current session", "/files/instance-1/plain.pcap", http.StatusOK},
+		{"sharded", "/files/instance-1/shard00/compressed.pcap.gz", http.StatusOK},
+		{"other session", "/files/instance-2/plain.pcap", http.StatusForbidden},
+		{"escaped traversal", "/files/instance-1/..%2F..%2Foutside.pcap", http.StatusForbidden},
+		{"escaped session traversal", "/files/instance-1/shard00%2F..%2F..%2Foutside.pcap", http.StatusForbidden},
+		{"symbolic link to outside", "/files/instance-1/link.pcap", http.StatusNotFound},
+		{"directory", "/files/instance-1/shard00", http.StatusNotFound},
+		{"missing", "/files/instance-1/missing.pcap", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := ts.get(tc.target, testToken, "")
+			if w.Code != tc.want {
+				t.Fatalf("GET %s = %d, want %d", tc.target, w.Code, tc.want)
+			}
+			if bytes.Contains(w.Body.Bytes(), []byte("outside")) {
+				t.Errorf("GET %s served a file outside of the session directory", tc.target)
+			}
+		})
+	}
+}
+
+// TestRange verifies range semantics for stored and decompressed files, and the max bytes of a response.
+func TestRange(t *testing.T) {
+	ts := newTestServer(t, 400, 0)
+
+	tests := []struct {
+		name        string
+		rangeHeader string
+		want        int
+		wantStart   int
+		wantEnd     int
+		wantRange   string
+		wantGzRange string
+	}{
+		{"first bytes", "bytes=0-9", http.StatusPartialContent, 0, 9, "bytes 0-9/1000", "bytes 0-9/*"},
+		{"middle", "bytes=100-199", http.StatusPartialContent, 100, 199, "bytes 100-199/1000", "bytes 100-199/*"},
+		{"open ended is capped", "bytes=100-", http.StatusPartialContent, 100, 499, "bytes 100-499/1000", "bytes 100-499/*"},
+		{"open ended tail", "bytes=900-", http.StatusPartialContent, 900, 999, "bytes 900-999/1000", "bytes 900-999/1000"},
+		{"suffix", "bytes=-10", http.StatusPartialContent, 990, 999, "bytes 990-999/1000", "bytes 990-999/1000"},
+		{"last beyond end", "bytes=990-2000", http.StatusRequestEntityTooLarge, 0, 0, "", ""},
+		{"clipped last", "bytes=990-1200", http.StatusPartialContent, 990, 999, "bytes 990-999/1000", "bytes 990-999/1000"},
+		{"too large", "bytes=0-500", http.StatusRequestEntityTooLarge, 0, 0, "", ""},
+		{"not satisfiable", "bytes=1000-", http.StatusRequestedRangeNotSatisfiable, 0, 0, "", ""},
+		{"multiple ranges", "bytes=0-9,20-29", http.StatusRequestedRangeNotSatisfiable, 0, 0, "", ""},
+		{"malformed", "bytes=9-0", http.StatusRequestedRangeNotSatisfiable, 0, 0, "", ""},
+		{"unknown unit", "items=0-9", http.StatusRequestedRangeNotSatisfiable, 0, 0, "", ""},
+		{"whole file is too large", "", http.StatusRequestEntityTooLarge, 0, 0, "", ""},
+	}
+
+	targets := map[string]string{
+		"stored":       "/files/instance-1/plain.pcap",
+		"decompressed": "/files/instance-1/shard00/compressed.pcap.gz",
+	}
+
+	for kind, target := range targets {
+		for _, tc := range tests {
+			t.Run(kind+"/"+tc.name, func(t *testing.T) {
+				wantRange := tc.wantRange
+				if kind == "decompressed" {
+					wantRange = tc.wantGzRange
+				}
+				w := ts.get(target, testToken, tc.rangeHeader)
+				if w.Code != tc.want {
+					t.Fatalf("GET %s [%s] = %d, want %d: %s", target, tc.rangeHeader, w.Code, tc.want, w.Body.String())
+				}
+				if tc.want != http.StatusPartialContent {
+					return
+				}
+				if got := w.Header().Get("Content-Range"); got != wantRange {
+					t.Errorf("Content-Range = %q, want %q", got, wantRange)
+				}
+				if !bytes.Equal(w.Body.Bytes(), ts.content[tc.wantStart:tc.wantEnd+1]) {
+					t.Errorf("GET %s [%s] served %d unexpected bytes", target, tc.rangeHeader, w.Body.Len())
+				}
+			})
+		}
+	}
+}
+
+// TestDecompression verifies that compressed files are decompressed on the fly.
+func TestDecompression(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 0)
+
+	w := ts.get("/files/instance-1/shard00/compressed.pcap.gz", testToken, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !bytes.Equal(w.Body.Bytes(), ts.content) {
+		t.Errorf("served %d bytes, want the %d decompressed bytes", w.Body.Len(), len(ts.content))
+	}
+	if got, want := w.Header().Get("Content-Length"), "1000"; got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="compressed.pcap"`; got != want {
+		t.Errorf("Content-Disposition = %s, want %s", got, want)
+	}
+}
+
+// TestList verifies that listings only contain name, size, and time of the files within the session directory.
+func TestList(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 0)
+
+	w := ts.get("/files", testToken, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /files = %d, want %d", w.Code, http.StatusOK)
+	}
+	var listing struct {
+		Session string            `json:"session"`
+		Files   []json.RawMessage `json:"files"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Session != testSession {
+		t.Errorf("session = %s, want %s", listing.Session, testSession)
+	}
+
+	var names []string
+	for _, raw := range listing.Files {
+		var fields map[string]any
+		json.Unmarshal(raw, &fields)
+		if len(fields) != 3 {
+			t.Errorf("entry %s, want name, size, and time only", raw)
+		}
+		names = append(names, fields["name"].(string))
+	}
+	// the symbolic link is not a regular file
+	if want := []string{"plain.pcap", "shard00/compressed.pcap.gz"}; len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+}
+
+// TestAudit verifies that every request is audited, and that only served content is counted.
+func TestAudit(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 0)
+
+	ts.get("/files/instance-1/plain.pcap", testToken, "bytes=0-99")
+	ts.get("/files/instance-2/plain.pcap", testToken, "")
+	ts.get("/files/instance-1/plain.pcap", "", "")
+
+	want := []Fetch{
+		{Session: testSession, Name: "plain.pcap", Range: "bytes=0-99", Status: http.StatusPartialContent, Bytes: 100},
+		{Session: "instance-2", Name: "plain.pcap", Status: http.StatusForbidden},
+		{Status: http.StatusUnauthorized},
+	}
+	if len(ts.audits) != len(want) {
+		t.Fatalf("got %d audits, want %d", len(ts.audits), len(want))
+	}
+	for i, fetch := range ts.audits {
+		if fetch.Who == "" || !fetch.Time.Equal(ts.now) {
+			t.Errorf("audit %d: who=%q time=%v, want the client and the time of the request", i, fetch.Who, fetch.Time)
+		}
+		if fetch.Session != want[i].Session || fetch.Name != want[i].Name || fetch.Range != want[i].Range ||
+			fetch.Status != want[i].Status || fetch.Bytes != want[i].Bytes {
+			t.Errorf("audit %d: %+v, want %+v", i, *fetch, want[i])
+		}
+	}
+}
+
+// TestRateLimit verifies that requests beyond the rate are rejected until tokens are refilled.
+func TestRateLimit(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 2)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := ts.get("/files", testToken, ""); w.Code != want {
+			t.Errorf("request %d = %d, want %d", i, w.Code, want)
+		}
+	}
+	ts.now = ts.now.Add(30 * time.Second)
+	if w := ts.get("/files", testToken, ""); w.Code != http.StatusOK {
+		t.Errorf("request after refill = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// TestClose verifies that no files are served once shutdown is in progress.
+func TestClose(t *testing.T) {
+	ts := newTestServer(t, 1<<20, 0)
+	ts.Close()
+
+	w := ts.get("/files/instance-1/plain.pcap", testToken, "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET after Close = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if body, _ := io.ReadAll(w.Body); bytes.Contains(body, ts.content[:10]) {
+		t.Error("file content served during shutdown")
+	}
+}
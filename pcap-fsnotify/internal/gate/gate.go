@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gate holds PCAP files export bookkeeping until the capture engine confirms
+// that its capture session has started by creating the `TCPDUMPW_READY` sentinel.
+package gate
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	State string
+
+	// StartGate is safe for concurrent use.
+	StartGate struct {
+		mu      sync.Mutex
+		state   State
+		readyAt time.Time
+		pending []string
+	}
+)
+
+const (
+	// STATE_WAITING: sentinel not seen yet; PCAP files are held
+	STATE_WAITING = State("waiting")
+	// STATE_READY: sentinel seen; PCAP files belong to the capture session
+	STATE_READY = State("ready")
+	// STATE_DEGRADED: sentinel not seen before the startup timeout, or gate disabled
+	STATE_DEGRADED = State("degraded")
+)
+
+// NewStartGate returns a gate that holds PCAP files until `Ready` or `Expire` are called;
+// a disabled gate never holds PCAP files.
+func NewStartGate(enabled bool) *StartGate {
+	g := &StartGate{state: STATE_DEGRADED}
+	if enabled {
+		g.state = STATE_WAITING
+	}
+	return g
+}
+
+// Admit returns `true` if the PCAP file should go through regular export bookkeeping;
+// otherwise the PCAP file is held until the gate is opened.
+func (g *StartGate) Admit(pcapFile string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.state != STATE_WAITING {
+		return true
+	}
+	g.pending = append(g.pending, pcapFile)
+	return false
+}
+
+// Ready opens the gate when the sentinel is detected; it returns the PCAP files
+// created before the sentinel: pre-session artifacts which must not be counted as rotations.
+// A late sentinel ( after `Expire` ) only records the session start.
+func (g *StartGate) Ready(ts time.Time) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.readyAt.IsZero() {
+		return nil
+	}
+	g.readyAt = ts
+
+	if g.state != STATE_WAITING {
+		return nil
+	}
+	g.state = STATE_READY
+	return g.drain()
+}
+
+// Expire opens the gate when no sentinel was detected within the startup timeout;
+// it returns the held PCAP files, in creation order, to go through regular export bookkeeping.
+func (g *StartGate) Expire() ([]string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.state != STATE_WAITING {
+		return nil, false
+	}
+	g.state = STATE_DEGRADED
+	return g.drain(), true
+}
+
+func (g *StartGate) drain() []string {
+	pending := g.pending
+	g.pending = nil
+	return pending
+}
+
+func (g *StartGate) State() State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state
+}
+
+// ReadyAt returns when the capture session started, if the sentinel was ever detected.
+func (g *StartGate) ReadyAt() (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.readyAt, !g.readyAt.IsZero()
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gate
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestPreSessionFiles verifies that files created before the sentinel are returned as pre-session artifacts.
+func TestPreSessionFiles(t *testing.T) {
+	t.Parallel()
+
+	g := NewStartGate(true)
+	if g.Admit("a.pcap") || g.Admit("b.pcap") {
+		t.Fatal("files were admitted before the sentinel")
+	}
+
+	ts := time.Now()
+	if got := g.Ready(ts); !slices.Equal(got, []string{"a.pcap", "b.pcap"}) {
+		t.Errorf("pre-session files: got %v", got)
+	}
+	if !g.Admit("c.pcap") {
+		t.Error("file was not admitted after the sentinel")
+	}
+	if readyAt, ok := g.ReadyAt(); !ok || !readyAt.Equal(ts) {
+		t.Errorf("ready at: got %v, want %v", readyAt, ts)
+	}
+	if pending, expired := g.Expire(); expired || pending != nil {
+		t.Error("ready gate must not expire")
+	}
+}
+
+// TestMissingSentinel verifies that held files are replayed in order when the sentinel never appears.
+func TestMissingSentinel(t *testing.T) {
+	t.Parallel()
+
+	g := NewStartGate(true)
+	g.Admit("a.pcap")
+	g.Admit("b.pcap")
+
+	pending, expired := g.Expire()
+	if !expired || !slices.Equal(pending, []string{"a.pcap", "b.pcap"}) {
+		t.Errorf("expired: %t, pending: %v", expired, pending)
+	}
+	if g.State() != STATE_DEGRADED || !g.Admit("c.pcap") {
+		t.Error("expired gate must admit all files")
+	}
+	if _, ok := g.ReadyAt(); ok {
+		t.Error("session start must be unknown")
+	}
+}
+
+// TestLateSentinel verifies that a sentinel after the startup timeout only records the session start.
+func TestLateSentinel(t *testing.T) {
+	t.Parallel()
+
+	g := NewStartGate(true)
+	g.Admit("a.pcap")
+	g.Expire()
+
+	if got := g.Ready(time.Now()); got != nil {
+		t.Errorf("late sentinel returned files: %v", got)
+	}
+	if _, ok := g.ReadyAt(); !ok {
+		t.Error("late sentinel must record the session start")
+	}
+	if g.State() != STATE_DEGRADED {
+		t.Errorf("state: got %s, want %s", g.State(), STATE_DEGRADED)
+	}
+}
+
+// TestDisabledGate verifies that a disabled gate never holds files.
+func TestDisabledGate(t *testing.T) {
+	t.Parallel()
+
+	g := NewStartGate(false)
+	if !g.Admit("a.pcap") {
+		t.Error("disabled gate held a file")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/canary"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+)
+
+type (
+	// fuseCanary stores canary objects next to the exported PCAP files, using GCS Fuse.
+	fuseCanary struct {
+		*exporter
+		compress bool
+	}
+)
+
+func (c *fuseCanary) encode(
+	content []byte,
+) ([]byte, error) {
+	if !c.compress {
+		return content, nil
+	}
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	if _, err := gzipWriter.Write(content); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *fuseCanary) Write(
+	_ context.Context,
+	name string,
+	content []byte,
+) (string, error) {
+	location := c.toTargetPcapFile(&name, c.compress)
+
+	if c.shards != nil {
+		if err := os.MkdirAll(filepath.Dir(location), 0o777); err != nil {
+			return location, err
+		}
+	}
+
+	data, err := c.encode(content)
+	if err != nil {
+		return location, err
+	}
+
+	// canary objects are never reused: an existing one must not be overwritten by this step
+	f, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return location, err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return location, err
+	}
+	return location, f.Close()
+}
+
+func (c *fuseCanary) Read(
+	_ context.Context,
+	location string,
+) ([]byte, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if c.compress {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+	return io.ReadAll(r)
+}
+
+func (c *fuseCanary) Overwrite(
+	_ context.Context,
+	location string,
+	content []byte,
+) error {
+	data, err := c.encode(content)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(location, data, 0o666)
+}
+
+func (c *fuseCanary) Delete(
+	_ context.Context,
+	location string,
+) error {
+	return os.Remove(location)
+}
+
+// Encryption is not supported: GCS Fuse does not expose objects metadata.
+func (c *fuseCanary) Encryption(
+	_ context.Context,
+	_ string,
+) (string, error) {
+	return "", canary.ErrUnsupported
+}
+
+// NewFuseCanary stores canary objects in `directory` using the same layout and compression as exported PCAP files.
+func NewFuseCanary(
+	logger *log.Logger,
+	directory string,
+	shards *Shards,
+	compress bool,
+) canary.Storage {
+	return &fuseCanary{
+		exporter: newExporter(logger, directory, "", 0, 0, shards),
+		compress: compress,
+	}
+}
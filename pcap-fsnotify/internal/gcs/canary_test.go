@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/canary"
+)
+
+// TestFuseCanary verifies that a GCS Fuse destination is fully characterized, using the same compression as PCAP files.
+func TestFuseCanary(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(map[bool]string{false: "uncompressed", true: "compressed"}[compress], func(t *testing.T) {
+			directory := t.TempDir()
+			prober := canary.NewProber(NewFuseCanary(newTestLogger(), directory, nil, compress), 0, time.Now)
+
+			got := prober.Probe(context.Background())
+			if !got.Healthy() || got.SurvivesDelay != canary.OUTCOME_YES ||
+				got.Overwritable != canary.OUTCOME_YES || got.Deletable != canary.OUTCOME_YES {
+				t.Errorf("Probe() = %+v", got)
+			}
+			// GCS Fuse does not expose objects metadata
+			if got.EncryptionVerified != canary.OUTCOME_UNKNOWN {
+				t.Errorf("encryption = %s, want %s", got.EncryptionVerified, canary.OUTCOME_UNKNOWN)
+			}
+			if filepath.Dir(got.Location) != directory || (filepath.Ext(got.Location) == ".gz") != compress {
+				t.Errorf("location = %s", got.Location)
+			}
+			if matches, _ := filepath.Glob(filepath.Join(directory, "*")); len(matches) != 0 {
+				t.Errorf("canary objects left behind: %v", matches)
+			}
+		})
+	}
+}
@@ -17,12 +17,12 @@ package gcs
 import (
 	"context"
 	"fmt"
-	"maps"
 	"net"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
 	"github.com/avast/retry-go/v4"
 	"github.com/googleapis/gax-go/v2"
@@ -43,10 +43,13 @@ type (
 		service    string
 		instanceID string
 		bucket     string
+		audit      log.Fields
 		client     *storage.Client
 		handle     *storage.BucketHandle
 		dialer     *net.Dialer
 		keepalive  keepalive.ClientParameters
+		// customer-managed encryption key; empty uses the bucket's default encryption
+		kmsKey string
 	}
 
 	contextKey string
@@ -55,12 +58,31 @@ type (
 const (
 	sourcePcapFile = contextKey("source_pcap_file")
 	targetPcapFile = contextKey("target_pcap_file")
+	objectMetadata = contextKey("object_metadata")
 
 	// see: https://pkg.go.dev/google.golang.org/grpc#WithContextDialer
 	gcsEndpoint = "passthrough:storage.googleapis.com"
 	gcsPort     = uint16(443)
+
+	auditHeaderPrefix = "x-goog-custom-audit-"
 )
 
+// identity fields which may be attached to GCS audit logs, and their headers
+var auditHeaders = map[log.Field]string{
+	log.FIELD_PROJECT:  "project",
+	log.FIELD_SERVICE:  "service",
+	log.FIELD_INSTANCE: "instance-id",
+}
+
+// WithMetadata returns a context which carries custom metadata attached to the GCS object of an exported PCAP file;
+// it is ignored when exporting using GCS Fuse.
+func WithMetadata(
+	ctx context.Context,
+	metadata map[string]string,
+) context.Context {
+	return context.WithValue(ctx, objectMetadata, metadata)
+}
+
 func (x *libraryExporter) onIntialized(
 	client *storage.Client,
 	handle *storage.BucketHandle,
@@ -72,17 +94,18 @@ func (x *libraryExporter) onIntialized(
 	bucketName := string(attrs.Name)
 	x.bucket = bucketName
 
-	data := map[string]any{
-		"bucket": bucketName,
+	data := &telemetry.Export{
+		Bucket: bucketName,
+		KMSKey: x.kmsKey,
+		Labels: attrs.Labels,
 	}
-	for label, value := range attrs.Labels {
-		data[label] = value
+	if attrs.Encryption != nil {
+		data.DefaultKMSKey = attrs.Encryption.DefaultKMSKeyName
 	}
 
 	x.logger.LogEvent(
 		zapcore.InfoLevel,
 		sf.Format("initialized GCS client library exporter with bucket: {0}", bucketName),
-		PCAP_EXPORT,
 		data,
 		nil)
 
@@ -106,9 +129,8 @@ func (x *libraryExporter) setBucketHandle(
 		x.logger.LogEvent(
 			zapcore.ErrorLevel,
 			sf.Format("failed to initialize GCS client library exporter with bucket: {0}", bucket),
-			PCAP_EXPORT,
-			map[string]any{
-				"bucket": bucket,
+			&telemetry.Export{
+				Bucket: bucket,
 			},
 			err)
 		return x, err
@@ -146,10 +168,9 @@ func (x *libraryExporter) connect(
 			x.logger.LogEvent(
 				zapcore.WarnLevel,
 				sf.Format("failed to connect at attempt {0}: {1}", _attempt, addr),
-				PCAP_EXPORT,
-				map[string]any{
-					"address": *address,
-					"attempt": addr,
+				&telemetry.Export{
+					Address: *address,
+					Attempt: telemetry.Ptr(int(_attempt)),
 				},
 				err)
 		}),
@@ -162,34 +183,30 @@ func (x *libraryExporter) dialContext(
 ) (net.Conn, error) {
 	address := x.gcsRemoteAddr(&addr)
 
-	data := map[string]any{
-		"endpoint": addr,
-		"address":  address,
-		"bucket":   x.bucket,
+	data := &telemetry.Export{
+		Endpoint: addr,
+		Address:  address,
+		Bucket:   x.bucket,
 	}
 
 	if conn, err := x.connect(ctx, &address); err != nil {
 		x.logger.LogEvent(
 			zapcore.ErrorLevel,
 			sf.Format("failed to connect to GCS: {0}", address),
-			PCAP_EXPORT,
 			data,
 			err)
 		return nil, err
 	} else {
 		remoteAddrStr := conn.RemoteAddr().String()
 
-		info := map[string]any{
-			"local":  conn.LocalAddr().String(),
-			"remote": remoteAddrStr,
-		}
-		maps.Copy(info, data)
+		info := *data
+		info.Local = conn.LocalAddr().String()
+		info.Remote = remoteAddrStr
 
 		x.logger.LogEvent(
 			zapcore.InfoLevel,
 			sf.Format("connected to GCS via: {0} => {1}", address, remoteAddrStr),
-			PCAP_EXPORT,
-			info,
+			&info,
 			nil)
 
 		return conn, nil
@@ -209,12 +226,11 @@ func (x *libraryExporter) streamInterceptor(
 	x.logger.LogEvent(
 		zapcore.InfoLevel,
 		sf.Format("GCS operation: {0}{1}", target, method),
-		PCAP_EXPORT,
-		map[string]any{
-			"target": target,
-			"stream": desc.StreamName,
-			"state":  cc.GetState().String(),
-			"bucket": x.bucket,
+		&telemetry.Export{
+			Target: target,
+			Stream: desc.StreamName,
+			State:  cc.GetState().String(),
+			Bucket: x.bucket,
 		},
 		nil)
 
@@ -269,7 +285,7 @@ func (x *libraryExporter) newObject(
 		Retryer(
 			storage.WithBackoff(gax.Backoff{
 				Initial: 2 * time.Second,
-				Max:     time.Duration(x.maxRetries) * x.retriesDelay * time.Second,
+				Max:     time.Duration(x.maxRetries) * x.retriesDelay,
 			}),
 			storage.WithMaxAttempts(int(x.maxRetries)),
 			storage.WithErrorFunc(func(err error) bool {
@@ -286,11 +302,10 @@ func (x *libraryExporter) newObject(
 				x.logger.LogEvent(
 					zapcore.WarnLevel,
 					sf.Format("failed to EXPORT file at attempt {0}: {1}", attempts, *srcPcapFile),
-					PCAP_EXPORT,
-					map[string]any{
-						"source":  *srcPcapFile,
-						"target":  tgtPcapFile,
-						"attempt": attempts,
+					&telemetry.Export{
+						Source:  *srcPcapFile,
+						Target:  *tgtPcapFile,
+						Attempt: telemetry.Ptr(int(attempts)),
 					},
 					err,
 				)
@@ -301,14 +316,96 @@ func (x *libraryExporter) newObject(
 		)
 }
 
+func (x *libraryExporter) toObjectName(
+	pcapFile string,
+) string {
+	parts := strings.Split(pcapFile, "/")
+	// skip local directory: `${0}/${1:PCAP_DIR}/...`
+	return strings.Join(parts[2:], "/")
+}
+
 func (x *libraryExporter) newObjectName(
 	srcPcapFile *string,
 	compress bool,
+) string {
+	return x.toObjectName(x.toTargetPcapFile(srcPcapFile, compress))
+}
+
+// newStagedObjectName returns the name of the staging object for `srcPcapFile`;
+// the staging directory follows the same layout as the destination directory.
+func (x *libraryExporter) newStagedObjectName(
+	srcPcapFile *string,
+	compress bool,
 ) string {
 	tgtPcapFile := x.toTargetPcapFile(srcPcapFile, compress)
-	parts := strings.Split(tgtPcapFile, "/")
-	// skip local directory: `${0}/${1:PCAP_DIR}/...`
-	return strings.Join(parts[2:], "/")
+	return x.toObjectName(x.toStagedPcapFile(&tgtPcapFile))
+}
+
+// place copies the staging object into the destination object, and then deletes the staging object.
+func (x *libraryExporter) place(
+	ctx context.Context,
+	srcPcapFile *string,
+	stagedPcapFile *string,
+	tgtPcapFile *string,
+	pcapBytes *int64,
+) error {
+	staged := x.handle.Object(*stagedPcapFile)
+	// the copy is performed by GCS: PCAP files bytes are not sent again
+	copier := x.newObject(srcPcapFile, tgtPcapFile).CopierFrom(staged)
+	// without it, the destination object would be encrypted using the bucket's default encryption
+	copier.DestinationKMSKeyName = x.kmsKey
+	if _, err := copier.Run(x.setHeaders(ctx)); err != nil {
+		x.logger.LogFsEvent(
+			zapcore.ErrorLevel,
+			sf.Format("failed to COPY staged object: gs://{0}/{1}", x.bucket, *stagedPcapFile),
+			PCAP_EXPORT,
+			*srcPcapFile,
+			*tgtPcapFile,
+			*pcapBytes,
+			err)
+		return errors.Wrap(err,
+			sf.Format("failed to copy staged object: {0}", *stagedPcapFile))
+	}
+
+	x.logger.LogFsEvent(
+		zapcore.InfoLevel,
+		sf.Format("COPIED staged object into gs://{0}/{1}", x.bucket, *tgtPcapFile),
+		PCAP_EXPORT,
+		*srcPcapFile,
+		*tgtPcapFile,
+		*pcapBytes,
+		nil)
+
+	// the destination object is complete: a leftover staging object is not an export failure
+	if err := staged.Delete(x.setHeaders(ctx)); err != nil {
+		x.logger.LogFsEvent(
+			zapcore.WarnLevel,
+			sf.Format("failed to DELETE staged object: gs://{0}/{1}", x.bucket, *stagedPcapFile),
+			PCAP_EXPORT,
+			*srcPcapFile,
+			*tgtPcapFile,
+			*pcapBytes,
+			err)
+	}
+
+	return nil
+}
+
+// newAuditHeaders returns the headers attached to GCS audit logs as key-value pairs;
+// identity fields are only attached when they are allowed.
+func (x *libraryExporter) newAuditHeaders() []string {
+	identity := map[log.Field]string{
+		log.FIELD_PROJECT:  x.projectID,
+		log.FIELD_SERVICE:  x.service,
+		log.FIELD_INSTANCE: x.instanceID,
+	}
+	headers := []string{}
+	for _, field := range x.audit {
+		if header, ok := auditHeaders[field]; ok {
+			headers = append(headers, auditHeaderPrefix+header, identity[field])
+		}
+	}
+	return append(headers, auditHeaderPrefix+"gcs-bucket", x.bucket)
 }
 
 func (x *libraryExporter) setHeaders(
@@ -316,12 +413,7 @@ func (x *libraryExporter) setHeaders(
 ) context.Context {
 	// [ToDo]: add details about: execution-environment.
 	// see: https://cloud.google.com/storage/docs/audit-logging
-	return callctx.SetHeaders(ctx,
-		"x-goog-custom-audit-project", x.projectID,
-		"x-goog-custom-audit-service", x.service,
-		"x-goog-custom-audit-instance-id", x.instanceID,
-		"x-goog-custom-audit-gcs-bucket", x.bucket,
-	)
+	return callctx.SetHeaders(ctx, x.newAuditHeaders()...)
 }
 
 func (x *libraryExporter) newWriter(
@@ -342,9 +434,20 @@ func (x *libraryExporter) newWriter(
 		"project":  x.projectID,
 		"instance": x.instanceID,
 	}
+	if metadata, ok := ctx.Value(objectMetadata).(map[string]string); ok {
+		// identity metadata is never overridden
+		for key, value := range metadata {
+			if _, found := writer.Metadata[key]; !found {
+				writer.Metadata[key] = value
+			}
+		}
+	}
 
 	writer.ChunkSize = googleapi.DefaultUploadChunkSize
 
+	// objects are encrypted server-side; GCS fails the upload if the key cannot be used
+	writer.KMSKeyName = x.kmsKey
+
 	return writer
 }
 
@@ -377,13 +480,27 @@ func (x *libraryExporter) Export(
 	tgtPcapFile := x.newObjectName(srcPcapFile, compress)
 	ctx = context.WithValue(ctx, targetPcapFile, tgtPcapFile)
 
-	object := x.newObject(srcPcapFile, &tgtPcapFile)
+	// when staging is enabled, PCAP files are uploaded into a staging object first
+	outPcapFile := tgtPcapFile
+	onExported := x.onExported
+	if x.isStaged() {
+		outPcapFile = x.newStagedObjectName(srcPcapFile, compress)
+		// the source PCAP file is only deleted after the staging object is copied into the destination object
+		onExported = func(cw ClosableWriter, src, staged *string, size *int64) error {
+			if err := x.onExported(cw, src, staged, size); err != nil {
+				return err
+			}
+			return x.place(ctx, src, staged, &tgtPcapFile, size)
+		}
+	}
 
-	writer := x.newWriter(ctx, srcPcapFile, &tgtPcapFile, object)
+	object := x.newObject(srcPcapFile, &outPcapFile)
 
-	pcapBytes, err := x.export(srcPcapFile, &tgtPcapFile, writer, compress, delete, x.onExported)
+	writer := x.newWriter(ctx, srcPcapFile, &outPcapFile, object)
 
-	return &tgtPcapFile, &pcapBytes, err
+	pcapBytes, err := x.export(ctx, srcPcapFile, &outPcapFile, writer, compress, delete, onExported)
+
+	return &tgtPcapFile, &pcapBytes, wrapKMSError(err, x.kmsKey)
 }
 
 func NewClientLibraryExporter(
@@ -394,10 +511,14 @@ func NewClientLibraryExporter(
 	instanceID string,
 	bucket string,
 	directory string,
+	staging string,
 	maxRetries uint,
-	retriesDelay uint,
+	retriesDelay time.Duration,
+	shards *Shards,
+	audit log.Fields,
+	kmsKey string,
 ) Exporter {
-	x := newExporter(logger, directory, maxRetries, retriesDelay)
+	x := newExporter(logger, directory, staging, maxRetries, retriesDelay, shards)
 
 	exporter := &libraryExporter{
 		exporter:   x,
@@ -405,6 +526,8 @@ func NewClientLibraryExporter(
 		service:    service,
 		instanceID: instanceID,
 		bucket:     bucket,
+		audit:      audit,
+		kmsKey:     kmsKey,
 		dialer: &net.Dialer{
 			Timeout: 5 * time.Minute,
 			KeepAliveConfig: net.KeepAliveConfig{
@@ -428,12 +551,11 @@ func NewClientLibraryExporter(
 		logger.LogEvent(
 			zapcore.ErrorLevel,
 			"failed to create PCAP files client library exporter",
-			PCAP_EXPORT,
-			map[string]any{
-				"project": projectID,
-				"bucket":  bucket,
+			&telemetry.Export{
+				Project: projectID,
+				Bucket:  bucket,
 			},
-			err)
+			wrapKMSError(err, kmsKey))
 	}
 
 	// return the NIL exporter by default
@@ -19,12 +19,16 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/compression"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/constants"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/naming"
 	"github.com/pkg/errors"
 	sf "github.com/wissance/stringFormatter"
 	"go.uber.org/zap/zapcore"
@@ -47,8 +51,13 @@ type (
 
 	exporter struct {
 		directory    string
+		staging      string
 		maxRetries   uint
 		retriesDelay time.Duration
+		openAttempts uint
+		openDelay    time.Duration
+		openFile     func(string, int, fs.FileMode) (*os.File, error)
+		shards       *Shards
 		logger       *log.Logger
 	}
 
@@ -56,6 +65,18 @@ type (
 		*exporter
 	}
 
+	countingWriter struct {
+		io.Writer
+		bytes int64
+	}
+
+	// contextReader stops reading once its context is done,
+	// so that a stuck export is cancelled between reads of the source PCAP file.
+	contextReader struct {
+		ctx context.Context
+		r   io.Reader
+	}
+
 	exportCallback func(
 		cw ClosableWriter,
 		srcPcapFile *string,
@@ -73,13 +94,20 @@ var nilExporterError = fmt.Errorf("GCS export is disabled")
 func newExporter(
 	logger *log.Logger,
 	directory string,
+	staging string,
 	maxRetries uint,
-	retriesDelay uint,
+	retriesDelay time.Duration,
+	shards *Shards,
 ) *exporter {
 	return &exporter{
 		directory:    directory,
+		staging:      staging,
 		maxRetries:   maxRetries,
-		retriesDelay: time.Duration(retriesDelay) * time.Second,
+		retriesDelay: retriesDelay,
+		openAttempts: openAttempts,
+		openDelay:    openDelay,
+		openFile:     os.OpenFile,
+		shards:       shards,
 		logger:       logger,
 	}
 }
@@ -88,7 +116,7 @@ func NewNilExporter(
 	logger *log.Logger,
 ) Exporter {
 	return &nilExporter{
-		exporter: newExporter(logger, "", 0, 0),
+		exporter: newExporter(logger, "", "", 0, 0, nil),
 	}
 }
 
@@ -109,22 +137,35 @@ func (x *nilExporter) Export(
 	x.logger.LogEvent(
 		zapcore.WarnLevel,
 		sf.Format("lost PCAP file: {0}", *srcPcapFile),
-		PCAP_EXPORT,
-		map[string]any{
-			"source": *srcPcapFile,
-			"target": x.toTargetPcapFile(srcPcapFile, compress),
+		&telemetry.Export{
+			Source: *srcPcapFile,
+			Target: x.toTargetPcapFile(srcPcapFile, compress),
 		},
 		err)
 
 	return &tgtPcap, &pcapBytes, err
 }
 
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
 func (x *exporter) toTargetPcapFile(
 	srcPcapFile *string,
 	compress bool,
 ) string {
-	pcapFileName := filepath.Base(*srcPcapFile)
-	tgtPcapFile := filepath.Join(x.directory, pcapFileName)
+	// interface names are sanitized so that they cannot alter the destination path
+	pcapFileName := naming.SafeBaseName(*srcPcapFile)
+	tgtPcapFile := x.shards.Join(x.directory, *srcPcapFile, pcapFileName)
 	// If compressing PCAP files is enabled, add `gz` siffux to the destination PCAP file path
 	if compress {
 		return sf.Format("{0}.gz", tgtPcapFile)
@@ -132,7 +173,28 @@ func (x *exporter) toTargetPcapFile(
 	return tgtPcapFile
 }
 
+// isStaged reports whether PCAP files are written into the staging directory before being placed into the destination directory.
+func (x *exporter) isStaged() bool {
+	return x.staging != ""
+}
+
+// toStagedPcapFile returns the path of `tgtPcapFile` within the staging directory;
+// shards are preserved so that the final placement only needs to change the parent directory.
+func (x *exporter) toStagedPcapFile(
+	tgtPcapFile *string,
+) string {
+	if !x.isStaged() {
+		return *tgtPcapFile
+	}
+	relPcapFile, err := filepath.Rel(x.directory, *tgtPcapFile)
+	if err != nil {
+		relPcapFile = filepath.Base(*tgtPcapFile)
+	}
+	return filepath.Join(x.staging, relPcapFile)
+}
+
 func (x *exporter) export(
+	ctx context.Context,
 	srcPcapFile *string,
 	tgtPcapFile *string,
 	outputPcapWriter ClosableWriter,
@@ -143,7 +205,7 @@ func (x *exporter) export(
 	pcapBytes := int64(0)
 
 	// Open source PCAP file: the one thas is being moved to the destination directory
-	inputPcapWriter, err := os.OpenFile(*srcPcapFile, os.O_RDONLY|os.O_EXCL, 0)
+	inputPcapWriter, err := x.withOpenRetries(ctx, *srcPcapFile, os.O_RDONLY|os.O_EXCL, 0)
 	if err != nil {
 		x.logger.LogFsEvent(
 			zapcore.ErrorLevel,
@@ -159,13 +221,20 @@ func (x *exporter) export(
 
 	// Copy source PCAP into destination PCAP, compressing destination PCAP is optional
 	if compress {
-		// see: https://pkg.go.dev/compress/gzip#NewWriter
-		gzipPcap := gzip.NewWriter(outputPcapWriter)
-		pcapBytes, err = io.Copy(gzipPcap, inputPcapWriter)
+		// count compressed bytes: `io.Copy` reports the bytes read from the source PCAP file
+		compressedPcapWriter := &countingWriter{Writer: outputPcapWriter}
+		// see: https://pkg.go.dev/compress/gzip#NewWriterLevel
+		gzipPcap, levelErr := gzip.NewWriterLevel(compressedPcapWriter, compression.Level(ctx))
+		if levelErr != nil {
+			inputPcapWriter.Close()
+			return pcapBytes, errors.Wrapf(levelErr, "invalid compression level for: %s", *srcPcapFile)
+		}
+		_, err = io.Copy(gzipPcap, &contextReader{ctx, inputPcapWriter})
 		gzipPcap.Flush()
 		gzipPcap.Close() // this is still required; `Close()` on parent `Writer` does not trigger `Close()` at `gzip`
+		pcapBytes = compressedPcapWriter.bytes
 	} else {
-		pcapBytes, err = io.Copy(outputPcapWriter, inputPcapWriter)
+		pcapBytes, err = io.Copy(outputPcapWriter, &contextReader{ctx, inputPcapWriter})
 	}
 
 	if err != nil {
@@ -227,6 +296,8 @@ func (x *exporter) export(
 		pcapBytes,
 		nil)
 
+	x.shards.record(*srcPcapFile)
+
 	if delete {
 		// remove the source PCAP file if copying is sucessful
 		err = os.Remove(*srcPcapFile)
@@ -0,0 +1,280 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+)
+
+// slowWriter simulates a stuck destination.
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func (w *slowWriter) Close() error {
+	return nil
+}
+
+func newTestLogger() *log.Logger {
+	return log.NewLogger("", "", "", "", "", "test", "gcs")
+}
+
+// newSourcePcapFile creates a source PCAP file large enough to require many writes.
+func newSourcePcapFile(t *testing.T) string {
+	t.Helper()
+	srcPcapFile := filepath.Join(t.TempDir(), "part__1_eth0__20240101T000000.pcap")
+	if err := os.WriteFile(srcPcapFile, make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return srcPcapFile
+}
+
+// TestExportIsAbortedAtDeadline verifies that a slow copy stops at the deadline, and that the source PCAP file is kept.
+func TestExportIsAbortedAtDeadline(t *testing.T) {
+	x := newExporter(newTestLogger(), "/pcap", "", 1, 0, nil)
+	srcPcapFile := newSourcePcapFile(t)
+	tgtPcapFile := "/pcap/part__1_eth0__20240101T000000.pcap"
+
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{"uncompressed", false},
+		{"compressed", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			// 32 writes of 32KiB would take more than 3 seconds
+			_, err := x.export(ctx, &srcPcapFile, &tgtPcapFile, &slowWriter{100 * time.Millisecond}, tc.compress, true,
+				func(ClosableWriter, *string, *string, *int64) error {
+					t.Error("export completed")
+					return nil
+				})
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("export was aborted after %s", elapsed)
+			}
+			if _, err := os.Stat(srcPcapFile); err != nil {
+				t.Errorf("source PCAP file was not kept: %v", err)
+			}
+		})
+	}
+}
+
+// TestFuseExportRemovesPartialFile verifies that a cancelled export leaves nothing at the destination,
+// so that the source PCAP file can be exported again.
+func TestFuseExportRemovesPartialFile(t *testing.T) {
+	directory := t.TempDir()
+	x := NewFuseExporter(newTestLogger(), directory, "", 3, 0, 0, nil)
+	srcPcapFile := newSourcePcapFile(t)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := x.Export(cancelled, &srcPcapFile, false, true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if entries, _ := os.ReadDir(directory); len(entries) != 0 {
+		t.Fatalf("partial file was not removed: %v", entries)
+	}
+
+	tgtPcapFile, pcapBytes, err := x.Export(context.Background(), &srcPcapFile, false, true)
+	if err != nil {
+		t.Fatalf("export after cancellation: %v", err)
+	}
+	if *pcapBytes != 1<<20 || filepath.Dir(*tgtPcapFile) != directory {
+		t.Errorf("got %d bytes at %s", *pcapBytes, *tgtPcapFile)
+	}
+	if _, err := os.Stat(srcPcapFile); !os.IsNotExist(err) {
+		t.Errorf("source PCAP file was not deleted: %v", err)
+	}
+}
+
+// TestFuseExportIsStaged verifies that PCAP files are written into the staging directory and then moved into the destination directory,
+// and that an existing destination PCAP file is never replaced.
+func TestFuseExportIsStaged(t *testing.T) {
+	directory, staging := t.TempDir(), t.TempDir()
+	x := NewFuseExporter(newTestLogger(), directory, staging, 1, 0, 0, nil)
+	srcPcapFile := newSourcePcapFile(t)
+	pcapFileName := filepath.Base(srcPcapFile)
+
+	// a destination PCAP file that already exists makes the placement fail after the staged PCAP file is written
+	tgtPcapFile := filepath.Join(directory, pcapFileName)
+	if err := os.WriteFile(tgtPcapFile, []byte("existing"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := x.Export(context.Background(), &srcPcapFile, false, true); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrExist)
+	}
+	if data, _ := os.ReadFile(tgtPcapFile); string(data) != "existing" {
+		t.Errorf("destination PCAP file was replaced")
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Errorf("staged file was not removed: %v", entries)
+	}
+	if _, err := os.Stat(srcPcapFile); err != nil {
+		t.Fatalf("source PCAP file was not kept: %v", err)
+	}
+
+	if err := os.Remove(tgtPcapFile); err != nil {
+		t.Fatal(err)
+	}
+	exportedPcapFile, pcapBytes, err := x.Export(context.Background(), &srcPcapFile, false, true)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if *exportedPcapFile != tgtPcapFile || *pcapBytes != 1<<20 {
+		t.Errorf("got %d bytes at %s, want %d bytes at %s", *pcapBytes, *exportedPcapFile, 1<<20, tgtPcapFile)
+	}
+	if info, err := os.Stat(tgtPcapFile); err != nil || info.Size() != 1<<20 {
+		t.Errorf("destination PCAP file was not placed: %v", err)
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Errorf("staged file was not moved: %v", entries)
+	}
+	if _, err := os.Stat(srcPcapFile); !os.IsNotExist(err) {
+		t.Errorf("source PCAP file was not deleted: %v", err)
+	}
+}
+
+// TestFuseExportReplace verifies that a PCAP file exported without being deleted, and then written again,
+// can only be exported again when replacing its previous export is explicitly allowed.
+func TestFuseExportReplace(t *testing.T) {
+	for _, staged := range []bool{false, true} {
+		t.Run(map[bool]string{false: "direct", true: "staged"}[staged], func(t *testing.T) {
+			directory, staging := t.TempDir(), ""
+			if staged {
+				staging = t.TempDir()
+			}
+			x := NewFuseExporter(newTestLogger(), directory, staging, 1, 0, 0, nil)
+			srcPcapFile := newSourcePcapFile(t)
+
+			if _, _, err := x.Export(context.Background(), &srcPcapFile, false, false /* delete */); err != nil {
+				t.Fatalf("first export: %v", err)
+			}
+			f, err := os.OpenFile(srcPcapFile, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("pcap"))
+			f.Close()
+
+			if _, _, err := x.Export(context.Background(), &srcPcapFile, false, true); !errors.Is(err, os.ErrExist) {
+				t.Fatalf("got %v, want %v", err, os.ErrExist)
+			}
+			tgtPcapFile, pcapBytes, err := x.Export(WithReplace(context.Background()), &srcPcapFile, false, true)
+			if err != nil {
+				t.Fatalf("export replacing the previous one: %v", err)
+			}
+			if info, err := os.Stat(*tgtPcapFile); err != nil || info.Size() != 1<<20+4 || *pcapBytes != 1<<20+4 {
+				t.Errorf("got %d bytes at %s, want %d bytes", *pcapBytes, *tgtPcapFile, 1<<20+4)
+			}
+			if _, err := os.Stat(srcPcapFile); !os.IsNotExist(err) {
+				t.Errorf("source PCAP file was not deleted: %v", err)
+			}
+		})
+	}
+}
+
+// TestToStagedPcapFile verifies that staged PCAP files keep their path relative to the destination directory.
+func TestToStagedPcapFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		staging string
+		tgt     string
+		want    string
+	}{
+		{"disabled", "", "/pcap/dir/a.pcap", "/pcap/dir/a.pcap"},
+		{"flat", "/pcap/.staging", "/pcap/dir/a.pcap", "/pcap/.staging/a.pcap"},
+		{"sharded", "/pcap/.staging", "/pcap/dir/shard01/a.pcap.gz", "/pcap/.staging/shard01/a.pcap.gz"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			x := newExporter(nil, "/pcap/dir", tc.staging, 1, 0, nil)
+			if got := x.toStagedPcapFile(&tc.tgt); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestAuditHeadersAreIndependentFromLogFields verifies that GCS audit headers use their own allowlist.
+func TestAuditHeadersAreIndependentFromLogFields(t *testing.T) {
+	logger := newTestLogger()
+	logger.SetFields(log.Fields{})
+
+	tests := []struct {
+		name  string
+		audit log.Fields
+		want  []string
+	}{
+		{
+			"default",
+			log.DefaultAuditFields,
+			[]string{
+				"x-goog-custom-audit-project", "project",
+				"x-goog-custom-audit-service", "service",
+				"x-goog-custom-audit-instance-id", "instance",
+				"x-goog-custom-audit-gcs-bucket", "bucket",
+			},
+		},
+		{
+			"no ids",
+			log.Fields{log.FIELD_SERVICE, log.FIELD_REGION},
+			[]string{
+				"x-goog-custom-audit-service", "service",
+				"x-goog-custom-audit-gcs-bucket", "bucket",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			x := &libraryExporter{
+				exporter:   newExporter(logger, "/pcap/dir", "", 1, 0, nil),
+				projectID:  "project",
+				service:    "service",
+				instanceID: "instance",
+				bucket:     "bucket",
+				audit:      tc.audit,
+			}
+			if got := x.newAuditHeaders(); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+	sf "github.com/wissance/stringFormatter"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	ErrorClass string
+
+	negativeEntry struct {
+		class     ErrorClass
+		err       error
+		until     time.Time
+		probing   bool
+		fastFails uint64
+	}
+
+	// NegativeCache remembers failing destinations so that exports can fail fast while they are down;
+	// once an entry expires, a single probe export is allowed to find out whether the destination recovered.
+	NegativeCache struct {
+		mu      sync.Mutex
+		now     func() time.Time
+		ttls    map[ErrorClass]time.Duration
+		entries map[string]*negativeEntry
+	}
+
+	fastFailExporter struct {
+		Exporter
+		logger      *log.Logger
+		destination string
+		cache       *NegativeCache
+	}
+)
+
+const (
+	ERROR_CLASS_NO_SPACE   = ErrorClass("no_space")
+	ERROR_CLASS_PERMISSION = ErrorClass("permission")
+	ERROR_CLASS_TRANSIENT  = ErrorClass("transient")
+
+	// only some fast-failed exports are logged
+	fastFailLogSampling = 10
+)
+
+var ErrFastFail = errors.New("destination is failing")
+
+// ClassifyError groups export errors by how long they are expected to last.
+func ClassifyError(
+	err error,
+) ErrorClass {
+	switch {
+	case errors.Is(err, syscall.ENOSPC),
+		errors.Is(err, syscall.EDQUOT),
+		errors.Is(err, ErrInsufficientSpace):
+		return ERROR_CLASS_NO_SPACE
+	case errors.Is(err, fs.ErrPermission),
+		errors.Is(err, syscall.EROFS):
+		return ERROR_CLASS_PERMISSION
+	default:
+		return ERROR_CLASS_TRANSIENT
+	}
+}
+
+// NewNegativeCache caches transient failures for `ttl`;
+// lack of space and permissions take longer to be fixed, so they are cached 12 times longer.
+func NewNegativeCache(
+	ttl time.Duration,
+	now func() time.Time,
+) *NegativeCache {
+	return &NegativeCache{
+		now: now,
+		ttls: map[ErrorClass]time.Duration{
+			ERROR_CLASS_TRANSIENT:  ttl,
+			ERROR_CLASS_NO_SPACE:   12 * ttl,
+			ERROR_CLASS_PERMISSION: 12 * ttl,
+		},
+		entries: make(map[string]*negativeEntry),
+	}
+}
+
+// Admit decides how to export to `destination`:
+//   - `probe` is `true` when the caller is the only one allowed to verify if an expired failure persists.
+//   - a non-nil error means that the export must fail fast; it wraps `ErrFastFail` and the cached failure.
+func (c *NegativeCache) Admit(
+	destination string,
+) (probe bool, fastFails uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[destination]
+	if !ok {
+		return false, 0, nil
+	}
+	if !entry.probing && !c.now().Before(entry.until) {
+		entry.probing = true
+		return true, entry.fastFails, nil
+	}
+	entry.fastFails += 1
+	return false, entry.fastFails, errors.Join(ErrFastFail, entry.err)
+}
+
+// Record caches the failure of an export to `destination`, or clears it on success.
+func (c *NegativeCache) Record(
+	destination string,
+	err error,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		delete(c.entries, destination)
+		return
+	}
+
+	class := ClassifyError(err)
+	entry, ok := c.entries[destination]
+	if !ok {
+		entry = &negativeEntry{}
+		c.entries[destination] = entry
+	}
+	entry.class = class
+	entry.err = err
+	entry.until = c.now().Add(c.ttls[class])
+	entry.probing = false
+}
+
+// Release allows another export to probe `destination`.
+func (c *NegativeCache) Release(
+	destination string,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[destination]; ok {
+		entry.probing = false
+	}
+}
+
+// NewFastFailExporter fails exports to a destination which is known to be failing without opening any files.
+func NewFastFailExporter(
+	logger *log.Logger,
+	exporter Exporter,
+	destination string,
+	cache *NegativeCache,
+) Exporter {
+	return &fastFailExporter{
+		Exporter:    exporter,
+		logger:      logger,
+		destination: destination,
+		cache:       cache,
+	}
+}
+
+func (x *fastFailExporter) Export(
+	ctx context.Context,
+	srcPcapFile *string,
+	compress bool,
+	delete bool,
+) (*string, *int64, error) {
+	probe, fastFails, err := x.cache.Admit(x.destination)
+	if err != nil {
+		tgtPcapFile := ""
+		pcapBytes := int64(0)
+		if fastFails == 1 || fastFails%fastFailLogSampling == 0 {
+			x.logger.LogEvent(
+				zapcore.WarnLevel,
+				sf.Format("fast-failed {0} exports to: {1}", fastFails, x.destination),
+				&telemetry.Export{
+					Source:      *srcPcapFile,
+					Destination: x.destination,
+					FastFails:   telemetry.Ptr(fastFails),
+				},
+				err)
+		}
+		return &tgtPcapFile, &pcapBytes, err
+	}
+
+	tgtPcapFile, pcapBytes, err := x.Exporter.Export(ctx, srcPcapFile, compress, delete)
+	switch {
+	case errors.Is(err, context.Canceled):
+		// cancelled exports do not tell anything about the destination
+		if probe {
+			x.cache.Release(x.destination)
+		}
+	case errors.Is(err, fs.ErrExist):
+		// conflicts are specific to the PCAP file: the destination is available
+		x.cache.Record(x.destination, nil)
+	default:
+		x.cache.Record(x.destination, err)
+	}
+	return tgtPcapFile, pcapBytes, err
+}
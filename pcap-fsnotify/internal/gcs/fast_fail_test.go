@@ -0,0 +1,147 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+)
+
+type fakeStorage struct {
+	calls int
+	err   error
+}
+
+func (s *fakeStorage) Export(
+	_ context.Context,
+	srcPcapFile *string,
+	_ bool,
+	_ bool,
+) (*string, *int64, error) {
+	s.calls++
+	tgtPcapFile := "/pcap/" + *srcPcapFile
+	pcapBytes := int64(0)
+	return &tgtPcapFile, &pcapBytes, s.err
+}
+
+// TestClassifyError verifies that failures are grouped by how long they are expected to last.
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{&fs.PathError{Op: "write", Path: "/pcap", Err: syscall.ENOSPC}, ERROR_CLASS_NO_SPACE},
+		{ErrInsufficientSpace, ERROR_CLASS_NO_SPACE},
+		{&fs.PathError{Op: "open", Path: "/pcap", Err: syscall.EACCES}, ERROR_CLASS_PERMISSION},
+		{&fs.PathError{Op: "write", Path: "/pcap", Err: syscall.EIO}, ERROR_CLASS_TRANSIENT},
+	}
+
+	for _, tc := range tests {
+		if got := ClassifyError(tc.err); got != tc.want {
+			t.Errorf("ClassifyError(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestFastFailExporter simulates a hard-down destination with 1s rotations:
+// only probes reach the storage, and the first successful probe clears the failure.
+func TestFastFailExporter(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewNegativeCache(5*time.Second, func() time.Time { return now })
+	storage := &fakeStorage{err: &fs.PathError{Op: "write", Path: "/pcap", Err: syscall.EIO}}
+	x := NewFastFailExporter(log.NewLogger("", "", "", "", "", "", ""), storage, "/pcap", cache)
+
+	export := func(i int) error {
+		srcPcapFile := fmt.Sprintf("part__2_eth0__20240101T0000%02d.pcap", i)
+		_, _, err := x.Export(context.Background(), &srcPcapFile, false, true)
+		return err
+	}
+
+	fastFails := 0
+	for i := 0; i < 60; i++ {
+		err := export(i)
+		if err == nil {
+			t.Fatalf("rotation %d: export succeeded, want error", i)
+		}
+		if errors.Is(err, ErrFastFail) {
+			fastFails++
+		}
+		now = now.Add(time.Second)
+	}
+
+	// 1st failure + 1 probe every 5 seconds
+	if storage.calls != 12 {
+		t.Errorf("storage calls = %d, want 12", storage.calls)
+	}
+	if fastFails != 60-storage.calls {
+		t.Errorf("fast fails = %d, want %d", fastFails, 60-storage.calls)
+	}
+
+	// the destination recovers: fast-fail until the next probe
+	storage.err = nil
+	for export(60) != nil {
+		now = now.Add(time.Second)
+	}
+	calls := storage.calls
+	for i := 61; i < 65; i++ {
+		if err := export(i); err != nil {
+			t.Fatalf("rotation %d: export failed after recovery: %v", i, err)
+		}
+	}
+	if storage.calls != calls+4 {
+		t.Errorf("storage calls after recovery = %d, want %d", storage.calls, calls+4)
+	}
+}
+
+// TestNegativeCacheTTLs verifies that lack of space is cached longer than transient errors,
+// and that only one probe is admitted at a time.
+func TestNegativeCacheTTLs(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewNegativeCache(5*time.Second, func() time.Time { return now })
+
+	cache.Record("transient", &fs.PathError{Op: "write", Path: "/pcap", Err: syscall.EIO})
+	cache.Record("no_space", &fs.PathError{Op: "write", Path: "/pcap", Err: syscall.ENOSPC})
+
+	now = now.Add(5 * time.Second)
+	if probe, _, err := cache.Admit("transient"); !probe || err != nil {
+		t.Errorf("Admit(transient) = (%v, %v), want probe", probe, err)
+	}
+	if probe, _, err := cache.Admit("transient"); probe || !errors.Is(err, ErrFastFail) {
+		t.Errorf("Admit(transient) while probing = (%v, %v), want fast-fail", probe, err)
+	}
+	if _, _, err := cache.Admit("no_space"); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("Admit(no_space) = %v, want fast-fail with ENOSPC", err)
+	}
+
+	cache.Release("transient")
+	if probe, _, _ := cache.Admit("transient"); !probe {
+		t.Error("Admit(transient) after Release: want probe")
+	}
+
+	now = now.Add(55 * time.Second)
+	if probe, _, err := cache.Admit("no_space"); !probe || err != nil {
+		t.Errorf("Admit(no_space) = (%v, %v), want probe", probe, err)
+	}
+	if probe, _, err := cache.Admit("unknown"); probe || err != nil {
+		t.Errorf("Admit(unknown) = (%v, %v), want regular export", probe, err)
+	}
+}
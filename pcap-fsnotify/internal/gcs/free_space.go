@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+	sf "github.com/wissance/stringFormatter"
+)
+
+var ErrInsufficientSpace = errors.New("insufficient free space")
+
+// allows tests to simulate a full filesystem
+var statfs = syscall.Statfs
+
+func freeBytes(
+	directory string,
+) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := statfs(directory, &stat); err != nil {
+		return 0, errors.Wrap(err,
+			sf.Format("failed to stat filesystem: {0}", directory))
+	}
+	// blocks available to unprivileged users
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// checkFreeSpace fails with `ErrInsufficientSpace` when `directory` has less than `minFreeBytes` available;
+// a `minFreeBytes` of `0` disables the check.
+func checkFreeSpace(
+	directory string,
+	minFreeBytes uint64,
+) error {
+	if minFreeBytes == 0 {
+		return nil
+	}
+
+	available, err := freeBytes(directory)
+	if err != nil {
+		return err
+	}
+
+	if available < minFreeBytes {
+		return errors.Wrap(ErrInsufficientSpace,
+			sf.Format("{0} has {1} bytes available, {2} required", directory, available, minFreeBytes))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// TestCheckFreeSpace verifies the free space threshold against a mocked `statfs`.
+func TestCheckFreeSpace(t *testing.T) {
+	defer func(original func(string, *syscall.Statfs_t) error) {
+		statfs = original
+	}(statfs)
+
+	// 10 blocks of 4KiB available
+	statfs = func(_ string, stat *syscall.Statfs_t) error {
+		stat.Bavail = 10
+		stat.Bsize = 4096
+		return nil
+	}
+
+	tests := []struct {
+		name         string
+		minFreeBytes uint64
+		want         error
+	}{
+		{"disabled", 0, nil},
+		{"enough", 40960, nil},
+		{"low", 40961, ErrInsufficientSpace},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := checkFreeSpace("/pcap", tc.minFreeBytes); !errors.Is(err, tc.want) {
+				t.Errorf("checkFreeSpace(%d): got %v, want %v", tc.minFreeBytes, err, tc.want)
+			}
+		})
+	}
+}
@@ -17,9 +17,11 @@ package gcs
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
-	"github.com/avast/retry-go/v4"
 	"github.com/pkg/errors"
 	sf "github.com/wissance/stringFormatter"
 	"go.uber.org/zap/zapcore"
@@ -28,16 +30,45 @@ import (
 type (
 	fuseExporter struct {
 		*exporter
+		minFreeBytes uint64
 	}
 )
 
+const replaceTarget = contextKey("replace_target")
+
+// WithReplace returns a context which allows the export of a PCAP file to replace its previous export, i.e.: a copy exported
+// before the PCAP file was complete; otherwise destination PCAP files are never replaced. GCS objects are always replaced.
+func WithReplace(
+	ctx context.Context,
+) context.Context {
+	return context.WithValue(ctx, replaceTarget, true)
+}
+
+func isReplacing(
+	ctx context.Context,
+) bool {
+	replace, _ := ctx.Value(replaceTarget).(bool)
+	return replace
+}
+
 func (x *fuseExporter) newFile(
+	ctx context.Context,
 	srcPcapFile *string,
 	tgtPcapFile *string,
 ) (*os.File, error) {
-	return os.OpenFile(
+	if x.shards != nil || x.isStaged() {
+		// shards are created on demand; with GCS Fuse this does not create any object
+		if err := os.MkdirAll(filepath.Dir(*tgtPcapFile), 0o777); err != nil {
+			return nil, err
+		}
+	}
+	flag := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	if isReplacing(ctx) {
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	}
+	return x.withOpenRetries(ctx,
 		*tgtPcapFile,
-		os.O_RDWR|os.O_CREATE|os.O_EXCL,
+		flag,
 		0o666,
 	)
 }
@@ -63,6 +94,48 @@ func (x *fuseExporter) onExported(
 	return cw.Close()
 }
 
+// place moves the staged PCAP file into the destination directory.
+func (x *fuseExporter) place(
+	ctx context.Context,
+	srcPcapFile *string,
+	stagedPcapFile *string,
+	tgtPcapFile *string,
+	pcapBytes *int64,
+) error {
+	// unlike creating the destination PCAP file, `rename` silently replaces an existing one
+	if _, err := os.Lstat(*tgtPcapFile); err == nil && !isReplacing(ctx) {
+		return errors.Wrap(os.ErrExist,
+			sf.Format("destination pcap already exists: {0}", *tgtPcapFile))
+	}
+	if x.shards != nil {
+		if err := os.MkdirAll(filepath.Dir(*tgtPcapFile), 0o777); err != nil {
+			return err
+		}
+	}
+	// with GCS Fuse, the destination object is only visible once it is complete
+	if err := os.Rename(*stagedPcapFile, *tgtPcapFile); err != nil {
+		x.logger.LogFsEvent(
+			zapcore.ErrorLevel,
+			sf.Format("failed to MOVE staged file: {0}", *stagedPcapFile),
+			PCAP_EXPORT,
+			*srcPcapFile,
+			*tgtPcapFile,
+			*pcapBytes,
+			err)
+		return errors.Wrap(err,
+			sf.Format("failed to move staged pcap: {0}", *stagedPcapFile))
+	}
+	x.logger.LogFsEvent(
+		zapcore.InfoLevel,
+		sf.Format("MOVED staged file into: {0}", *tgtPcapFile),
+		PCAP_EXPORT,
+		*srcPcapFile,
+		*tgtPcapFile,
+		*pcapBytes,
+		nil)
+	return nil
+}
+
 func (x *fuseExporter) Export(
 	ctx context.Context,
 	srcPcapFile *string,
@@ -70,60 +143,108 @@ func (x *fuseExporter) Export(
 	delete bool,
 ) (*string, *int64, error) {
 	tgtPcapFile := x.toTargetPcapFile(srcPcapFile, compress)
+	// when staging is enabled, PCAP files are written into the staging directory first
+	outPcapFile := x.toStagedPcapFile(&tgtPcapFile)
 
 	var pcapBytes int64 = 0
 
+	// Prevent partial writes that corrupt PCAP files when the destination filesystem is full
+	if err := checkFreeSpace(x.directory, x.minFreeBytes); err != nil {
+		return &tgtPcapFile, &pcapBytes, err
+	}
+
 	// Create destination PCAP file ( when using Fuse this is the same as exporting to the GCS Bucket )
-	pcapFileWriter, err := x.newFile(srcPcapFile, &tgtPcapFile)
+	pcapFileWriter, err := x.newFile(ctx, srcPcapFile, &outPcapFile)
 	if err != nil {
 		x.logger.LogFsEvent(
 			zapcore.ErrorLevel,
-			sf.Format("failed to CREATE file: {0}", tgtPcapFile),
+			sf.Format("failed to CREATE file: {0}", outPcapFile),
 			PCAP_EXPORT,
 			*srcPcapFile,
-			tgtPcapFile,
+			outPcapFile,
 			0,
 			err)
 		return &tgtPcapFile, &pcapBytes, errors.Wrap(err,
-			sf.Format("failed to create destination pcap: {0}", tgtPcapFile))
+			sf.Format("failed to create destination pcap: {0}", outPcapFile))
+	}
+
+	onExported := x.onExported
+	if x.isStaged() {
+		// the source PCAP file is only deleted after the staged PCAP file is placed into the destination directory
+		onExported = func(cw ClosableWriter, src, staged *string, size *int64) error {
+			if err := x.onExported(cw, src, staged, size); err != nil {
+				return err
+			}
+			return x.place(ctx, src, staged, &tgtPcapFile, size)
+		}
 	}
 	// x.logger.logFsEvent(zapcore.InfoLevel, fmt.Sprintf("CREATED: %s", tgtPcap), PCAP_EXPORT, *srcPcap, tgtPcap, 0)
 
-	pcapBytes, err = retry.DoWithData(func() (int64, error) {
+	pcapBytes, err = x.withRetries(ctx, func() (int64, error) {
 		// Copy source PCAP into destination PCAP directory, compressing destination PCAP is optional
-		return x.export(srcPcapFile, &tgtPcapFile, pcapFileWriter, compress, delete, x.onExported)
-	},
-		retry.Context(ctx),
-		retry.Attempts(x.maxRetries),
-		retry.Delay(x.retriesDelay),
-		retry.DelayType(retry.FixedDelay),
-		retry.OnRetry(func(attempt uint, err error) {
-			x.logger.LogEvent(
-				zapcore.WarnLevel,
-				sf.Format(
-					"failed to COPY file at attempt {0}: {1}",
-					attempt+1, *srcPcapFile,
-				),
-				PCAP_EXPORT,
-				map[string]any{
-					"source":  *srcPcapFile,
-					"target":  tgtPcapFile,
-					"attempt": attempt + 1,
-				},
-				err)
-		}))
-
-	return &tgtPcapFile, &pcapBytes, nil
+		return x.export(ctx, srcPcapFile, &outPcapFile, pcapFileWriter, compress, delete, onExported)
+	}, func(attempt uint, err error) {
+		x.logger.LogEvent(
+			zapcore.WarnLevel,
+			sf.Format(
+				"failed to COPY file at attempt {0}: {1}",
+				attempt+1, *srcPcapFile,
+			),
+			&telemetry.Export{
+				Source:  *srcPcapFile,
+				Target:  outPcapFile,
+				Attempt: telemetry.Ptr(int(attempt) + 1),
+			},
+			err)
+	})
+	if err != nil {
+		// a partial destination PCAP file would prevent exporting the source PCAP file again
+		x.removePartial(srcPcapFile, &outPcapFile, pcapFileWriter, err)
+	}
+
+	return &tgtPcapFile, &pcapBytes, err
+}
+
+func (x *fuseExporter) removePartial(
+	srcPcapFile *string,
+	tgtPcapFile *string,
+	pcapFileWriter *os.File,
+	cause error,
+) {
+	pcapFileWriter.Close()
+	if err := os.Remove(*tgtPcapFile); err != nil && !os.IsNotExist(err) {
+		x.logger.LogFsEvent(
+			zapcore.ErrorLevel,
+			sf.Format("failed to REMOVE partial file: {0}", *tgtPcapFile),
+			PCAP_EXPORT,
+			*srcPcapFile,
+			*tgtPcapFile,
+			0,
+			err)
+		return
+	}
+	x.logger.LogFsEvent(
+		zapcore.WarnLevel,
+		sf.Format("REMOVED partial file: {0}", *tgtPcapFile),
+		PCAP_EXPORT,
+		*srcPcapFile,
+		*tgtPcapFile,
+		0,
+		cause)
 }
 
 func NewFuseExporter(
 	logger *log.Logger,
 	directory string,
+	staging string,
 	maxRetries uint,
-	retriesDelay uint,
+	retriesDelay time.Duration,
+	minFreeBytes uint64,
+	shards *Shards,
 ) Exporter {
-	x := newExporter(logger, directory, maxRetries, retriesDelay)
+	x := newExporter(logger, directory, staging, maxRetries, retriesDelay, shards)
 	return &fuseExporter{
-		exporter: x,
+		exporter:     x,
+		minFreeBytes: minFreeBytes,
 	}
 }
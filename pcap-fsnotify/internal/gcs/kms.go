@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrKMSKeyInaccessible is returned when GCS fails to encrypt objects using the customer-managed key;
+// i.e.: the key does not exist, it is disabled, or GCS's service agent is not allowed to use it.
+var ErrKMSKeyInaccessible = errors.New("KMS key is inaccessible")
+
+// GCS only accepts keys, not key versions: objects are encrypted using the primary version of the key.
+var kmsKeyNameRegex = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// ValidateKMSKeyName requires `name` to be the full resource name of a Cloud KMS key:
+// `projects/${PROJECT}/locations/${LOCATION}/keyRings/${KEY_RING}/cryptoKeys/${KEY}`.
+func ValidateKMSKeyName(
+	name string,
+) error {
+	if !kmsKeyNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid KMS key name '%s': expected 'projects/{project}/locations/{location}/keyRings/{key_ring}/cryptoKeys/{key}'", name)
+	}
+	return nil
+}
+
+// wrapKMSError wraps errors caused by the customer-managed key with `ErrKMSKeyInaccessible`, so that they are not
+// mistaken for transient export failures; GCS reports them using the same status codes as other permission errors.
+func wrapKMSError(
+	err error,
+	keyName string,
+) error {
+	if err == nil || keyName == "" || errors.Is(err, ErrKMSKeyInaccessible) {
+		return err
+	}
+	if message := strings.ToLower(err.Error()); !strings.Contains(message, "kms") {
+		return err
+	}
+	return fmt.Errorf("%w: %s: %w", ErrKMSKeyInaccessible, keyName, err)
+}
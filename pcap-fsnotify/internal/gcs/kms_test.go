@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestValidateKMSKeyName verifies that only full key resource names are accepted.
+func TestValidateKMSKeyName(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"key", "projects/p/locations/us-central1/keyRings/r/cryptoKeys/k", false},
+		{"empty", "", true},
+		{"key ring", "projects/p/locations/us-central1/keyRings/r", true},
+		{"version", "projects/p/locations/us-central1/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1", true},
+		{"short name", "k", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateKMSKeyName(tc.key); (err != nil) != tc.wantErr {
+				t.Errorf("ValidateKMSKeyName(%q) = %v, wantErr %v", tc.key, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestWrapKMSError verifies that only errors caused by the KMS key are reported as such.
+func TestWrapKMSError(t *testing.T) {
+	const key = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+
+	kmsErr := errors.New("rpc error: code = PermissionDenied desc = Permission denied on Cloud KMS key")
+	otherErr := errors.New("rpc error: code = PermissionDenied desc = caller does not have storage.objects.create access")
+
+	if err := wrapKMSError(kmsErr, key); !errors.Is(err, ErrKMSKeyInaccessible) || !errors.Is(err, kmsErr) {
+		t.Errorf("wrapKMSError(kms) = %v, want ErrKMSKeyInaccessible", err)
+	}
+	if err := wrapKMSError(otherErr, key); errors.Is(err, ErrKMSKeyInaccessible) {
+		t.Errorf("wrapKMSError(other) = %v, want unwrapped", err)
+	}
+	if err := wrapKMSError(kmsErr, ""); errors.Is(err, ErrKMSKeyInaccessible) {
+		t.Errorf("wrapKMSError without key = %v, want unwrapped", err)
+	}
+	if err := wrapKMSError(nil, key); err != nil {
+		t.Errorf("wrapKMSError(nil) = %v, want nil", err)
+	}
+}
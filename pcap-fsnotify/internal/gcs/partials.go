@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/checkpoint"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+)
+
+type (
+	// fusePartials stores partial PCAP files next to the exported ones, using GCS Fuse.
+	fusePartials struct {
+		*exporter
+		compress bool
+	}
+)
+
+const partialsProbeFile = ".pcapfsn-checkpoint-probe"
+
+func (p *fusePartials) toPartialPcapFile(
+	srcPcapFile string,
+) string {
+	return p.toTargetPcapFile(&srcPcapFile, p.compress) + checkpoint.PartialSuffix
+}
+
+// Probe verifies whether files can be appended to; if they cannot, they must be overwritten.
+func (p *fusePartials) Probe(
+	_ context.Context,
+) (checkpoint.Mode, error) {
+	probeFile := filepath.Join(p.directory, partialsProbeFile)
+	defer os.Remove(probeFile)
+
+	if err := os.WriteFile(probeFile, []byte("pcap"), 0o666); err != nil {
+		return "", err
+	}
+
+	appendErr := func() error {
+		f, err := os.OpenFile(probeFile, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteString("-sidecar"); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}()
+	if appendErr == nil {
+		if content, err := os.ReadFile(probeFile); err == nil && bytes.Equal(content, []byte("pcap-sidecar")) {
+			return checkpoint.MODE_APPEND, nil
+		}
+	}
+
+	// the probe file was created, so it can also be replaced
+	return checkpoint.MODE_OVERWRITE, nil
+}
+
+func (p *fusePartials) Write(
+	_ context.Context,
+	srcPcapFile string,
+	mode checkpoint.Mode,
+	offset int64,
+	r io.Reader,
+) (string, error) {
+	partialPcapFile := p.toPartialPcapFile(srcPcapFile)
+
+	if p.shards != nil {
+		if err := os.MkdirAll(filepath.Dir(partialPcapFile), 0o777); err != nil {
+			return partialPcapFile, err
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if mode == checkpoint.MODE_OVERWRITE || offset == 0 {
+		// a stale partial PCAP file must not be appended to
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(partialPcapFile, flags, 0o666)
+	if err != nil {
+		return partialPcapFile, err
+	}
+
+	if p.compress {
+		// concatenated gzip members are a valid gzip stream: every checkpoint appends a new one
+		gzipWriter := gzip.NewWriter(f)
+		_, err = io.Copy(gzipWriter, r)
+		if closeErr := gzipWriter.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		_, err = io.Copy(f, r)
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return partialPcapFile, err
+}
+
+func (p *fusePartials) Remove(
+	_ context.Context,
+	partialPcapFile string,
+) error {
+	if err := os.Remove(partialPcapFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// NewFusePartials stores partial PCAP files in `directory` using the same names as exported PCAP files
+// with the suffix `.partial`; GCS Fuse does not expose objects metadata, so partial PCAP files are only marked by name.
+func NewFusePartials(
+	logger *log.Logger,
+	directory string,
+	shards *Shards,
+	compress bool,
+) checkpoint.Destination {
+	return &fusePartials{
+		exporter: newExporter(logger, directory, "", 0, 0, shards),
+		compress: compress,
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/checkpoint"
+)
+
+// TestFusePartials verifies that partial PCAP files are appended to, and that compressed ones remain valid gzip streams.
+func TestFusePartials(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(map[bool]string{false: "uncompressed", true: "compressed"}[compress], func(t *testing.T) {
+			directory := t.TempDir()
+			p := NewFusePartials(newTestLogger(), directory, nil, compress)
+			srcPcapFile := "/pcap-tmp/part__1_eth0__20240101T000000.pcap"
+
+			mode, err := p.Probe(context.Background())
+			if err != nil || mode != checkpoint.MODE_APPEND {
+				t.Fatalf("got %s, %v, want %s", mode, err, checkpoint.MODE_APPEND)
+			}
+			if entries, _ := os.ReadDir(directory); len(entries) != 0 {
+				t.Errorf("probe file was not removed: %v", entries)
+			}
+
+			var partialPcapFile string
+			for i, chunk := range []string{"pcap", "-side", "car"} {
+				partialPcapFile, err = p.Write(context.Background(), srcPcapFile, mode, int64(i), bytes.NewBufferString(chunk))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			wantName := "part__1_eth0__20240101T000000.pcap" + checkpoint.PartialSuffix
+			if compress {
+				wantName = "part__1_eth0__20240101T000000.pcap.gz" + checkpoint.PartialSuffix
+			}
+			if partialPcapFile != filepath.Join(directory, wantName) {
+				t.Errorf("got %s, want %s", partialPcapFile, wantName)
+			}
+
+			f, err := os.Open(partialPcapFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var r io.Reader = f
+			if compress {
+				if r, err = gzip.NewReader(f); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if content, err := io.ReadAll(r); err != nil || string(content) != "pcap-sidecar" {
+				t.Errorf("got %q, %v", content, err)
+			}
+
+			if err := p.Remove(context.Background(), partialPcapFile); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(partialPcapFile); !os.IsNotExist(err) {
+				t.Errorf("partial PCAP file was not removed: %v", err)
+			}
+		})
+	}
+}
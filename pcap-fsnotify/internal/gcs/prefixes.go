@@ -0,0 +1,249 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
+	sf "github.com/wissance/stringFormatter"
+	"go.uber.org/zap/zapcore"
+)
+
+type (
+	// PrefixShard places the destination directory of a session under one of `Count` top-level prefixes of the bucket;
+	// fleets exporting sequentially named objects under the same date-based prefix would otherwise create a GCS hotspot.
+	PrefixShard struct {
+		Session string `json:"session"`
+		Token   string `json:"token"`
+		Count   uint   `json:"count"`
+		mount   string
+	}
+
+	// ShardIndex maps the sessions of a day to their prefix shard tokens.
+	ShardIndex map[string]string
+
+	// FuseShardIndex stores one index object per day, so that listing all sessions of a day across shards
+	// only requires reading a single object: `<mount>/_shards/YYYY/MM/DD/index.json`.
+	FuseShardIndex struct {
+		logger *log.Logger
+		root   string
+	}
+)
+
+const (
+	// tokens are rendered as two hex digits
+	maxPrefixShards = 256
+
+	shardIndexDir      = "_shards"
+	shardIndexFile     = "index.json"
+	shardIndexSessions = "sessions"
+	// writers converge within a few attempts, unless many of them record the same day at the same time
+	shardIndexAttempts = 10
+)
+
+var errShardIndexNotConverged = errors.New("shard index was replaced by concurrent writers on every attempt")
+
+// MountPoint returns the first element of the destination directory, which is the GCS Fuse mount point of the bucket;
+// it is the same layout used to name objects when exporting using the GCS client library.
+func MountPoint(
+	directory string,
+) string {
+	mount, _, _ := strings.Cut(strings.TrimPrefix(filepath.Clean(directory), "/"), "/")
+	return "/" + mount
+}
+
+// PrefixToken hashes the session ID, so that a session always lands under the same prefix.
+func PrefixToken(
+	session string,
+	count uint,
+) string {
+	h := fnv.New32a()
+	h.Write([]byte(session))
+	return fmt.Sprintf("%02x", h.Sum32()%uint32(count))
+}
+
+// NewPrefixShard returns `nil` when `count` is `0`, which disables prefix sharding;
+// the session ID is the last element of the destination directory, which must be within `mount`.
+func NewPrefixShard(
+	count uint,
+	mount, directory string,
+) (*PrefixShard, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	if count > maxPrefixShards {
+		return nil, fmt.Errorf("prefix shards must not exceed %d: %d", maxPrefixShards, count)
+	}
+	if rel, err := filepath.Rel(mount, directory); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("destination directory must be within the mount point '%s': %s", mount, directory)
+	}
+	session := filepath.Base(filepath.Clean(directory))
+	return &PrefixShard{
+		Session: session,
+		Token:   PrefixToken(session, count),
+		Count:   count,
+		mount:   filepath.Clean(mount),
+	}, nil
+}
+
+// Dir returns the destination directory with the shard token right after the mount point.
+func (p *PrefixShard) Dir(
+	directory string,
+) string {
+	if p == nil {
+		return directory
+	}
+	rel, err := filepath.Rel(p.mount, directory)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return directory
+	}
+	return filepath.Join(p.mount, p.Token, rel)
+}
+
+// NewFuseShardIndex stores the daily shard indexes at the root of the GCS Fuse mount point.
+func NewFuseShardIndex(
+	logger *log.Logger,
+	mount string,
+) *FuseShardIndex {
+	return &FuseShardIndex{
+		logger: logger,
+		root:   filepath.Join(mount, shardIndexDir),
+	}
+}
+
+func (x *FuseShardIndex) dayDir(
+	day time.Time,
+) string {
+	return filepath.Join(x.root, day.UTC().Format("2006/01/02"))
+}
+
+// writeAtomic replaces `path` by renaming a fully written temporary file, so that readers never see partial content.
+func writeAtomic(
+	path string,
+	tmpSuffix string,
+	content []byte,
+) error {
+	tmpPath := sf.Format("{0}.{1}.tmp", path, tmpSuffix)
+	if err := os.WriteFile(tmpPath, content, 0o666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Read returns the index of `day`; it is empty if no session recorded it yet.
+func (x *FuseShardIndex) Read(
+	day time.Time,
+) (ShardIndex, error) {
+	index := ShardIndex{}
+	content, err := os.ReadFile(filepath.Join(x.dayDir(day), shardIndexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// list returns the sessions which recorded `day`; every session writes its own entry, so entries are never lost.
+func (x *FuseShardIndex) list(
+	day time.Time,
+) (ShardIndex, error) {
+	sessionsDir := filepath.Join(x.dayDir(day), shardIndexSessions)
+	entries, err := os.ReadDir(sessionsDir)
+	if err != nil {
+		return nil, err
+	}
+	index := ShardIndex{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		if token, err := os.ReadFile(filepath.Join(sessionsDir, entry.Name())); err == nil {
+			index[entry.Name()] = string(token)
+		}
+	}
+	return index, nil
+}
+
+// Record adds the session to the index of `day`. Concurrent writers may replace the index with a stale one,
+// so it is read back after being replaced: the last writer always leaves an index which contains all sessions.
+func (x *FuseShardIndex) Record(
+	ctx context.Context,
+	day time.Time,
+	shard *PrefixShard,
+) error {
+	sessionsDir := filepath.Join(x.dayDir(day), shardIndexSessions)
+	if err := os.MkdirAll(sessionsDir, 0o777); err != nil {
+		return err
+	}
+	if err := writeAtomic(filepath.Join(sessionsDir, shard.Session), shard.Session, []byte(shard.Token)); err != nil {
+		return err
+	}
+
+	indexFile := filepath.Join(x.dayDir(day), shardIndexFile)
+	for attempt := 1; attempt <= shardIndexAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		listed, err := x.list(day)
+		if err != nil {
+			return err
+		}
+		index, err := x.Read(day)
+		if err != nil {
+			// a corrupted index is rebuilt from the entries of all sessions
+			index = ShardIndex{}
+		}
+		missing := 0
+		for session, token := range listed {
+			if index[session] != token {
+				index[session] = token
+				missing++
+			}
+		}
+		if missing == 0 {
+			x.logger.LogEvent(zapcore.InfoLevel,
+				sf.Format("recorded prefix shard '{0}' of session '{1}'", shard.Token, shard.Session),
+				&telemetry.Export{Index: indexFile, Sessions: telemetry.Ptr(len(index)), Attempts: telemetry.Ptr(attempt)}, nil)
+			return nil
+		}
+		content, err := json.Marshal(index)
+		if err != nil {
+			return err
+		}
+		if err := writeAtomic(indexFile, shard.Session, content); err != nil {
+			return err
+		}
+	}
+	return errShardIndexNotConverged
+}
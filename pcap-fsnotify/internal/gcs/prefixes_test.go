@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPrefixShardDeterministic verifies that a session is always assigned the same token, right after the mount point.
+func TestPrefixShardDeterministic(t *testing.T) {
+	const directory = "/pcap/project/env/service/region/revision/2024/05/01/10-00/instance-1"
+	first, err := NewPrefixShard(16, MountPoint(directory), directory)
+	if err != nil {
+		t.Fatalf("NewPrefixShard() = %v", err)
+	}
+	second, _ := NewPrefixShard(16, "/pcap", directory)
+	if first.Token != second.Token || first.Token != PrefixToken("instance-1", 16) || len(first.Token) != 2 {
+		t.Errorf("tokens = %s, %s", first.Token, second.Token)
+	}
+	if got, want := first.Dir(directory), "/pcap/"+first.Token+"/project/env/service/region/revision/2024/05/01/10-00/instance-1"; got != want {
+		t.Errorf("Dir() = %s, want %s", got, want)
+	}
+
+	tokens := map[string]bool{}
+	for i := 0; i < 256; i++ {
+		tokens[PrefixToken(fmt.Sprintf("instance-%d", i), 16)] = true
+	}
+	if len(tokens) != 16 {
+		t.Errorf("sessions spread across %d prefixes, want 16", len(tokens))
+	}
+}
+
+func TestNewPrefixShardInvalid(t *testing.T) {
+	if shard, err := NewPrefixShard(0, "/pcap", "/pcap/instance"); shard != nil || err != nil {
+		t.Errorf("NewPrefixShard(0) = %v, %v", shard, err)
+	}
+	if _, err := NewPrefixShard(maxPrefixShards+1, "/pcap", "/pcap/instance"); err == nil {
+		t.Errorf("NewPrefixShard(%d) must fail", maxPrefixShards+1)
+	}
+	if _, err := NewPrefixShard(4, "/pcap", "/pcap"); err == nil {
+		t.Error("NewPrefixShard() must fail when the destination is the mount point")
+	}
+	var disabled *PrefixShard
+	if got := disabled.Dir("/pcap/instance"); got != "/pcap/instance" {
+		t.Errorf("Dir() = %s", got)
+	}
+}
+
+// TestShardIndexEnumeration verifies that all sessions of a day are found across shards using only the daily index.
+func TestShardIndexEnumeration(t *testing.T) {
+	mount := t.TempDir()
+	day := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	index := NewFuseShardIndex(newTestLogger(), mount)
+
+	dirs := map[string]string{}
+	for i := 0; i < 8; i++ {
+		directory := filepath.Join(mount, "project", "service", day.Format("2006/01/02/15-04"), fmt.Sprintf("instance-%d", i))
+		shard, err := NewPrefixShard(4, mount, directory)
+		if err != nil {
+			t.Fatalf("NewPrefixShard() = %v", err)
+		}
+		dirs[shard.Session] = shard.Dir(directory)
+		if err := os.MkdirAll(dirs[shard.Session], 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := index.Record(context.Background(), day, shard); err != nil {
+			t.Fatalf("Record() = %v", err)
+		}
+	}
+
+	got, err := index.Read(day)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if len(got) != len(dirs) {
+		t.Fatalf("index has %d sessions, want %d", len(got), len(dirs))
+	}
+	for session, token := range got {
+		want := filepath.Join(mount, token, "project", "service", day.Format("2006/01/02/15-04"), session)
+		if dirs[session] != want {
+			t.Errorf("session %s: exported to %s, index points to %s", session, dirs[session], want)
+		}
+	}
+	if other, _ := index.Read(day.AddDate(0, 0, 1)); len(other) != 0 {
+		t.Errorf("next day index = %v", other)
+	}
+}
+
+// TestShardIndexConcurrentWriters verifies that no session is lost when exporters record the same day at the same time.
+func TestShardIndexConcurrentWriters(t *testing.T) {
+	mount := t.TempDir()
+	day := time.Now()
+
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			directory := filepath.Join(mount, "project", fmt.Sprintf("instance-%d", i))
+			shard, _ := NewPrefixShard(16, mount, directory)
+			// every exporter owns its own index, as exporters running on different instances do
+			errs <- NewFuseShardIndex(newTestLogger(), mount).Record(context.Background(), day, shard)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Record() = %v", err)
+		}
+	}
+
+	got, err := NewFuseShardIndex(newTestLogger(), mount).Read(day)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	for i := 0; i < writers; i++ {
+		session := fmt.Sprintf("instance-%d", i)
+		if got[session] != PrefixToken(session, 16) {
+			t.Errorf("session %s: token = %q", session, got[session])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+	"github.com/avast/retry-go/v4"
+	sf "github.com/wissance/stringFormatter"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// opening files through GCS Fuse fails transiently, i.e.: `resource temporarily unavailable`;
+	// opens are retried sooner, and fewer times, than exports
+	openAttempts = 5
+	openDelay    = 200 * time.Millisecond
+)
+
+// errOpenFailed marks open failures which were already retried, so that they are not retried again by exports.
+var errOpenFailed = errors.New("failed to open file")
+
+// non-transient conditions fail the same way on every attempt
+var nonRetryableErrors = []error{
+	fs.ErrPermission,
+	fs.ErrExist,
+	syscall.ENOSPC,
+	syscall.EDQUOT,
+	syscall.EROFS,
+	ErrInsufficientSpace,
+	errOpenFailed,
+	// the export was cancelled or timed out
+	context.Canceled,
+	context.DeadlineExceeded,
+}
+
+func isRetryable(
+	err error,
+) bool {
+	for _, nonRetryableErr := range nonRetryableErrors {
+		if errors.Is(err, nonRetryableErr) {
+			return false
+		}
+	}
+	return true
+}
+
+// isRetryableOpen reports whether opening a file may succeed at the next attempt;
+// a source PCAP file which does not exist will not appear.
+func isRetryableOpen(
+	err error,
+) bool {
+	return isRetryable(err) && !errors.Is(err, fs.ErrNotExist)
+}
+
+// withOpenRetries opens the file `name` using `flag` and `perm`, retrying only while it fails with transient errors.
+func (x *exporter) withOpenRetries(
+	ctx context.Context,
+	name string,
+	flag int,
+	perm fs.FileMode,
+) (*os.File, error) {
+	f, err := retry.DoWithData(func() (*os.File, error) {
+		return x.openFile(name, flag, perm)
+	},
+		retry.Context(ctx),
+		retry.Attempts(x.openAttempts),
+		retry.Delay(x.openDelay),
+		retry.DelayType(retry.FixedDelay),
+		retry.RetryIf(isRetryableOpen),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(func(attempt uint, err error) {
+			x.logger.LogEvent(
+				zapcore.WarnLevel,
+				sf.Format("failed to OPEN file at attempt {0}: {1}", attempt+1, name),
+				&telemetry.Export{
+					File:    name,
+					Attempt: telemetry.Ptr(int(attempt) + 1),
+				},
+				err)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errOpenFailed, err)
+	}
+	return f, nil
+}
+
+// withRetries retries `export` only while it fails with transient errors.
+func (x *exporter) withRetries(
+	ctx context.Context,
+	export retry.RetryableFuncWithData[int64],
+	onRetry retry.OnRetryFunc,
+) (int64, error) {
+	return retry.DoWithData(export,
+		retry.Context(ctx),
+		retry.Attempts(x.maxRetries),
+		retry.Delay(x.retriesDelay),
+		retry.DelayType(retry.FixedDelay),
+		retry.RetryIf(isRetryable),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(onRetry))
+}
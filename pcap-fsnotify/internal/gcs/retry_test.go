@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestWithRetries verifies that only transient errors are retried.
+func TestWithRetries(t *testing.T) {
+	x := newExporter(nil, "/pcap", "", 5, 0, nil)
+
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{"permission denied", &fs.PathError{Op: "open", Path: "/pcap/part__2_eth0__20240101T000000.pcap", Err: syscall.EACCES}, 1},
+		{"exists", fmt.Errorf("create: %w", fs.ErrExist), 1},
+		{"no space", &fs.PathError{Op: "write", Path: "/pcap", Err: syscall.ENOSPC}, 1},
+		{"insufficient space", ErrInsufficientSpace, 1},
+		{"io error", &fs.PathError{Op: "write", Path: "/pcap", Err: syscall.EIO}, 5},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			_, err := x.withRetries(context.Background(), func() (int64, error) {
+				attempts++
+				return 0, tc.err
+			}, func(uint, error) {})
+
+			if err == nil {
+				t.Fatal("withRetries succeeded, want error")
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+// flakyOpen fails to open the file `path` with `err` for the first `failures` attempts; other files are opened.
+func flakyOpen(path string, failures int, err error, attempts *int) func(string, int, fs.FileMode) (*os.File, error) {
+	return func(name string, flag int, perm fs.FileMode) (*os.File, error) {
+		if name != path {
+			return os.OpenFile(name, flag, perm)
+		}
+		*attempts++
+		if *attempts <= failures {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+}
+
+// TestWithOpenRetries verifies that transient open errors are tolerated, and that permanent ones are not retried.
+func TestWithOpenRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		wantAttempts int
+		wantErr      error
+	}{
+		{"transient", 2, syscall.EAGAIN, 3, nil},
+		{"always transient", openAttempts + 1, syscall.EAGAIN, openAttempts, syscall.EAGAIN},
+		{"permission denied", 1, syscall.EACCES, 1, fs.ErrPermission},
+		{"not exist", 1, syscall.ENOENT, 1, fs.ErrNotExist},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			x := newExporter(newTestLogger(), "/pcap", "", 5, 0, nil)
+			x.openDelay = 0
+			attempts := 0
+			srcPcapFile := newSourcePcapFile(t)
+			x.openFile = flakyOpen(srcPcapFile, tc.failures, tc.err, &attempts)
+
+			f, err := x.withOpenRetries(context.Background(), srcPcapFile, os.O_RDONLY, 0)
+			if f != nil {
+				f.Close()
+			}
+
+			if tc.wantErr == nil && err != nil {
+				t.Fatalf("withOpenRetries failed: %v", err)
+			}
+			if tc.wantErr != nil && (!errors.Is(err, tc.wantErr) || !errors.Is(err, errOpenFailed)) {
+				t.Fatalf("got %v, want %v", err, tc.wantErr)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+// TestFuseExportRetriesOpen verifies that an export succeeds despite transient failures to open the source PCAP file,
+// and that open failures are not retried again by the export itself.
+func TestFuseExportRetriesOpen(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"transient", 2, 3, false},
+		// the export itself would be retried 3 times
+		{"persistent", 100, openAttempts, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			directory := t.TempDir()
+			x := NewFuseExporter(newTestLogger(), directory, "", 3, 0, 0, nil).(*fuseExporter)
+			x.openDelay = 0
+			attempts := 0
+			srcPcapFile := newSourcePcapFile(t)
+			x.openFile = flakyOpen(srcPcapFile, tc.failures, syscall.EAGAIN, &attempts)
+
+			tgtPcapFile, pcapBytes, err := x.Export(context.Background(), &srcPcapFile, false, true)
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("export succeeded, want error")
+				}
+				if entries, _ := os.ReadDir(directory); len(entries) != 0 {
+					t.Errorf("destination was written: %v", entries)
+				}
+			} else if err != nil {
+				t.Fatalf("export failed: %v", err)
+			} else if *pcapBytes != 1<<20 || filepath.Dir(*tgtPcapFile) != directory {
+				t.Errorf("got %d bytes at %s", *pcapBytes, *tgtPcapFile)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/naming"
+)
+
+// Shards spreads exported PCAP files across `shard00/`...`shardNN/` sub-prefixes of the destination directory;
+// GCS auto-scales per key range, so spreading objects prevents a single prefix from becoming a hotspot.
+type Shards struct {
+	count  uint32
+	format string
+	// number of PCAP files exported into each shard
+	exported []atomic.Uint64
+}
+
+const maxShards = 1000
+
+// NewShards returns `nil` when `count` is `0`, which disables sharding.
+func NewShards(
+	count uint,
+) (*Shards, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	if count > maxShards {
+		return nil, fmt.Errorf("shard count must not exceed %d: %d", maxShards, count)
+	}
+	width := max(len(fmt.Sprint(count-1)), 2)
+	return &Shards{
+		count:    uint32(count),
+		format:   fmt.Sprintf("shard%%0%dd", width),
+		exported: make([]atomic.Uint64, count),
+	}, nil
+}
+
+// index hashes the capture of the source PCAP file, so that it always lands in the same shard:
+// even when it is re-exported, compressed, or when files are derived from it.
+func (s *Shards) index(
+	srcPcapFile string,
+) uint32 {
+	key := naming.SafeBaseName(srcPcapFile)
+	if pcapFile, err := naming.ParseBaseName(srcPcapFile); err == nil {
+		key = pcapFile.Capture()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % s.count
+}
+
+// Dir returns the shard sub-prefix for the source PCAP file; `""` if sharding is disabled.
+func (s *Shards) Dir(
+	srcPcapFile string,
+) string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf(s.format, s.index(srcPcapFile))
+}
+
+// Join returns the destination path of the source PCAP file within `directory`.
+func (s *Shards) Join(
+	directory string,
+	srcPcapFile string,
+	tgtPcapFileName string,
+) string {
+	return filepath.Join(directory, s.Dir(srcPcapFile), tgtPcapFileName)
+}
+
+func (s *Shards) record(
+	srcPcapFile string,
+) {
+	if s == nil {
+		return
+	}
+	s.exported[s.index(srcPcapFile)].Add(1)
+}
+
+// Counts returns the number of PCAP files exported into each shard.
+func (s *Shards) Counts() map[string]uint64 {
+	if s == nil {
+		return nil
+	}
+	counts := make(map[string]uint64, s.count)
+	for i := range s.exported {
+		counts[fmt.Sprintf(s.format, i)] = s.exported[i].Load()
+	}
+	return counts
+}
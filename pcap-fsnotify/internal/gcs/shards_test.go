@@ -0,0 +1,111 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcs
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// TestNewShards verifies shard counts and the width of shard names.
+func TestNewShards(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   uint
+		want    string
+		wantErr bool
+	}{
+		{"disabled", 0, "", false},
+		{"one", 1, "shard00", false},
+		{"two digits", 100, "shard00", false},
+		{"three digits", 101, "shard000", false},
+		{"too many", maxShards + 1, "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			shards, err := NewShards(tc.count)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewShards(%d): error %v, wantErr %v", tc.count, err, tc.wantErr)
+			}
+			if got := shards.Dir("/pcap-tmp/part__1_eth0__20240101T000000.pcap"); len(got) != len(tc.want) {
+				t.Errorf("NewShards(%d): got shard %q, want the same width as %q", tc.count, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestShardsAreDeterministic verifies that compressed, re-exported and derived files land in the same shard.
+func TestShardsAreDeterministic(t *testing.T) {
+	shards, _ := NewShards(16)
+
+	src := "/pcap-tmp/part__2_eth1__20240101T000000.pcap"
+	want := shards.Dir(src)
+
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"re-exported", src},
+		{"other source directory", "/other/part__2_eth1__20240101T000000.pcap"},
+		{"compressed", src + ".gz"},
+		{"derived", src + ".analysis.json"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := shards.Dir(tc.src); got != want {
+				t.Errorf("Dir(%s): got %s, want %s", tc.src, got, want)
+			}
+		})
+	}
+
+	if got, want := shards.Join("/pcap", src, "x.pcap"), filepath.Join("/pcap", want, "x.pcap"); got != want {
+		t.Errorf("Join: got %s, want %s", got, want)
+	}
+}
+
+// TestShardsSpreadFiles verifies that PCAP files are spread across shards and counted.
+func TestShardsSpreadFiles(t *testing.T) {
+	shards, _ := NewShards(4)
+
+	for i := 0; i < 64; i++ {
+		shards.record(fmt.Sprintf("/pcap-tmp/part__1_eth0__20240101T0000%02d.pcap", i))
+	}
+
+	counts := shards.Counts()
+	if len(counts) != 4 {
+		t.Fatalf("Counts: got %d shards, want 4", len(counts))
+	}
+	total, used := uint64(0), 0
+	for _, count := range counts {
+		total += count
+		if count > 0 {
+			used++
+		}
+	}
+	if total != 64 {
+		t.Errorf("Counts: got %d PCAP files, want 64", total)
+	}
+	if used < 2 {
+		t.Errorf("Counts: PCAP files landed in %d shard(s), want them spread", used)
+	}
+
+	var disabled *Shards
+	if counts := disabled.Counts(); counts != nil {
+		t.Errorf("Counts: got %v for disabled sharding, want nil", counts)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/auth"
+)
+
+type (
+	Status struct {
+		// Started is set once the watcher is fully up
+		Started bool              `json:"started"`
+		Ready   bool              `json:"ready"`
+		Reasons map[string]string `json:"reasons,omitempty"`
+		// a degraded exporter is still healthy
+		Degraded        bool              `json:"degraded"`
+		DegradedReasons map[string]string `json:"degraded_reasons,omitempty"`
+		// volatile runtime information; i.e.: resolved network interfaces
+		Info map[string]any `json:"info,omitempty"`
+	}
+
+	// Server reports the exporter as unready while any component is unready.
+	Server struct {
+		mu       sync.RWMutex
+		started  bool
+		unready  map[string]string
+		degraded map[string]string
+		info     map[string]any
+		mux      *http.ServeMux
+	}
+)
+
+func NewServer() *Server {
+	s := &Server{
+		unready:  make(map[string]string),
+		degraded: make(map[string]string),
+		info:     make(map[string]any),
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	return s
+}
+
+// SetStarted marks the startup as complete: `/readyz` is only successful afterwards.
+func (s *Server) SetStarted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+}
+
+func (s *Server) SetUnready(
+	component, reason string,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unready[component] = reason
+}
+
+func (s *Server) SetReady(
+	component string,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.unready, component)
+}
+
+func (s *Server) SetDegraded(
+	component, reason string,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.degraded[component] = reason
+}
+
+func (s *Server) ClearDegraded(
+	component string,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.degraded, component)
+}
+
+// SetInfo publishes `value` at `key` without affecting readiness.
+func (s *Server) SetInfo(
+	key string,
+	value any,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info[key] = value
+}
+
+func (s *Server) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := Status{
+		Started:  s.started,
+		Ready:    len(s.unready) == 0,
+		Degraded: len(s.degraded) > 0,
+	}
+	if !status.Ready {
+		status.Reasons = maps.Clone(s.unready)
+	}
+	if status.Degraded {
+		status.DegradedReasons = maps.Clone(s.degraded)
+	}
+	if len(s.info) > 0 {
+		status.Info = maps.Clone(s.info)
+	}
+	return status
+}
+
+func (s *Server) handleHealthz(
+	w http.ResponseWriter,
+	_ *http.Request,
+) {
+	status := s.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleReadyz is successful only after startup is complete, and while all components are ready.
+func (s *Server) handleReadyz(
+	w http.ResponseWriter,
+	_ *http.Request,
+) {
+	status := s.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Started || !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleCommand serves `POST /${name}` by running `command` for requests authorized by `token`;
+// the response is the resulting status.
+func (s *Server) HandleCommand(
+	name string,
+	token *auth.Token,
+	command func() error,
+) {
+	s.mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !token.IsAuthorized(r) {
+			auth.Challenge(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := command(); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(s.Status())
+	})
+}
+
+// Handle serves additional endpoints next to health checks; i.e.: retrieval of exported PCAP files.
+func (s *Server) Handle(
+	pattern string,
+	handler http.Handler,
+) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start serves health checks at `addr` until `ctx` is done.
+func (s *Server) Start(
+	ctx context.Context,
+	addr string,
+) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			listener.Close()
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/auth"
+)
+
+// TestHandleCommand verifies that commands only run on authorized POST requests, and that they respond with the resulting status.
+func TestHandleCommand(t *testing.T) {
+	token, err := auth.NewToken("secret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	paused := false
+	s.HandleCommand("pause", token, func() error {
+		if paused {
+			return errors.New("already paused")
+		}
+		paused = true
+		s.SetDegraded("exports", "paused")
+		return nil
+	})
+
+	serve := func(method, bearer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/pause", nil)
+		if bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(http.MethodGet, "secret-token"); w.Code != http.StatusMethodNotAllowed || paused {
+		t.Fatalf("GET /pause = %d (paused=%v), want %d", w.Code, paused, http.StatusMethodNotAllowed)
+	}
+	for _, bearer := range []string{"", "other"} {
+		if w := serve(http.MethodPost, bearer); w.Code != http.StatusUnauthorized || paused {
+			t.Fatalf("POST /pause with token %q = %d (paused=%v), want %d", bearer, w.Code, paused, http.StatusUnauthorized)
+		}
+	}
+
+	w := serve(http.MethodPost, "secret-token")
+	if w.Code != http.StatusOK || !paused {
+		t.Fatalf("POST /pause = %d (paused=%v), want %d", w.Code, paused, http.StatusOK)
+	}
+	var status Status
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid status: %v", err)
+	}
+	if !status.Ready || !status.Degraded || status.DegradedReasons["exports"] != "paused" {
+		t.Errorf("status = %+v, want ready and degraded by paused exports", status)
+	}
+
+	if w := serve(http.MethodPost, "secret-token"); w.Code != http.StatusConflict {
+		t.Errorf("POST /pause twice = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+// TestReadyz verifies that `/readyz` fails until startup is complete, and while any component is unready;
+// `/healthz` does not depend on startup.
+func TestReadyz(t *testing.T) {
+	s := NewServer()
+
+	serve := func(path string) int {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	tests := []struct {
+		name        string
+		update      func()
+		wantReadyz  int
+		wantHealthz int
+	}{
+		{"starting", func() {}, http.StatusServiceUnavailable, http.StatusOK},
+		{"started", s.SetStarted, http.StatusOK, http.StatusOK},
+		{"unready", func() { s.SetUnready("gcs_dir", "missing") }, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{"ready", func() { s.SetReady("gcs_dir") }, http.StatusOK, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		tc.update()
+		if got := serve("/readyz"); got != tc.wantReadyz {
+			t.Errorf("%s: GET /readyz = %d, want %d", tc.name, got, tc.wantReadyz)
+		}
+		if got := serve("/healthz"); got != tc.wantHealthz {
+			t.Errorf("%s: GET /healthz = %d, want %d", tc.name, got, tc.wantHealthz)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lifecycle owns the long-lived goroutines of the PCAP files exporter, and sequences its shutdown:
+// every goroutine shares the root context, which is cancelled exactly once by whoever stops the group first.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Group is safe for concurrent use; a `nil` group is stopped.
+	Group struct {
+		ctx     context.Context
+		cancel  context.CancelCauseFunc
+		wg      sync.WaitGroup
+		stopped atomic.Bool
+		mu      sync.Mutex
+		errs    []error
+	}
+
+	// Step is a shutdown step; steps are run in order, and every step runs even if a previous one failed.
+	Step struct {
+		Name string
+		Run  func() error
+	}
+
+	StepResult struct {
+		Name     string `json:"name"`
+		Duration string `json:"duration"`
+		Error    string `json:"error,omitempty"`
+	}
+)
+
+// ErrStopped is the cause of groups stopped without a specific cause.
+var ErrStopped = errors.New("stopped")
+
+func NewGroup(
+	parent context.Context,
+) *Group {
+	ctx, cancel := context.WithCancelCause(parent)
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Context is cancelled when the group is stopped.
+func (g *Group) Context() context.Context {
+	return g.ctx
+}
+
+// Go runs `fn` in a goroutine owned by the group; an error returned by `fn` stops the group.
+func (g *Group) Go(
+	name string,
+	fn func(ctx context.Context) error,
+) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := fn(g.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			err = fmt.Errorf("%s: %w", name, err)
+			g.mu.Lock()
+			g.errs = append(g.errs, err)
+			g.mu.Unlock()
+			g.Stop(err)
+		}
+	}()
+}
+
+// Stop cancels the group context with `cause`; only the first call has any effect, and it is the only one returning `true`.
+func (g *Group) Stop(
+	cause error,
+) bool {
+	if !g.stopped.CompareAndSwap(false, true) {
+		return false
+	}
+	if cause == nil {
+		cause = ErrStopped
+	}
+	g.cancel(cause)
+	return true
+}
+
+func (g *Group) Stopped() bool {
+	return g == nil || g.stopped.Load()
+}
+
+// Cause returns the cause the group was stopped with, or `nil` while it is running.
+func (g *Group) Cause() error {
+	return context.Cause(g.ctx)
+}
+
+// Wait returns once all goroutines owned by the group returned; it does not stop the group.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
+
+// Shutdown runs `steps` sequentially, in the given order.
+func Shutdown(
+	steps ...Step,
+) []StepResult {
+	results := make([]StepResult, 0, len(steps))
+	for _, step := range steps {
+		start := time.Now()
+		err := step.Run()
+		result := StepResult{Name: step.Name, Duration: time.Since(start).String()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,324 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+) \[`)
+
+// goroutines returns the stacks of all running goroutines by ID.
+func goroutines() map[string]string {
+	buffer := make([]byte, 1<<20)
+	buffer = buffer[:runtime.Stack(buffer, true /* all */)]
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buffer), "\n\n") {
+		if match := goroutineHeader.FindStringSubmatch(stack); match != nil {
+			stacks[match[1]] = stack
+		}
+	}
+	return stacks
+}
+
+// verifyNoLeaks fails `t` when goroutines started after it was called are still running once the test ends;
+// goroutines are given some time to return, as they may be unblocked right before the test ends.
+func verifyNoLeaks(t *testing.T) {
+	before := goroutines()
+	t.Cleanup(func() {
+		var leaked []string
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; !ok && !strings.Contains(stack, "verifyNoLeaks") {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+		}
+		t.Errorf("%d leaked goroutines:\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+	})
+}
+
+// exporter reproduces the goroutines of the PCAP files exporter: an events loop, and a signal handler which waits
+// for the capture engine to stop before stopping the group; the final flush runs once both returned.
+type exporter struct {
+	group   *Group
+	events  chan string
+	signals chan string
+	// closed when the capture engine stops
+	stopped chan struct{}
+	mu      sync.Mutex
+	log     []string
+}
+
+func (x *exporter) record(entry string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.log = append(x.log, entry)
+}
+
+// waitFor blocks until `entry` is recorded, so that scenarios are deterministic.
+func (x *exporter) waitFor(entry string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		x.mu.Lock()
+		found := len(x.log) > 0 && x.log[len(x.log)-1] == entry
+		x.mu.Unlock()
+		if found {
+			return
+		}
+	}
+}
+
+func newExporter(initErr error) *exporter {
+	x := &exporter{
+		group:   NewGroup(context.Background()),
+		events:  make(chan string),
+		signals: make(chan string),
+		stopped: make(chan struct{}),
+	}
+
+	x.group.Go("events", func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case event := <-x.events:
+				x.record("event:" + event)
+				if event == "TCPDUMPW_EXITED" && x.group.Stop(errors.New("sentinel")) {
+					x.record("stopped by sentinel")
+					return nil
+				}
+			}
+		}
+	})
+
+	x.group.Go("signals", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case signal := <-x.signals:
+			x.record("signal:" + signal)
+		}
+		deadline, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		select {
+		case <-x.stopped:
+		case <-deadline.Done():
+		}
+		if x.group.Stop(errors.New("signal")) {
+			x.record("stopped by signal")
+		}
+		return nil
+	})
+
+	if initErr != nil && x.group.Stop(initErr) {
+		x.record("stopped by error at init")
+	}
+	return x
+}
+
+// shutdown waits for the group to be stopped, and then runs the same shutdown sequence as the PCAP files exporter.
+func (x *exporter) shutdown(t *testing.T) []StepResult {
+	select {
+	case <-x.group.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("group was never stopped")
+	}
+	return Shutdown(
+		Step{"wait", func() error { return x.group.Wait() }},
+		Step{"flush", func() error {
+			x.record("flushed")
+			return nil
+		}},
+	)
+}
+
+// TestShutdownScenarios verifies that every scenario stops the group exactly once, runs the shutdown sequence in order,
+// and leaves no goroutines behind.
+func TestShutdownScenarios(t *testing.T) {
+	tests := []struct {
+		name    string
+		initErr error
+		trigger func(x *exporter)
+		cause   string
+		want    []string
+	}{
+		{
+			name: "signal-driven",
+			trigger: func(x *exporter) {
+				x.signals <- "SIGTERM"
+				close(x.stopped)
+			},
+			cause: "signal",
+			want:  []string{"signal:SIGTERM", "stopped by signal", "flushed"},
+		},
+		{
+			name: "sentinel-driven",
+			trigger: func(x *exporter) {
+				x.events <- "part__1_eth0__20240101T000000.pcap"
+				x.events <- "TCPDUMPW_EXITED"
+			},
+			cause: "sentinel",
+			want:  []string{"event:part__1_eth0__20240101T000000.pcap", "event:TCPDUMPW_EXITED", "stopped by sentinel", "flushed"},
+		},
+		{
+			name: "signal then sentinel",
+			trigger: func(x *exporter) {
+				// the signal handler waits for the capture engine, which signals its termination first
+				x.signals <- "SIGTERM"
+				x.waitFor("signal:SIGTERM")
+				x.events <- "TCPDUMPW_EXITED"
+				x.waitFor("stopped by sentinel")
+				close(x.stopped)
+			},
+			cause: "sentinel",
+			want:  []string{"signal:SIGTERM", "event:TCPDUMPW_EXITED", "stopped by sentinel", "flushed"},
+		},
+		{
+			name: "signal deadline",
+			trigger: func(x *exporter) {
+				// the capture engine never stops: the signal handler gives up after its deadline
+				x.signals <- "SIGTERM"
+			},
+			cause: "signal",
+			want:  []string{"signal:SIGTERM", "stopped by signal", "flushed"},
+		},
+		{
+			name:    "error at init",
+			initErr: errors.New("failed to watch directory"),
+			trigger: func(*exporter) {},
+			cause:   "failed to watch directory",
+			want:    []string{"stopped by error at init", "flushed"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verifyNoLeaks(t)
+
+			x := newExporter(tc.initErr)
+			tc.trigger(x)
+			results := x.shutdown(t)
+
+			if cause := x.group.Cause(); cause == nil || cause.Error() != tc.cause {
+				t.Errorf("cause = %v, want %s", cause, tc.cause)
+			}
+			if !x.group.Stopped() || x.group.Stop(errors.New("late")) {
+				t.Error("a stopped group must not be stopped again")
+			}
+			if len(results) != 2 || results[0].Name != "wait" || results[0].Error != "" || results[1].Name != "flush" {
+				t.Errorf("shutdown = %+v", results)
+			}
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			if strings.Join(x.log, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("log = %v, want %v", x.log, tc.want)
+			}
+		})
+	}
+}
+
+// TestStopOnce verifies that exactly one of many concurrent callers stops the group.
+func TestStopOnce(t *testing.T) {
+	verifyNoLeaks(t)
+
+	g := NewGroup(context.Background())
+	var stops atomic.Int32
+	var wg sync.WaitGroup
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if g.Stop(nil) {
+				stops.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stops.Load() != 1 {
+		t.Errorf("stops = %d, want 1", stops.Load())
+	}
+	if !errors.Is(g.Cause(), ErrStopped) {
+		t.Errorf("cause = %v, want %v", g.Cause(), ErrStopped)
+	}
+
+	var nilGroup *Group
+	if !nilGroup.Stopped() {
+		t.Error("a nil group must be stopped")
+	}
+}
+
+// TestGoError verifies that a failing goroutine stops the group, and that its error is returned by `Wait`.
+func TestGoError(t *testing.T) {
+	verifyNoLeaks(t)
+
+	g := NewGroup(context.Background())
+	errFailed := errors.New("failed")
+	g.Go("waiting", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	g.Go("failing", func(context.Context) error {
+		return errFailed
+	})
+
+	if err := g.Wait(); !errors.Is(err, errFailed) || !strings.Contains(err.Error(), "failing") {
+		t.Errorf("Wait() = %v, want %v", err, errFailed)
+	}
+	if !errors.Is(g.Cause(), errFailed) {
+		t.Errorf("cause = %v, want %v", g.Cause(), errFailed)
+	}
+}
+
+// TestShutdownOrder verifies that steps run in order, and that failed steps do not prevent the next ones.
+func TestShutdownOrder(t *testing.T) {
+	var order []string
+	step := func(name string, err error) Step {
+		return Step{name, func() error {
+			order = append(order, name)
+			return err
+		}}
+	}
+
+	results := Shutdown(step("tasks", nil), step("watcher", errors.New("closed")), step("flush", nil))
+
+	if strings.Join(order, ",") != "tasks,watcher,flush" {
+		t.Errorf("order = %v", order)
+	}
+	if len(results) != 3 || results[1].Error != "closed" || results[0].Error != "" || results[2].Error != "" {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestParseLockFailure(t *testing.T) {
+	if mode, err := ParseLockFailure("Skip-Current"); err != nil || mode != LOCK_FAILURE_SKIP_CURRENT {
+		t.Errorf("ParseLockFailure(Skip-Current) = %v, %v", mode, err)
+	}
+	if mode, err := ParseLockFailure("wait"); err == nil || mode != LOCK_FAILURE_SKIP_CURRENT {
+		t.Errorf("ParseLockFailure(wait) = %v, %v", mode, err)
+	}
+}
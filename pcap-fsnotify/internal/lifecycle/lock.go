@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LockFailure defines the final flush when the PCAP lock is not acquired before the deadline:
+// capture processes may still be writing into their current PCAP files.
+type LockFailure string
+
+const (
+	// LOCK_FAILURE_ABORT skips the final flush: PCAP files are preserved for the next run
+	LOCK_FAILURE_ABORT = LockFailure("abort")
+	// LOCK_FAILURE_FORCE flushes all PCAP files, including those which may still be written
+	LOCK_FAILURE_FORCE = LockFailure("force")
+	// LOCK_FAILURE_SKIP_CURRENT flushes all PCAP files but the most recent one of every key
+	LOCK_FAILURE_SKIP_CURRENT = LockFailure("skip-current")
+)
+
+func ParseLockFailure(
+	mode string,
+) (LockFailure, error) {
+	switch m := LockFailure(strings.ToLower(mode)); m {
+	case LOCK_FAILURE_ABORT, LOCK_FAILURE_FORCE, LOCK_FAILURE_SKIP_CURRENT:
+		return m, nil
+	default:
+		return LOCK_FAILURE_SKIP_CURRENT, fmt.Errorf("invalid lock failure mode: %s", mode)
+	}
+}
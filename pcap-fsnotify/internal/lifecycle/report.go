@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type (
+	// Report is the outcome of a session, written once it is shut down so that it can be collected after the process exits.
+	Report struct {
+		Cause    string    `json:"cause"`
+		Started  time.Time `json:"started"`
+		Stopped  time.Time `json:"stopped"`
+		Exported uint64    `json:"exported"`
+		Bytes    int64     `json:"bytes"`
+		Failures uint64    `json:"failures"`
+		// Abandoned are the PCAP files which were not exported by the final flush, along with the reason
+		Abandoned []AbandonedFile `json:"abandoned"`
+		// Keys are the PCAP files detected for every key, i.e.: for every extension and interface
+		Keys map[string]uint64 `json:"keys"`
+		// Latency is the time from the session being stopped to the report being written
+		Latency  string       `json:"latency"`
+		Shutdown []StepResult `json:"shutdown,omitempty"`
+	}
+
+	AbandonedFile struct {
+		Path   string `json:"path"`
+		Reason string `json:"reason,omitempty"`
+	}
+)
+
+// Clean reports whether all PCAP files were drained: none was abandoned, and every shutdown step succeeded.
+func (r *Report) Clean() bool {
+	if len(r.Abandoned) > 0 {
+		return false
+	}
+	for _, step := range r.Shutdown {
+		if step.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteFile writes the report as JSON into `path`; the file is replaced atomically, so it is never read partially written.
+func (r *Report) WriteFile(
+	path string,
+) error {
+	content, err := json.MarshalIndent(struct {
+		*Report
+		Clean bool `json:"clean"`
+	}{r, r.Clean()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
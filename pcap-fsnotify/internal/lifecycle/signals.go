@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// DefaultShutdownSignals are the signals which stop the session unless configured otherwise; `SIGHUP` is left for reloads.
+const DefaultShutdownSignals = "SIGTERM,SIGINT,SIGQUIT"
+
+// shutdownSignals are all signals which may stop the session; `SIGUSR1` is reserved for manual flushes.
+var shutdownSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// ParseSignals parses a comma-separated list of signal names, with or without their `SIG` prefix, i.e.: `SIGTERM,INT`.
+func ParseSignals(
+	names string,
+) ([]os.Signal, error) {
+	var signals []os.Signal
+	var errs []error
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !strings.HasPrefix(name, "SIG") {
+			name = "SIG" + name
+		}
+		sig, ok := shutdownSignals[name]
+		if name == "SIGUSR1" {
+			errs = append(errs, fmt.Errorf("reserved for manual flushes: %s", name))
+		} else if !ok {
+			errs = append(errs, fmt.Errorf("unsupported signal: %s", name))
+		} else if !slices.Contains(signals, os.Signal(sig)) {
+			signals = append(signals, sig)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if len(signals) == 0 {
+		return nil, errors.New("at least one signal is required")
+	}
+	return signals, nil
+}
+
+// NotifySignals relays `signals` to `shutdown`, and `SIGHUP` to `reload` unless it is one of `signals`:
+// a signal which is not relayed to `shutdown` never stops the session.
+func NotifySignals(
+	shutdown, reload chan<- os.Signal,
+	signals []os.Signal,
+) {
+	signal.Notify(shutdown, signals...)
+	if !slices.Contains(signals, os.Signal(syscall.SIGHUP)) {
+		signal.Notify(reload, syscall.SIGHUP)
+	}
+}
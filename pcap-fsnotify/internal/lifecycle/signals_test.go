@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseSignals(t *testing.T) {
+	tests := []struct {
+		names   string
+		want    []os.Signal
+		wantErr bool
+	}{
+		{DefaultShutdownSignals, []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT}, false},
+		{" term, sigHup ,TERM", []os.Signal{syscall.SIGTERM, syscall.SIGHUP}, false},
+		{"SIGTERM,SIGUSR1", nil, true},
+		{"SIGTERM,SIGKILL", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.names, func(t *testing.T) {
+			got, err := ParseSignals(tc.names)
+			if !slices.Equal(got, tc.want) || (err != nil) != tc.wantErr {
+				t.Errorf("ParseSignals(%q) = %v, %v; want %v, error: %v", tc.names, got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestNotifySignals verifies that a signal which is not a shutdown signal never reaches the shutdown path,
+// and that `SIGHUP` is relayed for reloads instead.
+func TestNotifySignals(t *testing.T) {
+	shutdown := make(chan os.Signal, 1)
+	reload := make(chan os.Signal, 1)
+	NotifySignals(shutdown, reload, []os.Signal{syscall.SIGUSR2})
+	defer signal.Stop(shutdown)
+	defer signal.Stop(reload)
+
+	receive := func(signals <-chan os.Signal) os.Signal {
+		select {
+		case sig := <-signals:
+			return sig
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if sig := receive(reload); sig != syscall.SIGHUP {
+		t.Errorf("reload = %v, want %v", sig, syscall.SIGHUP)
+	}
+	select {
+	case sig := <-shutdown:
+		t.Errorf("shutdown = %v, want none", sig)
+	default:
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	if sig := receive(shutdown); sig != syscall.SIGUSR2 {
+		t.Errorf("shutdown = %v, want %v", sig, syscall.SIGUSR2)
+	}
+}
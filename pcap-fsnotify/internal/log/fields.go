@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type (
+	// Field is an identity field which may be attached to log events.
+	Field string
+
+	// Fields is an allowlist of identity fields.
+	Fields []Field
+)
+
+const (
+	FIELD_PROJECT  = Field("project")
+	FIELD_REGION   = Field("region")
+	FIELD_SERVICE  = Field("service")
+	FIELD_VERSION  = Field("version")
+	FIELD_INSTANCE = Field("instance")
+	FIELD_SIDECAR  = Field("sidecar")
+	FIELD_MODULE   = Field("module")
+)
+
+var (
+	// AllFields are attached to log events by default.
+	AllFields = Fields{
+		FIELD_PROJECT,
+		FIELD_REGION,
+		FIELD_SERVICE,
+		FIELD_VERSION,
+		FIELD_INSTANCE,
+		FIELD_SIDECAR,
+		FIELD_MODULE,
+	}
+
+	// DefaultAuditFields are attached to GCS audit logs by default.
+	DefaultAuditFields = Fields{
+		FIELD_PROJECT,
+		FIELD_SERVICE,
+		FIELD_INSTANCE,
+	}
+
+	// identity fields are attached to log events as `tags`; `tags_list` always holds all of them in this order
+	tagFields = Fields{
+		FIELD_PROJECT,
+		FIELD_SERVICE,
+		FIELD_REGION,
+		FIELD_VERSION,
+		FIELD_INSTANCE,
+	}
+)
+
+// ParseFields returns the allowlist of identity fields in `fields`; empty items are ignored.
+func ParseFields(
+	fields []string,
+) (Fields, error) {
+	allowlist := Fields{}
+	for _, field := range fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+		if !AllFields.Has(Field(field)) {
+			return nil, fmt.Errorf("unknown field '%s': must be one of %s", field, AllFields)
+		}
+		if !allowlist.Has(Field(field)) {
+			allowlist = append(allowlist, Field(field))
+		}
+	}
+	return allowlist, nil
+}
+
+// Has reports whether `field` is allowed.
+func (f Fields) Has(
+	field Field,
+) bool {
+	return slices.Contains(f, field)
+}
+
+func (f Fields) String() string {
+	fields := make([]string, len(f))
+	for i, field := range f {
+		fields[i] = string(field)
+	}
+	return strings.Join(fields, ",")
+}
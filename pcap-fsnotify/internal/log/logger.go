@@ -16,8 +16,10 @@ package log
 
 import (
 	"maps"
+	"sync/atomic"
 	"time"
 
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
 	constants "github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/constants"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -26,17 +28,14 @@ import (
 type (
 	pcapEvent = constants.PcapEvent
 
-	fsnEvent struct {
-		Source string `json:"source,omitempty"`
-		Target string `json:"target,omitempty"`
-		Bytes  int64  `json:"bytes,omitempty"`
-	}
+	// EventIDs returns the ID which correlates all events about the PCAP file at `path`; it returns "" if there is none.
+	EventIDs func(path string) string
 
 	Logger struct {
 		*zap.Logger
-		sidecar string
-		module  string
-		tags    []string
+		identity atomic.Pointer[map[Field]string]
+		fields   atomic.Pointer[Fields]
+		eventIDs atomic.Pointer[EventIDs]
 	}
 )
 
@@ -53,8 +52,6 @@ var (
 			EncodeTime:  zapcore.ISO8601TimeEncoder,
 		},
 	}.Build()
-
-	sugar = l.Sugar()
 )
 
 func NewLogger(
@@ -66,46 +63,89 @@ func NewLogger(
 	sidecar string,
 	module string,
 ) *Logger {
-	return &Logger{
-		Logger:  l,
-		sidecar: sidecar,
-		module:  module,
-		tags: []string{
-			projectID,
-			service,
-			gcpRegion,
-			version,
-			instanceID,
-		},
+	logger := &Logger{Logger: l}
+	logger.identity.Store(&map[Field]string{
+		FIELD_PROJECT:  projectID,
+		FIELD_REGION:   gcpRegion,
+		FIELD_SERVICE:  service,
+		FIELD_VERSION:  version,
+		FIELD_INSTANCE: instanceID,
+		FIELD_SIDECAR:  sidecar,
+		FIELD_MODULE:   module,
+	})
+	logger.SetFields(AllFields)
+	return logger
+}
+
+// SetFields sets the identity fields attached to every log event.
+func (l *Logger) SetFields(
+	fields Fields,
+) {
+	l.fields.Store(&fields)
+}
+
+// SetIdentity replaces the values of the given identity fields; other fields are kept.
+func (l *Logger) SetIdentity(
+	identity map[Field]string,
+) {
+	updated := l.Identity()
+	maps.Copy(updated, identity)
+	l.identity.Store(&updated)
+}
+
+// Identity returns all identity fields, regardless of the ones attached to log events.
+func (l *Logger) Identity() map[Field]string {
+	return maps.Clone(*l.identity.Load())
+}
+
+// SetEventIDs sets how events about PCAP files are correlated: the ID of their source, or else of their target, is attached to them.
+func (l *Logger) SetEventIDs(
+	eventIDs EventIDs,
+) {
+	l.eventIDs.Store(&eventIDs)
+}
+
+// newIdentityKeysAndValues returns the allowed identity fields as loosely-typed key-value pairs.
+func (l *Logger) newIdentityKeysAndValues() []any {
+	fields := *l.fields.Load()
+	identity := *l.identity.Load()
+	keysAndValues := []any{}
+	for _, field := range []Field{FIELD_SIDECAR, FIELD_MODULE} {
+		if fields.Has(field) {
+			keysAndValues = append(keysAndValues, string(field), identity[field])
+		}
+	}
+	// `tags` are named: excluded and empty identity fields are skipped rather than left empty
+	tags := make(map[string]string, len(tagFields))
+	// `tags_list` is the former positional array: excluded and empty identity fields are left empty so that positions never shift
+	tagsList := make([]string, len(tagFields))
+	for i, field := range tagFields {
+		if value := identity[field]; fields.Has(field) && value != "" {
+			tags[string(field)] = value
+			tagsList[i] = value
+		}
 	}
+	if len(tags) > 0 {
+		keysAndValues = append(keysAndValues, "tags", tags, "tags_list", tagsList)
+	}
+	return keysAndValues
 }
 
+// LogEvent logs `payload` as the `data` of a structured log event, along with the schema version of its event type.
 func (l *Logger) LogEvent(
 	level zapcore.Level,
 	message string,
-	event pcapEvent,
-	data map[string]any,
+	payload telemetry.Payload,
 	err error,
 ) {
 	now := time.Now()
-	_data := map[string]any{
-		"event": event,
-	}
-	if err != nil {
-		_data["error"] = err.Error()
-	}
-	if len(data) > 0 {
-		maps.Copy(_data, data)
-	}
-	sugar.Logw(level, message,
-		"sidecar", l.sidecar,
-		"module", l.module,
-		"tags", l.tags,
-		"data", _data,
-		"timestamp", map[string]interface{}{
-			"seconds": now.Unix(),
-			"nanos":   now.Nanosecond(),
-		})
+	l.Sugar().Logw(level, message,
+		append(l.newIdentityKeysAndValues(),
+			"data", telemetry.Data(payload, err),
+			"timestamp", map[string]interface{}{
+				"seconds": now.Unix(),
+				"nanos":   now.Nanosecond(),
+			})...)
 }
 
 func (l *Logger) LogFsEvent(
@@ -116,15 +156,34 @@ func (l *Logger) LogFsEvent(
 	by int64,
 	err error,
 ) {
-	e := fsnEvent{
+	l.LogFsEventWith(level, message, telemetry.New(event), src, tgt, by, err)
+}
+
+// LogFsEventWith is `LogFsEvent` with a populated `payload`; `fs` is always set by the event itself.
+func (l *Logger) LogFsEventWith(
+	level zapcore.Level,
+	message string,
+	payload telemetry.Payload,
+	src, tgt string,
+	by int64,
+	err error,
+) {
+	fs := &telemetry.Fs{
 		Source: src,
 		Target: tgt,
 	}
 	if by > 0 {
-		e.Bytes = by
+		fs.Bytes = by
 	}
-	data := map[string]any{
-		"fs": e,
+	telemetry.SetFs(payload, fs)
+	if eventIDs := l.eventIDs.Load(); eventIDs != nil {
+		path := src
+		if path == "" {
+			path = tgt
+		}
+		if id := (*eventIDs)(path); id != "" {
+			telemetry.SetEventID(payload, id)
+		}
 	}
-	l.LogEvent(level, message, event, data, err)
+	l.LogEvent(level, message, payload, err)
 }
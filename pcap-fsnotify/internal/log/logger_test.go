@@ -0,0 +1,188 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestLogger() *Logger {
+	return NewLogger("project", "service", "region", "version", "instance", "sidecar", "module")
+}
+
+// toJSON renders identity key-value pairs as they are emitted in log events.
+func toJSON(t *testing.T, keysAndValues []any) string {
+	t.Helper()
+	event := map[string]any{}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		event[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestIdentityFields verifies which identity fields are attached to log events for several allowlists.
+func TestIdentityFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{
+			"default",
+			nil,
+			`{"module":"module","sidecar":"sidecar","tags":{"instance":"instance","project":"project","region":"region","service":"service","version":"version"},"tags_list":["project","service","region","version","instance"]}`,
+		},
+		{
+			"no ids",
+			[]string{"region", "service", "version", "sidecar", "module"},
+			`{"module":"module","sidecar":"sidecar","tags":{"region":"region","service":"service","version":"version"},"tags_list":["","service","region","version",""]}`,
+		},
+		{
+			"no tags",
+			[]string{"sidecar"},
+			`{"sidecar":"sidecar"}`,
+		},
+		{
+			"none",
+			[]string{""},
+			`{}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := newTestLogger()
+			if tc.fields != nil {
+				fields, err := ParseFields(tc.fields)
+				if err != nil {
+					t.Fatal(err)
+				}
+				logger.SetFields(fields)
+			}
+			if got := toJSON(t, logger.newIdentityKeysAndValues()); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+			// identity is still available internally
+			if identity := logger.Identity(); len(identity) != len(AllFields) || identity[FIELD_INSTANCE] != "instance" {
+				t.Errorf("identity is not complete: %v", identity)
+			}
+		})
+	}
+}
+
+// TestMissingIdentityField verifies that an empty identity field is skipped from `tags`, and left empty in `tags_list` without misaligning the others.
+func TestMissingIdentityField(t *testing.T) {
+	tests := []struct {
+		name   string
+		logger *Logger
+		want   string
+	}{
+		{
+			"no project",
+			NewLogger("", "service", "region", "version", "instance", "sidecar", "module"),
+			`{"module":"module","sidecar":"sidecar","tags":{"instance":"instance","region":"region","service":"service","version":"version"},"tags_list":["","service","region","version","instance"]}`,
+		},
+		{
+			"no region",
+			NewLogger("project", "service", "", "version", "instance", "sidecar", "module"),
+			`{"module":"module","sidecar":"sidecar","tags":{"instance":"instance","project":"project","service":"service","version":"version"},"tags_list":["project","service","","version","instance"]}`,
+		},
+		{
+			"no tags",
+			NewLogger("", "", "", "", "", "sidecar", "module"),
+			`{"module":"module","sidecar":"sidecar"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := toJSON(t, tc.logger.newIdentityKeysAndValues()); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSetIdentity verifies that identity fields set from the config take precedence over the ones sourced from env vars,
+// and that env vars are still used for the fields which the config does not set.
+func TestSetIdentity(t *testing.T) {
+	logger := NewLogger("env-project", "service", "env-region", "version", "env-instance", "sidecar", "module")
+	logger.SetIdentity(map[Field]string{
+		FIELD_PROJECT:  "cfg-project",
+		FIELD_INSTANCE: "cfg-instance",
+	})
+
+	want := `{"module":"module","sidecar":"sidecar","tags":{"instance":"cfg-instance","project":"cfg-project","region":"env-region","service":"service","version":"version"},"tags_list":["cfg-project","service","env-region","version","cfg-instance"]}`
+	if got := toJSON(t, logger.newIdentityKeysAndValues()); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// an empty config leaves the identity sourced from env vars untouched
+	logger.SetIdentity(map[Field]string{})
+	if identity := logger.Identity(); identity[FIELD_REGION] != "env-region" || identity[FIELD_PROJECT] != "cfg-project" {
+		t.Errorf("identity = %v", identity)
+	}
+}
+
+// TestParseFields verifies that unknown fields are rejected, and that duplicates are ignored.
+func TestParseFields(t *testing.T) {
+	if fields, err := ParseFields([]string{" Project", "project", "instance"}); err != nil || fields.String() != "project,instance" {
+		t.Errorf("got %v, %v", fields, err)
+	}
+	if _, err := ParseFields([]string{"project", "bucket"}); err == nil {
+		t.Error("unknown field was accepted")
+	}
+}
+
+// TestEventIDs verifies that events about a PCAP file carry its event ID whether it is their source or their target,
+// and that events about other PCAP files do not.
+func TestEventIDs(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := newTestLogger()
+	logger.Logger = zap.New(core)
+	logger.SetEventIDs(func(path string) string {
+		if path == "a.pcap" {
+			return "0a1b2c3d"
+		}
+		return ""
+	})
+
+	logger.LogFsEvent(zapcore.InfoLevel, "detected", telemetry.PCAP_CREATE, "a.pcap", "", 0, nil)
+	logger.LogFsEvent(zapcore.ErrorLevel, "unavailable", telemetry.PCAP_EXPORT, "", "a.pcap", 0, nil)
+	logger.LogFsEvent(zapcore.DebugLevel, "mirrored", telemetry.PCAP_MIRROR, "a.pcap", "", 0, nil)
+	logger.LogFsEvent(zapcore.InfoLevel, "detected", telemetry.PCAP_CREATE, "b.pcap", "", 0, nil)
+
+	want := []string{"0a1b2c3d", "0a1b2c3d", "0a1b2c3d", ""}
+	for i, entry := range logs.All() {
+		data := entry.ContextMap()["data"].(map[string]any)
+		got, _ := data["event_id"].(string)
+		if extra, ok := data["extra"].(map[string]any); ok && got == "" {
+			got, _ = extra["event_id"].(string)
+		}
+		if got != want[i] {
+			t.Errorf("%s: event_id = %q, want %q", entry.Message, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mirror keeps a rolling window of the most recent PCAP files on local disk,
+// so that they can be inspected without downloading them from the destination.
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/naming"
+)
+
+type (
+	// Window bounds the mirrored PCAP files; `0` disables any of its limits.
+	Window struct {
+		// MaxAge is the age of the oldest mirrored PCAP file
+		MaxAge time.Duration
+		// MaxFiles is the number of PCAP files mirrored for each interface
+		MaxFiles int
+		// MaxBytes is the size of all mirrored PCAP files
+		MaxBytes int64
+	}
+
+	// Entry is a mirrored PCAP file.
+	Entry struct {
+		Name  string `json:"name"`
+		Iface string `json:"iface"`
+		Bytes int64  `json:"bytes"`
+		Age   string `json:"age"`
+
+		pcapFile *naming.PcapFile
+		modTime  time.Time
+	}
+
+	Mirror struct {
+		mu        sync.Mutex
+		directory string
+		window    Window
+		now       func() time.Time
+	}
+)
+
+// allows tests to simulate filesystems which do not support hard links
+var link = os.Link
+
+func NewMirror(
+	directory string,
+	window Window,
+	now func() time.Time,
+) (*Mirror, error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	return &Mirror{
+		directory: directory,
+		window:    window,
+		now:       now,
+	}, nil
+}
+
+func (m *Mirror) Directory() string {
+	return m.directory
+}
+
+// Add mirrors the PCAP file at `srcPcapFile` using its original name, and enforces the window;
+// it is hard-linked when possible, and copied otherwise. `linked` reports which one was used.
+func (m *Mirror) Add(
+	srcPcapFile string,
+) (linked bool, removed []string, err error) {
+	pcapFile, err := naming.ParseBaseName(srcPcapFile)
+	if err != nil {
+		return false, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// interface names are sanitized so that they cannot escape the mirror directory
+	tgtPcapFile := filepath.Join(m.directory, pcapFile.BaseName())
+	linked, err = mirror(srcPcapFile, tgtPcapFile)
+	if errors.Is(err, os.ErrExist) {
+		// a PCAP file whose export was deferred is mirrored again when it is flushed
+		err = nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	removed, err = m.enforce()
+	return linked, removed, err
+}
+
+func mirror(
+	srcPcapFile string,
+	tgtPcapFile string,
+) (bool, error) {
+	err := link(srcPcapFile, tgtPcapFile)
+	if err == nil || errors.Is(err, os.ErrExist) {
+		return err == nil, err
+	}
+
+	// hard links are not available across filesystems
+	src, err := os.Open(srcPcapFile)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	// temporary files are hidden: they are not PCAP files
+	tgt, err := os.CreateTemp(filepath.Dir(tgtPcapFile), ".mirror-*")
+	if err != nil {
+		return false, err
+	}
+	tmpPcapFile := tgt.Name()
+	if err = tgt.Chmod(0o644); err == nil {
+		_, err = io.Copy(tgt, src)
+	}
+	if closeErr := tgt.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// mirrored PCAP files are listed by name: only complete copies are visible
+		err = os.Rename(tmpPcapFile, tgtPcapFile)
+	}
+	if err != nil {
+		os.Remove(tmpPcapFile)
+	}
+	return false, err
+}
+
+// Enforce removes the mirrored PCAP files which are outside of the window.
+func (m *Mirror) Enforce() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enforce()
+}
+
+func (m *Mirror) enforce() ([]string, error) {
+	entries, err := m.list()
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	perIface := make(map[string]int)
+	var totalBytes int64
+	var removed []string
+
+	// newest first: the oldest PCAP files are the first to go
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		key := entry.pcapFile.Key()
+		switch {
+		case m.window.MaxAge > 0 && now.Sub(entry.modTime) > m.window.MaxAge,
+			m.window.MaxFiles > 0 && perIface[key] >= m.window.MaxFiles,
+			m.window.MaxBytes > 0 && totalBytes+entry.Bytes > m.window.MaxBytes:
+			if err := os.Remove(filepath.Join(m.directory, entry.Name)); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+			removed = append(removed, entry.Name)
+		default:
+			perIface[key] += 1
+			totalBytes += entry.Bytes
+		}
+	}
+
+	return removed, nil
+}
+
+// List returns the mirrored PCAP files, oldest first.
+func (m *Mirror) List() ([]*Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.list()
+}
+
+func (m *Mirror) list() ([]*Entry, error) {
+	dirEntries, err := os.ReadDir(m.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	entries := make([]*Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		// files which are not PCAP files are never mirrored, so they are never removed
+		pcapFile, err := naming.ParseBaseName(dirEntry.Name())
+		if err != nil || !dirEntry.Type().IsRegular() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, &Entry{
+			Name:     dirEntry.Name(),
+			Iface:    pcapFile.IfaceID(),
+			Bytes:    info.Size(),
+			Age:      now.Sub(info.ModTime()).Round(time.Second).String(),
+			pcapFile: pcapFile,
+			modTime:  info.ModTime(),
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].modTime.Equal(entries[j].modTime) {
+			return entries[i].modTime.Before(entries[j].modTime)
+		}
+		return entries[i].pcapFile.Timestamp < entries[j].pcapFile.Timestamp
+	})
+	return entries, nil
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("age=%s,files=%d,bytes=%d", w.MaxAge, w.MaxFiles, w.MaxBytes)
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/naming"
+)
+
+var now = time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+
+// newPcapFile creates a PCAP file rotated `age` ago.
+func newPcapFile(t *testing.T, dir string, iface string, age time.Duration, size int) string {
+	t.Helper()
+	ts := now.Add(-age)
+	path := filepath.Join(dir, fmt.Sprintf("part__1_%s__%s.pcap", iface, ts.Format(naming.TimestampLayout)))
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, ts, ts); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func names(t *testing.T, m *Mirror) []string {
+	t.Helper()
+	entries, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// TestWindow verifies that the window is enforced by age, by number of files per interface, and by size.
+func TestWindow(t *testing.T) {
+	files := []struct {
+		iface string
+		age   time.Duration
+	}{
+		{"eth0", 20 * time.Minute},
+		{"eth1", 20 * time.Minute},
+		{"eth0", 10 * time.Minute},
+		{"eth1", 10 * time.Minute},
+		{"eth0", 1 * time.Minute},
+	}
+
+	tests := []struct {
+		name   string
+		window Window
+		// indexes of the PCAP files which remain mirrored
+		want []int
+	}{
+		{"unbounded", Window{}, []int{0, 1, 2, 3, 4}},
+		{"age", Window{MaxAge: 15 * time.Minute}, []int{2, 3, 4}},
+		{"files per interface", Window{MaxFiles: 1}, []int{3, 4}},
+		{"bytes", Window{MaxBytes: 250}, []int{3, 4}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srcDir, mirrorDir := t.TempDir(), t.TempDir()
+			m, err := NewMirror(mirrorDir, tc.window, func() time.Time { return now })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			added := []string{}
+			for _, file := range files {
+				path := newPcapFile(t, srcDir, file.iface, file.age, 100)
+				if _, _, err := m.Add(path); err != nil {
+					t.Fatal(err)
+				}
+				added = append(added, filepath.Base(path))
+			}
+
+			want := []string{}
+			for _, i := range tc.want {
+				want = append(want, added[i])
+			}
+			if got := names(t, m); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestEnforceOnTick verifies that PCAP files leave the window as time passes, even if no PCAP file is added.
+func TestEnforceOnTick(t *testing.T) {
+	srcDir, mirrorDir := t.TempDir(), t.TempDir()
+	clock := now
+	m, _ := NewMirror(mirrorDir, Window{MaxAge: 15 * time.Minute}, func() time.Time { return clock })
+
+	path := newPcapFile(t, srcDir, "eth0", 10*time.Minute, 100)
+	if _, removed, err := m.Add(path); err != nil || len(removed) != 0 {
+		t.Fatalf("Add: removed %v, %v", removed, err)
+	}
+
+	clock = clock.Add(6 * time.Minute)
+	removed, err := m.Enforce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Base(path)}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("Enforce: got %v, want %v", removed, want)
+	}
+}
+
+// TestAddLinksOrCopies verifies that PCAP files are hard-linked, and copied when hard links are not available.
+func TestAddLinksOrCopies(t *testing.T) {
+	tests := []struct {
+		name       string
+		link       func(string, string) error
+		wantLinked bool
+	}{
+		{"hard link", os.Link, true},
+		{"cross-device copy", func(oldname, newname string) error {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+		}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func(original func(string, string) error) {
+				link = original
+			}(link)
+			link = tc.link
+
+			srcDir, mirrorDir := t.TempDir(), t.TempDir()
+			m, _ := NewMirror(mirrorDir, Window{}, func() time.Time { return now })
+			path := newPcapFile(t, srcDir, "eth0", time.Minute, 100)
+
+			linked, _, err := m.Add(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked != tc.wantLinked {
+				t.Errorf("linked: got %v, want %v", linked, tc.wantLinked)
+			}
+
+			srcInfo, _ := os.Stat(path)
+			tgtInfo, err := os.Stat(filepath.Join(mirrorDir, filepath.Base(path)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(srcInfo, tgtInfo) != tc.wantLinked {
+				t.Errorf("same file: got %v, want %v", os.SameFile(srcInfo, tgtInfo), tc.wantLinked)
+			}
+			content, _ := os.ReadFile(filepath.Join(mirrorDir, filepath.Base(path)))
+			if !bytes.Equal(content, make([]byte, 100)) {
+				t.Errorf("mirrored content does not match")
+			}
+
+			// the mirror outlives the source PCAP file, and mirroring again is not an error
+			if _, _, err := m.Add(path); err != nil {
+				t.Errorf("Add again: %v", err)
+			}
+			os.Remove(path)
+			if got := names(t, m); len(got) != 1 {
+				t.Errorf("got %v after removing the source PCAP file", got)
+			}
+		})
+	}
+}
+
+// TestExcludedFromBookkeeping verifies that files which are not PCAP files are never listed nor removed,
+// and that mirrored PCAP files are not matched as new PCAP files when the mirror is within the source directory.
+func TestExcludedFromBookkeeping(t *testing.T) {
+	srcDir := t.TempDir()
+	mirrorDir := filepath.Join(srcDir, "mirror")
+	m, err := NewMirror(mirrorDir, Window{MaxFiles: 1}, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	others := []string{"config.json", ".mirror-123", "notes.txt"}
+	for _, other := range others {
+		if err := os.WriteFile(filepath.Join(mirrorDir, other), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(mirrorDir, "part__1_eth0__20240101T000000.pcap"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := newPcapFile(t, srcDir, "eth0", time.Minute, 100)
+	if _, _, err := m.Add(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(t, m), []string{filepath.Base(path)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, other := range others {
+		if _, err := os.Stat(filepath.Join(mirrorDir, other)); err != nil {
+			t.Errorf("%s was removed: %v", other, err)
+		}
+	}
+
+	matcher := naming.NewMatcher(srcDir, []string{"pcap"})
+	if mirrored := filepath.Join(mirrorDir, filepath.Base(path)); matcher.MatchString(mirrored) {
+		t.Errorf("mirrored PCAP file matched as a new PCAP file: %s", mirrored)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mount detects when a directory disappears or is remounted,
+// i.e.: when `gcsfuse` is restarted the destination directory identity changes.
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"syscall"
+)
+
+type (
+	Event string
+
+	identity struct {
+		dev uint64
+		ino uint64
+	}
+
+	Monitor struct {
+		mu        sync.Mutex
+		directory string
+		present   bool
+		id        identity
+	}
+)
+
+const (
+	EVENT_NONE      = Event("none")
+	EVENT_GONE      = Event("gone")
+	EVENT_BACK      = Event("back")
+	EVENT_REMOUNTED = Event("remounted")
+)
+
+var errNotDirectory = errors.New("not a directory")
+
+// allows tests to simulate remounts
+var statDirectory = func(directory string) (identity, error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return identity{}, err
+	}
+	if !info.IsDir() {
+		return identity{}, fmt.Errorf("%w: %s", errNotDirectory, directory)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return identity{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, nil
+	}
+	return identity{}, nil
+}
+
+func NewMonitor(directory string) *Monitor {
+	m := &Monitor{directory: directory}
+	m.id, m.present = m.stat()
+	return m
+}
+
+func (m *Monitor) stat() (identity, bool) {
+	id, err := statDirectory(m.directory)
+	return id, err == nil
+}
+
+func (m *Monitor) Directory() string {
+	return m.directory
+}
+
+func (m *Monitor) Present() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.present
+}
+
+// Check returns the transition observed since the previous check.
+func (m *Monitor) Check() (Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, err := statDirectory(m.directory)
+	present := err == nil
+
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNotDirectory) {
+		err = nil
+	} else if err != nil {
+		// i.e.: `ENOTCONN` when the FUSE daemon is gone but the mount point remains
+		err = fmt.Errorf("failed to stat %s: %w", m.directory, err)
+	}
+
+	wasPresent := m.present
+	previousID := m.id
+
+	m.present = present
+	if present {
+		m.id = id
+	}
+
+	switch {
+	case wasPresent && !present:
+		return EVENT_GONE, err
+	case !wasPresent && present && previousID != (identity{}) && id != previousID:
+		return EVENT_REMOUNTED, nil
+	case !wasPresent && present:
+		return EVENT_BACK, nil
+	case present && id != previousID:
+		return EVENT_REMOUNTED, nil
+	}
+	return EVENT_NONE, err
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mount
+
+import (
+	"io/fs"
+	"testing"
+)
+
+// TestMonitorTransitions verifies the events reported while a directory disappears and gets remounted.
+func TestMonitorTransitions(t *testing.T) {
+	defer func(original func(string) (identity, error)) {
+		statDirectory = original
+	}(statDirectory)
+
+	var current *identity
+	statDirectory = func(string) (identity, error) {
+		if current == nil {
+			return identity{}, fs.ErrNotExist
+		}
+		return *current, nil
+	}
+
+	mounted := &identity{dev: 1, ino: 10}
+	remounted := &identity{dev: 2, ino: 10}
+
+	current = mounted
+	m := NewMonitor("/pcap")
+
+	steps := []struct {
+		name string
+		id   *identity
+		want Event
+	}{
+		{"unchanged", mounted, EVENT_NONE},
+		{"gone", nil, EVENT_GONE},
+		{"still gone", nil, EVENT_NONE},
+		{"back", mounted, EVENT_BACK},
+		{"remounted in place", remounted, EVENT_REMOUNTED},
+		{"gone again", nil, EVENT_GONE},
+		{"back remounted", mounted, EVENT_REMOUNTED},
+	}
+
+	for _, step := range steps {
+		current = step.id
+		if got, err := m.Check(); err != nil || got != step.want {
+			t.Errorf("%s: got (%s, %v), want %s", step.name, got, err, step.want)
+		}
+		if m.Present() != (step.id != nil) {
+			t.Errorf("%s: unexpected presence", step.name)
+		}
+	}
+}
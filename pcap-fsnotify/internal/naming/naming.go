@@ -0,0 +1,379 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// PcapFile is a PCAP file name as produced by `tcpdumpw`:
+	//   - `part__${IFACE_INDEX}_${IFACE_NAME}__${YYYYmmddTHHMMSS}.${EXT}`
+	PcapFile struct {
+		Path      string
+		Index     string
+		Iface     string // raw interface name; only for logging
+		SafeIface string
+		Timestamp string
+		Ext       string
+	}
+
+	// Matcher detects PCAP files by name; its extensions may be reloaded while PCAP files are being detected.
+	Matcher struct {
+		mu     sync.RWMutex
+		srcDir string
+		exts   []string
+		regexp *regexp.Regexp
+		// retired are the extensions of previous reloads: their PCAP files are no longer detected, but are still parsed so that they drain
+		retired []string
+		drain   *regexp.Regexp
+	}
+
+	// SanitizeMode defines which bytes of destination file names are percent-encoded.
+	SanitizeMode string
+
+	// KeyTemplate defines which parts of PCAP file names group them into the PCAP files of a single `tcpdump` instance:
+	// `{index}` is the interface sub-identifier, `{iface}` the sanitized interface name, and `{ext}` the extension.
+	KeyTemplate string
+)
+
+const (
+	// layout of `${YYYYmmddTHHMMSS}`; rotation timestamps are local to the capture timezone
+	TimestampLayout = "20060102T150405"
+
+	pcapFileNameTemplate = `part__(\d+?)_(.+?)__(\d{8}T\d{6})\.({0})`
+	pcapFileNameFormat   = "part__%s_%s__%s.%s"
+)
+
+const (
+	// only bytes which could escape the destination directory are encoded
+	SANITIZE_OFF = SanitizeMode("off")
+	// bytes outside of `[A-Za-z0-9._-]` are encoded
+	SANITIZE_SAFE = SanitizeMode("safe")
+	// bytes outside of `[a-z0-9._-]` are encoded: names are also safe for case-insensitive filesystems
+	SANITIZE_STRICT = SanitizeMode("strict")
+)
+
+const (
+	// every interface sub-identifier is a distinct `tcpdump` instance
+	DefaultKeyTemplate = KeyTemplate("{index}/{iface}/{ext}")
+
+	keyIndex = "{index}"
+	keyIface = "{iface}"
+	keyExt   = "{ext}"
+)
+
+var (
+	ErrNoMatch    = errors.New("not a PCAP file")
+	ErrUnsafeName = errors.New("unsafe PCAP file interface name")
+
+	baseNameRegexp = newRegexp("", `[^/]+?`)
+
+	// destination file names are sanitized using this mode; it must be set before exporting PCAP files
+	destinationSanitizeMode = SANITIZE_SAFE
+
+	// PCAP files are grouped using this template; it must be set before detecting PCAP files
+	keyTemplate = DefaultKeyTemplate
+
+	keyPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+)
+
+func newRegexp(
+	srcDir string,
+	exts string,
+) *regexp.Regexp {
+	pattern := strings.Replace(pcapFileNameTemplate, "{0}", exts, 1)
+	if srcDir == "" {
+		return regexp.MustCompile(`^` + pattern + `$`)
+	}
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(srcDir) + `/` + pattern + `$`)
+}
+
+func newExtsRegexp(
+	srcDir string,
+	exts []string,
+) *regexp.Regexp {
+	quotedExts := make([]string, len(exts))
+	for i, ext := range exts {
+		quotedExts[i] = regexp.QuoteMeta(ext)
+	}
+	return newRegexp(srcDir, strings.Join(quotedExts, "|"))
+}
+
+func NewMatcher(
+	srcDir string,
+	exts []string,
+) *Matcher {
+	pattern := newExtsRegexp(srcDir, exts)
+	return &Matcher{
+		srcDir: srcDir,
+		exts:   exts,
+		regexp: pattern,
+		drain:  pattern,
+	}
+}
+
+// Reload replaces the extensions of detected PCAP files; PCAP files of the previous extensions are no longer detected,
+// but they are still parsed, so that those which were already detected are exported.
+func (m *Matcher) Reload(
+	exts []string,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ext := range m.exts {
+		if !slices.Contains(exts, ext) && !slices.Contains(m.retired, ext) {
+			m.retired = append(m.retired, ext)
+		}
+	}
+	// an extension may be detected again
+	m.retired = slices.DeleteFunc(m.retired, func(ext string) bool {
+		return slices.Contains(exts, ext)
+	})
+	m.exts = exts
+	m.regexp = newExtsRegexp(m.srcDir, exts)
+	m.drain = newExtsRegexp(m.srcDir, slices.Concat(exts, m.retired))
+}
+
+// Extensions returns the extensions of detected PCAP files.
+func (m *Matcher) Extensions() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.exts)
+}
+
+func (m *Matcher) String() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.regexp.String()
+}
+
+// MatchString reports whether `path` is a PCAP file of the current extensions.
+func (m *Matcher) MatchString(path string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.regexp.MatchString(path)
+}
+
+// Parse parses `path` as a PCAP file of the current extensions, or of the extensions of previous reloads.
+func (m *Matcher) Parse(
+	path string,
+) (*PcapFile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return parse(m.drain, path)
+}
+
+func ParseSanitizeMode(
+	mode string,
+) (SanitizeMode, error) {
+	switch m := SanitizeMode(strings.ToLower(mode)); m {
+	case SANITIZE_OFF, SANITIZE_SAFE, SANITIZE_STRICT:
+		return m, nil
+	default:
+		return SANITIZE_SAFE, fmt.Errorf("invalid sanitize mode: %s", mode)
+	}
+}
+
+// ParseKeyTemplate validates `template`: it must include `{iface}` and `{ext}`, as grouping the PCAP files of different
+// interfaces or extensions together would export them out of order; `{index}` is optional.
+func ParseKeyTemplate(
+	template string,
+) (KeyTemplate, error) {
+	placeholders := keyPlaceholderRegexp.FindAllString(template, -1)
+	for _, placeholder := range placeholders {
+		if placeholder != keyIndex && placeholder != keyIface && placeholder != keyExt {
+			return DefaultKeyTemplate, fmt.Errorf("invalid key template: unknown placeholder %s: %s", placeholder, template)
+		}
+	}
+	if !slices.Contains(placeholders, keyIface) || !slices.Contains(placeholders, keyExt) {
+		return DefaultKeyTemplate, fmt.Errorf("invalid key template: %s and %s are required: %s", keyIface, keyExt, template)
+	}
+	return KeyTemplate(template), nil
+}
+
+// SetKeyTemplate sets how PCAP files are grouped into the PCAP files of a single `tcpdump` instance.
+func SetKeyTemplate(template KeyTemplate) {
+	keyTemplate = template
+}
+
+// SetSanitizeMode sets how interface names are sanitized in destination file names.
+func SetSanitizeMode(mode SanitizeMode) {
+	destinationSanitizeMode = mode
+}
+
+func isSafeByte(mode SanitizeMode, b byte) bool {
+	switch mode {
+	case SANITIZE_OFF:
+		return b != '/' && b != '\\' && b != 0 && b != '%'
+	case SANITIZE_STRICT:
+		return (b >= 'a' && b <= 'z') ||
+			(b >= '0' && b <= '9') ||
+			b == '.' || b == '_' || b == '-'
+	default:
+		return (b >= 'a' && b <= 'z') ||
+			(b >= 'A' && b <= 'Z') ||
+			(b >= '0' && b <= '9') ||
+			b == '.' || b == '_' || b == '-'
+	}
+}
+
+// Sanitize percent-encodes every byte of `name` outside of `[A-Za-z0-9._-]`;
+// `%` itself is encoded so the original name can always be recovered.
+func Sanitize(name string) string {
+	return SanitizeWith(SANITIZE_SAFE, name)
+}
+
+// SanitizeWith percent-encodes every byte of `name` which is not safe for `mode`.
+func SanitizeWith(mode SanitizeMode, name string) string {
+	// `.` and `..` are safe bytes, but not safe path elements
+	dotsOnly := strings.Trim(name, ".") == ""
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if b := name[i]; isSafeByte(mode, b) && !dotsOnly {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// isUnsafe reports whether the interface name could be used to escape the destination directory;
+// percent-encoded names are decoded before checking as other tools may decode them.
+func isUnsafe(iface string) bool {
+	names := []string{iface}
+	if decoded, err := url.PathUnescape(iface); err == nil {
+		names = append(names, decoded)
+	}
+	for _, name := range names {
+		if strings.ContainsAny(name, `/\`) ||
+			strings.Contains(name, "..") ||
+			strings.ContainsRune(name, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func parse(
+	r *regexp.Regexp,
+	path string,
+) (*PcapFile, error) {
+	rMatch := r.FindStringSubmatch(path)
+	if len(rMatch) < 5 {
+		return nil, ErrNoMatch
+	}
+	if isUnsafe(rMatch[2]) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsafeName, rMatch[2])
+	}
+	return &PcapFile{
+		Path:      path,
+		Index:     rMatch[1],
+		Iface:     rMatch[2],
+		SafeIface: Sanitize(rMatch[2]),
+		Timestamp: rMatch[3],
+		Ext:       rMatch[4],
+	}, nil
+}
+
+// Key groups all PCAP files created by the same `tcpdump` instance, according to the key template.
+func (f *PcapFile) Key() string {
+	return strings.NewReplacer(keyIndex, f.Index, keyIface, f.SafeIface, keyExt, f.Ext).Replace(string(keyTemplate))
+}
+
+// IfaceID is the human readable interface identifier: `${IFACE_INDEX}:${IFACE_NAME}`
+func (f *PcapFile) IfaceID() string {
+	return fmt.Sprintf("%s:%s", f.Index, f.Iface)
+}
+
+// BaseName is the file name to be used at the destination.
+func (f *PcapFile) BaseName() string {
+	return fmt.Sprintf(pcapFileNameFormat, f.Index, SanitizeWith(destinationSanitizeMode, f.Iface), f.Timestamp, f.Ext)
+}
+
+// ParseBaseName parses the base name of `path`, regardless of its directory and extension.
+func ParseBaseName(path string) (*PcapFile, error) {
+	return parse(baseNameRegexp, filepath.Base(path))
+}
+
+// Capture identifies the capture regardless of its extension: `${IFACE_INDEX}_${IFACE_NAME}__${YYYYmmddTHHMMSS}`;
+// files derived from the same capture share it.
+func (f *PcapFile) Capture() string {
+	return fmt.Sprintf("%s_%s__%s", f.Index, f.SafeIface, f.Timestamp)
+}
+
+// SafeBaseName returns the destination file name for the source file at `path`;
+// names which are not PCAP files are sanitized as a whole.
+func SafeBaseName(path string) string {
+	if pcapFile, err := ParseBaseName(path); err == nil {
+		return pcapFile.BaseName()
+	}
+	return SanitizeWith(destinationSanitizeMode, filepath.Base(path))
+}
+
+// Time returns the rotation timestamp of the PCAP file as if it was UTC;
+// it is only meaningful when compared with other PCAP files.
+func (f *PcapFile) Time() (time.Time, error) {
+	return f.TimeIn(time.UTC)
+}
+
+// TimeIn returns the rotation timestamp of the PCAP file in the capture timezone.
+func (f *PcapFile) TimeIn(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(TimestampLayout, f.Timestamp, loc)
+}
+
+// SortByRotation sorts PCAP files by key, and then by rotation timestamp;
+// interface indexes are not zero-padded, so they are compared numerically.
+func SortByRotation(files []*PcapFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Index != b.Index {
+			aIndex, aErr := strconv.Atoi(a.Index)
+			bIndex, bErr := strconv.Atoi(b.Index)
+			if aErr == nil && bErr == nil {
+				return aIndex < bIndex
+			}
+			return a.Index < b.Index
+		}
+		if a.SafeIface != b.SafeIface {
+			return a.SafeIface < b.SafeIface
+		}
+		if a.Ext != b.Ext {
+			return a.Ext < b.Ext
+		}
+		return CompareRotation(a, b) < 0
+	})
+}
+
+// CompareRotation compares the rotation timestamps of PCAP files, which are the ordinals of the PCAP files of a key;
+// it returns -1 if `a` was rotated before `b`, 0 if both were rotated at the same time, and +1 otherwise.
+func CompareRotation(a, b *PcapFile) int {
+	aTime, aErr := a.Time()
+	bTime, bErr := b.Time()
+	if aErr == nil && bErr == nil {
+		return aTime.Compare(bTime)
+	}
+	return strings.Compare(a.Timestamp, b.Timestamp)
+}
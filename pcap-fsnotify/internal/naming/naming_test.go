@@ -0,0 +1,335 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testSrcDir = "/pcap-tmp"
+	testTgtDir = "/pcap"
+)
+
+var testExts = []string{"pcap", "json"}
+
+// TestParse verifies that PCAP file names are parsed into their components and keys.
+func TestParse(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		path      string
+		wantKey   string
+		wantIface string
+		wantBase  string
+	}{
+		{
+			name:      "plain_iface",
+			path:      "/pcap-tmp/part__2_eth0__20240101T000000.pcap",
+			wantKey:   "2/eth0/pcap",
+			wantIface: "2:eth0",
+			wantBase:  "part__2_eth0__20240101T000000.pcap",
+		},
+		{
+			name:      "space_in_iface",
+			path:      "/pcap-tmp/part__3_my nic__20240101T000000.json",
+			wantKey:   "3/my%20nic/json",
+			wantIface: "3:my nic",
+			wantBase:  "part__3_my%20nic__20240101T000000.json",
+		},
+		{
+			name:      "utf8_iface",
+			path:      "/pcap-tmp/part__4_ñic__20240101T000000.pcap",
+			wantKey:   "4/%C3%B1ic/pcap",
+			wantIface: "4:ñic",
+			wantBase:  "part__4_%C3%B1ic__20240101T000000.pcap",
+		},
+		{
+			name:      "percent_in_iface",
+			path:      "/pcap-tmp/part__5_a%b__20240101T000000.pcap",
+			wantKey:   "5/a%25b/pcap",
+			wantIface: "5:a%b",
+			wantBase:  "part__5_a%25b__20240101T000000.pcap",
+		},
+	}
+
+	m := NewMatcher(testSrcDir, testExts)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			pcapFile, err := m.Parse(tc.path)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tc.path, err)
+			}
+			if got := pcapFile.Key(); got != tc.wantKey {
+				t.Errorf("Key() = %q, want %q", got, tc.wantKey)
+			}
+			if got := pcapFile.IfaceID(); got != tc.wantIface {
+				t.Errorf("IfaceID() = %q, want %q", got, tc.wantIface)
+			}
+			if got := pcapFile.BaseName(); got != tc.wantBase {
+				t.Errorf("BaseName() = %q, want %q", got, tc.wantBase)
+			}
+			if got := SafeBaseName(tc.path); got != tc.wantBase {
+				t.Errorf("SafeBaseName() = %q, want %q", got, tc.wantBase)
+			}
+		})
+	}
+}
+
+// TestParseRejectsTraversal verifies that interface names which could escape the destination directory are rejected.
+func TestParseRejectsTraversal(t *testing.T) {
+	t.Parallel()
+	paths := []string{
+		"/pcap-tmp/part__1_../../etc__20240101T000000.pcap",
+		"/pcap-tmp/part__1_eth0/../../x__20240101T000000.pcap",
+		"/pcap-tmp/part__1_..__20240101T000000.pcap",
+		"/pcap-tmp/part__1_%2E%2E%2Fx__20240101T000000.pcap",
+		"/pcap-tmp/part__1_%2e%2e__20240101T000000.pcap",
+		"/pcap-tmp/part__1_a%5Cb__20240101T000000.pcap",
+		"/pcap-tmp/part__1_a\\b__20240101T000000.pcap",
+	}
+
+	m := NewMatcher(testSrcDir, testExts)
+	for _, path := range paths {
+		if _, err := m.Parse(path); !errors.Is(err, ErrUnsafeName) {
+			t.Errorf("Parse(%q) = %v, want %v", path, err, ErrUnsafeName)
+		}
+	}
+}
+
+// TestSanitizeIsReversible verifies that sanitized names can be decoded back into the original ones.
+func TestSanitizeIsReversible(t *testing.T) {
+	t.Parallel()
+	for _, name := range []string{"eth0", "my nic", "ñic", "a%b", "a:b", ".", ".."} {
+		sanitized := Sanitize(name)
+		if sanitized == "." || sanitized == ".." || strings.ContainsAny(sanitized, `/\ `) {
+			t.Errorf("Sanitize(%q) = %q is not safe", name, sanitized)
+		}
+		if decoded, err := url.PathUnescape(sanitized); err != nil || decoded != name {
+			t.Errorf("PathUnescape(%q) = %q, %v; want %q", sanitized, decoded, err, name)
+		}
+	}
+}
+
+// TestSanitizeModes verifies that colon-containing interface names round-trip in every mode.
+func TestSanitizeModes(t *testing.T) {
+	tests := []struct {
+		mode SanitizeMode
+		name string
+		want string
+	}{
+		{SANITIZE_OFF, "eth0:1", "eth0:1"},
+		{SANITIZE_OFF, "a%b", "a%25b"},
+		{SANITIZE_OFF, "..", "%2E%2E"},
+		{SANITIZE_SAFE, "eth0:1", "eth0%3A1"},
+		{SANITIZE_SAFE, "Eth0:1", "Eth0%3A1"},
+		{SANITIZE_STRICT, "eth0:1", "eth0%3A1"},
+		{SANITIZE_STRICT, "Eth0:1", "%45th0%3A1"},
+	}
+
+	for _, tc := range tests {
+		sanitized := SanitizeWith(tc.mode, tc.name)
+		if sanitized != tc.want {
+			t.Errorf("SanitizeWith(%s, %q) = %q, want %q", tc.mode, tc.name, sanitized, tc.want)
+		}
+		if decoded, err := url.PathUnescape(sanitized); err != nil || decoded != tc.name {
+			t.Errorf("PathUnescape(%q) = %q, %v; want %q", sanitized, decoded, err, tc.name)
+		}
+	}
+
+	if _, err := ParseSanitizeMode("STRICT"); err != nil {
+		t.Errorf("ParseSanitizeMode(STRICT) failed: %v", err)
+	}
+	if mode, err := ParseSanitizeMode("bogus"); err == nil || mode != SANITIZE_SAFE {
+		t.Errorf("ParseSanitizeMode(bogus) = %s, %v; want %s and error", mode, err, SANITIZE_SAFE)
+	}
+}
+
+// TestBaseNameSanitizeMode verifies that destination file names follow the configured mode.
+func TestBaseNameSanitizeMode(t *testing.T) {
+	defer SetSanitizeMode(SANITIZE_SAFE)
+
+	path := "/pcap-tmp/part__3_Eth0:1__20240101T000000.pcap"
+	for mode, want := range map[SanitizeMode]string{
+		SANITIZE_OFF:    "part__3_Eth0:1__20240101T000000.pcap",
+		SANITIZE_SAFE:   "part__3_Eth0%3A1__20240101T000000.pcap",
+		SANITIZE_STRICT: "part__3_%45th0%3A1__20240101T000000.pcap",
+	} {
+		SetSanitizeMode(mode)
+		if got := SafeBaseName(path); got != want {
+			t.Errorf("SafeBaseName(%q) in mode %s = %q, want %q", path, mode, got, want)
+		}
+	}
+}
+
+// TestKeyTemplate verifies that PCAP files of different interface sub-identifiers are grouped separately by default,
+// and together when the sub-identifier is not part of the key template.
+func TestKeyTemplate(t *testing.T) {
+	defer SetKeyTemplate(DefaultKeyTemplate)
+
+	m := NewMatcher(testSrcDir, testExts)
+	parse := func(name string) *PcapFile {
+		pcapFile, err := m.Parse(testSrcDir + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pcapFile
+	}
+	a := parse("part__1_eth0__20240101T000000.pcap")
+	b := parse("part__2_eth0__20240101T000100.pcap")
+	c := parse("part__2_eth0__20240101T000100.json")
+
+	tests := []struct {
+		template    string
+		wantKey     string
+		wantGrouped bool
+	}{
+		{string(DefaultKeyTemplate), "1/eth0/pcap", false},
+		{"{iface}/{ext}", "eth0/pcap", true},
+		{"{iface}:{index}.{ext}", "eth0:1.pcap", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.template, func(t *testing.T) {
+			template, err := ParseKeyTemplate(tc.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetKeyTemplate(template)
+			if got := a.Key(); got != tc.wantKey {
+				t.Errorf("Key() = %q, want %q", got, tc.wantKey)
+			}
+			if grouped := a.Key() == b.Key(); grouped != tc.wantGrouped {
+				t.Errorf("%s and %s grouped: %v, want %v", a.Key(), b.Key(), grouped, tc.wantGrouped)
+			}
+			if b.Key() == c.Key() {
+				t.Errorf("extensions are grouped together: %s", b.Key())
+			}
+		})
+	}
+
+	for _, template := range []string{"{index}/{iface}", "{index}/{ext}", "{iface}/{ext}/{timestamp}"} {
+		if _, err := ParseKeyTemplate(template); err == nil {
+			t.Errorf("ParseKeyTemplate(%s) succeeded", template)
+		}
+	}
+}
+
+func assertWithinTarget(
+	t *testing.T,
+	baseName string,
+) {
+	target := filepath.Join(testTgtDir, baseName)
+	if filepath.Dir(target) != testTgtDir {
+		t.Fatalf("destination %q escapes %q", target, testTgtDir)
+	}
+}
+
+// FuzzParse verifies that no source file name can produce a destination path outside of the destination directory.
+func FuzzParse(f *testing.F) {
+	f.Add("part__2_eth0__20240101T000000.pcap")
+	f.Add("part__1_../../etc__20240101T000000.pcap")
+
+	m := NewMatcher(testSrcDir, testExts)
+	f.Fuzz(func(t *testing.T, name string) {
+		path := testSrcDir + "/" + name
+		if pcapFile, err := m.Parse(path); err == nil {
+			assertWithinTarget(t, pcapFile.BaseName())
+		}
+		assertWithinTarget(t, SafeBaseName(path))
+	})
+}
+
+// TestSortByRotation verifies that unpadded interface indexes are sorted numerically,
+// and that PCAP files for the same key are sorted by rotation timestamp.
+// TestMatcherReload verifies that reloaded extensions are detected, that PCAP files of retired extensions are no longer detected
+// but are still parsed so that they drain, and that reloading is safe while PCAP files are being detected.
+func TestMatcherReload(t *testing.T) {
+	m := NewMatcher(testSrcDir, []string{"pcap"})
+	oldFile := testSrcDir + "/part__1_eth0__20240101T000000.pcap"
+	newFile := testSrcDir + "/part__1_eth0__20240101T000000.pcapng"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.MatchString(oldFile)
+			m.Parse(newFile)
+		}
+	}()
+	m.Reload([]string{"json"})
+	m.Reload([]string{"pcapng", "json"})
+	wg.Wait()
+
+	if got := m.Extensions(); !slices.Equal(got, []string{"pcapng", "json"}) {
+		t.Errorf("Extensions() = %v, want [pcapng json]", got)
+	}
+	if !m.MatchString(newFile) {
+		t.Errorf("MatchString(%q) = false after reload", newFile)
+	}
+	if m.MatchString(oldFile) {
+		t.Errorf("MatchString(%q) = true after its extension was retired", oldFile)
+	}
+	if pcapFile, err := m.Parse(oldFile); err != nil || pcapFile.Ext != "pcap" {
+		t.Errorf("Parse(%q) = %v, %v; want it to drain", oldFile, pcapFile, err)
+	}
+
+	// a retired extension which is reloaded again is detected
+	m.Reload([]string{"pcap"})
+	if !m.MatchString(oldFile) || m.MatchString(newFile) {
+		t.Errorf("MatchString() does not follow the reloaded extensions: %s", m)
+	}
+	if _, err := m.Parse(newFile); err != nil {
+		t.Errorf("Parse(%q) failed: %v", newFile, err)
+	}
+}
+
+func TestSortByRotation(t *testing.T) {
+	m := NewMatcher(testSrcDir, testExts)
+
+	paths := []string{}
+	for index := 10; index >= 1; index-- {
+		paths = append(paths,
+			fmt.Sprintf("%s/part__%d_eth0__20240101T000100.pcap", testSrcDir, index),
+			fmt.Sprintf("%s/part__%d_eth0__20240101T000000.pcap", testSrcDir, index))
+	}
+
+	files := make([]*PcapFile, len(paths))
+	for i, path := range paths {
+		pcapFile, err := m.Parse(path)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", path, err)
+		}
+		files[i] = pcapFile
+	}
+
+	SortByRotation(files)
+
+	for i, pcapFile := range files {
+		wantIndex := fmt.Sprintf("%d", i/2+1)
+		wantTimestamp := []string{"20240101T000000", "20240101T000100"}[i%2]
+		if pcapFile.Index != wantIndex || pcapFile.Timestamp != wantTimestamp {
+			t.Errorf("files[%d] = %s, want index %s at %s", i, filepath.Base(pcapFile.Path), wantIndex, wantTimestamp)
+		}
+	}
+}
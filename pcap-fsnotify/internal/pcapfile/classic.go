@@ -0,0 +1,142 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcapfile
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+type (
+	classicWriter struct {
+		w     io.Writer
+		opts  WriterOptions
+		order binary.ByteOrder
+	}
+
+	classicReader struct {
+		r          io.Reader
+		order      binary.ByteOrder
+		nanosecond bool
+		snaplen    uint32
+		linkType   LinkType
+	}
+)
+
+// NewWriter writes the global header of a classic PCAP file into `w`.
+func NewWriter(w io.Writer, opts WriterOptions) (Writer, error) {
+	opts = opts.withDefaults()
+
+	magic := magicMicroseconds
+	if opts.Nanosecond {
+		magic = magicNanoseconds
+	}
+
+	header := make([]byte, globalHeaderLength)
+	order := opts.ByteOrder
+	order.PutUint32(header[0:4], magic)
+	order.PutUint16(header[4:6], versionMajor)
+	order.PutUint16(header[6:8], versionMinor)
+	// `thiszone` and `sigfigs` are always `0`
+	order.PutUint32(header[16:20], opts.Snaplen)
+	order.PutUint32(header[20:24], uint32(opts.LinkType))
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &classicWriter{w: w, opts: opts, order: order}, nil
+}
+
+func (w *classicWriter) WritePacket(ts time.Time, data []byte, length int) error {
+	data, length = capture(data, length, w.opts.Snaplen)
+
+	fraction := uint32(ts.Nanosecond())
+	if !w.opts.Nanosecond {
+		fraction /= 1000
+	}
+
+	header := make([]byte, recordHeaderLength)
+	w.order.PutUint32(header[0:4], uint32(ts.Unix()))
+	w.order.PutUint32(header[4:8], fraction)
+	w.order.PutUint32(header[8:12], uint32(len(data)))
+	w.order.PutUint32(header[12:16], uint32(length))
+
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+func newClassicReader(r io.Reader) (*classicReader, error) {
+	header := make([]byte, globalHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, newInvalidFileError("short global header: %v", err)
+	}
+
+	reader := &classicReader{r: r}
+
+	var magic uint32
+	if magic = binary.LittleEndian.Uint32(header[0:4]); isClassicMagic(magic) {
+		reader.order = binary.LittleEndian
+	} else if magic = binary.BigEndian.Uint32(header[0:4]); isClassicMagic(magic) {
+		reader.order = binary.BigEndian
+	} else {
+		return nil, ErrUnknownFormat
+	}
+
+	reader.nanosecond = magic == magicNanoseconds
+	reader.snaplen = reader.order.Uint32(header[16:20])
+	reader.linkType = LinkType(reader.order.Uint32(header[20:24]))
+	return reader, nil
+}
+
+func (r *classicReader) LinkType() LinkType {
+	return r.linkType
+}
+
+func (r *classicReader) ReadPacket() (*Packet, error) {
+	header := make([]byte, recordHeaderLength)
+	if _, err := io.ReadFull(r.r, header); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, newInvalidFileError("short record header: %v", err)
+	}
+
+	seconds := int64(r.order.Uint32(header[0:4]))
+	fraction := int64(r.order.Uint32(header[4:8]))
+	if !r.nanosecond {
+		fraction *= 1000
+	}
+	capturedLength := r.order.Uint32(header[8:12])
+	length := r.order.Uint32(header[12:16])
+
+	// a corrupted record must not cause a huge allocation
+	if capturedLength > r.snaplen && capturedLength > DefaultSnaplen {
+		return nil, newInvalidFileError("captured length %d exceeds snaplen %d", capturedLength, r.snaplen)
+	}
+
+	data := make([]byte, capturedLength)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, newInvalidFileError("short packet data: %v", err)
+	}
+
+	return &Packet{
+		Timestamp: time.Unix(seconds, fraction).UTC(),
+		Length:    int(length),
+		Data:      data,
+	}, nil
+}
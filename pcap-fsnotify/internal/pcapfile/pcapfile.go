@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pcapfile reads and writes classic PCAP files and a minimal PCAPNG subset
+// ( SHB, IDB, and EPB blocks ), and synthesizes packets to build test fixtures and probe captures.
+package pcapfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/snaplen"
+)
+
+type (
+	LinkType uint32
+
+	Packet struct {
+		Timestamp time.Time
+		// Length is the length of the packet on the wire
+		Length int
+		// InterfaceIndex is always `0` for classic PCAP files
+		InterfaceIndex int
+		Data           []byte
+	}
+
+	Reader interface {
+		LinkType() LinkType
+		// ReadPacket returns `io.EOF` when there are no more packets
+		ReadPacket() (*Packet, error)
+	}
+
+	WriterOptions struct {
+		// ByteOrder defaults to `binary.LittleEndian`
+		ByteOrder  binary.ByteOrder
+		Nanosecond bool
+		// Snaplen defaults to `DefaultSnaplen`
+		Snaplen  uint32
+		LinkType LinkType
+	}
+
+	Writer interface {
+		// WritePacket truncates `data` to the snaplen; `length` is the length of the packet
+		// on the wire, and defaults to `len(data)` when lower.
+		WritePacket(ts time.Time, data []byte, length int) error
+	}
+)
+
+const (
+	LINKTYPE_NULL     = LinkType(0)
+	LINKTYPE_ETHERNET = LinkType(1)
+	LINKTYPE_RAW      = LinkType(101)
+
+	// DefaultSnaplen is the capture length used by `tcpdumpw` when none is configured
+	DefaultSnaplen = uint32(snaplen.Default)
+
+	magicMicroseconds = uint32(0xa1b2c3d4)
+	magicNanoseconds  = uint32(0xa1b23c4d)
+	magicPcapng       = uint32(0x0a0d0d0a)
+
+	versionMajor = uint16(2)
+	versionMinor = uint16(4)
+
+	globalHeaderLength = 24
+	recordHeaderLength = 16
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown PCAP file format")
+	ErrInvalidFile   = errors.New("invalid PCAP file")
+)
+
+func newInvalidFileError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFile, fmt.Sprintf(format, args...))
+}
+
+func (o *WriterOptions) withDefaults() WriterOptions {
+	opts := *o
+	if opts.ByteOrder == nil {
+		opts.ByteOrder = binary.LittleEndian
+	}
+	if opts.Snaplen == 0 {
+		opts.Snaplen = DefaultSnaplen
+	}
+	return opts
+}
+
+// NewReader detects whether `r` contains a classic PCAP or a PCAPNG file.
+func NewReader(r io.Reader) (Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, newInvalidFileError("missing header: %v", err)
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(magic) == magicPcapng:
+		return newPcapngReader(br)
+	case isClassicMagic(binary.LittleEndian.Uint32(magic)), isClassicMagic(binary.BigEndian.Uint32(magic)):
+		return newClassicReader(br)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func isClassicMagic(magic uint32) bool {
+	return magic == magicMicroseconds || magic == magicNanoseconds
+}
+
+// capture truncates `data` to `snaplen`, and returns the length of the packet on the wire.
+func capture(data []byte, length int, snaplen uint32) ([]byte, int) {
+	if length < len(data) {
+		length = len(data)
+	}
+	if uint32(len(data)) > snaplen {
+		data = data[:snaplen]
+	}
+	return data, length
+}
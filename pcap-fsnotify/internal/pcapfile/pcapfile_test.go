@@ -0,0 +1,233 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcapfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type newWriterFunc func(io.Writer, WriterOptions) (Writer, error)
+
+var (
+	goldenTimestampUsec = time.Unix(1700000000, 1000).UTC()
+	goldenTimestampNsec = time.Unix(1700000000, 7).UTC()
+	goldenPacket        = []byte{0xde, 0xad, 0xbe, 0xef}
+)
+
+// TestGoldenFiles verifies that the writers produce byte-exact files built from the format specifications,
+// and that the readers parse them back.
+func TestGoldenFiles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		file      string
+		newWriter newWriterFunc
+		order     binary.ByteOrder
+		nano      bool
+		ts        time.Time
+	}{
+		{"le_usec.pcap", NewWriter, binary.LittleEndian, false, goldenTimestampUsec},
+		{"be_nsec.pcap", NewWriter, binary.BigEndian, true, goldenTimestampNsec},
+		{"le_usec.pcapng", NewPcapngWriter, binary.LittleEndian, false, goldenTimestampUsec},
+		{"be_nsec.pcapng", NewPcapngWriter, binary.BigEndian, true, goldenTimestampNsec},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.file, func(t *testing.T) {
+			t.Parallel()
+
+			golden, err := os.ReadFile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			w, err := tc.newWriter(&buf, WriterOptions{
+				ByteOrder:  tc.order,
+				Nanosecond: tc.nano,
+				Snaplen:    65535,
+				LinkType:   LINKTYPE_ETHERNET,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WritePacket(tc.ts, goldenPacket, 0); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), golden) {
+				t.Errorf("written bytes do not match %s:\n got: %x\nwant: %x", tc.file, buf.Bytes(), golden)
+			}
+
+			r, err := NewReader(bytes.NewReader(golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.LinkType() != LINKTYPE_ETHERNET {
+				t.Errorf("link type: got %d", r.LinkType())
+			}
+			packet, err := r.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !packet.Timestamp.Equal(tc.ts) || packet.Length != 4 || !bytes.Equal(packet.Data, goldenPacket) {
+				t.Errorf("unexpected packet: %+v", packet)
+			}
+			if _, err := r.ReadPacket(); err != io.EOF {
+				t.Errorf("expected EOF, got %v", err)
+			}
+		})
+	}
+}
+
+// TestRoundTrip verifies that synthesized packets survive writing and reading, including truncation to snaplen.
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	specs := []PacketSpec{
+		{
+			FiveTuple: FiveTuple{
+				Src: netip.MustParseAddr("10.0.0.1"), Dst: netip.MustParseAddr("10.0.0.2"),
+				SrcPort: 40000, DstPort: 443, Transport: TRANSPORT_TCP,
+			},
+			TCPFlags: TCP_SYN | TCP_ACK, Seq: 1, Ack: 2, PayloadSize: 100,
+		},
+		{
+			FiveTuple: FiveTuple{
+				Src: netip.MustParseAddr("fd00::1"), Dst: netip.MustParseAddr("fd00::2"),
+				SrcPort: 5353, DstPort: 53, Transport: TRANSPORT_UDP,
+			},
+			PayloadSize: 1001,
+		},
+	}
+
+	for _, newWriter := range []newWriterFunc{NewWriter, NewPcapngWriter} {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			for _, nano := range []bool{false, true} {
+				var buf bytes.Buffer
+				w, err := newWriter(&buf, WriterOptions{ByteOrder: order, Nanosecond: nano, Snaplen: 512, LinkType: LINKTYPE_ETHERNET})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				frames := make([][]byte, len(specs))
+				for i, spec := range specs {
+					if frames[i], err = Synthesize(spec); err != nil {
+						t.Fatal(err)
+					}
+					if err := w.WritePacket(start.Add(time.Duration(i)*time.Second), frames[i], 0); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				r, err := NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, spec := range specs {
+					packet, err := r.ReadPacket()
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					want := start.Add(time.Duration(i) * time.Second)
+					if !nano {
+						want = want.Truncate(time.Microsecond)
+					}
+					if !packet.Timestamp.Equal(want) {
+						t.Errorf("%s/nano=%t: timestamp: got %v, want %v", order, nano, packet.Timestamp, want)
+					}
+
+					captured := frames[i]
+					if len(captured) > 512 {
+						captured = captured[:512]
+					}
+					if packet.Length != len(frames[i]) || !bytes.Equal(packet.Data, captured) {
+						t.Errorf("%s/nano=%t: packet %d does not match", order, nano, i)
+					}
+
+					if len(captured) == len(frames[i]) {
+						tuple, flags, payload, err := ParseFiveTuple(packet.Data)
+						if err != nil || tuple != spec.FiveTuple || flags != spec.TCPFlags || payload != spec.PayloadSize {
+							t.Errorf("parsed (%+v, %d, %d, %v), want %+v", tuple, flags, payload, err, spec)
+						}
+					}
+				}
+				if _, err := r.ReadPacket(); err != io.EOF {
+					t.Errorf("expected EOF, got %v", err)
+				}
+			}
+		}
+	}
+}
+
+// TestSynthesizeChecksums verifies IPv4 header and transport checksums.
+func TestSynthesizeChecksums(t *testing.T) {
+	t.Parallel()
+
+	frame, err := Synthesize(PacketSpec{
+		FiveTuple: FiveTuple{
+			Src: netip.MustParseAddr("192.168.0.1"), Dst: netip.MustParseAddr("192.168.0.2"),
+			SrcPort: 1234, DstPort: 80, Transport: TRANSPORT_TCP,
+		},
+		TCPFlags:    TCP_PSH | TCP_ACK,
+		PayloadSize: 33,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := frame[ethernetHeaderLength : ethernetHeaderLength+ipv4HeaderLength]
+	if checksum(ip, 0) != 0 {
+		t.Error("invalid IPv4 header checksum")
+	}
+
+	segment := frame[ethernetHeaderLength+ipv4HeaderLength:]
+	spec := PacketSpec{FiveTuple: FiveTuple{
+		Src: netip.MustParseAddr("192.168.0.1"), Dst: netip.MustParseAddr("192.168.0.2"), Transport: TRANSPORT_TCP,
+	}}
+	if checksum(segment, pseudoHeaderSum(&spec, len(segment))) != 0 {
+		t.Error("invalid TCP checksum")
+	}
+}
+
+// TestReaderRejectsInvalidFiles verifies that unknown or truncated files are rejected.
+func TestReaderRejectsInvalidFiles(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewReader(bytes.NewReader([]byte("not a pcap file"))); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("unknown format: got %v", err)
+	}
+
+	golden, err := os.ReadFile(filepath.Join("testdata", "le_usec.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(golden[:len(golden)-6]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadPacket(); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("truncated file: got %v", err)
+	}
+}
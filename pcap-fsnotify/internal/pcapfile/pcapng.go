@@ -0,0 +1,337 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcapfile
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+type (
+	ngInterface struct {
+		linkType LinkType
+		snaplen  uint32
+		// units per second of EPB timestamps
+		resolution uint64
+	}
+
+	pcapngWriter struct {
+		w     io.Writer
+		opts  WriterOptions
+		order binary.ByteOrder
+	}
+
+	pcapngReader struct {
+		r          io.Reader
+		order      binary.ByteOrder
+		interfaces []ngInterface
+	}
+)
+
+const (
+	blockTypeSHB = uint32(0x0a0d0d0a)
+	blockTypeIDB = uint32(0x00000001)
+	blockTypeEPB = uint32(0x00000006)
+
+	byteOrderMagic = uint32(0x1a2b3c4d)
+
+	optionEndOfOpt = uint16(0)
+	optionTsResol  = uint16(9)
+
+	// a corrupted block length must not cause a huge allocation
+	maxBlockLength = 16 * 1024 * 1024
+)
+
+func padding(length int) int {
+	return (4 - length%4) % 4
+}
+
+// NewPcapngWriter writes a section header and a single interface description into `w`.
+func NewPcapngWriter(w io.Writer, opts WriterOptions) (Writer, error) {
+	opts = opts.withDefaults()
+	writer := &pcapngWriter{w: w, opts: opts, order: opts.ByteOrder}
+
+	// SHB: byte-order magic, version 1.0, unspecified section length
+	shb := make([]byte, 16)
+	writer.order.PutUint32(shb[0:4], byteOrderMagic)
+	writer.order.PutUint16(shb[4:6], 1)
+	writer.order.PutUint16(shb[6:8], 0)
+	writer.order.PutUint64(shb[8:16], math.MaxUint64)
+	if err := writer.writeBlock(blockTypeSHB, shb); err != nil {
+		return nil, err
+	}
+
+	// IDB: microseconds is the default resolution, so `if_tsresol` is only required for nanoseconds
+	idb := make([]byte, 8, 20)
+	writer.order.PutUint16(idb[0:2], uint16(opts.LinkType))
+	writer.order.PutUint32(idb[4:8], opts.Snaplen)
+	if opts.Nanosecond {
+		option := make([]byte, 12)
+		writer.order.PutUint16(option[0:2], optionTsResol)
+		writer.order.PutUint16(option[2:4], 1)
+		option[4] = 9
+		writer.order.PutUint16(option[8:10], optionEndOfOpt)
+		idb = append(idb, option...)
+	}
+	if err := writer.writeBlock(blockTypeIDB, idb); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *pcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+
+	block := make([]byte, length)
+	w.order.PutUint32(block[0:4], blockType)
+	w.order.PutUint32(block[4:8], length)
+	copy(block[8:], body)
+	w.order.PutUint32(block[length-4:], length)
+
+	_, err := w.w.Write(block)
+	return err
+}
+
+func (w *pcapngWriter) WritePacket(ts time.Time, data []byte, length int) error {
+	data, length = capture(data, length, w.opts.Snaplen)
+
+	var timestamp uint64
+	if w.opts.Nanosecond {
+		timestamp = uint64(ts.UnixNano())
+	} else {
+		timestamp = uint64(ts.UnixMicro())
+	}
+
+	epb := make([]byte, 20, 20+len(data)+padding(len(data)))
+	// interface ID is always `0`
+	w.order.PutUint32(epb[4:8], uint32(timestamp>>32))
+	w.order.PutUint32(epb[8:12], uint32(timestamp))
+	w.order.PutUint32(epb[12:16], uint32(len(data)))
+	w.order.PutUint32(epb[16:20], uint32(length))
+	epb = append(epb, data...)
+	epb = append(epb, make([]byte, padding(len(data)))...)
+
+	return w.writeBlock(blockTypeEPB, epb)
+}
+
+func newPcapngReader(r io.Reader) (*pcapngReader, error) {
+	reader := &pcapngReader{r: r}
+	// the first block must be a section header, which sets the byte order
+	if err := reader.readFirstSection(); err != nil {
+		return nil, err
+	}
+	// the link type is defined by the first interface description
+	for len(reader.interfaces) == 0 {
+		blockType, body, err := reader.readBlock()
+		if err != nil {
+			return nil, newInvalidFileError("missing interface description: %v", err)
+		}
+		switch blockType {
+		case blockTypeIDB:
+			err = reader.readInterface(body)
+		case blockTypeEPB:
+			err = newInvalidFileError("packet before interface description")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reader, nil
+}
+
+// readBlock returns the type and the body of the next block.
+func (r *pcapngReader) readBlock() (uint32, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r.r, header); err == io.EOF {
+		return 0, nil, io.EOF
+	} else if err != nil {
+		return 0, nil, newInvalidFileError("short block header: %v", err)
+	}
+
+	// SHB block type is a palindrome: its byte order is defined by the byte-order magic
+	blockType := binary.BigEndian.Uint32(header[0:4])
+	if blockType == blockTypeSHB {
+		bom := make([]byte, 4)
+		if _, err := io.ReadFull(r.r, bom); err != nil {
+			return 0, nil, newInvalidFileError("short section header: %v", err)
+		}
+		switch byteOrderMagic {
+		case binary.LittleEndian.Uint32(bom):
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(bom):
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, newInvalidFileError("invalid byte-order magic")
+		}
+		// interfaces are scoped to sections
+		r.interfaces = nil
+		header = append(header, bom...)
+	} else if r.order == nil {
+		return 0, nil, newInvalidFileError("missing section header")
+	} else {
+		blockType = r.order.Uint32(header[0:4])
+	}
+
+	length := r.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > maxBlockLength || int(length) < len(header)+4 {
+		return 0, nil, newInvalidFileError("invalid block length: %d", length)
+	}
+
+	rest := make([]byte, int(length)-len(header))
+	if _, err := io.ReadFull(r.r, rest); err != nil {
+		return 0, nil, newInvalidFileError("short block: %v", err)
+	}
+	if trailer := r.order.Uint32(rest[len(rest)-4:]); trailer != length {
+		return 0, nil, newInvalidFileError("block length mismatch: %d != %d", trailer, length)
+	}
+
+	body := append(header[8:], rest[:len(rest)-4]...)
+	return blockType, body, nil
+}
+
+func (r *pcapngReader) readFirstSection() error {
+	blockType, body, err := r.readBlock()
+	if err != nil {
+		return err
+	}
+	if blockType != blockTypeSHB {
+		return newInvalidFileError("missing section header")
+	}
+	return r.readSectionHeader(body)
+}
+
+func (r *pcapngReader) readSectionHeader(body []byte) error {
+	if len(body) < 16 {
+		return newInvalidFileError("short section header")
+	}
+	if major := r.order.Uint16(body[4:6]); major != 1 {
+		return newInvalidFileError("unsupported PCAPNG version: %d", major)
+	}
+	return nil
+}
+
+func (r *pcapngReader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return newInvalidFileError("short interface description")
+	}
+
+	iface := ngInterface{
+		linkType:   LinkType(r.order.Uint16(body[0:2])),
+		snaplen:    r.order.Uint32(body[4:8]),
+		resolution: 1_000_000,
+	}
+
+	options := body[8:]
+	for len(options) >= 4 {
+		code := r.order.Uint16(options[0:2])
+		length := int(r.order.Uint16(options[2:4]))
+		if code == optionEndOfOpt || 4+length > len(options) {
+			break
+		}
+		if code == optionTsResol && length >= 1 {
+			iface.resolution = tsResolution(options[4])
+		}
+		options = options[4+length+padding(length):]
+	}
+
+	r.interfaces = append(r.interfaces, iface)
+	return nil
+}
+
+// tsResolution returns units per second: the MSB selects a power of 2, otherwise a power of 10.
+func tsResolution(value uint8) uint64 {
+	exponent := uint64(value & 0x7f)
+	if exponent > 63 {
+		exponent = 63
+	}
+	if value&0x80 != 0 {
+		return 1 << exponent
+	}
+	resolution := uint64(1)
+	for i := uint64(0); i < exponent && resolution <= math.MaxUint64/10; i++ {
+		resolution *= 10
+	}
+	return resolution
+}
+
+func (r *pcapngReader) LinkType() LinkType {
+	if len(r.interfaces) == 0 {
+		return LINKTYPE_NULL
+	}
+	return r.interfaces[0].linkType
+}
+
+func (r *pcapngReader) ReadPacket() (*Packet, error) {
+	for {
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case blockTypeSHB:
+			if err := r.readSectionHeader(body); err != nil {
+				return nil, err
+			}
+		case blockTypeIDB:
+			if err := r.readInterface(body); err != nil {
+				return nil, err
+			}
+		case blockTypeEPB:
+			return r.readEnhancedPacket(body)
+		}
+		// other blocks are not supported: they are skipped
+	}
+}
+
+func (r *pcapngReader) readEnhancedPacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, newInvalidFileError("short enhanced packet")
+	}
+
+	ifaceID := int(r.order.Uint32(body[0:4]))
+	if ifaceID >= len(r.interfaces) {
+		return nil, newInvalidFileError("unknown interface: %d", ifaceID)
+	}
+	iface := r.interfaces[ifaceID]
+
+	timestamp := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+	capturedLength := int(r.order.Uint32(body[12:16]))
+	length := int(r.order.Uint32(body[16:20]))
+
+	if capturedLength > len(body)-20 {
+		return nil, newInvalidFileError("captured length %d exceeds block", capturedLength)
+	}
+
+	seconds := timestamp / iface.resolution
+	fraction := timestamp % iface.resolution
+	// `fraction * 1e9` may overflow 64 bits for high resolutions
+	hi, lo := bits.Mul64(fraction, 1_000_000_000)
+	nanos, _ := bits.Div64(hi, lo, iface.resolution)
+
+	data := make([]byte, capturedLength)
+	copy(data, body[20:20+capturedLength])
+
+	return &Packet{
+		Timestamp:      time.Unix(int64(seconds), int64(nanos)).UTC(),
+		Length:         length,
+		InterfaceIndex: ifaceID,
+		Data:           data,
+	}, nil
+}
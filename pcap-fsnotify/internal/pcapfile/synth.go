@@ -0,0 +1,304 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pcapfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+type (
+	Transport uint8
+
+	TCPFlags uint8
+
+	FiveTuple struct {
+		Src       netip.Addr
+		Dst       netip.Addr
+		SrcPort   uint16
+		DstPort   uint16
+		Transport Transport
+	}
+
+	// PacketSpec describes an Ethernet frame carrying a TCP or UDP segment over IPv4 or IPv6.
+	PacketSpec struct {
+		FiveTuple
+		// SrcMAC and DstMAC default to locally administered addresses
+		SrcMAC      net.HardwareAddr
+		DstMAC      net.HardwareAddr
+		TCPFlags    TCPFlags
+		Seq         uint32
+		Ack         uint32
+		PayloadSize int
+	}
+)
+
+const (
+	TRANSPORT_TCP = Transport(6)
+	TRANSPORT_UDP = Transport(17)
+
+	TCP_FIN = TCPFlags(0x01)
+	TCP_SYN = TCPFlags(0x02)
+	TCP_RST = TCPFlags(0x04)
+	TCP_PSH = TCPFlags(0x08)
+	TCP_ACK = TCPFlags(0x10)
+	TCP_URG = TCPFlags(0x20)
+
+	etherTypeIPv4 = uint16(0x0800)
+	etherTypeIPv6 = uint16(0x86dd)
+
+	ethernetHeaderLength = 14
+	ipv4HeaderLength     = 20
+	ipv6HeaderLength     = 40
+	tcpHeaderLength      = 20
+	udpHeaderLength      = 8
+)
+
+var (
+	defaultSrcMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	defaultDstMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+
+	ErrInvalidPacketSpec = errors.New("invalid packet spec")
+	ErrUnsupportedPacket = errors.New("unsupported packet")
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TRANSPORT_TCP:
+		return "tcp"
+	case TRANSPORT_UDP:
+		return "udp"
+	}
+	return fmt.Sprintf("%d", uint8(t))
+}
+
+func (spec *PacketSpec) validate() error {
+	switch {
+	case !spec.Src.IsValid() || !spec.Dst.IsValid():
+		return fmt.Errorf("%w: missing IP addresses", ErrInvalidPacketSpec)
+	case spec.Src.Is4() != spec.Dst.Is4():
+		return fmt.Errorf("%w: mixed IP versions", ErrInvalidPacketSpec)
+	case spec.Transport != TRANSPORT_TCP && spec.Transport != TRANSPORT_UDP:
+		return fmt.Errorf("%w: transport %s", ErrInvalidPacketSpec, spec.Transport)
+	case spec.PayloadSize < 0 || spec.PayloadSize > 65000:
+		return fmt.Errorf("%w: payload size %d", ErrInvalidPacketSpec, spec.PayloadSize)
+	}
+	return nil
+}
+
+// Synthesize builds an Ethernet frame with valid IPv4, TCP and UDP checksums;
+// the payload is a deterministic sequence of bytes.
+func Synthesize(spec PacketSpec) ([]byte, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	srcMAC, dstMAC := spec.SrcMAC, spec.DstMAC
+	if len(srcMAC) != 6 {
+		srcMAC = defaultSrcMAC
+	}
+	if len(dstMAC) != 6 {
+		dstMAC = defaultDstMAC
+	}
+
+	segment := newSegment(&spec)
+
+	var frame []byte
+	if spec.Src.Is4() {
+		frame = newEthernetHeader(dstMAC, srcMAC, etherTypeIPv4)
+		frame = append(frame, newIPv4Header(&spec, len(segment))...)
+	} else {
+		frame = newEthernetHeader(dstMAC, srcMAC, etherTypeIPv6)
+		frame = append(frame, newIPv6Header(&spec, len(segment))...)
+	}
+
+	return append(frame, segment...), nil
+}
+
+func newEthernetHeader(dst, src net.HardwareAddr, etherType uint16) []byte {
+	header := make([]byte, ethernetHeaderLength, ethernetHeaderLength+ipv6HeaderLength)
+	copy(header[0:6], dst)
+	copy(header[6:12], src)
+	binary.BigEndian.PutUint16(header[12:14], etherType)
+	return header
+}
+
+func newIPv4Header(spec *PacketSpec, segmentLength int) []byte {
+	header := make([]byte, ipv4HeaderLength)
+	header[0] = 0x45 // version 4, 5 words
+	binary.BigEndian.PutUint16(header[2:4], uint16(ipv4HeaderLength+segmentLength))
+	binary.BigEndian.PutUint16(header[6:8], 0x4000) // don't fragment
+	header[8] = 64                                  // TTL
+	header[9] = uint8(spec.Transport)
+	src, dst := spec.Src.As4(), spec.Dst.As4()
+	copy(header[12:16], src[:])
+	copy(header[16:20], dst[:])
+	binary.BigEndian.PutUint16(header[10:12], checksum(header, 0))
+	return header
+}
+
+func newIPv6Header(spec *PacketSpec, segmentLength int) []byte {
+	header := make([]byte, ipv6HeaderLength)
+	header[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(header[4:6], uint16(segmentLength))
+	header[6] = uint8(spec.Transport)
+	header[7] = 64 // hop limit
+	src, dst := spec.Src.As16(), spec.Dst.As16()
+	copy(header[8:24], src[:])
+	copy(header[24:40], dst[:])
+	return header
+}
+
+func newSegment(spec *PacketSpec) []byte {
+	var segment []byte
+	checksumOffset := 0
+
+	switch spec.Transport {
+	case TRANSPORT_TCP:
+		segment = make([]byte, tcpHeaderLength+spec.PayloadSize)
+		binary.BigEndian.PutUint32(segment[4:8], spec.Seq)
+		binary.BigEndian.PutUint32(segment[8:12], spec.Ack)
+		segment[12] = (tcpHeaderLength / 4) << 4
+		segment[13] = uint8(spec.TCPFlags)
+		binary.BigEndian.PutUint16(segment[14:16], 65535) // window
+		checksumOffset = 16
+	case TRANSPORT_UDP:
+		segment = make([]byte, udpHeaderLength+spec.PayloadSize)
+		binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+		checksumOffset = 6
+	}
+
+	binary.BigEndian.PutUint16(segment[0:2], spec.SrcPort)
+	binary.BigEndian.PutUint16(segment[2:4], spec.DstPort)
+
+	headerLength := len(segment) - spec.PayloadSize
+	for i := range spec.PayloadSize {
+		segment[headerLength+i] = byte(i)
+	}
+
+	sum := checksum(segment, pseudoHeaderSum(spec, len(segment)))
+	if sum == 0 && spec.Transport == TRANSPORT_UDP {
+		// `0` means no checksum for UDP
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[checksumOffset:checksumOffset+2], sum)
+
+	return segment
+}
+
+func pseudoHeaderSum(spec *PacketSpec, segmentLength int) uint32 {
+	var pseudo []byte
+	if spec.Src.Is4() {
+		src, dst := spec.Src.As4(), spec.Dst.As4()
+		pseudo = append(pseudo, src[:]...)
+		pseudo = append(pseudo, dst[:]...)
+	} else {
+		src, dst := spec.Src.As16(), spec.Dst.As16()
+		pseudo = append(pseudo, src[:]...)
+		pseudo = append(pseudo, dst[:]...)
+	}
+	pseudo = append(pseudo, 0, uint8(spec.Transport))
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(segmentLength))
+	return sum(pseudo, 0)
+}
+
+func sum(data []byte, initial uint32) uint32 {
+	s := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	return s
+}
+
+// checksum is the internet checksum as defined by RFC 1071.
+func checksum(data []byte, initial uint32) uint16 {
+	s := sum(data, initial)
+	for s>>16 != 0 {
+		s = (s & 0xffff) + (s >> 16)
+	}
+	return ^uint16(s)
+}
+
+// ParseFiveTuple extracts the 5-tuple, TCP flags and payload size from an Ethernet frame.
+func ParseFiveTuple(frame []byte) (FiveTuple, TCPFlags, int, error) {
+	var tuple FiveTuple
+
+	if len(frame) < ethernetHeaderLength {
+		return tuple, 0, 0, fmt.Errorf("%w: short Ethernet header", ErrUnsupportedPacket)
+	}
+
+	var segment []byte
+	switch etherType := binary.BigEndian.Uint16(frame[12:14]); etherType {
+	case etherTypeIPv4:
+		ip := frame[ethernetHeaderLength:]
+		if len(ip) < ipv4HeaderLength || ip[0]>>4 != 4 {
+			return tuple, 0, 0, fmt.Errorf("%w: invalid IPv4 header", ErrUnsupportedPacket)
+		}
+		headerLength := int(ip[0]&0x0f) * 4
+		totalLength := int(binary.BigEndian.Uint16(ip[2:4]))
+		if headerLength < ipv4HeaderLength || totalLength < headerLength || totalLength > len(ip) {
+			return tuple, 0, 0, fmt.Errorf("%w: invalid IPv4 lengths", ErrUnsupportedPacket)
+		}
+		tuple.Transport = Transport(ip[9])
+		tuple.Src = netip.AddrFrom4([4]byte(ip[12:16]))
+		tuple.Dst = netip.AddrFrom4([4]byte(ip[16:20]))
+		segment = ip[headerLength:totalLength]
+	case etherTypeIPv6:
+		ip := frame[ethernetHeaderLength:]
+		if len(ip) < ipv6HeaderLength || ip[0]>>4 != 6 {
+			return tuple, 0, 0, fmt.Errorf("%w: invalid IPv6 header", ErrUnsupportedPacket)
+		}
+		payloadLength := int(binary.BigEndian.Uint16(ip[4:6]))
+		if ipv6HeaderLength+payloadLength > len(ip) {
+			return tuple, 0, 0, fmt.Errorf("%w: invalid IPv6 length", ErrUnsupportedPacket)
+		}
+		// extension headers are not supported
+		tuple.Transport = Transport(ip[6])
+		tuple.Src = netip.AddrFrom16([16]byte(ip[8:24]))
+		tuple.Dst = netip.AddrFrom16([16]byte(ip[24:40]))
+		segment = ip[ipv6HeaderLength : ipv6HeaderLength+payloadLength]
+	default:
+		return tuple, 0, 0, fmt.Errorf("%w: EtherType 0x%04x", ErrUnsupportedPacket, etherType)
+	}
+
+	var flags TCPFlags
+	headerLength := 0
+	switch tuple.Transport {
+	case TRANSPORT_TCP:
+		if len(segment) < tcpHeaderLength {
+			return tuple, 0, 0, fmt.Errorf("%w: short TCP header", ErrUnsupportedPacket)
+		}
+		headerLength = int(segment[12]>>4) * 4
+		flags = TCPFlags(segment[13])
+	case TRANSPORT_UDP:
+		headerLength = udpHeaderLength
+	default:
+		return tuple, 0, 0, fmt.Errorf("%w: transport %s", ErrUnsupportedPacket, tuple.Transport)
+	}
+	if headerLength < 4 || headerLength > len(segment) {
+		return tuple, 0, 0, fmt.Errorf("%w: invalid %s header", ErrUnsupportedPacket, tuple.Transport)
+	}
+
+	tuple.SrcPort = binary.BigEndian.Uint16(segment[0:2])
+	tuple.DstPort = binary.BigEndian.Uint16(segment[2:4])
+
+	return tuple, flags, len(segment) - headerLength, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+type (
+	// command runs an external program for each PCAP file;
+	// every field of its spec is a template: `{{.Source}}` is the PCAP file, and `{{.Name}}` its exported name.
+	command struct {
+		spec   string
+		args   []*template.Template
+		limits Limits
+	}
+
+	// CommandResult is the analysis produced by an external command;
+	// its output is embedded as JSON when it is valid JSON.
+	CommandResult struct {
+		Processor string          `json:"processor"`
+		Command   []string        `json:"command"`
+		JSON      json.RawMessage `json:"json,omitempty"`
+		Output    string          `json:"output,omitempty"`
+		Truncated bool            `json:"truncated,omitempty"`
+	}
+
+	// limitedBuffer discards everything written after `max` bytes;
+	// the buffer is not embedded: `io.Copy` would bypass the limit using `ReadFrom`.
+	limitedBuffer struct {
+		buf       bytes.Buffer
+		max       int
+		truncated bool
+	}
+)
+
+const (
+	PROCESSOR_COMMAND = "command"
+
+	maxOutputBytes = 1 << 20
+	maxErrorBytes  = 4096
+	maxNice        = 19
+)
+
+// limits are set by the shell before it is replaced by the command: they are inherited by all its children.
+var shell = "/bin/sh"
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if available := b.max - b.buf.Len(); len(p) > available {
+		b.buf.Write(p[:max(available, 0)])
+		b.truncated = true
+		// the command must not fail because its output is too large
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// NewCommand parses `spec` as the template of an external command: i/e: `tshark -q -z io,phs -r {{.Source}}`.
+func NewCommand(
+	spec string,
+	limits Limits,
+) (Processor, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, errors.New("empty post-processing command")
+	}
+	if limits.Nice < 0 || limits.Nice > maxNice {
+		return nil, fmt.Errorf("niceness must be between 0 and %d: %d", maxNice, limits.Nice)
+	}
+
+	args := make([]*template.Template, len(fields))
+	for i, field := range fields {
+		arg, err := template.New(fmt.Sprintf("arg%d", i)).Option("missingkey=error").Parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid post-processing command: %w", err)
+		}
+		args[i] = arg
+	}
+
+	return &command{
+		spec:   spec,
+		args:   args,
+		limits: limits,
+	}, nil
+}
+
+func (c *command) Name() string {
+	return PROCESSOR_COMMAND
+}
+
+func (c *command) render(
+	job *Job,
+) ([]string, error) {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		var sb strings.Builder
+		if err := arg.Execute(&sb, job); err != nil {
+			return nil, err
+		}
+		args[i] = sb.String()
+	}
+	return args, nil
+}
+
+// script sets resource limits before replacing the shell with the command;
+// arguments are never interpreted by the shell.
+func (c *command) script() string {
+	var sb strings.Builder
+	if c.limits.CPU > 0 {
+		// CPU time limits have a resolution of seconds
+		fmt.Fprintf(&sb, "ulimit -t %d && ", int64((c.limits.CPU+time.Second-1)/time.Second))
+	}
+	if c.limits.Memory > 0 {
+		fmt.Fprintf(&sb, "ulimit -v %d && ", max(c.limits.Memory/1024, 1))
+	}
+	sb.WriteString(`exec "$@"`)
+	return sb.String()
+}
+
+func (c *command) Process(
+	ctx context.Context,
+	job *Job,
+) ([]byte, error) {
+	args, err := c.render(job)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, shell, append([]string{"-c", c.script(), "postprocess"}, args...)...)
+	// the command runs in its own process group, so that all its children are killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	stdout := &limitedBuffer{max: maxOutputBytes}
+	stderr := &limitedBuffer{max: maxErrorBytes}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if c.limits.Nice > 0 {
+		// best effort: the command may be running before its priority is lowered
+		syscall.Setpriority(syscall.PRIO_PROCESS, cmd.Process.Pid, c.limits.Nice)
+	}
+
+	err = cmd.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("'%s' failed: %w: %s", c.spec, err, strings.TrimSpace(stderr.buf.String()))
+	}
+
+	result := &CommandResult{
+		Processor: PROCESSOR_COMMAND,
+		Command:   args,
+		Truncated: stdout.truncated,
+	}
+	if output := bytes.TrimSpace(stdout.buf.Bytes()); !stdout.truncated && json.Valid(output) {
+		result.JSON = output
+	} else {
+		result.Output = stdout.buf.String()
+	}
+	return json.Marshal(result)
+}
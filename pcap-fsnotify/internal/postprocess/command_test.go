@@ -0,0 +1,216 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript creates an executable shell script in a temporary directory.
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func runCommand(t *testing.T, spec string, limits Limits, job *Job) (*CommandResult, error) {
+	t.Helper()
+	processor, err := NewCommand(spec, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	analysis, err := processor.Process(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var result CommandResult
+	if err := json.Unmarshal(analysis, &result); err != nil {
+		t.Fatal(err)
+	}
+	return &result, nil
+}
+
+// TestNewCommand verifies that invalid templates and limits are rejected.
+func TestNewCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		limits Limits
+	}{
+		{"empty", "  ", Limits{}},
+		{"invalid template", "tshark -r {{.Source", Limits{}},
+		{"negative niceness", "true", Limits{Nice: -1}},
+		{"high niceness", "true", Limits{Nice: 20}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewCommand(tc.spec, tc.limits); err == nil {
+				t.Errorf("NewCommand(%q): got no error", tc.spec)
+			}
+		})
+	}
+}
+
+// TestCommandOutput verifies how arguments are rendered, and how the output is captured.
+func TestCommandOutput(t *testing.T) {
+	job := &Job{Source: "/pcap-tmp/.pcapfsn-postprocess-x", Name: "part__1_eth0__20240101T000000.pcap"}
+
+	tests := []struct {
+		name  string
+		spec  string
+		check func(t *testing.T, result *CommandResult)
+	}{
+		{
+			name: "JSON output",
+			spec: writeScript(t, `echo '{"packets": 7}'`),
+			check: func(t *testing.T, result *CommandResult) {
+				if string(result.JSON) != `{"packets":7}` {
+					t.Errorf("got JSON %q", result.JSON)
+				}
+			},
+		},
+		{
+			name: "text output",
+			spec: writeScript(t, `echo "$1" "$2"`) + " {{.Source}} {{.Name}}",
+			check: func(t *testing.T, result *CommandResult) {
+				if want := job.Source + " " + job.Name + "\n"; result.Output != want {
+					t.Errorf("got output %q, want %q", result.Output, want)
+				}
+			},
+		},
+		{
+			name: "arguments are not interpreted by the shell",
+			spec: writeScript(t, `echo "$1"`) + " $(id)",
+			check: func(t *testing.T, result *CommandResult) {
+				if result.Output != "$(id)\n" {
+					t.Errorf("got output %q", result.Output)
+				}
+			},
+		},
+		{
+			name: "large output",
+			spec: writeScript(t, `head -c 2000000 /dev/zero`),
+			check: func(t *testing.T, result *CommandResult) {
+				if !result.Truncated || len(result.Output) != maxOutputBytes {
+					t.Errorf("got %d bytes, truncated %v", len(result.Output), result.Truncated)
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := runCommand(t, tc.spec, Limits{}, job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Processor != PROCESSOR_COMMAND {
+				t.Errorf("got processor %s", result.Processor)
+			}
+			tc.check(t, result)
+		})
+	}
+}
+
+// TestCommandLimits verifies that resource limits are enforced, and that commands are killed on timeout.
+func TestCommandLimits(t *testing.T) {
+	job := &Job{Source: "/dev/null", Name: "null"}
+
+	tests := []struct {
+		name   string
+		spec   string
+		limits Limits
+		check  func(t *testing.T, result *CommandResult, err error)
+	}{
+		{
+			name:   "failure",
+			spec:   writeScript(t, `echo "oops" >&2; exit 3`),
+			limits: Limits{},
+			check: func(t *testing.T, _ *CommandResult, err error) {
+				if err == nil || !strings.Contains(err.Error(), "oops") {
+					t.Errorf("got %v, want the command error", err)
+				}
+			},
+		},
+		{
+			name:   "timeout kills all processes",
+			spec:   writeScript(t, `sleep 10 & sleep 10; wait`),
+			limits: Limits{Timeout: 200 * time.Millisecond},
+			check: func(t *testing.T, _ *CommandResult, err error) {
+				if !errors.Is(err, ErrTimeout) {
+					t.Errorf("got %v, want %v", err, ErrTimeout)
+				}
+			},
+		},
+		{
+			name:   "CPU time",
+			spec:   writeScript(t, `while :; do :; done`),
+			limits: Limits{CPU: time.Second, Timeout: 10 * time.Second},
+			check: func(t *testing.T, _ *CommandResult, err error) {
+				if err == nil || errors.Is(err, ErrTimeout) {
+					t.Errorf("got %v, want the command to be killed by its CPU limit", err)
+				}
+			},
+		},
+		{
+			name:   "memory",
+			spec:   writeScript(t, `ulimit -v`),
+			limits: Limits{Memory: 64 << 20},
+			check: func(t *testing.T, result *CommandResult, err error) {
+				if err != nil || string(result.JSON) != "65536" {
+					t.Errorf("got %+v, %v; want a limit of 65536 KiB", result, err)
+				}
+			},
+		},
+		{
+			name:   "niceness",
+			spec:   writeScript(t, `sleep 0.2; cut -d' ' -f19 /proc/$$/stat`),
+			limits: Limits{Nice: 10},
+			check: func(t *testing.T, result *CommandResult, err error) {
+				if err != nil || string(result.JSON) != "10" {
+					t.Errorf("got %+v, %v; want niceness 10", result, err)
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			result, err := runCommand(t, tc.spec, tc.limits, job)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %s", elapsed)
+			}
+			tc.check(t, result, err)
+		})
+	}
+}
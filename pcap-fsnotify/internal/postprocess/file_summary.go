@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// SummarySuffix is appended to the name of exported PCAP files to name their JSON summary
+const SummarySuffix = ".summary.json"
+
+// FileSummary indexes a single exported PCAP file, so that analysts can find captures without opening them;
+// unlike the `summary` processor, it is produced synchronously and never includes histograms.
+type FileSummary struct {
+	Name    string    `json:"name"`
+	Iface   string    `json:"iface,omitempty"`
+	Filter  string    `json:"filter"`
+	Packets uint64    `json:"packets"`
+	Bytes   uint64    `json:"bytes"`
+	First   time.Time `json:"first,omitzero"`
+	Last    time.Time `json:"last,omitzero"`
+	// Truncated is set when the PCAP file ends with a partial packet
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// NewFileSummary reads all packets from the PCAP file at `source`, which is exported as `name`;
+// `filter` is the BPF filter used by the capture, and is empty when disabled.
+func NewFileSummary(
+	ctx context.Context,
+	source, name, iface, filter string,
+) (*FileSummary, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	summary, err := Summarize(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSummary{
+		Name:      name,
+		Iface:     iface,
+		Filter:    filter,
+		Packets:   summary.Packets,
+		Bytes:     summary.Bytes,
+		First:     summary.First,
+		Last:      summary.Last,
+		Truncated: summary.Truncated,
+	}, nil
+}
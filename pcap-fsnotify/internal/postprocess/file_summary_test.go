@@ -0,0 +1,60 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestNewFileSummary verifies the summary of `testdata/summary.pcap`, and that it is rendered without histograms.
+func TestNewFileSummary(t *testing.T) {
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	summary, err := NewFileSummary(context.Background(), filepath.Join("testdata", "summary.pcap"),
+		"part__0_eth0__20240101T000000.pcap.gz", "0:eth0", "tcp port 443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := FileSummary{
+		Name:    "part__0_eth0__20240101T000000.pcap.gz",
+		Iface:   "0:eth0",
+		Filter:  "tcp port 443",
+		Packets: 7,
+		Bytes:   3*154 + 2*74 + 74 + 60,
+		First:   first,
+		Last:    first.Add(6 * time.Second),
+	}
+	if *summary != want {
+		t.Errorf("got %+v, want %+v", *summary, want)
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{"name":"part__0_eth0__20240101T000000.pcap.gz","iface":"0:eth0","filter":"tcp port 443","packets":7,"bytes":744,"first":"2024-01-01T00:00:00Z","last":"2024-01-01T00:00:06Z"}`
+	if string(data) != wantJSON {
+		t.Errorf("got %s, want %s", data, wantJSON)
+	}
+
+	if _, err := NewFileSummary(context.Background(), filepath.Join("testdata", "missing.pcap"), "", "", ""); err == nil {
+		t.Error("summarized a missing PCAP file")
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package postprocess generates lightweight analysis of exported PCAP files in the background;
+// analysis never affects exports: failures are only reported to the caller.
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Processor analyzes the PCAP file of `job` and returns a JSON document.
+	Processor interface {
+		Name() string
+		Process(ctx context.Context, job *Job) ([]byte, error)
+	}
+
+	// Limits bound the resources used to analyze a single PCAP file;
+	// `CPU` and `Memory` are only enforced for external commands, and `0` disables any of them.
+	Limits struct {
+		CPU     time.Duration
+		Memory  uint64
+		Timeout time.Duration
+		// Nice is the scheduling priority of external commands: from `0` to `19`
+		Nice int
+	}
+
+	// Job is a PCAP file waiting to be analyzed; `Source` is removed once it is processed.
+	Job struct {
+		Source string
+		// Name is the base name of the exported PCAP file
+		Name string
+	}
+
+	// ExportFunc exports the analysis of `job` stored at `analysisFile`.
+	ExportFunc func(ctx context.Context, job *Job, analysisFile string) error
+
+	// ResultFunc is called once for every submitted job, with the error that prevented exporting its analysis.
+	ResultFunc func(job *Job, err error)
+
+	// Runner analyzes PCAP files one at a time, so that analysis does not compete with exports.
+	Runner struct {
+		processor Processor
+		limits    Limits
+		export    ExportFunc
+		onResult  ResultFunc
+		// skip reports whether analysis should be skipped; i/e: while the main application is under pressure
+		skip func() bool
+
+		jobs chan *Job
+		wg   sync.WaitGroup
+	}
+)
+
+const (
+	// AnalysisSuffix is appended to the name of exported PCAP files to name their analysis
+	AnalysisSuffix = ".analysis.json"
+
+	PROCESSOR_SUMMARY = "summary"
+
+	queueSize = 16
+)
+
+var (
+	ErrQueueFull = errors.New("post-processing queue is full")
+	ErrSkipped   = errors.New("post-processing skipped")
+	ErrTimeout   = errors.New("post-processing timed out")
+)
+
+// New returns the built-in processor named `spec`, or an external command processor using `spec` as its template.
+func New(
+	spec string,
+	limits Limits,
+) (Processor, error) {
+	switch spec = strings.TrimSpace(spec); spec {
+	case "":
+		return nil, errors.New("empty post-processor")
+	case PROCESSOR_SUMMARY:
+		return NewSummary(), nil
+	}
+	return NewCommand(spec, limits)
+}
+
+func NewRunner(
+	processor Processor,
+	limits Limits,
+	export ExportFunc,
+	onResult ResultFunc,
+	skip func() bool,
+) *Runner {
+	return &Runner{
+		processor: processor,
+		limits:    limits,
+		export:    export,
+		onResult:  onResult,
+		skip:      skip,
+		jobs:      make(chan *Job, queueSize),
+	}
+}
+
+// Start processes submitted jobs until `ctx` is done; pending jobs are dropped.
+func (r *Runner) Start(ctx context.Context) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				r.drain(ctx.Err())
+				return
+			case job := <-r.jobs:
+				r.onResult(job, r.run(ctx, job))
+			}
+		}
+	}()
+}
+
+// Wait blocks until the runner stops.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+// Submit queues `job` without blocking; if it cannot be queued, `job.Source` is removed.
+func (r *Runner) Submit(job *Job) bool {
+	select {
+	case r.jobs <- job:
+		return true
+	default:
+		os.Remove(job.Source)
+		r.onResult(job, ErrQueueFull)
+		return false
+	}
+}
+
+func (r *Runner) drain(err error) {
+	for {
+		select {
+		case job := <-r.jobs:
+			os.Remove(job.Source)
+			r.onResult(job, err)
+		default:
+			return
+		}
+	}
+}
+
+func (r *Runner) run(
+	ctx context.Context,
+	job *Job,
+) error {
+	defer os.Remove(job.Source)
+
+	if r.skip != nil && r.skip() {
+		return ErrSkipped
+	}
+
+	if r.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.limits.Timeout)
+		defer cancel()
+	}
+
+	analysis, err := r.processor.Process(ctx, job)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrTimeout
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.processor.Name(), err)
+	}
+
+	// the analysis file name is preserved at the destination
+	tmpDir, err := os.MkdirTemp("", "pcapfsn-analysis-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	analysisFile := filepath.Join(tmpDir, job.Name+AnalysisSuffix)
+	if err := os.WriteFile(analysisFile, analysis, 0o644); err != nil {
+		return err
+	}
+	return r.export(ctx, job, analysisFile)
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeProcessor struct {
+	err     error
+	release chan struct{}
+}
+
+func (p *fakeProcessor) Name() string {
+	return "fake"
+}
+
+func (p *fakeProcessor) Process(ctx context.Context, job *Job) ([]byte, error) {
+	if p.release != nil {
+		<-p.release
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return []byte(`{"name":"` + job.Name + `"}`), nil
+}
+
+// newSource creates a PCAP file to be analyzed.
+func newSource(t *testing.T) string {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), ".pcapfsn-postprocess-x")
+	if err := os.WriteFile(source, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+// TestNew verifies how post-processors are selected.
+func TestNew(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", true},
+		{"summary", PROCESSOR_SUMMARY, false},
+		{" summary ", PROCESSOR_SUMMARY, false},
+		{"tshark -r {{.Source}}", PROCESSOR_COMMAND, false},
+	}
+
+	for _, tc := range tests {
+		processor, err := New(tc.spec, Limits{})
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("New(%q): error %v, wantErr %v", tc.spec, err, tc.wantErr)
+		}
+		if err == nil && processor.Name() != tc.want {
+			t.Errorf("New(%q): got %s, want %s", tc.spec, processor.Name(), tc.want)
+		}
+	}
+}
+
+// TestRunner verifies that analysis is exported, that failures are reported, and that sources are always removed.
+func TestRunner(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name       string
+		processor  Processor
+		limits     Limits
+		skip       bool
+		exportErr  error
+		wantErr    error
+		wantExport bool
+	}{
+		{"exported", &fakeProcessor{}, Limits{}, false, nil, nil, true},
+		{"skipped", &fakeProcessor{}, Limits{}, true, nil, ErrSkipped, false},
+		{"processor failure", &fakeProcessor{err: errFailed}, Limits{}, false, nil, errFailed, false},
+		{"timeout", &fakeProcessor{err: context.DeadlineExceeded}, Limits{Timeout: time.Second}, false, nil, ErrTimeout, false},
+		{"export failure", &fakeProcessor{}, Limits{}, false, errFailed, errFailed, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			exported := ""
+			results := make(chan error, 1)
+			runner := NewRunner(tc.processor, tc.limits,
+				func(_ context.Context, job *Job, analysisFile string) error {
+					content, err := os.ReadFile(analysisFile)
+					if err != nil {
+						t.Error(err)
+					}
+					exported = filepath.Base(analysisFile) + " " + string(content)
+					return tc.exportErr
+				},
+				func(_ *Job, err error) { results <- err },
+				func() bool { return tc.skip })
+			runner.Start(ctx)
+
+			job := &Job{Source: newSource(t), Name: "part__1_eth0__20240101T000000.pcap"}
+			runner.Submit(job)
+
+			if err := <-results; !errors.Is(err, tc.wantErr) {
+				t.Errorf("got %v, want %v", err, tc.wantErr)
+			}
+			if want := job.Name + AnalysisSuffix + ` {"name":"` + job.Name + `"}`; tc.wantExport && exported != want {
+				t.Errorf("got export %q, want %q", exported, want)
+			}
+			if !tc.wantExport && exported != "" {
+				t.Errorf("got export %q, want none", exported)
+			}
+			if _, err := os.Stat(job.Source); !os.IsNotExist(err) {
+				t.Errorf("source was not removed: %v", err)
+			}
+
+			cancel()
+			runner.Wait()
+		})
+	}
+}
+
+// TestRunnerQueueFull verifies that jobs are dropped without blocking when the queue is full.
+func TestRunnerQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	dropped := make(chan *Job, 1)
+	runner := NewRunner(&fakeProcessor{release: release}, Limits{},
+		func(context.Context, *Job, string) error { return nil },
+		func(job *Job, err error) {
+			if errors.Is(err, ErrQueueFull) {
+				dropped <- job
+			}
+		},
+		nil)
+
+	// the runner is not started: jobs are only queued
+	for i := 0; i < queueSize; i++ {
+		if !runner.Submit(&Job{Source: newSource(t)}) {
+			t.Fatalf("job %d was not queued", i)
+		}
+	}
+
+	job := &Job{Source: newSource(t)}
+	if runner.Submit(job) {
+		t.Fatal("job was queued into a full queue")
+	}
+	if got := <-dropped; got != job {
+		t.Errorf("got dropped job %v, want %v", got, job)
+	}
+	if _, err := os.Stat(job.Source); !os.IsNotExist(err) {
+		t.Errorf("source of the dropped job was not removed: %v", err)
+	}
+	close(release)
+}
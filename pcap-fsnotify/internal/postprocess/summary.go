@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/pcapfile"
+)
+
+type (
+	summary struct{}
+
+	// Count is a single histogram bucket.
+	Count struct {
+		Key   string `json:"key"`
+		Count uint64 `json:"count"`
+	}
+
+	// Summary is the analysis produced by the built-in `summary` processor.
+	Summary struct {
+		Processor string    `json:"processor"`
+		Packets   uint64    `json:"packets"`
+		Bytes     uint64    `json:"bytes"`
+		First     time.Time `json:"first,omitzero"`
+		Last      time.Time `json:"last,omitzero"`
+		// Unsupported counts packets which are not TCP or UDP over IPv4 or IPv6 within Ethernet frames
+		Unsupported uint64  `json:"unsupported"`
+		Protocols   []Count `json:"protocols"`
+		Ports       []Count `json:"ports"`
+		Hosts       []Count `json:"hosts"`
+		// Truncated is set when the PCAP file ends with a partial packet
+		Truncated bool `json:"truncated,omitempty"`
+	}
+
+	histogram map[string]uint64
+)
+
+const (
+	// histograms only keep the busiest buckets; all others are added to `otherKey`
+	maxBuckets = 20
+	otherKey   = "other"
+
+	// how often to check whether processing should stop
+	cancelCheckInterval = 1024
+)
+
+// NewSummary returns the built-in processor which produces protocol, port and host histograms using the internal parser.
+func NewSummary() Processor {
+	return &summary{}
+}
+
+func (s *summary) Name() string {
+	return PROCESSOR_SUMMARY
+}
+
+func (h histogram) top(n int) []Count {
+	counts := make([]Count, 0, len(h))
+	for key, count := range h {
+		counts = append(counts, Count{Key: key, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if len(counts) <= n {
+		return counts
+	}
+	other := Count{Key: otherKey}
+	for _, count := range counts[n:] {
+		other.Count += count.Count
+	}
+	return append(counts[:n], other)
+}
+
+// Summarize reads all packets from `r`.
+func Summarize(
+	ctx context.Context,
+	r io.Reader,
+) (*Summary, error) {
+	reader, err := pcapfile.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Summary{Processor: PROCESSOR_SUMMARY}
+	protocols, ports, hosts := histogram{}, histogram{}, histogram{}
+
+	for {
+		if result.Packets%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		packet, err := reader.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if errors.Is(err, pcapfile.ErrInvalidFile) && result.Packets > 0 {
+			// PCAP files may be rotated while a packet is being written
+			result.Truncated = true
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		result.Packets += 1
+		result.Bytes += uint64(packet.Length)
+		if result.First.IsZero() || packet.Timestamp.Before(result.First) {
+			result.First = packet.Timestamp
+		}
+		if packet.Timestamp.After(result.Last) {
+			result.Last = packet.Timestamp
+		}
+
+		if reader.LinkType() != pcapfile.LINKTYPE_ETHERNET {
+			result.Unsupported += 1
+			continue
+		}
+		tuple, _, _, err := pcapfile.ParseFiveTuple(packet.Data)
+		if err != nil {
+			result.Unsupported += 1
+			continue
+		}
+
+		ipVersion := "ipv4"
+		if !tuple.Src.Is4() {
+			ipVersion = "ipv6"
+		}
+		protocols[fmt.Sprintf("%s/%s", ipVersion, tuple.Transport)] += 1
+		// the lowest port is most likely the service port
+		ports[fmt.Sprintf("%s/%d", tuple.Transport, min(tuple.SrcPort, tuple.DstPort))] += 1
+		hosts[tuple.Src.String()] += 1
+		hosts[tuple.Dst.String()] += 1
+	}
+
+	result.Protocols = protocols.top(maxBuckets)
+	result.Ports = ports.top(maxBuckets)
+	result.Hosts = hosts.top(maxBuckets)
+	return result, nil
+}
+
+func (s *summary) Process(
+	ctx context.Context,
+	job *Job,
+) ([]byte, error) {
+	f, err := os.Open(job.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result, err := Summarize(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
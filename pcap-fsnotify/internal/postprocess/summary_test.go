@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSummarize verifies the histograms produced for `testdata/summary.pcap`:
+//   - 3 IPv4 TCP packets from `10.0.0.1:40000` to `10.0.0.2:443`
+//   - 2 IPv4 UDP packets from `10.0.0.1:53000` to `8.8.8.8:53`
+//   - 1 IPv6 TCP packet from `[fd00::1]:40001` to `[fd00::2]:80`
+//   - 1 ARP packet
+func TestSummarize(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "summary.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, s *Summary)
+	}{
+		{
+			name: "fixture",
+			data: fixture,
+			check: func(t *testing.T, s *Summary) {
+				want := &Summary{
+					Processor:   PROCESSOR_SUMMARY,
+					Packets:     7,
+					Bytes:       3*154 + 2*74 + 74 + 60,
+					First:       first,
+					Last:        first.Add(6 * time.Second),
+					Unsupported: 1,
+					Protocols:   []Count{{"ipv4/tcp", 3}, {"ipv4/udp", 2}, {"ipv6/tcp", 1}},
+					Ports:       []Count{{"tcp/443", 3}, {"udp/53", 2}, {"tcp/80", 1}},
+					Hosts:       []Count{{"10.0.0.1", 5}, {"10.0.0.2", 3}, {"8.8.8.8", 2}, {"fd00::1", 1}, {"fd00::2", 1}},
+				}
+				if !reflect.DeepEqual(s, want) {
+					t.Errorf("got %+v, want %+v", s, want)
+				}
+			},
+		},
+		{
+			name: "truncated",
+			// the last packet is partially written
+			data: fixture[:len(fixture)-10],
+			check: func(t *testing.T, s *Summary) {
+				if !s.Truncated || s.Packets != 6 {
+					t.Errorf("got %d packets, truncated %v; want 6 packets, truncated", s.Packets, s.Truncated)
+				}
+			},
+		},
+		{
+			name: "empty",
+			data: fixture[:24],
+			check: func(t *testing.T, s *Summary) {
+				if s.Packets != 0 || len(s.Protocols) != 0 || s.Truncated {
+					t.Errorf("got %+v, want no packets", s)
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := Summarize(context.Background(), bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, s)
+		})
+	}
+}
+
+// TestSummarizeErrors verifies that invalid PCAP files and cancelled contexts fail.
+func TestSummarizeErrors(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "summary.pcap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		data []byte
+	}{
+		{"not a PCAP file", context.Background(), []byte("not a PCAP file")},
+		{"truncated first packet", context.Background(), fixture[:30]},
+		{"cancelled", cancelled, fixture},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Summarize(tc.ctx, bytes.NewReader(tc.data)); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// TestHistogramTop verifies that only the busiest buckets are kept.
+func TestHistogramTop(t *testing.T) {
+	h := histogram{"a": 5, "b": 3, "c": 3, "d": 1, "e": 1}
+
+	tests := []struct {
+		n    int
+		want []Count
+	}{
+		{5, []Count{{"a", 5}, {"b", 3}, {"c", 3}, {"d", 1}, {"e", 1}}},
+		{2, []Count{{"a", 5}, {"b", 3}, {otherKey, 5}}},
+	}
+
+	for _, tc := range tests {
+		if got := h.top(tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("top(%d): got %v, want %v", tc.n, got, tc.want)
+		}
+	}
+}
+
+// TestSummaryProcess verifies that the built-in processor produces JSON.
+func TestSummaryProcess(t *testing.T) {
+	analysis, err := NewSummary().Process(context.Background(), &Job{Source: filepath.Join("testdata", "summary.pcap")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Summary
+	if err := json.Unmarshal(analysis, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Processor != PROCESSOR_SUMMARY || s.Packets != 7 {
+		t.Errorf("got %+v", s)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pressure reads PSI ( pressure stall information ) to detect when the main application is under load.
+package pressure
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	Resource string
+
+	// Source reads the share of time ( 0-100 ) that some tasks stalled on `resource` over the last 10 seconds.
+	Source interface {
+		Read(resource Resource) (float64, error)
+	}
+
+	fileSource struct {
+		files map[Resource]string
+	}
+
+	// Monitor throttles when any resource pressure reaches the threshold,
+	// and only stops throttling when all of them drop below half of it.
+	Monitor struct {
+		mu        sync.Mutex
+		source    Source
+		threshold float64
+		readings  map[Resource]float64
+		throttled atomic.Bool
+	}
+)
+
+const (
+	RESOURCE_CPU    = Resource("cpu")
+	RESOURCE_MEMORY = Resource("memory")
+	RESOURCE_IO     = Resource("io")
+)
+
+var (
+	ErrUnavailable = errors.New("pressure stall information is not available")
+
+	Resources = []Resource{RESOURCE_CPU, RESOURCE_MEMORY, RESOURCE_IO}
+
+	// cgroup level pressure is preferred over system wide pressure
+	pressureDirs = []string{"/sys/fs/cgroup", "/proc/pressure"}
+)
+
+// Parse returns the `avg10` value of the `some` line of a PSI file:
+//   - `some avg10=0.00 avg60=0.00 avg300=0.00 total=0`
+func Parse(
+	r io.Reader,
+) (float64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				return strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("invalid PSI: no 'some avg10' value")
+}
+
+func fileName(dir string, resource Resource) string {
+	if dir == "/proc/pressure" {
+		return filepath.Join(dir, string(resource))
+	}
+	return filepath.Join(dir, string(resource)+".pressure")
+}
+
+// NewFileSource returns a Source for the PSI files available for every resource.
+func NewFileSource() (Source, error) {
+	return newFileSource(pressureDirs)
+}
+
+func newFileSource(
+	dirs []string,
+) (Source, error) {
+	s := &fileSource{files: make(map[Resource]string)}
+	for _, resource := range Resources {
+		for _, dir := range dirs {
+			file := fileName(dir, resource)
+			if _, err := s.readFile(file); err == nil {
+				s.files[resource] = file
+				break
+			}
+		}
+		if _, ok := s.files[resource]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnavailable, resource)
+		}
+	}
+	return s, nil
+}
+
+func (s *fileSource) readFile(
+	file string,
+) (float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func (s *fileSource) Read(
+	resource Resource,
+) (float64, error) {
+	file, ok := s.files[resource]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnavailable, resource)
+	}
+	return s.readFile(file)
+}
+
+func NewMonitor(
+	source Source,
+	threshold float64,
+) *Monitor {
+	return &Monitor{
+		source:    source,
+		threshold: threshold,
+		readings:  make(map[Resource]float64),
+	}
+}
+
+// Check reads the pressure of all resources, and returns `true` if throttling started or stopped.
+func (m *Monitor) Check() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := []error{}
+	maxPressure := 0.0
+	for _, resource := range Resources {
+		value, err := m.source.Read(resource)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.readings[resource] = value
+		maxPressure = max(maxPressure, value)
+	}
+	// without readings, the current state is kept
+	if len(errs) == len(Resources) {
+		return false, errors.Join(errs...)
+	}
+
+	throttled := m.throttled.Load()
+	if !throttled && maxPressure >= m.threshold {
+		m.throttled.Store(true)
+		return true, errors.Join(errs...)
+	}
+	if throttled && maxPressure < m.threshold/2 {
+		m.throttled.Store(false)
+		return true, errors.Join(errs...)
+	}
+	return false, errors.Join(errs...)
+}
+
+// Throttled reports whether the exporter should reduce its impact on the main application.
+func (m *Monitor) Throttled() bool {
+	return m.throttled.Load()
+}
+
+func (m *Monitor) Readings() map[Resource]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.readings)
+}
+
+func (m *Monitor) Threshold() float64 {
+	return m.threshold
+}
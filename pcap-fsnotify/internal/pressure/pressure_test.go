@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pressure
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeSource map[Resource]float64
+
+func (s fakeSource) Read(resource Resource) (float64, error) {
+	if value, ok := s[resource]; ok {
+		return value, nil
+	}
+	return 0, ErrUnavailable
+}
+
+// TestParse verifies that the `some avg10` value is extracted from PSI files.
+func TestParse(t *testing.T) {
+	psi := "some avg10=12.50 avg60=3.00 avg300=1.00 total=123\nfull avg10=99.00 avg60=0.00 avg300=0.00 total=0\n"
+	if got, err := Parse(strings.NewReader(psi)); err != nil || got != 12.5 {
+		t.Errorf("Parse = %v, %v; want 12.5", got, err)
+	}
+	if _, err := Parse(strings.NewReader("full avg10=1.00\n")); err == nil {
+		t.Error("Parse without 'some' succeeded, want error")
+	}
+}
+
+// TestMonitorHysteresis verifies that throttling starts at the threshold,
+// and only stops when pressure drops below half of it.
+func TestMonitorHysteresis(t *testing.T) {
+	source := fakeSource{RESOURCE_CPU: 0, RESOURCE_MEMORY: 0, RESOURCE_IO: 0}
+	m := NewMonitor(source, 40)
+
+	steps := []struct {
+		resource      Resource
+		value         float64
+		wantChanged   bool
+		wantThrottled bool
+	}{
+		{RESOURCE_CPU, 10, false, false},
+		{RESOURCE_IO, 40, true, true},
+		{RESOURCE_IO, 30, false, true}, // below threshold, above hysteresis
+		{RESOURCE_IO, 19, true, false},
+		{RESOURCE_MEMORY, 39, false, false},
+		{RESOURCE_MEMORY, 80, true, true},
+		{RESOURCE_MEMORY, 80, false, true},
+	}
+
+	for i, step := range steps {
+		source[step.resource] = step.value
+		changed, err := m.Check()
+		if err != nil {
+			t.Fatalf("step %d: Check failed: %v", i, err)
+		}
+		if changed != step.wantChanged || m.Throttled() != step.wantThrottled {
+			t.Errorf("step %d: %s=%v => changed=%v throttled=%v; want changed=%v throttled=%v",
+				i, step.resource, step.value, changed, m.Throttled(), step.wantChanged, step.wantThrottled)
+		}
+	}
+
+	if got := m.Readings()[RESOURCE_MEMORY]; got != 80 {
+		t.Errorf("Readings()[memory] = %v, want 80", got)
+	}
+}
+
+// TestMonitorWithoutReadings verifies that the state is kept when no resource can be read.
+func TestMonitorWithoutReadings(t *testing.T) {
+	source := fakeSource{RESOURCE_CPU: 90}
+	m := NewMonitor(source, 40)
+
+	if changed, _ := m.Check(); !changed || !m.Throttled() {
+		t.Fatal("partial readings: want throttling")
+	}
+	delete(source, RESOURCE_CPU)
+	if changed, err := m.Check(); changed || !m.Throttled() || !errors.Is(err, ErrUnavailable) {
+		t.Errorf("no readings: changed=%v throttled=%v err=%v; want state kept", changed, m.Throttled(), err)
+	}
+}
+
+// TestFileSource verifies that cgroup PSI files are preferred, and that missing PSI disables the source.
+func TestFileSource(t *testing.T) {
+	cgroupDir, procDir := t.TempDir(), t.TempDir()
+	write := func(file, value string) {
+		if err := os.WriteFile(file, []byte("some avg10="+value+" avg60=0.00 avg300=0.00 total=0\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(cgroupDir, "cpu.pressure"), "1.00")
+	write(filepath.Join(procDir, "cpu.pressure"), "2.00")
+	write(filepath.Join(procDir, "memory.pressure"), "3.00")
+
+	if _, err := newFileSource([]string{cgroupDir, procDir}); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("newFileSource without io PSI = %v, want %v", err, ErrUnavailable)
+	}
+
+	write(filepath.Join(procDir, "io.pressure"), "4.00")
+	source, err := newFileSource([]string{cgroupDir, procDir})
+	if err != nil {
+		t.Fatalf("newFileSource failed: %v", err)
+	}
+	for resource, want := range map[Resource]float64{RESOURCE_CPU: 1, RESOURCE_MEMORY: 3, RESOURCE_IO: 4} {
+		if got, err := source.Read(resource); err != nil || got != want {
+			t.Errorf("Read(%s) = %v, %v; want %v", resource, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rotation tracks PCAP files rotations to detect the ones that never showed up.
+package rotation
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	Gap struct {
+		Previous time.Time
+		Current  time.Time
+		// Missing is the number of rotations between `Previous` and `Current`
+		Missing uint64
+		// Total is the number of missing rotations observed for the same key
+		Total uint64
+	}
+
+	GapDetector struct {
+		interval time.Duration
+		mu       sync.Mutex
+		last     map[string]time.Time
+		missing  map[string]uint64
+	}
+)
+
+func NewGapDetector(interval time.Duration) *GapDetector {
+	return &GapDetector{
+		interval: interval,
+		last:     make(map[string]time.Time),
+		missing:  make(map[string]uint64),
+	}
+}
+
+func (d *GapDetector) Interval() time.Duration {
+	return d.interval
+}
+
+// Observe records the rotation timestamp of a PCAP file for `key`,
+// and returns the gap since the previous rotation, if any.
+func (d *GapDetector) Observe(key string, ts time.Time) (*Gap, bool) {
+	if d.interval <= 0 {
+		return nil, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous, ok := d.last[key]
+	// out of order PCAP files must not move the last rotation back
+	if !ok || ts.After(previous) {
+		d.last[key] = ts
+	}
+	if !ok || !ts.After(previous) {
+		return nil, false
+	}
+
+	// rotations are not exact: round to the closest number of intervals
+	elapsed := ts.Sub(previous)
+	rotations := (elapsed + d.interval/2) / d.interval
+	if rotations <= 1 {
+		return nil, false
+	}
+
+	missing := uint64(rotations - 1)
+	d.missing[key] += missing
+
+	return &Gap{
+		Previous: previous,
+		Current:  ts,
+		Missing:  missing,
+		Total:    d.missing[key],
+	}, true
+}
+
+// Reset forgets the last rotations, i.e.: when a new capture session starts; totals are kept.
+// Rebase makes `ts` the last rotation of `key` even if it is older, i.e.: when rotation timestamps went back.
+func (d *GapDetector) Rebase(key string, ts time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last[key] = ts
+}
+
+func (d *GapDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.last)
+}
+
+// Missing returns the number of missing rotations observed for each key.
+func (d *GapDetector) Missing() map[string]uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	missing := make(map[string]uint64, len(d.missing))
+	for key, count := range d.missing {
+		missing[key] = count
+	}
+	return missing
+}
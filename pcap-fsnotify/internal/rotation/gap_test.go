@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rotation
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGapDetector verifies that missing rotations are counted per key, tolerating rotation jitter.
+func TestGapDetector(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewGapDetector(time.Minute)
+
+	steps := []struct {
+		key     string
+		offset  time.Duration
+		missing uint64
+	}{
+		{"1/eth0/pcap", 0, 0},
+		{"1/eth0/pcap", 61 * time.Second, 0},
+		{"1/eth0/pcap", 180 * time.Second, 1},
+		{"2/lo/pcap", 0, 0},
+		{"1/eth0/pcap", 419 * time.Second, 3},
+		{"1/eth0/pcap", 300 * time.Second, 0}, // out of order
+		{"2/lo/pcap", 89 * time.Second, 0},
+	}
+
+	for i, step := range steps {
+		gap, ok := d.Observe(step.key, start.Add(step.offset))
+		if step.missing == 0 && ok {
+			t.Errorf("step %d: unexpected gap: %+v", i, gap)
+		} else if step.missing > 0 && (!ok || gap.Missing != step.missing) {
+			t.Errorf("step %d: got %+v, want %d missing", i, gap, step.missing)
+		}
+	}
+
+	if missing := d.Missing(); missing["1/eth0/pcap"] != 4 || missing["2/lo/pcap"] != 0 {
+		t.Errorf("unexpected totals: %v", missing)
+	}
+}
+
+// TestGapDetectorRebase verifies that once rotation timestamps went back, gaps are measured from the new ones.
+func TestGapDetectorRebase(t *testing.T) {
+	start := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	d := NewGapDetector(time.Minute)
+
+	d.Observe("1/eth0/pcap", start)
+	reset := start.Add(-time.Hour)
+	d.Rebase("1/eth0/pcap", reset)
+
+	if gap, ok := d.Observe("1/eth0/pcap", reset.Add(time.Minute)); ok {
+		t.Errorf("unexpected gap after rebase: %+v", gap)
+	}
+	if gap, ok := d.Observe("1/eth0/pcap", reset.Add(3*time.Minute)); !ok || gap.Missing != 1 {
+		t.Errorf("got %+v, want 1 missing", gap)
+	}
+}
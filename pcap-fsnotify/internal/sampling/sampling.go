@@ -0,0 +1,213 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sampling decides once per session whether its PCAP files are exported with full fidelity,
+// so that long-term storage is bounded while sampled sessions remain statistically useful.
+package sampling
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/pcapfile"
+)
+
+type (
+	// Mode is what unsampled sessions export.
+	Mode string
+
+	// Seed is the source of the sampling decision.
+	Seed string
+
+	Decision struct {
+		Sampled bool    `json:"sampled"`
+		Rate    float64 `json:"rate"`
+		Mode    Mode    `json:"mode"`
+		Seed    Seed    `json:"seed"`
+		// Value is uniformly distributed in `[0,1)`: sessions are sampled when it is lower than `Rate`
+		Value float64 `json:"value"`
+		// Forced sessions are sampled regardless of `Value`
+		Forced bool `json:"forced"`
+	}
+
+	// Sampler holds the decision of the current session; it is safe for concurrent use.
+	Sampler struct {
+		mu        sync.Mutex
+		decision  Decision
+		exporting bool
+	}
+)
+
+const (
+	MODE_DROP    = Mode("drop")
+	MODE_HEADERS = Mode("headers")
+
+	// SEED_INSTANCE hashes the instance ID: re-deploys of the same instance make the same decision
+	SEED_INSTANCE = Seed("instance")
+	SEED_RANDOM   = Seed("random")
+
+	truncatedFilePrefix = ".pcapfsn-sample-"
+)
+
+var ErrAlreadyExporting = errors.New("PCAP files of this session are already being exported")
+
+// valueOf maps `key` to a value uniformly distributed in `[0,1)`.
+func valueOf(
+	key string,
+) float64 {
+	// instance IDs differ in a few characters only: the hash must be uniform regardless
+	sum := sha256.Sum256([]byte(key))
+	// the 53 most significant bits fit exactly in a `float64` mantissa
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// NewDecision evaluates whether the session is sampled: `key` is hashed when `seed` is `SEED_INSTANCE`,
+// otherwise `random` is used. Rates are comparable: a session sampled at some rate is also sampled at higher rates.
+func NewDecision(
+	rate float64,
+	mode Mode,
+	seed Seed,
+	key string,
+	random func() float64,
+) Decision {
+	var value float64
+	if seed == SEED_INSTANCE && key != "" {
+		value = valueOf(key)
+	} else {
+		value = random()
+	}
+	return Decision{
+		Sampled: value < rate,
+		Rate:    rate,
+		Mode:    mode,
+		Seed:    seed,
+		Value:   value,
+	}
+}
+
+// Metadata describes the decision using string values, so that it can be attached to exported artifacts
+// and downstream consumers can correct for sampling.
+func (d Decision) Metadata() map[string]string {
+	return map[string]string{
+		"sampled":       strconv.FormatBool(d.Sampled),
+		"sample-rate":   strconv.FormatFloat(d.Rate, 'g', -1, 64),
+		"sample-mode":   string(d.Mode),
+		"sample-seed":   string(d.Seed),
+		"sample-forced": strconv.FormatBool(d.Forced),
+	}
+}
+
+func NewSampler(
+	decision Decision,
+) *Sampler {
+	return &Sampler{decision: decision}
+}
+
+func (s *Sampler) Decision() Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.decision
+}
+
+// Force adds the session to the sampled set for targeted debugging; it is only allowed before its first export,
+// so that all PCAP files of a session have the same fidelity.
+func (s *Sampler) Force() (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.decision.Sampled {
+		return s.decision, nil
+	}
+	if s.exporting {
+		return s.decision, ErrAlreadyExporting
+	}
+	s.decision.Sampled, s.decision.Forced = true, true
+	return s.decision, nil
+}
+
+// Export returns the decision which applies to a PCAP file about to be exported; afterwards, it is final.
+func (s *Sampler) Export() Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exporting = true
+	return s.decision
+}
+
+// Truncate rewrites the PCAP file at `path` as a classic PCAP file which keeps only the first `snaplen` bytes
+// of every packet; packets keep their original length, so that traffic volumes remain accurate.
+func Truncate(
+	path string,
+	snaplen uint32,
+) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	reader, err := pcapfile.NewReader(bufio.NewReader(src))
+	if err != nil {
+		return err
+	}
+
+	// the truncated file is hidden so that it is not matched as a new PCAP file
+	tmpPath := filepath.Join(filepath.Dir(path), truncatedFilePrefix+filepath.Base(path))
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	buffer := bufio.NewWriter(tmp)
+	writer, err := pcapfile.NewWriter(buffer, pcapfile.WriterOptions{
+		Nanosecond: true,
+		Snaplen:    snaplen,
+		LinkType:   reader.LinkType(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		packet, readErr := reader.ReadPacket()
+		if errors.Is(readErr, io.EOF) {
+			break
+		} else if readErr != nil {
+			return fmt.Errorf("failed to read packet: %w", readErr)
+		}
+		if err = writer.WritePacket(packet.Timestamp, packet.Data, packet.Length); err != nil {
+			return err
+		}
+	}
+
+	if err = buffer.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
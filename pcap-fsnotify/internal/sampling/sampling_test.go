@@ -0,0 +1,212 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sampling
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/pcapfile"
+)
+
+func noRandom() float64 {
+	panic("random must not be used")
+}
+
+// TestNewDecisionIsDeterministic verifies that instance seeded decisions only depend on the instance ID and the rate.
+func TestNewDecisionIsDeterministic(t *testing.T) {
+	const instances = 10000
+
+	for _, rate := range []float64{0, 0.05, 0.5, 1} {
+		sampled := 0
+		for i := range instances {
+			key := fmt.Sprintf("instance-%d", i)
+			first := NewDecision(rate, MODE_DROP, SEED_INSTANCE, key, noRandom)
+			if again := NewDecision(rate, MODE_DROP, SEED_INSTANCE, key, noRandom); again != first {
+				t.Fatalf("rate %v: %s decided %+v, then %+v", rate, key, first, again)
+			}
+			// a session sampled at some rate is also sampled at higher rates
+			if first.Sampled && !NewDecision(min(rate*2, 1), MODE_DROP, SEED_INSTANCE, key, noRandom).Sampled {
+				t.Fatalf("rate %v: %s is not sampled at a higher rate", rate, key)
+			}
+			if first.Sampled {
+				sampled++
+			}
+		}
+		// within 1% of the rate
+		if got := float64(sampled) / instances; got < rate-0.01 || got > rate+0.01 {
+			t.Errorf("rate %v: sampled %v of sessions", rate, got)
+		}
+	}
+}
+
+// TestNewDecisionRandom verifies that random seeded decisions, and instances without ID, use `random`.
+func TestNewDecisionRandom(t *testing.T) {
+	tests := []struct {
+		name  string
+		seed  Seed
+		key   string
+		value float64
+		want  bool
+	}{
+		{"random sampled", SEED_RANDOM, "instance-1", 0.01, true},
+		{"random unsampled", SEED_RANDOM, "instance-1", 0.5, false},
+		{"no instance ID", SEED_INSTANCE, "", 0.01, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := NewDecision(0.05, MODE_HEADERS, tc.seed, tc.key, func() float64 { return tc.value })
+			if got.Sampled != tc.want || got.Value != tc.value || got.Mode != MODE_HEADERS || got.Rate != 0.05 {
+				t.Errorf("got %+v, want sampled=%v with value %v", got, tc.want, tc.value)
+			}
+		})
+	}
+}
+
+// TestForce verifies that sessions can only be forced into the sampled set before their first export.
+func TestForce(t *testing.T) {
+	unsampled := Decision{Rate: 0.05, Mode: MODE_DROP, Seed: SEED_INSTANCE, Value: 0.9}
+
+	s := NewSampler(unsampled)
+	decision, err := s.Force()
+	if err != nil || !decision.Sampled || !decision.Forced {
+		t.Fatalf("Force() = %+v, %v, want a forced sampled decision", decision, err)
+	}
+	if got := s.Export(); !got.Sampled || !got.Forced {
+		t.Errorf("Export() = %+v, want the forced decision", got)
+	}
+
+	s = NewSampler(unsampled)
+	s.Export()
+	if decision, err := s.Force(); !errors.Is(err, ErrAlreadyExporting) || decision.Sampled {
+		t.Errorf("Force() after Export() = %+v, %v, want %v", decision, err, ErrAlreadyExporting)
+	}
+
+	// forcing a sampled session is a no-op, even after exports started
+	s = NewSampler(Decision{Sampled: true, Rate: 0.05})
+	s.Export()
+	if decision, err := s.Force(); err != nil || !decision.Sampled || decision.Forced {
+		t.Errorf("Force() of a sampled session = %+v, %v", decision, err)
+	}
+}
+
+// TestTruncate verifies that packets are truncated to the snaplen, and that their original length is kept.
+func TestTruncate(t *testing.T) {
+	const snaplen = 64
+
+	var pcap bytes.Buffer
+	writer, err := pcapfile.NewWriter(&pcap, pcapfile.WriterOptions{LinkType: pcapfile.LINKTYPE_ETHERNET})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 0)
+	frames := [][]byte{}
+	for _, payloadSize := range []int{0, 100, 1400} {
+		frame, err := pcapfile.Synthesize(pcapfile.PacketSpec{
+			FiveTuple: pcapfile.FiveTuple{
+				Src:       netip.MustParseAddr("10.0.0.1"),
+				Dst:       netip.MustParseAddr("10.0.0.2"),
+				SrcPort:   40000,
+				DstPort:   443,
+				Transport: pcapfile.TRANSPORT_TCP,
+			},
+			TCPFlags:    pcapfile.TCP_ACK,
+			PayloadSize: payloadSize,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+		if err := writer.WritePacket(ts, frame, len(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eth0.pcap")
+	if err := os.WriteFile(path, pcap.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Truncate(path, snaplen); err != nil {
+		t.Fatalf("Truncate() = %v", err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, want the truncated PCAP file only", len(entries))
+	}
+
+	truncated, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer truncated.Close()
+	reader, err := pcapfile.NewReader(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, frame := range frames {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		want := frame[:min(len(frame), snaplen)]
+		if !bytes.Equal(packet.Data, want) || packet.Length != len(frame) || !packet.Timestamp.Equal(ts) {
+			t.Errorf("packet %d: got %d/%d bytes, want %d/%d", i, len(packet.Data), packet.Length, len(want), len(frame))
+		}
+	}
+	if _, err := reader.ReadPacket(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v, want %v", err, io.EOF)
+	}
+}
+
+// TestTruncateInvalidFile verifies that files which are not PCAP files are left untouched.
+func TestTruncateInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eth0.pcap")
+	if err := os.WriteFile(path, []byte("not a PCAP file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Truncate(path, 64); err == nil {
+		t.Error("Truncate() = nil, want an error")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "not a PCAP file" {
+		t.Errorf("file was modified: %q", data)
+	}
+}
+
+// TestDecisionMetadata verifies the metadata attached to exported artifacts.
+func TestDecisionMetadata(t *testing.T) {
+	d := Decision{Sampled: true, Rate: 0.05, Mode: MODE_HEADERS, Seed: SEED_INSTANCE, Forced: true}
+	want := map[string]string{
+		"sampled":       "true",
+		"sample-rate":   "0.05",
+		"sample-mode":   "headers",
+		"sample-seed":   "instance",
+		"sample-forced": "true",
+	}
+	if got := d.Metadata(); !maps.Equal(got, want) {
+		t.Errorf("Metadata() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import "time"
+
+type (
+	Timer interface {
+		C() <-chan time.Time
+		Stop() bool
+	}
+
+	Clock interface {
+		Now() time.Time
+		NewTimer(d time.Duration) Timer
+	}
+
+	realClock struct{}
+
+	realTimer struct {
+		*time.Timer
+	}
+)
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func (c *realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{time.NewTimer(d)}
+}
+
+func NewRealClock() Clock {
+	return &realClock{}
+}
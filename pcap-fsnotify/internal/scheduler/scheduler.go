@@ -0,0 +1,305 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	TaskFunc func(ctx context.Context) error
+
+	Task struct {
+		Name     string
+		Interval time.Duration
+		// Jitter is the maximum random delay added to every execution
+		Jitter time.Duration
+		// Timeout is the maximum duration of every execution; `0` means no timeout
+		Timeout time.Duration
+		Run     TaskFunc
+	}
+
+	Outcome string
+
+	TaskStatus struct {
+		Name     string    `json:"name"`
+		Runs     uint64    `json:"runs"`
+		Skipped  uint64    `json:"skipped"`
+		LastRun  time.Time `json:"last_run,omitempty"`
+		NextRun  time.Time `json:"next_run,omitempty"`
+		Duration string    `json:"duration,omitempty"`
+		Outcome  Outcome   `json:"outcome,omitempty"`
+		Error    string    `json:"error,omitempty"`
+	}
+
+	// OnSkipFunc is called when an execution is skipped because the previous one is still running
+	OnSkipFunc func(task *Task)
+
+	scheduledTask struct {
+		*Task
+		running atomic.Bool
+		mu      sync.Mutex
+		status  TaskStatus
+	}
+
+	Scheduler struct {
+		clock   Clock
+		onSkip  OnSkipFunc
+		mu      sync.Mutex
+		tasks   map[string]*scheduledTask
+		started bool
+		ctx     context.Context
+		cancel  context.CancelFunc
+		wg      sync.WaitGroup
+	}
+
+	triggerKey struct{}
+)
+
+const (
+	// TRIGGER_TIMER is the trigger of periodic executions
+	TRIGGER_TIMER = "timer"
+
+	OUTCOME_SUCCESS = Outcome("success")
+	OUTCOME_FAILURE = Outcome("failure")
+	OUTCOME_TIMEOUT = Outcome("timeout")
+)
+
+var (
+	ErrInvalidTask      = errors.New("invalid task")
+	ErrDuplicateTask    = errors.New("task already registered")
+	ErrSchedulerStarted = errors.New("scheduler already started")
+	noopOnSkip          = func(*Task) {}
+)
+
+func NewScheduler(
+	clock Clock,
+	onSkip OnSkipFunc,
+) *Scheduler {
+	if clock == nil {
+		clock = NewRealClock()
+	}
+	if onSkip == nil {
+		onSkip = noopOnSkip
+	}
+	return &Scheduler{
+		clock:  clock,
+		onSkip: onSkip,
+		tasks:  make(map[string]*scheduledTask),
+	}
+}
+
+// Register adds a periodic task; tasks must be registered before the scheduler is started.
+func (s *Scheduler) Register(task *Task) error {
+	if task == nil || task.Name == "" || task.Interval <= 0 || task.Run == nil {
+		return ErrInvalidTask
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrSchedulerStarted
+	}
+	if _, ok := s.tasks[task.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateTask, task.Name)
+	}
+	s.tasks[task.Name] = &scheduledTask{
+		Task:   task,
+		status: TaskStatus{Name: task.Name},
+	}
+	return nil
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, task := range s.tasks {
+		s.wg.Add(1)
+		go s.schedule(s.ctx, task)
+	}
+}
+
+// Stop prevents all future executions and waits for the running ones to complete.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	// cancelling while holding the lock guarantees that `Trigger` never starts executions after `Stop`
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Trigger runs the task now, in addition to its periodic executions; same as periodic executions, it is skipped
+// if the previous execution is still running. It returns `true` if the task was started.
+func (s *Scheduler) Trigger(
+	name, trigger string,
+) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[name]
+	if !ok || !s.started || s.ctx.Err() != nil {
+		return false
+	}
+	if !task.running.CompareAndSwap(false, true) {
+		task.mu.Lock()
+		task.status.Skipped += 1
+		task.mu.Unlock()
+		s.onSkip(task.Task)
+		return false
+	}
+	s.wg.Add(1)
+	go s.run(s.ctx, task, trigger)
+	return true
+}
+
+// TriggerOf returns what started the execution of the task running with `ctx`.
+func TriggerOf(
+	ctx context.Context,
+) string {
+	if trigger, ok := ctx.Value(triggerKey{}).(string); ok {
+		return trigger
+	}
+	return TRIGGER_TIMER
+}
+
+func (s *Scheduler) Status() []TaskStatus {
+	s.mu.Lock()
+	tasks := make([]*scheduledTask, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	s.mu.Unlock()
+
+	statuses := make([]TaskStatus, 0, len(tasks))
+	for _, task := range tasks {
+		task.mu.Lock()
+		statuses = append(statuses, task.status)
+		task.mu.Unlock()
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+func (t *scheduledTask) jitter() time.Duration {
+	if t.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(t.Jitter)
+}
+
+func (t *scheduledTask) setNextRun(next time.Time) {
+	t.mu.Lock()
+	t.status.NextRun = next
+	t.mu.Unlock()
+}
+
+func (s *Scheduler) schedule(
+	ctx context.Context,
+	task *scheduledTask,
+) {
+	defer s.wg.Done()
+
+	next := s.clock.Now().Add(task.Interval)
+	for {
+		due := next.Add(task.jitter())
+		task.setNextRun(due)
+
+		timer := s.clock.NewTimer(due.Sub(s.clock.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		if ctx.Err() != nil {
+			// the timer and the cancellation may be ready at once: executions never start after cancellation
+			return
+		}
+
+		// same as `time.Ticker`: executions are never queued
+		if task.running.CompareAndSwap(false, true) {
+			s.wg.Add(1)
+			go s.run(ctx, task, TRIGGER_TIMER)
+		} else {
+			task.mu.Lock()
+			task.status.Skipped += 1
+			task.mu.Unlock()
+			s.onSkip(task.Task)
+		}
+
+		next = next.Add(task.Interval)
+		if now := s.clock.Now(); next.Before(now) {
+			next = now.Add(task.Interval)
+		}
+	}
+}
+
+func (s *Scheduler) run(
+	ctx context.Context,
+	task *scheduledTask,
+	trigger string,
+) {
+	defer s.wg.Done()
+	defer task.running.Store(false)
+
+	runCtx := context.WithValue(ctx, triggerKey{}, trigger)
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
+	}
+
+	start := s.clock.Now()
+	err := task.Run(runCtx)
+	duration := s.clock.Now().Sub(start)
+
+	outcome := OUTCOME_SUCCESS
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		outcome = OUTCOME_TIMEOUT
+	} else if err != nil {
+		outcome = OUTCOME_FAILURE
+	}
+
+	task.mu.Lock()
+	defer task.mu.Unlock()
+	task.status.Runs += 1
+	task.status.LastRun = start
+	task.status.Duration = duration.String()
+	task.status.Outcome = outcome
+	task.status.Error = ""
+	if err != nil {
+		task.status.Error = err.Error()
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type (
+	fakeTimer struct {
+		clock    *fakeClock
+		deadline time.Time
+		c        chan time.Time
+		stopped  bool
+	}
+
+	fakeClock struct {
+		mu     sync.Mutex
+		now    time.Time
+		timers []*fakeTimer
+	}
+)
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+	return true
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// waitForTimers blocks until `n` timers are pending.
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		c.mu.Lock()
+		pending := 0
+		for _, timer := range c.timers {
+			if !timer.stopped {
+				pending += 1
+			}
+		}
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d timers", n)
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	timers := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.stopped {
+			continue
+		}
+		if !timer.deadline.After(c.now) {
+			timer.stopped = true
+			timer.c <- c.now
+			continue
+		}
+		timers = append(timers, timer)
+	}
+	c.timers = timers
+}
+
+// TestSchedulerRunsEveryInterval verifies that a task runs once per interval, just like `time.Ticker`.
+func TestSchedulerRunsEveryInterval(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	s := NewScheduler(clock, nil)
+
+	runs := make(chan struct{}, 10)
+	if err := s.Register(&Task{
+		Name:     "task",
+		Interval: time.Minute,
+		Run: func(context.Context) error {
+			runs <- struct{}{}
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Start(context.Background())
+	defer s.Stop()
+
+	for i := 0; i < 3; i++ {
+		clock.waitForTimers(t, 1)
+		clock.Advance(time.Minute)
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d did not happen", i+1)
+		}
+	}
+
+	clock.waitForTimers(t, 1)
+	status := s.Status()[0]
+	if status.Runs != 3 || status.Outcome != OUTCOME_SUCCESS {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if want := time.Unix(0, 0).Add(4 * time.Minute); !status.NextRun.Equal(want) {
+		t.Errorf("next run: got %v, want %v", status.NextRun, want)
+	}
+}
+
+// TestSchedulerSkipsOverlappingRuns verifies that executions are skipped while the previous one is still running.
+func TestSchedulerSkipsOverlappingRuns(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	skipped := make(chan string, 10)
+	s := NewScheduler(clock, func(task *Task) { skipped <- task.Name })
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	if err := s.Register(&Task{
+		Name:     "slow",
+		Interval: time.Second,
+		Run: func(context.Context) error {
+			started <- struct{}{}
+			<-release
+			return errors.New("failed")
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Start(context.Background())
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Second)
+	<-started
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Second)
+	select {
+	case name := <-skipped:
+		if name != "slow" {
+			t.Errorf("skipped task: got %s, want slow", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("overlapping run was not skipped")
+	}
+
+	close(release)
+	clock.waitForTimers(t, 1)
+	s.Stop()
+
+	status := s.Status()[0]
+	if status.Runs != 1 || status.Skipped != 1 || status.Outcome != OUTCOME_FAILURE || status.Error != "failed" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+// TestSchedulerDoesNotRunAfterStop verifies that executions never start once the context is cancelled,
+// even when the timer fires at the same time.
+func TestSchedulerDoesNotRunAfterStop(t *testing.T) {
+	// with a single P, the scheduler goroutine only wakes up once both the timer and the cancellation are ready
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	for i := 0; i < 100; i++ {
+		clock := newFakeClock()
+		s := NewScheduler(clock, nil)
+
+		var runs atomic.Int32
+		if err := s.Register(&Task{
+			Name:     "task",
+			Interval: time.Second,
+			Run: func(context.Context) error {
+				runs.Add(1)
+				return nil
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s.Start(ctx)
+		clock.waitForTimers(t, 1)
+
+		clock.Advance(time.Second)
+		cancel()
+		s.Stop()
+
+		if n := runs.Load(); n != 0 {
+			t.Fatalf("iteration %d: task ran %d times after cancellation", i, n)
+		}
+	}
+}
+
+// TestTrigger verifies that triggered executions run in addition to periodic ones, report their trigger,
+// are skipped while the previous execution is still running, and never start after `Stop`.
+func TestTrigger(t *testing.T) {
+	t.Parallel()
+
+	clock := newFakeClock()
+	s := NewScheduler(clock, nil)
+
+	release := make(chan struct{})
+	triggers := make(chan string, 10)
+	if err := s.Register(&Task{
+		Name:     "task",
+		Interval: time.Second,
+		Run: func(ctx context.Context) error {
+			triggers <- TriggerOf(ctx)
+			<-release
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Trigger("task", "manual") {
+		t.Error("Trigger() must not run tasks before the scheduler is started")
+	}
+	s.Start(context.Background())
+	if s.Trigger("unknown", "manual") {
+		t.Error("Trigger() must not run unknown tasks")
+	}
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Second)
+	if got := <-triggers; got != TRIGGER_TIMER {
+		t.Errorf("trigger = %s, want %s", got, TRIGGER_TIMER)
+	}
+	if s.Trigger("task", "manual") {
+		t.Error("Trigger() must skip the task while it is running")
+	}
+	release <- struct{}{}
+
+	// the periodic execution completes asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.Trigger("task", "manual") {
+		if time.Now().After(deadline) {
+			t.Fatal("Trigger() = false after the previous execution completed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := <-triggers; got != "manual" {
+		t.Errorf("trigger = %s, want manual", got)
+	}
+	close(release)
+
+	s.Stop()
+	if s.Trigger("task", "manual") {
+		t.Error("Trigger() must not run tasks after Stop()")
+	}
+	if status := s.Status()[0]; status.Runs != 2 || status.Skipped == 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+// TestRegisterRejectsInvalidTasks verifies task validation and duplicated names.
+func TestRegisterRejectsInvalidTasks(t *testing.T) {
+	t.Parallel()
+
+	noop := func(context.Context) error { return nil }
+	s := NewScheduler(newFakeClock(), nil)
+
+	if err := s.Register(&Task{Name: "task", Run: noop}); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("zero interval: got %v, want %v", err, ErrInvalidTask)
+	}
+	if err := s.Register(&Task{Name: "task", Interval: time.Second, Run: noop}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(&Task{Name: "task", Interval: time.Second, Run: noop}); !errors.Is(err, ErrDuplicateTask) {
+		t.Errorf("duplicated task: got %v, want %v", err, ErrDuplicateTask)
+	}
+
+	s.Start(context.Background())
+	defer s.Stop()
+
+	if err := s.Register(&Task{Name: "other", Interval: time.Second, Run: noop}); !errors.Is(err, ErrSchedulerStarted) {
+		t.Errorf("started scheduler: got %v, want %v", err, ErrSchedulerStarted)
+	}
+}
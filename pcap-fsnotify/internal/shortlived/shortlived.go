@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shortlived optimizes capture sessions of instances that live shorter than one rotation interval,
+// which would otherwise stop before exporting any PCAP file, and explains sessions that exported nothing.
+package shortlived
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	Mode string
+
+	// Decision is whether the short-lifetime optimizations apply to the current session, and why.
+	Decision struct {
+		Enabled  bool   `json:"enabled"`
+		Mode     Mode   `json:"mode"`
+		Reason   string `json:"reason"`
+		Lifetime string `json:"lifetime"`
+	}
+
+	CapturedFile struct {
+		Name  string `json:"name"`
+		Bytes int64  `json:"bytes"`
+	}
+
+	// Manifest explains a session that exported no data: what was captured, and why it was lost.
+	Manifest struct {
+		Session       string         `json:"session"`
+		Started       time.Time      `json:"started"`
+		Stopped       time.Time      `json:"stopped"`
+		Cause         string         `json:"cause"`
+		Captured      []CapturedFile `json:"captured"`
+		CapturedBytes int64          `json:"captured_bytes"`
+		ExportedBytes int64          `json:"exported_bytes"`
+	}
+
+	// ExportFunc exports the file at `path` into the destination directory.
+	ExportFunc func(ctx context.Context, path string) error
+)
+
+const (
+	MODE_AUTO = Mode("auto")
+	MODE_ON   = Mode("on")
+	MODE_OFF  = Mode("off")
+
+	// the manifest does not match PCAP files, so it is never exported as one
+	ManifestName = "session.lost.json"
+)
+
+func ParseMode(
+	mode string,
+) (Mode, error) {
+	switch m := Mode(strings.ToLower(mode)); m {
+	case MODE_AUTO, MODE_ON, MODE_OFF:
+		return m, nil
+	default:
+		return MODE_AUTO, fmt.Errorf("invalid short-lived mode: %s", mode)
+	}
+}
+
+// Decide enables the optimizations in `auto` mode when the runtime is Cloud Run,
+// and PCAP files are rotated less often than instances are expected to live.
+func Decide(
+	mode Mode,
+	runtime string,
+	rotation, lifetime time.Duration,
+) Decision {
+	d := Decision{Mode: mode, Lifetime: lifetime.String()}
+	switch {
+	case mode == MODE_ON:
+		d.Enabled, d.Reason = true, "forced"
+	case mode == MODE_OFF:
+		d.Reason = "disabled"
+	case !strings.HasPrefix(runtime, "cloud_run"):
+		d.Reason = fmt.Sprintf("runtime is not Cloud Run: %s", runtime)
+	case lifetime <= 0 || rotation <= lifetime:
+		d.Reason = fmt.Sprintf("rotation interval (%v) does not exceed instance lifetime (%v)", rotation, lifetime)
+	default:
+		d.Enabled = true
+		d.Reason = fmt.Sprintf("rotation interval (%v) exceeds instance lifetime (%v)", rotation, lifetime)
+	}
+	return d
+}
+
+// Captured lists the files of `dir` accepted by `match`; unreadable entries are skipped.
+func Captured(
+	dir string,
+	match func(path string) bool,
+) ([]CapturedFile, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0
+	}
+	files := []CapturedFile{}
+	total := int64(0)
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || !match(path) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, CapturedFile{Name: entry.Name(), Bytes: info.Size()})
+			total += info.Size()
+		}
+	}
+	return files, total
+}
+
+// Export writes the manifest into `dir`, and exports it using `export`. When this fails the destination is unavailable,
+// and the manifest must be logged instead so that the absence of data remains explainable.
+func (m *Manifest) Export(
+	ctx context.Context,
+	dir string,
+	export ExportFunc,
+) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, ManifestName)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return err
+	}
+	// the manifest is deleted by a successful export
+	defer os.Remove(path)
+	return export(ctx, path)
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shortlived
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mode     Mode
+		runtime  string
+		rotation time.Duration
+		want     bool
+	}{
+		{"auto with long rotation", MODE_AUTO, "cloud_run_gen2", 5 * time.Minute, true},
+		{"auto with short rotation", MODE_AUTO, "cloud_run_gen2", time.Minute, false},
+		{"auto outside Cloud Run", MODE_AUTO, "gke", 5 * time.Minute, false},
+		{"forced", MODE_ON, "gke", time.Minute, true},
+		{"disabled", MODE_OFF, "cloud_run_gen1", 5 * time.Minute, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Decide(tc.mode, tc.runtime, tc.rotation, 90*time.Second); got.Enabled != tc.want || got.Reason == "" {
+				t.Errorf("Decide() = %+v, want enabled=%v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := ParseMode("sometimes"); err == nil {
+		t.Error("ParseMode() must fail for unknown modes")
+	}
+}
+
+// TestManifestExport verifies that the manifest lists captured files, is removed once exported,
+// and reports the failure when the destination is unavailable.
+func TestManifestExport(t *testing.T) {
+	srcDir := t.TempDir()
+	for name, content := range map[string]string{"part__1_eth0__20240101T000000.pcap": "pcap", "tcpdumpw.ready": ""} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	captured, capturedBytes := Captured(srcDir, func(path string) bool { return strings.HasSuffix(path, ".pcap") })
+	if len(captured) != 1 || capturedBytes != 4 {
+		t.Fatalf("Captured() = %v, %d", captured, capturedBytes)
+	}
+	manifest := &Manifest{Session: "instance", Captured: captured, CapturedBytes: capturedBytes}
+
+	dstDir := t.TempDir()
+	copyTo := func(_ context.Context, path string) error {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dstDir, filepath.Base(path)), content, 0o644)
+	}
+	if err := manifest.Export(context.Background(), t.TempDir(), copyTo); err != nil {
+		t.Fatalf("Export() = %v", err)
+	}
+	var exported Manifest
+	if content, err := os.ReadFile(filepath.Join(dstDir, ManifestName)); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(content, &exported); err != nil || exported.CapturedBytes != 4 {
+		t.Errorf("exported manifest = %+v, %v", exported, err)
+	}
+
+	unavailable := errors.New("destination unavailable")
+	workDir := t.TempDir()
+	if err := manifest.Export(context.Background(), workDir, func(context.Context, string) error { return unavailable }); !errors.Is(err, unavailable) {
+		t.Errorf("Export() = %v, want %v", err, unavailable)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, ManifestName)); !os.IsNotExist(err) {
+		t.Errorf("manifest left behind: %v", err)
+	}
+}
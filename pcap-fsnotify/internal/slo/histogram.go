@@ -0,0 +1,123 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slo
+
+import (
+	"math/bits"
+	"time"
+)
+
+const (
+	// values lower than `linearBuckets` milliseconds are recorded exactly
+	linearBits    = 6
+	linearBuckets = 1 << linearBits
+	// every power of 2 above `linearBuckets` is split into `subBuckets`: ~3% relative error
+	subBucketBits = 5
+	subBuckets    = 1 << subBucketBits
+	// values are capped at 2^32 milliseconds ( ~49 days )
+	maxValueBits = 32
+	maxValue     = uint64(1)<<maxValueBits - 1
+
+	bucketsCount = linearBuckets + (maxValueBits-linearBits)*subBuckets
+)
+
+// Histogram is a fixed-size log-linear histogram of durations with millisecond granularity;
+// it is not safe for concurrent use.
+type Histogram struct {
+	counts [bucketsCount]uint64
+	count  uint64
+	max    uint64
+}
+
+func bucketOf(value uint64) int {
+	if value < linearBuckets {
+		return int(value)
+	}
+	if value > maxValue {
+		value = maxValue
+	}
+	// position of the most significant bit: `linearBits` or higher
+	msb := bits.Len64(value) - 1
+	sub := (value >> (msb - subBucketBits)) & (subBuckets - 1)
+	return linearBuckets + (msb-linearBits)*subBuckets + int(sub)
+}
+
+// lowerBoundOf and upperBoundOf return the range of values that fall into `bucket`.
+func lowerBoundOf(bucket int) uint64 {
+	if bucket < linearBuckets {
+		return uint64(bucket)
+	}
+	bucket -= linearBuckets
+	msb := bucket/subBuckets + linearBits
+	sub := uint64(bucket % subBuckets)
+	return (uint64(1) << msb) | (sub << (msb - subBucketBits))
+}
+
+func upperBoundOf(bucket int) uint64 {
+	if bucket < linearBuckets {
+		return uint64(bucket)
+	}
+	msb := (bucket-linearBuckets)/subBuckets + linearBits
+	return lowerBoundOf(bucket) + (uint64(1) << (msb - subBucketBits)) - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	value := uint64(0)
+	if d > 0 {
+		value = uint64(d.Milliseconds())
+	}
+	if value > maxValue {
+		value = maxValue
+	}
+	h.counts[bucketOf(value)] += 1
+	h.count += 1
+	if value > h.max {
+		h.max = value
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Quantile returns an estimate of the `q` quantile, i.e.: `0.95` for p95.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+
+	rank := uint64(q*float64(h.count) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+
+	seen := uint64(0)
+	for bucket, count := range h.counts {
+		if seen += count; seen >= rank {
+			// the middle of the bucket minimizes the relative error; never report above the max
+			value := (lowerBoundOf(bucket) + upperBoundOf(bucket)) / 2
+			if value > h.max {
+				value = h.max
+			}
+			return time.Duration(value) * time.Millisecond
+		}
+	}
+	return time.Duration(h.max) * time.Millisecond
+}
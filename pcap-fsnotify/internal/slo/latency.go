@@ -0,0 +1,69 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slo
+
+import "time"
+
+const (
+	// the creation time of a PCAP file is the timestamp of the rotation embedded in its name
+	LATENCY_SOURCE_NAME = "name"
+	// the creation time of a PCAP file is the time of its last write, which makes the latency a lower bound
+	LATENCY_SOURCE_STAT = "stat"
+)
+
+// ExportLatency is the time a PCAP file waited between its creation and the completion of its export.
+type ExportLatency struct {
+	Created  time.Time `json:"created"`
+	Exported time.Time `json:"exported"`
+	Latency  string    `json:"latency"`
+	Millis   int64     `json:"millis"`
+	Source   string    `json:"source"`
+	// the timestamp embedded in the name is later than the export: the clock used to name the PCAP file,
+	// or its timezone, disagrees with the wall clock
+	Skewed bool `json:"skewed,omitempty"`
+
+	latency time.Duration
+}
+
+// NewExportLatency computes the export latency of a PCAP file; `named` is the timestamp embedded in its name,
+// and `modified` the time of its last write: either is zero when unknown, and `false` is returned when both are.
+// Skewed timestamps fall back to `modified`; the latency is never negative.
+func NewExportLatency(
+	named, modified, exported time.Time,
+) (ExportLatency, bool) {
+	l := ExportLatency{Exported: exported}
+	switch {
+	case !named.IsZero() && !named.After(exported):
+		l.Created, l.Source = named, LATENCY_SOURCE_NAME
+	case !modified.IsZero():
+		l.Created, l.Source = modified, LATENCY_SOURCE_STAT
+		l.Skewed = !named.IsZero()
+	case !named.IsZero():
+		l.Created, l.Source = exported, LATENCY_SOURCE_NAME
+		l.Skewed = true
+	default:
+		return l, false
+	}
+	if l.Created.Before(exported) {
+		l.latency = exported.Sub(l.Created)
+	}
+	l.Latency = l.latency.String()
+	l.Millis = l.latency.Milliseconds()
+	return l, true
+}
+
+func (l ExportLatency) Duration() time.Duration {
+	return l.latency
+}
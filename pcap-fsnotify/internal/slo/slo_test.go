@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slo
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestHistogramAccuracy verifies percentile estimates against exact values for synthetic export timings.
+func TestHistogramAccuracy(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	h := &Histogram{}
+	latencies := make([]time.Duration, 10000)
+	for i := range latencies {
+		// rotation interval plus a long-tailed export delay
+		latencies[i] = 60*time.Second + time.Duration(rng.ExpFloat64()*float64(5*time.Second))
+		h.Record(latencies[i])
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		exact := latencies[int(q*float64(len(latencies)))-1]
+		got := h.Quantile(q)
+		if err := math.Abs(float64(got-exact)) / float64(exact); err > 0.03 {
+			t.Errorf("p%v: got %v, exact %v ( error %.3f )", q*100, got, exact, err)
+		}
+	}
+}
+
+// TestBucketBounds verifies that every value falls within the bounds of its bucket.
+func TestBucketBounds(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range []uint64{0, 1, 63, 64, 65, 127, 128, 1000, 60000, 3600000, maxValue} {
+		bucket := bucketOf(value)
+		if bucket >= bucketsCount || value < lowerBoundOf(bucket) || value > upperBoundOf(bucket) {
+			t.Errorf("%d: bucket %d [%d, %d]", value, bucket, lowerBoundOf(bucket), upperBoundOf(bucket))
+		}
+	}
+}
+
+// TestBreachWindow verifies that the degraded flag follows the breach ratio within the rolling window.
+func TestBreachWindow(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewTracker(90*time.Second, 0.2, 10)
+
+	for range 8 {
+		tracker.Record("eth0", 61*time.Second)
+	}
+	tracker.Record("eth0", 120*time.Second)
+	tracker.Record("eth0", 120*time.Second)
+	if tracker.Degraded() {
+		t.Error("2/10 breaches must not degrade the SLO")
+	}
+
+	if !tracker.Record("lo", 91*time.Second) || !tracker.Degraded() {
+		t.Error("3/10 breaches must degrade the SLO")
+	}
+
+	// breaches age out of the window
+	for range 10 {
+		tracker.Record("eth0", 61*time.Second)
+	}
+	if tracker.Degraded() {
+		t.Error("breaches must age out of the window")
+	}
+
+	summary := tracker.Summary()
+	if summary.Overall.Count != 21 || summary.Interfaces["lo"].Count != 1 {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	tracker.Reset()
+	if summary := tracker.Summary(); summary.Overall.Count != 0 || len(summary.Interfaces) != 0 || summary.BreachRatio != 0 {
+		t.Errorf("reset must discard all latencies: %+v", summary)
+	}
+}
+
+// TestExportLatency verifies that skewed timestamps embedded in PCAP file names never produce negative latencies.
+func TestExportLatency(t *testing.T) {
+	t.Parallel()
+
+	exported := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name            string
+		named, modified time.Time
+		want            time.Duration
+		source          string
+		skewed          bool
+	}{
+		{"name", exported.Add(-90 * time.Second), exported.Add(-time.Second), 90 * time.Second, LATENCY_SOURCE_NAME, false},
+		{"stat", time.Time{}, exported.Add(-time.Second), time.Second, LATENCY_SOURCE_STAT, false},
+		{"skewed", exported.Add(time.Hour), exported.Add(-time.Second), time.Second, LATENCY_SOURCE_STAT, true},
+		{"skewed without stat", exported.Add(time.Hour), time.Time{}, 0, LATENCY_SOURCE_NAME, true},
+		{"stat after export", time.Time{}, exported.Add(time.Second), 0, LATENCY_SOURCE_STAT, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := NewExportLatency(tc.named, tc.modified, exported)
+			if !ok || got.Duration() != tc.want || got.Source != tc.source || got.Skewed != tc.skewed {
+				t.Errorf("NewExportLatency() = %+v, %v", got, ok)
+			}
+			if got.Latency != tc.want.String() || got.Millis != tc.want.Milliseconds() {
+				t.Errorf("latency = %s (%dms), want %s", got.Latency, got.Millis, tc.want)
+			}
+		})
+	}
+
+	if _, ok := NewExportLatency(time.Time{}, time.Time{}, exported); ok {
+		t.Error("NewExportLatency() must fail without a creation time")
+	}
+}
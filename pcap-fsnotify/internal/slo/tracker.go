@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package slo tracks the durability latency of PCAP files: the time from the rotation
+// that started a PCAP file, which is the time of its oldest packet, to its export.
+package slo
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	Percentiles struct {
+		Count uint64 `json:"count"`
+		P50   string `json:"p50"`
+		P95   string `json:"p95"`
+		P99   string `json:"p99"`
+	}
+
+	Summary struct {
+		Overall     Percentiles            `json:"overall"`
+		Interfaces  map[string]Percentiles `json:"interfaces,omitempty"`
+		Target      string                 `json:"target,omitempty"`
+		BreachRatio float64                `json:"breach_ratio"`
+		Degraded    bool                   `json:"degraded"`
+	}
+
+	// Tracker is safe for concurrent use; its memory usage is bounded by the number of interfaces.
+	Tracker struct {
+		target     time.Duration
+		maxBreach  float64
+		mu         sync.Mutex
+		overall    *Histogram
+		interfaces map[string]*Histogram
+		// rolling window of the last exports: `true` means the target was breached
+		window   []bool
+		next     int
+		filled   int
+		breaches int
+	}
+)
+
+// NewTracker creates a tracker for the durability `target`; the SLO is considered degraded when
+// more than `maxBreachRatio` of the last `windowSize` exports breached it. A `target` of `0` disables SLO evaluation.
+func NewTracker(
+	target time.Duration,
+	maxBreachRatio float64,
+	windowSize int,
+) *Tracker {
+	if windowSize <= 0 {
+		windowSize = 1
+	}
+	return &Tracker{
+		target:     target,
+		maxBreach:  maxBreachRatio,
+		overall:    &Histogram{},
+		interfaces: make(map[string]*Histogram),
+		window:     make([]bool, windowSize),
+	}
+}
+
+// Record adds the durability latency of an exported PCAP file, and returns `true` if it breached the target.
+func (t *Tracker) Record(
+	iface string,
+	latency time.Duration,
+) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.overall.Record(latency)
+	h, ok := t.interfaces[iface]
+	if !ok {
+		h = &Histogram{}
+		t.interfaces[iface] = h
+	}
+	h.Record(latency)
+
+	if t.target <= 0 {
+		return false
+	}
+
+	breached := latency > t.target
+	if t.filled == len(t.window) {
+		if t.window[t.next] {
+			t.breaches -= 1
+		}
+	} else {
+		t.filled += 1
+	}
+	t.window[t.next] = breached
+	if breached {
+		t.breaches += 1
+	}
+	t.next = (t.next + 1) % len(t.window)
+
+	return breached
+}
+
+func (t *Tracker) breachRatio() float64 {
+	if t.filled == 0 {
+		return 0
+	}
+	return float64(t.breaches) / float64(t.filled)
+}
+
+// Degraded returns `true` when the breach ratio within the rolling window exceeds the allowed one.
+func (t *Tracker) Degraded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.target > 0 && t.breachRatio() > t.maxBreach
+}
+
+func percentilesOf(h *Histogram) Percentiles {
+	return Percentiles{
+		Count: h.Count(),
+		P50:   h.Quantile(0.50).String(),
+		P95:   h.Quantile(0.95).String(),
+		P99:   h.Quantile(0.99).String(),
+	}
+}
+
+func (t *Tracker) Summary() Summary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summary := Summary{
+		Overall:     percentilesOf(t.overall),
+		Interfaces:  make(map[string]Percentiles, len(t.interfaces)),
+		BreachRatio: t.breachRatio(),
+		Degraded:    t.target > 0 && t.breachRatio() > t.maxBreach,
+	}
+	if t.target > 0 {
+		summary.Target = t.target.String()
+	}
+
+	ifaces := make([]string, 0, len(t.interfaces))
+	for iface := range t.interfaces {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+	for _, iface := range ifaces {
+		summary.Interfaces[iface] = percentilesOf(t.interfaces[iface])
+	}
+
+	return summary
+}
+
+// Reset discards all latencies, i.e.: when a new capture session starts.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.overall = &Histogram{}
+	clear(t.interfaces)
+	clear(t.window)
+	t.next, t.filled, t.breaches = 0, 0, 0
+}
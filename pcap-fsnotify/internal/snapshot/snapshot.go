@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Snapshots numbers the versions of a config file exported during a session:
+// the first one is `config.json`, and every later change is `config.${N}.json`.
+type Snapshots struct {
+	mu       sync.Mutex
+	exported int
+	last     [sha256.Size]byte
+}
+
+const (
+	// same as the values of config keys registered as sensitive
+	RedactedValue = "***"
+
+	firstSnapshotName    = "config.json"
+	snapshotNameTemplate = "config.%d.json"
+)
+
+// config keys containing any of these words hold sensitive values
+var sensitiveWords = []string{"secret", "token", "password", "credential", "private"}
+
+func NewSnapshots() *Snapshots {
+	return &Snapshots{}
+}
+
+func Name(n int) string {
+	if n <= 1 {
+		return firstSnapshotName
+	}
+	return fmt.Sprintf(snapshotNameTemplate, n)
+}
+
+// Next returns the name of the snapshot for `content`;
+// `ok` is `false` when `content` was already exported as the latest snapshot.
+func (s *Snapshots) Next(
+	content []byte,
+) (name string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.exported > 0 && sha256.Sum256(content) == s.last {
+		return "", false
+	}
+	return Name(s.exported + 1), true
+}
+
+// Commit records `content` as exported; snapshots which fail to be exported are not committed, so they are retried.
+func (s *Snapshots) Commit(
+	content []byte,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := sha256.Sum256(content)
+	if s.exported > 0 && sum == s.last {
+		return
+	}
+	s.exported += 1
+	s.last = sum
+}
+
+func (s *Snapshots) Exported() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exported
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range sensitiveWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if isSensitive(key) {
+				v[key] = RedactedValue
+			} else {
+				v[key] = redact(item)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return value
+}
+
+// Redact replaces the values of all sensitive keys in the JSON document `content`.
+func Redact(
+	content []byte,
+) ([]byte, error) {
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(redact(document), "", "  ")
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestSnapshotsNumbering verifies that only config changes create new snapshots.
+func TestSnapshotsNumbering(t *testing.T) {
+	s := NewSnapshots()
+	steps := []struct {
+		content  string
+		commit   bool
+		wantName string
+		wantOK   bool
+	}{
+		{`{"a":1}`, false, "config.json", true}, // failed export: retried
+		{`{"a":1}`, true, "config.json", true},
+		{`{"a":1}`, true, "", false},
+		{`{"a":2}`, true, "config.2.json", true},
+		{`{"a":2}`, true, "", false},
+		{`{"a":1}`, true, "config.3.json", true},
+	}
+
+	for i, step := range steps {
+		name, ok := s.Next([]byte(step.content))
+		if name != step.wantName || ok != step.wantOK {
+			t.Fatalf("step %d: Next(%s) = (%q, %v), want (%q, %v)", i, step.content, name, ok, step.wantName, step.wantOK)
+		}
+		if ok && step.commit {
+			s.Commit([]byte(step.content))
+		}
+	}
+
+	if got := s.Exported(); got != 3 {
+		t.Errorf("Exported() = %d, want 3", got)
+	}
+}
+
+// TestRedact verifies that sensitive values are redacted at any depth.
+func TestRedact(t *testing.T) {
+	content := []byte(`{"pcap":{"debug":true,"gcp":{"auth_token":"t0k3n","bucket":"b"},"hooks":[{"Secret":"s"}],"snaplen":65536}}`)
+
+	redacted, err := Redact(content)
+	if err != nil {
+		t.Fatalf("Redact failed: %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(redacted, &got); err != nil {
+		t.Fatalf("invalid redacted document: %v", err)
+	}
+	pcap := got["pcap"].(map[string]any)
+	gcp := pcap["gcp"].(map[string]any)
+	if gcp["auth_token"] != RedactedValue {
+		t.Errorf("auth_token = %v, want %s", gcp["auth_token"], RedactedValue)
+	}
+	if gcp["bucket"] != "b" {
+		t.Errorf("bucket = %v, want b", gcp["bucket"])
+	}
+	if hook := pcap["hooks"].([]any)[0].(map[string]any); hook["Secret"] != RedactedValue {
+		t.Errorf("Secret = %v, want %s", hook["Secret"], RedactedValue)
+	}
+	if pcap["snaplen"] != float64(65536) || pcap["debug"] != true {
+		t.Errorf("non-sensitive values changed: %v", pcap)
+	}
+
+	if _, err := Redact([]byte(`{`)); err == nil {
+		t.Error("Redact(invalid JSON) succeeded, want error")
+	}
+}
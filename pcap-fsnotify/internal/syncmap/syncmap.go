@@ -0,0 +1,161 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package syncmap provides the concurrent map used to track the PCAP files of every key,
+// with the atomic operations the exporter relies on to detect rotations and leaked PCAP files.
+package syncmap
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// shards spreads keys across locks; keys are few, so contention between different keys is rare.
+const shards = 16
+
+type (
+	shard[K comparable, V comparable] struct {
+		mu sync.RWMutex
+		m  map[K]V
+	}
+
+	// Map is safe for concurrent use; values must be comparable so that they can be swapped atomically.
+	Map[K comparable, V comparable] struct {
+		seed   maphash.Seed
+		shards [shards]shard[K, V]
+	}
+)
+
+func New[K comparable, V comparable]() *Map[K, V] {
+	m := &Map[K, V]{seed: maphash.MakeSeed()}
+	for i := range m.shards {
+		m.shards[i].m = make(map[K]V)
+	}
+	return m
+}
+
+func (m *Map[K, V]) shardOf(
+	key K,
+) *shard[K, V] {
+	return &m.shards[maphash.Comparable(m.seed, key)%shards]
+}
+
+// Get returns the value of `key`, and whether it is set.
+func (m *Map[K, V]) Get(
+	key K,
+) (V, bool) {
+	s := m.shardOf(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.m[key]
+	return value, ok
+}
+
+func (m *Map[K, V]) Set(
+	key K,
+	value V,
+) {
+	s := m.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[key] = value
+}
+
+func (m *Map[K, V]) Delete(
+	key K,
+) {
+	s := m.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+}
+
+// GetOrCompute returns the value of `key`, and `true` if it was already set; otherwise it sets the value
+// returned by `compute`, which is called at most once per missing key, and must not use the map.
+func (m *Map[K, V]) GetOrCompute(
+	key K,
+	compute func() V,
+) (V, bool) {
+	if value, ok := m.Get(key); ok {
+		return value, true
+	}
+	s := m.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another goroutine may have set it while the lock was released
+	if value, ok := s.m[key]; ok {
+		return value, true
+	}
+	value := compute()
+	s.m[key] = value
+	return value, false
+}
+
+// CompareAndSwap sets `key` to `new` only if its value is `old`; it never sets a missing key,
+// so it fails for keys which were deleted or cleared, even if `old` is the zero value.
+func (m *Map[K, V]) CompareAndSwap(
+	key K,
+	old, new V,
+) bool {
+	s := m.shardOf(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.m[key]; !ok || value != old {
+		return false
+	}
+	s.m[key] = new
+	return true
+}
+
+// ForEach calls `fn` for every key until it returns `false`. Each shard is copied before `fn` is called,
+// so `fn` may use the map; keys set or deleted concurrently may or may not be visited.
+func (m *Map[K, V]) ForEach(
+	fn func(K, V) bool,
+) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		keys, values := make([]K, 0, len(s.m)), make([]V, 0, len(s.m))
+		for key, value := range s.m {
+			keys, values = append(keys, key), append(values, value)
+		}
+		s.mu.RUnlock()
+		for j := range keys {
+			if !fn(keys[j], values[j]) {
+				return
+			}
+		}
+	}
+}
+
+func (m *Map[K, V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		n += len(s.m)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// Clear deletes all keys; it is not atomic across shards.
+func (m *Map[K, V]) Clear() {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		clear(s.m)
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncmap
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+// benchmarkKeys are as many as the interfaces of a typical capture: few keys, each one rotated often.
+const benchmarkKeys = 4
+
+// rotations are the maps the exporter updates at every rotation.
+type rotations struct {
+	counters *Map[string, *atomic.Uint64]
+	lastPcap *Map[string, string]
+}
+
+func (m *rotations) count(key string) uint64 {
+	counter, _ := m.counters.GetOrCompute(key, func() *atomic.Uint64 { return new(atomic.Uint64) })
+	return counter.Add(1)
+}
+
+func (m *rotations) swap(key, pcapFile string) bool {
+	previous, ok := m.lastPcap.Get(key)
+	if !ok {
+		m.lastPcap.Set(key, pcapFile)
+		return true
+	}
+	return m.lastPcap.CompareAndSwap(key, previous, pcapFile)
+}
+
+func benchmarkRotations(b *testing.B, m *rotations) {
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("1/eth%d/pcap", i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%benchmarkKeys]
+			m.swap(key, fmt.Sprint(m.count(key)))
+			i++
+		}
+	})
+}
+
+// BenchmarkRotations measures `Map` on the exporter access pattern.
+func BenchmarkRotations(b *testing.B) {
+	benchmarkRotations(b, &rotations{
+		counters: New[string, *atomic.Uint64](),
+		lastPcap: New[string, string](),
+	})
+}
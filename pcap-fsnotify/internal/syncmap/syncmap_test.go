@@ -0,0 +1,214 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncmap
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestGetOrCompute verifies that concurrent callers observe a single computed value per key.
+func TestGetOrCompute(t *testing.T) {
+	m := New[string, *atomic.Uint64]()
+
+	var computed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter, _ := m.GetOrCompute("eth0", func() *atomic.Uint64 {
+				computed.Add(1)
+				return new(atomic.Uint64)
+			})
+			counter.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	if n := computed.Load(); n != 1 {
+		t.Errorf("computed %d values, want 1", n)
+	}
+	if counter, ok := m.Get("eth0"); !ok || counter.Load() != 64 {
+		t.Errorf("counter = %v, want 64", counter)
+	}
+	if _, loaded := m.GetOrCompute("eth0", func() *atomic.Uint64 { return nil }); !loaded {
+		t.Error("existing value was not loaded")
+	}
+}
+
+// TestCompareAndSwap verifies that missing, deleted, and cleared keys are never swapped.
+func TestCompareAndSwap(t *testing.T) {
+	m := New[string, string]()
+
+	if m.CompareAndSwap("eth0", "", "a.pcap") {
+		t.Error("missing key was swapped")
+	}
+	m.Set("eth0", "a.pcap")
+	if !m.CompareAndSwap("eth0", "a.pcap", "b.pcap") {
+		t.Error("current value was not swapped")
+	}
+	if m.CompareAndSwap("eth0", "a.pcap", "c.pcap") {
+		t.Error("stale value was swapped")
+	}
+	m.Delete("eth0")
+	if m.CompareAndSwap("eth0", "b.pcap", "c.pcap") {
+		t.Error("deleted key was swapped")
+	}
+	m.Set("eth0", "c.pcap")
+	m.Clear()
+	if m.CompareAndSwap("eth0", "c.pcap", "d.pcap") {
+		t.Error("cleared key was swapped")
+	}
+	if _, ok := m.Get("eth0"); ok || m.Len() != 0 {
+		t.Error("failed swaps set a value")
+	}
+}
+
+// TestLeakedPcapFile reconstructs the exporter leak detection: a PCAP file replaced while it was being exported
+// must fail the swap, so that the replacement is reported as leaked instead of being overwritten silently.
+func TestLeakedPcapFile(t *testing.T) {
+	m := New[string, string]()
+	m.Set("eth0", "1.pcap")
+
+	exporting, _ := m.Get("eth0")
+	replaced := make(chan struct{})
+	go func() {
+		defer close(replaced)
+		// i.e. a flush of the capture window resets PCAP files bookkeeping
+		m.Clear()
+		m.Set("eth0", "3.pcap")
+	}()
+	<-replaced
+
+	if m.CompareAndSwap("eth0", exporting, "2.pcap") {
+		t.Fatal("swap of a replaced PCAP file succeeded")
+	}
+	if current, _ := m.Get("eth0"); current != "3.pcap" {
+		t.Errorf("current = %s, want 3.pcap", current)
+	}
+}
+
+// TestForEachReentrant verifies that `fn` may use the map while iterating, and that iteration stops early.
+func TestForEachReentrant(t *testing.T) {
+	m := New[string, int]()
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprint(i), i)
+	}
+
+	visited := 0
+	m.ForEach(func(key string, value int) bool {
+		m.Set(key, value+1)
+		visited++
+		return true
+	})
+	if visited != 100 {
+		t.Errorf("visited %d keys, want 100", visited)
+	}
+
+	visited = 0
+	m.ForEach(func(string, int) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Errorf("visited %d keys, want 10", visited)
+	}
+}
+
+// TestRotationStress simulates thousands of rotations across dozens of keys, as the exporter tracks them:
+// every key counts its PCAP files and swaps the last one, while readers concurrently list the current ones.
+// Every rotated PCAP file must be handed over exactly once, and none may be skipped.
+func TestRotationStress(t *testing.T) {
+	const (
+		keys      = 48
+		rotations = 500
+	)
+
+	counters := New[string, *atomic.Uint64]()
+	lastPcap := New[string, string]()
+
+	var exported sync.Map
+	var duplicates atomic.Int64
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				lastPcap.ForEach(func(key string, _ string) bool {
+					lastPcap.Get(key)
+					return rand.Intn(4) > 0
+				})
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		writers.Add(1)
+		go func(key string) {
+			defer writers.Done()
+			for r := 1; r <= rotations; r++ {
+				pcapFile := fmt.Sprintf("%s/%d.pcap", key, r)
+				counter, _ := counters.GetOrCompute(key, func() *atomic.Uint64 {
+					return new(atomic.Uint64)
+				})
+				if iteration := counter.Add(1); iteration == 1 {
+					lastPcap.Set(key, pcapFile)
+					continue
+				}
+				previous, _ := lastPcap.Get(key)
+				if _, loaded := exported.LoadOrStore(previous, struct{}{}); loaded {
+					duplicates.Add(1)
+				}
+				if !lastPcap.CompareAndSwap(key, previous, pcapFile) {
+					t.Errorf("leaked PCAP file: %s", pcapFile)
+				}
+			}
+		}(fmt.Sprintf("eth%d", k))
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	if n := duplicates.Load(); n != 0 {
+		t.Errorf("%d PCAP files were exported twice", n)
+	}
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("eth%d", k)
+		for r := 1; r < rotations; r++ {
+			if _, ok := exported.Load(fmt.Sprintf("%s/%d.pcap", key, r)); !ok {
+				t.Fatalf("PCAP file was skipped: %s/%d.pcap", key, r)
+			}
+		}
+		if counter, _ := counters.Get(key); counter.Load() != rotations {
+			t.Errorf("%s: counted %d PCAP files, want %d", key, counter.Load(), rotations)
+		}
+		if current, _ := lastPcap.Get(key); current != fmt.Sprintf("%s/%d.pcap", key, rotations) {
+			t.Errorf("%s: current PCAP file = %s", key, current)
+		}
+	}
+}
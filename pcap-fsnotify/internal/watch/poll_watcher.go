@@ -0,0 +1,233 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type (
+	fileState struct {
+		size    int64
+		modTime time.Time
+	}
+
+	pollWatcher struct {
+		mu       sync.Mutex
+		dirs     map[string]map[string]fileState
+		events   chan fsnotify.Event
+		errors   chan error
+		ticker   *time.Ticker
+		done     chan struct{}
+		closeOne sync.Once
+	}
+)
+
+var errWatcherClosed = errors.New("poll watcher already closed")
+
+func listDir(
+	dir string,
+) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	listing := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// file was removed between `ReadDir` and `Info`
+			continue
+		}
+		listing[filepath.Join(dir, entry.Name())] = fileState{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+	}
+	return listing, nil
+}
+
+func (w *pollWatcher) isClosed() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *pollWatcher) Add(name string) error {
+	if w.isClosed() {
+		return errWatcherClosed
+	}
+	// the initial listing is the baseline: same as inotify, pre-existing files are not reported
+	listing, err := listDir(name)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[name] = listing
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *pollWatcher) Remove(name string) error {
+	w.mu.Lock()
+	delete(w.dirs, name)
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *pollWatcher) Close() error {
+	w.closeOne.Do(func() {
+		w.ticker.Stop()
+		close(w.done)
+	})
+	return nil
+}
+
+func (w *pollWatcher) Events() <-chan fsnotify.Event {
+	return w.events
+}
+
+func (w *pollWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *pollWatcher) Mode() Mode {
+	return MODE_POLL
+}
+
+func (w *pollWatcher) emit(
+	name string,
+	op fsnotify.Op,
+) bool {
+	select {
+	case <-w.done:
+		return false
+	case w.events <- fsnotify.Event{Name: name, Op: op}:
+		return true
+	}
+}
+
+func sortedNames(
+	listing map[string]fileState,
+) []string {
+	names := make([]string, 0, len(listing))
+	for name := range listing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (w *pollWatcher) diff(
+	previous, current map[string]fileState,
+) bool {
+	// PCAP files names embed their rotation timestamp, so sorting
+	// them delivers events in the same order tcpdump created them
+	for _, name := range sortedNames(current) {
+		state := current[name]
+		if prev, ok := previous[name]; !ok {
+			if !w.emit(name, fsnotify.Create) {
+				return false
+			}
+		} else if prev != state {
+			if !w.emit(name, fsnotify.Write) {
+				return false
+			}
+		}
+	}
+	for _, name := range sortedNames(previous) {
+		if _, ok := current[name]; !ok {
+			if !w.emit(name, fsnotify.Remove) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (w *pollWatcher) poll() {
+	w.mu.Lock()
+	dirs := make([]string, 0, len(w.dirs))
+	for dir := range w.dirs {
+		dirs = append(dirs, dir)
+	}
+	w.mu.Unlock()
+
+	for _, dir := range dirs {
+		current, err := listDir(dir)
+		if err != nil {
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+			continue
+		}
+
+		w.mu.Lock()
+		previous, ok := w.dirs[dir]
+		if ok {
+			w.dirs[dir] = current
+		}
+		w.mu.Unlock()
+
+		// directory was removed from the watch list while listing it
+		if ok && !w.diff(previous, current) {
+			return
+		}
+	}
+}
+
+func (w *pollWatcher) run() {
+	defer close(w.events)
+	defer close(w.errors)
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func newPollWatcher(
+	bufferSize uint,
+	pollInterval time.Duration,
+) *pollWatcher {
+	w := &pollWatcher{
+		dirs:   make(map[string]map[string]fileState),
+		events: make(chan fsnotify.Event, bufferSize),
+		errors: make(chan error),
+		ticker: time.NewTicker(pollInterval),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestDiff(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		previous map[string]fileState
+		current  map[string]fileState
+		want     []fsnotify.Event
+	}{
+		{
+			name:     "unchanged",
+			previous: map[string]fileState{"a.pcap": {1, t0}},
+			current:  map[string]fileState{"a.pcap": {1, t0}},
+		},
+		{
+			name:     "create in rotation order",
+			previous: map[string]fileState{},
+			current:  map[string]fileState{"b.pcap": {0, t0}, "a.pcap": {0, t0}},
+			want:     []fsnotify.Event{{Name: "a.pcap", Op: fsnotify.Create}, {Name: "b.pcap", Op: fsnotify.Create}},
+		},
+		{
+			name:     "write by size",
+			previous: map[string]fileState{"a.pcap": {1, t0}},
+			current:  map[string]fileState{"a.pcap": {2, t0}},
+			want:     []fsnotify.Event{{Name: "a.pcap", Op: fsnotify.Write}},
+		},
+		{
+			name:     "write by mtime",
+			previous: map[string]fileState{"a.pcap": {1, t0}},
+			current:  map[string]fileState{"a.pcap": {1, t0.Add(time.Second)}},
+			want:     []fsnotify.Event{{Name: "a.pcap", Op: fsnotify.Write}},
+		},
+		{
+			name:     "remove",
+			previous: map[string]fileState{"a.pcap": {1, t0}, "b.pcap": {1, t0}},
+			current:  map[string]fileState{"b.pcap": {1, t0}},
+			want:     []fsnotify.Event{{Name: "a.pcap", Op: fsnotify.Remove}},
+		},
+		{
+			name:     "rotate",
+			previous: map[string]fileState{"a.pcap": {1, t0}},
+			current:  map[string]fileState{"b.pcap": {0, t0}},
+			want:     []fsnotify.Event{{Name: "b.pcap", Op: fsnotify.Create}, {Name: "a.pcap", Op: fsnotify.Remove}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &pollWatcher{events: make(chan fsnotify.Event, 10), done: make(chan struct{})}
+			if !w.diff(tc.previous, tc.current) {
+				t.Fatal("diff() = false, want true")
+			}
+			close(w.events)
+			var got []fsnotify.Event
+			for event := range w.events {
+				got = append(got, event)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("diff() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestPollWatcherRun verifies that polling a directory reports files created, written and removed after it is watched,
+// and that files which existed before are not reported.
+func TestPollWatcherRun(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.pcap")
+	if err := os.WriteFile(existing, []byte("pcap"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newPollWatcher(10, 5*time.Millisecond)
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(want fsnotify.Event) {
+		t.Helper()
+		select {
+		case event := <-w.Events():
+			if event != want {
+				t.Errorf("event = %v, want %v", event, want)
+			}
+		case err := <-w.Errors():
+			t.Fatalf("error = %v, want %v", err, want)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+
+	pcapFile := filepath.Join(dir, "part__1_eth0__20240101T000000.pcap")
+	if err := os.WriteFile(pcapFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	receive(fsnotify.Event{Name: pcapFile, Op: fsnotify.Create})
+
+	if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	receive(fsnotify.Event{Name: pcapFile, Op: fsnotify.Write})
+
+	if err := os.Remove(pcapFile); err != nil {
+		t.Fatal(err)
+	}
+	receive(fsnotify.Event{Name: pcapFile, Op: fsnotify.Remove})
+
+	w.Close()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events channel is open after Close()")
+	}
+	if err := w.Add(dir); err != errWatcherClosed {
+		t.Errorf("Add() after Close() = %v, want %v", err, errWatcherClosed)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// QueueSampler approximates how long events wait to be handled: events are not timestamped when they are queued,
+	// so it samples how full the events buffer of a Watcher is, and how long handling every event takes.
+	QueueSampler struct {
+		mu        sync.Mutex
+		watcher   Watcher
+		nearFull  float64
+		sustained uint64
+		streak    uint64
+		samples   uint64
+		total     uint64
+		max       int
+		events    uint64
+		slowest   time.Duration
+	}
+
+	// QueueStats describes the events buffer since the previous report.
+	QueueStats struct {
+		Capacity int     `json:"capacity"`
+		Samples  uint64  `json:"samples"`
+		Max      int     `json:"max"`
+		Mean     float64 `json:"mean"`
+		// Streak is how many consecutive samples, up to the latest one, found the buffer near full
+		Streak uint64 `json:"streak"`
+		// Sustained is set when the buffer has been near full for long enough: events are produced faster than they are handled
+		Sustained bool   `json:"sustained"`
+		Events    uint64 `json:"events"`
+		Slowest   string `json:"slowest"`
+	}
+)
+
+// NewQueueSampler samples the events buffer of `watcher`: it is near full when at least `nearFull` of its capacity is used,
+// and it is sustained after `sustained` consecutive near full samples.
+func NewQueueSampler(
+	watcher Watcher,
+	nearFull float64,
+	sustained uint64,
+) *QueueSampler {
+	return &QueueSampler{
+		watcher:   watcher,
+		nearFull:  nearFull,
+		sustained: max(sustained, 1),
+	}
+}
+
+// Sample records how many events are waiting in the buffer; it returns that number.
+func (s *QueueSampler) Sample() int {
+	events := s.watcher.Events()
+	length, capacity := len(events), cap(events)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples += 1
+	s.total += uint64(length)
+	s.max = max(s.max, length)
+	if capacity > 0 && float64(length) >= s.nearFull*float64(capacity) {
+		s.streak += 1
+	} else {
+		s.streak = 0
+	}
+	return length
+}
+
+// Handled records that an event received at `received` was handled at `now`.
+func (s *QueueSampler) Handled(
+	received, now time.Time,
+) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events += 1
+	s.slowest = max(s.slowest, now.Sub(received))
+}
+
+// Sustained reports whether the buffer has been near full for `sustained` consecutive samples.
+func (s *QueueSampler) Sustained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streak >= s.sustained
+}
+
+// Report returns the stats since the previous report, and resets them; the near full streak is kept.
+func (s *QueueSampler) Report() *QueueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := &QueueStats{
+		Capacity:  cap(s.watcher.Events()),
+		Samples:   s.samples,
+		Max:       s.max,
+		Streak:    s.streak,
+		Sustained: s.streak >= s.sustained,
+		Events:    s.events,
+		Slowest:   s.slowest.String(),
+	}
+	if s.samples > 0 {
+		stats.Mean = float64(s.total) / float64(s.samples)
+	}
+	s.samples, s.total, s.max, s.events, s.slowest = 0, 0, 0, 0, 0
+	return stats
+}
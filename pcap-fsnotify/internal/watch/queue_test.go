@@ -0,0 +1,83 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeWatcher exposes a buffered events channel which is filled and drained by tests.
+type fakeWatcher struct {
+	events chan fsnotify.Event
+}
+
+func (w *fakeWatcher) Add(string) error              { return nil }
+func (w *fakeWatcher) Remove(string) error           { return nil }
+func (w *fakeWatcher) Close() error                  { return nil }
+func (w *fakeWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *fakeWatcher) Errors() <-chan error          { return nil }
+func (w *fakeWatcher) Mode() Mode                    { return MODE_INOTIFY }
+
+// TestQueueSampler verifies that a buffer which stays near full is reported as sustained,
+// that draining it resets the streak, and that stats are reset after every report.
+func TestQueueSampler(t *testing.T) {
+	watcher := &fakeWatcher{events: make(chan fsnotify.Event, 10)}
+	sampler := NewQueueSampler(watcher, 0.9, 3)
+
+	fill := func(n int) {
+		for len(watcher.events) < n {
+			watcher.events <- fsnotify.Event{Name: "part__1_eth0__20240101T000000.pcap", Op: fsnotify.Create}
+		}
+		for len(watcher.events) > n {
+			<-watcher.events
+		}
+	}
+
+	for _, length := range []int{2, 9, 10} {
+		fill(length)
+		if got := sampler.Sample(); got != length {
+			t.Errorf("Sample() = %d, want %d", got, length)
+		}
+	}
+	if sampler.Sustained() {
+		t.Error("sustained after 2 near full samples")
+	}
+	fill(9)
+	sampler.Sample()
+	if !sampler.Sustained() {
+		t.Error("not sustained after 3 near full samples")
+	}
+
+	received := time.Now()
+	sampler.Handled(received, received.Add(10*time.Millisecond))
+	sampler.Handled(received, received.Add(250*time.Millisecond))
+
+	stats := sampler.Report()
+	if stats.Capacity != 10 || stats.Samples != 4 || stats.Max != 10 || stats.Mean != 7.5 {
+		t.Errorf("Report() = %+v, want 4 samples of 10, max 10, mean 7.5", stats)
+	}
+	if !stats.Sustained || stats.Streak != 3 || stats.Events != 2 || stats.Slowest != "250ms" {
+		t.Errorf("Report() = %+v, want a sustained streak of 3, and 2 events handled in at most 250ms", stats)
+	}
+
+	fill(0)
+	sampler.Sample()
+	if stats := sampler.Report(); stats.Sustained || stats.Samples != 1 || stats.Max != 0 || stats.Events != 0 {
+		t.Errorf("Report() = %+v after draining, want 1 empty sample", stats)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Trigger is the event which makes a PCAP file a candidate for export.
+	Trigger string
+
+	// Quiescence tracks files which are still being written: a file is due once it has not been written for a while.
+	Quiescence struct {
+		mu      sync.Mutex
+		quiet   time.Duration
+		written map[string]time.Time
+	}
+)
+
+const (
+	// TRIGGER_CREATE exports a PCAP file when the next one of its interface is created, i.e.: when it is rotated
+	TRIGGER_CREATE = Trigger("create")
+	// TRIGGER_WRITE_CLOSE exports a PCAP file once it has not been written for a while
+	TRIGGER_WRITE_CLOSE = Trigger("write-close")
+	// TRIGGER_RENAME exports a PCAP file as soon as it appears, as producers rename complete files into place
+	TRIGGER_RENAME = Trigger("rename")
+)
+
+func ParseTrigger(
+	trigger string,
+) (Trigger, error) {
+	switch t := Trigger(strings.ToLower(trigger)); t {
+	case TRIGGER_CREATE, TRIGGER_WRITE_CLOSE, TRIGGER_RENAME:
+		return t, nil
+	default:
+		return TRIGGER_CREATE, fmt.Errorf("invalid export trigger: %s", trigger)
+	}
+}
+
+func NewQuiescence(
+	quiet time.Duration,
+) *Quiescence {
+	return &Quiescence{
+		quiet:   quiet,
+		written: make(map[string]time.Time),
+	}
+}
+
+// Touch records that `name` was written at `at`, which postpones it being due.
+func (q *Quiescence) Touch(
+	name string,
+	at time.Time,
+) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.written[name] = at
+}
+
+// Forget stops tracking `name`, i.e.: when it is removed or renamed before being due.
+func (q *Quiescence) Forget(
+	name string,
+) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.written, name)
+}
+
+// Due stops tracking, and returns sorted, all files which have not been written for the quiet period at `now`.
+func (q *Quiescence) Due(
+	now time.Time,
+) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	due := []string{}
+	for name, written := range q.written {
+		if now.Sub(written) >= q.quiet {
+			due = append(due, name)
+			delete(q.written, name)
+		}
+	}
+	slices.Sort(due)
+	return due
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestQuiescence verifies that a file written several times is due exactly once,
+// only after it has not been written for the quiet period, and that forgotten files are never due.
+func TestQuiescence(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := NewQuiescence(time.Second)
+
+	q.Touch("a.pcap", start)
+	q.Touch("b.pcap", start)
+	for i := 1; i <= 3; i++ {
+		q.Touch("a.pcap", start.Add(time.Duration(i)*500*time.Millisecond))
+	}
+	q.Forget("b.pcap")
+
+	if due := q.Due(start.Add(2 * time.Second)); len(due) != 0 {
+		t.Errorf("Due() = %v while being written", due)
+	}
+	if due := q.Due(start.Add(2500 * time.Millisecond)); !slices.Equal(due, []string{"a.pcap"}) {
+		t.Errorf("Due() = %v, want [a.pcap]", due)
+	}
+	if due := q.Due(start.Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Due() = %v after being due", due)
+	}
+}
+
+func TestParseTrigger(t *testing.T) {
+	if trigger, err := ParseTrigger("Write-Close"); err != nil || trigger != TRIGGER_WRITE_CLOSE {
+		t.Errorf("ParseTrigger(Write-Close) = %v, %v", trigger, err)
+	}
+	if trigger, err := ParseTrigger("close"); err == nil || trigger != TRIGGER_CREATE {
+		t.Errorf("ParseTrigger(close) = %v, %v", trigger, err)
+	}
+}
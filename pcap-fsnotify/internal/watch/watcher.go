@@ -0,0 +1,153 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type (
+	Mode string
+
+	Watcher interface {
+		Add(name string) error
+		Remove(name string) error
+		Close() error
+		Events() <-chan fsnotify.Event
+		Errors() <-chan error
+		Mode() Mode
+	}
+
+	inotifyWatcher struct {
+		*fsnotify.Watcher
+	}
+
+	autoWatcher struct {
+		Watcher
+		bufferSize   uint
+		pollInterval time.Duration
+	}
+)
+
+const (
+	MODE_INOTIFY = Mode("inotify")
+	MODE_POLL    = Mode("poll")
+	MODE_AUTO    = Mode("auto")
+)
+
+func ParseMode(
+	mode string,
+) (Mode, error) {
+	switch m := Mode(strings.ToLower(mode)); m {
+	case MODE_INOTIFY, MODE_POLL, MODE_AUTO:
+		return m, nil
+	default:
+		return MODE_AUTO, fmt.Errorf("invalid watch mode: %s", mode)
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan fsnotify.Event {
+	return w.Watcher.Events
+}
+
+func (w *inotifyWatcher) Errors() <-chan error {
+	return w.Watcher.Errors
+}
+
+func (w *inotifyWatcher) Mode() Mode {
+	return MODE_INOTIFY
+}
+
+// Add watches `name` using inotify; if that is not possible,
+// it falls back to polling `name` and all subsequent operations.
+// Directories which do not exist yet cannot be polled either: they do not trigger the fallback.
+// It must not be called once the events loop started: the fallback closes the channels returned by `Events` and `Errors`,
+// and replaces them with the ones of the poll watcher.
+func (w *autoWatcher) Add(name string) error {
+	err := w.Watcher.Add(name)
+	if err == nil || w.Watcher.Mode() == MODE_POLL || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	w.Watcher.Close()
+	w.Watcher = newPollWatcher(w.bufferSize, w.pollInterval)
+	return w.Watcher.Add(name)
+}
+
+func newInotifyWatcher(
+	bufferSize uint,
+) (Watcher, error) {
+	watcher, err := fsnotify.NewBufferedWatcher(bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	return &inotifyWatcher{Watcher: watcher}, nil
+}
+
+func NewWatcher(
+	mode Mode,
+	bufferSize uint,
+	pollInterval time.Duration,
+) (Watcher, error) {
+	switch mode {
+	case MODE_POLL:
+		return newPollWatcher(bufferSize, pollInterval), nil
+	case MODE_INOTIFY:
+		return newInotifyWatcher(bufferSize)
+	}
+
+	// `auto` prefers inotify, but filesystems that do not support it are polled
+	watcher, err := newInotifyWatcher(bufferSize)
+	if err != nil {
+		watcher = newPollWatcher(bufferSize, pollInterval)
+	}
+	return &autoWatcher{
+		Watcher:      watcher,
+		bufferSize:   bufferSize,
+		pollInterval: pollInterval,
+	}, nil
+}
+
+// AddWithRetries watches `name`, retrying up to `retries` times when it fails, i.e.: while `name` is not mounted yet;
+// the delay between attempts starts at `delay` and doubles after every retry. `onRetry` is called before every retry.
+func AddWithRetries(
+	ctx context.Context,
+	w Watcher,
+	name string,
+	retries uint,
+	delay time.Duration,
+	onRetry func(attempt uint, err error),
+) error {
+	err := w.Add(name)
+	for attempt := uint(1); err != nil && attempt <= retries; attempt++ {
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		err = w.Add(name)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAddWithRetries verifies that a directory which appears after a delay is eventually watched,
+// and that watching fails once all retries are exhausted.
+func TestAddWithRetries(t *testing.T) {
+	for _, mode := range []Mode{MODE_POLL, MODE_AUTO} {
+		t.Run(string(mode), func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "pcap-tmp")
+			watcher, err := NewWatcher(mode, 10, 10*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer watcher.Close()
+
+			// the directory is created while the 1st retry is waiting
+			attempts := uint(0)
+			onRetry := func(attempt uint, err error) {
+				attempts = attempt
+				if attempt == 1 {
+					time.AfterFunc(5*time.Millisecond, func() { os.Mkdir(dir, 0o755) })
+				}
+			}
+			if err := AddWithRetries(context.Background(), watcher, dir, 5, 20*time.Millisecond, onRetry); err != nil {
+				t.Fatalf("directory is not watched: %v", err)
+			}
+			if attempts == 0 {
+				t.Error("directory was watched without retries")
+			}
+			if mode == MODE_AUTO && watcher.Mode() == MODE_POLL {
+				if _, err := NewWatcher(MODE_INOTIFY, 10, 0); err == nil {
+					t.Error("a missing directory made the watcher fall back to polling")
+				}
+			}
+		})
+	}
+
+	watcher := newPollWatcher(10, 10*time.Millisecond)
+	defer watcher.Close()
+	attempts := uint(0)
+	missing := filepath.Join(t.TempDir(), "missing")
+	if err := AddWithRetries(context.Background(), watcher, missing, 2, time.Millisecond,
+		func(attempt uint, _ error) { attempts = attempt }); err == nil || attempts != 2 {
+		t.Errorf("got %v after %d retries, want an error after 2 retries", err, attempts)
+	}
+}
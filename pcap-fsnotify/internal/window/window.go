@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package window tracks capture windows: periods of `duration` starting at every time produced by a schedule,
+// such as a cron expression; PCAP files are only exported while a capture window is open.
+package window
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Schedule produces the start time of the next capture window strictly after the given time.
+	Schedule interface {
+		Next(time.Time) time.Time
+	}
+
+	Event string
+
+	// Status describes the current, or last, capture window.
+	Status struct {
+		Open  bool      `json:"open"`
+		Start time.Time `json:"start,omitempty"`
+		End   time.Time `json:"end,omitempty"`
+		Next  time.Time `json:"next,omitempty"`
+	}
+
+	// Tracker is safe for concurrent use.
+	Tracker struct {
+		mu       sync.Mutex
+		schedule Schedule
+		duration time.Duration
+		location *time.Location
+		open     bool
+		// the current, or last, capture window
+		start, end time.Time
+		// start of the next capture window; zero when the schedule is exhausted
+		next time.Time
+	}
+)
+
+const (
+	EVENT_NONE  = Event("")
+	EVENT_START = Event("start")
+	EVENT_STOP  = Event("stop")
+)
+
+// NewTracker creates a capture windows tracker; schedules are evaluated in `location`.
+// Capture windows which started less than `duration` before `now` are open at the first check.
+func NewTracker(
+	schedule Schedule,
+	duration time.Duration,
+	location *time.Location,
+	now time.Time,
+) *Tracker {
+	return &Tracker{
+		schedule: schedule,
+		duration: duration,
+		location: location,
+		next:     schedule.Next(now.In(location).Add(-duration)),
+	}
+}
+
+// Check returns `EVENT_START` when a capture window opened since the previous check,
+// and `EVENT_STOP` when it closed; overlapping capture windows are merged.
+func (t *Tracker) Check(
+	now time.Time,
+) Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now = now.In(t.location)
+	// only the latest capture window which started since the previous check may still be open
+	for !t.next.IsZero() && !t.next.After(now) {
+		if t.next.Before(t.end) {
+			// overlapping capture windows are extended
+			t.end = t.next.Add(t.duration)
+		} else {
+			t.start, t.end = t.next, t.next.Add(t.duration)
+		}
+		t.next = t.schedule.Next(t.next)
+	}
+
+	open := !t.start.IsZero() && now.Before(t.end)
+	if open == t.open {
+		return EVENT_NONE
+	}
+	t.open = open
+	if open {
+		return EVENT_START
+	}
+	return EVENT_STOP
+}
+
+// IsOpen reports whether a capture window was open at the last check.
+func (t *Tracker) IsOpen() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.open
+}
+
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Status{
+		Open:  t.open,
+		Start: t.start,
+		End:   t.end,
+		Next:  t.next,
+	}
+}
+
+func (t *Tracker) Duration() time.Duration {
+	return t.duration
+}
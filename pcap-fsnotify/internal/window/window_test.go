@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+// everySchedule starts a capture window at every multiple of `period`, like the cron descriptor `@every`.
+type everySchedule struct {
+	period time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.period).Add(s.period)
+}
+
+// onceSchedule starts a single capture window.
+type onceSchedule struct {
+	at time.Time
+}
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.at) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// TestCheckTransitions verifies schedule-driven transitions using a fake clock.
+func TestCheckTransitions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type step struct {
+		at   time.Duration
+		want Event
+		open bool
+	}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		duration time.Duration
+		start    time.Duration
+		steps    []step
+	}{
+		{
+			name:     "hourly",
+			schedule: everySchedule{time.Hour},
+			duration: 10 * time.Minute,
+			start:    30 * time.Minute,
+			steps: []step{
+				{59 * time.Minute, EVENT_NONE, false},
+				{60 * time.Minute, EVENT_START, true},
+				{65 * time.Minute, EVENT_NONE, true},
+				{70 * time.Minute, EVENT_STOP, false},
+				{119 * time.Minute, EVENT_NONE, false},
+				{121 * time.Minute, EVENT_START, true},
+				{131 * time.Minute, EVENT_STOP, false},
+			},
+		},
+		{
+			name:     "started before the tracker",
+			schedule: everySchedule{time.Hour},
+			duration: 10 * time.Minute,
+			start:    65 * time.Minute,
+			steps: []step{
+				{65 * time.Minute, EVENT_START, true},
+				{70 * time.Minute, EVENT_STOP, false},
+			},
+		},
+		{
+			name:     "overlapping windows are merged",
+			schedule: everySchedule{time.Hour},
+			duration: 90 * time.Minute,
+			start:    30 * time.Minute,
+			steps: []step{
+				{60 * time.Minute, EVENT_START, true},
+				{120 * time.Minute, EVENT_NONE, true},
+				{149 * time.Minute, EVENT_NONE, true},
+				{180 * time.Minute, EVENT_NONE, true},
+			},
+		},
+		{
+			name:     "missed window",
+			schedule: everySchedule{time.Hour},
+			duration: 10 * time.Minute,
+			start:    30 * time.Minute,
+			steps: []step{
+				{75 * time.Minute, EVENT_NONE, false},
+				{125 * time.Minute, EVENT_START, true},
+			},
+		},
+		{
+			name:     "exhausted schedule",
+			schedule: onceSchedule{base.Add(time.Hour)},
+			duration: time.Minute,
+			start:    0,
+			steps: []step{
+				{time.Hour, EVENT_START, true},
+				{2 * time.Hour, EVENT_STOP, false},
+				{3 * time.Hour, EVENT_NONE, false},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewTracker(tc.schedule, tc.duration, time.UTC, base.Add(tc.start))
+			for _, s := range tc.steps {
+				if got := tracker.Check(base.Add(s.at)); got != s.want {
+					t.Errorf("Check(+%v) = %q, want %q", s.at, got, s.want)
+				}
+				if tracker.IsOpen() != s.open {
+					t.Errorf("IsOpen(+%v) = %v, want %v", s.at, tracker.IsOpen(), s.open)
+				}
+			}
+		})
+	}
+}
+
+// TestStatus verifies that the status describes the current and the next capture windows.
+func TestStatus(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(everySchedule{time.Hour}, 10*time.Minute, time.UTC, base)
+
+	tracker.Check(base.Add(61 * time.Minute))
+
+	status := tracker.Status()
+	want := Status{
+		Open:  true,
+		Start: base.Add(time.Hour),
+		End:   base.Add(70 * time.Minute),
+		Next:  base.Add(2 * time.Hour),
+	}
+	if status != want {
+		t.Errorf("Status() = %+v, want %+v", status, want)
+	}
+}
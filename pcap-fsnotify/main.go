@@ -15,27 +15,64 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
+	"math/rand/v2"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/bpf"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/buildinfo"
+	cfg "github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/config"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/env"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/iface"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/activeflush"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/auth"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/backfill"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/canary"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/checkpoint"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/compression"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/constants"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/durations"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/files"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/gate"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/gcs"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/health"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/lifecycle"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/log"
-	"github.com/alphadose/haxmap"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/mirror"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/mount"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/naming"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/postprocess"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/pressure"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/profiling"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/rotation"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/sampling"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/scheduler"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/shortlived"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/slo"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/snapshot"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/syncmap"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/watch"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/window"
 	"github.com/fsnotify/fsnotify"
 	"github.com/gofrs/flock"
 	"go.uber.org/zap/zapcore"
@@ -46,15 +83,30 @@ type (
 )
 
 const (
-	PCAP_FSNINI = constants.PCAP_FSNINI
-	PCAP_FSNEND = constants.PCAP_FSNEND
-	PCAP_FSNERR = constants.PCAP_FSNERR
-	PCAP_CREATE = constants.PCAP_CREATE
-	PCAP_EXPORT = constants.PCAP_EXPORT
-	PCAP_QUEUED = constants.PCAP_QUEUED
-	PCAP_OSWMEM = constants.PCAP_OSWMEM
-	PCAP_SIGNAL = constants.PCAP_SIGNAL
-	PCAP_FSLOCK = constants.PCAP_FSLOCK
+	PCAP_FSNINI   = constants.PCAP_FSNINI
+	PCAP_FSNEND   = constants.PCAP_FSNEND
+	PCAP_FSNERR   = constants.PCAP_FSNERR
+	PCAP_CREATE   = constants.PCAP_CREATE
+	PCAP_EXPORT   = constants.PCAP_EXPORT
+	PCAP_QUEUED   = constants.PCAP_QUEUED
+	PCAP_OSWMEM   = constants.PCAP_OSWMEM
+	PCAP_SIGNAL   = constants.PCAP_SIGNAL
+	PCAP_FSLOCK   = constants.PCAP_FSLOCK
+	PCAP_MFLUSH   = constants.PCAP_MFLUSH
+	PCAP_SCHEDL   = constants.PCAP_SCHEDL
+	PCAP_RMOUNT   = constants.PCAP_RMOUNT
+	PCAP_SLO      = constants.PCAP_SLO
+	PCAP_IFACES   = constants.PCAP_IFACES
+	PCAP_PRESSURE = constants.PCAP_PRESSURE
+	PCAP_ANALYSIS = constants.PCAP_ANALYSIS
+	PCAP_MIRROR   = constants.PCAP_MIRROR
+	PCAP_CHKPNT   = constants.PCAP_CHKPNT
+	PCAP_BACKFILL = constants.PCAP_BACKFILL
+	PCAP_WINDOW   = constants.PCAP_WINDOW
+	PCAP_FETCH    = constants.PCAP_FETCH
+	PCAP_SAMPLE   = constants.PCAP_SAMPLE
+	PCAP_CANARY   = constants.PCAP_CANARY
+	PCAP_CODECS   = constants.PCAP_CODECS
 )
 
 const (
@@ -62,6 +114,23 @@ const (
 	dockerCgroupMemoryUtilization = "/sys/fs/cgroup/memory.current"
 	procSysVmDropCaches           = "/proc/sys/vm/drop_caches"
 	pcapLockFile                  = "/var/lock/pcap.lock"
+	procDir                       = "/proc"
+	activeFlushSettle             = 100 * time.Millisecond
+	heldOpenRetryDelay            = 1 * time.Second
+	windowCheckInterval           = 1 * time.Second
+	selfTestFilePattern           = ".pcapfsn-selftest-*"
+	postprocessFilePrefix         = ".pcapfsn-postprocess-"
+	flushOSBuffersTask            = "flush_os_buffers"
+	// `flush_os_buffers` executions triggered by `flush_every_n`
+	flushTriggerExports = "exports"
+	// `tcpdumpw` must terminate within this time after the exporter is signaled
+	pcapLockDeadline  = 3 * time.Second
+	finalFlushTimeout = 5 * time.Second
+	// events of the watched directory are buffered while the events loop is exporting PCAP files
+	watcherEventsBuffer   = 100
+	queueSampleInterval   = 100 * time.Millisecond
+	queueNearFull         = 0.9
+	queueSustainedSamples = 50
 )
 
 var (
@@ -73,429 +142,3839 @@ var (
 	gcp_run       = flag.Bool("run", true, "Cloud Run execution environment")
 	gcp_gae       = flag.Bool("gae", false, "App Engine execution environment")
 	gcp_gke       = flag.Bool("gke", false, "Kubernetes Engine execution environment")
-	interval      = flag.Uint("interval", 60, "seconds after which tcpdump rotates PCAP files")
+	interval      = durations.Flag("interval", 60*time.Second, "time after which tcpdump rotates PCAP files")
 	retries_max   = flag.Uint("retries_max", 5, "times a failed copy-to-GCS operation should be retried")
-	retries_delay = flag.Uint("retries_delay", 2, "seconds between retries for copy-to-GCS operations")
+	retries_delay = durations.Flag("retries_delay", 2*time.Second, "time between retries for copy-to-GCS operations")
 	compat        = flag.Bool("compat", false, "apply filters in Cloud Run gen1 mode")
 	rt_env        = flag.String("rt_env", "cloud_run_gen2", "runtime where PCAP sidecar is used")
 	pcap_debug    = flag.Bool("debug", false, "enable debug logs")
 	gcs_export    = flag.Bool("gcs_export", true, "export PCAP files to GCS")
 	gcs_fuse      = flag.Bool("gcs_fuse", true, "export PCAP files using GCS Fuse")
 	gcs_bucket    = flag.String("gcs_bucket", "", "export PCAP files to this GCS bucket")
+	gcs_kms_key   = flag.String("gcs_kms_key", "", "Cloud KMS key used to encrypt PCAP files exported using the GCS client library; empty uses the bucket's default encryption")
+	gcs_temp_dir  = flag.String("gcs_temp_dir", "", "staging directory where PCAP files are written before being moved into 'gcs_dir'; empty sources it from the config file, or disables staging")
 	instance_id   = flag.String("instance_id", "", "compute resource hosting the PCAP sidecar")
+	watch_mode    = flag.String("watch_mode", "auto", "how to detect new PCAP files; any of: inotify, poll, auto")
+	poll_interval = durations.Flag("poll_interval", 1*time.Second, "time between source directory listings when polling for new PCAP files")
+	watch_retries = flag.Uint("watch_init_retries", 5, "times watching the source directory is retried when it fails at startup, i.e.: while it is not mounted yet")
+	watch_delay   = durations.Flag("watch_init_delay", 1*time.Second, "time before the first retry to watch the source directory; it doubles after every retry")
+	export_on     = flag.String("export_on", "create", "event which makes a PCAP file a candidate for export; any of: create ( the next PCAP file is created ), write-close ( it is no longer written ), rename ( it is renamed into place )")
+	export_quiet  = durations.Flag("export_quiet", 5*time.Second, "time without writes after which a PCAP file is exported when 'export_on' is 'write-close'")
+	queue_report  = durations.Flag("queue_report", 60*time.Second, "time between reports of how full the buffer of events of the source directory gets; 0 disables it")
+	trace_events  = flag.Bool("trace_events", false, "log every event of the source directory at debug level, including those which are ignored; for debugging only")
+	selftest      = flag.Bool("selftest", true, "verify write access to the source and destination directories at startup")
+	config_file   = flag.String("config", "", "PCAP sidecar JSON config file; when set, PCAP files extensions are also sourced from it")
+	ready_timeout = durations.Flag("ready_timeout", 10*time.Second, "time to wait for the 'tcpdumpw' readiness signal before falling back to counting all PCAP files; 0 disables waiting")
+	status_addr   = flag.String("status_addr", "", "address where health checks are served at '/healthz'; i.e.: ':12346'; empty uses the port next to the config file healthcheck port, or disables it; 'none' disables it")
+	pprof_addr    = flag.String("pprof_addr", "", "address where CPU, heap, and goroutine profiles are served at '/debug/pprof/'; i.e.: '127.0.0.1:6060'; exposes internals; empty disables it")
+	gcs_dir_check = durations.Flag("gcs_dir_check", 5*time.Second, "time between checks of the destination directory; exports are paused while it is missing; 0 disables it")
+	timezone      = flag.String("timezone", "UTC", "timezone used by 'tcpdumpw' to name PCAP files")
+	slo_target    = durations.Flag("durability_slo", 0*time.Second, "time from a PCAP file rotation to its export that should not be exceeded; 0 disables SLO evaluation")
+	slo_ratio     = flag.Float64("durability_slo_ratio", 0.05, "ratio of recent exports allowed to exceed the durability SLO before the exporter is flagged as degraded")
+	flush_every_n = flag.Uint("flush_every_n", 0, "also flush OS file write buffers after every this many exported PCAP files; 0 only flushes them every 'interval'")
+	exp_latency   = flag.Bool("export_latency", false, "log the time from the creation of every exported PCAP file to the completion of its export, and track it per interface")
+	slo_report    = durations.Flag("slo_report", 60*time.Second, "time between durability latency reports; 0 disables them")
+	wait_for_dest = durations.Flag("wait_for_dest", 0*time.Second, "time to wait for the destination directory to exist and be writable at startup; 0 disables waiting")
+	min_free      = flag.Uint64("min_free_bytes", 0, "defer exports while the destination directory has fewer bytes available; 0 disables the check")
+	iface_spec    = flag.String("iface", "", "network interfaces to capture from; any of: 'any', names prefixes, or globs; empty sources it from the config file")
+	iface_refresh = durations.Flag("iface_refresh", 30*time.Second, "time between network interfaces discovery; 0 disables it")
+	iface_down    = flag.Bool("iface_skip_down", false, "exclude network interfaces which are down from the resolved interfaces")
+	check_config  = flag.Bool("check_config", false, "validate flags, log the effective configuration, and exit")
+	psi_threshold = flag.Float64("pressure_threshold", 0, "CPU, memory or IO pressure ( PSI 'some avg10' ) at which exports are throttled; 0 disables it")
+	psi_check     = durations.Flag("pressure_check", 5*time.Second, "time between pressure readings")
+	key_template  = flag.String("key_template", string(naming.DefaultKeyTemplate), "how PCAP files are grouped into the PCAP files of a single 'tcpdump' instance, which are exported in order; placeholders: {index} ( interface sub-identifier ), {iface}, and {ext}; {iface} and {ext} are required")
+	sanitize      = flag.String("sanitize", "safe", "how interface names are percent-encoded in destination file names; any of: off, safe, strict")
+	ordered       = flag.Bool("ordered", false, "flush PCAP files sequentially in rotation order for each interface")
+	min_age       = durations.Flag("min_age_before_export", 0, "defer exports of PCAP files modified more recently than this; younger PCAP files are exported once they are old enough; 0 disables it")
+	fast_fail     = durations.Flag("fast_fail", 5*time.Second, "time during which exports fail fast after a transient destination failure; out of space and permission failures last 12 times longer; 0 disables it")
+	export_config = flag.Bool("export_config", true, "export the redacted config file along with PCAP files; changes are exported as numbered snapshots")
+	print_version = flag.Bool("version", false, "print version information and exit")
+	version_json  = flag.Bool("version_json", false, "print version information as JSON and exit")
+	dump_schemas  = flag.Bool("dump_event_schemas", false, "print the JSON schemas of all structured log events and exit")
+	shard_count   = flag.Uint("shard_count", 0, "spread exported PCAP files across this many 'shardNN/' sub-prefixes of the destination directory; 0 disables it")
+	shard_prefix  = flag.Uint("shard_prefixes", 0, "place the destination directory of this session under one of this many top-level prefixes of the GCS bucket; 0 disables it")
+	postproc      = flag.String("postprocess", "", "analyze exported PCAP files in the background; either 'summary', or a command template such as 'tshark -q -z io,phs -r {{.Source}}'; empty disables it")
+	json_summary  = flag.Bool("json_summary", false, "export a JSON summary next to every exported PCAP file: packet and byte counts, first and last timestamps, interface, and BPF filter")
+	postproc_time = durations.Flag("postprocess_timeout", 60*time.Second, "wall-clock time after which the analysis of a PCAP file is killed; 0 disables it")
+	postproc_cpu  = durations.Flag("postprocess_cpu", 30*time.Second, "CPU time allowed to a post-processing command for a single PCAP file; 0 disables it")
+	postproc_mem  = flag.Uint64("postprocess_memory", 256<<20, "virtual memory bytes allowed to a post-processing command; 0 disables it")
+	postproc_nice = flag.Int("postprocess_nice", 10, "niceness of post-processing commands; from 0 to 19")
+	mirror_dir    = flag.String("mirror_dir", "", "directory where the most recent PCAP files are mirrored for instant access; empty disables it")
+	mirror_window = durations.Flag("mirror_window", 15*time.Minute, "age of the oldest mirrored PCAP file; 0 disables it")
+	mirror_files  = flag.Int("mirror_files", 0, "PCAP files mirrored for each interface; 0 disables it")
+	mirror_bytes  = flag.Int64("mirror_max_bytes", 512<<20, "size of all mirrored PCAP files; 0 disables it")
+	export_time   = durations.Flag("export_timeout", 0*time.Second, "time after which a stuck PCAP file export is cancelled, and the PCAP file is left for the next flush; 0 uses the rotation interval")
+	active_flush  = flag.Bool("active_flush", false, "on shutdown, signal 'tcpdump' with SIGUSR2 and wait for in-progress PCAP files to stop growing before the final flush")
+	flush_timeout = durations.Flag("active_flush_timeout", 1*time.Second, "time to wait for in-progress PCAP files to stop growing after signaling 'tcpdump'")
+	report_file   = flag.String("report_file", "", "path where a JSON report of the session is written on shutdown: exports, failures, and abandoned PCAP files; empty disables it")
+	lock_failure  = flag.String("lock_failure", string(lifecycle.LOCK_FAILURE_SKIP_CURRENT), "final flush when the PCAP lock file is not acquired before the deadline; any of: abort ( PCAP files are preserved ), force ( all PCAP files are flushed ), skip-current ( all PCAP files but the latest one of every key are flushed )")
+	shutdown_sigs = flag.String("shutdown_signals", lifecycle.DefaultShutdownSignals, "comma-separated list of signals which trigger a graceful shutdown; any of: SIGTERM, SIGINT, SIGQUIT, SIGHUP, SIGUSR2; SIGHUP reloads the extensions of PCAP files from the config file unless listed")
+	skip_open     = flag.Bool("skip_open", false, "never export PCAP files held open by 'tcpdump', as listed by '/proc/<pid>/fd'; PIDs are read from 'capture_pidfile' or discovered")
+	capture_pids  = flag.String("capture_pidfile", "", "file with the PIDs of 'tcpdump' processes, one per line; empty discovers them using '/proc'")
+	log_fields    = flag.String("log_fields", "", "comma-separated list of identity fields attached to every log event; empty sources it from the config file, or attaches all of them")
+	ckpt_interval = durations.Flag("checkpoint", 0*time.Second, "time between copies of the PCAP files being written into '<file>.partial' at the destination; must divide 'interval'; 0 disables it")
+	bf_bytes      = flag.Int64("backfill_bytes_per_sec", 0, "bandwidth allowed to the export of PCAP files accumulated while exports were paused; 0 disables the limit")
+	bf_ops        = flag.Float64("backfill_ops_per_sec", 0, "PCAP files exports per second allowed when draining PCAP files accumulated while exports were paused; 0 disables the limit")
+	bf_adaptive   = flag.Bool("backfill_adaptive", false, "start draining accumulated PCAP files at a fraction of 'backfill_bytes_per_sec', and only speed up while the durability SLO is healthy")
+	bf_report     = durations.Flag("backfill_report", 60*time.Second, "time between backfill progress reports; 0 disables them")
+	comp_min      = flag.Int("compress_min_level", 1, "lowest gzip level used by adaptive compression; 0 allows disabling compression for incompressible interfaces")
+	comp_max      = flag.Int("compress_max_level", 9, "highest gzip level used by adaptive compression")
+	comp_adaptive = flag.Bool("compress_adaptive", false, "adapt the gzip level of each interface to the compression ratio of its recent exports")
+	comp_window   = flag.Int("compress_window", 10, "exports of an interface after which its gzip level is re-evaluated")
+	comp_levels   = flag.String("compress_levels", "", "comma-separated list of '<iface>=<level>' gzip levels which pin the compression of PCAP files per interface; empty sources it from the config file")
+	files_token   = flag.String("files_token_file", "", "file containing the bearer token required by operator commands and to retrieve exported PCAP files at '/files' of 'status_addr'; retrieval requires 'gcs_fuse'; empty disables both")
+	files_max     = flag.Int64("files_max_bytes", 64<<20, "max bytes served by a single '/files' response; larger files must be retrieved using range requests")
+	files_rate    = flag.Uint("files_per_minute", 6, "max requests per minute allowed at '/files'; 0 disables the limit")
+	sample_snap   = flag.Uint("session_sample_snaplen", 128, "bytes kept from each packet of the PCAP files exported by sessions which are not sampled, when the config file sampling mode is 'headers'")
+	sample_force  = flag.Bool("session_sample_force", false, "export the PCAP files of this session with full fidelity regardless of the config file sampling rate")
+	canary_every  = durations.Flag("canary_interval", 3600*time.Second, "time between characterizations of the destination using canary objects; requires 'gcs_fuse'; 0 disables them")
+	canary_delay  = durations.Flag("canary_delay", 120*time.Second, "time after which canary objects are read back to detect their premature deletion")
+	cmp_codecs    = flag.Bool("compare_codecs", false, "BENCHMARKING ONLY: also compress every exported PCAP file using gzip and zstd, and log the size and time of both; requires 'zstd'; never enable it in production")
+	export_first  = flag.Bool("export_first", false, "export the first PCAP file of every interface once it stops growing, without waiting for it to be rotated")
+	first_after   = durations.Flag("export_first_after", 10*time.Second, "time the first PCAP file of every interface must not change before it is exported when 'export_first' is enabled")
+	short_lived   = flag.String("short_lived", "auto", "optimize for instances that live shorter than one rotation: 'auto' enables it on Cloud Run when 'interval' exceeds 'short_lived_lifetime'; any of: auto, on, off")
+	short_life    = durations.Flag("short_lived_lifetime", 90*time.Second, "expected lifetime of short-lived instances; used by 'short_lived' in 'auto' mode")
+	audit_fields  = flag.String("audit_fields", "", "comma-separated list of identity fields attached to GCS audit logs; empty sources it from the config file, or uses: project,service,instance")
 )
 
+// every consumed env var is included in the startup summary
+var environ = env.NewFromOS()
+
 var (
-	projectID  string = os.Getenv("PROJECT_ID")
-	gcpRegion  string = os.Getenv("GCP_REGION")
-	service    string = os.Getenv("APP_SERVICE")
-	version    string = os.Getenv("APP_VERSION")
-	sidecar    string = os.Getenv("APP_SIDECAR")
-	instanceID string = os.Getenv("INSTANCE_ID")
-	module     string = os.Getenv("PROC_NAME")
-	gcpGAE     string = os.Getenv("PCAP_GAE")
+	projectID  string = environ.String("PROJECT_ID", "")
+	gcpRegion  string = environ.String("GCP_REGION", "")
+	service    string = environ.String("APP_SERVICE", "")
+	version    string = environ.String("APP_VERSION", "")
+	sidecar    string = environ.String("APP_SIDECAR", "")
+	instanceID string = environ.String("INSTANCE_ID", "")
+	module     string = environ.String("PROC_NAME", "")
 )
 
 var (
 	logger   = log.NewLogger(projectID, service, gcpRegion, version, instanceID, sidecar, module)
 	exporter = gcs.NewNilExporter(logger)
 
-	counters *haxmap.Map[string, *atomic.Uint64]
-	lastPcap *haxmap.Map[string, string]
+	healthServer = health.NewServer()
+
+	counters *syncmap.Map[string, *atomic.Uint64]
+	lastPcap *syncmap.Map[string, string]
+	gaps     *rotation.GapDetector
+
+	// event which makes a PCAP file a candidate for export
+	exportTrigger = watch.TRIGGER_CREATE
+	// `nil` when the buffer of events is not sampled
+	eventsQueue *watch.QueueSampler
+	// final flush when the PCAP lock file is not acquired
+	lockFailure = lifecycle.LOCK_FAILURE_SKIP_CURRENT
+
+	// rotation timestamps in PCAP file names are local to the capture timezone
+	captureLocation = time.UTC
+	durabilitySLO   *slo.Tracker
+	// `nil` when export latencies are not tracked
+	exportLatencies *slo.Tracker
+
+	configSnapshots  = snapshot.NewSnapshots()
+	configSnapshotMu sync.Mutex
+	// BPF filter last read from the config file, guarded by `configSnapshotMu`; `nil` before the first check
+	configFilter *string
+
+	// while throttled, exports run one at a time
+	pressureMonitor *pressure.Monitor
+	throttleMu      sync.Mutex
+
+	// `nil` when exported PCAP files are not sharded
+	shards *gcs.Shards
+
+	// `nil` when the destination directory is not placed under a prefix shard
+	prefixShard *gcs.PrefixShard
+
+	// `nil` when exported PCAP files are not analyzed
+	postprocessor *postprocess.Runner
+
+	// `nil` when PCAP files are not mirrored
+	pcapMirror *mirror.Mirror
+
+	// `nil` when the PCAP files being written are not checkpointed
+	checkpoints *checkpoint.Checkpointer
+
+	// `nil` when PCAP files are exported regardless of the cron schedule
+	captureWindow *window.Tracker
+
+	// `nil` when the destination is not characterized using canary objects
+	canaryProber *canary.Prober
+
+	// `nil` when exported PCAP files cannot be retrieved through the status server
+	fileServer *files.Server
+
+	// decides the gzip level of exported PCAP files per interface; the default gzip level by default
+	compressionAdapter = compression.NewAdapter(compression.Bounds{Min: 1, Max: 9}, false, 1, nil)
+
+	// decides whether PCAP files of this session are exported with full fidelity; all sessions are sampled by default
+	sessionSampler = sampling.NewSampler(sampling.Decision{Sampled: true, Rate: 1})
+
+	// name of the active capture profile; empty when the config does not select one
+	captureProfile string
+
+	// codecs compared when exporting every PCAP file; empty unless benchmarking
+	comparedCodecs []compression.Codec
+
+	// paces the export of PCAP files accumulated while exports were paused; unlimited by default
+	backfills = backfill.NewController(backfill.Limits{}, false, nil)
+)
+
+// owns the long-lived goroutines of the current run; `nil` until `main` starts watching
+var session *lifecycle.Group
+
+var isFlushing, exportsPaused, exportsPausedByOperator, exportsPausedByWindow atomic.Bool
+
+var (
+	activeFlushStarted atomic.Bool
+	activeFlushDone    = make(chan []*activeflush.Result, 1)
+)
+
+var origBytesTotal, compBytesTotal atomic.Int64
+
+// bytes exported by the current session, including PCAP files which are not compressed
+var exportedBytesTotal atomic.Int64
+
+// exports attempted by the current session, and PCAP files which the final flush failed to export
+var (
+	exportedFilesTotal, failedExportsTotal atomic.Uint64
+	abandonedPcapFiles                     = syncmap.New[string, string]()
+)
+
+// eventIDs correlate all log events about a PCAP file, from its creation being accepted until it is exported
+var eventIDs = syncmap.New[string, string]()
+
+var (
+	// `nil` until scheduled tasks are registered
+	tasks *scheduler.Scheduler
+	// successful exports since the exporter started; counted only when `flush_every_n` is set
+	exportsSinceStart atomic.Uint64
+)
+
+var (
+	// whether the short-lifetime optimizations apply to the current session
+	shortLived shortlived.Decision
+	// set once any PCAP file is rotated: until then, `tcpdump` is still writing into the first PCAP files
+	rotationObserved atomic.Bool
+	// set when shutdown skipped waiting for `tcpdumpw` termination
+	fastShutdown atomic.Bool
+)
+
+type firstExport struct {
+	mu   sync.Mutex
+	path string
+	// size of the PCAP file when it was exported; `-1` when it was not exported
+	size int64
+}
+
+// PCAP files whose export is deferred until they are older than `min_age_before_export`
+var agingPcapFiles sync.Map
+
+var (
+	// first PCAP file of every key when `export_first` is enabled; they are exported before being rotated
+	firstExports   = make(map[string]*firstExport)
+	firstExportsMu sync.Mutex
+	// PCAP files exported before being rotated; exporting them again replaces that copy
+	firstCopies sync.Map
+)
+
+var (
+	errExportsPaused           = errors.New("exports are paused: destination directory is unavailable")
+	errExportsPausedByOperator = errors.New("exports are paused by an operator")
+	errExportsOutsideWindow    = errors.New("exports are paused: outside of capture windows")
+	errSessionNotSampled       = errors.New("PCAP file dropped: session is not sampled")
+	errPcapFileHeldOpen        = errors.New("PCAP file is held open by 'tcpdump'")
+	errNothingToReload         = errors.New("nothing to reload: no config file")
+	errCanaryFailed            = errors.New("canary objects cannot be written and read back")
+	errTcpdumpwExited          = errors.New("detected 'tcpdumpw' termination signal")
+	errPcapLockAcquired        = errors.New("acquired PCAP lock file")
+	errShutdownDeadline        = errors.New("signaled: 'tcpdumpw' did not terminate before the deadline")
+	errShortLivedShutdown      = errors.New("signaled: no PCAP file was rotated, skipped waiting for 'tcpdumpw' termination")
 )
 
-var isActive atomic.Bool
+// newFlushOSBuffersTask flushes OS file write buffers;
+// it is safe: 'non-destructive operation and will not free any dirty objects'.
+// additionally, PCAP files are [write|append]-only
+func newFlushOSBuffersTask(isGAE bool) scheduler.TaskFunc {
+	return func(ctx context.Context) error {
+		trigger := scheduler.TriggerOf(ctx)
+		memoryBefore, _ := getCurrentMemoryUtilization(isGAE)
+		_, memFlushErr := flushBuffers()
+		memoryAfter, _ := getCurrentMemoryUtilization(isGAE)
+		if memFlushErr != nil {
+			return memFlushErr
+		}
+		releasedMemory := int64(memoryBefore) - int64(memoryAfter)
+		logger.LogEvent(zapcore.InfoLevel,
+			fmt.Sprintf("flushed OS file write buffers: [%s] memory[before=%d|after=%d] / released=%d", trigger, memoryBefore, memoryAfter, releasedMemory),
+			&telemetry.OsWMem{Before: telemetry.Ptr(memoryBefore), After: telemetry.Ptr(memoryAfter), Released: telemetry.Ptr(releasedMemory), Trigger: trigger}, nil)
+		return nil
+	}
+}
 
 func movePcapToGcs(
 	ctx context.Context,
 	srcPcap *string,
 	compress, delete bool,
 ) (*string, *int64, error) {
-	return exporter.Export(ctx, srcPcap, compress, delete)
-}
+	if exportsPaused.Load() {
+		tgtPcap := ""
+		pcapBytes := int64(0)
+		return &tgtPcap, &pcapBytes, errExportsPaused
+	}
+	if exportsPausedByOperator.Load() {
+		tgtPcap := ""
+		pcapBytes := int64(0)
+		return &tgtPcap, &pcapBytes, errExportsPausedByOperator
+	}
+	if exportsPausedByWindow.Load() {
+		tgtPcap := ""
+		pcapBytes := int64(0)
+		return &tgtPcap, &pcapBytes, errExportsOutsideWindow
+	}
+	if isHeldOpen(*srcPcap) {
+		tgtPcap := ""
+		pcapBytes := int64(0)
+		return &tgtPcap, &pcapBytes, errPcapFileHeldOpen
+	}
 
-func getCurrentMemoryUtilization(isGAE bool) (uint64, error) {
-	var err error
-	var memoryUtilizationFilePath string
+	// once a PCAP file is exported, the sampling decision of the session is final
+	sample := sessionSampler.Export()
+	if err := applySampling(*srcPcap, delete, sample); err != nil {
+		tgtPcap := ""
+		pcapBytes := int64(0)
+		return &tgtPcap, &pcapBytes, err
+	}
+	metadata := sample.Metadata()
+	if captureProfile != "" {
+		metadata["profile"] = captureProfile
+	}
+	if prefixShard != nil {
+		metadata["shard-prefix"] = prefixShard.Token
+	}
+	ctx = gcs.WithMetadata(ctx, metadata)
+	if _, copied := firstCopies.Load(*srcPcap); copied {
+		// packets were written into the PCAP file after it was exported before being rotated
+		ctx = gcs.WithReplace(ctx)
+	}
 
-	if isGAE {
-		memoryUtilizationFilePath = dockerCgroupMemoryUtilization
+	if pressureMonitor != nil && pressureMonitor.Throttled() {
+		// the main application is under pressure: do not compete with it for resources
+		throttleMu.Lock()
+		defer throttleMu.Unlock()
+		compress = false
+	}
+
+	var decision compression.Decision
+	if compress {
+		if pcapFile, err := naming.ParseBaseName(*srcPcap); err == nil {
+			decision = compressionAdapter.Decide(pcapFile.Iface)
+			compress = decision.Compress
+			ctx = compression.WithLevel(ctx, decision.Level)
+		}
+	}
+
+	// source PCAP files are deleted after being exported: stat before copying
+	var origBytes int64 = -1
+	if compress {
+		if info, err := os.Stat(*srcPcap); err == nil {
+			origBytes = info.Size()
+		}
+	}
+
+	// source PCAP files are deleted after being exported: analysis uses a hard link to them
+	staged := stageForPostprocessing(*srcPcap)
+	// ... and summaries are produced before
+	fileSummary := newFileSummary(ctx, *srcPcap)
+
+	if len(comparedCodecs) > 0 {
+		// the source PCAP file is deleted by the export: all codecs must complete before
+		compareCodecs(ctx, *srcPcap)
+	}
+
+	tgtPcap, pcapBytes, err := exporter.Export(ctx, srcPcap, compress, delete)
+	if err == nil && pcapBytes != nil {
+		exportedBytesTotal.Add(*pcapBytes)
+	}
+	if err == nil {
+		exportedFilesTotal.Add(1)
+		countExportForFlush()
+		firstCopies.Delete(*srcPcap)
 	} else {
-		memoryUtilizationFilePath = cgroupMemoryUtilization
+		failedExportsTotal.Add(1)
+	}
+	if *gcs_export && !*gcs_fuse && *gcs_kms_key != "" {
+		reportKMSKey(err)
 	}
 
-	memoryUtilizationFile, err := os.OpenFile(memoryUtilizationFilePath, os.O_RDONLY, 0o444 /* -r--r--r-- */)
+	if err == nil && origBytes >= 0 && pcapBytes != nil {
+		reportCompression(*srcPcap, *tgtPcap, origBytes, *pcapBytes, &decision)
+	} else if err == nil && decision.Iface != "" {
+		// uncompressed exports are counted to eventually probe whether the interface became compressible
+		compressionAdapter.Record(decision, 0, 0)
+	}
+
+	if staged != "" {
+		if err == nil {
+			postprocessor.Submit(&postprocess.Job{Source: staged, Name: filepath.Base(*tgtPcap)})
+		} else {
+			os.Remove(staged)
+		}
+	}
+
+	if err == nil && fileSummary != nil {
+		exportFileSummary(ctx, fileSummary, *tgtPcap)
+	}
+
+	if err == nil && shards != nil {
+		healthServer.SetInfo("shards", shards.Counts())
+	}
+
+	return tgtPcap, pcapBytes, err
+}
+
+// newFileSummary summarizes the PCAP file at `srcPcap` when `json_summary` is enabled;
+// it returns `nil` otherwise, or when the PCAP file cannot be summarized.
+func newFileSummary(
+	ctx context.Context,
+	srcPcap string,
+) *postprocess.FileSummary {
+	if !*json_summary {
+		return nil
+	}
+	iface := ""
+	if pcapFile, err := naming.ParseBaseName(srcPcap); err == nil {
+		iface = pcapFile.IfaceID()
+	}
+	summary, err := postprocess.NewFileSummary(ctx, srcPcap, filepath.Base(srcPcap), iface, currentConfigFilter())
 	if err != nil {
-		return 0, err
+		// summaries never affect exports
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("failed to summarize PCAP file: %s", srcPcap), PCAP_ANALYSIS, srcPcap, "", 0, err)
+		return nil
 	}
+	return summary
+}
 
-	var memoryUtilization int
-	_, err = fmt.Fscanf(memoryUtilizationFile, "%d\n", &memoryUtilization)
+// exportFileSummary exports `summary` next to the exported PCAP file `tgtPcap`.
+func exportFileSummary(
+	ctx context.Context,
+	summary *postprocess.FileSummary,
+	tgtPcap string,
+) {
+	summary.Name = filepath.Base(tgtPcap)
+	content, err := json.Marshal(summary)
 	if err != nil {
-		if err == io.EOF {
-			return uint64(memoryUtilization), nil
+		logger.LogEvent(zapcore.ErrorLevel, "failed to create PCAP file summary", &telemetry.FsnErr{}, err)
+		return
+	}
+
+	// the summary file name is preserved at the destination
+	tmpDir, err := os.MkdirTemp("", "pcapfsn-summary-*")
+	if err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, "failed to create PCAP file summary", &telemetry.FsnErr{}, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcSummary := filepath.Join(tmpDir, summary.Name+postprocess.SummarySuffix)
+	if err := os.WriteFile(srcSummary, content, 0o644); err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, "failed to create PCAP file summary", &telemetry.FsnErr{}, err)
+		return
+	}
+
+	tgtSummary, summaryBytes, err := exporter.Export(ctx, &srcSummary, false /* compress */, true /* delete */)
+	if err != nil {
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("failed to export summary of PCAP file: %s", tgtPcap), PCAP_ANALYSIS, srcSummary, *tgtSummary, 0, err)
+		return
+	}
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("exported summary of PCAP file: %s", tgtPcap), PCAP_ANALYSIS, srcSummary, *tgtSummary, *summaryBytes, nil)
+}
+
+// currentConfigFilter returns the BPF filter of the config file; it is empty when disabled or unknown.
+func currentConfigFilter() string {
+	configSnapshotMu.Lock()
+	defer configSnapshotMu.Unlock()
+	if configFilter == nil {
+		return ""
+	}
+	return *configFilter
+}
+
+// reportKMSKey degrades the exporter while the customer-managed encryption key cannot be used;
+// PCAP files which fail to be exported remain at the source directory.
+func reportKMSKey(
+	err error,
+) {
+	const component = "kms_key"
+
+	if errors.Is(err, gcs.ErrKMSKeyInaccessible) {
+		healthServer.SetDegraded(component, err.Error())
+	} else if err == nil {
+		healthServer.ClearDegraded(component)
+	}
+}
+
+// stageForPostprocessing links the source PCAP file into a hidden file which is not matched as a PCAP file;
+// it returns `""` when the PCAP file should not be analyzed.
+func stageForPostprocessing(
+	srcPcap string,
+) string {
+	if postprocessor == nil || (pressureMonitor != nil && pressureMonitor.Throttled()) {
+		return ""
+	}
+	staged := filepath.Join(filepath.Dir(srcPcap), postprocessFilePrefix+filepath.Base(srcPcap))
+	if err := os.Link(srcPcap, staged); err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("skipping analysis of PCAP file: %s", srcPcap), PCAP_ANALYSIS, srcPcap, staged, 0, err)
+		return ""
+	}
+	return staged
+}
+
+func newPostprocessor(
+	spec string,
+) (*postprocess.Runner, error) {
+	limits := postprocess.Limits{
+		CPU:     *postproc_cpu,
+		Memory:  *postproc_mem,
+		Timeout: *postproc_time,
+		Nice:    *postproc_nice,
+	}
+	processor, err := postprocess.New(spec, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	export := func(ctx context.Context, job *postprocess.Job, analysisFile string) error {
+		_, _, err := exporter.Export(ctx, &analysisFile, false /* compress */, true /* delete */)
+		return err
+	}
+
+	onResult := func(job *postprocess.Job, err error) {
+		if err != nil {
+			// analysis never affects exports
+			logger.LogEvent(zapcore.WarnLevel,
+				fmt.Sprintf("failed to analyze PCAP file: %s", job.Name),
+				&telemetry.Analysis{Processor: processor.Name(), Target: job.Name}, err)
+			return
+		}
+		logger.LogEvent(zapcore.InfoLevel,
+			fmt.Sprintf("exported analysis of PCAP file: %s", job.Name),
+			&telemetry.Analysis{Processor: processor.Name(), Target: job.Name + postprocess.AnalysisSuffix}, nil)
+	}
+
+	// analysis is skipped while the main application is under pressure
+	skip := func() bool {
+		return pressureMonitor != nil && pressureMonitor.Throttled()
+	}
+
+	return postprocess.NewRunner(processor, limits, export, onResult, skip), nil
+}
+
+// exportConfigSnapshot exports the redacted config file after PCAP files are successfully exported,
+// so that captures can always be traced back to the config that produced them.
+func exportConfigSnapshot(
+	ctx context.Context,
+) {
+	if *config_file == "" {
+		return
+	}
+
+	configSnapshotMu.Lock()
+	defer configSnapshotMu.Unlock()
+
+	content, err := os.ReadFile(*config_file)
+	if err == nil {
+		checkFilterDrift(content)
+	}
+	if !*export_config {
+		return
+	}
+	if err == nil {
+		content, err = cfg.RedactJSON(content)
+	}
+	if err == nil {
+		content, err = snapshot.Redact(content)
+	}
+	if err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to read config file: %s", *config_file), &telemetry.FsnErr{}, err)
+		return
+	}
+
+	name, ok := configSnapshots.Next(content)
+	if !ok {
+		return
+	}
+
+	// the snapshot file name is preserved at the destination
+	tmpDir, err := os.MkdirTemp("", "pcapfsn-config-*")
+	if err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, "failed to create config snapshot", &telemetry.FsnErr{}, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcConfig := filepath.Join(tmpDir, name)
+	if err := os.WriteFile(srcConfig, content, 0o644); err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, "failed to create config snapshot", &telemetry.FsnErr{}, err)
+		return
+	}
+
+	// failed snapshots are not committed: they are retried after the next PCAP file export
+	tgtConfig, configBytes, err := exporter.Export(ctx, &srcConfig, false /* compress */, true /* delete */)
+	if err != nil {
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("failed to export config snapshot: %s", name), PCAP_EXPORT, *config_file, *tgtConfig, 0, err)
+		return
+	}
+	configSnapshots.Commit(content)
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("exported config snapshot: %s", *tgtConfig), PCAP_EXPORT, *config_file, *tgtConfig, *configBytes, nil)
+}
+
+// readConfigFilter returns the BPF filter set in the sidecar JSON config file; it is empty when disabled.
+func readConfigFilter(
+	content []byte,
+) (string, error) {
+	var config struct {
+		Pcap struct {
+			Filter struct {
+				BPF string `json:"bpf"`
+			} `json:"filter"`
+		} `json:"pcap"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", err
+	}
+	if filter := config.Pcap.Filter.BPF; !strings.EqualFold(filter, "DISABLED") {
+		return filter, nil
+	}
+	return "", nil
+}
+
+// checkFilterDrift re-checks the BPF filter whenever it changes in the config file: the capture keeps the filter it
+// started with, so a filter which no longer compiles is degraded now instead of failing at the next restart.
+// `configSnapshotMu` must be held.
+func checkFilterDrift(
+	content []byte,
+) {
+	const component = "filter"
+
+	filter, err := readConfigFilter(content)
+	if err != nil || configFilter != nil && *configFilter == filter {
+		return
+	}
+	drifted := configFilter != nil
+	configFilter = &filter
+
+	info := map[string]any{"filter": filter, "compiles": true}
+	compileErr := bpf.CompileCheck(filter)
+	if compileErr != nil {
+		info["compiles"] = false
+		info["error"] = compileErr.Error()
+	}
+	healthServer.SetInfo(component, info)
+
+	if compileErr == nil {
+		healthServer.ClearDegraded(component)
+		if drifted {
+			logger.LogEvent(zapcore.InfoLevel, "BPF filter changed",
+				&telemetry.FsnIni{Filter: telemetry.Ptr(filter), Compiles: telemetry.Ptr(true)}, nil)
+		}
+		return
+	}
+	healthServer.SetDegraded(component, fmt.Sprintf("BPF filter does not compile: %v", compileErr))
+	logger.LogEvent(zapcore.ErrorLevel, "BPF filter does not compile",
+		&telemetry.FsnErr{Filter: telemetry.Ptr(filter), Compiles: telemetry.Ptr(false)}, compileErr)
+}
+
+// reportCompression logs the sizes of a PCAP file before and after compression, along with the compression decision;
+// `ratio` is `orig_bytes / comp_bytes`: higher is better.
+func reportCompression(
+	srcPcap, tgtPcap string,
+	origBytes, compBytes int64,
+	decision *compression.Decision,
+) {
+	origBytesTotal.Add(origBytes)
+	compBytesTotal.Add(compBytes)
+
+	ratio := 0.0
+	if compBytes > 0 {
+		ratio = float64(origBytes) / float64(compBytes)
+	}
+
+	data := &telemetry.Export{
+		Source:    srcPcap,
+		Target:    tgtPcap,
+		OrigBytes: telemetry.Ptr(origBytes),
+		CompBytes: telemetry.Ptr(compBytes),
+		Ratio:     telemetry.Ptr(ratio),
+	}
+	if decision.Iface != "" {
+		// decisions are logged so that the gzip level of every exported PCAP file can be audited
+		data.Decision = decision
+		data.NextLevel = telemetry.Ptr(compressionAdapter.Record(*decision, origBytes, compBytes))
+		healthServer.SetInfo("compression", compressionAdapter.Levels())
+	}
+
+	logger.LogEvent(zapcore.InfoLevel,
+		fmt.Sprintf("compressed PCAP file: %d => %d bytes ( ratio: %.2f ) %s", origBytes, compBytes, ratio, tgtPcap),
+		data, nil)
+}
+
+// compareCodecs logs the size and time of the copies of `srcPcap` compressed by every compared codec,
+// using the gzip level decided for its interface.
+func compareCodecs(
+	ctx context.Context,
+	srcPcap string,
+) {
+	level := compression.Level(ctx)
+	if level == gzip.DefaultCompression || level == gzip.NoCompression {
+		level = 6 /* default gzip level */
+	}
+
+	origBytes, results, err := compression.Compare(ctx, srcPcap, level, comparedCodecs...)
+	if err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel, fmt.Sprintf("failed to compare codecs: %s", srcPcap), PCAP_CODECS, srcPcap, "" /* target PCAP file */, 0, err)
+		return
+	}
+
+	summary := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.Error != "" {
+			summary = append(summary, fmt.Sprintf("%s=error", result.Codec))
+		} else {
+			summary = append(summary, fmt.Sprintf("%s=%d/%s", result.Codec, result.Bytes, result.Duration))
+		}
+	}
+	logger.LogEvent(zapcore.InfoLevel,
+		fmt.Sprintf("compared codecs: %s [%d] %s", filepath.Base(srcPcap), origBytes, strings.Join(summary, " | ")),
+		&telemetry.Codecs{Source: srcPcap, Bytes: telemetry.Ptr(origBytes), Level: telemetry.Ptr(level), Codecs: results}, nil)
+}
+
+// applySampling reduces the PCAP file at `srcPcap` when the session is not sampled:
+// it is either dropped, or truncated to its packets headers; dropped PCAP files are deleted only if `delete` is set.
+func applySampling(
+	srcPcap string,
+	delete bool,
+	decision sampling.Decision,
+) error {
+	if decision.Sampled {
+		return nil
+	}
+	if decision.Mode == sampling.MODE_HEADERS {
+		err := sampling.Truncate(srcPcap, uint32(*sample_snap))
+		if err == nil {
+			return nil
+		}
+		// PCAP files which cannot be truncated must not be exported with full fidelity
+		logger.LogFsEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to truncate PCAP file: %s", srcPcap), PCAP_SAMPLE, srcPcap, "" /* target PCAP file */, 0, err)
+	}
+	if delete {
+		if err := os.Remove(srcPcap); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return errors.Join(errSessionNotSampled, err)
+		}
+	}
+	return errSessionNotSampled
+}
+
+// isDeferredExport returns `true` when a PCAP file was not exported but it remains available at `src_dir`.
+func isDeferredExport(err error) bool {
+	return errors.Is(err, gcs.ErrInsufficientSpace) ||
+		errors.Is(err, errExportsPaused) ||
+		errors.Is(err, errExportsPausedByOperator) ||
+		errors.Is(err, errExportsOutsideWindow) ||
+		errors.Is(err, errPcapFileHeldOpen) ||
+		errors.Is(err, gcs.ErrFastFail) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// mirrorPcapFile keeps a local copy of a PCAP file which is about to be exported;
+// PCAP files flushed on shutdown are not mirrored.
+func mirrorPcapFile(
+	srcPcap string,
+) {
+	if pcapMirror == nil {
+		return
+	}
+	linked, removed, err := pcapMirror.Add(srcPcap)
+	if err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("failed to mirror PCAP file: %s", srcPcap), PCAP_MIRROR, srcPcap, pcapMirror.Directory(), 0, err)
+	} else {
+		logger.LogEvent(zapcore.DebugLevel,
+			fmt.Sprintf("mirrored PCAP file: %s", srcPcap),
+			&telemetry.Mirror{Source: srcPcap, Linked: telemetry.Ptr(linked), Removed: removed}, nil)
+	}
+	publishMirror()
+}
+
+// publishMirror lists the mirrored PCAP files at `/healthz`.
+func publishMirror() {
+	if entries, err := pcapMirror.List(); err == nil {
+		healthServer.SetInfo("mirror", map[string]any{"directory": pcapMirror.Directory(), "files": entries})
+	}
+}
+
+// newCheckpointTask copies the new bytes of the PCAP files being written into their partial PCAP files.
+func newCheckpointTask() scheduler.TaskFunc {
+	return func(ctx context.Context) error {
+		if !sessionSampler.Decision().Sampled {
+			// partial PCAP files would be exported with full fidelity
+			return nil
+		}
+		// the PCAP files which are next to be exported are the ones being written
+		srcPcapFiles := []string{}
+		lastPcap.ForEach(func(_ string, srcPcapFile string) bool {
+			srcPcapFiles = append(srcPcapFiles, srcPcapFile)
+			return true
+		})
+
+		var errs []error
+		for _, srcPcapFile := range srcPcapFiles {
+			result, err := checkpoints.Checkpoint(ctx, srcPcapFile)
+			if err != nil && !os.IsNotExist(err) {
+				// the PCAP file may have been rotated and exported since it was listed
+				logger.LogFsEvent(zapcore.WarnLevel,
+					fmt.Sprintf("failed to checkpoint PCAP file: %s", srcPcapFile), PCAP_CHKPNT, srcPcapFile, "" /* target PCAP file */, 0, err)
+				errs = append(errs, err)
+			} else if result != nil {
+				logger.LogFsEvent(zapcore.DebugLevel,
+					fmt.Sprintf("checkpointed PCAP file at offset %d: %s", result.Offset, srcPcapFile), PCAP_CHKPNT, srcPcapFile, result.Partial, result.Bytes, nil)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// completeCheckpoint removes the partial PCAP file of an exported PCAP file.
+func completeCheckpoint(
+	ctx context.Context,
+	srcPcapFile string,
+) {
+	if checkpoints == nil {
+		return
+	}
+	partialPcapFile, err := checkpoints.Complete(ctx, srcPcapFile)
+	if err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("failed to remove partial PCAP file: %s", partialPcapFile), PCAP_CHKPNT, srcPcapFile, partialPcapFile, 0, err)
+	} else if partialPcapFile != "" {
+		logger.LogFsEvent(zapcore.DebugLevel,
+			fmt.Sprintf("removed partial PCAP file: %s", partialPcapFile), PCAP_CHKPNT, srcPcapFile, partialPcapFile, 0, nil)
+	}
+}
+
+// newEnforceMirrorTask removes mirrored PCAP files which are outside of the window, even if no new PCAP files are mirrored.
+func newEnforceMirrorTask() scheduler.TaskFunc {
+	return func(_ context.Context) error {
+		removed, err := pcapMirror.Enforce()
+		if len(removed) > 0 {
+			logger.LogEvent(zapcore.DebugLevel, fmt.Sprintf("removed %d mirrored PCAP files", len(removed)),
+				&telemetry.Mirror{Removed: removed}, err)
+		}
+		publishMirror()
+		return err
+	}
+}
+
+// newExportContext bounds a single PCAP file export, so that a stuck export does not hold its slot indefinitely.
+func newExportContext(
+	ctx context.Context,
+) (context.Context, context.CancelFunc) {
+	timeout := *export_time
+	if timeout == 0 {
+		// PCAP files are rotated at every interval: exports should not take longer
+		timeout = *interval
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// newWatchGcsDirTask pauses exports while the destination directory is missing,
+// and resumes them by flushing all pending PCAP files when it becomes available again.
+func newWatchGcsDirTask(
+	monitor *mount.Monitor,
+	flushChan chan<- os.Signal,
+) scheduler.TaskFunc {
+	const component = "gcs_dir"
+
+	return func(_ context.Context) error {
+		event, err := monitor.Check()
+		// the availability change is not logged as `event`: it would replace the event type
+		data := &telemetry.RMount{Directory: monitor.Directory(), DirectoryEvent: string(event)}
+
+		switch event {
+		case mount.EVENT_GONE:
+			exportsPaused.Store(true)
+			healthServer.SetUnready(component, "destination directory is unavailable")
+			logger.LogEvent(zapcore.ErrorLevel,
+				fmt.Sprintf("destination directory is gone: %s; pausing exports", monitor.Directory()), data, err)
+		case mount.EVENT_BACK, mount.EVENT_REMOUNTED:
+			logger.LogEvent(zapcore.WarnLevel,
+				fmt.Sprintf("destination directory is %s: %s", event, monitor.Directory()), data, nil)
+			if exportsPaused.CompareAndSwap(true, false) {
+				healthServer.SetReady(component)
+				logger.LogEvent(zapcore.InfoLevel, "resuming exports", data, nil)
+				// export PCAP files that were deferred while the destination directory was unavailable
+				select {
+				case flushChan <- syscall.SIGUSR1:
+				default:
+				}
+			}
+		}
+
+		return err
+	}
+}
+
+// newResolveIfacesTask re-evaluates the interfaces specification, and publishes the resolved interfaces at `/healthz`;
+// if interfaces cannot be discovered, the literal specification is used instead.
+func newResolveIfacesTask(
+	resolver *iface.Resolver,
+) scheduler.TaskFunc {
+	return func(_ context.Context) error {
+		added, removed, err := resolver.Refresh()
+		ifaces := resolver.Interfaces()
+		healthServer.SetInfo("interfaces", ifaces)
+
+		data := &telemetry.Ifaces{Interfaces: ifaces, Added: added, Removed: removed, Literal: telemetry.Ptr(resolver.IsLiteral())}
+		if err != nil {
+			logger.LogEvent(zapcore.WarnLevel,
+				fmt.Sprintf("failed to discover network interfaces; using: %v", resolver.Names()), data, err)
+		} else if len(added) > 0 || len(removed) > 0 {
+			logger.LogEvent(zapcore.InfoLevel,
+				fmt.Sprintf("resolved network interfaces: %v", resolver.Names()), data, nil)
+		}
+		return nil
+	}
+}
+
+// newReportBackfillTask adapts the backfill rate to the durability SLO, and reports the progress of draining the backlog.
+func newReportBackfillTask() scheduler.TaskFunc {
+	return func(_ context.Context) error {
+		changed := backfills.Adjust()
+		progress := backfills.Progress()
+		healthServer.SetInfo("backfill", progress)
+		if progress.RemainingFiles == 0 && !changed {
+			return nil
+		}
+		logger.LogEvent(zapcore.InfoLevel,
+			fmt.Sprintf("backfill: %d PCAP files (%d bytes) remaining; ETA: %s", progress.RemainingFiles, progress.RemainingBytes, progress.ETA),
+			&telemetry.Backfill{Progress: progress, Limits: backfills.Limits().String()}, nil)
+		return nil
+	}
+}
+
+// newWatchPressureTask throttles exports while the main application is under CPU, memory or IO pressure;
+// in-flight exports are not affected by transitions.
+func newWatchPressureTask(
+	monitor *pressure.Monitor,
+) scheduler.TaskFunc {
+	const component = "pressure"
+
+	return func(_ context.Context) error {
+		changed, err := monitor.Check()
+		readings := monitor.Readings()
+		healthServer.SetInfo(component, map[string]interface{}{"throttled": monitor.Throttled(), "readings": readings})
+		if !changed {
+			return err
+		}
+
+		data := &telemetry.Pressure{Readings: readings, Threshold: telemetry.Ptr(monitor.Threshold()), Throttled: telemetry.Ptr(monitor.Throttled())}
+		if monitor.Throttled() {
+			healthServer.SetDegraded(component, "exports are throttled")
+			logger.LogEvent(zapcore.WarnLevel,
+				fmt.Sprintf("throttling exports: pressure reached %.2f", monitor.Threshold()), data, err)
+		} else {
+			healthServer.ClearDegraded(component)
+			logger.LogEvent(zapcore.InfoLevel,
+				fmt.Sprintf("no longer throttling exports: pressure below %.2f", monitor.Threshold()/2), data, err)
+		}
+		return err
+	}
+}
+
+// newCaptureWindowTask relays capture window transitions to the FS events loop, which owns PCAP files bookkeeping.
+func newCaptureWindowTask(
+	windowEvents chan<- window.Event,
+) scheduler.TaskFunc {
+	const component = "window"
+
+	return func(ctx context.Context) error {
+		event := captureWindow.Check(time.Now())
+		healthServer.SetInfo(component, captureWindow.Status())
+		if event == window.EVENT_NONE {
+			return nil
+		}
+		select {
+		case windowEvents <- event:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+}
+
+// onCaptureWindowEvent resumes exports when a capture window starts, and pauses them when it stops;
+// when a capture window stops, all non-current PCAP files are exported: the current ones are rotated by the next window.
+// It must be called from the FS events loop so that no rotation is processed while pending files are collected.
+func onCaptureWindowEvent(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	event window.Event,
+	compress bool,
+) {
+	status := captureWindow.Status()
+	// the transition is not logged as `event`: it would replace the event type
+	data := &telemetry.Window{WindowEvent: string(event), Window: status, Duration: captureWindow.Duration().String()}
+
+	switch event {
+	case window.EVENT_START:
+		exportsPausedByWindow.Store(false)
+		logger.LogEvent(zapcore.InfoLevel,
+			fmt.Sprintf("capture window started; closes at %s", status.End.Format(time.RFC3339)), data, nil)
+		// export PCAP files that were deferred while outside of capture windows
+		flushPendingPcapFiles(ctx, wg, pcapDotExt, compress)
+
+	case window.EVENT_STOP:
+		pending := pendingPcapFiles(pcapDotExt)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			flushed := exportPendingPcapFiles(ctx, pending, compress)
+			exportsPausedByWindow.Store(true)
+			data.Files, data.Flushed = telemetry.Ptr(len(pending)), telemetry.Ptr(flushed)
+			logger.LogEvent(zapcore.InfoLevel,
+				fmt.Sprintf("capture window stopped: flushed %d/%d PCAP files; next at %s", flushed, len(pending), status.Next.Format(time.RFC3339)),
+				data, nil)
+		}()
+	}
+}
+
+// newOperatorToken reads the bearer token shared by operator commands and the retrieval of exported PCAP files;
+// it is `nil` when operator endpoints are disabled.
+func newOperatorToken() *auth.Token {
+	if *files_token == "" {
+		return nil
+	}
+	token, err := auth.ReadToken(*files_token)
+	if err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("operator endpoints are disabled: %v", err), &telemetry.FsnIni{}, err)
+		return nil
+	}
+	return token
+}
+
+// registerPauseCommands allows operators to pause exports for maintenance;
+// while paused, new PCAP files are tracked but remain at `src_dir` until exports are resumed.
+func registerPauseCommands(
+	flushChan chan<- os.Signal,
+	token *auth.Token,
+) {
+	const component = "operator"
+
+	healthServer.HandleCommand("pause", token, func() error {
+		if exportsPausedByOperator.CompareAndSwap(false, true) {
+			healthServer.SetDegraded(component, "exports are paused")
+			logger.LogEvent(zapcore.WarnLevel, "exports paused by an operator", &telemetry.Signal{Command: "pause"}, nil)
+		}
+		return nil
+	})
+
+	healthServer.HandleCommand("resume", token, func() error {
+		if exportsPausedByOperator.CompareAndSwap(true, false) {
+			healthServer.ClearDegraded(component)
+			logger.LogEvent(zapcore.InfoLevel, "exports resumed by an operator", &telemetry.Signal{Command: "resume"}, nil)
+			// export PCAP files that were deferred while paused
+			select {
+			case flushChan <- syscall.SIGUSR1:
+			default:
+			}
+		}
+		return nil
+	})
+}
+
+// registerSampleCommand allows operators to force this session into the sampled set for targeted debugging;
+// it is rejected once PCAP files of this session have been exported.
+func registerSampleCommand(
+	token *auth.Token,
+) {
+	healthServer.HandleCommand("sample", token, func() error {
+		decision, err := sessionSampler.Force()
+		if err != nil {
+			return err
+		}
+		healthServer.SetInfo("sampling", decision)
+		logger.LogEvent(zapcore.InfoLevel, "session sampled by an operator",
+			&telemetry.Sample{Command: "sample", Sampling: decision}, nil)
+		return nil
+	})
+}
+
+// registerFilesEndpoint allows incident responders to retrieve PCAP files exported by the current session
+// when the bucket is not reachable; every request is audited.
+func registerFilesEndpoint(
+	token *auth.Token,
+) {
+	if !*gcs_export || !*gcs_fuse {
+		logger.LogEvent(zapcore.WarnLevel, "retrieval of exported PCAP files is disabled: requires exporting using GCS Fuse", &telemetry.Fetch{}, nil)
+		return
+	}
+
+	var err error
+	fileServer, err = files.NewServer(&files.Options{
+		Directory: *gcs_dir,
+		Token:     token,
+		MaxBytes:  *files_max,
+		PerMinute: *files_rate,
+		Audit:     auditFetch,
+	})
+	if err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("retrieval of exported PCAP files is disabled: %v", err), &telemetry.Fetch{}, err)
+		return
+	}
+
+	healthServer.Handle(files.PATH_FILES, fileServer)
+	healthServer.Handle(files.PATH_FILES+"/", fileServer)
+	logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("serving exported PCAP files of session: %s", fileServer.Session()),
+		&telemetry.Fetch{Session: fileServer.Session(), MaxBytes: telemetry.Ptr(*files_max), PerMinute: telemetry.Ptr(*files_rate)}, nil)
+}
+
+func auditFetch(
+	fetch *files.Fetch,
+) {
+	level := zapcore.InfoLevel
+	if fetch.Status >= http.StatusBadRequest {
+		level = zapcore.WarnLevel
+	}
+	target := fetch.Name
+	if target == "" {
+		target = "listing"
+	}
+	logger.LogEvent(level, fmt.Sprintf("FETCHED: %s [%d]", target, fetch.Status),
+		&telemetry.Fetch{
+			Who:       fetch.Who,
+			UserAgent: fetch.UserAgent,
+			Session:   fetch.Session,
+			Name:      fetch.Name,
+			Range:     fetch.Range,
+			Status:    telemetry.Ptr(fetch.Status),
+			Bytes:     telemetry.Ptr(fetch.Bytes),
+			Timestamp: fetch.Time.Format(time.RFC3339Nano),
+			Latency:   fetch.Latency.String(),
+		}, nil)
+}
+
+// deferrable skips executions of `run` while exports are throttled.
+func deferrable(
+	run scheduler.TaskFunc,
+) scheduler.TaskFunc {
+	return func(ctx context.Context) error {
+		if pressureMonitor != nil && pressureMonitor.Throttled() {
+			return nil
+		}
+		return run(ctx)
+	}
+}
+
+func getCurrentMemoryUtilization(isGAE bool) (uint64, error) {
+	var err error
+	var memoryUtilizationFilePath string
+
+	if isGAE {
+		memoryUtilizationFilePath = dockerCgroupMemoryUtilization
+	} else {
+		memoryUtilizationFilePath = cgroupMemoryUtilization
+	}
+
+	memoryUtilizationFile, err := os.OpenFile(memoryUtilizationFilePath, os.O_RDONLY, 0o444 /* -r--r--r-- */)
+	if err != nil {
+		return 0, err
+	}
+
+	var memoryUtilization int
+	_, err = fmt.Fscanf(memoryUtilizationFile, "%d\n", &memoryUtilization)
+	if err != nil {
+		if err == io.EOF {
+			return uint64(memoryUtilization), nil
+		}
+		return 0, err
+	}
+	return uint64(memoryUtilization), nil
+}
+
+func flushBuffers() (int, error) {
+	cmd := exec.Command("sync")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Run()
+	// see: https://www.kernel.org/doc/Documentation/sysctl/vm.txt
+	fd, err := os.OpenFile(procSysVmDropCaches,
+		os.O_WRONLY|os.O_TRUNC|os.O_EXCL, 0o200 /* --w------- */)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+	return fmt.Fprintln(fd, "3")
+}
+
+func flushPcapFile(
+	ctx context.Context,
+	pcapFile *naming.PcapFile,
+	compress, delete bool,
+) bool {
+	srcFile := &pcapFile.Path
+	key, ext, iface := pcapFile.Key(), pcapFile.Ext, pcapFile.IfaceID()
+
+	if !*gcs_export {
+		// capture only: PCAP files are kept at `src_dir` so that they can be exported later
+		return false
+	}
+
+	if *export_first && isFirstExported(pcapFile.Key(), *srcFile) {
+		if delete {
+			os.Remove(*srcFile)
+		}
+		logger.LogFsEvent(zapcore.InfoLevel,
+			fmt.Sprintf("skipped PCAP file: already exported before being rotated: (%s/%s) %s", ext, iface, *srcFile), PCAP_EXPORT, *srcFile, "" /* target PCAP file */, 0, nil)
+		untrackPcapFile(*srcFile)
+		return false
+	}
+
+	if deferYoungPcapFile(pcapFile, compress, delete) {
+		return false
+	}
+
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("flushing PCAP file: [%s] (%s/%s) %s", key, ext, iface, *srcFile), PCAP_EXPORT, *srcFile, "" /* target PCAP file */, 0, nil)
+	modified := modTimeOf(*srcFile)
+	tgtPcapFileName, pcapBytes, moveErr := movePcapToGcs(ctx, srcFile, compress, delete)
+	if isDeferredExport(moveErr) {
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("deferred PCAP file flush: (%s/%s) %s", ext, iface, *srcFile), PCAP_FSNERR, *srcFile, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
+		abandonPcapFile(*srcFile, moveErr)
+		return false
+	} else if errors.Is(moveErr, errSessionNotSampled) {
+		logger.LogFsEvent(zapcore.InfoLevel,
+			fmt.Sprintf("dropped PCAP file: (%s/%s) %s", ext, iface, *srcFile), PCAP_SAMPLE, *srcFile, "" /* target PCAP file */, 0, nil)
+		completeCheckpoint(ctx, *srcFile)
+		untrackPcapFile(*srcFile)
+		return false
+	} else if moveErr != nil {
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("failed to flush PCAP file: (%s/%s) %s", ext, iface, *srcFile), PCAP_FSNERR, *srcFile, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
+		abandonPcapFile(*srcFile, moveErr)
+		return false
+	}
+	logger.LogFsEventWith(zapcore.InfoLevel,
+		fmt.Sprintf("flushed PCAP file: (%s/%s) %s", ext, iface, *tgtPcapFileName),
+		&telemetry.Export{ExportLatency: recordExportLatency(pcapFile, modified)}, *srcFile, *tgtPcapFileName, *pcapBytes, nil)
+	completeCheckpoint(ctx, *srcFile)
+	recordDurability(pcapFile)
+	exportConfigSnapshot(ctx)
+	untrackPcapFile(*srcFile)
+	return true
+}
+
+// abandonPcapFile records a PCAP file which failed to be flushed after the session stopped: it is never exported.
+func abandonPcapFile(
+	srcFile string,
+	err error,
+) {
+	if session.Stopped() {
+		abandonedPcapFiles.Set(srcFile, err.Error())
+	}
+}
+
+// newShutdownReport describes the outcome of the session started at `started` and stopped at `stopped`.
+func newShutdownReport(
+	cause error,
+	started, stopped time.Time,
+	shutdown []lifecycle.StepResult,
+) *lifecycle.Report {
+	report := &lifecycle.Report{
+		Started:   started,
+		Stopped:   stopped,
+		Exported:  exportedFilesTotal.Load(),
+		Bytes:     exportedBytesTotal.Load(),
+		Failures:  failedExportsTotal.Load(),
+		Abandoned: []lifecycle.AbandonedFile{},
+		Keys:      make(map[string]uint64),
+		Latency:   time.Since(stopped).String(),
+		Shutdown:  shutdown,
+	}
+	if cause != nil {
+		report.Cause = cause.Error()
+	}
+	abandonedPcapFiles.ForEach(func(path, reason string) bool {
+		report.Abandoned = append(report.Abandoned, lifecycle.AbandonedFile{Path: path, Reason: reason})
+		return true
+	})
+	slices.SortFunc(report.Abandoned, func(a, b lifecycle.AbandonedFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	counters.ForEach(func(key string, counter *atomic.Uint64) bool {
+		report.Keys[key] = counter.Load()
+		return true
+	})
+	return report
+}
+
+// verifyWriteAccess creates and removes a probe file in `dir`
+func verifyWriteAccess(
+	dir string,
+) error {
+	probe, err := os.CreateTemp(dir, selfTestFilePattern)
+	if err != nil {
+		return err
+	}
+	_, writeErr := probe.WriteString("pcap-sidecar")
+	closeErr := probe.Close()
+	removeErr := os.Remove(probe.Name())
+	return errors.Join(writeErr, closeErr, removeErr)
+}
+
+// waitForDestination polls `gcs_dir` until it exists and is writable, or `timeout` elapses.
+func waitForDestination(
+	timeout time.Duration,
+) error {
+	const pollInterval = 100 * time.Millisecond
+	const logInterval = 5 * time.Second
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	lastLog := time.Time{}
+
+	for {
+		err := verifyWriteAccess(*gcs_dir)
+		if err == nil {
+			logger.LogEvent(zapcore.InfoLevel,
+				fmt.Sprintf("destination directory is available: %s", *gcs_dir),
+				&telemetry.FsnIni{Directory: *gcs_dir, Latency: time.Since(start).String()}, nil)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("destination directory '%s' not available after %v: %w", *gcs_dir, timeout, err)
+		}
+
+		if time.Since(lastLog) >= logInterval {
+			lastLog = time.Now()
+			logger.LogEvent(zapcore.WarnLevel,
+				fmt.Sprintf("waiting for destination directory to be available: %s", *gcs_dir),
+				&telemetry.FsnIni{Directory: *gcs_dir, Elapsed: time.Since(start).String(), Timeout: timeout.String()}, err)
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+func runSelfTest() error {
+	dirs := []string{*src_dir}
+	// the destination directory is only available locally when exporting using GCS Fuse
+	if *gcs_export && *gcs_fuse {
+		dirs = append(dirs, *gcs_dir)
+	}
+	var errs []error
+	for _, dir := range dirs {
+		if err := verifyWriteAccess(dir); err != nil {
+			errs = append(errs, fmt.Errorf("directory '%s' is not writable: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newCanaryTask characterizes the destination using a canary object; only failures to write and read it back
+// degrade health, bucket policies which reject overwrites or deletes are recorded but not required.
+func newCanaryTask() scheduler.TaskFunc {
+	const component = "canary"
+
+	return func(ctx context.Context) error {
+		result := canaryProber.Probe(ctx)
+		healthServer.SetInfo("canary", result)
+
+		data := &telemetry.Canary{Canary: result}
+		if !result.Healthy() {
+			healthServer.SetDegraded(component, errCanaryFailed.Error())
+			logger.LogEvent(zapcore.ErrorLevel, "destination characterization failed", data, errCanaryFailed)
+			return errCanaryFailed
+		}
+		healthServer.ClearDegraded(component)
+
+		level := zapcore.InfoLevel
+		if len(result.Errors) > 0 {
+			level = zapcore.WarnLevel
+		}
+		logger.LogEvent(level,
+			fmt.Sprintf("destination characterized: survives_delay=%s | overwritable=%s | deletable=%s | encryption_verified=%s",
+				result.SurvivesDelay, result.Overwritable, result.Deletable, result.EncryptionVerified),
+			data, nil)
+		return nil
+	}
+}
+
+// recordDurability tracks the time from the rotation that created an exported PCAP file to now.
+func recordDurability(pcapFile *naming.PcapFile) {
+	if rotationTS, err := pcapFile.TimeIn(captureLocation); err == nil {
+		durabilitySLO.Record(pcapFile.IfaceID(), time.Since(rotationTS))
+	}
+}
+
+// countExportForFlush flushes OS file write buffers after every `flush_every_n` exported PCAP files,
+// so that flushing tracks the actual write volume; the flush is skipped if one is already running.
+func countExportForFlush() {
+	if *flush_every_n == 0 || tasks == nil {
+		return
+	}
+	if exportsSinceStart.Add(1)%uint64(*flush_every_n) == 0 {
+		tasks.Trigger(flushOSBuffersTask, flushTriggerExports)
+	}
+}
+
+// exportLostSessionManifest exports a manifest of the captured PCAP files when the session exported no data;
+// it returns `nil` if the session exported data, or the manifest along with the error if it could not be exported.
+func exportLostSessionManifest(
+	pcapDotExt *naming.Matcher,
+	started time.Time,
+	cause error,
+) (*shortlived.Manifest, error) {
+	if exportedBytesTotal.Load() > 0 {
+		return nil, nil
+	}
+
+	captured, capturedBytes := shortlived.Captured(*src_dir, pcapDotExt.MatchString)
+	manifest := &shortlived.Manifest{
+		Session:       instanceID,
+		Started:       started,
+		Stopped:       time.Now(),
+		Captured:      captured,
+		CapturedBytes: capturedBytes,
+	}
+	if cause != nil {
+		manifest.Cause = cause.Error()
+	}
+
+	dir, err := os.MkdirTemp("", "pcapfsn-lost-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+	defer cancel()
+	err = manifest.Export(ctx, dir, func(ctx context.Context, path string) error {
+		_, _, err := exporter.Export(ctx, &path, false /* compress */, true /* delete */)
+		return err
+	})
+	if err == nil {
+		logger.LogEvent(zapcore.WarnLevel, "exported lost session manifest: no PCAP file was exported",
+			&telemetry.Export{Manifest: manifest}, nil)
+	}
+	return manifest, err
+}
+
+// modTimeOf returns the time of the last write of a PCAP file before it is exported; it is zero if unknown.
+func modTimeOf(path string) time.Time {
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// recordExportLatency tracks the time from the creation of an exported PCAP file to now,
+// and returns it as additional data of its export log event; `nil` when export latencies are not tracked.
+func recordExportLatency(
+	pcapFile *naming.PcapFile,
+	modified time.Time,
+) any {
+	if exportLatencies == nil {
+		return nil
+	}
+	// unparsable timestamps fall back to the time of the last write
+	named, _ := pcapFile.TimeIn(captureLocation)
+	latency, ok := slo.NewExportLatency(named, modified, time.Now())
+	if !ok {
+		return nil
+	}
+	exportLatencies.Record(pcapFile.IfaceID(), latency.Duration())
+	healthServer.SetInfo("export_latency", exportLatencies.Summary())
+	return latency
+}
+
+// newReportEventsTask logs how full the buffer of events of the source directory got since the previous report;
+// a buffer which stays near full delays the detection of PCAP files, and so their export.
+func newReportEventsTask() scheduler.TaskFunc {
+	const component = "events"
+
+	return func(_ context.Context) error {
+		stats := eventsQueue.Report()
+		healthServer.SetInfo(component, stats)
+
+		level := zapcore.DebugLevel
+		if stats.Sustained {
+			level = zapcore.WarnLevel
+		}
+		logger.LogEvent(level,
+			fmt.Sprintf("events buffer: max=%d/%d | mean=%.1f | slowest=%s", stats.Max, stats.Capacity, stats.Mean, stats.Slowest),
+			&telemetry.Events{Queue: stats, Interval: queue_report.String()}, nil)
+		return nil
+	}
+}
+
+// newReportSLOTask logs durability latency percentiles, and flags the exporter as degraded when the SLO is breached.
+func newReportSLOTask() scheduler.TaskFunc {
+	const component = "durability_slo"
+
+	return func(_ context.Context) error {
+		summary := durabilitySLO.Summary()
+		if summary.Overall.Count == 0 {
+			return nil
+		}
+
+		if summary.Degraded {
+			healthServer.SetDegraded(component, fmt.Sprintf("%.1f%% of recent exports exceeded %s", summary.BreachRatio*100, summary.Target))
+		} else {
+			healthServer.ClearDegraded(component)
+		}
+
+		level := zapcore.InfoLevel
+		if summary.Degraded {
+			level = zapcore.WarnLevel
+		}
+		logger.LogEvent(level,
+			fmt.Sprintf("durability latency: p50=%s | p95=%s | p99=%s", summary.Overall.P50, summary.Overall.P95, summary.Overall.P99),
+			&telemetry.SLO{SLO: summary}, nil)
+		return nil
+	}
+}
+
+// trackPcapFile assigns a new event ID to the PCAP file at `path`, whose creation was accepted; it returns the ID.
+func trackPcapFile(
+	path string,
+) string {
+	id := fmt.Sprintf("%08x", rand.Uint32())
+	eventIDs.Set(path, id)
+	return id
+}
+
+// eventIDOf returns the event ID of the PCAP file at `path`; it returns "" if its creation was not accepted.
+func eventIDOf(
+	path string,
+) string {
+	id, _ := eventIDs.Get(path)
+	return id
+}
+
+// untrackPcapFile stops correlating log events about the PCAP file at `path`: it is done with.
+func untrackPcapFile(
+	path string,
+) {
+	eventIDs.Delete(path)
+}
+
+// exportPreSessionPcapFile exports a PCAP file immediately without counting it as a rotation.
+func exportPreSessionPcapFile(
+	ctx context.Context,
+	pcapDotExt *naming.Matcher,
+	srcFile *string,
+	compress bool,
+) bool {
+	pcapFile, err := pcapDotExt.Parse(*srcFile)
+	if err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("skipping pre-session PCAP file: %v", err), PCAP_FSNERR, *srcFile, "" /* target PCAP file */, 0, err)
+		return false
+	}
+	return flushPcapFile(ctx, pcapFile, compress, true /* delete */)
+}
+
+// exportDetectedPcapFile exports the PCAP file rotated by `srcFile` being created, or `srcFile` itself
+// when it is complete as soon as it is detected, according to `export_on`.
+func exportDetectedPcapFile(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	srcFile *string,
+) bool {
+	trackPcapFile(*srcFile)
+	if exportTrigger == watch.TRIGGER_CREATE {
+		return exportPcapFile(ctx, wg, pcapDotExt, srcFile, *gzip_pcaps /* compress */, true /* delete */, false /* flush */)
+	}
+	return exportCompletePcapFile(ctx, wg, pcapDotExt, srcFile, *gzip_pcaps /* compress */)
+}
+
+// exportCompletePcapFile exports `srcFile` immediately: it is no longer written, so there is no rotation to wait for.
+func exportCompletePcapFile(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	srcFile *string,
+	compress bool,
+) bool {
+	defer wg.Done()
+
+	pcapFile, err := pcapDotExt.Parse(*srcFile)
+	if err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("skipping PCAP file: %v", err), PCAP_FSNERR, *srcFile, "" /* target PCAP file */, 0, err)
+		return false
+	}
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("complete PCAP file detected: [%s] (%s/%s) %s", pcapFile.Key(), pcapFile.Ext, pcapFile.IfaceID(), *srcFile),
+		PCAP_CREATE, *srcFile, "" /* target PCAP file */, 0, nil)
+	return flushPcapFile(ctx, pcapFile, compress, true /* delete */)
+}
+
+// observeWrite tracks PCAP files which are still being written when they are exported once no longer written.
+func observeWrite(
+	quiescence *watch.Quiescence,
+	event fsnotify.Event,
+	at time.Time,
+) {
+	switch {
+	case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+		quiescence.Touch(event.Name, at)
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		quiescence.Forget(event.Name)
+	}
+}
+
+// exportQuiescentPcapFiles exports all PCAP files which have not been written for `export_quiet` at `now`,
+// unless they are held until `tcpdumpw` is ready; it returns how many were exported.
+func exportQuiescentPcapFiles(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	quiescence *watch.Quiescence,
+	startGate *gate.StartGate,
+	now time.Time,
+) int {
+	exported := 0
+	for _, pcapFile := range quiescence.Due(now) {
+		if !startGate.Admit(pcapFile) {
+			continue
+		}
+		trackPcapFile(pcapFile)
+		wg.Add(1)
+		if exportCompletePcapFile(ctx, wg, pcapDotExt, &pcapFile, *gzip_pcaps /* compress */) {
+			exported += 1
+		}
+	}
+	return exported
+}
+
+// traceEvent logs `event` as a `PCAP_FSTRACE` event when `trace_events` is enabled, whatever its op is,
+// so that missing exports can be told apart from events which never reached the watcher or were ignored.
+func traceEvent(
+	event fsnotify.Event,
+	pcapDotExt *naming.Matcher,
+) *telemetry.FsTrace {
+	if !*trace_events {
+		return nil
+	}
+	trace := &telemetry.FsTrace{
+		Op:      event.Op.String(),
+		Matched: telemetry.Ptr(pcapDotExt.MatchString(event.Name)),
+	}
+	logger.LogFsEventWith(zapcore.DebugLevel,
+		fmt.Sprintf("fs event: %s %s", trace.Op, event.Name),
+		trace, event.Name, "", 0, nil)
+	return trace
+}
+
+func exportPcapFile(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	srcFile *string,
+	compress, delete, flush bool,
+) bool {
+	defer wg.Done()
+
+	if flush && !session.Stopped() {
+		return false
+	}
+
+	pcapFile, err := pcapDotExt.Parse(*srcFile)
+	if errors.Is(err, naming.ErrUnsafeName) {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("skipping PCAP file: %v", err), PCAP_FSNERR, *srcFile, "" /* target PCAP file */, 0, err)
+		return false
+	} else if err != nil {
+		return false
+	}
+
+	iface := pcapFile.IfaceID()
+	ext := pcapFile.Ext
+	key := pcapFile.Key()
+
+	lastPcapFileName, loaded := lastPcap.Get(key)
+
+	// `flushing` is the only thread-safe PCAP export operation.
+	if flush {
+		return flushPcapFile(ctx, pcapFile, compress, delete)
+	}
+
+	// the same PCAP file detected again, i.e.: its creation reported twice, is the one being written: it is not a rotation
+	if loaded && lastPcapFileName == *srcFile {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("ignored duplicate PCAP file: [%s] (%s/%s) %s", key, ext, iface, *srcFile), PCAP_CREATE, *srcFile, "" /* target PCAP file */, 0, nil)
+		return false
+	}
+
+	// the counter only labels and counts PCAP files: rotations are decided by the last PCAP file, and ordered by file names
+	counter, _ := counters.GetOrCompute(key,
+		func() *atomic.Uint64 {
+			return new(atomic.Uint64)
+		})
+	iteration := (*counter).Add(1)
+	if loaded {
+		rotationObserved.Store(true)
+	}
+
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("new PCAP file detected: [%s] (%s/%s/%d) %s", key, ext, iface, iteration, *srcFile), PCAP_CREATE, *srcFile, "" /* target PCAP file */, 0, nil)
+
+	// PCAP files are named after their rotation timestamp: a jump larger than the interval means lost rotations
+	if rotationTS, tsErr := pcapFile.Time(); tsErr == nil {
+		if gap, ok := gaps.Observe(key, rotationTS); ok {
+			logger.LogEvent(zapcore.ErrorLevel,
+				fmt.Sprintf("rotation gap: [%s] (%s/%s/%d) %d PCAP files missing before %s", key, ext, iface, iteration, gap.Missing, *srcFile),
+
+				&telemetry.FsnErr{
+					Key:      key,
+					Interval: gaps.Interval().String(),
+					Previous: gap.Previous.Format(naming.TimestampLayout),
+					Current:  gap.Current.Format(naming.TimestampLayout),
+					Missing:  telemetry.Ptr(gap.Missing),
+					Gaps:     telemetry.Ptr(gap.Total),
+				}, nil)
+		}
+	}
+
+	// PCAP files are ordered by their rotation timestamp, not by the counter
+	if lastPcapFile, err := pcapDotExt.Parse(lastPcapFileName); err == nil && naming.CompareRotation(pcapFile, lastPcapFile) < 0 {
+		// the rotation timestamp went back, i.e.: the clock of the capture was adjusted; 'tcpdump' only creates
+		// a PCAP file once it is done with the previous one, so the last PCAP file is still exported and replaced
+		logger.LogEvent(zapcore.WarnLevel,
+			fmt.Sprintf("rotation ordinal reset: [%s] (%s/%s/%d) %s is older than %s", key, ext, iface, iteration, *srcFile, lastPcapFileName),
+
+			&telemetry.FsnErr{
+				Key:      key,
+				Previous: lastPcapFile.Timestamp,
+				Current:  pcapFile.Timestamp,
+			}, nil)
+		// gaps are measured from the new rotation timestamps
+		if rotationTS, tsErr := pcapFile.Time(); tsErr == nil {
+			gaps.Rebase(key, rotationTS)
+		}
+	}
+
+	if !loaded && *export_first && *gcs_export {
+		// short-lived captures may never rotate their only PCAP file
+		wg.Add(1)
+		go exportFirstPcapFile(ctx, wg, pcapFile, compress)
+	}
+
+	// Skip 1st PCAP, start moving PCAPs as soon as TCPDUMP rolls over into the 2nd file.
+	// The outcome of this implementation is that the directory in which TCPDUMP writes
+	// PCAP files will contain at most 2 files, the current one, and the one being moved
+	// into the destination directory ( `gcs_dir` ). Otherwise it will contain all PCAPs.
+	if !loaded {
+		lastPcap.Set(key, *srcFile)
+		return false
+	}
+
+	if lastPcapFileName == "" {
+		lastPcap.Set(key, *srcFile)
+		logger.LogFsEvent(zapcore.ErrorLevel, fmt.Sprintf("PCAP file [%s] (%s/%s/%d) unavailable", key, ext, iface, iteration), PCAP_EXPORT, "" /* source PCAP File */, *srcFile /* target PCAP file */, 0, nil)
+		return false
+	}
+
+	if !*gcs_export {
+		// capture only: PCAP files are kept at `src_dir` so that they can be exported later
+		lastPcap.Set(key, *srcFile)
+		logger.LogFsEvent(zapcore.InfoLevel,
+			fmt.Sprintf("kept PCAP file: (%s/%s/%d) %s", ext, iface, iteration, lastPcapFileName), PCAP_QUEUED, lastPcapFileName, "" /* target PCAP file */, 0, nil)
+		untrackPcapFile(lastPcapFileName)
+		return false
+	}
+
+	if *export_first && isFirstExported(key, lastPcapFileName) {
+		if delete {
+			os.Remove(lastPcapFileName)
+		}
+		lastPcap.Set(key, *srcFile)
+		logger.LogFsEvent(zapcore.InfoLevel,
+			fmt.Sprintf("skipped PCAP file: already exported before being rotated: (%s/%s/%d) %s", ext, iface, iteration, lastPcapFileName), PCAP_EXPORT, lastPcapFileName, "" /* target PCAP file */, 0, nil)
+		untrackPcapFile(lastPcapFileName)
+		return false
+	}
+
+	// `lastPcapFile` is `nil` if its name cannot be parsed
+	lastPcapFile, parseErr := pcapDotExt.Parse(lastPcapFileName)
+	if parseErr == nil && deferYoungPcapFile(lastPcapFile, compress, delete) {
+		lastPcap.Set(key, *srcFile)
+		return false
+	}
+
+	moveErr := exportRotatedPcapFile(ctx, lastPcapFileName, lastPcapFile,
+		fmt.Sprintf("%s/%s/%d", ext, iface, iteration), compress, delete)
+
+	// current PCAP file is the next one to be moved
+	if !lastPcap.CompareAndSwap(key, lastPcapFileName, *srcFile) {
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("leaked PCAP file: [%s] (%s/%s/%d) %s", key, ext, iface, iteration, *srcFile), PCAP_FSNERR, *srcFile, "" /* target PCAP file */, 0, nil)
+		lastPcap.Set(key, *srcFile)
+	}
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("queued PCAP file: (%s/%s/%d) %s", ext, iface, iteration, *srcFile), PCAP_QUEUED, *srcFile, "" /* target PCAP file */, 0, nil)
+
+	return moveErr == nil
+}
+
+// exportRotatedPcapFile exports a PCAP file which `tcpdump` is done with, and logs the outcome labeled with `label`;
+// `pcapFile` is `nil` when its name cannot be parsed, in which case its latency and durability are not recorded.
+func exportRotatedPcapFile(
+	ctx context.Context,
+	srcFile string,
+	pcapFile *naming.PcapFile,
+	label string,
+	compress, delete bool,
+) error {
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("exporting PCAP file: (%s) %s", label, srcFile), PCAP_EXPORT, srcFile, "" /* target PCAP file */, 0, nil)
+	// move non-current PCAP file into `gcs_dir` which means that:
+	// 1. the GCS Bucket should have already been mounted
+	// 2. the directory hierarchy to store PCAP files already exists
+	mirrorPcapFile(srcFile)
+
+	// backfill exports yield to live exports
+	backfills.LiveStarted()
+	exportCtx, cancelExport := newExportContext(ctx)
+	modified := modTimeOf(srcFile)
+	tgtPcapFileName, pcapBytes, moveErr := movePcapToGcs(exportCtx, &srcFile, compress, delete)
+	cancelExport()
+	backfills.LiveDone()
+	if errors.Is(moveErr, errPcapFileHeldOpen) {
+		logger.LogFsEvent(zapcore.InfoLevel,
+			fmt.Sprintf("deferred PCAP file export for %v: held open by 'tcpdump': (%s) %s", heldOpenRetryDelay, label, srcFile), PCAP_QUEUED, srcFile, "" /* target PCAP file */, 0, nil)
+		retryHeldOpenPcapFile(srcFile, pcapFile, label, compress, delete)
+	} else if isDeferredExport(moveErr) {
+		// the PCAP file remains at `src_dir`: it will be exported by the next flush
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("deferred PCAP file export: (%s) %s", label, srcFile), PCAP_FSNERR, srcFile, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
+	} else if errors.Is(moveErr, errSessionNotSampled) {
+		logger.LogFsEvent(zapcore.InfoLevel,
+			fmt.Sprintf("dropped PCAP file: (%s) %s", label, srcFile), PCAP_SAMPLE, srcFile, "" /* target PCAP file */, 0, nil)
+		completeCheckpoint(ctx, srcFile)
+		untrackPcapFile(srcFile)
+	} else if moveErr == nil {
+		var latency any
+		if pcapFile != nil {
+			latency = recordExportLatency(pcapFile, modified)
+		}
+		logger.LogFsEventWith(zapcore.InfoLevel,
+			fmt.Sprintf("exported PCAP file: (%s) %s", label, *tgtPcapFileName),
+			&telemetry.Export{ExportLatency: latency}, srcFile, *tgtPcapFileName, *pcapBytes, nil)
+		// the full export replaces the partial PCAP file
+		completeCheckpoint(ctx, srcFile)
+		if pcapFile != nil {
+			recordDurability(pcapFile)
+		}
+		exportConfigSnapshot(ctx)
+		untrackPcapFile(srcFile)
+	} else {
+		logger.LogFsEvent(zapcore.ErrorLevel,
+			fmt.Sprintf("failed to export PCAP file: (%s) %s", label, srcFile), PCAP_EXPORT, srcFile, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
+	}
+	return moveErr
+}
+
+// retryHeldOpenPcapFile exports a rotated PCAP file once 'tcpdump' closes it;
+// if the session stops first, the final flush exports it.
+func retryHeldOpenPcapFile(
+	srcFile string,
+	pcapFile *naming.PcapFile,
+	label string,
+	compress, delete bool,
+) {
+	session.Go("skip_open", func(ctx context.Context) error {
+		timer := time.NewTimer(heldOpenRetryDelay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		if _, err := os.Stat(srcFile); err != nil {
+			// already exported by a flush
+			return nil
+		}
+		exportRotatedPcapFile(ctx, srcFile, pcapFile, label, compress, delete)
+		return nil
+	})
+}
+
+// exportDelay returns how long the export of a PCAP file last modified at `modified` must wait
+// for it to be older than `minAge`; `0` means that it can be exported now.
+func exportDelay(
+	modified, now time.Time,
+	minAge time.Duration,
+) time.Duration {
+	if minAge <= 0 || modified.IsZero() {
+		return 0
+	}
+	return max(0, minAge-now.Sub(modified))
+}
+
+// deferYoungPcapFile schedules the export of a PCAP file younger than `min_age_before_export` for when it is old enough,
+// and returns `true`; if the PCAP file is modified in the meantime, it is deferred again. Once the session stops,
+// PCAP files are never deferred: `tcpdump` is done with all of them, and pending exports are left to the final flush.
+func deferYoungPcapFile(
+	pcapFile *naming.PcapFile,
+	compress, delete bool,
+) bool {
+	if *min_age == 0 || session.Stopped() {
+		return false
+	}
+	delay := exportDelay(modTimeOf(pcapFile.Path), time.Now(), *min_age)
+	if delay == 0 {
+		return false
+	}
+	if _, aging := agingPcapFiles.LoadOrStore(pcapFile.Path, struct{}{}); aging {
+		// the PCAP file is already scheduled
+		return true
+	}
+
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("deferred PCAP file export for %v: younger than %v: %s", delay.Round(time.Millisecond), *min_age, pcapFile.Path),
+		PCAP_QUEUED, pcapFile.Path, "" /* target PCAP file */, 0, nil)
+
+	session.Go("min_age", func(ctx context.Context) error {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			agingPcapFiles.Delete(pcapFile.Path)
+			return nil
+		case <-timer.C:
+		}
+		// released before exporting so that a PCAP file modified in the meantime can be deferred again
+		agingPcapFiles.Delete(pcapFile.Path)
+		if session.Stopped() || deferYoungPcapFile(pcapFile, compress, delete) {
+			return nil
+		}
+		if _, err := os.Stat(pcapFile.Path); err != nil {
+			// already exported by a flush
+			return nil
+		}
+		exportRotatedPcapFile(ctx, pcapFile.Path, pcapFile,
+			fmt.Sprintf("%s/%s", pcapFile.Ext, pcapFile.IfaceID()), compress, delete)
+		return nil
+	})
+	return true
+}
+
+// claimFirstExport returns the locked export state of the first PCAP file of `key`,
+// or `nil` when it was already rotated or flushed.
+func claimFirstExport(
+	key, path string,
+) *firstExport {
+	firstExportsMu.Lock()
+	defer firstExportsMu.Unlock()
+	if export, ok := firstExports[key]; ok && export.path == path {
+		return nil
+	}
+	export := &firstExport{path: path, size: -1}
+	export.mu.Lock()
+	firstExports[key] = export
+	return export
+}
+
+// isFirstExported reports whether `path` was exported before being rotated, and it did not change since then;
+// it waits for an in-progress export, and prevents `path` from being exported before being rotated afterwards.
+func isFirstExported(
+	key, path string,
+) bool {
+	firstExportsMu.Lock()
+	export, ok := firstExports[key]
+	if !ok || export.path != path {
+		firstExports[key] = &firstExport{path: path, size: -1}
+		firstExportsMu.Unlock()
+		return false
+	}
+	firstExportsMu.Unlock()
+
+	export.mu.Lock()
+	defer export.mu.Unlock()
+	if export.size < 0 {
+		return false
+	}
+	// packets written after the export must not be lost: the PCAP file is exported again, replacing the previous copy
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != export.size {
+		return false
+	}
+	firstCopies.Delete(path)
+	return true
+}
+
+// exportFirstPcapFile exports the first PCAP file of its key once it did not change for `export_first_after`,
+// unless it is rotated first. It is not deleted, as `tcpdump` may still write into it.
+func exportFirstPcapFile(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapFile *naming.PcapFile,
+	compress bool,
+) {
+	defer wg.Done()
+
+	key, path := pcapFile.Key(), pcapFile.Path
+	ticker := time.NewTicker(*first_after)
+	defer ticker.Stop()
+
+	size, modTime := int64(-1), time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			// the final flush exports it
+			return
+		case <-ticker.C:
+		}
+		if current, _ := lastPcap.Get(key); current != path {
+			// rotated: it is exported as usual
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if info.Size() == size && info.ModTime().Equal(modTime) {
+			break
+		}
+		size, modTime = info.Size(), info.ModTime()
+	}
+
+	export := claimFirstExport(key, path)
+	if export == nil {
+		return
+	}
+	defer export.mu.Unlock()
+
+	tgtPcapFileName, pcapBytes, moveErr := movePcapToGcs(ctx, &path, compress, false /* delete */)
+	if moveErr != nil {
+		logger.LogFsEvent(zapcore.WarnLevel,
+			fmt.Sprintf("failed to export first PCAP file: (%s/%s) %s", pcapFile.Ext, pcapFile.IfaceID(), path), PCAP_EXPORT, path, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
+		return
+	}
+	export.size = size
+	firstCopies.Store(path, struct{}{})
+	logger.LogFsEvent(zapcore.InfoLevel,
+		fmt.Sprintf("exported first PCAP file: (%s/%s) %s", pcapFile.Ext, pcapFile.IfaceID(), *tgtPcapFileName), PCAP_EXPORT, path, *tgtPcapFileName, *pcapBytes, nil)
+}
+
+// capturePIDs returns the PIDs of the 'tcpdump' processes for every interface.
+func capturePIDs() (map[string][]int, error) {
+	if *capture_pids != "" {
+		return activeflush.ReadPIDFile(*capture_pids, procDir)
+	}
+	return activeflush.Discover(procDir, "tcpdump")
+}
+
+// isHeldOpen reports whether 'tcpdump' is still writing into `srcPcap`, regardless of its ordinal;
+// once the session is stopped all PCAP files are exported: capture processes are flushed or gone.
+func isHeldOpen(
+	srcPcap string,
+) bool {
+	if !*skip_open || session.Stopped() {
+		return false
+	}
+	pids, err := capturePIDs()
+	if err != nil {
+		logger.LogFsEvent(zapcore.WarnLevel, "failed to discover capture processes", PCAP_FSNERR, srcPcap, "" /* target PCAP file */, 0, err)
+		return false
+	}
+	if path, err := filepath.Abs(srcPcap); err == nil {
+		srcPcap = path
+	}
+	_, open := activeflush.OpenFiles(procDir, pids)[srcPcap]
+	return open
+}
+
+// activeFlush signals 'tcpdump' to flush its packet buffer, and waits for the in-progress PCAP files to stop growing;
+// signaling is skipped for interfaces whose capture process cannot be unambiguously identified.
+func activeFlush(
+	pcapDotExt *naming.Matcher,
+) []*activeflush.Result {
+	start := time.Now()
+
+	pids, err := capturePIDs()
+	if err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, "failed to discover capture processes", &telemetry.FsnEnd{}, err)
+		return nil
+	}
+
+	// the PCAP files which are next to be exported are the ones being written
+	files := make(map[string][]string)
+	lastPcap.ForEach(func(_ string, srcFile string) bool {
+		if pcapFile, err := pcapDotExt.Parse(srcFile); err == nil {
+			files[pcapFile.Iface] = append(files[pcapFile.Iface], srcFile)
+		}
+		return true
+	})
+
+	results := activeflush.NewFlusher(*flush_timeout, activeFlushSettle).
+		Flush(context.Background(), pids, files)
+
+	logger.LogEvent(zapcore.InfoLevel,
+		fmt.Sprintf("active flush completed for %d interfaces", len(results)),
+
+		&telemetry.FsnEnd{
+			Results: results,
+			Latency: time.Since(start).String(),
+		}, nil)
+	return results
+}
+
+func flushSrcDir(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	sync, compress, delete bool,
+	validator func(string, fs.FileInfo) bool,
+) uint32 {
+	pendingPcapFiles := uint32(0)
+	if sync {
+		flushBuffers()
+	}
+	if *ordered {
+		return flushSrcDirInOrder(ctx, wg, pcapDotExt, compress, delete, validator)
+	}
+	filepath.Walk(*src_dir, func(path string, info fs.FileInfo, err error) error {
+		if info.IsDir() {
+			return nil
+		}
+		if err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to flush PCAP files", &telemetry.FsnErr{}, err)
+			return nil
+		}
+		if validator(path, info) {
+			pendingPcapFiles += 1
+			wg.Add(1)
+			go exportPcapFile(ctx, wg, pcapDotExt, &path, compress, delete, true /* flush */)
+		}
+		return nil
+	})
+	return pendingPcapFiles
+}
+
+// newFinalFlushValidator returns which files the final flush exports: all of them, unless the PCAP lock file was not acquired;
+// then `lock_failure` decides, and PCAP files which are not flushed are preserved at `src_dir` and reported as abandoned.
+func newFinalFlushValidator(
+	pcapDotExt *naming.Matcher,
+	lockFailed bool,
+	mode lifecycle.LockFailure,
+) func(string, fs.FileInfo) bool {
+	if !lockFailed || mode == lifecycle.LOCK_FAILURE_FORCE {
+		return func(string, fs.FileInfo) bool { return true }
+	}
+	// `pendingPcapFiles` excludes the latest PCAP file of every key
+	pending := make(map[string]struct{})
+	if mode == lifecycle.LOCK_FAILURE_SKIP_CURRENT {
+		for _, pcapFile := range pendingPcapFiles(pcapDotExt) {
+			pending[pcapFile.Path] = struct{}{}
+		}
+	}
+	return func(path string, _ fs.FileInfo) bool {
+		if _, ok := pending[path]; ok {
+			return true
+		}
+		if pcapDotExt.MatchString(path) {
+			abandonPcapFile(path, errShutdownDeadline)
+		}
+		return false
+	}
+}
+
+// flushSrcDirInOrder exports the PCAP files of each key sequentially and in rotation order;
+// keys are still flushed concurrently.
+func flushSrcDirInOrder(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	compress, delete bool,
+	validator func(string, fs.FileInfo) bool,
+) uint32 {
+	pcapFiles := []*naming.PcapFile{}
+	filepath.Walk(*src_dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to flush PCAP files", &telemetry.FsnErr{}, err)
+			return nil
+		}
+		if info.IsDir() || !validator(path, info) {
+			return nil
+		}
+		if pcapFile, err := pcapDotExt.Parse(path); err == nil {
+			pcapFiles = append(pcapFiles, pcapFile)
+		}
+		return nil
+	})
+
+	naming.SortByRotation(pcapFiles)
+
+	pcapFilesByKey := make(map[string][]*naming.PcapFile)
+	for _, pcapFile := range pcapFiles {
+		key := pcapFile.Key()
+		pcapFilesByKey[key] = append(pcapFilesByKey[key], pcapFile)
+	}
+
+	wg.Add(len(pcapFiles))
+	for _, keyPcapFiles := range pcapFilesByKey {
+		go func(keyPcapFiles []*naming.PcapFile) {
+			for _, pcapFile := range keyPcapFiles {
+				exportPcapFile(ctx, wg, pcapDotExt, &pcapFile.Path, compress, delete, true /* flush */)
+			}
+		}(keyPcapFiles)
+	}
+	return uint32(len(pcapFiles))
+}
+
+// pendingPcapFiles returns all PCAP files which are not being written by `tcpdump`:
+//   - per key, files rotated before the last PCAP file detected; which is the one `tcpdump` is writing into.
+//   - if no PCAP file has been detected for a key yet, all files but the last one rotated.
+//
+// PCAP files are ordered by rotation timestamp, as rotations are: files rotated after the last PCAP file detected
+// are either not detected yet, or were written before the rotation timestamps went back; the rotation path exports those.
+func pendingPcapFiles(
+	pcapDotExt *naming.Matcher,
+) []*naming.PcapFile {
+	newest := make(map[string]*naming.PcapFile)
+	pcapFiles := make(map[string][]*naming.PcapFile)
+
+	filepath.Walk(*src_dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		pcapFile, err := pcapDotExt.Parse(path)
+		if err != nil {
+			return nil
+		}
+		key := pcapFile.Key()
+		pcapFiles[key] = append(pcapFiles[key], pcapFile)
+		if newest[key] == nil || naming.CompareRotation(pcapFile, newest[key]) > 0 {
+			newest[key] = pcapFile
+		}
+		return nil
+	})
+
+	pending := []*naming.PcapFile{}
+	for key, files := range pcapFiles {
+		currentPcapFile := newest[key]
+		if currentPcapFileName, loaded := lastPcap.Get(key); loaded && currentPcapFileName != "" {
+			if lastPcapFile, err := pcapDotExt.Parse(currentPcapFileName); err == nil {
+				currentPcapFile = lastPcapFile
+			}
+		}
+		for _, pcapFile := range files {
+			if pcapFile.Path != currentPcapFile.Path && naming.CompareRotation(pcapFile, currentPcapFile) < 0 {
+				pending = append(pending, pcapFile)
+			}
+		}
+	}
+	return pending
+}
+
+// flushPendingPcapFiles exports all non-current PCAP files without waiting for `tcpdump` to rotate them.
+// It must be called from the FS events loop so that no rotation is processed while pending files are collected.
+func flushPendingPcapFiles(
+	ctx context.Context,
+	wg *sync.WaitGroup,
+	pcapDotExt *naming.Matcher,
+	compress bool,
+) {
+	if !isFlushing.CompareAndSwap(false, true) {
+		logger.LogEvent(zapcore.WarnLevel, "manual flush already in progress", &telemetry.MFlush{}, nil)
+		return
+	}
+
+	pending := pendingPcapFiles(pcapDotExt)
+
+	flushStart := time.Now()
+	logger.LogEvent(zapcore.InfoLevel,
+		fmt.Sprintf("manual flush started: %d PCAP files", len(pending)),
+
+		&telemetry.MFlush{
+			Files:     telemetry.Ptr(len(pending)),
+			Timestamp: flushStart.Format(time.RFC3339Nano),
+		}, nil)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer isFlushing.Store(false)
+
+		flushed := exportPendingPcapFiles(ctx, pending, compress)
+
+		logger.LogEvent(zapcore.InfoLevel,
+			fmt.Sprintf("manual flush complete: %d/%d PCAP files", flushed, len(pending)),
+
+			&telemetry.MFlush{
+				Files:   telemetry.Ptr(len(pending)),
+				Flushed: telemetry.Ptr(flushed),
+				Latency: time.Since(flushStart).String(),
+			}, nil)
+	}()
+}
+
+// exportPendingPcapFiles exports `pending` PCAP files as backfills, and returns how many of them were exported.
+func exportPendingPcapFiles(
+	ctx context.Context,
+	pending []*naming.PcapFile,
+	compress bool,
+) uint32 {
+	// pending PCAP files are the backlog accumulated while exports were paused
+	sizes := make([]int64, len(pending))
+	var backlogBytes int64
+	for i, pcapFile := range pending {
+		if info, err := os.Stat(pcapFile.Path); err == nil {
+			sizes[i] = info.Size()
+			backlogBytes += sizes[i]
+		}
+	}
+	backfills.Add(int64(len(pending)), backlogBytes)
+
+	var flushed atomic.Uint32
+	var flushWG sync.WaitGroup
+	for i, pcapFile := range pending {
+		if err := backfills.Acquire(ctx, sizes[i]); err != nil {
+			// the final flush exports the remaining PCAP files
+			for _, size := range sizes[i:] {
+				backfills.Done(size)
+			}
+			break
+		}
+		flushWG.Add(1)
+		go func(pcapFile *naming.PcapFile, size int64) {
+			defer flushWG.Done()
+			defer backfills.Done(size)
+			if flushPcapFile(ctx, pcapFile, compress, true /* delete */) {
+				flushed.Add(1)
+			}
+		}(pcapFile, sizes[i])
+	}
+	flushWG.Wait()
+	return flushed.Load()
+}
+
+// sidecarConfig is the subset of the sidecar JSON config file used by the PCAP files exporter.
+type sidecarConfig struct {
+	extensions []string
+	ifaceSpec  string
+	gcsExport  bool
+	gcsDir     string
+	gcsTempDir string
+	// identity fields allowlists are `nil` when not set
+	logFields   log.Fields
+	auditFields log.Fields
+	// empty when scheduled captures are disabled
+	cronExpression string
+	// `0` when every capture runs until the sidecar stops
+	captureTimeout time.Duration
+	// `<iface>=<level>` gzip levels; `nil` when not set
+	compression []string
+	// `0` when not set
+	hcPort uint16
+	// `nil` when not set: all sessions are sampled
+	sampling *cfg.SessionSampling
+	// empty when not set
+	profile string
+	// deployment identity fields set by the config file; others are sourced from env vars
+	identity map[log.Field]string
+	// `nil` when not set
+	gzip *bool
+	// local directories; empty when not set
+	directory string
+	gcsMount  string
+}
+
+// downloadConfig copies the config file stored at `gcsURI` into a local file, and returns its path;
+// the config file is read many times, so it is downloaded once at startup, and then once per reload.
+func downloadConfig(
+	gcsURI string,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	content, err := cfg.FetchFromGCS(ctx, gcsURI)
+	if err != nil {
+		return "", err
+	}
+	localConfig, err := os.CreateTemp("", "pcapfsn-config-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer localConfig.Close()
+	if _, err := localConfig.Write(content); err != nil {
+		return "", err
+	}
+	return localConfig.Name(), nil
+}
+
+// loadConfig reads the PCAP files extensions, the interfaces specification, and whether export is enabled
+// from the sidecar JSON config file.
+func loadConfig(
+	configFile string,
+) (*sidecarConfig, error) {
+	ctx, report, err := cfg.LoadJSONWithReport(context.Background(), configFile)
+	if err != nil {
+		return nil, err
+	}
+	if report.HasFatal() {
+		return nil, fmt.Errorf("required keys are missing:\n%s", report)
+	}
+	extensions, err := cfg.GetExtension(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// `tcpdumpw` writes JSON dumps next to PCAP files: they are exported along with them
+	if jsondump, _ := cfg.IsJsondumpEnabled(ctx); jsondump {
+		extensions = mergeExtensions(extensions, []string{"json"})
+	}
+	// the interfaces specification is optional
+	ifaceSpec, _ := cfg.GetIface(ctx)
+	// config files which predate the export flag always export
+	gcsExport, err := cfg.IsGcsExportEnabled(ctx)
+	if err != nil {
+		gcsExport = true
+	}
+	// both directories are optional, and relative to the GCS bucket
+	gcsDir, _ := cfg.GetGcsDir(ctx)
+	gcsTempDir, _ := cfg.GetGcsTempDir(ctx)
+	sidecarCfg := &sidecarConfig{
+		extensions: extensions,
+		ifaceSpec:  ifaceSpec,
+		gcsExport:  gcsExport,
+		gcsDir:     gcsDir,
+		gcsTempDir: gcsTempDir,
+	}
+	if hcPort, err := cfg.GetHealthcheckPort(ctx); err == nil {
+		sidecarCfg.hcPort = hcPort
+	} else if errors.Is(err, cfg.InvalidConfigError) {
+		return nil, err
+	}
+	if enabled, _ := cfg.IsCronEnabled(ctx); enabled {
+		if sidecarCfg.cronExpression, err = cfg.GetCronExpression(ctx); err != nil {
+			return nil, fmt.Errorf("cron: %w", err)
+		}
+	}
+	if timeout, err := cfg.GetCaptureTimeout(ctx); err == nil {
+		sidecarCfg.captureTimeout = timeout
+	} else if errors.Is(err, cfg.InvalidConfigError) {
+		return nil, err
+	}
+	if overrides, err := cfg.GetCompressionOverrides(ctx); err == nil {
+		if _, err := compression.ParsePins(overrides); err != nil {
+			return nil, fmt.Errorf("compression: %w", err)
+		}
+		sidecarCfg.compression = overrides
+	}
+	if fields, err := cfg.GetLogFields(ctx); err == nil {
+		if sidecarCfg.logFields, err = log.ParseFields(fields); err != nil {
+			return nil, fmt.Errorf("logging fields: %w", err)
+		}
+	}
+	if fields, err := cfg.GetAuditFields(ctx); err == nil {
+		if sidecarCfg.auditFields, err = log.ParseFields(fields); err != nil {
+			return nil, fmt.Errorf("audit fields: %w", err)
+		}
+	}
+	if sessionSampling, err := cfg.GetSessionSampling(ctx); err == nil {
+		sidecarCfg.sampling = sessionSampling
+	} else if errors.Is(err, cfg.InvalidConfigError) {
+		return nil, fmt.Errorf("session sampling: %w", err)
+	}
+	// keys of the active profile are already expanded: its name is only used to annotate exports
+	sidecarCfg.profile, _ = cfg.GetProfile(ctx)
+	sidecarCfg.identity = newConfigIdentity(ctx)
+	if enabled, err := cfg.IsGzipEnabled(ctx); err == nil {
+		sidecarCfg.gzip = &enabled
+	}
+	sidecarCfg.directory, _ = cfg.GetDirectory(ctx)
+	sidecarCfg.gcsMount, _ = cfg.GetGcsMountPoint(ctx)
+	return sidecarCfg, nil
+}
+
+// newConfigIdentity returns the deployment identity fields which are set by the config file.
+func newConfigIdentity(
+	ctx context.Context,
+) map[log.Field]string {
+	identity := map[log.Field]string{}
+	getters := map[log.Field]func(context.Context) (string, error){
+		log.FIELD_PROJECT:  cfg.GetProjectID,
+		log.FIELD_REGION:   cfg.GetRegion,
+		log.FIELD_INSTANCE: cfg.GetInstanceID,
+	}
+	for field, get := range getters {
+		if value, err := get(ctx); err == nil {
+			identity[field] = value
+		}
+	}
+	return identity
+}
+
+// newSessionSampler decides whether PCAP files of this session are exported with full fidelity;
+// sessions are always sampled when sampling is not configured, or when `force` is set.
+func newSessionSampler(
+	sessionSampling *cfg.SessionSampling,
+	key string,
+	force bool,
+) *sampling.Sampler {
+	decision := sampling.Decision{Sampled: true, Rate: 1, Mode: sampling.MODE_DROP, Seed: sampling.SEED_INSTANCE}
+	if sessionSampling != nil {
+		decision = sampling.NewDecision(sessionSampling.Rate,
+			sampling.Mode(sessionSampling.Mode), sampling.Seed(sessionSampling.Seed), key, rand.Float64)
+	}
+	sampler := sampling.NewSampler(decision)
+	if force {
+		// nothing has been exported yet: forcing cannot fail
+		decision, _ = sampler.Force()
+	}
+	healthServer.SetInfo("sampling", decision)
+	logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("session sampled: %t", decision.Sampled),
+		&telemetry.Sample{Sampling: decision}, nil)
+	return sampler
+}
+
+// newCompressionPins returns the per-interface gzip levels from `flagValue` when set, otherwise from the config file.
+func newCompressionPins(
+	flagValue string,
+	cfgOverrides []string,
+) (map[string]int, error) {
+	if flagValue != "" {
+		return compression.ParsePins(strings.Split(flagValue, ","))
+	}
+	return compression.ParsePins(cfgOverrides)
+}
+
+// newStatusAddr returns the address where health checks are served: `statusAddr` when set, otherwise
+// the port next to `hcPort`, which is already used by 'tcpdumpw' to accept startup probes. Empty disables it.
+func newStatusAddr(
+	statusAddr string,
+	hcPort uint16,
+) string {
+	if statusAddr == "none" {
+		return ""
+	}
+	if statusAddr != "" || hcPort == 0 || hcPort == math.MaxUint16 {
+		return statusAddr
+	}
+	return fmt.Sprintf(":%d", hcPort+1)
+}
+
+// isFlagSet reports whether the flag `name` was set explicitly in `flags`.
+func isFlagSet(
+	flags *flag.FlagSet,
+	name string,
+) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// newGzipEnabled returns whether PCAP files are compressed, and where the decision comes from:
+// the `gzip` flag when it is set explicitly, then `cfgGzip`, or the default value of the flag.
+func newGzipEnabled(
+	flags *flag.FlagSet,
+	cfgGzip *bool,
+) (bool, string) {
+	enabled, _ := flags.Lookup("gzip").Value.(flag.Getter).Get().(bool)
+	if isFlagSet(flags, "gzip") {
+		return enabled, "flag"
+	}
+	if cfgGzip != nil {
+		return *cfgGzip, "config"
+	}
+	return enabled, "default"
+}
+
+// newConfigDirs returns the source and destination directories of PCAP files: the `src_dir` and `gcs_dir` flags
+// when set explicitly, otherwise the `directory` of the config file, and its `gcs.dir` within `gcs.mount`.
+// Directories derived from the config file must exist; the destination only when `checkDest` is set.
+func newConfigDirs(
+	flags *flag.FlagSet,
+	sidecarCfg *sidecarConfig,
+	checkDest bool,
+) (string, string, error) {
+	srcDir := flags.Lookup("src_dir").Value.String()
+	gcsDir := flags.Lookup("gcs_dir").Value.String()
+	var errs []error
+	if !isFlagSet(flags, "src_dir") && sidecarCfg.directory != "" {
+		srcDir = filepath.Clean(sidecarCfg.directory)
+		if err := checkDir(srcDir); err != nil {
+			errs = append(errs, fmt.Errorf("directory: %w", err))
+		}
+	}
+	if !isFlagSet(flags, "gcs_dir") && sidecarCfg.gcsMount != "" {
+		gcsDir = filepath.Join(sidecarCfg.gcsMount, sidecarCfg.gcsDir)
+		if err := checkDir(gcsDir); checkDest && err != nil {
+			errs = append(errs, fmt.Errorf("gcs.dir: %w", err))
+		}
+	}
+	if err := checkDistinctDirs(srcDir, gcsDir); err != nil {
+		errs = append(errs, fmt.Errorf("gcs.dir: %w", err))
+	}
+	return srcDir, gcsDir, errors.Join(errs...)
+}
+
+// checkDistinctDirs fails when PCAP files would be exported into the directory where they are watched:
+// exports would overwrite their own source, and every exported PCAP file would be detected as a new one.
+func checkDistinctDirs(
+	srcDir, gcsDir string,
+) error {
+	if filepath.Clean(srcDir) == filepath.Clean(gcsDir) {
+		return fmt.Errorf("must not be the source directory: %s", gcsDir)
+	}
+	// symbolic links and bind mounts may lead both to the same directory
+	srcInfo, srcErr := os.Stat(srcDir)
+	gcsInfo, gcsErr := os.Stat(gcsDir)
+	if srcErr == nil && gcsErr == nil && os.SameFile(srcInfo, gcsInfo) {
+		return fmt.Errorf("must not be the source directory: %s is the same directory as %s", gcsDir, srcDir)
+	}
+	return nil
+}
+
+func checkDir(
+	dir string,
+) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", dir)
+	}
+	return nil
+}
+
+// newFields returns the identity fields allowlist: from `flagValue` when set, then from `cfgFields`, or `defaultFields`.
+func newFields(
+	flagValue string,
+	cfgFields log.Fields,
+	defaultFields log.Fields,
+) (log.Fields, error) {
+	if flagValue != "" {
+		return log.ParseFields(strings.Split(flagValue, ","))
+	}
+	if cfgFields != nil {
+		return cfgFields, nil
+	}
+	return defaultFields, nil
+}
+
+// setupPrefixShard places `gcs_dir` under the prefix shard of this session, and records it in the daily shard index
+// so that all sessions of a day can be found without listing every prefix; the index is only available with GCS Fuse.
+func setupPrefixShard() error {
+	mount := gcs.MountPoint(*gcs_dir)
+	shard, err := gcs.NewPrefixShard(*shard_prefix, mount, *gcs_dir)
+	if err != nil || shard == nil {
+		return err
+	}
+	prefixShard = shard
+	*gcs_dir = shard.Dir(*gcs_dir)
+	healthServer.SetInfo("prefix_shard", shard)
+	logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("destination directory is under prefix shard '%s': %s", shard.Token, *gcs_dir),
+		&telemetry.FsnIni{PrefixShard: shard, Directory: *gcs_dir}, nil)
+
+	if !*gcs_export || !*gcs_fuse {
+		logger.LogEvent(zapcore.WarnLevel, "shard index is only available with GCS Fuse",
+			&telemetry.FsnIni{PrefixShard: shard}, nil)
+		return nil
+	}
+	// the sharded destination directory is created on the first export
+	if shortLived.Enabled {
+		logger.LogEvent(zapcore.InfoLevel, "shard index is disabled: instance is short-lived",
+			&telemetry.FsnIni{PrefixShard: shard}, nil)
+		return nil
+	}
+	// the sharded destination directory is not created by the init script
+	if err := os.MkdirAll(*gcs_dir, 0o777); err != nil {
+		return err
+	}
+	return gcs.NewFuseShardIndex(logger, mount).Record(context.Background(), time.Now(), shard)
+}
+
+// newStagingDir maps the staging directory from the config file into the local path used to export PCAP files.
+// The config file directories are relative to the GCS bucket, so the GCS bucket root is found by removing `gcsDir`
+// from `gcs_dir`; if `gcs_dir` does not end with `gcsDir`, its first element is assumed to be the GCS bucket root.
+func newStagingDir(
+	localGcsDir, gcsDir, gcsTempDir string,
+) string {
+	if gcsTempDir == "" {
+		return ""
+	}
+	if filepath.IsAbs(gcsTempDir) {
+		return filepath.Clean(gcsTempDir)
+	}
+	localGcsDir = filepath.Clean(localGcsDir)
+	gcsDir = strings.Trim(filepath.Clean(gcsDir), "/")
+	if root, ok := strings.CutSuffix(localGcsDir, "/"+gcsDir); ok && gcsDir != "." && root != "" {
+		return filepath.Join(root, gcsTempDir)
+	}
+	// same layout used to name objects when exporting using the GCS client library: `${0}/${1:GCS_MOUNT}/...`
+	parts := strings.SplitN(localGcsDir, "/", 3)
+	if len(parts) < 2 {
+		return filepath.Join(localGcsDir, gcsTempDir)
+	}
+	return filepath.Join("/", parts[1], gcsTempDir)
+}
+
+// mergeExtensions appends to `extensions` all the `others` that it does not contain yet.
+func mergeExtensions(
+	extensions, others []string,
+) []string {
+	for _, other := range others {
+		if other != "" && !slices.Contains(extensions, other) {
+			extensions = append(extensions, other)
+		}
+	}
+	return extensions
+}
+
+// newExtensionsWarning reports the extensions of PCAP files written by `tcpdumpw` which `pcap_ext` does not include;
+// i.e.: capturing `pcapng` while watching `pcap`. Such PCAP files are only exported because extensions
+// are merged with the config file, so it is a warning rather than an error.
+func newExtensionsWarning(
+	captured, watched []string,
+) error {
+	if unwatched := cfg.UnwatchedExtensions(captured, watched); len(unwatched) > 0 {
+		return fmt.Errorf("pcap_ext: does not include the extensions of captured PCAP files: %s", strings.Join(unwatched, ","))
+	}
+	return nil
+}
+
+// reloadExtensions recompiles the extensions of detected PCAP files from the config file at `configSource`, along with `pcap_ext`,
+// without restarting the watcher: PCAP files of previous extensions which were already detected are still exported.
+// The rest of the naming pattern is not reloaded: it is fixed by `tcpdumpw`, and `key_template` is a flag.
+func reloadExtensions(
+	pcapDotExt *naming.Matcher,
+	configSource string,
+	watched []string,
+) error {
+	if configSource == "" {
+		return errNothingToReload
+	}
+	configFile := configSource
+	if cfg.IsGCSURI(configSource) {
+		localConfig, err := downloadConfig(configSource)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(localConfig)
+		configFile = localConfig
+	}
+	sidecarCfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if warning := newExtensionsWarning(sidecarCfg.extensions, watched); warning != nil {
+		logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("watching extensions from the config file: %v", warning), &telemetry.FsnIni{}, warning)
+	}
+	pcapDotExt.Reload(mergeExtensions(sidecarCfg.extensions, watched))
+	return nil
+}
+
+// validateFlags reports all invalid flags values and combinations at once.
+func validateFlags() error {
+	errs := []error{}
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if info, err := os.Stat(*src_dir); err != nil {
+		invalid("src_dir: %w", err)
+	} else if !info.IsDir() {
+		invalid("src_dir: not a directory: %s", *src_dir)
+	}
+	if err := checkDistinctDirs(*src_dir, *gcs_dir); err != nil {
+		invalid("gcs_dir: %w", err)
+	}
+	if _, err := cfg.ParseExtensions(*pcap_ext); err != nil {
+		invalid("pcap_ext: %w", err)
+	}
+	if !slices.Contains([]string{"run", "gae", "gke"}, *gcp_env) {
+		invalid("env: must be one of (run,gae,gke): %s", *gcp_env)
+	}
+	if *interval == 0 {
+		invalid("interval: must be greater than 0")
+	}
+	if _, err := watch.ParseMode(*watch_mode); err != nil {
+		invalid("watch_mode: %w", err)
+	}
+	if *poll_interval == 0 {
+		invalid("poll_interval: must be greater than 0")
+	}
+	if trigger, err := watch.ParseTrigger(*export_on); err != nil {
+		invalid("export_on: %w", err)
+	} else if trigger == watch.TRIGGER_WRITE_CLOSE && *export_quiet == 0 {
+		invalid("export_quiet: must be greater than 0 when 'export_on' is 'write-close'")
+	}
+	if *watch_retries > 0 && *watch_delay == 0 {
+		invalid("watch_init_delay: must be greater than 0 when 'watch_init_retries' is set")
+	}
+	if *active_flush && *flush_timeout <= 0 {
+		invalid("active_flush_timeout: must be greater than 0 when active_flush is enabled")
+	}
+	if _, err := time.LoadLocation(*timezone); err != nil {
+		invalid("timezone: %w", err)
+	}
+	if _, err := lifecycle.ParseLockFailure(*lock_failure); err != nil {
+		invalid("lock_failure: %w", err)
+	}
+	if _, err := lifecycle.ParseSignals(*shutdown_sigs); err != nil {
+		invalid("shutdown_signals: %w", err)
+	}
+	if _, err := naming.ParseKeyTemplate(*key_template); err != nil {
+		invalid("key_template: %w", err)
+	}
+	if _, err := naming.ParseSanitizeMode(*sanitize); err != nil {
+		invalid("sanitize: %w", err)
+	}
+	if _, err := shortlived.ParseMode(*short_lived); err != nil {
+		invalid("short_lived: %w", err)
+	}
+	if *psi_threshold < 0 || *psi_threshold > 100 {
+		invalid("pressure_threshold: must be between 0 and 100: %v", *psi_threshold)
+	}
+	if *psi_threshold > 0 && *psi_check == 0 {
+		invalid("pressure_check: must be greater than 0")
+	}
+	if _, err := gcs.NewShards(*shard_count); err != nil {
+		invalid("shard_count: %w", err)
+	}
+	if _, err := gcs.NewPrefixShard(*shard_prefix, gcs.MountPoint(*gcs_dir), *gcs_dir); err != nil {
+		invalid("shard_prefixes: %w", err)
+	}
+	if *gcs_temp_dir != "" {
+		stagingDir := filepath.Clean(*gcs_temp_dir)
+		if stagingDir == filepath.Clean(*src_dir) || stagingDir == filepath.Clean(*gcs_dir) {
+			invalid("gcs_temp_dir: must not be the source or the destination directory: %s", *gcs_temp_dir)
+		}
+	}
+	if *mirror_dir != "" {
+		mirrorDir := filepath.Clean(*mirror_dir)
+		if mirrorDir == filepath.Clean(*src_dir) || mirrorDir == filepath.Clean(*gcs_dir) {
+			invalid("mirror_dir: must not be the source or the destination directory: %s", *mirror_dir)
+		}
+		if *mirror_files < 0 {
+			invalid("mirror_files: must not be negative: %d", *mirror_files)
+		}
+		if *mirror_bytes < 0 {
+			invalid("mirror_max_bytes: must not be negative: %d", *mirror_bytes)
+		}
+	}
+	if *ckpt_interval > 0 && (*ckpt_interval >= *interval || *interval%*ckpt_interval != 0) {
+		invalid("checkpoint: must be shorter than and divide interval (%v): %v", *interval, *ckpt_interval)
+	}
+	if *postproc != "" {
+		if _, err := newPostprocessor(*postproc); err != nil {
+			invalid("postprocess: %w", err)
+		}
+	}
+	if err := (compression.Bounds{Min: *comp_min, Max: *comp_max}).Validate(); err != nil {
+		invalid("compress_min_level/compress_max_level: %w", err)
+	}
+	if *comp_window < 1 {
+		invalid("compress_window: must be positive: %d", *comp_window)
+	}
+	if *comp_levels != "" {
+		if _, err := compression.ParsePins(strings.Split(*comp_levels, ",")); err != nil {
+			invalid("compress_levels: %w", err)
+		}
+	}
+	if *sample_snap == 0 || *sample_snap > math.MaxUint32 {
+		invalid("session_sample_snaplen: must be between 1 and %d: %d", uint32(math.MaxUint32), *sample_snap)
+	}
+	if *canary_every > 0 && *canary_delay >= *canary_every {
+		invalid("canary_delay: must be shorter than canary_interval (%v): %v", *canary_every, *canary_delay)
+	}
+	if *cmp_codecs {
+		if _, err := compression.NewZstdCodec(); err != nil {
+			invalid("compare_codecs: %w", err)
+		}
+	}
+	if *export_first && *first_after <= 0 {
+		invalid("export_first_after: must be greater than 0 when export_first is enabled")
+	}
+	if *canary_delay < 0 {
+		invalid("canary_delay: must not be negative: %v", *canary_delay)
+	}
+	if *min_age < 0 {
+		invalid("min_age_before_export: must not be negative: %v", *min_age)
+	}
+	if *bf_bytes < 0 {
+		invalid("backfill_bytes_per_sec: must not be negative: %d", *bf_bytes)
+	}
+	if *bf_ops < 0 {
+		invalid("backfill_ops_per_sec: must not be negative: %v", *bf_ops)
+	}
+	if *bf_adaptive && *bf_bytes == 0 {
+		invalid("backfill_adaptive: requires backfill_bytes_per_sec")
+	}
+	if *files_token != "" {
+		if *files_max <= 0 {
+			invalid("files_max_bytes: must be positive: %d", *files_max)
+		}
+		if _, err := auth.ReadToken(*files_token); err != nil {
+			invalid("files_token_file: %w", err)
+		}
+	}
+	if *slo_ratio < 0 || *slo_ratio > 1 {
+		invalid("durability_slo_ratio: must be between 0 and 1: %v", *slo_ratio)
+	}
+	if *gcs_export && !*gcs_fuse && (*gcs_bucket == "" || *gcs_bucket == "none") {
+		invalid("gcs_bucket: required when exporting without GCS Fuse")
+	}
+	if *gcs_kms_key != "" {
+		if err := gcs.ValidateKMSKeyName(*gcs_kms_key); err != nil {
+			invalid("gcs_kms_key: %w", err)
+		}
+	}
+	if *iface_spec != "" {
+		if _, err := iface.ParseSpec(*iface_spec); err != nil {
+			invalid("iface: %w", err)
+		}
+	}
+	if _, err := newFields(*log_fields, nil, log.AllFields); err != nil {
+		invalid("log_fields: %w", err)
+	}
+	if _, err := newFields(*audit_fields, nil, log.DefaultAuditFields); err != nil {
+		invalid("audit_fields: %w", err)
+	}
+	if *config_file != "" {
+		if _, err := loadConfig(*config_file); err != nil {
+			invalid("config: %w", err)
 		}
-		return 0, err
 	}
-	return uint64(memoryUtilization), nil
-}
 
-func flushBuffers() (int, error) {
-	cmd := exec.Command("sync")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
-	// see: https://www.kernel.org/doc/Documentation/sysctl/vm.txt
-	fd, err := os.OpenFile(procSysVmDropCaches,
-		os.O_WRONLY|os.O_TRUNC|os.O_EXCL, 0o200 /* --w------- */)
-	if err != nil {
-		return 0, err
-	}
-	defer fd.Close()
-	return fmt.Fprintln(fd, "3")
+	return errors.Join(errs...)
 }
 
-func exportPcapFile(
-	ctx context.Context,
-	wg *sync.WaitGroup,
-	pcapDotExt *regexp.Regexp,
-	srcFile *string,
-	compress, delete, flush bool,
-) bool {
-	defer wg.Done()
+// effectiveFlags returns the value of all flags, including defaults.
+func effectiveFlags() map[string]any {
+	flags := make(map[string]any)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
 
-	if flush && isActive.Load() {
-		return false
+// checkConfig validates flags and the config file without starting the exporter, and logs the effective configuration.
+func checkConfig() error {
+	flags := &telemetry.FsnIni{Flags: effectiveFlags()}
+	if err := validateFlags(); err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, "invalid configuration", flags, err)
+		return err
 	}
-
-	rMatch := pcapDotExt.FindStringSubmatch(*srcFile)
-	if len(rMatch) == 0 || len(rMatch) < 3 {
-		return false
+	if *config_file != "" {
+		// config file errors are already reported by `validateFlags`
+		if sidecarCfg, err := loadConfig(*config_file); err == nil {
+			if warning := newExtensionsWarning(sidecarCfg.extensions, strings.Split(*pcap_ext, ",")); warning != nil {
+				logger.LogEvent(zapcore.WarnLevel, "inconsistent configuration", flags, warning)
+			}
+		}
+		// keys which are never read are most likely misspelled: they are not fatal when running
+		if _, report, err := cfg.LoadJSONWithKeysMode(context.Background(), *config_file, cfg.KEYS_WARN); err == nil {
+			for _, entry := range report.Unknown() {
+				logger.LogEvent(zapcore.WarnLevel, "unknown config key", flags, errors.New(entry.String()))
+			}
+		}
 	}
+	logger.LogEvent(zapcore.InfoLevel, "valid configuration", flags, nil)
+	return nil
+}
 
-	iface := fmt.Sprintf("%s:%s", rMatch[1], rMatch[2])
-	ext := rMatch[3]
-	key := strings.Join(rMatch[1:], "/")
+// logEnvironment logs every consumed env var along with the source of its value, and warns about malformed values.
+func logEnvironment() {
+	for _, v := range environ.Malformed() {
+		logger.LogEvent(zapcore.WarnLevel,
+			fmt.Sprintf("ignoring malformed env var %s=%q: using %v", v.Name, v.Malformed, v.Value),
+			&telemetry.FsnIni{Name: v.Name, Value: v.Malformed, Default: v.Value}, v.Err)
+	}
+	logger.LogEvent(zapcore.InfoLevel, "environment", &telemetry.FsnIni{Env: environ.Summary()}, nil)
+}
 
-	lastPcapFileName, loaded := lastPcap.Get(key)
+func main() {
+	flag.Parse()
 
-	// `flushing` is the only thread-safe PCAP export operation.
-	if flush {
-		logger.LogFsEvent(zapcore.InfoLevel,
-			fmt.Sprintf("flushing PCAP file: [%s] (%s/%s) %s", key, ext, iface, *srcFile), PCAP_EXPORT, *srcFile, "" /* target PCAP file */, 0, nil)
-		tgtPcapFileName, pcapBytes, moveErr := movePcapToGcs(ctx, srcFile, compress, delete)
-		if moveErr != nil {
-			logger.LogFsEvent(zapcore.ErrorLevel,
-				fmt.Sprintf("failed to flush PCAP file: (%s/%s) %s", ext, iface, *srcFile), PCAP_FSNERR, *srcFile, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
-			return false
+	if *print_version || *version_json {
+		if err := buildinfo.Get().Print(os.Stdout, *version_json); err != nil {
+			os.Exit(1)
 		}
-		logger.LogFsEvent(zapcore.InfoLevel,
-			fmt.Sprintf("flushed PCAP file: (%s/%s) %s", ext, iface, *tgtPcapFileName), PCAP_EXPORT, *srcFile, *tgtPcapFileName, *pcapBytes, nil)
-		return true
+		return
 	}
 
-	counter, _ := counters.GetOrCompute(key,
-		func() *atomic.Uint64 {
-			return new(atomic.Uint64)
-		})
-	iteration := (*counter).Add(1)
+	if *dump_schemas {
+		if err := telemetry.DumpSchemas(os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
 
-	logger.LogFsEvent(zapcore.InfoLevel,
-		fmt.Sprintf("new PCAP file detected: [%s] (%s/%s/%d) %s", key, ext, iface, iteration, *srcFile), PCAP_CREATE, *srcFile, "" /* target PCAP file */, 0, nil)
+	defer logger.Sync()
 
-	// Skip 1st PCAP, start moving PCAPs as soon as TCPDUMP rolls over into the 2nd file.
-	// The outcome of this implementation is that the directory in which TCPDUMP writes
-	// PCAP files will contain at most 2 files, the current one, and the one being moved
-	// into the destination directory ( `gcs_dir` ). Otherwise it will contain all PCAPs.
-	if iteration == 1 {
-		lastPcap.Set(key, *srcFile)
-		return false
+	// reloads read the config file from where it was sourced
+	configSource := *config_file
+	if cfg.IsGCSURI(*config_file) {
+		localConfig, err := downloadConfig(*config_file)
+		if err != nil {
+			logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("failed to download config file '%s': %v", *config_file, err), &telemetry.FsnIni{}, err)
+		}
+		*config_file = localConfig
 	}
 
-	if !loaded || lastPcapFileName == "" {
-		lastPcap.Set(key, *srcFile)
-		logger.LogFsEvent(zapcore.ErrorLevel, fmt.Sprintf("PCAP file [%s] (%s/%s/%d) unavailable", key, ext, iface, iteration), PCAP_EXPORT, "" /* source PCAP File */, *srcFile /* target PCAP file */, 0, nil)
-		return false
+	if *check_config {
+		if err := checkConfig(); err != nil {
+			logger.Sync()
+			os.Exit(1)
+		}
+		return
 	}
 
-	logger.LogFsEvent(zapcore.InfoLevel,
-		fmt.Sprintf("exporting PCAP file: (%s/%s/%d) %s", ext, iface, iteration, *srcFile), PCAP_EXPORT, lastPcapFileName, "" /* target PCAP file */, 0, nil)
-	// move non-current PCAP file into `gcs_dir` which means that:
-	// 1. the GCS Bucket should have already been mounted
-	// 2. the directory hierarchy to store PCAP files already exists
-	tgtPcapFileName, pcapBytes, moveErr := movePcapToGcs(ctx, &lastPcapFileName, compress, delete)
-	if moveErr == nil {
-		logger.LogFsEvent(zapcore.InfoLevel,
-			fmt.Sprintf("exported PCAP file: (%s/%s/%d) %s", ext, iface, iteration, *tgtPcapFileName), PCAP_EXPORT, lastPcapFileName, *tgtPcapFileName, *pcapBytes, nil)
-	} else {
-		logger.LogFsEvent(zapcore.ErrorLevel,
-			fmt.Sprintf("failed to export PCAP file: (%s/%s/%d) %s", ext, iface, iteration, lastPcapFileName), PCAP_EXPORT, lastPcapFileName, *tgtPcapFileName /* target PCAP file */, 0, moveErr)
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	logger.SetEventIDs(eventIDOf)
+
+	// an explicit `-gae` flag takes precedence over `PCAP_GAE`; it selects the cgroup memory file
+	isGAE := environ.BoolWithFlag("PCAP_GAE", flag.CommandLine, "gae", false /* default */)
+
+	pcapExtensions := strings.Split(*pcap_ext, ",")
+	ifaceSpec := *iface_spec
+	stagingDir := *gcs_temp_dir
+	var cfgLogFields, cfgAuditFields log.Fields
+	var cfgHcPort uint16
+	var cronExpression string
+	var captureTimeout time.Duration
+	var cfgCompression []string
+	var cfgSampling *cfg.SessionSampling
+	var cfgGzip *bool
+	if *config_file != "" {
+		sidecarCfg, err := loadConfig(*config_file)
+		if err != nil {
+			logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("invalid config file '%s': %v", *config_file, err), &telemetry.FsnIni{}, err)
+			os.Exit(1)
+		}
+		// the PCAP files producer and consumer must agree on extensions
+		if warning := newExtensionsWarning(sidecarCfg.extensions, pcapExtensions); warning != nil {
+			logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("watching extensions from the config file: %v", warning), &telemetry.FsnIni{}, warning)
+		}
+		pcapExtensions = mergeExtensions(sidecarCfg.extensions, pcapExtensions)
+		if ifaceSpec == "" {
+			ifaceSpec = sidecarCfg.ifaceSpec
+		}
+		// export is only enabled when both the flag and the config file enable it
+		if !sidecarCfg.gcsExport {
+			*gcs_export = false
+		}
+		// the PCAP files producer and consumer must agree on where PCAP files live
+		if *src_dir, *gcs_dir, err = newConfigDirs(flag.CommandLine, sidecarCfg, *gcs_export && *gcs_fuse); err != nil {
+			logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("invalid directories in config file '%s': %v", *config_file, err), &telemetry.FsnIni{}, err)
+			os.Exit(1)
+		}
+		if stagingDir == "" {
+			stagingDir = newStagingDir(*gcs_dir, sidecarCfg.gcsDir, sidecarCfg.gcsTempDir)
+		}
+		cfgLogFields, cfgAuditFields = sidecarCfg.logFields, sidecarCfg.auditFields
+		cfgHcPort = sidecarCfg.hcPort
+		cronExpression, captureTimeout = sidecarCfg.cronExpression, sidecarCfg.captureTimeout
+		cfgCompression = sidecarCfg.compression
+		cfgSampling = sidecarCfg.sampling
+		captureProfile = sidecarCfg.profile
+		cfgGzip = sidecarCfg.gzip
+		// the config file is the source of truth for the deployment identity; env vars fill the gaps
+		logger.SetIdentity(sidecarCfg.identity)
+		identity := logger.Identity()
+		projectID, gcpRegion, instanceID = identity[log.FIELD_PROJECT], identity[log.FIELD_REGION], identity[log.FIELD_INSTANCE]
 	}
+	// an explicit `-gzip` flag takes precedence over the config file, so that compression is toggled in one place
+	gzipEnabled, gzipSource := newGzipEnabled(flag.CommandLine, cfgGzip)
+	*gzip_pcaps = gzipEnabled
+	// all modules derive their health checks ports from the same config
+	statusAddr := newStatusAddr(*status_addr, cfgHcPort)
+	// identity fields excluded from log events are still used wherever they are functionally required
+	logFields, _ := newFields(*log_fields, cfgLogFields, log.AllFields)
+	logger.SetFields(logFields)
+	auditFields, _ := newFields(*audit_fields, cfgAuditFields, log.DefaultAuditFields)
+	pcapDotExt := naming.NewMatcher(*src_dir, pcapExtensions)
+	tcpdumpwExitSignal := regexp.MustCompile(`^` + *src_dir + `/TCPDUMPW_EXITED$`)
+	tcpdumpwReadySignal := regexp.MustCompile(`^` + *src_dir + `/TCPDUMPW_READY$`)
 
-	// current PCAP file is the next one to be moved
-	if !lastPcap.CompareAndSwap(key, lastPcapFileName, *srcFile) {
-		logger.LogFsEvent(zapcore.ErrorLevel,
-			fmt.Sprintf("leaked PCAP file: [%s] (%s/%s/%d) %s", key, ext, iface, iteration, *srcFile), PCAP_FSNERR, *srcFile, "" /* target PCAP file */, 0, nil)
-		lastPcap.Set(key, *srcFile)
-	}
-	logger.LogFsEvent(zapcore.InfoLevel,
-		fmt.Sprintf("queued PCAP file: (%s/%s/%d) %s", ext, iface, iteration, *srcFile), PCAP_QUEUED, *srcFile, "" /* target PCAP file */, 0, nil)
+	// PCAP files bookkeeping starts when `tcpdumpw` signals that its capture session started
+	startGate := gate.NewStartGate(*ready_timeout > 0)
+	readyTimeout := *ready_timeout
 
-	return moveErr == nil
-}
+	// must match the value of `PCAP_ROTATE_SECS`
+	watchdogInterval := *interval
+	gaps = rotation.NewGapDetector(watchdogInterval)
 
-func flushSrcDir(
-	ctx context.Context,
-	wg *sync.WaitGroup,
-	pcapDotExt *regexp.Regexp,
-	sync, compress, delete bool,
-	validator func(fs.FileInfo) bool,
-) uint32 {
-	pendingPcapFiles := uint32(0)
-	if sync {
-		flushBuffers()
+	if location, err := time.LoadLocation(*timezone); err == nil {
+		captureLocation = location
+	} else {
+		logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("could not load timezone '%s': %v", *timezone, err), &telemetry.FsnIni{}, err)
 	}
-	filepath.Walk(*src_dir, func(path string, info fs.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		if err != nil {
-			logger.LogEvent(zapcore.ErrorLevel, "failed to flush PCAP files", PCAP_FSNERR, nil, err)
-			return nil
+	if cronExpression != "" && captureTimeout > 0 {
+		// the expression was validated when loading the config file
+		if schedule, err := cfg.ParseCronExpression(cronExpression); err == nil {
+			// `tcpdumpw` schedules captures using the same cron expression, timezone and timeout
+			captureWindow = window.NewTracker(schedule, captureTimeout, captureLocation, time.Now())
+			exportsPausedByWindow.Store(true)
 		}
-		if validator(info) {
-			pendingPcapFiles += 1
-			wg.Add(1)
-			go exportPcapFile(ctx, wg, pcapDotExt, &path, compress, delete, true /* flush */)
+	}
+	if *config_file != "" {
+		// publishes the BPF filter the capture starts with; later changes are checked along with config snapshots
+		if content, err := os.ReadFile(*config_file); err == nil {
+			configSnapshotMu.Lock()
+			checkFilterDrift(content)
+			configSnapshotMu.Unlock()
 		}
-		return nil
-	})
-	return pendingPcapFiles
-}
-
-func main() {
-	isActive.Store(false)
+	}
+	compressionPins, _ := newCompressionPins(*comp_levels, cfgCompression)
+	compressionAdapter = compression.NewAdapter(compression.Bounds{Min: *comp_min, Max: *comp_max},
+		*comp_adaptive, *comp_window, compressionPins)
+	sessionSampler = newSessionSampler(cfgSampling, instanceID,
+		environ.BoolWithFlag("PCAP_FSN_SESSION_SAMPLE_FORCE", flag.CommandLine, "session_sample_force", false /* default */))
+	if *cmp_codecs {
+		// `zstd` was found when validating flags
+		zstdCodec, _ := compression.NewZstdCodec()
+		comparedCodecs = []compression.Codec{compression.NewGzipCodec(), zstdCodec}
+		logger.LogEvent(zapcore.WarnLevel, "comparing codecs: every exported PCAP file is compressed once per codec; benchmarking only", &telemetry.Codecs{}, nil)
+	}
+	// the last 100 exports are used to evaluate the durability SLO
+	durabilitySLO = slo.NewTracker(*slo_target, *slo_ratio, 100)
+	if *exp_latency {
+		// export latencies are only tracked, never evaluated against a target
+		exportLatencies = slo.NewTracker(0, 0, 1)
+	}
+	backfills = backfill.NewController(backfill.Limits{BytesPerSecond: *bf_bytes, OpsPerSecond: *bf_ops}, *bf_adaptive,
+		func() bool { return !durabilitySLO.Degraded() })
 
-	flag.Parse()
+	watchMode, watchModeErr := watch.ParseMode(*watch_mode)
+	if watchModeErr != nil {
+		logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("using watch mode '%s': %v", watchMode, watchModeErr), &telemetry.FsnIni{}, watchModeErr)
+	}
+	pollInterval := *poll_interval
+	// `export_on` and `lock_failure` are validated along with all other flags
+	exportTrigger, _ = watch.ParseTrigger(*export_on)
+	lockFailure, _ = lifecycle.ParseLockFailure(*lock_failure)
+
+	sanitizeMode, sanitizeModeErr := naming.ParseSanitizeMode(*sanitize)
+	if sanitizeModeErr != nil {
+		logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("using sanitize mode '%s': %v", sanitizeMode, sanitizeModeErr), &telemetry.FsnIni{}, sanitizeModeErr)
+	}
+	naming.SetSanitizeMode(sanitizeMode)
+	// `key_template` is validated along with all other flags
+	keyTemplate, _ := naming.ParseKeyTemplate(*key_template)
+	naming.SetKeyTemplate(keyTemplate)
+
+	var shardsErr error
+	if shards, shardsErr = gcs.NewShards(*shard_count); shardsErr != nil {
+		logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("sharding is disabled: %v", shardsErr), &telemetry.FsnIni{}, shardsErr)
+	}
 
-	defer logger.Sync()
+	shortLivedMode, _ := shortlived.ParseMode(*short_lived)
+	shortLived = shortlived.Decide(shortLivedMode, *rt_env, *interval, *short_life)
+	if shortLived.Enabled {
+		// instances may stop before the first rotation: do not wait for it to export the first PCAP files
+		*export_first = true
+	}
+	healthServer.SetInfo("short_lived", shortLived)
 
-	counters = haxmap.New[string, *atomic.Uint64]()
-	lastPcap = haxmap.New[string, string]()
+	buildInfo := buildinfo.Get()
+	healthServer.SetInfo("build", buildInfo)
 
-	isGAE, isGAEerr := strconv.ParseBool(gcpGAE)
-	isGAE = (isGAEerr == nil && isGAE) || *gcp_gae
+	args := map[string]any{
+		"src_dir":      *src_dir,
+		"gcs_dir":      *gcs_dir,
+		"gcs_export":   *gcs_export,
+		"gcs_fuse":     *gcs_fuse,
+		"gcs_bucket":   *gcs_bucket,
+		"gcs_kms_key":  *gcs_kms_key,
+		"gcs_temp_dir": stagingDir,
+		"pcap_ext":     pcapDotExt.String(),
+		"interval":     watchdogInterval.String(),
+		"retries":      *retries_max,
+		"delay":        retries_delay.String(),
+		"gzip":         *gzip_pcaps,
+		"gzip_source":  gzipSource,
+		"rt_env":       *rt_env,
+		"pcap_debug":   *pcap_debug,
+		"watch_mode":   watchMode,
+		"poll":         pollInterval.String(),
+		"watch_init":   fmt.Sprintf("%d/%s", *watch_retries, watch_delay.String()),
+		"trace_events": *trace_events,
+		"skip_open":    *skip_open,
+		"report_file":  *report_file,
+		"lock_failure": lockFailure,
+		"shutdown":     *shutdown_sigs,
+		"queue_report": queue_report.String(),
+		"export_on":    exportTrigger,
+		"min_free":     *min_free,
+		"config":       *config_file,
+		"ready":        readyTimeout.String(),
+		"timezone":     captureLocation.String(),
+		"slo":          slo_target.String(),
+		"latency":      *exp_latency,
+		"flush_every":  *flush_every_n,
+		"iface":        ifaceSpec,
+		"sanitize":     sanitizeMode,
+		"key_template": keyTemplate,
+		"shards":       *shard_count,
+		"prefixes":     *shard_prefix,
+		"postprocess":  *postproc,
+		"json_summary": *json_summary,
+		"timeout":      export_time.String(),
+		"mirror":       *mirror_dir,
+		"active_flush": *active_flush,
+		"checkpoint":   ckpt_interval.String(),
+		"backfill":     backfills.Limits().String(),
+		"window":       captureTimeout.String(),
+		"compression":  compressionAdapter.Levels(),
+		"adaptive":     *comp_adaptive,
+		"cron":         cronExpression,
+		"status_addr":  statusAddr,
+		"pprof_addr":   *pprof_addr,
+		"files":        *files_token != "",
+		"sampling":     sessionSampler.Decision(),
+		"canary":       canary_every.String(),
+		"codecs":       *cmp_codecs,
+		"export_first": *export_first,
+		"min_age":      min_age.String(),
+		"short_lived":  shortLived,
+		"profile":      captureProfile,
+		"log_fields":   logFields.String(),
+		"audit_fields": auditFields.String(),
+		"build":        buildInfo.Map(),
+	}
 
-	ext := strings.Join(strings.Split(*pcap_ext, ","), "|")
-	pcapDotExt := regexp.MustCompile(`^` + *src_dir + `/part__(\d+?)_(.+?)__\d{8}T\d{6}\.(` + ext + `)$`)
-	tcpdumpwExitSignal := regexp.MustCompile(`^` + *src_dir + `/TCPDUMPW_EXITED$`)
+	logger.LogEvent(zapcore.InfoLevel, "starting PCAP filesystem watcher", &telemetry.FsnIni{Args: args}, nil)
+	logEnvironment()
 
-	// must match the value of `PCAP_ROTATE_SECS`
-	watchdogInterval := time.Duration(*interval) * time.Second
+	// the GCS Fuse mount may not be ready yet when the exporter starts
+	if *gcs_export && *gcs_fuse && *wait_for_dest > 0 {
+		if err := waitForDestination(*wait_for_dest); err != nil {
+			logger.LogEvent(zapcore.FatalLevel, err.Error(), &telemetry.FsnIni{}, err)
+			os.Exit(1)
+		}
+	}
 
-	args := map[string]any{
-		"src_dir":    *src_dir,
-		"gcs_dir":    *gcs_dir,
-		"gcs_export": *gcs_export,
-		"gcs_fuse":   *gcs_fuse,
-		"gcs_bucket": *gcs_bucket,
-		"pcap_ext":   pcapDotExt.String(),
-		"interval":   watchdogInterval.String(),
-		"gzip":       *gzip_pcaps,
-		"rt_env":     *rt_env,
-		"pcap_debug": *pcap_debug,
+	// must run once the GCS Fuse mount is ready, and before anything uses `gcs_dir`
+	if err := setupPrefixShard(); err != nil {
+		logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("prefix sharding is incomplete: %v", err), &telemetry.FsnIni{}, err)
 	}
 
-	logger.LogEvent(zapcore.InfoLevel, "starting PCAP filesystem watcher", PCAP_FSNINI, args, nil)
+	if *selftest {
+		if err := runSelfTest(); err != nil {
+			logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("self-test failed: %v", err), &telemetry.FsnIni{}, err)
+			os.Exit(1)
+		}
+		logger.LogEvent(zapcore.InfoLevel, "self-test passed", &telemetry.FsnIni{}, nil)
+	}
 
+	// `shutdown_signals` is validated along with all other flags
+	shutdownSignals, _ := lifecycle.ParseSignals(*shutdown_sigs)
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT)
-
-	// Create new watcher.
-	watcher, err := fsnotify.NewBufferedWatcher(100)
+	// `SIGHUP` is reserved for reloads unless it is a shutdown signal
+	reloadChan := make(chan os.Signal, 1)
+	lifecycle.NotifySignals(sigChan, reloadChan, shutdownSignals)
+
+	// `SIGUSR1` exports all non-current PCAP files on demand
+	flushChan := make(chan os.Signal, 1)
+	signal.Notify(flushChan, syscall.SIGUSR1)
+	// capture window transitions are handled by the FS events loop
+	windowEvents := make(chan window.Event)
+
+	// Create new watcher: `inotify` based, or `poll` based for filesystems without inotify support.
+	watcher, err := watch.NewWatcher(watchMode, watcherEventsBuffer, pollInterval)
 	if err != nil {
-		logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("failed to create FS watcher: %v", err), PCAP_FSNINI, nil, nil)
+		logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("failed to create FS watcher: %v", err), &telemetry.FsnIni{}, nil)
 		os.Exit(1)
 	}
 	defer watcher.Close()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	session = lifecycle.NewGroup(context.Background())
+	ctx := session.Context()
+	sessionStart := time.Now()
+
+	if statusAddr != "" {
+		// all operator endpoints are authorized by the same bearer token
+		if token := newOperatorToken(); token != nil {
+			registerPauseCommands(flushChan, token)
+			registerSampleCommand(token)
+			registerFilesEndpoint(token)
+		}
+		if err := healthServer.Start(ctx, statusAddr); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to serve health checks at '%s': %v", statusAddr, err), &telemetry.FsnIni{}, err)
+		}
+	}
+
+	if *pprof_addr != "" {
+		// profiles expose internals such as command line arguments and memory contents
+		if addr, err := profiling.Start(ctx, *pprof_addr); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to serve profiles at '%s': %v", *pprof_addr, err), &telemetry.FsnIni{}, err)
+		} else {
+			logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("serving profiles at '%s/debug/pprof/': internals are exposed; disable it once done", addr), &telemetry.FsnIni{}, nil)
+		}
+	}
 
 	if *gcs_export {
 		// if GCS export is disabled, the PCAP files `exporter` is already initialized using `NewNilExporter`
 		if *gcs_fuse {
-			exporter = gcs.NewFuseExporter(logger, *gcs_dir, *retries_max, *retries_delay)
+			exporter = gcs.NewFuseExporter(logger, *gcs_dir, stagingDir, *retries_max, *retries_delay, *min_free, shards)
+		} else {
+			exporter = gcs.NewClientLibraryExporter(ctx, logger, projectID, service, instanceID, *gcs_bucket, *gcs_dir, stagingDir, *retries_max, *retries_delay, shards, auditFields, *gcs_kms_key)
+		}
+		if *gcs_fuse && *gcs_kms_key != "" {
+			// objects written using GCS Fuse use the bucket's default encryption
+			logger.LogEvent(zapcore.WarnLevel, "ignoring KMS key: only applies when exporting using the GCS client library",
+				&telemetry.FsnIni{GcsKMSKey: *gcs_kms_key}, nil)
+		}
+		if *fast_fail > 0 {
+			// while the destination is failing, new PCAP files remain at `src_dir` without attempting to export them
+			exporter = gcs.NewFastFailExporter(logger, exporter, *gcs_dir, gcs.NewNegativeCache(*fast_fail, time.Now))
+		}
+	}
+
+	if *gcs_export && *ckpt_interval > 0 {
+		if !*gcs_fuse {
+			// objects cannot be appended to, and overwriting them on every checkpoint is not supported yet
+			logger.LogEvent(zapcore.WarnLevel, "checkpoints are disabled: not supported when exporting using the GCS client library", &telemetry.Chkpnt{}, nil)
+		} else if checkpoints, err = checkpoint.NewCheckpointer(ctx, gcs.NewFusePartials(logger, *gcs_dir, shards, *gzip_pcaps), time.Now); err == nil {
+			logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("checkpointing PCAP files every %v", *ckpt_interval),
+				&telemetry.Chkpnt{Mode: string(checkpoints.Mode())}, nil)
 		} else {
-			exporter = gcs.NewClientLibraryExporter(ctx, logger, projectID, service, instanceID, *gcs_bucket, *gcs_dir, *retries_max, *retries_delay)
+			logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("checkpoints are disabled: %v", err), &telemetry.Chkpnt{}, err)
 		}
 	}
 
-	var wg sync.WaitGroup
+	if *gcs_export && *canary_every > 0 {
+		if shortLived.Enabled {
+			// deferrable subsystems compete with the first exports for the short lifetime of the instance
+			logger.LogEvent(zapcore.InfoLevel, "canary objects are disabled: instance is short-lived", &telemetry.Canary{}, nil)
+		} else if !*gcs_fuse {
+			logger.LogEvent(zapcore.WarnLevel, "canary objects are disabled: not supported when exporting using the GCS client library", &telemetry.Canary{}, nil)
+		} else {
+			canaryProber = canary.NewProber(gcs.NewFuseCanary(logger, *gcs_dir, shards, *gzip_pcaps), *canary_delay, time.Now)
+		}
+	}
+
+	if *gcs_export && *postproc != "" && shortLived.Enabled {
+		logger.LogEvent(zapcore.InfoLevel, "post-processing is disabled: instance is short-lived", &telemetry.Analysis{}, nil)
+	} else if *gcs_export && *postproc != "" {
+		// PCAP files staged for analysis before a restart are never analyzed
+		if stale, err := filepath.Glob(filepath.Join(*src_dir, postprocessFilePrefix+"*")); err == nil {
+			for _, staged := range stale {
+				os.Remove(staged)
+			}
+		}
+		if postprocessor, err = newPostprocessor(*postproc); err == nil {
+			postprocessor.Start(ctx)
+		} else {
+			logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("post-processing is disabled: %v", err), &telemetry.Analysis{}, err)
+		}
+	}
+
+	if *mirror_dir != "" {
+		window := mirror.Window{MaxAge: *mirror_window, MaxFiles: *mirror_files, MaxBytes: *mirror_bytes}
+		if pcapMirror, err = mirror.NewMirror(*mirror_dir, window, time.Now); err == nil {
+			logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("mirroring PCAP files into: %s", *mirror_dir),
+				&telemetry.Mirror{Window: window.String()}, nil)
+		} else {
+			logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("mirroring is disabled: %v", err), &telemetry.Mirror{}, err)
+		}
+	}
 
-	// Watch the PCAP files source directory for FS events.
-	if isActive.CompareAndSwap(false, true) {
-		if err = watcher.Add(*src_dir); err != nil {
-			logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to watch directory '%s': %v", *src_dir, err), PCAP_FSNERR, nil, err)
-			isActive.Store(false)
+	var ifaceResolver *iface.Resolver
+	if ifaceSpec != "" {
+		if spec, err := iface.ParseSpec(ifaceSpec); err == nil {
+			ifaceResolver = iface.NewResolver(spec, iface.NewSource(), iface.Options{ExcludeDown: *iface_down})
+			newResolveIfacesTask(ifaceResolver)(ctx)
+		} else {
+			logger.LogEvent(zapcore.WarnLevel, fmt.Sprintf("invalid interfaces specification '%s': %v", ifaceSpec, err), &telemetry.Ifaces{}, err)
 		}
 	}
 
-	ticker := time.NewTicker(watchdogInterval)
+	var wg sync.WaitGroup
+
+	// directories are only listed as watched once `watcher.Add` succeeds
+	watchedDirs := []string{}
+
+	// Watch the PCAP files source directory for FS events; it may be created or mounted slightly later.
+	if err = watch.AddWithRetries(ctx, watcher, *src_dir, *watch_retries, *watch_delay, func(attempt uint, err error) {
+		logger.LogEvent(zapcore.WarnLevel,
+			fmt.Sprintf("failed to watch directory '%s': retry %d/%d: %v", *src_dir, attempt, *watch_retries, err), &telemetry.FsnErr{}, err)
+	}); err != nil {
+		// PCAP files would never be exported
+		logger.LogEvent(zapcore.FatalLevel, fmt.Sprintf("failed to watch directory '%s': %v", *src_dir, err), &telemetry.FsnErr{}, err)
+		os.Exit(1)
+	}
+	watchedDirs = append(watchedDirs, *src_dir)
+
+	tasks = scheduler.NewScheduler(scheduler.NewRealClock(), func(task *scheduler.Task) {
+		logger.LogEvent(zapcore.WarnLevel,
+			fmt.Sprintf("skipped task '%s': previous execution is still running", task.Name),
+			&telemetry.Schedl{Task: task.Name, Interval: task.Interval.String()}, nil)
+	})
+	// packet capturing is write intensive
+	// OS buffers memory must be fluhsed often to prevent memory saturation
+	if err := tasks.Register(&scheduler.Task{
+		Name:     flushOSBuffersTask,
+		Interval: watchdogInterval,
+		Run:      newFlushOSBuffersTask(isGAE),
+	}); err != nil {
+		logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to register task '%s'", flushOSBuffersTask), &telemetry.Schedl{}, err)
+	}
+	if checkpoints != nil {
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "checkpoint",
+			Interval: *ckpt_interval,
+			Run:      deferrable(newCheckpointTask()),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'checkpoint'", &telemetry.Schedl{}, err)
+		}
+	}
+	if captureWindow != nil {
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "capture_window",
+			Interval: windowCheckInterval,
+			Run:      newCaptureWindowTask(windowEvents),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'capture_window'", &telemetry.Schedl{}, err)
+		}
+	}
+	if *bf_report > 0 {
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "report_backfill",
+			Interval: *bf_report,
+			Run:      newReportBackfillTask(),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'report_backfill'", &telemetry.Schedl{}, err)
+		}
+	}
+	if pcapMirror != nil {
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "enforce_mirror",
+			Interval: watchdogInterval,
+			Run:      newEnforceMirrorTask(),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'enforce_mirror'", &telemetry.Schedl{}, err)
+		}
+	}
+	if *gcs_export && *gcs_fuse && *gcs_dir_check > 0 {
+		// `gcsfuse` may be restarted: its mount point disappears or changes identity
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "watch_gcs_dir",
+			Interval: *gcs_dir_check,
+			Run:      newWatchGcsDirTask(mount.NewMonitor(*gcs_dir), flushChan),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'watch_gcs_dir'", &telemetry.Schedl{}, err)
+		}
+	}
+	if ifaceResolver != nil && *iface_refresh > 0 {
+		// network interfaces may be hot-plugged
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "resolve_ifaces",
+			Interval: *iface_refresh,
+			Run:      deferrable(newResolveIfacesTask(ifaceResolver)),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'resolve_ifaces'", &telemetry.Schedl{}, err)
+		}
+	}
+	if *psi_threshold > 0 {
+		if source, err := pressure.NewFileSource(); err == nil {
+			pressureMonitor = pressure.NewMonitor(source, *psi_threshold)
+			if err := tasks.Register(&scheduler.Task{
+				Name:     "watch_pressure",
+				Interval: *psi_check,
+				Run:      newWatchPressureTask(pressureMonitor),
+			}); err != nil {
+				logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'watch_pressure'", &telemetry.Schedl{}, err)
+			}
+		} else {
+			logger.LogEvent(zapcore.WarnLevel, "pressure-aware exports are disabled", &telemetry.Pressure{}, err)
+		}
+	}
+	if *slo_report > 0 {
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "report_slo",
+			Interval: *slo_report,
+			Run:      deferrable(newReportSLOTask()),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'report_slo'", &telemetry.Schedl{}, err)
+		}
+	}
+	if *queue_report > 0 {
+		// a buffer which stays near full means that PCAP files are detected faster than they are exported
+		eventsQueue = watch.NewQueueSampler(watcher, queueNearFull, queueSustainedSamples)
+		session.Go("sample_events", func(ctx context.Context) error {
+			ticker := time.NewTicker(queueSampleInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+					eventsQueue.Sample()
+				}
+			}
+		})
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "report_events",
+			Interval: *queue_report,
+			Run:      newReportEventsTask(),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'report_events'", &telemetry.Schedl{}, err)
+		}
+	}
+	if canaryProber != nil {
+		if err := tasks.Register(&scheduler.Task{
+			Name:     "canary",
+			Interval: *canary_every,
+			Run:      newCanaryTask(),
+		}); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, "failed to register task 'canary'", &telemetry.Schedl{}, err)
+		}
+		// the destination is characterized at startup, without waiting for the first interval
+		session.Go("canary", func(ctx context.Context) error {
+			newCanaryTask()(ctx)
+			// a failed characterization is reported by the task itself: it must not stop the session
+			return nil
+		})
+	}
+	tasks.Start(ctx)
 
 	// Start listening for FS events at PCAP files source directory.
-	go func(wg *sync.WaitGroup, watcher *fsnotify.Watcher, ticker *time.Ticker) {
-		for isActive.Load() {
+	session.Go("events", func(ctx context.Context) error {
+		var startGateTimeout <-chan time.Time
+		if startGate.State() == gate.STATE_WAITING {
+			startGateTimeout = time.After(readyTimeout)
+		}
+
+		// PCAP files written by producers which do not rotate them are exported once they are no longer written
+		var quiescence *watch.Quiescence
+		var quiescenceTicks <-chan time.Time
+		if exportTrigger == watch.TRIGGER_WRITE_CLOSE {
+			quiescence = watch.NewQuiescence(*export_quiet)
+			ticker := time.NewTicker(max(*export_quiet/4, 10*time.Millisecond))
+			defer ticker.Stop()
+			quiescenceTicks = ticker.C
+		}
+
+		for {
 			select {
 
-			case event, ok := <-watcher.Events:
+			case <-ctx.Done():
+				return nil
+
+			case <-startGateTimeout:
+				// older `tcpdumpw` versions do not signal readiness: count all PCAP files
+				if pcapFiles, expired := startGate.Expire(); expired {
+					logger.LogEvent(zapcore.WarnLevel,
+						fmt.Sprintf("capture engine never became ready: no readiness signal after %v", readyTimeout),
+						&telemetry.FsnIni{Timeout: readyTimeout.String(), Files: telemetry.Ptr(len(pcapFiles))}, nil)
+					for _, pcapFile := range pcapFiles {
+						wg.Add(1)
+						exportDetectedPcapFile(ctx, &wg, pcapDotExt, &pcapFile)
+					}
+				}
+
+			case now := <-quiescenceTicks:
+				exportQuiescentPcapFiles(ctx, &wg, pcapDotExt, quiescence, startGate, now)
+
+			case event, ok := <-watcher.Events():
 				if !ok { // Channel was closed (i.e. Watcher.Close() was called)
-					return
+					return nil
 				}
-				// Skip events which are not CREATE, and all which are not related to PCAP files
-				if event.Has(fsnotify.Create) && pcapDotExt.MatchString(event.Name) {
-					wg.Add(1)
-					exportPcapFile(ctx, wg, pcapDotExt, &event.Name, *gzip_pcaps /* compress */, true /* delete */, false /* flush */)
-				} else if event.Has(fsnotify.Create) && tcpdumpwExitSignal.MatchString(event.Name) && isActive.CompareAndSwap(true, false) {
+				received := time.Now()
+				traceEvent(event, pcapDotExt)
+				// Skip events which are not CREATE, and all which are not related to PCAP files;
+				// all events of PCAP files are tracked when they are exported once no longer written
+				isPcapFile := pcapDotExt.MatchString(event.Name)
+				if isPcapFile && quiescence != nil {
+					observeWrite(quiescence, event, received)
+				} else if event.Has(fsnotify.Create) && isPcapFile {
+					if startGate.Admit(event.Name) {
+						wg.Add(1)
+						exportDetectedPcapFile(ctx, &wg, pcapDotExt, &event.Name)
+					}
+				} else if event.Has(fsnotify.Create) && tcpdumpwReadySignal.MatchString(event.Name) {
+					tcpdumpwReadyTS := time.Now()
+					// PCAP files created before `tcpdumpw` readiness are not part of the capture session
+					pcapFiles := startGate.Ready(tcpdumpwReadyTS)
+					// scheduled captures start a new session on every execution: time between sessions is not a gap
+					gaps.Reset()
+					durabilitySLO.Reset()
+					logger.LogEvent(zapcore.InfoLevel,
+						"detected 'tcpdumpw' readiness signal",
+
+						&telemetry.Signal{
+							Signal:    event.Name,
+							Timestamp: tcpdumpwReadyTS.Format(time.RFC3339Nano),
+							Files:     telemetry.Ptr(len(pcapFiles)),
+						}, nil)
+					os.Remove(event.Name)
+					for _, pcapFile := range pcapFiles {
+						exportPreSessionPcapFile(ctx, pcapDotExt, &pcapFile, *gzip_pcaps /* compress */)
+					}
+				} else if event.Has(fsnotify.Create) && tcpdumpwExitSignal.MatchString(event.Name) {
 					// `tcpdumpw` signals its termination by creating the file `TCPDUMPW_EXITED` is the source directory
 					tcpdumpwExitTS := time.Now()
 					logger.LogEvent(zapcore.InfoLevel,
 						"detected 'tcpdumpw' termination signal",
-						PCAP_SIGNAL,
-						map[string]interface{}{
-							"event":     PCAP_SIGNAL,
-							"signal":    event.Name,
-							"timestamp": tcpdumpwExitTS.Format(time.RFC3339Nano),
+
+						&telemetry.Signal{
+							Signal:    event.Name,
+							Timestamp: tcpdumpwExitTS.Format(time.RFC3339Nano),
 						}, nil)
 					// delete `tcpdumpw` termination signal
 					os.Remove(event.Name)
 					// when `tcpdumpw` signal is detected:
-					//   - cancel the context which triggers final PCAP files flushing
-					cancel()
-					return
+					//   - stop the session which triggers final PCAP files flushing
+					session.Stop(errTcpdumpwExited)
+					return nil
 				}
+				if eventsQueue != nil {
+					eventsQueue.Handled(received, time.Now())
+				}
+
+			case <-flushChan:
+				flushPendingPcapFiles(ctx, &wg, pcapDotExt, *gzip_pcaps /* compress */)
 
-			case fsnErr, ok := <-watcher.Errors:
+			case event := <-windowEvents:
+				onCaptureWindowEvent(ctx, &wg, pcapDotExt, event, *gzip_pcaps /* compress */)
+
+			case fsnErr, ok := <-watcher.Errors():
 				if !ok { // Channel was closed (i.e. Watcher.Close() was called).
-					ticker.Stop()
-					return
+					tasks.Stop()
+					return nil
 				}
-				logger.LogEvent(zapcore.ErrorLevel, "FS watcher failed", PCAP_FSNERR, map[string]interface{}{"closed": ok}, fsnErr)
+				logger.LogEvent(zapcore.ErrorLevel, "FS watcher failed", &telemetry.FsnErr{Closed: telemetry.Ptr(ok)}, fsnErr)
 
 			}
 		}
-	}(&wg, watcher, ticker)
+	})
 
-	go func(watcher *fsnotify.Watcher, ticker *time.Ticker) {
-		for isActive.Load() {
+	session.Go("reload", func(ctx context.Context) error {
+		for {
 			select {
-
 			case <-ctx.Done():
-				return
-
-			case <-ticker.C:
-				// packet capturing is write intensive
-				// OS buffers memory must be fluhsed often to prevent memory saturation
-				// flushing OS file write buffers is safe: 'non-destructive operation and will not free any dirty objects'
-				// additionally, PCAP files are [write|append]-only
-				memoryBefore, _ := getCurrentMemoryUtilization(isGAE)
-				_, memFlushErr := flushBuffers()
-				memoryAfter, _ := getCurrentMemoryUtilization(isGAE)
-				if memFlushErr != nil {
+				return nil
+			case signal := <-reloadChan:
+				data := &telemetry.Signal{
+					Signal:    signal,
+					Timestamp: time.Now().Format(time.RFC3339Nano),
+				}
+				if err := reloadExtensions(pcapDotExt, configSource, strings.Split(*pcap_ext, ",")); err != nil {
+					logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to reload on %v: %v", signal, err), data, err)
 					continue
 				}
-				releasedMemory := int64(memoryBefore) - int64(memoryAfter)
+				data.Extra = map[string]any{"pcap_ext": pcapDotExt.String()}
 				logger.LogEvent(zapcore.InfoLevel,
-					fmt.Sprintf("flushed OS file write buffers: memory[before=%d|after=%d] / released=%d", memoryBefore, memoryAfter, releasedMemory),
-					PCAP_OSWMEM, map[string]interface{}{"before": memoryBefore, "after": memoryAfter, "released": releasedMemory}, nil)
-
+					fmt.Sprintf("reloaded PCAP files extensions on %v: %s", signal, strings.Join(pcapDotExt.Extensions(), ",")), data, nil)
 			}
 		}
-	}(watcher, ticker)
+	})
 
-	go func(watcher *fsnotify.Watcher, ticker *time.Ticker) {
-		signal := <-sigChan
+	session.Go("signals", func(ctx context.Context) error {
+		var signal os.Signal
+		select {
+		case <-ctx.Done():
+			return nil
+		case signal = <-sigChan:
+		}
 
 		signalTS := time.Now()
-		deadline := 3 * time.Second
+
+		if fileServer != nil {
+			// exported PCAP files are no longer served once shutdown starts
+			fileServer.Close()
+		}
 
 		logger.LogEvent(zapcore.InfoLevel,
 			fmt.Sprintf("signaled: %v", signal),
-			PCAP_SIGNAL,
-			map[string]interface{}{
-				"signal":    signal,
-				"timestamp": signalTS.Format(time.RFC3339Nano),
+
+			&telemetry.Signal{
+				Signal:    signal,
+				Timestamp: signalTS.Format(time.RFC3339Nano),
 			}, nil)
 
-		timer := time.AfterFunc(deadline-time.Since(signalTS), func() {
-			if isActive.CompareAndSwap(true, false) {
-				// cancel the context after 3s regardless of `tcpdumpw` termination signal:
-				//   - this is effectively the `max_wait_time` for `tcpdumpw` termination signal.
-				cancel()
+		if *active_flush && activeFlushStarted.CompareAndSwap(false, true) {
+			// in-progress PCAP files must be flushed while `tcpdump` is still running
+			session.Go("active_flush", func(context.Context) error {
+				activeFlushDone <- activeFlush(pcapDotExt)
+				return nil
+			})
+		}
+
+		if shortLived.Enabled && !rotationObserved.Load() {
+			// only the first PCAP files exist: the grace period is better spent exporting them than waiting for `tcpdumpw`
+			fastShutdown.Store(true)
+			if session.Stop(errShortLivedShutdown) {
+				logger.LogEvent(zapcore.InfoLevel, "skipped waiting for PCAP lock file",
+					&telemetry.FsLock{Lock: pcapLockFile, ShortLived: shortLived}, nil)
 			}
-		})
+			return nil
+		}
 
 		pcapMutex := flock.New(pcapLockFile)
-		lockData := map[string]interface{}{"lock": pcapLockFile}
-		logger.LogEvent(zapcore.InfoLevel, "waiting for PCAP lock file", PCAP_FSLOCK, lockData, nil)
-		lockCtx, lockCancel := context.WithTimeout(ctx, deadline-time.Since(signalTS))
+		lockData := &telemetry.FsLock{Lock: pcapLockFile}
+		logger.LogEvent(zapcore.InfoLevel, "waiting for PCAP lock file", lockData, nil)
+		lockCtx, lockCancel := context.WithTimeout(ctx, pcapLockDeadline-time.Since(signalTS))
 		defer lockCancel()
 		// `tcpdumpq` will unlock the PCAP lock file when all PCAP engines have stopped
 		if locked, lockErr := pcapMutex.TryLockContext(lockCtx, 10*time.Millisecond); !locked || lockErr != nil {
-			lockData["latency"] = time.Since(signalTS).String()
-			logger.LogEvent(zapcore.ErrorLevel, "failed to acquire PCAP lock file", PCAP_FSLOCK, lockData, lockErr)
-		} else if isActive.CompareAndSwap(true, false) {
-			timer.Stop()
-			lockData["latency"] = time.Since(signalTS).String()
-			cancel()
-			logger.LogEvent(zapcore.InfoLevel, "acquired PCAP lock file", PCAP_FSLOCK, lockData, nil)
+			lockData.Latency = time.Since(signalTS).String()
+			logger.LogEvent(zapcore.ErrorLevel, "failed to acquire PCAP lock file", lockData, lockErr)
+			// stop the session 3s after the signal regardless of `tcpdumpw` termination signal:
+			//   - this is effectively the `max_wait_time` for `tcpdumpw` termination signal.
+			<-lockCtx.Done()
+			session.Stop(errShutdownDeadline)
+		} else if session.Stop(errPcapLockAcquired) {
+			lockData.Latency = time.Since(signalTS).String()
+			logger.LogEvent(zapcore.InfoLevel, "acquired PCAP lock file", lockData, nil)
 		}
-	}(watcher, ticker)
+		return nil
+	})
 
 	if err == nil {
-		logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("watching directory: %s", *src_dir), PCAP_FSNINI, nil, nil)
-	} else if isActive.CompareAndSwap(true, false) {
-		logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("error at initialization: %v", err), PCAP_FSNINI, nil, err)
-		watcher.Close()
-		ticker.Stop()
-		cancel()
+		logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("watching directory: %s", *src_dir), &telemetry.FsnIni{WatchMode: string(watcher.Mode())}, nil)
+		// all directories are being watched, and the self-test ( if enabled ) passed: it would have exited otherwise
+		healthServer.SetStarted()
+		logger.LogEvent(zapcore.InfoLevel, "ready",
+			&telemetry.FsnIni{Ready: telemetry.Ptr(true), Watched: watchedDirs, WatchMode: string(watcher.Mode()), SelfTest: telemetry.Ptr(*selftest)}, nil)
+	} else if session.Stop(err) {
+		logger.LogEvent(zapcore.InfoLevel, fmt.Sprintf("error at initialization: %v", err), &telemetry.FsnIni{}, err)
 	}
 
-	<-ctx.Done() // wait for context to be cancelled
-
-	ticker.Stop()
-	watcher.Remove(*src_dir)
-	watcher.Close()
-
-	// wait for all regular export operations to terminate
-	wg.Wait()
-
-	ctx = context.Background()
-	ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	<-ctx.Done() // wait for the session to be stopped
+	sessionStop := time.Now()
+
+	logger.LogEvent(zapcore.InfoLevel, "session stopped",
+		&telemetry.FsnEnd{Cause: session.Cause().Error()}, nil)
+
+	var activeFlushResults []*activeflush.Result
+	var pendingPcapFiles uint32
+	var flushLatency time.Duration
+
+	// the order of the shutdown steps matters:
+	//   - scheduled tasks must not start new executions while the source directory is being flushed.
+	//   - closing the watcher unblocks the events loop, which may still be exporting a PCAP file.
+	//   - no goroutine owned by the session may outlive it: this includes the active flush.
+	//   - the final flush must not export in-progress PCAP files before `tcpdump` flushed its packet buffer.
+	shutdown := lifecycle.Shutdown(
+		lifecycle.Step{Name: "tasks", Run: func() error {
+			tasks.Stop()
+			logger.LogEvent(zapcore.InfoLevel, "stopped scheduled tasks", &telemetry.Schedl{Tasks: tasks.Status()}, nil)
+			return nil
+		}},
+		lifecycle.Step{Name: "watcher", Run: func() error {
+			watcher.Remove(*src_dir)
+			return watcher.Close()
+		}},
+		lifecycle.Step{Name: "goroutines", Run: session.Wait},
+		lifecycle.Step{Name: "exports", Run: func() error {
+			// wait for all regular export operations to terminate
+			wg.Wait()
+			return nil
+		}},
+		lifecycle.Step{Name: "active_flush", Run: func() error {
+			if activeFlushStarted.Load() {
+				activeFlushResults = <-activeFlushDone
+			}
+			return nil
+		}},
+		lifecycle.Step{Name: "final_flush", Run: func() error {
+			timeout := finalFlushTimeout
+			if fastShutdown.Load() {
+				// the time not spent waiting for `tcpdumpw` is spent exporting
+				timeout += pcapLockDeadline
+			}
+			flushCtx, flushCancel := context.WithTimeout(context.Background(), timeout)
+			defer flushCancel()
+
+			// without the PCAP lock file, `tcpdump` may still be writing into its current PCAP files
+			lockFailed := errors.Is(session.Cause(), errShutdownDeadline)
+			if lockFailed {
+				logger.LogEvent(zapcore.WarnLevel,
+					fmt.Sprintf("PCAP lock file not acquired: final flush mode is '%s'", lockFailure),
+					&telemetry.FsLock{Lock: pcapLockFile, Base: telemetry.Base{Extra: map[string]any{"acquired": false, "lock_failure": lockFailure}}}, nil)
+			}
 
-	flushStart := time.Now()
-	// flush remaining PCAP files after context is done
-	// compression & deletion are disabled when exiting in order to speed up the process
-	pendingPcapFiles := flushSrcDir(ctx, &wg, pcapDotExt,
-		true /* sync */, false /* compress */, false, /* delete */
-		func(_ fs.FileInfo) bool { return true },
+			flushStart := time.Now()
+			// flush remaining PCAP files after the session is stopped
+			// compression & deletion are disabled when exiting in order to speed up the process
+			pendingPcapFiles = flushSrcDir(flushCtx, &wg, pcapDotExt,
+				true /* sync */, false /* compress */, false, /* delete */
+				newFinalFlushValidator(pcapDotExt, lockFailed, lockFailure),
+			)
+
+			logger.LogEvent(zapcore.InfoLevel,
+				fmt.Sprintf("waiting for %d PCAP files to be flushed", pendingPcapFiles),
+
+				&telemetry.FsnEnd{
+					Files:     telemetry.Ptr(pendingPcapFiles),
+					Timestamp: flushStart.Format(time.RFC3339Nano),
+				}, nil)
+
+			wg.Wait() // wait for remaining PCAP failes to be flushed
+			flushLatency = time.Since(flushStart)
+			return flushCtx.Err()
+		}},
 	)
 
-	logger.LogEvent(zapcore.InfoLevel,
-		fmt.Sprintf("waiting for %d PCAP files to be flushed", pendingPcapFiles),
-		PCAP_FSNEND,
-		map[string]interface{}{
-			"files":     pendingPcapFiles,
-			"timestamp": flushStart.Format(time.RFC3339Nano),
-		}, nil)
-
-	wg.Wait() // wait for remaining PCAP failes to be flushed
-	flushLatency := time.Since(flushStart)
-
+	shutdownSummary := &telemetry.FsnEnd{
+		Files:    telemetry.Ptr(pendingPcapFiles),
+		Latency:  flushLatency.String(),
+		Shutdown: shutdown,
+		Gaps:     gaps.Missing(),
+		SLO:      durabilitySLO.Summary(),
+		Compression: &telemetry.Compression{
+			OrigBytes: origBytesTotal.Load(),
+			CompBytes: compBytesTotal.Load(),
+		},
+	}
+	if *active_flush {
+		shutdownSummary.ActiveFlush = activeFlushResults
+	}
+	if exportLatencies != nil {
+		shutdownSummary.ExportLatency = exportLatencies.Summary()
+	}
+	if shortLived.Enabled && *gcs_export {
+		shutdownSummary.ExportedBytes = telemetry.Ptr(exportedBytesTotal.Load())
+		if manifest, err := exportLostSessionManifest(pcapDotExt, sessionStart, session.Cause()); manifest != nil {
+			shutdownSummary.Lost = manifest
+			if err != nil {
+				// the destination is unavailable: logs are the only place left to explain the absence of data
+				logger.LogEvent(zapcore.ErrorLevel, "failed to export lost session manifest",
+					&telemetry.FsnEnd{Manifest: manifest}, err)
+			}
+		}
+	}
 	logger.LogEvent(zapcore.InfoLevel,
 		fmt.Sprintf("flushed %d PCAP files", pendingPcapFiles),
-		PCAP_FSNEND,
-		map[string]interface{}{
-			"files":   pendingPcapFiles,
-			"latency": flushLatency.String(),
-		}, nil)
+		shutdownSummary, nil)
+
+	if *report_file != "" {
+		// the session is stopped by signals and deadlines alike: the report is always written
+		report := newShutdownReport(session.Cause(), sessionStart, sessionStop, shutdown)
+		if err := report.WriteFile(*report_file); err != nil {
+			logger.LogEvent(zapcore.ErrorLevel, fmt.Sprintf("failed to write shutdown report: %s", *report_file), &telemetry.FsnEnd{}, err)
+		}
+	}
 }
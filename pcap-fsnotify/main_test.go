@@ -0,0 +1,1572 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cfg "github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/config"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/compression"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/gate"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/gcs"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/health"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/lifecycle"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/naming"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/rotation"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/sampling"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/scheduler"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/shortlived"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/slo"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/syncmap"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/watch"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-fsnotify/internal/window"
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// countingExporter records export attempts without exporting anything.
+type countingExporter struct {
+	attempts atomic.Int64
+}
+
+func (x *countingExporter) Export(
+	_ context.Context,
+	srcPcapFile *string,
+	_, _ bool,
+) (*string, *int64, error) {
+	x.attempts.Add(1)
+	tgtPcapFile, pcapBytes := *srcPcapFile, int64(0)
+	return &tgtPcapFile, &pcapBytes, nil
+}
+
+// TestExportDisabled verifies that disabling GCS export suppresses all export attempts,
+// both when PCAP files are rotated and when they are flushed, and that PCAP files are kept locally.
+func TestExportDisabled(t *testing.T) {
+	defer func(export bool, x gcs.Exporter) {
+		*gcs_export, exporter = export, x
+	}(*gcs_export, exporter)
+
+	srcDir := t.TempDir()
+	counting := &countingExporter{}
+	exporter = counting
+	*gcs_export = false
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFiles := []string{}
+	for _, ts := range []string{"20240101T000000", "20240101T000100", "20240101T000200"} {
+		pcapFile := filepath.Join(srcDir, "part__1_eth0__"+ts+".pcap")
+		if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pcapFiles = append(pcapFiles, pcapFile)
+	}
+
+	var wg sync.WaitGroup
+	for _, flush := range []bool{false, true} {
+		for _, pcapFile := range pcapFiles {
+			wg.Add(1)
+			if exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, true, flush) {
+				t.Errorf("exportPcapFile(%s, flush=%v) = true, want false", pcapFile, flush)
+			}
+		}
+	}
+	wg.Wait()
+
+	if attempts := counting.attempts.Load(); attempts != 0 {
+		t.Errorf("export attempts = %d, want 0", attempts)
+	}
+	for _, pcapFile := range pcapFiles {
+		if _, err := os.Stat(pcapFile); err != nil {
+			t.Errorf("PCAP file was not kept: %v", err)
+		}
+	}
+}
+
+// TestMovePcapToGcsSampling verifies that PCAP files of unsampled sessions are dropped instead of exported,
+// including in 'headers' mode when they cannot be truncated, and that sampled sessions export them normally.
+func TestMovePcapToGcsSampling(t *testing.T) {
+	defer func(x gcs.Exporter, s *sampling.Sampler) {
+		exporter, sessionSampler = x, s
+	}(exporter, sessionSampler)
+
+	tests := []struct {
+		name     string
+		decision sampling.Decision
+		wantErr  error
+		exported int64
+	}{
+		{"sampled", sampling.Decision{Sampled: true, Rate: 0.05, Mode: sampling.MODE_DROP}, nil, 1},
+		{"forced", sampling.Decision{Sampled: true, Forced: true, Rate: 0, Mode: sampling.MODE_DROP}, nil, 1},
+		{"drop", sampling.Decision{Rate: 0.05, Mode: sampling.MODE_DROP}, errSessionNotSampled, 0},
+		{"headers of invalid PCAP file", sampling.Decision{Rate: 0.05, Mode: sampling.MODE_HEADERS}, errSessionNotSampled, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			counting := &countingExporter{}
+			exporter = counting
+			sessionSampler = sampling.NewSampler(tc.decision)
+
+			srcPcapFile := filepath.Join(t.TempDir(), "part__1_eth0__20240101T000000.pcap")
+			if err := os.WriteFile(srcPcapFile, []byte("pcap"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err := movePcapToGcs(context.Background(), &srcPcapFile, false, true)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("movePcapToGcs() error = %v, want %v", err, tc.wantErr)
+			}
+			if attempts := counting.attempts.Load(); attempts != tc.exported {
+				t.Errorf("export attempts = %d, want %d", attempts, tc.exported)
+			}
+			if _, statErr := os.Stat(srcPcapFile); tc.wantErr != nil && !os.IsNotExist(statErr) {
+				t.Errorf("dropped PCAP file was not deleted: %v", statErr)
+			}
+		})
+	}
+}
+
+// TestExportFirst verifies that the only PCAP file of a short-lived capture is exported before the final flush
+// when `export_first` is enabled, and that the final flush does not export it again; otherwise only the final flush does.
+func TestExportFirst(t *testing.T) {
+	defer func(export, first bool, after time.Duration, x gcs.Exporter, tracker *slo.Tracker) {
+		*gcs_export, *export_first, *first_after, exporter, durabilitySLO = export, first, after, x, tracker
+	}(*gcs_export, *export_first, *first_after, exporter, durabilitySLO)
+
+	*gcs_export = true
+	*first_after = 10 * time.Millisecond
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(map[bool]string{false: "disabled", true: "enabled"}[enabled], func(t *testing.T) {
+			*export_first = enabled
+			counting := &countingExporter{}
+			exporter = counting
+			counters = syncmap.New[string, *atomic.Uint64]()
+			lastPcap = syncmap.New[string, string]()
+			gaps = rotation.NewGapDetector(time.Minute)
+
+			srcDir := t.TempDir()
+			pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+			pcapFile := filepath.Join(srcDir, "part__1_eth0__20240101T000000.pcap")
+			if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			exportPcapFile(ctx, &wg, pcapDotExt, &pcapFile, false, true, false /* flush */)
+
+			var want int64
+			if enabled {
+				want = 1
+				deadline := time.Now().Add(5 * time.Second)
+				for counting.attempts.Load() == 0 && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+			} else {
+				time.Sleep(10 * *first_after)
+			}
+			if attempts := counting.attempts.Load(); attempts != want {
+				t.Fatalf("export attempts before the final flush = %d, want %d", attempts, want)
+			}
+
+			// the final flush exports all PCAP files, after all regular exports terminate
+			cancel()
+			wg.Wait()
+			wg.Add(1)
+			exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, false, true /* flush */)
+
+			if attempts := counting.attempts.Load(); attempts != 1 {
+				t.Errorf("export attempts = %d, want 1", attempts)
+			}
+		})
+	}
+}
+
+// TestExportFirstChanged verifies that when packets are written into the first PCAP file after it was exported,
+// the final flush replaces the exported copy using GCS Fuse, so that no packets are lost.
+func TestExportFirstChanged(t *testing.T) {
+	defer func(export, first bool, after time.Duration, x gcs.Exporter, tracker *slo.Tracker) {
+		*gcs_export, *export_first, *first_after, exporter, durabilitySLO = export, first, after, x, tracker
+	}(*gcs_export, *export_first, *first_after, exporter, durabilitySLO)
+
+	*gcs_export = true
+	*export_first = true
+	*first_after = 10 * time.Millisecond
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	dstDir := t.TempDir()
+	exporter = gcs.NewFuseExporter(logger, dstDir, "", 0, 0, 0, nil)
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+
+	srcDir := t.TempDir()
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFile := filepath.Join(srcDir, "part__1_eth0__20240101T000000.pcap")
+	if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tgtPcapFile := filepath.Join(dstDir, filepath.Base(pcapFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	exportPcapFile(ctx, &wg, pcapDotExt, &pcapFile, false, true, false /* flush */)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := os.Stat(tgtPcapFile); err != nil; _, err = os.Stat(tgtPcapFile) {
+		if time.Now().After(deadline) {
+			t.Fatalf("first PCAP file was not exported: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	f, err := os.OpenFile(pcapFile, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("-sidecar"))
+	f.Close()
+
+	wg.Add(1)
+	if !exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, true, true /* flush */) {
+		t.Fatalf("final flush failed to export the first PCAP file again")
+	}
+	if data, _ := os.ReadFile(tgtPcapFile); string(data) != "pcap-sidecar" {
+		t.Errorf("exported PCAP file = %q, want %q", data, "pcap-sidecar")
+	}
+	if _, err := os.Stat(pcapFile); !os.IsNotExist(err) {
+		t.Errorf("first PCAP file was not deleted: %v", err)
+	}
+}
+
+// TestExportOnWriteClose verifies that a PCAP file which is created empty and then written several times
+// is exported exactly once, and only after it has not been written for `export_quiet`.
+func TestExportOnWriteClose(t *testing.T) {
+	defer func(export bool, trigger watch.Trigger, x gcs.Exporter, tracker *slo.Tracker) {
+		*gcs_export, exportTrigger, exporter, durabilitySLO = export, trigger, x, tracker
+	}(*gcs_export, exportTrigger, exporter, durabilitySLO)
+
+	*gcs_export = true
+	exportTrigger = watch.TRIGGER_WRITE_CLOSE
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	counting := &countingExporter{}
+	exporter = counting
+
+	srcDir := t.TempDir()
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFile := filepath.Join(srcDir, "part__1_eth0__20240101T000000.pcap")
+	if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	quiet := time.Second
+	quiescence := watch.NewQuiescence(quiet)
+	startGate := gate.NewStartGate(false)
+	start := time.Now()
+	observeWrite(quiescence, fsnotify.Event{Name: pcapFile, Op: fsnotify.Create}, start)
+	for i := 1; i <= 3; i++ {
+		observeWrite(quiescence, fsnotify.Event{Name: pcapFile, Op: fsnotify.Write}, start.Add(time.Duration(i)*quiet/2))
+	}
+
+	var wg sync.WaitGroup
+	for _, at := range []time.Duration{quiet, 2 * quiet, 3 * quiet, time.Hour} {
+		exportQuiescentPcapFiles(context.Background(), &wg, pcapDotExt, quiescence, startGate, start.Add(at))
+	}
+	wg.Wait()
+
+	if attempts := counting.attempts.Load(); attempts != 1 {
+		t.Errorf("export attempts = %d, want 1", attempts)
+	}
+}
+
+// TestEventIDs verifies that all log events about a PCAP file, from its creation being accepted to its export, share its event ID,
+// and that every PCAP file gets its own.
+func TestEventIDs(t *testing.T) {
+	defer func(export bool, trigger watch.Trigger, x gcs.Exporter, tracker *slo.Tracker, l *zap.Logger) {
+		*gcs_export, exportTrigger, exporter, durabilitySLO, logger.Logger = export, trigger, x, tracker, l
+	}(*gcs_export, exportTrigger, exporter, durabilitySLO, logger.Logger)
+
+	*gcs_export = true
+	exportTrigger = watch.TRIGGER_CREATE
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	exporter = &countingExporter{}
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger.Logger = zap.New(core)
+	logger.SetEventIDs(eventIDOf)
+
+	srcDir := t.TempDir()
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFiles := []string{}
+	for _, name := range []string{
+		"part__1_eth0__20240101T000000.pcap",
+		"part__1_eth0__20240101T000100.pcap",
+		"part__1_eth0__20240101T000200.pcap",
+	} {
+		pcapFile := filepath.Join(srcDir, name)
+		if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pcapFiles = append(pcapFiles, pcapFile)
+	}
+
+	var wg sync.WaitGroup
+	for _, pcapFile := range pcapFiles {
+		wg.Add(1)
+		exportDetectedPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile)
+	}
+	wg.Wait()
+
+	// event IDs of every PCAP file, along with how many log events are about it
+	ids := map[string]map[string]int{}
+	for _, entry := range logs.All() {
+		data, _ := entry.ContextMap()["data"].(map[string]any)
+		fs, ok := data["fs"].(map[string]any)
+		if !ok {
+			continue
+		}
+		path, _ := fs["source"].(string)
+		if path == "" {
+			path, _ = fs["target"].(string)
+		}
+		id, _ := data["event_id"].(string)
+		if ids[path] == nil {
+			ids[path] = map[string]int{}
+		}
+		ids[path][id] += 1
+	}
+
+	seen := map[string]string{}
+	for _, pcapFile := range pcapFiles {
+		if len(ids[pcapFile]) != 1 {
+			t.Errorf("%s: event IDs = %v, want a single one", filepath.Base(pcapFile), ids[pcapFile])
+			continue
+		}
+		for id := range ids[pcapFile] {
+			if id == "" {
+				t.Errorf("%s: log events have no event ID", filepath.Base(pcapFile))
+			} else if other, ok := seen[id]; ok {
+				t.Errorf("%s: event ID %s is shared with %s", filepath.Base(pcapFile), id, filepath.Base(other))
+			}
+			seen[id] = pcapFile
+		}
+	}
+	// detected, exporting, and exported
+	for _, pcapFile := range pcapFiles[:2] {
+		for id, events := range ids[pcapFile] {
+			if events < 3 {
+				t.Errorf("%s: %d log events with event ID %s, want at least 3", filepath.Base(pcapFile), events, id)
+			}
+		}
+		if id := eventIDOf(pcapFile); id != "" {
+			t.Errorf("%s: event ID %s is kept after being exported", filepath.Base(pcapFile), id)
+		}
+	}
+	if id := eventIDOf(pcapFiles[2]); id == "" {
+		t.Errorf("%s: event ID is not kept until being exported", filepath.Base(pcapFiles[2]))
+	}
+}
+
+// TestReloadExtensions verifies that once extensions are reloaded, PCAP files of the new extensions are exported,
+// while the PCAP files of the previous extensions which were already detected still drain.
+func TestReloadExtensions(t *testing.T) {
+	defer func(dir string, export, wasOrdered bool, trigger watch.Trigger, x gcs.Exporter, tracker *slo.Tracker) {
+		*src_dir, *gcs_export, *ordered, exportTrigger, exporter, durabilitySLO = dir, export, wasOrdered, trigger, x, tracker
+	}(*src_dir, *gcs_export, *ordered, exportTrigger, exporter, durabilitySLO)
+
+	srcDir := t.TempDir()
+	*src_dir = srcDir
+	*gcs_export = true
+	*ordered = false
+	exportTrigger = watch.TRIGGER_CREATE
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	recording := &recordingExporter{}
+	exporter = recording
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	create := func(names ...string) []string {
+		pcapFiles := []string{}
+		for _, name := range names {
+			pcapFile := filepath.Join(srcDir, name)
+			if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			pcapFiles = append(pcapFiles, pcapFile)
+		}
+		return pcapFiles
+	}
+	// events are only handled for PCAP files of the current extensions
+	detect := func(pcapFiles []string) {
+		var wg sync.WaitGroup
+		for _, pcapFile := range pcapFiles {
+			if pcapDotExt.MatchString(pcapFile) {
+				wg.Add(1)
+				exportDetectedPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile)
+			}
+		}
+		wg.Wait()
+	}
+	// the exporter deletes exported PCAP files
+	exported := func(want ...string) {
+		t.Helper()
+		for _, pcapFile := range want {
+			if _, ok := recording.exported.Load(pcapFile); !ok {
+				t.Errorf("%s was not exported", filepath.Base(pcapFile))
+			}
+			os.Remove(pcapFile)
+		}
+	}
+
+	before := create("part__1_eth0__20240101T000000.pcap", "part__1_eth0__20240101T000100.pcap")
+	detect(before)
+	exported(before[0])
+
+	pcapDotExt.Reload([]string{"pcapng"})
+	after := create("part__1_eth0__20240101T000200.pcapng", "part__1_eth0__20240101T000300.pcapng")
+	ignored := create("part__1_eth0__20240101T000200.pcap")
+	detect(append(after, ignored...))
+	exported(after[0])
+
+	// the final flush drains the current PCAP file of both extensions
+	all := func(string, fs.FileInfo) bool { return true }
+	var wg sync.WaitGroup
+	flushSrcDir(context.Background(), &wg, pcapDotExt, false /* sync */, false /* compress */, true /* delete */, all)
+	wg.Wait()
+	exported(before[1], after[1])
+
+	if duplicates := recording.duplicates.Load(); duplicates != 0 {
+		t.Errorf("%d PCAP files were exported more than once", duplicates)
+	}
+	if count, _ := counters.Get("1/eth0/pcap"); count.Load() != 2 {
+		t.Errorf("PCAP files of the previous extension detected: %d, want 2", count.Load())
+	}
+}
+
+// TestRotationOrdinalReset verifies that when rotation timestamps go back, every rotated PCAP file is still exported exactly once,
+// and that the PCAP file being written is never exported, even when its creation is detected twice or by a manual flush.
+func TestRotationOrdinalReset(t *testing.T) {
+	defer func(dir string, export bool, x gcs.Exporter, tracker *slo.Tracker) {
+		*src_dir, *gcs_export, exporter, durabilitySLO = dir, export, x, tracker
+	}(*src_dir, *gcs_export, exporter, durabilitySLO)
+
+	srcDir := t.TempDir()
+	*src_dir = srcDir
+	recording := &recordingExporter{}
+	exporter = recording
+	*gcs_export = true
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFiles := []string{}
+	for _, ts := range []string{
+		"20240101T010000",
+		"20240101T010100",
+		"20240101T010200",
+		// the clock of the capture is adjusted
+		"20240101T000000",
+		"20240101T000100",
+	} {
+		pcapFile := filepath.Join(srcDir, fmt.Sprintf("part__1_eth0__%s.pcap", ts))
+		if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pcapFiles = append(pcapFiles, pcapFile)
+	}
+	current := pcapFiles[len(pcapFiles)-1]
+
+	var wg sync.WaitGroup
+	// the creation of the current PCAP file is detected twice
+	for _, pcapFile := range append(pcapFiles, current) {
+		wg.Add(1)
+		exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, true, false /* flush */)
+	}
+	wg.Wait()
+
+	if n := recording.duplicates.Load(); n != 0 {
+		t.Errorf("%d PCAP files were exported more than once", n)
+	}
+	for _, pcapFile := range pcapFiles[:len(pcapFiles)-1] {
+		if _, ok := recording.exported.Load(pcapFile); !ok {
+			t.Errorf("PCAP file was skipped: %s", filepath.Base(pcapFile))
+		}
+	}
+	if _, ok := recording.exported.Load(current); ok {
+		t.Errorf("current PCAP file was exported: %s", filepath.Base(current))
+	}
+	if last, _ := lastPcap.Get("1/eth0/pcap"); last != current {
+		t.Errorf("last PCAP file = %s, want %s", filepath.Base(last), filepath.Base(current))
+	}
+	if count, _ := counters.Get("1/eth0/pcap"); count.Load() != uint64(len(pcapFiles)) {
+		t.Errorf("PCAP files counted: %d, want %d", count.Load(), len(pcapFiles))
+	}
+
+	// the exporter deletes exported PCAP files; a PCAP file whose creation was missed after the reset is left
+	for _, pcapFile := range pcapFiles[:len(pcapFiles)-1] {
+		os.Remove(pcapFile)
+	}
+	missed := filepath.Join(srcDir, "part__1_eth0__20240101T000030.pcap")
+	if err := os.WriteFile(missed, []byte("pcap"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if pending := pendingPcapFiles(pcapDotExt); len(pending) != 1 || pending[0].Path != missed {
+		t.Errorf("pending PCAP files = %v, want %s", pending, filepath.Base(missed))
+	}
+	flushPendingPcapFiles(context.Background(), &wg, pcapDotExt, false /* compress */)
+	wg.Wait()
+	if _, ok := recording.exported.Load(missed); !ok {
+		t.Errorf("manual flush skipped PCAP file: %s", filepath.Base(missed))
+	}
+	if _, ok := recording.exported.Load(current); ok {
+		t.Errorf("manual flush exported the current PCAP file: %s", filepath.Base(current))
+	}
+}
+
+// TestCaptureWindowStop verifies that when a capture window stops, only non-current PCAP files are exported,
+// and that PCAP files bookkeeping is kept so that the current PCAP file is exported when it is rotated.
+func TestCaptureWindowStop(t *testing.T) {
+	defer func(dir string, export bool, x gcs.Exporter, tracker *slo.Tracker, captures *window.Tracker, paused bool) {
+		*src_dir, *gcs_export, exporter, durabilitySLO, captureWindow = dir, export, x, tracker, captures
+		exportsPausedByWindow.Store(paused)
+	}(*src_dir, *gcs_export, exporter, durabilitySLO, captureWindow, exportsPausedByWindow.Load())
+
+	srcDir := t.TempDir()
+	*src_dir = srcDir
+	recording := &recordingExporter{}
+	exporter = recording
+	*gcs_export = true
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+
+	schedule, err := cfg.ParseCronExpression("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureWindow = window.NewTracker(schedule, time.Minute, time.UTC, time.Now())
+	exportsPausedByWindow.Store(false)
+
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFiles := []string{}
+	for _, ts := range []string{"20240101T010000", "20240101T010100", "20240101T010200"} {
+		pcapFile := filepath.Join(srcDir, fmt.Sprintf("part__1_eth0__%s.pcap", ts))
+		if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pcapFiles = append(pcapFiles, pcapFile)
+	}
+	current := pcapFiles[len(pcapFiles)-1]
+	lastPcap.Set("1/eth0/pcap", current)
+	count := &atomic.Uint64{}
+	count.Store(uint64(len(pcapFiles)))
+	counters.Set("1/eth0/pcap", count)
+
+	var wg sync.WaitGroup
+	onCaptureWindowEvent(context.Background(), &wg, pcapDotExt, window.EVENT_STOP, false /* compress */)
+	wg.Wait()
+
+	for _, pcapFile := range pcapFiles[:len(pcapFiles)-1] {
+		if _, ok := recording.exported.Load(pcapFile); !ok {
+			t.Errorf("PCAP file was not exported: %s", filepath.Base(pcapFile))
+		}
+	}
+	if _, ok := recording.exported.Load(current); ok {
+		t.Errorf("current PCAP file was exported: %s", filepath.Base(current))
+	}
+	if last, _ := lastPcap.Get("1/eth0/pcap"); last != current {
+		t.Errorf("last PCAP file = %s, want %s", filepath.Base(last), filepath.Base(current))
+	}
+	if count, ok := counters.Get("1/eth0/pcap"); !ok || count.Load() != uint64(len(pcapFiles)) {
+		t.Errorf("PCAP files counter was reset")
+	}
+	if !exportsPausedByWindow.Load() {
+		t.Errorf("exports were not paused")
+	}
+}
+
+// failingExporter fails every export, as an unavailable destination does.
+type failingExporter struct{}
+
+func (x *failingExporter) Export(
+	_ context.Context,
+	srcPcapFile *string,
+	_, _ bool,
+) (*string, *int64, error) {
+	tgtPcapFile := ""
+	return &tgtPcapFile, nil, errors.New("destination unavailable")
+}
+
+// TestFinalFlushOnLockFailure verifies which PCAP files the final flush exports in every 'lock_failure' mode
+// when the PCAP lock file is not acquired, and that PCAP files which are not flushed are reported as abandoned.
+func TestFinalFlushOnLockFailure(t *testing.T) {
+	defer func(dir string) {
+		*src_dir = dir
+	}(*src_dir)
+
+	srcDir := t.TempDir()
+	*src_dir = srcDir
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFiles := []string{}
+	for _, name := range []string{
+		"part__1_eth0__20240101T000000.pcap",
+		"part__1_eth0__20240101T000100.pcap",
+		"part__2_eth1__20240101T000000.pcap",
+	} {
+		pcapFile := filepath.Join(srcDir, name)
+		if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pcapFiles = append(pcapFiles, pcapFile)
+	}
+	lastPcap = syncmap.New[string, string]()
+	lastPcap.Set("1/eth0/pcap", pcapFiles[1])
+	lastPcap.Set("2/eth1/pcap", pcapFiles[2])
+
+	tests := []struct {
+		name       string
+		lockFailed bool
+		mode       lifecycle.LockFailure
+		want       []bool
+	}{
+		{"lock acquired", false, lifecycle.LOCK_FAILURE_ABORT, []bool{true, true, true}},
+		{"force", true, lifecycle.LOCK_FAILURE_FORCE, []bool{true, true, true}},
+		{"abort", true, lifecycle.LOCK_FAILURE_ABORT, []bool{false, false, false}},
+		{"skip-current", true, lifecycle.LOCK_FAILURE_SKIP_CURRENT, []bool{true, false, false}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			abandonedPcapFiles.Clear()
+			validator := newFinalFlushValidator(pcapDotExt, tc.lockFailed, tc.mode)
+			for i, pcapFile := range pcapFiles {
+				if got := validator(pcapFile, nil); got != tc.want[i] {
+					t.Errorf("flushed %s: %v, want %v", pcapFile, got, tc.want[i])
+				}
+				if _, abandoned := abandonedPcapFiles.Get(pcapFile); abandoned == tc.want[i] {
+					t.Errorf("abandoned %s: %v, want %v", pcapFile, abandoned, !tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+// sizingExporter exports every PCAP file as its own size, without exporting anything.
+type sizingExporter struct{}
+
+func (x *sizingExporter) Export(
+	_ context.Context,
+	srcPcapFile *string,
+	_, _ bool,
+) (*string, *int64, error) {
+	info, err := os.Stat(*srcPcapFile)
+	if err != nil {
+		return srcPcapFile, nil, err
+	}
+	tgtPcapFile, pcapBytes := *srcPcapFile, info.Size()
+	return &tgtPcapFile, &pcapBytes, nil
+}
+
+// TestShutdownReport simulates a session in which rotated PCAP files are exported, and the final flush fails
+// because the destination became unavailable: the report must account for every PCAP file.
+func TestShutdownReport(t *testing.T) {
+	defer func(export bool, x gcs.Exporter, tracker *slo.Tracker) {
+		*gcs_export, exporter, durabilitySLO = export, x, tracker
+	}(*gcs_export, exporter, durabilitySLO)
+
+	*gcs_export = true
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+	exportedFilesTotal.Store(0)
+	failedExportsTotal.Store(0)
+	exportedBytesTotal.Store(0)
+	abandonedPcapFiles.Clear()
+
+	srcDir := t.TempDir()
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFiles := []string{}
+	for _, name := range []string{
+		"part__1_eth0__20240101T000000.pcap",
+		"part__1_eth0__20240101T000100.pcap",
+		"part__1_eth0__20240101T000200.pcap",
+		"part__2_eth1__20240101T000000.pcap",
+	} {
+		pcapFile := filepath.Join(srcDir, name)
+		if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pcapFiles = append(pcapFiles, pcapFile)
+	}
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	// rotations export the 1st and 2nd PCAP files of 'eth0'
+	exporter = &sizingExporter{}
+	for _, pcapFile := range pcapFiles {
+		wg.Add(1)
+		exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, true, false /* flush */)
+	}
+	wg.Wait()
+
+	// the final flush fails for the current PCAP file of every interface
+	stopped := time.Now()
+	exporter = &failingExporter{}
+	for _, pcapFile := range []string{pcapFiles[2], pcapFiles[3]} {
+		wg.Add(1)
+		exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, false, true /* flush */)
+	}
+	wg.Wait()
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := newShutdownReport(errShutdownDeadline, started, stopped, nil).WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		lifecycle.Report
+		Clean bool `json:"clean"`
+	}
+	if err := json.Unmarshal(content, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Cause != errShutdownDeadline.Error() {
+		t.Errorf("cause = %q, want %q", report.Cause, errShutdownDeadline)
+	}
+	if report.Exported != 2 || report.Bytes != 8 || report.Failures != 2 {
+		t.Errorf("exported %d PCAP files (%d bytes) and failed %d times, want 2 (8 bytes) and 2", report.Exported, report.Bytes, report.Failures)
+	}
+	if len(report.Abandoned) != 2 || report.Abandoned[0].Path != pcapFiles[2] || report.Abandoned[1].Path != pcapFiles[3] {
+		t.Errorf("abandoned = %+v, want %s and %s", report.Abandoned, pcapFiles[2], pcapFiles[3])
+	}
+	if report.Keys["1/eth0/pcap"] != 3 || report.Keys["2/eth1/pcap"] != 1 {
+		t.Errorf("keys = %v, want 3 PCAP files of eth0 and 1 of eth1", report.Keys)
+	}
+	if report.Clean {
+		t.Error("report is clean, but PCAP files were abandoned")
+	}
+}
+
+// TestShortLivedInstance simulates a Cloud Run instance which lives 60 seconds with PCAP files rotated every 5 minutes;
+// time is scaled down 1000 times. Its only PCAP file must land in the destination before the instance stops,
+// and a manifest of the captured PCAP files must be produced when the destination is unavailable.
+func TestShortLivedInstance(t *testing.T) {
+	defer func(export, first bool, after time.Duration, dir string, x gcs.Exporter, tracker *slo.Tracker, decision shortlived.Decision) {
+		*gcs_export, *export_first, *first_after, *src_dir, exporter, durabilitySLO, shortLived = export, first, after, dir, x, tracker, decision
+	}(*gcs_export, *export_first, *first_after, *src_dir, exporter, durabilitySLO, shortLived)
+
+	const scale = 1000
+	lifetime := 60 * time.Second / scale
+
+	shortLived = shortlived.Decide(shortlived.MODE_AUTO, "cloud_run_gen2", 5*time.Minute, 90*time.Second)
+	if !shortLived.Enabled {
+		t.Fatalf("short-lived optimizations are disabled: %+v", shortLived)
+	}
+	*gcs_export = true
+	*export_first = true
+	*first_after = 10 * time.Second / scale
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+
+	for _, available := range []bool{true, false} {
+		t.Run(map[bool]string{true: "available", false: "unavailable"}[available], func(t *testing.T) {
+			counters = syncmap.New[string, *atomic.Uint64]()
+			lastPcap = syncmap.New[string, string]()
+			gaps = rotation.NewGapDetector(5 * time.Minute)
+			rotationObserved.Store(false)
+			exportedBytesTotal.Store(0)
+
+			srcDir, dstDir := t.TempDir(), t.TempDir()
+			*src_dir = srcDir
+			if available {
+				exporter = gcs.NewFuseExporter(logger, dstDir, "", 0, 0, 0, nil)
+			} else {
+				exporter = &failingExporter{}
+			}
+
+			pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+			pcapFile := filepath.Join(srcDir, "part__1_eth0__20240101T000000.pcap")
+			if err := os.WriteFile(pcapFile, []byte("pcap"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			started := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), lifetime)
+			defer cancel()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			exportPcapFile(ctx, &wg, pcapDotExt, &pcapFile, false, true, false /* flush */)
+
+			// the instance stops before the first rotation
+			<-ctx.Done()
+			wg.Wait()
+			if rotationObserved.Load() {
+				t.Fatal("no PCAP file was rotated")
+			}
+			if _, err := os.Stat(filepath.Join(dstDir, filepath.Base(pcapFile))); available && err != nil {
+				t.Errorf("PCAP file was not exported before the instance stopped: %v", err)
+			}
+
+			manifest, err := exportLostSessionManifest(pcapDotExt, started, errShortLivedShutdown)
+			if available {
+				if manifest != nil {
+					t.Errorf("exportLostSessionManifest() = %+v, want nil", manifest)
+				}
+				return
+			}
+			if err == nil || manifest == nil {
+				t.Fatalf("exportLostSessionManifest() = %+v, %v", manifest, err)
+			}
+			if len(manifest.Captured) != 1 || manifest.CapturedBytes != 4 || manifest.ExportedBytes != 0 || manifest.Cause != errShortLivedShutdown.Error() {
+				t.Errorf("manifest = %+v", manifest)
+			}
+		})
+	}
+}
+
+// TestFlushEveryN verifies that every `flush_every_n` exported PCAP files trigger exactly one extra flush of OS buffers.
+func TestFlushEveryN(t *testing.T) {
+	defer func(n uint, x gcs.Exporter, s *scheduler.Scheduler) {
+		*flush_every_n, exporter, tasks = n, x, s
+	}(*flush_every_n, exporter, tasks)
+
+	*flush_every_n = 3
+	exporter = &countingExporter{}
+	exportsSinceStart.Store(0)
+
+	flushes := make(chan string, 10)
+	tasks = scheduler.NewScheduler(scheduler.NewRealClock(), nil)
+	if err := tasks.Register(&scheduler.Task{
+		Name:     flushOSBuffersTask,
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			flushes <- scheduler.TriggerOf(ctx)
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tasks.Start(context.Background())
+	defer tasks.Stop()
+
+	srcDir := t.TempDir()
+	export := func(n int) {
+		for i := 0; i < n; i++ {
+			srcPcapFile := filepath.Join(srcDir, "part__1_eth0__20240101T000000.pcap")
+			if err := os.WriteFile(srcPcapFile, []byte("pcap"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := movePcapToGcs(context.Background(), &srcPcapFile, false, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	export(int(*flush_every_n))
+	select {
+	case trigger := <-flushes:
+		if trigger != flushTriggerExports {
+			t.Errorf("trigger = %s, want %s", trigger, flushTriggerExports)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%d exports did not trigger a flush", *flush_every_n)
+	}
+
+	export(int(*flush_every_n) - 1)
+	select {
+	case trigger := <-flushes:
+		t.Errorf("unexpected flush: %s", trigger)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestPendingPcapFiles verifies that manual flushes select every PCAP file rotated before the current one of its key,
+// where the current PCAP file is the last one detected, or the newest one when none was detected yet.
+func TestPendingPcapFiles(t *testing.T) {
+	defer func(dir string) { *src_dir = dir }(*src_dir)
+
+	srcDir := t.TempDir()
+	*src_dir = srcDir
+	lastPcap = syncmap.New[string, string]()
+
+	pcapFile := func(name string) string {
+		path := filepath.Join(srcDir, name)
+		if err := os.WriteFile(path, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	// the creation of the newest PCAP file of `eth0` was not processed yet
+	eth0 := []string{
+		pcapFile("part__1_eth0__20240101T000000.pcap"),
+		pcapFile("part__1_eth0__20240101T000100.pcap"),
+		pcapFile("part__1_eth0__20240101T000200.pcap"),
+	}
+	lastPcap.Set("1/eth0/pcap", eth0[1])
+	// no PCAP file of `eth1` was detected yet
+	eth1 := []string{
+		pcapFile("part__2_eth1__20240101T000000.pcap"),
+		pcapFile("part__2_eth1__20240101T000100.pcap"),
+	}
+	pcapFile("notes.txt")
+
+	want := []string{eth0[0], eth1[0]}
+	var got []string
+	for _, pending := range pendingPcapFiles(naming.NewMatcher(srcDir, []string{"pcap"})) {
+		got = append(got, pending.Path)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("pending PCAP files = %v, want %v", got, want)
+	}
+}
+
+// TestVerifyWriteAccess verifies that probing a directory leaves nothing behind, and that directories which cannot be written fail.
+func TestVerifyWriteAccess(t *testing.T) {
+	dir := t.TempDir()
+	if err := verifyWriteAccess(dir); err != nil {
+		t.Fatalf("writable directory: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("probe files were left: %v", entries)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(dir, "missing"), file} {
+		if err := verifyWriteAccess(dir); err == nil {
+			t.Errorf("%s: no error, want one", filepath.Base(dir))
+		}
+	}
+}
+
+// TestWaitForDestination verifies that waiting succeeds once the destination directory appears, and fails after the timeout.
+func TestWaitForDestination(t *testing.T) {
+	defer func(dir string) { *gcs_dir = dir }(*gcs_dir)
+
+	*gcs_dir = filepath.Join(t.TempDir(), "gcs")
+	time.AfterFunc(150*time.Millisecond, func() { os.Mkdir(*gcs_dir, 0o755) })
+	if err := waitForDestination(5 * time.Second); err != nil {
+		t.Errorf("destination directory created while waiting: %v", err)
+	}
+
+	*gcs_dir = filepath.Join(t.TempDir(), "missing")
+	start := time.Now()
+	if err := waitForDestination(200 * time.Millisecond); err == nil {
+		t.Error("missing destination directory: no error, want one")
+	} else if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("gave up after %v, want at least %v", elapsed, 200*time.Millisecond)
+	}
+}
+
+// TestReportCompression verifies that the compression ratio is reported as original over compressed bytes,
+// that empty outputs are reported as a ratio of 0, and that sizes are accumulated for the shutdown report.
+func TestReportCompression(t *testing.T) {
+	defer func(l *zap.Logger, orig, comp int64) {
+		logger.Logger = l
+		origBytesTotal.Store(orig)
+		compBytesTotal.Store(comp)
+	}(logger.Logger, origBytesTotal.Load(), compBytesTotal.Load())
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger.Logger = zap.New(core)
+	origBytesTotal.Store(0)
+	compBytesTotal.Store(0)
+
+	tests := []struct {
+		name      string
+		origBytes int64
+		compBytes int64
+		want      float64
+	}{
+		{"compressed", 1000, 250, 4},
+		{"incompressible", 1000, 1000, 1},
+		{"empty", 0, 0, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.TakeAll()
+			reportCompression("a.pcap", "a.pcap.gz", tc.origBytes, tc.compBytes, &compression.Decision{})
+			entries := logs.TakeAll()
+			if len(entries) != 1 {
+				t.Fatalf("got %d log events, want 1", len(entries))
+			}
+			data, _ := entries[0].ContextMap()["data"].(map[string]any)
+			ratio, _ := data["ratio"].(json.Number)
+			if got, err := ratio.Float64(); err != nil || got != tc.want {
+				t.Errorf("ratio = %v, want %v", data["ratio"], tc.want)
+			}
+			if _, ok := data["decision"]; ok {
+				t.Errorf("decision = %v, want none without an interface", data["decision"])
+			}
+		})
+	}
+
+	if orig, comp := origBytesTotal.Load(), compBytesTotal.Load(); orig != 2000 || comp != 1250 {
+		t.Errorf("totals = %d => %d bytes, want 2000 => 1250 bytes", orig, comp)
+	}
+}
+
+// TestCheckConfig verifies that `-check_config` fails on invalid flags and config files, and succeeds otherwise;
+// the effective configuration is logged either way.
+func TestCheckConfig(t *testing.T) {
+	defer func(src, gcs, config string, l *zap.Logger) {
+		*src_dir, *gcs_dir, *config_file, logger.Logger = src, gcs, config, l
+	}(*src_dir, *gcs_dir, *config_file, logger.Logger)
+
+	invalidConfig := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(invalidConfig, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		srcDir      string
+		configFile  string
+		wantErr     bool
+		wantMessage string
+	}{
+		{"valid", t.TempDir(), "", false, "valid configuration"},
+		{"missing src_dir", filepath.Join(t.TempDir(), "missing"), "", true, "invalid configuration"},
+		{"invalid config file", t.TempDir(), invalidConfig, true, "invalid configuration"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			logger.Logger = zap.New(core)
+			*src_dir, *gcs_dir, *config_file = tc.srcDir, t.TempDir(), tc.configFile
+
+			if err := checkConfig(); (err != nil) != tc.wantErr {
+				t.Fatalf("checkConfig() = %v, want error: %v", err, tc.wantErr)
+			}
+			entries := logs.FilterMessage(tc.wantMessage).All()
+			if len(entries) != 1 {
+				t.Fatalf("got %d %q log events, want 1", len(entries), tc.wantMessage)
+			}
+			data, _ := entries[0].ContextMap()["data"].(map[string]any)
+			if flags, _ := data["flags"].(map[string]any); flags["src_dir"] != tc.srcDir {
+				t.Errorf("flags = %v, want the effective src_dir %s", data["flags"], tc.srcDir)
+			}
+		})
+	}
+}
+
+// TestCheckFilterDrift verifies that a BPF filter which stops compiling after the config file changes degrades the exporter.
+func TestCheckFilterDrift(t *testing.T) {
+	defer func(s *health.Server, f *string) {
+		healthServer, configFilter = s, f
+	}(healthServer, configFilter)
+
+	healthServer = health.NewServer()
+	configFilter = nil
+
+	steps := []struct {
+		name         string
+		config       string
+		wantCompiles bool
+	}{
+		{"disabled", `{"pcap":{"filter":{"bpf":"DISABLED"}}}`, true},
+		{"valid", `{"pcap":{"filter":{"bpf":"tcp port 443"}}}`, true},
+		{"drifted", `{"pcap":{"filter":{"bpf":"tcp port"}}}`, false},
+		{"unchanged", `{"pcap":{"filter":{"bpf":"tcp port"}}}`, false},
+		{"fixed", `{"pcap":{"filter":{"bpf":"tcp port 80"}}}`, true},
+	}
+
+	for _, step := range steps {
+		checkFilterDrift([]byte(step.config))
+
+		status := healthServer.Status()
+		info, ok := status.Info["filter"].(map[string]any)
+		if !ok {
+			t.Fatalf("%s: filter info is missing: %v", step.name, status.Info)
+		}
+		if info["compiles"] != step.wantCompiles {
+			t.Errorf("%s: compiles = %v, want %v", step.name, info["compiles"], step.wantCompiles)
+		}
+		if _, degraded := status.DegradedReasons["filter"]; degraded == step.wantCompiles {
+			t.Errorf("%s: degraded = %v, want %v", step.name, degraded, !step.wantCompiles)
+		}
+	}
+}
+
+// TestNewStagingDir verifies how the staging directory from the config file is mapped into the GCS mount point.
+func TestNewStagingDir(t *testing.T) {
+	tests := []struct {
+		name       string
+		gcsDir     string
+		cfgDir     string
+		cfgTempDir string
+		want       string
+	}{
+		{"disabled", "/pcap/project/run/service", "project/run/service", "", ""},
+		{"absolute", "/pcap/project/run/service", "project/run/service", "/staging", "/staging"},
+		{"relative", "/gcs/project/run/service", "project/run/service", ".staging/service", "/gcs/.staging/service"},
+		{"mismatch", "/gcs/project/run/service", "other", ".staging", "/gcs/.staging"},
+		{"no config dir", "/gcs/project/run/service", "", ".staging", "/gcs/.staging"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := newStagingDir(tc.gcsDir, tc.cfgDir, tc.cfgTempDir); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestNewStatusAddr verifies that health checks are served next to the config file healthcheck port by default.
+func TestNewStatusAddr(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusAddr string
+		hcPort     uint16
+		want       string
+	}{
+		{"flag", ":8080", 12345, ":8080"},
+		{"config", "", 12345, ":12346"},
+		{"disabled", "none", 12345, ""},
+		{"no config", "", 0, ""},
+		{"overflow", "", 65535, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := newStatusAddr(tc.statusAddr, tc.hcPort); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestNewGzipEnabled verifies that the config file drives compression unless the `gzip` flag is set explicitly.
+func TestNewGzipEnabled(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name       string
+		args       []string
+		cfgGzip    *bool
+		want       bool
+		wantSource string
+	}{
+		{"config enables", nil, &enabled, true, "config"},
+		{"config disables", nil, &disabled, false, "config"},
+		{"flag overrides config", []string{"-gzip=false"}, &enabled, false, "flag"},
+		{"flag without config", []string{"-gzip"}, nil, true, "flag"},
+		{"default", nil, nil, false, "default"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags.Bool("gzip", false, "compress pcap files")
+			if err := flags.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			got, source := newGzipEnabled(flags, tc.cfgGzip)
+			if got != tc.want || source != tc.wantSource {
+				t.Errorf("got %v from %s, want %v from %s", got, source, tc.want, tc.wantSource)
+			}
+		})
+	}
+}
+
+// TestTraceEvent verifies that events of every op are traced when `trace_events` is enabled,
+// not only CREATE events of PCAP files, and that nothing is traced when it is disabled.
+func TestTraceEvent(t *testing.T) {
+	defer func(trace bool) {
+		*trace_events = trace
+	}(*trace_events)
+
+	srcDir := t.TempDir()
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	pcapFile := filepath.Join(srcDir, "part__1_eth0__20240101T000000.pcap")
+	otherFile := filepath.Join(srcDir, "TCPDUMPW_READY")
+
+	tests := []struct {
+		event       fsnotify.Event
+		wantOp      string
+		wantMatched bool
+	}{
+		{fsnotify.Event{Name: pcapFile, Op: fsnotify.Create}, "CREATE", true},
+		{fsnotify.Event{Name: pcapFile, Op: fsnotify.Write}, "WRITE", true},
+		{fsnotify.Event{Name: pcapFile, Op: fsnotify.Remove}, "REMOVE", true},
+		{fsnotify.Event{Name: pcapFile, Op: fsnotify.Rename}, "RENAME", true},
+		{fsnotify.Event{Name: pcapFile, Op: fsnotify.Chmod}, "CHMOD", true},
+		{fsnotify.Event{Name: otherFile, Op: fsnotify.Create}, "CREATE", false},
+	}
+
+	*trace_events = false
+	if trace := traceEvent(tests[0].event, pcapDotExt); trace != nil {
+		t.Errorf("traced %+v while disabled", trace)
+	}
+
+	*trace_events = true
+	for _, tc := range tests {
+		t.Run(tc.event.String(), func(t *testing.T) {
+			trace := traceEvent(tc.event, pcapDotExt)
+			if trace == nil {
+				t.Fatal("event was not traced")
+			}
+			if trace.Op != tc.wantOp || *trace.Matched != tc.wantMatched {
+				t.Errorf("traced %s (matched: %v), want %s (matched: %v)", trace.Op, *trace.Matched, tc.wantOp, tc.wantMatched)
+			}
+			if trace.Fs == nil || trace.Fs.Source != tc.event.Name {
+				t.Errorf("traced fs %+v, want source %s", trace.Fs, tc.event.Name)
+			}
+		})
+	}
+}
+
+// TestNewConfigDirs verifies that directories are derived from the config file unless their flags are set explicitly,
+// and that derived directories which do not exist are rejected.
+func TestNewConfigDirs(t *testing.T) {
+	root := t.TempDir()
+	srcDir := filepath.Join(root, "pcap-tmp")
+	mount := filepath.Join(root, "pcap")
+	for _, dir := range []string{srcDir, filepath.Join(mount, "project/run")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		sidecar   *sidecarConfig
+		checkDest bool
+		wantSrc   string
+		wantDest  string
+		wantErr   bool
+	}{
+		{"derived", nil, &sidecarConfig{directory: srcDir, gcsMount: mount, gcsDir: "project/run"}, true, srcDir, filepath.Join(mount, "project/run"), false},
+		{"flags win", []string{"-src_dir=/src", "-gcs_dir=/dest"}, &sidecarConfig{directory: srcDir, gcsMount: mount, gcsDir: "project/run"}, true, "/src", "/dest", false},
+		{"not set", nil, &sidecarConfig{}, true, "/pcap-tmp", "/pcap", false},
+		{"missing source", nil, &sidecarConfig{directory: filepath.Join(root, "missing")}, true, filepath.Join(root, "missing"), "/pcap", true},
+		{"missing destination", nil, &sidecarConfig{gcsMount: mount, gcsDir: "other"}, true, "/pcap-tmp", filepath.Join(mount, "other"), true},
+		{"destination not required", nil, &sidecarConfig{gcsMount: mount, gcsDir: "other"}, false, "/pcap-tmp", filepath.Join(mount, "other"), false},
+		{"same directory", nil, &sidecarConfig{directory: srcDir, gcsMount: srcDir}, true, srcDir, srcDir, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags.String("src_dir", "/pcap-tmp", "pcaps source directory")
+			flags.String("gcs_dir", "/pcap", "pcaps destination directory")
+			if err := flags.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			src, dest, err := newConfigDirs(flags, tc.sidecar, tc.checkDest)
+			if src != tc.wantSrc || dest != tc.wantDest || (err != nil) != tc.wantErr {
+				t.Errorf("got %q, %q, %v, want %q, %q, error: %v", src, dest, err, tc.wantSrc, tc.wantDest, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestCheckDistinctDirs verifies that exporting PCAP files into their source directory is rejected,
+// including when the destination directory leads to it through a symbolic link.
+func TestCheckDistinctDirs(t *testing.T) {
+	root := t.TempDir()
+	srcDir := filepath.Join(root, "pcap-tmp")
+	gcsDir := filepath.Join(root, "pcap")
+	for _, dir := range []string{srcDir, gcsDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(srcDir, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		dest    string
+		wantErr bool
+	}{
+		{"distinct", srcDir, gcsDir, false},
+		{"identical", srcDir, srcDir, true},
+		{"trailing separator", srcDir, srcDir + "/", true},
+		{"symbolic link", srcDir, link, true},
+		{"missing destination", srcDir, filepath.Join(root, "missing"), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := checkDistinctDirs(tc.src, tc.dest); (err != nil) != tc.wantErr {
+				t.Errorf("checkDistinctDirs(%q, %q) = %v, want error: %v", tc.src, tc.dest, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestNewExtensionsWarning verifies that captured extensions which are not watched are reported.
+func TestNewExtensionsWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		captured []string
+		watched  []string
+		want     string
+	}{
+		{"consistent", []string{"pcap"}, []string{"pcap"}, ""},
+		{"pcapng captured, pcap watched", []string{"pcapng"}, []string{"pcap"}, "pcap_ext: does not include the extensions of captured PCAP files: pcapng"},
+		{"partially watched", []string{"pcap", "json", "pcapng"}, []string{"pcap"}, "pcap_ext: does not include the extensions of captured PCAP files: json,pcapng"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ""
+			if err := newExtensionsWarning(tc.captured, tc.watched); err != nil {
+				got = err.Error()
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestMinAgeBeforeExport verifies that PCAP files younger than `min_age_before_export` are deferred
+// until they are old enough, while older PCAP files are exported right away.
+func TestMinAgeBeforeExport(t *testing.T) {
+	defer func(export bool, minAge time.Duration, x gcs.Exporter, g *lifecycle.Group, tracker *slo.Tracker) {
+		*gcs_export, *min_age, exporter, session, durabilitySLO = export, minAge, x, g, tracker
+	}(*gcs_export, *min_age, exporter, session, durabilitySLO)
+
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	*gcs_export = true
+	*min_age = 200 * time.Millisecond
+	exporter = gcs.NewFuseExporter(logger, dstDir, "", 0, 0, 0, nil)
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	session = lifecycle.NewGroup(context.Background())
+	defer session.Stop(nil)
+
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	newPcapFile := func(ts string, modified time.Time) *naming.PcapFile {
+		path := filepath.Join(srcDir, "part__1_eth0__"+ts+".pcap")
+		if err := os.WriteFile(path, []byte("pcap"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		pcapFile, err := pcapDotExt.Parse(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pcapFile
+	}
+	exported := func(pcapFile *naming.PcapFile) bool {
+		_, err := os.Stat(filepath.Join(dstDir, filepath.Base(pcapFile.Path)))
+		return err == nil
+	}
+
+	oldPcapFile := newPcapFile("20240101T000000", time.Now().Add(-time.Minute))
+	if !flushPcapFile(context.Background(), oldPcapFile, false, true) || !exported(oldPcapFile) {
+		t.Errorf("old PCAP file was not exported: %s", oldPcapFile.Path)
+	}
+
+	youngPcapFile := newPcapFile("20240101T000100", time.Now())
+	if flushPcapFile(context.Background(), youngPcapFile, false, true) {
+		t.Errorf("young PCAP file was not deferred: %s", youngPcapFile.Path)
+	}
+	if exported(youngPcapFile) {
+		t.Fatalf("young PCAP file was exported before it was old enough: %s", youngPcapFile.Path)
+	}
+	if _, err := os.Stat(youngPcapFile.Path); err != nil {
+		t.Fatalf("young PCAP file was not kept: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !exported(youngPcapFile) {
+		if time.Now().After(deadline) {
+			t.Fatalf("deferred PCAP file was never exported: %s", youngPcapFile.Path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestExportDelay verifies that PCAP files wait until they are older than the minimum age,
+// and that PCAP files with an unknown modification time are not delayed.
+func TestExportDelay(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		modified time.Time
+		minAge   time.Duration
+		want     time.Duration
+	}{
+		{now, 0, 0},
+		{time.Time{}, time.Minute, 0},
+		{now.Add(-2 * time.Minute), time.Minute, 0},
+		{now.Add(-20 * time.Second), time.Minute, 40 * time.Second},
+		{now.Add(time.Second), time.Minute, time.Minute + time.Second},
+	} {
+		if got := exportDelay(tc.modified, now, tc.minAge); got != tc.want {
+			t.Errorf("exportDelay(%v, %v) = %v, want %v", now.Sub(tc.modified), tc.minAge, got, tc.want)
+		}
+	}
+}
+
+// recordingExporter records every exported PCAP file, and how many times it was exported.
+type recordingExporter struct {
+	exported   sync.Map
+	duplicates atomic.Int64
+}
+
+func (x *recordingExporter) Export(
+	_ context.Context,
+	srcPcapFile *string,
+	_, _ bool,
+) (*string, *int64, error) {
+	if _, loaded := x.exported.LoadOrStore(*srcPcapFile, struct{}{}); loaded {
+		x.duplicates.Add(1)
+	}
+	tgtPcapFile, pcapBytes := *srcPcapFile, int64(0)
+	return &tgtPcapFile, &pcapBytes, nil
+}
+
+// TestRotationStress rotates thousands of PCAP files across dozens of interfaces concurrently,
+// while PCAP files bookkeeping is read as flushes do: every rotated PCAP file must be exported exactly once,
+// and only the current PCAP file of every interface must be left.
+func TestRotationStress(t *testing.T) {
+	defer func(export bool, x gcs.Exporter, tracker *slo.Tracker) {
+		*gcs_export, exporter, durabilitySLO = export, x, tracker
+	}(*gcs_export, exporter, durabilitySLO)
+
+	const (
+		ifaces    = 24
+		rotations = 100
+	)
+
+	srcDir := t.TempDir()
+	recording := &recordingExporter{}
+	exporter = recording
+	*gcs_export = true
+	durabilitySLO = slo.NewTracker(0, 0, 1)
+	counters = syncmap.New[string, *atomic.Uint64]()
+	lastPcap = syncmap.New[string, string]()
+	gaps = rotation.NewGapDetector(time.Minute)
+
+	pcapDotExt := naming.NewMatcher(srcDir, []string{"pcap"})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pcapFileOf := func(iface, r int) string {
+		ts := start.Add(time.Duration(r) * time.Minute).Format(naming.TimestampLayout)
+		return filepath.Join(srcDir, fmt.Sprintf("part__1_eth%d__%s.pcap", iface, ts))
+	}
+	for iface := 0; iface < ifaces; iface++ {
+		for r := 0; r < rotations; r++ {
+			if err := os.WriteFile(pcapFileOf(iface, r), []byte("pcap"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				lastPcap.ForEach(func(key string, _ string) bool {
+					lastPcap.Get(key)
+					return true
+				})
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var ifacesWG sync.WaitGroup
+	for iface := 0; iface < ifaces; iface++ {
+		ifacesWG.Add(1)
+		go func(iface int) {
+			defer ifacesWG.Done()
+			// rotations of the same interface are detected in order by the FS events loop
+			for r := 0; r < rotations; r++ {
+				pcapFile := pcapFileOf(iface, r)
+				wg.Add(1)
+				exportPcapFile(context.Background(), &wg, pcapDotExt, &pcapFile, false, true, false /* flush */)
+			}
+		}(iface)
+	}
+	ifacesWG.Wait()
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	if n := recording.duplicates.Load(); n != 0 {
+		t.Errorf("%d PCAP files were exported more than once", n)
+	}
+	for iface := 0; iface < ifaces; iface++ {
+		for r := 0; r < rotations-1; r++ {
+			if _, ok := recording.exported.Load(pcapFileOf(iface, r)); !ok {
+				t.Fatalf("PCAP file was skipped: %s", pcapFileOf(iface, r))
+			}
+		}
+		current := pcapFileOf(iface, rotations-1)
+		if _, ok := recording.exported.Load(current); ok {
+			t.Errorf("current PCAP file was exported: %s", current)
+		}
+		if pcapFile, err := pcapDotExt.Parse(current); err != nil {
+			t.Fatal(err)
+		} else if last, _ := lastPcap.Get(pcapFile.Key()); last != current {
+			t.Errorf("last PCAP file = %s, want %s", last, current)
+		}
+	}
+}
@@ -2,7 +2,10 @@ module github.com/GoogleCloudPlatform/pcap-sidecar/tcpdumpw
 
 go 1.25.8
 
-require github.com/GoogleCloudPlatform/pcap-sidecar/pcap-cli v0.0.0
+require (
+	github.com/GoogleCloudPlatform/pcap-sidecar/config v0.0.0
+	github.com/GoogleCloudPlatform/pcap-sidecar/pcap-cli v0.0.0
+)
 
 require (
 	github.com/alphadose/haxmap v1.4.1
@@ -56,3 +59,5 @@ require (
 )
 
 replace github.com/GoogleCloudPlatform/pcap-sidecar/pcap-cli => ../pcap-cli
+
+replace github.com/GoogleCloudPlatform/pcap-sidecar/config => ../config
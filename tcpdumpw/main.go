@@ -36,6 +36,10 @@ import (
 	// _ "net/http/pprof"
 	_ "time/tzdata"
 
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/buildinfo"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/env"
+	pcapSnaplen "github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/snaplen"
+	"github.com/GoogleCloudPlatform/pcap-sidecar/config/pkg/telemetry"
 	"github.com/GoogleCloudPlatform/pcap-sidecar/pcap-cli/pkg/pcap"
 	"github.com/alphadose/haxmap"
 	"github.com/go-co-op/gocron/v2"
@@ -54,7 +58,7 @@ var (
 	timezone       = flag.String("timezone", "UTC", "TimeZone to be used to schedule packet captures")
 	duration       = flag.Int("timeout", 0, "perform packet capture during this mount of seconds")
 	interval       = flag.Int("interval", 60, "seconds after which tcpdump rotates PCAP files")
-	snaplen        = flag.Int("snaplen", 0, "bytes to be captured from each packet")
+	snaplen        = flag.Int("snaplen", 0, "bytes to be captured from each packet; 0 uses the default of 262144")
 	extension      = flag.String("extension", "pcap", "extension to be used for tcpdump PCAP files")
 	directory      = flag.String("directory", "", "directory where PCAP files will be stored")
 	tcp_dump       = flag.Bool("tcpdump", true, "enable JSON PCAP using tcpdump")
@@ -87,6 +91,10 @@ var (
 	no_procs          = flag.String("no_procs", "gcsfuse", "process for which TCP sockets should be excluded")
 	no_procs_interval = flag.Uint("no_procs_interval", 15, "how often to reresh sockets owned by pcap-sidecar's processes")
 	no_procs_debug    = flag.Bool("no_procs_debug", false, "enable/disable logging of socket discovery for pcap-sidecar's processes")
+
+	print_version      = flag.Bool("version", false, "print version information and exit")
+	print_version_json = flag.Bool("version_json", false, "print version information as JSON and exit")
+	dump_schemas       = flag.Bool("dump_event_schemas", false, "print the JSON schemas of all structured log events and exit")
 )
 
 type (
@@ -110,22 +118,24 @@ type (
 	jLogLevel string
 
 	jLogEntry struct {
-		Severity  jLogLevel        `json:"severity"`
-		Message   string           `json:"message"`
-		Sidecar   string           `json:"sidecar"`
-		Module    string           `json:"module"`
-		Job       tcpdumpJob       `json:"job,omitempty"`
-		Tags      []string         `json:"tags,omitempty"`
-		Timestamp map[string]int64 `json:"timestamp,omitempty"`
+		Severity      jLogLevel        `json:"severity"`
+		Message       string           `json:"message"`
+		Sidecar       string           `json:"sidecar"`
+		Module        string           `json:"module"`
+		SchemaVersion int              `json:"schema_version"`
+		Job           tcpdumpJob       `json:"job,omitempty"`
+		Tags          []string         `json:"tags,omitempty"`
+		Timestamp     map[string]int64 `json:"timestamp,omitempty"`
 	}
 )
 
+var environ = env.NewFromOS()
+
 var (
 	projectID         string = os.Getenv("PROJECT_ID")
 	ifacePrefixEnvVar string = os.Getenv("PCAP_IFACE_SAFE")
 	sidecarEnvVar     string = os.Getenv("APP_SIDECAR")
 	moduleEnvVar      string = os.Getenv("PROC_NAME")
-	gaeEnvVar         string = os.Getenv("GCP_GAE")
 	hcPortEnvVar      string = os.Getenv("PCAP_HC_PORT")
 )
 
@@ -148,10 +158,14 @@ var gaeJSONInterval = 0 // disable time based file rotation
 
 const (
 	INFO  jLogLevel = "INFO"
+	WARN  jLogLevel = "WARNING"
 	ERROR jLogLevel = "ERROR"
 	FATAL jLogLevel = "FATAL"
 )
 
+// jLogSchemaVersion is the version of the layout of `jLogEntry`: it must be bumped when fields are renamed or removed
+const jLogSchemaVersion = 1
+
 const (
 	fileNamePattern      = "%d_%s__%%Y%%m%%dT%%H%%M%%S"
 	runFileOutput        = `%s/part__` + fileNamePattern
@@ -190,12 +204,13 @@ func jlog(severity jLogLevel, job *tcpdumpJob, message string) {
 	j.Xid = xid.Load().(uuid.UUID).String()
 
 	entry := &jLogEntry{
-		Severity: severity,
-		Message:  message,
-		Sidecar:  sidecarEnvVar,
-		Module:   moduleEnvVar,
-		Job:      j,
-		Tags:     j.Tags,
+		Severity:      severity,
+		Message:       message,
+		Sidecar:       sidecarEnvVar,
+		Module:        moduleEnvVar,
+		SchemaVersion: jLogSchemaVersion,
+		Job:           j,
+		Tags:          j.Tags,
 		Timestamp: map[string]int64{
 			"seconds": now.Unix(),
 			"nanos":   int64(now.Nanosecond()),
@@ -270,6 +285,9 @@ func start(ctx context.Context, timeout *time.Duration, job *tcpdumpJob) error {
 		defer cancel()
 	}
 
+	// `TCPDUMPW_READY` file creation signals `pcapfsn` that PCAP files from now on belong to this capture session
+	signalReady(job, fmt.Sprintf("%s/TCPDUMPW_READY", *directory))
+
 	stopDeadline := make(chan *time.Duration, len(job.tasks))
 	for _, task := range job.tasks {
 		wg.Add(1)
@@ -349,7 +367,7 @@ func newPcapConfig(
 		Extension:     extension,
 		Filter:        filter,
 		Interval:      interval,
-		Ordered:       ordered,
+		Ordered:       ordered || conntrack, // connections tracking implies ordering
 		ConnTrack:     conntrack,
 		Filters:       filters,
 		CompatFilters: compatFilters,
@@ -375,8 +393,11 @@ func createTasks(
 		iface = ifacePrefixEnvVar
 	}
 
-	isGAE, err := strconv.ParseBool(gaeEnvVar)
-	isGAE = (err == nil && isGAE) || *gcpGAE
+	// explicit `-gae` flag, then `GCP_GAE`, then the default value of `-gae`
+	isGAE := environ.BoolWithFlag("GCP_GAE", flag.CommandLine, "gae", *gcpGAE)
+	for _, v := range environ.Malformed() {
+		jlog(WARN, &emptyTcpdumpJob, stringFormatter.Format("ignoring malformed env var {0}={1}: using {2}", v.Name, strconv.Quote(v.Malformed), v.Value))
+	}
 
 	var devices []*pcap.PcapDevice = nil
 	if strings.EqualFold(iface, anyIfaceName) {
@@ -518,6 +539,16 @@ func startTCPListener(ctx context.Context, port *uint, job *tcpdumpJob, stopChan
 	}
 }
 
+func signalReady(job *tcpdumpJob, readySignal string) {
+	readinessSignal, err := os.OpenFile(readySignal, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o666)
+	if err == nil {
+		jlog(INFO, job, fmt.Sprintf("'tcpdumpw' readiness signal created: %s", readinessSignal.Name()))
+		readinessSignal.Close()
+	} else {
+		jlog(ERROR, job, fmt.Sprintf("'tcpdumpw' readiness signal creation failed: %s | %s", readySignal, err.Error()))
+	}
+}
+
 func waitDone(job *tcpdumpJob, pcapMutex *flock.Flock, exitSignal *string) {
 	// wait for all PCAP tasks to be gracefully stopped
 	wg.Wait()
@@ -607,6 +638,20 @@ func parseEphemeralPorts(ephemerals *string) *pcap.PcapEphemeralPorts {
 func main() {
 	flag.Parse()
 
+	if *print_version || *print_version_json {
+		if err := buildinfo.Get().Print(os.Stdout, *print_version_json); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *dump_schemas {
+		if err := telemetry.DumpSchemas(os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
 		if r := recover(); r != nil {
@@ -618,6 +663,19 @@ func main() {
 	jid.Store(uuid.Nil)
 	xid.Store(uuid.Nil)
 
+	jlog(INFO, &emptyTcpdumpJob, stringFormatter.Format("starting: {0}", buildinfo.Get()))
+
+	// both `tcpdump` and `gopacket` engines must capture the same bytes per packet
+	if resolvedSnaplen, err := pcapSnaplen.Resolve(*snaplen); err != nil {
+		jlog(FATAL, &emptyTcpdumpJob, stringFormatter.Format("invalid snaplen: {0}", err.Error()))
+		os.Exit(1)
+	} else {
+		*snaplen = resolvedSnaplen
+	}
+	if pcapSnaplen.IsTruncating(*snaplen) {
+		jlog(WARN, &emptyTcpdumpJob, stringFormatter.Format("snaplen {0} is smaller than {1} bytes: full-sized packets will be truncated", *snaplen, pcapSnaplen.MinUntruncated))
+	}
+
 	if *compat || strings.EqualFold(*filter, "DISABLED") {
 		*filter = ""
 	} else {
@@ -64,7 +64,14 @@ func (p *TCPFlagsFilterProvider) Get(ctx context.Context) (*string, bool) {
 
 	ip6Filter := stringFormatter.Format("ip6[13+40]&0x{0}!=0", strconv.FormatUint(uint64(setFlags), 16))
 	// OR'ing out all the TCP flags: if any of the flags is set, packet will be captured
-	ip4Filter := stringFormatter.Format("tcp-{0}", strings.Join(flagsSet.ToSlice(), "|tcp-"))
+	ip4Flags := flagsSet.ToSlice()
+	for i, flag := range ip4Flags {
+		// libpcap names the `PSH` flag constant `tcp-push`
+		if flag == "psh" {
+			ip4Flags[i] = "push"
+		}
+	}
+	ip4Filter := stringFormatter.Format("tcp-{0}", strings.Join(ip4Flags, "|tcp-"))
 	// bitwise intersection should not yield 0, so intersection must not be empty
 	filter := stringFormatter.Format("(tcp[tcpflags]&({0})!=0) or ({1})", ip4Filter, ip6Filter)
 